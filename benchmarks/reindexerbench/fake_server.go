@@ -0,0 +1,401 @@
+package reindexerbench
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	_ "github.com/restream/reindexer/bindings/cproto"
+	"github.com/restream/reindexer/cjson"
+	_ "github.com/restream/reindexer/reindexertest"
+)
+
+// Constants of cproto protocol, which are duplicated from bindings/cproto
+const (
+	fakeCprotoMagic           = 0xEEDD1132
+	fakeCprotoVersion         = 0x103
+	fakeCprotoCompressionFlag = 1 << 10
+	fakeCprotoHdrLen          = 16
+)
+
+// Commands of cproto protocol, which are executed by fake server
+const (
+	fakeCmdPing              = 0
+	fakeCmdLogin             = 1
+	fakeCmdOpenNamespace     = 16
+	fakeCmdCloseNamespace    = 17
+	fakeCmdDropNamespace     = 18
+	fakeCmdTruncateNamespace = 19
+	fakeCmdAddIndex          = 21
+	fakeCmdDropIndex         = 24
+	fakeCmdUpdateIndex       = 25
+	fakeCmdAddTxItem         = 26
+	fakeCmdCommitTx          = 27
+	fakeCmdRollbackTx        = 28
+	fakeCmdStartTransaction  = 29
+	fakeCmdDeleteQueryTx     = 30
+	fakeCmdUpdateQueryTx     = 31
+	fakeCmdCommit            = 32
+	fakeCmdModifyItem        = 33
+	fakeCmdDeleteQuery       = 34
+	fakeCmdUpdateQuery       = 35
+	fakeCmdSelect            = 48
+	fakeCmdSelectSQL         = 49
+	fakeCmdCloseResults      = 51
+	fakeCmdGetMeta           = 64
+	fakeCmdPutMeta           = 65
+)
+
+// fakeArgs - args of request. Missing args and args of another type are zero values, the server and the client are in the same process,
+// so requests aren't validated
+type fakeArgs []interface{}
+
+func (args fakeArgs) int(i int) int64 {
+	if i < len(args) {
+		if v, ok := args[i].(int64); ok {
+			return v
+		}
+	}
+	return 0
+}
+
+func (args fakeArgs) bytes(i int) []byte {
+	if i < len(args) {
+		if v, ok := args[i].([]byte); ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func (args fakeArgs) str(i int) string {
+	return string(args.bytes(i))
+}
+
+// precepts decodes packed precepts of item
+func (args fakeArgs) precepts(i int) []string {
+	data := args.bytes(i)
+	if len(data) == 0 {
+		return nil
+	}
+	ser := cjson.NewSerializer(data)
+	precepts := make([]string, int(ser.GetVarUInt()))
+	for j := range precepts {
+		precepts[j] = ser.GetVString()
+	}
+	return precepts
+}
+
+// ptVersions decodes versions of payload types of select
+func (args fakeArgs) ptVersions(i int) []int32 {
+	data := args.bytes(i)
+	if len(data) == 0 {
+		return nil
+	}
+	ser := cjson.NewSerializer(data)
+	versions := make([]int32, int(ser.GetVarUInt()))
+	for j := range versions {
+		versions[j] = int32(ser.GetVarUInt())
+	}
+	return versions
+}
+
+// decodeFakeArgs decodes the first chunk of args of request body. The next chunks (e.g. exec timeout) are ignored
+func decodeFakeArgs(body []byte) (args fakeArgs, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("malformed args: %v", p)
+		}
+	}()
+	ser := cjson.NewSerializer(body)
+	args = make(fakeArgs, int(ser.GetVarUInt()))
+	for i := range args {
+		switch typ := int(ser.GetVarUInt()); typ {
+		case bindings.ValueString:
+			args[i] = ser.GetVBytes()
+		case bindings.ValueInt, bindings.ValueInt64:
+			args[i] = ser.GetVarInt()
+		case bindings.ValueBool:
+			args[i] = ser.GetVarUInt() != 0
+		default:
+			return nil, fmt.Errorf("unsupported type %d of arg %d", typ, i)
+		}
+	}
+	return args, nil
+}
+
+// fakeServer - cproto server, which executes commands of namespaces, items, queries, transactions and meta by in-memory binding
+// of reindexertest. Results of selects are returned at once, so they are not fetched and closed
+type fakeServer struct {
+	db    bindings.RawBinding
+	l     net.Listener
+	lock  sync.Mutex
+	conns map[net.Conn]struct{}
+	txs   map[int64]*bindings.TxCtx
+	wg    sync.WaitGroup
+}
+
+func startFakeServer() (*fakeServer, error) {
+	db := bindings.GetBinding("inmemory").Clone()
+	if err := db.Init([]url.URL{{Scheme: "inmemory"}}); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &fakeServer{db: db, l: l, conns: make(map[net.Conn]struct{}), txs: make(map[int64]*bindings.TxCtx)}
+	srv.wg.Add(1)
+	go srv.acceptLoop()
+	return srv, nil
+}
+
+func (srv *fakeServer) addr() string {
+	return srv.l.Addr().String()
+}
+
+// stop closes listener and connections and waits for the end of their requests
+func (srv *fakeServer) stop() {
+	srv.l.Close()
+	srv.lock.Lock()
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	srv.lock.Unlock()
+	srv.wg.Wait()
+	srv.db.Finalize()
+}
+
+func (srv *fakeServer) acceptLoop() {
+	defer srv.wg.Done()
+	for {
+		conn, err := srv.l.Accept()
+		if err != nil {
+			return
+		}
+		srv.lock.Lock()
+		srv.conns[conn] = struct{}{}
+		srv.lock.Unlock()
+		srv.wg.Add(1)
+		go srv.serve(conn)
+	}
+}
+
+func (srv *fakeServer) serve(conn net.Conn) {
+	defer srv.wg.Done()
+	defer func() {
+		srv.lock.Lock()
+		delete(srv.conns, conn)
+		srv.lock.Unlock()
+		conn.Close()
+	}()
+	rd := bufio.NewReader(conn)
+	hdr := make([]byte, fakeCprotoHdrLen)
+	reply := cjson.NewSerializer(nil)
+	for {
+		if _, err := io.ReadFull(rd, hdr); err != nil {
+			return
+		}
+		if binary.LittleEndian.Uint32(hdr) != fakeCprotoMagic {
+			return
+		}
+		version := binary.LittleEndian.Uint16(hdr[4:])
+		cmd := binary.LittleEndian.Uint16(hdr[6:])
+		body := make([]byte, binary.LittleEndian.Uint32(hdr[8:]))
+		seq := binary.LittleEndian.Uint32(hdr[12:])
+		if _, err := io.ReadFull(rd, body); err != nil {
+			return
+		}
+		var results []interface{}
+		var err error
+		if version&fakeCprotoCompressionFlag != 0 {
+			body, err = snappy.Decode(nil, body)
+		}
+		if err == nil {
+			var args fakeArgs
+			if args, err = decodeFakeArgs(body); err == nil {
+				results, err = srv.exec(int(cmd), args)
+			}
+		}
+
+		reply.Truncate(0)
+		reply.PutUInt32(fakeCprotoMagic)
+		reply.PutUInt16(fakeCprotoVersion)
+		reply.PutUInt16(cmd)
+		reply.PutUInt32(0)
+		reply.PutUInt32(seq)
+		putFakeReply(&reply, results, err)
+		binary.LittleEndian.PutUint32(reply.Bytes()[8:], uint32(len(reply.Bytes())-fakeCprotoHdrLen))
+		if _, err = conn.Write(reply.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+// putFakeReply writes error code, message and typed args of reply body
+func putFakeReply(ser *cjson.Serializer, results []interface{}, err error) {
+	if err != nil {
+		code := bindings.ErrLogic
+		if rerr, ok := err.(interface{ Code() int }); ok {
+			code = rerr.Code()
+		}
+		ser.PutVarUInt(uint64(code))
+		ser.PutVString(err.Error())
+		ser.PutVarUInt(0)
+		return
+	}
+	ser.PutVarUInt(0)
+	ser.PutVString("")
+	ser.PutVarUInt(uint64(len(results)))
+	for _, res := range results {
+		switch v := res.(type) {
+		case []byte:
+			ser.PutVarUInt(uint64(bindings.ValueString))
+			ser.PutVBytes(v)
+		case string:
+			ser.PutVarUInt(uint64(bindings.ValueString))
+			ser.PutVString(v)
+		case int:
+			ser.PutVarUInt(uint64(bindings.ValueInt))
+			ser.PutVarInt(int64(v))
+		case int64:
+			ser.PutVarUInt(uint64(bindings.ValueInt64))
+			ser.PutVarInt(v)
+		}
+	}
+}
+
+// rawResult returns data of buffer of in-memory binding
+func rawResult(buf bindings.RawBuffer, err error) ([]interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Free()
+	return []interface{}{append([]byte(nil), buf.GetBuf()...)}, nil
+}
+
+func (srv *fakeServer) tx(id int64) (*bindings.TxCtx, error) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	txCtx, ok := srv.txs[id]
+	if !ok {
+		return nil, bindings.NewError(fmt.Sprintf("fake server: Transaction %d is not found", id), bindings.ErrLogic)
+	}
+	return txCtx, nil
+}
+
+// exec executes command and returns args of reply
+func (srv *fakeServer) exec(cmd int, args fakeArgs) ([]interface{}, error) {
+	ctx := context.Background()
+	switch cmd {
+	case fakeCmdPing, fakeCmdCloseResults:
+		return nil, nil
+	case fakeCmdLogin:
+		return []interface{}{bindings.ReindexerVersion, time.Now().UnixNano(), int64(0)}, nil
+	case fakeCmdOpenNamespace:
+		var def bindings.NamespaceDef
+		if err := json.Unmarshal(args.bytes(0), &def); err != nil {
+			return nil, err
+		}
+		return nil, srv.db.OpenNamespace(ctx, def.Namespace, def.StorageOpts.EnableStorage, def.StorageOpts.DropOnFormatError)
+	case fakeCmdCloseNamespace:
+		return nil, srv.db.CloseNamespace(ctx, args.str(0))
+	case fakeCmdDropNamespace:
+		return nil, srv.db.DropNamespace(ctx, args.str(0))
+	case fakeCmdTruncateNamespace:
+		return nil, srv.db.TruncateNamespace(ctx, args.str(0))
+	case fakeCmdAddIndex, fakeCmdUpdateIndex:
+		var def bindings.IndexDef
+		if err := json.Unmarshal(args.bytes(1), &def); err != nil {
+			return nil, err
+		}
+		if cmd == fakeCmdAddIndex {
+			return nil, srv.db.AddIndex(ctx, args.str(0), def)
+		}
+		return nil, srv.db.UpdateIndex(ctx, args.str(0), def)
+	case fakeCmdDropIndex:
+		return nil, srv.db.DropIndex(ctx, args.str(0), args.str(1))
+	case fakeCmdCommit:
+		return nil, srv.db.Commit(ctx, args.str(0))
+	case fakeCmdModifyItem:
+		return rawResult(srv.db.ModifyItem(ctx, 0, args.str(0), int(args.int(1)), args.bytes(2), int(args.int(3)), args.precepts(4), int(args.int(5))))
+	case fakeCmdDeleteQuery:
+		return rawResult(srv.db.DeleteQuery(ctx, 0, args.bytes(0)))
+	case fakeCmdUpdateQuery:
+		return rawResult(srv.db.UpdateQuery(ctx, 0, args.bytes(0)))
+	case fakeCmdSelect, fakeCmdSelectSQL:
+		asJSON := args.int(1)&bindings.ResultsFormatMask == bindings.ResultsJson
+		var results []interface{}
+		var err error
+		if cmd == fakeCmdSelect {
+			results, err = rawResult(srv.db.SelectQuery(ctx, args.bytes(0), asJSON, args.ptVersions(3), int(args.int(2))))
+		} else {
+			results, err = rawResult(srv.db.Select(ctx, args.str(0), asJSON, args.ptVersions(3), int(args.int(2))))
+		}
+		// Results are not kept on server
+		return append(results, -1), err
+	case fakeCmdStartTransaction:
+		txCtx, err := srv.db.BeginTx(ctx, args.str(0))
+		if err != nil {
+			return nil, err
+		}
+		srv.lock.Lock()
+		srv.txs[int64(txCtx.Id)] = &txCtx
+		srv.lock.Unlock()
+		return []interface{}{int64(txCtx.Id)}, nil
+	case fakeCmdAddTxItem:
+		txCtx, err := srv.tx(args.int(5))
+		if err != nil {
+			return nil, err
+		}
+		return nil, srv.db.ModifyItemTx(txCtx, int(args.int(0)), args.bytes(1), int(args.int(2)), args.precepts(3), int(args.int(4)))
+	case fakeCmdDeleteQueryTx, fakeCmdUpdateQueryTx:
+		txCtx, err := srv.tx(args.int(1))
+		if err != nil {
+			return nil, err
+		}
+		if cmd == fakeCmdDeleteQueryTx {
+			return nil, srv.db.DeleteQueryTx(txCtx, args.bytes(0))
+		}
+		return nil, srv.db.UpdateQueryTx(txCtx, args.bytes(0))
+	case fakeCmdCommitTx, fakeCmdRollbackTx:
+		txCtx, err := srv.tx(args.int(0))
+		if err != nil {
+			return nil, err
+		}
+		srv.lock.Lock()
+		delete(srv.txs, args.int(0))
+		srv.lock.Unlock()
+		if cmd == fakeCmdCommitTx {
+			return rawResult(srv.db.CommitTx(txCtx))
+		}
+		return nil, srv.db.RollbackTx(txCtx)
+	case fakeCmdPutMeta:
+		return nil, srv.db.PutMeta(ctx, args.str(0), args.str(1), args.str(2))
+	case fakeCmdGetMeta:
+		return rawResult(srv.db.GetMeta(ctx, args.str(0), args.str(1)))
+	}
+	return nil, bindings.NewError(fmt.Sprintf("fake server: Command %d is not supported", cmd), bindings.ErrParams)
+}
+
+func openCprotoFake(cfg Config, options []interface{}) (*reindexer.Reindexer, func(), error) {
+	srv, err := startFakeServer()
+	if err != nil {
+		return nil, nil, err
+	}
+	db := reindexer.NewReindex("cproto://"+srv.addr()+"/bench", options...)
+	return db, func() {
+		db.Close()
+		srv.stop()
+	}, nil
+}
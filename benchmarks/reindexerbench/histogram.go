@@ -0,0 +1,94 @@
+package reindexerbench
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// histSubBits - bits of sub-buckets of each power of 2 of histogram, so relative error of quantiles is below 1/64
+	histSubBits    = 6
+	histSubBuckets = 1 << histSubBits
+	histBuckets    = (64 - histSubBits) * histSubBuckets
+)
+
+// Histogram - log-linear histogram of durations with fixed memory footprint. Durations below 64ns are counted exactly,
+// the larger ones by 64 buckets of each power of 2. Zero value is empty histogram
+type Histogram struct {
+	counts [histBuckets]uint64
+	count  uint64
+	max    time.Duration
+}
+
+func histIndex(v uint64) int {
+	if v < histSubBuckets {
+		return int(v)
+	}
+	shift := uint(bits.Len64(v) - histSubBits - 1)
+	return int(shift+1)*histSubBuckets + int(v>>shift) - histSubBuckets
+}
+
+// histUpper returns the max value of bucket
+func histUpper(index int) uint64 {
+	if index < histSubBuckets {
+		return uint64(index)
+	}
+	shift := uint(index/histSubBuckets - 1)
+	sub := uint64(index%histSubBuckets + histSubBuckets)
+	return (sub+1)<<shift - 1
+}
+
+// Record adds duration to histogram. Negative durations are counted as zero
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[histIndex(uint64(d))]++
+	h.count++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds durations of other histogram
+func (h *Histogram) Merge(other *Histogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count returns count of recorded durations
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Max returns the max recorded duration
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns upper bound of q-quantile (0 <= q <= 1) of recorded durations, which is not greater than Max
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		if seen += c; seen >= rank {
+			if upper := time.Duration(histUpper(i)); upper < h.max {
+				return upper
+			}
+			break
+		}
+	}
+	return h.max
+}
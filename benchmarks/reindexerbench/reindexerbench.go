@@ -0,0 +1,217 @@
+// Package reindexerbench provides reproducible benchmark scenarios of reindexer client (point lookups, scans, bulk transactions
+// and join-heavy selects), which run against one of targets: builtin, builtinserver, cproto to fake server over in-memory DB
+// or cproto to real server. Each scenario reports allocations and latency quantiles of its operations, which are collected
+// into Report and written in benchstat-compatible and JSON formats.
+//
+//	env, err := reindexerbench.Open(reindexerbench.Config{Target: reindexerbench.TargetCproto, DSN: "cproto://127.0.0.1:6534/bench"})
+//	...
+//	defer env.Close()
+//	for _, s := range reindexerbench.Scenarios() {
+//		b.Run(s.Name+"/target="+env.Target, func(b *testing.B) { env.Bench(b, s) })
+//	}
+//	env.Report().WriteJSON(w)
+package reindexerbench
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restream/reindexer"
+)
+
+// Targets of benchmarks
+const (
+	// TargetBuiltin - builtin binding with storage in temporary dir (requires cgo)
+	TargetBuiltin = "builtin"
+	// TargetBuiltinServer - cproto client of embedded server of builtinserver binding (requires cgo)
+	TargetBuiltinServer = "builtinserver"
+	// TargetCprotoFake - cproto client of fake server, which executes commands by in-memory DB of reindexertest
+	TargetCprotoFake = "cproto-fake"
+	// TargetCproto - cproto client of real server by Config.DSN
+	TargetCproto = "cproto"
+)
+
+// seed of random generators of datasets and operations, so runs of scenarios are reproducible
+const seed = 1
+
+// Config - config of benchmark environment
+type Config struct {
+	// Target - one of Target... consts
+	Target string
+	// DSN - DSN of server of TargetCproto
+	DSN string
+	// Scale - multiplier of sizes of datasets of scenarios (default 1)
+	Scale float64
+}
+
+// opener opens client of target and returns func, which closes it with all the resources of target
+type opener func(cfg Config, options []interface{}) (db *reindexer.Reindexer, close func(), err error)
+
+var targets = map[string]opener{
+	TargetCprotoFake: openCprotoFake,
+	TargetCproto:     openCproto,
+}
+
+// Targets returns names of targets, which are supported by this build
+func Targets() []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func openCproto(cfg Config, options []interface{}) (*reindexer.Reindexer, func(), error) {
+	if cfg.DSN == "" {
+		return nil, nil, fmt.Errorf("reindexerbench: DSN of target '%s' is required", TargetCproto)
+	}
+	db := reindexer.NewReindex(cfg.DSN, append([]interface{}{reindexer.WithCreateDBIfMissing()}, options...)...)
+	return db, func() { db.Close() }, nil
+}
+
+// Env - client of target with datasets of scenarios, which are prepared once
+type Env struct {
+	// DB - client of target
+	DB     *reindexer.Reindexer
+	Target string
+	scale  float64
+	close  func()
+	// prepared - names of scenarios, which datasets are filled
+	prepared map[string]bool
+	report   Report
+}
+
+// Open opens client of target of cfg. Options are passed to reindexer.NewReindex
+func Open(cfg Config, options ...interface{}) (*Env, error) {
+	open, ok := targets[cfg.Target]
+	if !ok {
+		return nil, fmt.Errorf("reindexerbench: Unknown target '%s', supported targets: %s", cfg.Target, strings.Join(Targets(), ", "))
+	}
+	if cfg.Scale <= 0 {
+		cfg.Scale = 1
+	}
+	db, close, err := open(cfg, options)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Status().Err; err != nil {
+		close()
+		return nil, fmt.Errorf("reindexerbench: Can't open target '%s': %v", cfg.Target, err)
+	}
+	return &Env{
+		DB:       db,
+		Target:   cfg.Target,
+		scale:    cfg.Scale,
+		close:    close,
+		prepared: make(map[string]bool),
+		report:   Report{GoOS: runtime.GOOS, GoArch: runtime.GOARCH, Procs: runtime.GOMAXPROCS(0)},
+	}, nil
+}
+
+// Close drops namespaces of scenarios and closes target
+func (e *Env) Close() {
+	for _, ns := range benchNamespaces {
+		e.DB.DropNamespace(ns)
+	}
+	e.close()
+}
+
+// Report returns results of the last runs of scenarios
+func (e *Env) Report() *Report {
+	return &e.report
+}
+
+// items returns size of dataset of s by scale of env
+func (e *Env) items(s *Scenario) int {
+	n := int(float64(s.Items) * e.scale)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// Prepare fills dataset of scenario, if it's not filled yet
+func (e *Env) Prepare(s *Scenario) error {
+	if e.prepared[s.Name] {
+		return nil
+	}
+	if err := s.Setup(e.DB, e.items(s), rand.New(rand.NewSource(seed))); err != nil {
+		return fmt.Errorf("reindexerbench: Setup of scenario '%s' failed: %v", s.Name, err)
+	}
+	e.prepared[s.Name] = true
+	return nil
+}
+
+// measure runs n operations of s and returns their result
+func (e *Env) measure(s *Scenario, n int) (Result, error) {
+	items := e.items(s)
+	rnd := rand.New(rand.NewSource(seed))
+	hist := &Histogram{}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		opStart := time.Now()
+		if err := s.Op(e.DB, items, rnd); err != nil {
+			return Result{}, fmt.Errorf("reindexerbench: Operation of scenario '%s' failed: %v", s.Name, err)
+		}
+		hist.Record(time.Since(opStart))
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	res := Result{Name: s.Name, Target: e.Target, Items: items, N: n}
+	if n > 0 {
+		res.NsPerOp = float64(elapsed.Nanoseconds()) / float64(n)
+		res.AllocsPerOp = float64(after.Mallocs-before.Mallocs) / float64(n)
+		res.BytesPerOp = float64(after.TotalAlloc-before.TotalAlloc) / float64(n)
+		res.P50Ns = hist.Quantile(0.5).Nanoseconds()
+		res.P99Ns = hist.Quantile(0.99).Nanoseconds()
+		res.MaxNs = hist.Max().Nanoseconds()
+	}
+	return res, nil
+}
+
+// Run prepares dataset of s, runs n operations of it and adds result to report
+func (e *Env) Run(s *Scenario, n int) (Result, error) {
+	if !s.Supports(e.Target) {
+		return Result{}, fmt.Errorf("reindexerbench: Scenario '%s' is not supported by target '%s'", s.Name, e.Target)
+	}
+	if err := e.Prepare(s); err != nil {
+		return Result{}, err
+	}
+	res, err := e.measure(s, n)
+	if err != nil {
+		return res, err
+	}
+	e.report.add(res)
+	return res, nil
+}
+
+// Bench runs s as benchmark b. Scenarios, which are not supported by target, are skipped. Latency quantiles are reported
+// as custom metrics of benchmark, result of the last run is added to report
+func (e *Env) Bench(b *testing.B, s *Scenario) {
+	b.Helper()
+	if !s.Supports(e.Target) {
+		b.Skipf("scenario '%s' is not supported by target '%s'", s.Name, e.Target)
+	}
+	if err := e.Prepare(s); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	res, err := e.measure(s, b.N)
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(res.P50Ns), "p50-ns")
+	b.ReportMetric(float64(res.P99Ns), "p99-ns")
+	e.report.add(res)
+}
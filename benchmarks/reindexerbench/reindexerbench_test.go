@@ -0,0 +1,147 @@
+package reindexerbench
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	targetFlag    = flag.String("target", TargetCprotoFake, "target of scenarios: "+strings.Join([]string{TargetBuiltin, TargetBuiltinServer, TargetCprotoFake, TargetCproto}, ", "))
+	dsnFlag       = flag.String("dsn", "", "DSN of server of target cproto")
+	scaleFlag     = flag.Float64("scale", 1, "multiplier of sizes of datasets of benchmarks")
+	reportFlag    = flag.String("report", "", "path of JSON report of benchmarks")
+	benchstatFlag = flag.String("benchstat", "", "path of benchstat report of benchmarks")
+)
+
+// smokeScale - scale of datasets of smoke tests of scenarios
+const smokeScale = 0.001
+
+// benchEnv is opened by the first benchmark and closed by TestMain after reports are written
+var benchEnv *Env
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	if benchEnv != nil {
+		if err := writeReports(benchEnv.Report()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+		benchEnv.Close()
+	}
+	os.Exit(code)
+}
+
+func writeReports(r *Report) error {
+	write := func(path string, writeReport func(f *os.File) error) error {
+		if path == "" {
+			return nil
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err = writeReport(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	if err := write(*reportFlag, func(f *os.File) error { return r.WriteJSON(f) }); err != nil {
+		return err
+	}
+	return write(*benchstatFlag, func(f *os.File) error { return r.WriteBenchstat(f) })
+}
+
+func BenchmarkScenarios(b *testing.B) {
+	if benchEnv == nil {
+		env, err := Open(Config{Target: *targetFlag, DSN: *dsnFlag, Scale: *scaleFlag})
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchEnv = env
+	}
+	for _, s := range Scenarios() {
+		s := s
+		b.Run(s.Name+"/target="+benchEnv.Target, func(b *testing.B) { benchEnv.Bench(b, s) })
+	}
+}
+
+func TestScenarios(t *testing.T) {
+	env, err := Open(Config{Target: *targetFlag, DSN: *dsnFlag, Scale: smokeScale})
+	require.NoError(t, err)
+	defer env.Close()
+
+	for _, s := range Scenarios() {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			if !s.Supports(env.Target) {
+				_, err := env.Run(s, 1)
+				assert.Error(t, err)
+				t.Skipf("scenario '%s' is not supported by target '%s'", s.Name, env.Target)
+			}
+			res, err := env.Run(s, 20)
+			require.NoError(t, err)
+			assert.Equal(t, s.Name, res.Name)
+			assert.Equal(t, env.Target, res.Target)
+			assert.Equal(t, int(float64(s.Items)*smokeScale), res.Items)
+			assert.Equal(t, 20, res.N)
+			assert.True(t, res.NsPerOp > 0)
+			assert.True(t, res.AllocsPerOp > 0)
+			assert.True(t, res.P50Ns > 0 && res.P50Ns <= res.P99Ns && res.P99Ns <= res.MaxNs, "%+v", res)
+		})
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, env.Report().WriteJSON(&out))
+	var report Report
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, *env.Report(), report)
+}
+
+func TestHistogram(t *testing.T) {
+	h := &Histogram{}
+	assert.Equal(t, time.Duration(0), h.Quantile(0.99))
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	assert.Equal(t, uint64(1000), h.Count())
+	assert.Equal(t, time.Millisecond, h.Max())
+	// Relative error of quantiles is below 1/64
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 500 * time.Microsecond}, {0.99, 990 * time.Microsecond}, {0.001, time.Microsecond}} {
+		got := h.Quantile(c.q)
+		assert.True(t, got >= c.want && got <= c.want+c.want/64, "quantile %v: %v", c.q, got)
+	}
+	assert.Equal(t, time.Millisecond, h.Quantile(1))
+
+	small := &Histogram{}
+	for i := 0; i < 10; i++ {
+		small.Record(time.Duration(i))
+	}
+	assert.Equal(t, time.Duration(4), small.Quantile(0.5))
+	h.Merge(small)
+	assert.Equal(t, uint64(1010), h.Count())
+	assert.Equal(t, time.Duration(0), h.Quantile(0))
+}
+
+func TestReportBenchstat(t *testing.T) {
+	r := &Report{GoOS: "linux", GoArch: "amd64", Procs: 8}
+	r.add(Result{Name: "PointLookup", Target: TargetCproto, N: 100, NsPerOp: 1})
+	r.add(Result{Name: "PointLookup", Target: TargetCproto, N: 1000, NsPerOp: 25000.5, BytesPerOp: 1024, AllocsPerOp: 12, P50Ns: 20000, P99Ns: 90000})
+	var out bytes.Buffer
+	require.NoError(t, r.WriteBenchstat(&out))
+	assert.Equal(t, "goos: linux\ngoarch: amd64\npkg: github.com/restream/reindexer/benchmarks/reindexerbench\n"+
+		"BenchmarkScenarios/PointLookup/target=cproto-8\t1000\t25000.5 ns/op\t1024 B/op\t12 allocs/op\t20000 p50-ns\t90000 p99-ns\n", out.String())
+}
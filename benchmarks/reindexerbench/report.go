@@ -0,0 +1,66 @@
+package reindexerbench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Result - result of run of scenario
+type Result struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	// Items - size of dataset of scenario
+	Items int `json:"items"`
+	// N - count of operations
+	N           int     `json:"n"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	AllocsPerOp float64 `json:"allocs_per_op"`
+	BytesPerOp  float64 `json:"bytes_per_op"`
+	// Latency quantiles and the max latency of operations by Histogram
+	P50Ns int64 `json:"p50_ns"`
+	P99Ns int64 `json:"p99_ns"`
+	MaxNs int64 `json:"max_ns"`
+}
+
+// Report - results of scenarios of environment
+type Report struct {
+	GoOS    string   `json:"goos"`
+	GoArch  string   `json:"goarch"`
+	Procs   int      `json:"procs"`
+	Results []Result `json:"results"`
+}
+
+// add replaces result of the previous run of the same scenario and target with res
+func (r *Report) add(res Result) {
+	for i := range r.Results {
+		if r.Results[i].Name == res.Name && r.Results[i].Target == res.Target {
+			r.Results[i] = res
+			return
+		}
+	}
+	r.Results = append(r.Results, res)
+}
+
+// WriteJSON writes report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteBenchstat writes report in format of 'go test -bench' output, which is accepted by benchstat.
+// Results are named like sub-benchmarks of BenchmarkScenarios: BenchmarkScenarios/<Name>/target=<Target>, latency quantiles are written as p50-ns and p99-ns metrics
+func (r *Report) WriteBenchstat(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "goos: %s\ngoarch: %s\npkg: github.com/restream/reindexer/benchmarks/reindexerbench\n", r.GoOS, r.GoArch); err != nil {
+		return err
+	}
+	for _, res := range r.Results {
+		_, err := fmt.Fprintf(w, "BenchmarkScenarios/%s/target=%s-%d\t%d\t%.1f ns/op\t%.0f B/op\t%.0f allocs/op\t%d p50-ns\t%d p99-ns\n",
+			res.Name, res.Target, r.Procs, res.N, res.NsPerOp, res.BytesPerOp, res.AllocsPerOp, res.P50Ns, res.P99Ns)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package reindexerbench
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/restream/reindexer"
+)
+
+// Namespaces of scenarios
+const (
+	lookupNs    = "bench_lookup"
+	scanNs      = "bench_scan"
+	txNs        = "bench_tx"
+	ordersNs    = "bench_orders"
+	customersNs = "bench_customers"
+)
+
+var benchNamespaces = []string{lookupNs, scanNs, txNs, ordersNs, customersNs}
+
+const (
+	// maxPrice - prices of items are uniformly distributed in [0, maxPrice)
+	maxPrice = 1000000
+	// scanWindow - width of range of prices of scan, which selects ~0.1% of items
+	scanWindow = maxPrice / 1000
+	// txItems - count of upserts of transaction of BulkTx
+	txItems = 1000
+	// fillBatch - count of items of transactions, which fill datasets
+	fillBatch = 10000
+	// ordersPerCustomer - ratio of sizes of customers and orders namespaces
+	ordersPerCustomer = 10
+	// joinLimit - limit of orders of join select
+	joinLimit = 100
+)
+
+// Item - item of namespaces of lookups, scans and transactions
+type Item struct {
+	ID    int64    `reindex:"id,,pk" json:"id"`
+	Name  string   `reindex:"name" json:"name"`
+	Year  int      `reindex:"year,tree" json:"year"`
+	Price int64    `json:"price"`
+	Tags  []string `reindex:"tags" json:"tags"`
+}
+
+// Customer - joined item of orders
+type Customer struct {
+	ID      int64  `reindex:"id,,pk" json:"id"`
+	Name    string `reindex:"name" json:"name"`
+	Country string `reindex:"country" json:"country"`
+}
+
+// Order - item of join-heavy selects, which is joined with its customer
+type Order struct {
+	ID         int64       `reindex:"id,,pk" json:"id"`
+	CustomerID int64       `reindex:"customer_id" json:"customer_id"`
+	Amount     int64       `reindex:"amount,tree" json:"amount"`
+	Customer   []*Customer `reindex:"customer,,joined" json:"-"`
+}
+
+// Scenario - benchmark of operation of client on dataset, which is filled once by Setup.
+// Setup and Op receive size of dataset and random generator with fixed seed, so runs are reproducible
+type Scenario struct {
+	Name string
+	// Items - size of dataset with scale 1
+	Items int
+	// Unsupported - targets, which can't execute operation of scenario
+	Unsupported []string
+	Setup       func(db *reindexer.Reindexer, items int, rnd *rand.Rand) error
+	Op          func(db *reindexer.Reindexer, items int, rnd *rand.Rand) error
+}
+
+// Supports returns true, if scenario can be run against target
+func (s *Scenario) Supports(target string) bool {
+	for _, t := range s.Unsupported {
+		if t == target {
+			return false
+		}
+	}
+	return true
+}
+
+// Scenarios returns built-in scenarios:
+//
+//	PointLookup - select of item by primary key of 100K items
+//	Scan - select of ~0.1% of 1M items by range of non-indexed field, which scans namespace
+//	BulkTx - transaction of 1000 upserts into namespace of 100K items
+//	JoinSelect - select of 100 orders by range of indexed field with inner join of their customers
+func Scenarios() []*Scenario {
+	return []*Scenario{
+		{Name: "PointLookup", Items: 100000, Setup: setupItems(lookupNs), Op: pointLookup},
+		{Name: "Scan", Items: 1000000, Setup: setupItems(scanNs), Op: scan},
+		{Name: "BulkTx", Items: 100000, Setup: setupItems(txNs), Op: bulkTx},
+		// In-memory DB of fake server doesn't support joins
+		{Name: "JoinSelect", Items: 100000, Unsupported: []string{TargetCprotoFake}, Setup: setupOrders, Op: joinSelect},
+	}
+}
+
+func newItem(id int64, rnd *rand.Rand) *Item {
+	return &Item{
+		ID:    id,
+		Name:  "item_" + strconv.Itoa(rnd.Intn(100000)),
+		Year:  1990 + rnd.Intn(30),
+		Price: rnd.Int63n(maxPrice),
+		Tags:  []string{"tag_" + strconv.Itoa(rnd.Intn(100)), "tag_" + strconv.Itoa(rnd.Intn(100))},
+	}
+}
+
+// fill recreates namespace ns of struct s and upserts count items, which are made by newDoc, by transactions
+func fill(db *reindexer.Reindexer, ns string, s interface{}, count int, newDoc func(id int64) interface{}) error {
+	db.DropNamespace(ns)
+	if err := db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), s); err != nil {
+		return err
+	}
+	for start := 0; start < count; start += fillBatch {
+		tx, err := db.BeginTx(ns)
+		if err != nil {
+			return err
+		}
+		for id := start; id < start+fillBatch && id < count; id++ {
+			if err = tx.UpsertAsync(newDoc(int64(id)), func(error) {}); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if err = tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setupItems(ns string) func(db *reindexer.Reindexer, items int, rnd *rand.Rand) error {
+	return func(db *reindexer.Reindexer, items int, rnd *rand.Rand) error {
+		return fill(db, ns, Item{}, items, func(id int64) interface{} { return newItem(id, rnd) })
+	}
+}
+
+func setupOrders(db *reindexer.Reindexer, items int, rnd *rand.Rand) error {
+	customers := items/ordersPerCustomer + 1
+	err := fill(db, customersNs, Customer{}, customers, func(id int64) interface{} {
+		return &Customer{ID: id, Name: "customer_" + strconv.Itoa(int(id)), Country: "country_" + strconv.Itoa(rnd.Intn(50))}
+	})
+	if err != nil {
+		return err
+	}
+	return fill(db, ordersNs, Order{}, items, func(id int64) interface{} {
+		return &Order{ID: id, CustomerID: rnd.Int63n(int64(customers)), Amount: rnd.Int63n(maxPrice)}
+	})
+}
+
+func pointLookup(db *reindexer.Reindexer, items int, rnd *rand.Rand) error {
+	id := rnd.Int63n(int64(items))
+	it := db.Query(lookupNs).WhereInt64("id", reindexer.EQ, id).Limit(1).Exec()
+	defer it.Close()
+	if !it.Next() {
+		if err := it.Error(); err != nil {
+			return err
+		}
+		return fmt.Errorf("item %d is not found", id)
+	}
+	return it.Error()
+}
+
+func scan(db *reindexer.Reindexer, items int, rnd *rand.Rand) error {
+	from := rnd.Int63n(maxPrice - scanWindow)
+	it := db.Query(scanNs).Where("price", reindexer.RANGE, []int64{from, from + scanWindow - 1}).Exec()
+	defer it.Close()
+	for it.Next() {
+	}
+	return it.Error()
+}
+
+func bulkTx(db *reindexer.Reindexer, items int, rnd *rand.Rand) error {
+	tx, err := db.BeginTx(txNs)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < txItems; i++ {
+		if err = tx.Upsert(newItem(rnd.Int63n(int64(items)), rnd)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func joinSelect(db *reindexer.Reindexer, items int, rnd *rand.Rand) error {
+	from := rnd.Int63n(maxPrice / 2)
+	it := db.Query(ordersNs).
+		WhereInt64("amount", reindexer.GE, from).
+		Limit(joinLimit).
+		InnerJoin(db.Query(customersNs), "customer").
+		On("customer_id", reindexer.EQ, "id").
+		Exec()
+	defer it.Close()
+	for it.Next() {
+		if len(it.Object().(*Order).Customer) != 1 {
+			return fmt.Errorf("order %d is not joined with its customer", it.Object().(*Order).ID)
+		}
+	}
+	return it.Error()
+}
@@ -0,0 +1,79 @@
+// +build cgo
+
+package reindexerbench
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/restream/reindexer"
+	_ "github.com/restream/reindexer/bindings/builtin"
+	_ "github.com/restream/reindexer/bindings/builtinserver"
+	"github.com/restream/reindexer/bindings/builtinserver/config"
+)
+
+func init() {
+	targets[TargetBuiltin] = openBuiltin
+	targets[TargetBuiltinServer] = openBuiltinServer
+}
+
+func openBuiltin(cfg Config, options []interface{}) (*reindexer.Reindexer, func(), error) {
+	dir, err := ioutil.TempDir("", "reindexerbench")
+	if err != nil {
+		return nil, nil, err
+	}
+	db := reindexer.NewReindex("builtin://"+dir, options...)
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// localAddr returns local address with free TCP port
+func localAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// openBuiltinServer starts embedded server with storage in temporary dir and returns its cproto client
+func openBuiltinServer(cfg Config, options []interface{}) (*reindexer.Reindexer, func(), error) {
+	dir, err := ioutil.TempDir("", "reindexerbench")
+	if err != nil {
+		return nil, nil, err
+	}
+	serverCfg := config.DefaultServerConfig()
+	if serverCfg.Net.RPCAddr, err = localAddr(); err == nil {
+		serverCfg.Net.HTTPAddr, err = localAddr()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	serverCfg.Storage.Path = dir
+	serverCfg.Logger.LogLevel = "error"
+	serverCfg.Logger.ServerLog = ""
+	serverCfg.Logger.CoreLog = ""
+	serverCfg.Logger.HTTPLog = ""
+	serverCfg.Logger.RPCLog = ""
+
+	server := reindexer.NewReindex("builtinserver://bench", reindexer.WithServerConfig(time.Minute, serverCfg))
+	stop := func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+	if err = server.Status().Err; err != nil {
+		stop()
+		return nil, nil, err
+	}
+	db := reindexer.NewReindex("cproto://"+serverCfg.Net.RPCAddr+"/bench", append([]interface{}{reindexer.WithCreateDBIfMissing()}, options...)...)
+	return db, func() {
+		db.Close()
+		stop()
+	}, nil
+}
@@ -2,10 +2,13 @@ package reindexer
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/restream/reindexer/bindings"
@@ -20,27 +23,66 @@ const (
 	modeDelete = bindings.ModeDelete
 )
 
-func (db *reindexerImpl) modifyItem(ctx context.Context, namespace string, ns *reindexerNamespace, item interface{}, json []byte, mode int, precepts ...string) (count int, err error) {
+const defaultMaxAsyncWrites = 1000
 
+func (db *reindexerImpl) modifyItem(ctx context.Context, namespace string, ns *reindexerNamespace, item interface{}, json []byte, mode int, precepts ...string) (count int, err error) {
+	if err = checkSystemWrite(namespace); err != nil {
+		return 0, err
+	}
 	if ns == nil {
 		ns, err = db.getNS(namespace)
 		if err != nil {
 			return 0, err
 		}
 	}
+	ser := cjson.NewPoolSerializer()
+	defer ser.Close()
+	format, stateToken, err := packItem(ns, item, json, ser)
+	if err != nil {
+		return 0, err
+	}
+	ctx, orderedWrite, err := ns.beginOrderedWrite(ctx, item)
+	if err != nil {
+		return 0, err
+	}
+	defer orderedWrite.end()
+	dedupWrite, skip := ns.beginDedupWrite(item, ser.Bytes(), mode, precepts)
+	if skip {
+		return 1, nil
+	}
+	defer func() { dedupWrite.end(err) }()
+	ns.replica.invalidate()
+	defer ns.replica.invalidate()
 
-	for tryCount := 0; tryCount < 2; tryCount++ {
-		ser := cjson.NewPoolSerializer()
-		defer ser.Close()
-
-		format := 0
-		stateToken := 0
+	if err = db.limiter().wait(ctx, ns.name, CommandClassWrite); err != nil {
+		return 0, err
+	}
+	journalEntry := db.writeJournal.begin(ns.name, writeOpName(mode), precepts)
+	if journalEntry != nil {
+		start := time.Now()
+		defer func() {
+			// Item may be updated by precepts, so PK is taken after the reply
+			journalEntry.PK, journalEntry.Count, journalEntry.Err = ns.itemPK(item), count, err
+			db.writeJournal.record(journalEntry, start)
+		}()
+	}
 
-		if format, stateToken, err = packItem(ns, item, json, ser); err != nil {
-			return
+	for tryCount := 0; tryCount < 2; tryCount++ {
+		if tryCount > 0 {
+			// Item is packed again with the refreshed tags state
+			ser.Truncate(0)
+			if format, stateToken, err = packItem(ns, item, json, ser); err != nil {
+				return
+			}
+			dedupWrite.repacked(ns, item, ser.Bytes(), precepts)
+		}
+		if journalEntry != nil {
+			journalEntry.PayloadSize = len(ser.Bytes())
 		}
 
-		out, err := db.binding.ModifyItem(ctx, ns.nsHash, ns.name, format, ser.Bytes(), mode, precepts, stateToken)
+		op := db.startOp(ctx, bindings.ShutdownOpModify, ns.name)
+		out, err := db.getBinding().ModifyItem(ctx, ns.nsHash, ns.name, format, ser.Bytes(), mode, precepts, stateToken)
+		op.finish(db, err)
 
 		if err != nil {
 			rerr, ok := err.(bindings.Error)
@@ -49,29 +91,38 @@ func (db *reindexerImpl) modifyItem(ctx context.Context, namespace string, ns *r
 				err = rerr
 				continue
 			}
+			db.checkNsError(ctx, err, ns)
 			return 0, err
 		}
 
 		defer out.Free()
 
 		rdSer := newSerializer(out.GetBuf())
+		var state cjson.State
 		rawQueryParams := rdSer.readRawQueryParams(func(nsid int) {
-			ns.cjsonState.ReadPayloadType(&rdSer.Serializer)
+			state = ns.cjsonState.ReadPayloadType(&rdSer.Serializer)
 		})
+		if state.StateData != nil {
+			// Item is unpacked below with the current state, so it's checked after that
+			defer db.observeNsState(ns, state.StateToken)
+		}
 
 		if rawQueryParams.count == 0 {
 			return 0, err
 		}
 
 		resultp := rdSer.readRawtItemParams()
+		if journalEntry != nil {
+			journalEntry.LSN = int64(resultp.version)
+		}
 
 		ns.cacheLock.Lock()
 		delete(ns.cacheItems, resultp.id)
 		ns.cacheLock.Unlock()
 
-		if len(precepts) > 0 && (resultp.cptr != 0 || resultp.data != nil) && reflect.TypeOf(item).Kind() == reflect.Ptr {
-			nsArrEntry := nsArrayEntry{ns, ns.cjsonState.Copy()}
-			if _, err := unpackItem(&nsArrEntry, &resultp, false, true, item); err != nil {
+		if len(precepts) > 0 && (resultp.cptr != 0 || resultp.data != nil) && item != nil && reflect.TypeOf(item).Kind() == reflect.Ptr {
+			nsArrEntry := nsArrayEntry{ns, ns.cjsonState.Copy(), false}
+			if _, err := unpackItem(&nsArrEntry, &resultp, false, true, item, nil, nil); err != nil {
 				return 0, err
 			}
 		}
@@ -81,6 +132,159 @@ func (db *reindexerImpl) modifyItem(ctx context.Context, namespace string, ns *r
 	return 0, err
 }
 
+func (db *reindexerImpl) modifyItemAsync(ctx context.Context, namespace string, item interface{}, mode int, cmpl bindings.Completion, precepts ...string) error {
+	if err := checkSystemWrite(namespace); err != nil {
+		return err
+	}
+	ns, err := db.getNS(namespace)
+	if err != nil {
+		return err
+	}
+	if err = db.limiter().wait(ctx, ns.name, CommandClassWrite); err != nil {
+		return err
+	}
+	// Lane is held until the item is sent, so the next write of lane is sent after it
+	ctx, orderedWrite, err := ns.beginOrderedWrite(ctx, item)
+	if err != nil {
+		return err
+	}
+	defer orderedWrite.end()
+
+	if db.asyncWritesOpts.FailFast {
+		select {
+		case db.asyncWritesSem <- struct{}{}:
+		default:
+			return ErrAsyncWritesLimit
+		}
+	} else {
+		select {
+		case db.asyncWritesSem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	db.lock.RLock()
+	if atomic.LoadInt32(&db.closed) != 0 {
+		db.lock.RUnlock()
+		<-db.asyncWritesSem
+		return ErrClientClosed
+	}
+	// close waits for completions of all the async writes, which were started before it
+	db.asyncWrites.Add(1)
+	db.lock.RUnlock()
+
+	start := time.Now()
+	journalEntry := db.writeJournal.begin(ns.name, writeOpName(mode), precepts)
+	if journalEntry != nil {
+		journalEntry.PK = ns.itemPK(item)
+	}
+	// Async upserts are not deduplicated, but the hash of item is forgotten
+	dedupWrite, _ := ns.beginDedupWrite(item, nil, mode, precepts)
+	ns.replica.invalidate()
+	done := func(err error) {
+		dedupWrite.end(err)
+		ns.replica.invalidate()
+		if journalEntry != nil {
+			journalEntry.Err = err
+			db.writeJournal.record(journalEntry, start)
+		}
+		<-db.asyncWritesSem
+		db.asyncWrites.Done()
+		if cmpl != nil {
+			cmpl(err)
+		}
+	}
+	if err = db.sendItemAsync(ctx, ns, item, mode, precepts, retriesOnInvalidStateCnt, journalEntry, done); err != nil {
+		dedupWrite.end(err)
+		if journalEntry != nil {
+			journalEntry.Err = err
+			db.writeJournal.record(journalEntry, start)
+		}
+		<-db.asyncWritesSem
+		db.asyncWrites.Done()
+		return err
+	}
+	return nil
+}
+
+// sendItemAsync encodes item and passes it to the binding. done is called with the result, unless error is returned.
+// Payload size and result are set to journalEntry, if it's not nil
+func (db *reindexerImpl) sendItemAsync(ctx context.Context, ns *reindexerNamespace, item interface{}, mode int, precepts []string, retries uint32,
+	journalEntry *bindings.WriteJournalEntry, done bindings.Completion) error {
+	ser := cjson.NewPoolSerializer()
+	defer ser.Close()
+
+	format, stateToken, err := packItem(ns, item, nil, ser)
+	if err != nil {
+		return err
+	}
+	if journalEntry != nil {
+		journalEntry.PayloadSize = len(ser.Bytes())
+	}
+
+	db.rawModifyItemAsync(ctx, ns.nsHash, ns.name, format, ser.Bytes(), mode, precepts, stateToken, func(out bindings.RawBuffer, err error) {
+		if err != nil {
+			if rerr, ok := err.(bindings.Error); ok && rerr.Code() == bindings.ErrStateInvalidated && retries > 0 {
+				// Completion may be called from the network reply handler, so synchronous requests can't be made here
+				go func() {
+					db.query(ns.name).Limit(0).ExecCtx(ctx).Close()
+					if err := db.sendItemAsync(ctx, ns, item, mode, precepts, retries-1, journalEntry, done); err != nil {
+						done(err)
+					}
+				}()
+				return
+			}
+			if out != nil {
+				out.Free()
+			}
+			if ns.missingOnServer(err) {
+				db.invalidateNamespaceAsync(ns, err)
+			}
+			done(err)
+			return
+		}
+
+		rdSer := newSerializer(out.GetBuf())
+		rawQueryParams := rdSer.readRawQueryParams(func(nsid int) {
+			state := ns.cjsonState.ReadPayloadType(&rdSer.Serializer)
+			if ns.serverStateChanged(state.StateToken) {
+				db.invalidateNamespaceAsync(ns, errNsRecreated(ns))
+			}
+		})
+		if journalEntry != nil {
+			journalEntry.Count = rawQueryParams.count
+		}
+		if rawQueryParams.count != 0 {
+			resultp := rdSer.readRawtItemParams()
+			if journalEntry != nil {
+				journalEntry.LSN = int64(resultp.version)
+			}
+			ns.cacheLock.Lock()
+			delete(ns.cacheItems, resultp.id)
+			ns.cacheLock.Unlock()
+		}
+		out.Free()
+		done(nil)
+	})
+	return nil
+}
+
+// rawModifyItemAsync sends item without waiting for reply, if binding supports it. Otherwise item is sent synchronously and cmpl is called before return
+func (db *reindexerImpl) rawModifyItemAsync(ctx context.Context, nsHash int, namespace string, format int, data []byte, mode int, precepts []string, stateToken int, cmpl bindings.RawCompletion) {
+	binding := db.getBinding()
+	if async, ok := binding.(bindings.RawBindingModifyItemAsync); ok {
+		async.ModifyItemAsync(ctx, nsHash, namespace, format, data, mode, precepts, stateToken, cmpl)
+		return
+	}
+	out, err := binding.ModifyItem(ctx, nsHash, namespace, format, data, mode, precepts, stateToken)
+	if err != nil {
+		cmpl(nil, err)
+		return
+	}
+	cmpl(out, nil)
+}
+
 func packItem(ns *reindexerNamespace, item interface{}, json []byte, ser *cjson.Serializer) (format int, stateToken int, err error) {
 
 	if item != nil {
@@ -92,13 +296,14 @@ func packItem(ns *reindexerNamespace, item interface{}, json []byte, ser *cjson.
 		if t.Kind() == reflect.Ptr {
 			t = t.Elem()
 		}
-		if ns.rtype.Name() != t.Name() || ns.rtype.PkgPath() != t.PkgPath() {
+		if ns.rtype == nil || ns.rtype.Name() != t.Name() || ns.rtype.PkgPath() != t.PkgPath() {
 			panic(ErrWrongType)
 		}
 
 		format = bindings.FormatCJson
 
 		enc := ns.cjsonState.NewEncoder()
+		enc.SetFloatFormat(ns.floatFormat)
 		if stateToken, err = enc.Encode(item, ser); err != nil {
 			return
 		}
@@ -121,13 +326,42 @@ func (db *reindexerImpl) getNS(namespace string) (*reindexerNamespace, error) {
 	return ns, nil
 }
 
+// queryNS returns namespace of query or error of its open (see Tenant.Query)
+func (db *reindexerImpl) queryNS(q *Query) (*reindexerNamespace, error) {
+	if q.nsErr != nil {
+		return nil, q.nsErr
+	}
+	return db.getNS(q.Namespace)
+}
+
+// jsonNS returns namespace for raw JSON items. Namespace, which is not registered by OpenNamespace, has no Go type and object cache,
+// items are parsed by server with its own tags state
+func (db *reindexerImpl) jsonNS(namespace string) *reindexerNamespace {
+	if ns, err := db.getNS(namespace); err == nil {
+		return ns
+	}
+	return &reindexerNamespace{
+		name:             strings.ToLower(namespace),
+		cjsonState:       cjson.NewState(),
+		serverStateToken: -1,
+		// Not registered namespace is never reported to invalidation handler
+		invalidated: 1,
+	}
+}
+
 func (db *reindexerImpl) putMeta(ctx context.Context, namespace, key string, data []byte) error {
-	return db.binding.PutMeta(ctx, namespace, key, string(data))
+	if err := db.limiter().wait(ctx, namespace, CommandClassWrite); err != nil {
+		return err
+	}
+	return db.getBinding().PutMeta(ctx, namespace, key, string(data))
 }
 
 func (db *reindexerImpl) getMeta(ctx context.Context, namespace, key string) ([]byte, error) {
+	if err := db.limiter().wait(ctx, namespace, CommandClassRead); err != nil {
+		return nil, err
+	}
 
-	out, err := db.binding.GetMeta(ctx, namespace, key)
+	out, err := db.getBinding().GetMeta(ctx, namespace, key)
 	if err != nil {
 		return nil, err
 	}
@@ -137,8 +371,72 @@ func (db *reindexerImpl) getMeta(ctx context.Context, namespace, key string) ([]
 	return ret, nil
 }
 
-func unpackItem(ns *nsArrayEntry, params *rawResultItemParams, allowUnsafe bool, nonCacheableData bool, item interface{}) (interface{}, error) {
-	useCache := item == nil && (ns.deepCopyIface || allowUnsafe) && !nonCacheableData
+func (db *reindexerImpl) getSQLSuggestions(ctx context.Context, query string, pos int) ([]string, error) {
+	if err := db.checkFeature(featureSQLSuggestions); err != nil {
+		return nil, err
+	}
+	binding, ok := db.getBinding().(bindings.RawBindingSQLSuggestions)
+	if !ok {
+		return nil, ErrServerUnsupported{Feature: featureSQLSuggestions.name, Required: featureSQLSuggestions.minVersion, Actual: db.serverVersion()}
+	}
+	if err := db.limiter().wait(ctx, "", CommandClassRead); err != nil {
+		return nil, err
+	}
+
+	suggestions, err := binding.GetSQLSuggestions(ctx, query, pos)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(suggestions))
+	seen := make(map[string]struct{}, len(suggestions))
+	for _, s := range suggestions {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			ret = append(ret, s)
+		}
+	}
+	return ret, nil
+}
+
+// decodeItem decodes item of results into item. Fields of the stored document are added to presence, if it's not nil.
+// Strings and small slices are placed into arena, if it's not nil
+func decodeItem(ns *nsArrayEntry, params *rawResultItemParams, item interface{}, presence FieldsPresence, arena *cjson.Arena) error {
+	dec := ns.localCjsonState.NewDecoder(item, logger)
+	dec.SetIntTruncation(ns.truncateInts)
+	dec.SetUnknownFields(ns.opts.unknownFields)
+	dec.TrackFieldsPresence(presence)
+	dec.SetArena(arena)
+	if params.cptr != 0 {
+		return dec.DecodeCPtr(params.cptr, item)
+	} else if params.data != nil {
+		return dec.Decode(params.data, item)
+	}
+	panic(fmt.Errorf("Internal error while decoding item id %d from ns %s: cptr and data are both null", params.id, ns.name))
+}
+
+// storeCachedItem stores decoded item to object cache, unless the same or newer version of item is cached.
+// Returns item of cache with the same version or item itself and whether it's stored
+func (ns *reindexerNamespace) storeCachedItem(params *rawResultItemParams, item interface{}) (interface{}, bool) {
+	ns.cacheLock.Lock()
+	defer ns.cacheLock.Unlock()
+	if ns.cacheItems == nil {
+		return item, false
+	}
+	if citem, ok := ns.cacheItems[params.id]; ok {
+		if citem.version == params.version {
+			return citem.item, false
+		} else if citem.version > params.version {
+			return item, false
+		}
+	}
+	ns.cacheItems[params.id] = cacheItem{item: item, version: params.version}
+	return item, true
+}
+
+// unpackItem decodes item of results. Fields of the stored document are added to presence, if it's not nil.
+// Strings and small slices of item, which isn't stored in object cache, are placed into arena, if it's not nil
+func unpackItem(ns *nsArrayEntry, params *rawResultItemParams, allowUnsafe bool, nonCacheableData bool, item interface{}, presence FieldsPresence, arena *cjson.Arena) (interface{}, error) {
+	useCache := item == nil && (ns.deepCopyIface || allowUnsafe) && !nonCacheableData && !ns.noObjCache && presence == nil
 	hasCache := false
 	needCopy := ns.deepCopyIface && !allowUnsafe
 	var err error
@@ -154,45 +452,21 @@ func unpackItem(ns *nsArrayEntry, params *rawResultItemParams, allowUnsafe bool,
 		if citem, ok := ns.cacheItems[params.id]; ok && citem.version == params.version {
 			item = citem.item
 			ns.cacheLock.RUnlock()
+			atomic.AddInt64(&ns.objCacheHits, 1)
 		} else {
 			ns.cacheLock.RUnlock()
+			atomic.AddInt64(&ns.objCacheMisses, 1)
 			item = reflect.New(ns.rtype).Interface()
-			dec := ns.localCjsonState.NewDecoder(item, logger)
-			if params.cptr != 0 {
-				err = dec.DecodeCPtr(params.cptr, item)
-			} else if params.data != nil {
-				err = dec.Decode(params.data, item)
-			} else {
-				panic(fmt.Errorf("Internal error while decoding item id %d from ns %s: cptr and data are both null", params.id, ns.name))
-			}
-			if err != nil {
+			if err = decodeItem(ns, params, item, nil, nil); err != nil {
 				return item, err
 			}
-			ns.cacheLock.Lock()
-			if citem, ok := ns.cacheItems[params.id]; ok {
-				if citem.version == params.version {
-					item = citem.item
-				} else if citem.version < params.version {
-					ns.cacheItems[params.id] = cacheItem{item: item, version: params.version}
-				}
-			} else {
-				ns.cacheItems[params.id] = cacheItem{item: item, version: params.version}
-			}
-			ns.cacheLock.Unlock()
+			item, _ = ns.storeCachedItem(params, item)
 		}
 	} else {
 		if item == nil {
 			item = reflect.New(ns.rtype).Interface()
 		}
-		dec := ns.localCjsonState.NewDecoder(item, logger)
-		if params.cptr != 0 {
-			err = dec.DecodeCPtr(params.cptr, item)
-		} else if params.data != nil {
-			err = dec.Decode(params.data, item)
-		} else {
-			panic(fmt.Errorf("Internal error while decoding item id %d from ns %s: cptr and data are both null", params.id, ns.name))
-		}
-		if err != nil {
+		if err = decodeItem(ns, params, item, presence, arena); err != nil {
 			return item, err
 		}
 		// Reset needCopy, because item already separate
@@ -210,11 +484,10 @@ func unpackItem(ns *nsArrayEntry, params *rawResultItemParams, allowUnsafe bool,
 	return item, err
 }
 
-func (db *reindexerImpl) rawResultToJson(rawResult []byte, jsonName string, totalName string, initJson []byte, initOffsets []int) (json []byte, offsets []int, explain []byte, err error) {
+func (db *reindexerImpl) rawResultToJson(rawResult []byte, nsArray []nsArrayEntry, jsonName string, totalName string, initJson []byte, initOffsets []int) (json []byte, offsets []int, rawQueryParams rawResultQueryParams, err error) {
 
 	ser := newSerializer(rawResult)
-	rawQueryParams := ser.readRawQueryParams()
-	explain = rawQueryParams.explainResults
+	rawQueryParams = ser.readRawQueryParams()
 
 	jsonReserveLen := len(rawResult) + len(totalName) + len(jsonName) + 20
 	if cap(initJson) < jsonReserveLen {
@@ -242,13 +515,19 @@ func (db *reindexerImpl) rawResultToJson(rawResult []byte, jsonName string, tota
 	jsonBuf.WriteString(jsonName)
 	jsonBuf.WriteString("\":[")
 
+	var formatted []byte
 	for i := 0; i < rawQueryParams.count; i++ {
 		item := ser.readRawtItemParams()
 		if i != 0 {
 			jsonBuf.WriteString(",")
 		}
 		offsets = append(offsets, len(jsonBuf.Bytes()))
-		jsonBuf.Write(item.data)
+		if item.nsid < len(nsArray) && nsArray[item.nsid].jsonFloats != nil {
+			formatted = nsArray[item.nsid].jsonFloats.format(formatted[:0], item.data)
+			jsonBuf.Write(formatted)
+		} else {
+			jsonBuf.Write(item.data)
+		}
 
 		if (rawQueryParams.flags&bindings.ResultsWithJoined) != 0 && ser.GetVarUInt() != 0 {
 			panic("Sorry, not implemented: Can't return join query results as json")
@@ -256,21 +535,27 @@ func (db *reindexerImpl) rawResultToJson(rawResult []byte, jsonName string, tota
 	}
 	jsonBuf.WriteString("]}")
 
-	return jsonBuf.Bytes(), offsets, explain, nil
+	return jsonBuf.Bytes(), offsets, rawQueryParams, nil
 }
 
 func (db *reindexerImpl) prepareQuery(ctx context.Context, q *Query, asJson bool) (result bindings.RawBuffer, err error) {
 
-	if ns, err := db.getNS(q.Namespace); err == nil {
-		q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy()})
+	if ns, err := db.queryNS(q); err == nil {
+		q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), q.noObjCache})
+	} else if asJson && q.jsonNS {
+		ns = db.jsonNS(q.Namespace)
+		q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), true})
 	} else {
 		return nil, err
 	}
 
-	ser := q.ser
+	if err = q.resolveRelTimes(time.Now()); err != nil {
+		return nil, err
+	}
+	ser := q.execSer()
 	for _, sq := range q.mergedQueries {
 		if ns, err := db.getNS(sq.Namespace); err == nil {
-			q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy()})
+			q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), sq.noObjCache})
 		} else {
 			return nil, err
 		}
@@ -278,7 +563,7 @@ func (db *reindexerImpl) prepareQuery(ctx context.Context, q *Query, asJson bool
 
 	for _, sq := range q.joinQueries {
 		if ns, err := db.getNS(sq.Namespace); err == nil {
-			q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy()})
+			q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), sq.noObjCache})
 		} else {
 			return nil, err
 		}
@@ -287,40 +572,43 @@ func (db *reindexerImpl) prepareQuery(ctx context.Context, q *Query, asJson bool
 	for _, mq := range q.mergedQueries {
 		for _, sq := range mq.joinQueries {
 			if ns, err := db.getNS(sq.Namespace); err == nil {
-				q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy()})
+				q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), sq.noObjCache})
 			} else {
 				return nil, err
 			}
 		}
 	}
 
-	ser.PutVarCUInt(queryEnd)
-	for _, sq := range q.joinQueries {
-		ser.PutVarCUInt(sq.joinType)
-		ser.Append(sq.ser)
-		ser.PutVarCUInt(queryEnd)
-	}
-
-	for _, mq := range q.mergedQueries {
-		ser.PutVarCUInt(merge)
-		ser.Append(mq.ser)
-		ser.PutVarCUInt(queryEnd)
-		for _, sq := range mq.joinQueries {
-			ser.PutVarCUInt(sq.joinType)
-			ser.Append(sq.ser)
-			ser.PutVarCUInt(queryEnd)
+	if len(q.requiredState) != 0 {
+		if err = db.putRequiredState(ctx, q, &ser); err != nil {
+			return nil, err
 		}
 	}
-
-	for _, ns := range q.nsArray {
-		q.ptVersions = append(q.ptVersions, ns.localCjsonState.Version^ns.localCjsonState.StateToken)
+	if err = q.putTieBreaker(&ser); err != nil {
+		return nil, err
 	}
-	fetchCount := q.fetchCount
+	db.captureStateLSN(ctx, q)
+	db.sampleExplain(q, &ser)
+	q.putSubQueries(&ser, true)
+	q.putPtVersions()
+	fetchCount := q.nextFetchCount()
 	if asJson {
 		// json iterator not support fetch queries
 		fetchCount = -1
 	}
-	result, err = db.binding.SelectQuery(ctx, ser.Bytes(), asJson, q.ptVersions, fetchCount)
+	if ctx, err = db.withReplyLimit(ctx, q.maxBufferBytes, 0); err != nil {
+		return nil, err
+	}
+	if !asJson && q.bulkFetch(db.bulkFetchBytes, fetchCount) {
+		ctx = bindings.ContextWithBulkConn(ctx)
+	}
+	result, err = db.getBinding().SelectQuery(ctx, ser.Bytes(), asJson, q.ptVersions, fetchCount)
+	if err != nil {
+		for i := range q.nsArray {
+			db.checkNsError(ctx, err, q.nsArray[i].reindexerNamespace)
+		}
+		err = stateMismatchError(err, q)
+	}
 
 	if err == nil && result.GetBuf() == nil {
 		panic(fmt.Errorf("result.Buffer is nil"))
@@ -328,28 +616,91 @@ func (db *reindexerImpl) prepareQuery(ctx context.Context, q *Query, asJson bool
 	return
 }
 
+// reexecuteQuery runs query again with offset and limit of the rest of items.
+// It's used to continue iteration, when query results were lost on reconnect. RelTime values are the same as in the first execution
+func (db *reindexerImpl) reexecuteQuery(ctx context.Context, q *Query, offset, limit int) (bindings.RawBuffer, error) {
+	ser := q.execSer()
+	ser.PutVarCUInt(queryOffset).PutVarCUInt(offset)
+	ser.PutVarCUInt(queryLimit).PutVarCUInt(limit)
+	if err := q.putTieBreaker(&ser); err != nil {
+		return nil, err
+	}
+	q.putSubQueries(&ser, true)
+	q.putPtVersions()
+	fetchCount := q.nextFetchCount()
+	if q.bulkFetch(db.bulkFetchBytes, fetchCount) {
+		ctx = bindings.ContextWithBulkConn(ctx)
+	}
+	return db.getBinding().SelectQuery(ctx, ser.Bytes(), false, q.ptVersions, fetchCount)
+}
+
 // Execute query
 func (db *reindexerImpl) execQuery(ctx context.Context, q *Query) *Iterator {
+	if it := db.replicaIterator(ctx, q); it != nil {
+		return it
+	}
+	key, hooked := db.queryHooksKey(q)
+	if hooked {
+		if served, res := db.beforeExec(key, q, false); served {
+			return db.cachedIterator(ctx, q, res)
+		}
+	}
+	if err := db.limiter().wait(ctx, q.Namespace, CommandClassRead); err != nil {
+		return errIterator(err)
+	}
+	op := db.startOp(ctx, bindings.ShutdownOpSelect, q.Namespace)
 	result, err := db.prepareQuery(ctx, q, false)
 	if err != nil {
+		err = q.minMaxError(err)
+		op.finish(db, err)
+		if hooked {
+			db.afterExec(key, q, false, rawResultQueryParams{}, err)
+		}
 		return errIterator(err)
 	}
-	iter := newIterator(ctx, q, result, q.nsArray, q.joinToFields, q.joinHandlers, q.context)
+	iter := newIterator(ctx, db, q, result, q.nsArray, q.joinToFields, q.joinHandlers, q.context)
+	iter.op = op
+	q.addSampledExplain(iter.rawQueryParams.explainResults)
+	if hooked {
+		db.afterExec(key, q, false, iter.rawQueryParams, iter.err)
+	}
 	return iter
 }
 
 func (db *reindexerImpl) execJSONQuery(ctx context.Context, q *Query, jsonRoot string) *JSONIterator {
+	key, hooked := db.queryHooksKey(q)
+	if hooked {
+		if served, res := db.beforeExec(key, q, true); served {
+			return db.cachedJSONIterator(ctx, q, jsonRoot, res)
+		}
+	}
+	if err := db.limiter().wait(ctx, q.Namespace, CommandClassRead); err != nil {
+		return errJSONIterator(err)
+	}
+	op := db.startOp(ctx, bindings.ShutdownOpSelect, q.Namespace)
 	result, err := db.prepareQuery(ctx, q, true)
 	if err != nil {
+		op.finish(db, err)
+		if hooked {
+			db.afterExec(key, q, true, rawResultQueryParams{}, err)
+		}
 		return errJSONIterator(err)
 	}
 	defer result.Free()
-	var explain []byte
-	q.json, q.jsonOffsets, explain, err = db.rawResultToJson(result.GetBuf(), jsonRoot, q.totalName, q.json, q.jsonOffsets)
+	var params rawResultQueryParams
+	q.json, q.jsonOffsets, params, err = db.rawResultToJson(result.GetBuf(), q.nsArray, jsonRoot, q.totalName, q.json, q.jsonOffsets)
+	op.finish(db, err)
+	q.addSampledExplain(params.explainResults)
+	if hooked {
+		db.afterExec(key, q, true, params, err)
+	}
 	if err != nil {
 		return errJSONIterator(err)
 	}
-	return newJSONIterator(ctx, q, q.json, q.jsonOffsets, explain)
+	if params.nsStateLSN < 0 {
+		params.nsStateLSN = q.stateLSN
+	}
+	return newJSONIterator(ctx, db, q, q.json, q.jsonOffsets, q.Namespace, params)
 }
 
 func (db *reindexerImpl) prepareSQL(ctx context.Context, namespace, query string, asJson bool) (result bindings.RawBuffer, nsArray []nsArrayEntry, err error) {
@@ -359,29 +710,65 @@ func (db *reindexerImpl) prepareSQL(ctx context.Context, namespace, query string
 	if ns, err = db.getNS(namespace); err != nil {
 		return
 	}
+	if err = db.limiter().wait(ctx, namespace, CommandClassRead); err != nil {
+		return
+	}
 
-	nsArray = append(nsArray, nsArrayEntry{ns, ns.cjsonState.Copy()})
+	nsArray = append(nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), false})
 
 	ptVersions := make([]int32, 0, 16)
 	for _, ns := range nsArray {
 		ptVersions = append(ptVersions, ns.localCjsonState.Version^ns.localCjsonState.StateToken)
 	}
 
-	result, err = db.binding.Select(ctx, query, asJson, ptVersions, defaultFetchCount)
+	result, err = db.getBinding().Select(ctx, query, asJson, ptVersions, defaultFetchCount)
+	db.checkNsError(ctx, err, ns)
 	return
 }
 
 // Execute query
-func (db *reindexerImpl) deleteQuery(ctx context.Context, q *Query) (int, error) {
+func (db *reindexerImpl) deleteQuery(ctx context.Context, q *Query) (count int, explain []byte, err error) {
 
-	ns, err := db.getNS(q.Namespace)
+	ns, err := db.queryNS(q)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
+	}
+	if err = db.limiter().wait(ctx, ns.name, CommandClassWrite); err != nil {
+		return 0, nil, err
 	}
 
-	result, err := db.binding.DeleteQuery(ctx, ns.nsHash, q.ser.Bytes())
+	if q.dryRun {
+		if err := db.checkFeature(featureDryRun); err != nil {
+			return db.dryRunDeleteQuery(ctx, q)
+		}
+		q.ser.PutVarCUInt(queryDryRun)
+	} else if journalEntry := db.writeJournal.begin(ns.name, bindings.WriteOpDeleteQuery, nil); journalEntry != nil {
+		start := time.Now()
+		journalEntry.PayloadSize = len(q.ser.Bytes())
+		defer func() {
+			journalEntry.Count, journalEntry.Err = count, err
+			db.writeJournal.record(journalEntry, start)
+		}()
+	}
+
+	if !q.dryRun {
+		// Query may change any items of namespace
+		dedupWrite := ns.upsertDedup.beginClear()
+		defer dedupWrite.end(nil)
+		ns.replica.invalidate()
+		defer ns.replica.invalidate()
+	}
+	if err = q.resolveRelTimes(time.Now()); err != nil {
+		return 0, nil, err
+	}
+	qser := q.execSer()
+	q.putSoftDeleteFilter(&qser)
+	op := db.startOp(ctx, bindings.ShutdownOpDelete, ns.name)
+	result, err := db.getBinding().DeleteQuery(ctx, ns.nsHash, qser.Bytes())
+	op.finish(db, err)
 	if err != nil {
-		return 0, err
+		db.checkNsError(ctx, err, ns)
+		return 0, nil, err
 	}
 	defer result.Free()
 
@@ -406,19 +793,76 @@ func (db *reindexerImpl) deleteQuery(ctx context.Context, q *Query) (int, error)
 		panic("Internal error: data after end of delete query result")
 	}
 
-	return rawQueryParams.count, err
+	return rawQueryParams.count, append([]byte(nil), rawQueryParams.explainResults...), err
+}
+
+// dryRunDeleteQuery emulates dry run of delete query by equivalent select for servers, which don't support it
+func (db *reindexerImpl) dryRunDeleteQuery(ctx context.Context, q *Query) (int, []byte, error) {
+	q.Explain()
+	q.fetchCount = -1
+	result, err := db.prepareQuery(ctx, q, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer result.Free()
+
+	ser := newSerializer(result.GetBuf())
+	rawQueryParams := ser.readRawQueryParams(func(nsid int) {
+		q.nsArray[nsid].localCjsonState = q.nsArray[nsid].cjsonState.ReadPayloadType(&ser.Serializer)
+	})
+	return rawQueryParams.count, append([]byte(nil), rawQueryParams.explainResults...), nil
 }
 
 // Execute query
 func (db *reindexerImpl) updateQuery(ctx context.Context, q *Query) *Iterator {
 
-	ns, err := db.getNS(q.Namespace)
+	ns, err := db.queryNS(q)
 	if err != nil {
 		return errIterator(err)
 	}
+	if err = db.limiter().wait(ctx, ns.name, CommandClassWrite); err != nil {
+		return errIterator(err)
+	}
 
-	result, err := db.binding.UpdateQuery(ctx, ns.nsHash, q.ser.Bytes())
+	count := 0
+	if q.dryRun {
+		if err := db.checkFeature(featureDryRun); err != nil {
+			// Equivalent select returns the same items and explain for servers, which don't support dry run
+			q.Explain()
+			q.fetchCount = -1
+			result, err := db.prepareQuery(ctx, q, false)
+			if err != nil {
+				return errIterator(err)
+			}
+			return newIterator(ctx, db, q, result, q.nsArray, nil, nil, nil)
+		}
+		q.ser.PutVarCUInt(queryDryRun)
+	} else if journalEntry := db.writeJournal.begin(ns.name, bindings.WriteOpUpdateQuery, nil); journalEntry != nil {
+		start := time.Now()
+		journalEntry.PayloadSize = len(q.ser.Bytes())
+		defer func() {
+			journalEntry.Count, journalEntry.Err = count, err
+			db.writeJournal.record(journalEntry, start)
+		}()
+	}
+
+	if !q.dryRun {
+		// Query may change any items of namespace
+		dedupWrite := ns.upsertDedup.beginClear()
+		defer dedupWrite.end(nil)
+		ns.replica.invalidate()
+		defer ns.replica.invalidate()
+	}
+	if err = q.resolveRelTimes(time.Now()); err != nil {
+		return errIterator(err)
+	}
+	qser := q.execSer()
+	q.putSoftDeleteFilter(&qser)
+	op := db.startOp(ctx, bindings.ShutdownOpUpdate, ns.name)
+	result, err := db.getBinding().UpdateQuery(ctx, ns.nsHash, qser.Bytes())
+	op.finish(db, err)
 	if err != nil {
+		db.checkNsError(ctx, err, ns)
 		return errIterator(err)
 	}
 
@@ -427,6 +871,7 @@ func (db *reindexerImpl) updateQuery(ctx context.Context, q *Query) *Iterator {
 	rawQueryParams := ser.readRawQueryParams(func(nsid int) {
 		ns.cjsonState.ReadPayloadType(&ser.Serializer)
 	})
+	count = rawQueryParams.count
 
 	ns.cacheLock.Lock()
 	for i := 0; i < rawQueryParams.count; i++ {
@@ -445,19 +890,31 @@ func (db *reindexerImpl) updateQuery(ctx context.Context, q *Query) *Iterator {
 		panic("Internal error: data after end of update query result")
 	}
 
-	q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy()})
-	return newIterator(ctx, q, result, q.nsArray, nil, nil, nil)
+	q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), false})
+	return newIterator(ctx, db, q, result, q.nsArray, nil, nil, nil)
 }
 
 // Execute query
 func (db *reindexerImpl) updateQueryTx(ctx context.Context, q *Query, tx *Tx) *Iterator {
-	err := db.binding.UpdateQueryTx(&tx.ctx, q.ser.Bytes())
+	if err := q.resolveRelTimes(time.Now()); err != nil {
+		return errIterator(err)
+	}
+	ser := q.execSer()
+	q.putSoftDeleteFilter(&ser)
+	tx.dedup.clearAll()
+	err := db.getBinding().UpdateQueryTx(&tx.ctx, ser.Bytes())
 	return errIterator(err)
 }
 
 // Execute query
 func (db *reindexerImpl) deleteQueryTx(ctx context.Context, q *Query, tx *Tx) (int, error) {
-	err := db.binding.DeleteQueryTx(&tx.ctx, q.ser.Bytes())
+	if err := q.resolveRelTimes(time.Now()); err != nil {
+		return 0, err
+	}
+	ser := q.execSer()
+	q.putSoftDeleteFilter(&ser)
+	tx.dedup.clearAll()
+	err := db.getBinding().DeleteQueryTx(&tx.ctx, ser.Bytes())
 	return 0, err
 }
 
@@ -479,6 +936,10 @@ func (db *reindexerImpl) resetCachesCtx(ctx context.Context) {
 		}
 		ns.cacheLock.Unlock()
 		ns.cjsonState.Reset()
+		ns.upsertDedup.flush()
+		ns.replica.invalidate()
+		// Results of another server have another tags state, it's not a sign of recreated namespace
+		atomic.StoreInt32(&ns.serverStateToken, -1)
 		db.query(ns.name).Limit(0).ExecCtx(ctx).Close()
 	}
 }
@@ -495,10 +956,114 @@ func WithConnPoolSize(connPoolSize int) interface{} {
 	return bindings.OptionConnPoolSize{connPoolSize}
 }
 
+// WithPoolStrategy sets strategy of choice of cproto connection for each request: PoolRoundRobin (default), PoolLeastInFlight
+// or PoolTwoRandomChoices. Load aware strategies keep fast requests from queueing behind slow ones on the same connection
+func WithPoolStrategy(strategy int) interface{} {
+	return bindings.OptionPoolStrategy{Strategy: strategy}
+}
+
+// WithConnQueueSize sets max count of concurrent requests of each cproto connection (default 512, max 4096)
+func WithConnQueueSize(queueSize int) interface{} {
+	return bindings.OptionConnQueueSize{QueueSize: queueSize}
+}
+
 func WithRetryAttempts(read int, write int) interface{} {
 	return bindings.OptionRetryAttempts{read, write}
 }
 
+// WithConnectRetries sets retries budget of the initial connection. Requests, which are started while client is connecting, wait for the connection
+func WithConnectRetries(attempts int, backoff time.Duration, total time.Duration) interface{} {
+	return bindings.OptionConnectRetries{Attempts: attempts, Backoff: backoff, Total: total}
+}
+
+// WithReconnectBackoff delays reconnect attempts of cproto binding after failed reconnect: delay starts from min and is doubled up to max
+func WithReconnectBackoff(min time.Duration, max time.Duration) interface{} {
+	return bindings.OptionReconnectBackoff{Min: min, Max: max}
+}
+
+// WithPingInterval pings cproto connections, which have no replies for interval. Connection, which doesn't reply to ping in timeout, is reconnected
+func WithPingInterval(interval time.Duration, timeout time.Duration) interface{} {
+	return bindings.OptionPingInterval{Interval: interval, Timeout: timeout}
+}
+
+// WithRequeueOnConnLoss makes cproto binding send idempotent requests, which connection is broken after they were sent, via another
+// connection up to attempts times. The other requests fail with ErrCodeConnBrokenMidRequest in this case
+func WithRequeueOnConnLoss(attempts int) interface{} {
+	return bindings.OptionRequeueOnConnLoss{Attempts: attempts}
+}
+
+// WithDiscardedRepliesLimit makes cproto binding reconnect connection, which discards more than count replies without waiting requests
+// in interval, because it's out of sync with server. Discarded replies are counted by Status and are logged with warning anyway
+func WithDiscardedRepliesLimit(count int, interval time.Duration) interface{} {
+	return bindings.OptionDiscardedRepliesLimit{Count: count, Interval: interval}
+}
+
+// WithBackgroundReconnect makes cproto binding re-dial failed connections in background with exponential backoff from min up to max,
+// which is changed by random share jitter of delay. Requests are sent via the rest of the pool meanwhile.
+// If all the connections are down longer than downWindow, requests fail with ErrCodeNetwork
+func WithBackgroundReconnect(min time.Duration, max time.Duration, jitter float64, downWindow time.Duration) interface{} {
+	return bindings.OptionReconnectBackoff{Min: min, Max: max, Jitter: jitter, Background: true, DownWindow: downWindow}
+}
+
+// WithResultChecksum makes cproto binding verify each chunk of query results by CRC-32C checksum, which is sent by server.
+// Mismatch fails query or iterator with ErrResultCorrupted. Query.ResultChecksum overrides it for the query
+func WithResultChecksum() interface{} {
+	return bindings.OptionResultChecksum{Enable: true}
+}
+
+// WithBulkConns reserves up to count connections of cproto binding for bulk queries, e.g. long exports, so their fetches don't delay
+// short requests of the main pool. Select queries with limit not less than minLimit (0 - disabled) and queries with Query.UseDedicatedConn are bulk
+func WithBulkConns(count int, minLimit int) interface{} {
+	return bindings.OptionBulkConns{Count: count, MinLimit: minLimit}
+}
+
+// WithBulkFetchThreshold makes select queries bulk (see WithBulkConns), if chunks of their results are expected to be not less than bytes.
+// Size of chunk is estimated by FetchCount (or Limit) of query and by average size of items in the previous results of namespace,
+// so query is sent with all the fetches of its results via bulk connection, as results are bound to connection of the query
+func WithBulkFetchThreshold(bytes int) interface{} {
+	return bindings.OptionBulkFetchThreshold{Bytes: bytes}
+}
+
+// WithCircuitBreaker enables client-side circuit breaker of cproto binding: after failureThreshold consecutive network errors and timeouts
+// requests to the host fail immediately with ErrCircuitOpen during openDuration, then up to halfOpenProbes requests check, whether server is recovered.
+// onStateChange (may be nil) is called on each transition of breaker
+func WithCircuitBreaker(failureThreshold int, openDuration time.Duration, halfOpenProbes int, onStateChange func(ev CircuitBreakerEvent)) interface{} {
+	return bindings.OptionCircuitBreaker{FailureThreshold: failureThreshold, OpenDuration: openDuration, HalfOpenProbes: halfOpenProbes, OnStateChange: onStateChange}
+}
+
+// WithTLS makes cproto binding connect to server via TLS with config cfg
+func WithTLS(cfg *tls.Config) interface{} {
+	return bindings.OptionTLS{Config: cfg}
+}
+
+// WithDialer makes cproto binding dial connections to server by dialContext (e.g. via SOCKS proxy) instead of net.Dialer.
+// Deadline of ctx is set by login timeout
+func WithDialer(dialContext func(ctx context.Context, network, addr string) (net.Conn, error)) interface{} {
+	return bindings.OptionDialer{DialContext: dialContext}
+}
+
+// WithTCP tunes TCP sockets of cproto binding: period of keepalive probes (0 - default, negative - disabled)
+// and sizes of kernel read and write buffers (0 - default)
+func WithTCP(keepAlivePeriod time.Duration, readBufSize, writeBufSize int) interface{} {
+	return bindings.OptionTCP{KeepAlivePeriod: keepAlivePeriod, ReadBufSize: readBufSize, WriteBufSize: writeBufSize}
+}
+
+// WithDisableObjCache disables object cache of all the namespaces
+func WithDisableObjCache() interface{} {
+	return bindings.OptionDisableObjCache{}
+}
+
+// WithDisableRegistrationAudit disables recording of callers of OpenNamespace and RegisterNamespace (see Registrations)
+func WithDisableRegistrationAudit() interface{} {
+	return bindings.OptionDisableRegistrationAudit{}
+}
+
+// WithQueryHooks sets hooks of select queries for application-level results cache: beforeExec may serve result of query
+// by its Query.CacheKey instead of server, afterExec gets metadata of results of server. Any of hooks may be nil
+func WithQueryHooks(beforeExec func(key uint64, q QueryDescription) (served bool, result CachedResult), afterExec func(key uint64, res ResultSummary)) interface{} {
+	return bindings.OptionQueryHooks{BeforeExec: beforeExec, AfterExec: afterExec}
+}
+
 func WithServerConfig(startupTimeout time.Duration, serverConfig *config.ServerConfig) interface{} {
 	return bindings.OptionBuiltinWithServer{ServerConfig: serverConfig, StartupTimeout: startupTimeout}
 }
@@ -507,14 +1072,201 @@ func WithTimeouts(loginTimeout time.Duration, requestTimeout time.Duration) inte
 	return bindings.OptionTimeouts{loginTimeout, requestTimeout}
 }
 
+// WithLoginTimeout sets only login timeout. Can be combined with WithRequestTimeout in Open
+func WithLoginTimeout(loginTimeout time.Duration) interface{} {
+	return bindings.OptionTimeouts{LoginTimeout: loginTimeout}
+}
+
+// WithRequestTimeout sets only request timeout. Can be combined with WithLoginTimeout in Open
+func WithRequestTimeout(requestTimeout time.Duration) interface{} {
+	return bindings.OptionTimeouts{RequestTimeout: requestTimeout}
+}
+
 func WithCreateDBIfMissing() interface{} {
 	return bindings.OptionConnect{CreateDBIfMissing: true}
 }
 
+// WithNetCompression enables snappy compression of cproto requests and results. Compression is used after the login reply, if server
+// supports it (cproto version 0x103+), so old servers are accessed without compression
 func WithNetCompression() interface{} {
 	return bindings.OptionCompression{EnableCompression: true}
 }
 
+// WithNetCompressionThreshold enables compression of cproto requests, which are larger than threshold bytes.
+// Small requests and replies to them are sent uncompressed to save CPU. See also Query.NoCompression and Query.ForceCompression
+func WithNetCompressionThreshold(threshold int) interface{} {
+	return bindings.OptionCompression{EnableCompression: true, Threshold: threshold}
+}
+
+// WithWriteCoalescing enables batching of small cproto requests into one socket write.
+// The first queued request waits up to delay for the subsequent ones, or until maxPending bytes are queued (0 - no limit).
+// Ping, login, close results and tx commit/rollback are written immediately. Trades latency for fewer syscalls at high concurrency
+func WithWriteCoalescing(delay time.Duration, maxPending int) interface{} {
+	return bindings.OptionWriteCoalescing{Delay: delay, MaxPending: maxPending}
+}
+
+// WithAutoReexecute makes iterator to re-run query with offset of already consumed items, if cproto connection,
+// which owned query results, was broken during iteration. Otherwise, iterator fails with *ErrResultsLostOnReconnect.
+// It's best-effort: items, which were modified concurrently, may be skipped or returned twice
+func WithAutoReexecute() interface{} {
+	return bindings.OptionAutoReexecute{AutoReexecute: true}
+}
+
+// WithAsyncWritesLimit limits count of the client's async writes (UpsertAsync, etc), which are waiting for reply.
+// When the limit is reached, new async write blocks until one of the replies is received or fails with ErrAsyncWritesLimit, if failFast is set.
+// Default limit is 1000 writes
+func WithAsyncWritesLimit(maxInFlight int, failFast bool) interface{} {
+	return bindings.OptionAsyncWrites{MaxInFlight: maxInFlight, FailFast: failFast}
+}
+
+// WithFloatFormat sets default format of all the float fields. Fields may override it by 'precision=N', 'round' and 'nan=keep|null|error'
+// options of reindex tag, e.g. `reindex:"score,,precision=4"`. Precision limits digits after decimal point in JSON output (ExecToJson, etc)
+// and doesn't affect stored values and query conditions, unless Round is set
+func WithFloatFormat(format FloatFormat) interface{} {
+	return bindings.OptionFloatFormat{Format: format}
+}
+
+// WithIntTruncation restores legacy decoding of integer fields: stored values, which don't fit into type of the field
+// (e.g. 300 into uint8), are silently truncated instead of failing with ErrValueOverflow
+func WithIntTruncation() interface{} {
+	return bindings.OptionIntTruncation{Truncate: true}
+}
+
+// WithDestructiveGuard sets guard, which is called before DropNamespace, TruncateNamespace and DropIndex requests
+// and before implicit drops of namespace by OpenNamespace (see DestructiveOp). Error of the guard aborts the operation
+// client-side and is returned to the caller. If implicit drop on storage format error is rejected, namespace is opened without it.
+// E.g. to require confirmation by context or to restrict drops by namespace pattern
+func WithDestructiveGuard(guard func(op DestructiveOp) error) interface{} {
+	return bindings.OptionDestructiveGuard{Guard: guard}
+}
+
+// WithWriteJournal sets journal, which is called for each Insert, Update, Upsert, Delete (including async ones),
+// update and delete query and transaction commit after its outcome is known. Journal is called by background goroutine
+// in the order of outcomes, so it never blocks requests: entries, which don't fit into the queue of queueSize (default 1000), are dropped.
+// Counters of delivered and dropped entries are reported by Status. Dry runs are not journaled, system namespaces are excluded unless includeSystem is set
+func WithWriteJournal(journal func(entry WriteJournalEntry), queueSize int, includeSystem bool) interface{} {
+	return bindings.OptionWriteJournal{Journal: journal, QueueSize: queueSize, IncludeSystem: includeSystem}
+}
+
+// WithOnNamespaceInvalidated sets handler, which is called, when registered namespace doesn't exist on server anymore
+// or is recreated there. Object cache and cjson state of the namespace are flushed before the call.
+// If reopen is true, namespace is opened again with the registered type after the call.
+// Recreation is detected by results of requests, so it's handled in background without blocking them
+func WithOnNamespaceInvalidated(handler func(namespace string, reason error), reopen bool) interface{} {
+	return bindings.OptionOnNamespaceInvalidated{Handler: handler, Reopen: reopen}
+}
+
+// WithConnectHook sets hook, which is called after login of each new or re-established cproto connection, before the connection is used
+// by the other requests. Requests, which hook makes with its ctx via binding, are sent via this connection; error of hook fails the connection.
+// Namespaces, which are opened by the client, are opened again on each new connection before the hook
+func WithConnectHook(onConnect func(ctx context.Context, binding bindings.RawBinding) error) interface{} {
+	return bindings.OptionConnectHook{OnConnect: onConnect}
+}
+
+// WithFailback makes cproto binding with multi DSN config to return to the first DSN: when the pool is connected to another DSN,
+// server of the first one is dialed each probeInterval and connections are moved to it, once it's reachable
+func WithFailback(probeInterval time.Duration) interface{} {
+	return bindings.OptionFailback{ProbeInterval: probeInterval}
+}
+
+// WithMaxResultBufferBytes limits total size of query results buffers, retained by all the open iterators (including JSON ones) of the client.
+// When the limit is exceeded, iterator fails with ErrResultBufferLimit. cproto binding rejects oversized reply before reading it. 0 - no limit
+func WithMaxResultBufferBytes(maxBytes int) interface{} {
+	return bindings.OptionMaxResultBufferBytes{MaxBytes: maxBytes}
+}
+
+// WithRateLimit limits rate of the client's requests by token bucket per rule. Request waits for or fails by each matching rule.
+// Control commands (ping, login, status) are not limited. Counters of the rules are reported by Status
+func WithRateLimit(rules ...RateRule) interface{} {
+	return bindings.OptionRateLimit{Rules: rules}
+}
+
+// WithOnDisconnect sets handler, which is called, when cproto connection is closed not by the client.
+// On shutdown notice of server (or close of idle connection by server) the connection isn't used for new requests,
+// it's closed after in-flight requests and its slot of the pool is connected again in background
+func WithOnDisconnect(handler func(ev DisconnectEvent)) interface{} {
+	return bindings.OptionOnDisconnect{Handler: handler}
+}
+
+// WithSlowRPCHook sets hook, which is called for each cproto request, which took at least threshold.
+// Reported duration includes time, which request was waiting for the free slot of connection (QueueWait)
+func WithSlowRPCHook(threshold time.Duration, hook func(rpc SlowRPC)) interface{} {
+	return bindings.OptionSlowRPC{Threshold: threshold, Hook: hook}
+}
+
+// WithTracer sets tracer of cproto requests: startSpan is called with context of each request (including fetches of results,
+// logins and pings), and returned end is called with sizes of request and reply and with error, when the request is done.
+// startSpan and end are called synchronously by requests, so they must be fast. Tracer may be changed by UpdateOptions
+func WithTracer(startSpan func(ctx context.Context, span RPCSpan) (end func(res RPCSpanEnd))) interface{} {
+	return bindings.OptionTracer{StartSpan: startSpan}
+}
+
+// WithRequestLog sets hook, which is called with each cproto request (including fetches of results, logins and pings), when it's done:
+// name of command, seq number, wait and round-trip times, sizes of frames and error. It's useful to debug protocol issues without tcpdump.
+// Hook is called synchronously by requests, so it must be fast. Hook may be changed by UpdateOptions, nil hook disables logging
+func WithRequestLog(hook func(entry RequestLogEntry)) interface{} {
+	return bindings.OptionRequestLog{Hook: hook}
+}
+
+// WithShutdownReport sets report, which is called once by Close with operations, which are running or have open iterators:
+// namespace, label, count of consumed items, elapsed time and whether they were drained or aborted. Close waits up to drainTimeout
+// (bounded by context of CloseCtx) for them to complete, while connections are still open. Operations are tracked only if report is set
+func WithShutdownReport(drainTimeout time.Duration, report func(report ShutdownReport)) interface{} {
+	return bindings.OptionShutdownReport{DrainTimeout: drainTimeout, Report: report}
+}
+
+// WithQueueFullError makes cproto request, which doesn't get free request slot of connection in maxQueueWait
+// (0 - if all the slots are busy), fail with ErrCodeQueueFull instead of waiting for the slot without limit (if context has no deadline).
+// Connections of the pool with free slots are preferred for requests in this mode
+func WithQueueFullError(maxQueueWait time.Duration) interface{} {
+	return bindings.OptionQueueFullError{MaxQueueWait: maxQueueWait}
+}
+
+// WithWriteLimit limits size in bytes of frames of cproto connection, which are pending write, by maxPending (0 - unlimited):
+// frame, which exceeds it, fails the connection or, if blockTimeout is set, waits for the pending frames up to blockTimeout and then
+// fails its request with ErrCodeQueueFull. Each write to socket, which isn't completed in writeTimeout (0 - unlimited), fails the connection.
+// So server, which stops reading requests, doesn't make client buffer them without limit
+func WithWriteLimit(maxPending int, blockTimeout, writeTimeout time.Duration) interface{} {
+	return bindings.OptionWriteLimit{MaxPending: maxPending, BlockTimeout: blockTimeout, WriteTimeout: writeTimeout}
+}
+
+// WithDeadlineFloor sets min remaining timeout of cproto request: request with deadline, which has less time left, when it gets slot
+// of connection or when it's written to connection, fails with ErrCodeTimeout without sending. It's useless to send such requests
+// under load, because they're likely to time out on the server
+func WithDeadlineFloor(floor time.Duration) interface{} {
+	return bindings.OptionDeadlineFloor{Floor: floor}
+}
+
 func WithAppName(appName string) interface{} {
 	return bindings.OptionAppName{AppName: appName}
 }
+
+// WithActivityLabelFunc sets function, which derives activity label from the request context, if label is not set by CtxWithActivityLabel.
+// E.g. to pass OpenTelemetry trace ID:
+//
+//	reindexer.WithActivityLabelFunc(func(ctx context.Context) string {
+//		if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+//			return "trace=" + sc.TraceID().String()
+//		}
+//		return ""
+//	})
+func WithActivityLabelFunc(labelFunc func(ctx context.Context) string) interface{} {
+	return bindings.OptionActivityLabel{LabelFunc: labelFunc}
+}
+
+// CtxWithActivityLabel returns ctx with label, which is sent by cproto binding with each request made with this ctx.
+// Label is shown in 'client' field of '#activitystats' and in server's RPC log.
+// Non-printable characters and quotes are replaced with '_', label is truncated to bindings.MaxActivityLabelLen bytes
+func CtxWithActivityLabel(ctx context.Context, label string) context.Context {
+	return bindings.ContextWithActivityLabel(ctx, label)
+}
+
+// WithLogLevel sets max level of messages (ERROR, WARNING, INFO or TRACE), which are passed to logger. 0 - no messages
+func WithLogLevel(level int) interface{} {
+	return bindings.OptionLogLevel{Level: level}
+}
+
+// WithLogger sets logger on Open
+func WithLogger(log Logger) interface{} {
+	return optionLogger{log}
+}
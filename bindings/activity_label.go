@@ -0,0 +1,41 @@
+package bindings
+
+import (
+	"context"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxActivityLabelLen - max length of activity label in bytes. Longer labels are truncated
+const MaxActivityLabelLen = 128
+
+type activityLabelKey struct{}
+
+// ContextWithActivityLabel returns copy of ctx with sanitized activity label
+func ContextWithActivityLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, activityLabelKey{}, SanitizeActivityLabel(label))
+}
+
+// ActivityLabel returns activity label from ctx or empty string
+func ActivityLabel(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	label, _ := ctx.Value(activityLabelKey{}).(string)
+	return label
+}
+
+// SanitizeActivityLabel replaces non-printable characters and quotes with '_' and truncates label to MaxActivityLabelLen
+func SanitizeActivityLabel(label string) string {
+	res := make([]byte, 0, len(label))
+	for _, r := range label {
+		if r == utf8.RuneError || r == '"' || r == '\'' || r == '\\' || !unicode.IsPrint(r) {
+			r = '_'
+		}
+		if len(res)+utf8.RuneLen(r) > MaxActivityLabelLen {
+			break
+		}
+		res = append(res, string(r)...)
+	}
+	return string(res)
+}
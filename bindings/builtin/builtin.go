@@ -201,7 +201,20 @@ func (binding *Builtin) Clone() bindings.RawBinding {
 	return &Builtin{}
 }
 
+// ServerVersion returns version of linked reindexer library
+func (binding *Builtin) ServerVersion() string {
+	return bindings.ReindexerVersion
+}
+
+// ServerCapabilities returns capabilities of linked reindexer library
+func (binding *Builtin) ServerCapabilities() int64 {
+	return int64(C.kServerCapabilities)
+}
+
 func (binding *Builtin) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return err2go(C.reindexer_ping(binding.rx))
 }
 
@@ -410,6 +423,26 @@ func (binding *Builtin) GetMeta(ctx context.Context, namespace, key string) (bin
 	return ret2go(C.reindexer_get_meta(binding.rx, str2c(namespace), str2c(key), ctxInfo.cCtx))
 }
 
+func (binding *Builtin) GetSQLSuggestions(ctx context.Context, query string, pos int) ([]string, error) {
+	ctxInfo, err := binding.ctxWatcher.StartWatchOnCtx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer binding.ctxWatcher.StopWatchOnCtx(ctxInfo)
+
+	buf, err := ret2go(C.reindexer_get_sql_suggestions(binding.rx, str2c(query), C.int(pos), ctxInfo.cCtx))
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Free()
+	ser := cjson.NewSerializer(buf.GetBuf())
+	suggestions := make([]string, ser.GetVarUInt())
+	for i := range suggestions {
+		suggestions[i] = ser.GetVString()
+	}
+	return suggestions, nil
+}
+
 func (binding *Builtin) Select(ctx context.Context, query string, asJson bool, ptVersions []int32, fetchCount int) (bindings.RawBuffer, error) {
 	if withLimiter, err := binding.awaitLimiter(ctx); err != nil {
 		return nil, err
@@ -259,6 +259,18 @@ func (server *BuiltinServer) Status(ctx context.Context) (status bindings.Status
 	return server.builtin.Status(ctx)
 }
 
+func (server *BuiltinServer) ServerVersion() string {
+	return bindings.ReindexerVersion
+}
+
+func (server *BuiltinServer) ServerCapabilities() int64 {
+	return server.builtin.(bindings.RawBindingCapabilities).ServerCapabilities()
+}
+
+func (server *BuiltinServer) GetSQLSuggestions(ctx context.Context, query string, pos int) ([]string, error) {
+	return server.builtin.(bindings.RawBindingSQLSuggestions).GetSQLSuggestions(ctx, query, pos)
+}
+
 func (server *BuiltinServer) Ping(ctx context.Context) error {
 	return server.builtin.Ping(ctx)
 }
@@ -0,0 +1,19 @@
+package bindings
+
+import "context"
+
+type bulkConnKey struct{}
+
+// ContextWithBulkConn returns copy of ctx, which routes select query and fetches of its results to the bulk connections of OptionBulkConns
+func ContextWithBulkConn(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bulkConnKey{}, true)
+}
+
+// BulkConn returns true, if ctx routes query to the bulk connections
+func BulkConn(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	bulk, _ := ctx.Value(bulkConnKey{}).(bool)
+	return bulk
+}
@@ -0,0 +1,29 @@
+package bindings
+
+import "context"
+
+// Compression modes of cproto requests. Server compresses reply only if request was compressed
+const (
+	// CompressionDefault - request is compressed according to OptionCompression
+	CompressionDefault = iota
+	// CompressionDisabled - request is sent uncompressed
+	CompressionDisabled
+	// CompressionForced - request is compressed regardless of its size, if server supports compression
+	CompressionForced
+)
+
+type compressionKey struct{}
+
+// ContextWithCompression returns copy of ctx with compression mode of requests
+func ContextWithCompression(ctx context.Context, mode int) context.Context {
+	return context.WithValue(ctx, compressionKey{}, mode)
+}
+
+// Compression returns compression mode from ctx or CompressionDefault
+func Compression(ctx context.Context) int {
+	if ctx == nil {
+		return CompressionDefault
+	}
+	mode, _ := ctx.Value(compressionKey{}).(int)
+	return mode
+}
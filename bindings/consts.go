@@ -78,6 +78,10 @@ const (
 	QueryUpdateObject      = 22
 	QueryWithRank          = 23
 
+	// Query items of this fork start from 0x100, so they don't clash with items of upstream reindexer
+	QueryDryRun       = 0x100
+	QueryRequireState = 0x101
+
 	LeftJoin    = 0
 	InnerJoin   = 1
 	OrInnerJoin = 2
@@ -102,6 +106,8 @@ const (
 	QueryResultEnd         = 0
 	QueryResultAggregation = 1
 	QueryResultExplain     = 2
+	// Result items of this fork start from 0x100, so they don't clash with items of upstream reindexer
+	QueryResultNsState = 0x100
 
 	ResultsFormatMask = 0xF
 	ResultsPure       = 0x0
@@ -114,6 +120,13 @@ const (
 	ResultsWithPercents     = 0x40
 	ResultsWithNsID         = 0x80
 	ResultsWithJoined       = 0x100
+	// Result flags of this fork start from 0x10000, so they don't clash with flags of upstream reindexer
+	ResultsWithChecksum = 0x10000
+
+	ServerCapDryRun         = 1 << 0
+	ServerCapStateToken     = 1 << 1
+	ServerCapResultChecksum = 1 << 2
+	ServerCapCancelRequest  = 1 << 3
 
 	IndexOptPK         = 1 << 7
 	IndexOptArray      = 1 << 6
@@ -145,7 +158,18 @@ const (
 	ErrNetwork          = 12
 	ErrNotFound         = 13
 	ErrStateInvalidated = 14
+	ErrBadTransaction   = 15
+	ErrOutdatedWAL      = 16
+	ErrNoWAL            = 17
+	ErrDataHashMismatch = 18
 	ErrTimeout          = 19
 	ErrCanceled         = 20
 	ErrTagsMissmatch    = 21
+	ErrReplParams       = 22
+	ErrNsInvalidated    = 23
+	ErrStateMismatch    = 24
+
+	// Codes of client side errors
+	ErrConnBrokenMidRequest = 100
+	ErrQueueFull            = 101
 )
@@ -0,0 +1,178 @@
+package cproto
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+const defaultCircuitOpenDuration = 5 * time.Second
+
+// circuitBreakers - circuit breakers of hosts of server (see OptionCircuitBreaker). Disabled, if FailureThreshold is not set
+type circuitBreakers struct {
+	opts  bindings.OptionCircuitBreaker
+	lock  sync.Mutex
+	hosts map[string]*circuitBreaker
+}
+
+type circuitBreaker struct {
+	state    string
+	failures int
+	openedAt time.Time
+	// probes of half-open breaker in flight
+	probes int
+}
+
+func (cb *circuitBreakers) init(opts bindings.OptionCircuitBreaker) {
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = defaultCircuitOpenDuration
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+	cb.opts = opts
+	cb.hosts = make(map[string]*circuitBreaker)
+}
+
+func (cb *circuitBreakers) enabled() bool {
+	return cb.opts.FailureThreshold > 0
+}
+
+// allow returns ErrCircuitOpen, if request to host must not be sent. probe is true for request of half-open breaker
+func (cb *circuitBreakers) allow(host string) (probe bool, err error) {
+	if !cb.enabled() {
+		return false, nil
+	}
+	var ev *bindings.CircuitBreakerEvent
+	cb.lock.Lock()
+	b := cb.get(host)
+	if b.state == bindings.CircuitOpen {
+		if wait := time.Until(b.openedAt.Add(cb.opts.OpenDuration)); wait > 0 {
+			cb.lock.Unlock()
+			return false, &bindings.ErrCircuitOpen{Host: host, RetryAfter: wait}
+		}
+		ev = cb.setState(host, b, bindings.CircuitHalfOpen, nil)
+	}
+	if b.state == bindings.CircuitHalfOpen {
+		if b.probes >= cb.opts.HalfOpenProbes {
+			err = &bindings.ErrCircuitOpen{Host: host}
+		} else {
+			b.probes++
+			probe = true
+		}
+	}
+	cb.lock.Unlock()
+	cb.notify(ev)
+	return probe, err
+}
+
+// done reports result of request to host, which was allowed by allow
+func (cb *circuitBreakers) done(host string, probe bool, err error) {
+	if !cb.enabled() {
+		return
+	}
+	failed := isCircuitFailure(err)
+	var ev *bindings.CircuitBreakerEvent
+	cb.lock.Lock()
+	b := cb.get(host)
+	switch {
+	case probe:
+		b.probes--
+		if b.state != bindings.CircuitHalfOpen {
+			break
+		}
+		if failed {
+			ev = cb.setState(host, b, bindings.CircuitOpen, err)
+		} else {
+			ev = cb.setState(host, b, bindings.CircuitClosed, nil)
+		}
+	case b.state != bindings.CircuitClosed:
+		// Results of requests, which were sent before breaker was open, are not probes
+	case !failed:
+		b.failures = 0
+	default:
+		if b.failures++; b.failures >= cb.opts.FailureThreshold {
+			ev = cb.setState(host, b, bindings.CircuitOpen, err)
+		}
+	}
+	cb.lock.Unlock()
+	cb.notify(ev)
+}
+
+func (cb *circuitBreakers) get(host string) *circuitBreaker {
+	b, ok := cb.hosts[host]
+	if !ok {
+		b = &circuitBreaker{state: bindings.CircuitClosed}
+		cb.hosts[host] = b
+	}
+	return b
+}
+
+// setState changes state of breaker and returns event of transition. Must be called under lock
+func (cb *circuitBreakers) setState(host string, b *circuitBreaker, state string, err error) *bindings.CircuitBreakerEvent {
+	ev := &bindings.CircuitBreakerEvent{Host: host, From: b.state, To: state, Err: err}
+	b.state = state
+	switch state {
+	case bindings.CircuitOpen:
+		b.openedAt = time.Now()
+	case bindings.CircuitClosed:
+		b.failures = 0
+	}
+	return ev
+}
+
+func (cb *circuitBreakers) notify(ev *bindings.CircuitBreakerEvent) {
+	if ev == nil {
+		return
+	}
+	if logger != nil {
+		logger.Printf(3, "rq: circuit breaker of host '%s' is %s (was %s), last error: %v\n", ev.Host, ev.To, ev.From, ev.Err)
+	}
+	if cb.opts.OnStateChange != nil {
+		cb.opts.OnStateChange(*ev)
+	}
+}
+
+// status returns state of breakers sorted by host
+func (cb *circuitBreakers) status() []bindings.CircuitBreakerStatus {
+	if !cb.enabled() {
+		return nil
+	}
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	res := make([]bindings.CircuitBreakerStatus, 0, len(cb.hosts))
+	for host, b := range cb.hosts {
+		res = append(res, bindings.CircuitBreakerStatus{Host: host, State: b.state, Failures: b.failures})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Host < res[j].Host })
+	return res
+}
+
+// isCircuitFailure returns true for errors, which mean that server is unavailable: network errors and timeouts
+func isCircuitFailure(err error) bool {
+	switch err.(type) {
+	case nil:
+		return false
+	case net.Error, *net.OpError:
+		return true
+	}
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	if rerr, ok := err.(bindings.Error); ok {
+		return rerr.Code() == bindings.ErrNetwork || rerr.Code() == bindings.ErrTimeout
+	}
+	return false
+}
+
+// activeHost returns host of active DSN, which breaker guards requests
+func (binding *NetCProto) activeHost() string {
+	binding.lock.RLock()
+	defer binding.lock.RUnlock()
+	_, addr, _ := dsnAddr(binding.getActiveDSN())
+	return addr
+}
@@ -0,0 +1,74 @@
+package cproto
+
+import (
+	"context"
+	"sync"
+)
+
+// bulkPool - connections, reserved for bulk queries (see OptionBulkConns), so fetches of their results don't delay short requests of the main pool.
+// Connections are connected lazily, when all the existing ones are busy, up to size
+type bulkPool struct {
+	size  int
+	lock  sync.Mutex
+	conns []*connection
+	// connVersion of DSN, which conns are connected to
+	connVersion int
+}
+
+// getAll returns copy of connections of the pool
+func (p *bulkPool) getAll() []*connection {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([]*connection(nil), p.conns...)
+}
+
+// getBulkConn returns the least loaded bulk connection or connects the new one
+func (binding *NetCProto) getBulkConn(ctx context.Context) (*connection, error) {
+	if err := binding.awaitConnected(ctx); err != nil {
+		return nil, err
+	}
+	binding.lock.RLock()
+	connVersion, dsn := binding.dsn.connVersion, binding.getActiveDSN()
+	binding.lock.RUnlock()
+
+	p := &binding.bulk
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.connVersion != connVersion {
+		// Main pool is reconnected to another DSN, so bulk connections follow it
+		for _, conn := range p.conns {
+			go conn.Finalize()
+		}
+		p.conns, p.connVersion = nil, connVersion
+	}
+	var best *connection
+	conns := p.conns[:0]
+	for _, conn := range p.conns {
+		if !conn.isUsable() {
+			go conn.Finalize()
+			continue
+		}
+		conns = append(conns, conn)
+		if best == nil || len(conn.seqs) > len(best.seqs) {
+			best = conn
+		}
+	}
+	p.conns = conns
+	if len(p.conns) >= p.size || (best != nil && len(best.seqs) == cap(best.seqs)) {
+		return best, nil
+	}
+
+	conn, err := newConnection(ctx, binding, dsn)
+	if err != nil {
+		conn.Finalize()
+		if best != nil {
+			return best, nil
+		}
+		return nil, err
+	}
+	p.conns = append(p.conns, conn)
+	if conn.isServerChanged && binding.onChangeCallback != nil {
+		binding.onChangeCallback()
+	}
+	return conn, nil
+}
@@ -0,0 +1,43 @@
+package cproto
+
+import (
+	"context"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+type connectFunc func(ctx context.Context, binding bindings.RawBinding) error
+
+type pinnedConnKey struct{}
+
+// pinConn returns ctx, requests of binding with which are sent via c regardless of its state in the pool
+func pinConn(ctx context.Context, c *connection) context.Context {
+	return context.WithValue(ctx, pinnedConnKey{}, c)
+}
+
+// pinnedConn returns connection of binding, which is pinned to ctx by pinConn, or nil
+func (binding *NetCProto) pinnedConn(ctx context.Context) *connection {
+	if c, ok := ctx.Value(pinnedConnKey{}).(*connection); ok && c.owner == binding {
+		return c
+	}
+	return nil
+}
+
+func (binding *NetCProto) OnConnectCallback(f func(ctx context.Context, binding bindings.RawBinding) error) {
+	binding.connectCallback.Store(connectFunc(f))
+}
+
+// onConnected calls connect callback and connect hook of logged in connection c. Connection may be not in the pool yet and lock of binding
+// may be held, so their requests are sent via c only
+func (binding *NetCProto) onConnected(ctx context.Context, c *connection) error {
+	ctx = pinConn(ctx, c)
+	if f, _ := binding.connectCallback.Load().(connectFunc); f != nil {
+		if err := f(ctx, binding); err != nil {
+			return err
+		}
+	}
+	if binding.connectHook.OnConnect != nil {
+		return binding.connectHook.OnConnect(ctx, binding)
+	}
+	return nil
+}
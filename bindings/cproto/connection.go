@@ -2,12 +2,15 @@ package cproto
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
+	"net/url"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,8 +27,16 @@ type bufPtr struct {
 type sig chan bufPtr
 
 const bufsCap = 16 * 1024
-const queueSize = 512
-const maxSeqNum = queueSize * 1000000
+const wrBatchSize = 1024
+
+// defQueueSize - default count of request slots of connection (see bindings.OptionConnQueueSize)
+const defQueueSize = 512
+const maxQueueSize = 4096
+
+// Seq nums of connection are cycled in range [0, queueSize*seqNumCycles), so slot of request is seq % queueSize.
+// Seq nums of any queue size are less than maxSeqNum, so it marks free slot
+const seqNumCycles = 1000000
+const maxSeqNum = maxQueueSize * seqNumCycles
 
 const cprotoMagic = 0xEEDD1132
 const cprotoVersion = 0x103
@@ -36,7 +47,28 @@ const cprotoVersionCompressionFlag = 1 << 10
 const cprotoVersionMask = 0x3FF
 
 const cprotoHdrLen = 16
-const deadlineCheckPeriodSec = 1
+const cprotoMaxReplySize = 0x7FFFFFFF
+
+// deadlineCheckPeriod - accuracy of timeouts of async requests
+const deadlineCheckPeriod = 10 * time.Millisecond
+const finalizeCheckPeriod = 10 * time.Millisecond
+
+var errConnClosed = bindings.NewError("rq: Connection is closed", bindings.ErrNetwork)
+
+// errPingTimeout - connection is failed, because it doesn't reply to keepalive ping (see bindings.OptionPingInterval)
+var errPingTimeout = bindings.NewError("rq: Keepalive ping of connection is not replied", bindings.ErrNetwork)
+
+// errDeadlineFloor - request is not sent, because its remaining timeout is less than deadline floor (see bindings.OptionDeadlineFloor)
+var errDeadlineFloor = bindings.NewError("rq: Remaining timeout of request is less than deadline floor", bindings.ErrTimeout)
+
+// errQueueFull - request is not sent, because it didn't get free request slot in max queue wait (see bindings.OptionQueueFullError)
+var errQueueFull = bindings.NewError("rq: Request queue is full", bindings.ErrQueueFull)
+
+// errWriteLimit - pending writes of connection exceed limit, so connection is failed (see bindings.OptionWriteLimit)
+var errWriteLimit = bindings.NewError("rq: Pending writes of connection exceed limit, server doesn't read requests", bindings.ErrNetwork)
+
+// errWriteBlocked - request is not sent, because pending writes of connection exceed limit during block timeout
+var errWriteBlocked = bindings.NewError("rq: Pending writes of connection exceed limit", bindings.ErrQueueFull)
 
 const (
 	cmdPing              = 0
@@ -70,24 +102,78 @@ const (
 	cmdGetMeta           = 64
 	cmdPutMeta           = 65
 	cmdEnumMeta          = 66
+	cmdGetSQLSuggestions = 92
+	cmdCancelRequest     = 93
 	cmdCodeMax           = 128
 )
 
+// Commands of this fork start from 0x100, so they don't clash with commands of upstream reindexer
+const (
+	// Notice of server, which is going to close connection: new requests must be sent to other connections
+	cmdShutdownNotice = 0x100
+)
+
+// trafficStats counts sizes of requests and replies bodies
+type trafficStats struct {
+	uncompressed  int64
+	compressedSrc int64
+	compressed    int64
+	// replies, which were discarded, because there were no requests waiting for them, and replies with unknown seq numbers
+	staleReplies   int64
+	unknownReplies int64
+	// server side results, which may be leaked, because their close failed on alive connection
+	leakedResults int64
+	// requests, which were sent again after connection loss
+	requeuedRequests int64
+	// hedges of selects, which were sent, hedges, which replied first, and losing requests, which were canceled or which replies were dropped
+	hedgedRequests int64
+	hedgeWins      int64
+	hedgeWasted    int64
+	// requests, which failed with errQueueFull
+	queueFullRequests int64
+}
+
+func (s *trafficStats) add(srcSize, size int, compressed bool) {
+	if compressed {
+		atomic.AddInt64(&s.compressedSrc, int64(srcSize))
+		atomic.AddInt64(&s.compressed, int64(size))
+	} else {
+		atomic.AddInt64(&s.uncompressed, int64(size))
+	}
+}
+
 type requestInfo struct {
-	seqNum   uint32
-	repl     sig
-	deadline uint32
-	isAsync  int32
-	cmpl     bindings.RawCompletion
-	cmplLock sync.Mutex
+	seqNum uint32
+	repl   sig
+	// deadline of async request in milliseconds since start of connection (0 - no deadline)
+	deadline     int64
+	isAsync      int32
+	maxReplySize int64
+	cmpl         bindings.RawCompletion
+	cmplLock     sync.Mutex
+	// cmd and request timeout of async request, which are reported on its timeout (0 - deadline is set by context)
+	cmd        int
+	reqTimeout time.Duration
+}
+
+// completeAsync releases slot of async request, which completion is taken by caller. It's called under cmplLock, so
+// completion is called exactly once: by reply, by deadline or by connection error
+func (r *requestInfo) completeAsync() {
+	r.cmpl = nil
+	atomic.StoreInt64(&r.deadline, 0)
+	atomic.StoreUint32(&r.seqNum, maxSeqNum)
+	atomic.StoreInt32(&r.isAsync, 0)
 }
 
 type connection struct {
 	owner *NetCProto
 	conn  net.Conn
 
-	wrBuf, wrBuf2 *bytes.Buffer
-	wrKick        chan struct{}
+	wrCh chan *rpcEncoder
+	// size of frames, which are queued to write or are being written, if it's limited (accessed atomically),
+	// and signal of writeLoop to frames, which wait for free space
+	pendingWrites int64
+	wrSpace       chan struct{}
 
 	rdBuf *bufio.Reader
 
@@ -97,39 +183,60 @@ type connection struct {
 	err   error
 	errCh chan struct{}
 
+	// lastReadStamp - time of the last reply in nanoseconds. lastPingStamp - time of the last successful keepalive ping,
+	// pingSentStamp - time, when keepalive ping was sent (0 - no ping in flight). Accessed atomically
 	lastReadStamp int64
+	lastPingStamp int64
+	pingSentStamp int64
 
-	now    uint32
+	// start - monotonic time of connection start, which deadlines of async requests are counted from
+	start  time.Time
 	termCh chan struct{}
 
-	requests        [queueSize]requestInfo
+	requests        []requestInfo
+	queueSize       uint32
+	seqNumLimit     uint32
 	enableSnappy    int32
+	snappySupported int32
 	isServerChanged bool
+
+	// dsn, which connection is bound to, and address of its server
+	dsn  *url.URL
+	addr string
+	// serverID - ID of connection on server, which is returned by login (-1 - unknown)
+	serverID int64
+	// connection is logged in, is closed by server shutdown and its disconnect is reported. Accessed atomically
+	established int32
+	shutdown    int32
+	reported    int32
+
+	// late and unknown replies, which were discarded by connection. Accessed atomically
+	staleReplies   int64
+	unknownReplies int64
+	discarded      discardedReplies
+	stats          connStats
 }
 
-func newConnection(ctx context.Context, owner *NetCProto) (c *connection, err error) {
+// newConnection connects to server of dsn and logs in
+func newConnection(ctx context.Context, owner *NetCProto, dsn *url.URL) (c *connection, err error) {
 	c = &connection{
-		owner:  owner,
-		wrBuf:  bytes.NewBuffer(make([]byte, 0, bufsCap)),
-		wrBuf2: bytes.NewBuffer(make([]byte, 0, bufsCap)),
-		wrKick: make(chan struct{}, 1),
-		seqs:   make(chan uint32, queueSize),
-		errCh:  make(chan struct{}),
-		termCh: make(chan struct{}),
-	}
-	for i := 0; i < queueSize; i++ {
-		c.seqs <- uint32(i)
-		c.requests[i].repl = make(sig)
+		owner:    owner,
+		dsn:      dsn,
+		errCh:    make(chan struct{}),
+		termCh:   make(chan struct{}),
+		serverID: -1,
+		start:    time.Now(),
 	}
+	c.initRequests(owner.queueSize)
 
 	go c.deadlineTicker()
 
-	intCtx, cancel := applyTimeout(ctx, uint32(owner.timeouts.LoginTimeout/time.Second))
+	intCtx, cancel := applyTimeout(ctx, owner.tuned().timeouts.LoginTimeout)
 	if cancel != nil {
 		defer cancel()
 	}
 
-	if err = c.connect(intCtx); err != nil {
+	if err = c.connect(intCtx, dsn); err != nil {
 		c.onError(err)
 		return
 	}
@@ -138,95 +245,182 @@ func newConnection(ctx context.Context, owner *NetCProto) (c *connection, err er
 		c.onError(err)
 		return
 	}
+	if err = owner.onConnected(intCtx, c); err != nil {
+		c.onError(err)
+		return
+	}
+	atomic.StoreInt32(&c.established, 1)
+	if owner.pingInterval.Interval > 0 {
+		go c.keepalive(owner.pingInterval)
+	}
 	return
 }
 
-func seqNumIsValid(seqNum uint32) bool {
-	if seqNum < maxSeqNum {
+// initRequests makes queueSize request slots and write queue of the same size
+func (c *connection) initRequests(queueSize int) {
+	if queueSize <= 0 {
+		queueSize = defQueueSize
+	}
+	c.queueSize, c.seqNumLimit = uint32(queueSize), uint32(queueSize)*seqNumCycles
+	c.wrCh = make(chan *rpcEncoder, queueSize)
+	c.wrSpace = make(chan struct{}, 1)
+	c.seqs = make(chan uint32, queueSize)
+	c.requests = make([]requestInfo, queueSize)
+	for i := 0; i < queueSize; i++ {
+		c.seqs <- uint32(i)
+		c.requests[i].repl = make(sig, 1)
+	}
+}
+
+func (c *connection) seqNumIsValid(seqNum uint32) bool {
+	if seqNum < c.seqNumLimit {
 		return true
 	}
 	return false
 }
 
+// sinceStart returns milliseconds since start of connection
+func (c *connection) sinceStart() int64 {
+	return int64(time.Since(c.start) / time.Millisecond)
+}
+
 func (c *connection) deadlineTicker() {
-	timeout := time.Second * time.Duration(deadlineCheckPeriodSec)
-	ticker := time.NewTicker(timeout)
-	atomic.StoreUint32(&c.now, 0)
-	for range ticker.C {
+	ticker := time.NewTicker(deadlineCheckPeriod)
+	defer ticker.Stop()
+	for {
 		select {
 		case <-c.errCh:
 			return
 		case <-c.termCh:
 			return
-		default:
+		case <-ticker.C:
 		}
-		now := atomic.AddUint32(&c.now, deadlineCheckPeriodSec)
+		now := c.sinceStart()
 		for i := range c.requests {
 			seqNum := atomic.LoadUint32(&c.requests[i].seqNum)
-			if !seqNumIsValid(seqNum) {
+			if !c.seqNumIsValid(seqNum) {
 				continue
 			}
-			deadline := atomic.LoadUint32(&c.requests[i].deadline)
-			if deadline != 0 && now >= deadline && atomic.CompareAndSwapUint32(&c.requests[i].deadline, deadline, 0) {
-				if atomic.LoadInt32(&c.requests[i].isAsync) != 0 {
-					c.requests[i].cmplLock.Lock()
-					if c.requests[i].cmpl != nil && deadline == atomic.LoadUint32(&c.requests[i].deadline) {
-						cmpl := c.requests[i].cmpl
-						c.requests[i].cmpl = nil
-						seqNum = atomic.LoadUint32(&c.requests[i].seqNum)
-						atomic.StoreUint32(&c.requests[i].seqNum, maxSeqNum)
-						atomic.StoreInt32(&c.requests[i].isAsync, 0)
-						c.requests[i].cmplLock.Unlock()
-						c.seqs <- nextSeqNum(seqNum)
-						cmpl(nil, context.DeadlineExceeded)
-					} else {
-						c.requests[i].cmplLock.Unlock()
-					}
-				}
+			if deadline := atomic.LoadInt64(&c.requests[i].deadline); deadline == 0 || now < deadline || atomic.LoadInt32(&c.requests[i].isAsync) == 0 {
+				continue
+			}
+			// Slot may be completed and taken by the next request after the loads above, so the deadline is checked again under
+			// the lock together with seq num. Request, which is completed by reply or by connection error, doesn't fire
+			c.requests[i].cmplLock.Lock()
+			deadline := atomic.LoadInt64(&c.requests[i].deadline)
+			if c.requests[i].cmpl == nil || seqNum != atomic.LoadUint32(&c.requests[i].seqNum) || deadline == 0 || now < deadline {
+				c.requests[i].cmplLock.Unlock()
+				continue
 			}
+			cmpl, err := c.requests[i].cmpl, error(context.DeadlineExceeded)
+			if c.requests[i].reqTimeout != 0 {
+				err = requestTimeoutError(c.requests[i].cmd, c.requests[i].reqTimeout)
+			}
+			c.requests[i].completeAsync()
+			c.requests[i].cmplLock.Unlock()
+			c.seqs <- c.nextSeqNum(seqNum)
+			c.complete(cmpl, nil, err)
 		}
 	}
 }
 
-func (c *connection) connect(ctx context.Context) (err error) {
-	var d net.Dialer
-	c.conn, err = d.DialContext(ctx, "tcp", c.owner.getActiveDSN().Host)
+func (c *connection) connect(ctx context.Context, dsn *url.URL) (err error) {
+	var network string
+	network, c.addr, _ = dsnAddr(dsn)
+	c.conn, err = c.owner.dial(ctx, network, c.addr)
 	if err != nil {
 		return err
 	}
-	c.conn.(*net.TCPConn).SetNoDelay(true)
-	c.rdBuf = bufio.NewReaderSize(c.conn, bufsCap)
+	if err = c.owner.tuneTCP(c.conn); err != nil {
+		c.conn.Close()
+		return err
+	}
+	if c.owner.tlsConfig != nil {
+		if c.conn, err = c.handshakeTLS(ctx, c.conn); err != nil {
+			return err
+		}
+	}
+	c.rdBuf = bufio.NewReaderSize(c.conn, c.owner.readBufSize())
 
 	go c.writeLoop()
 	go c.readLoop()
 	return
 }
 
+// dial connects to server by dialer of bindings.OptionDialer or by net.Dialer
+func (binding *NetCProto) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if binding.dialContext != nil {
+		return binding.dialContext(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+// handshakeTLS wraps conn to TLS client connection. Handshake is bounded by ctx
+func (c *connection) handshakeTLS(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	cfg := c.owner.tlsConfig
+	if len(cfg.ServerName) == 0 && !cfg.InsecureSkipVerify {
+		cfg = cfg.Clone()
+		cfg.ServerName = c.dsn.Hostname()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// dsnAddr returns network and address of server and database name of DSN. DSN with unixScheme is
+// ucproto://<path of socket>:/<database name>, e.g. ucproto:///var/run/reindexer.sock:/db
+func dsnAddr(dsn *url.URL) (network, addr, db string) {
+	network, addr, db = "tcp", dsn.Host, dsn.Path
+	if dsn.Scheme == unixScheme {
+		network, addr, db = "unix", dsn.Path, ""
+		if i := strings.LastIndex(dsn.Path, ":/"); i >= 0 {
+			addr, db = dsn.Path[:i], dsn.Path[i+1:]
+		}
+	}
+	if len(db) > 0 && db[0] == '/' {
+		db = db[1:]
+	}
+	return network, addr, db
+}
+
 func (c *connection) login(ctx context.Context, owner *NetCProto) (err error) {
-	dsn := owner.getActiveDSN()
-	password, username, path := "", "", dsn.Path
+	dsn := c.dsn
+	password, username := "", ""
+	_, _, path := dsnAddr(dsn)
 	if dsn.User != nil {
 		username = dsn.User.Username()
 		password, _ = dsn.User.Password()
 	}
-	if len(path) > 0 && path[0] == '/' {
-		path = path[1:]
-	}
 
 	buf, err := c.rpcCall(ctx, cmdLogin, 0, username, password, path, c.owner.connectOpts.CreateDBIfMissing, false, -1, bindings.ReindexerVersion, c.owner.appName)
 	if err != nil {
-		c.err = err
 		return
 	}
 	defer buf.Free()
 
-	if len(buf.args) > 1 {
-		serverStartTS := buf.args[1].(int64)
+	if version, err := buf.ArgString(0); err == nil {
+		owner.serverVersion.Store(version)
+	}
+	if buf.ArgsCount() > 1 {
+		serverStartTS, err := buf.ArgInt(1)
+		if err != nil {
+			return err
+		}
 		old := atomic.SwapInt64(&owner.serverStartTime, serverStartTS)
 		if old != 0 && old != serverStartTS {
 			c.isServerChanged = true
 		}
 	}
+	if caps, err := buf.ArgInt(2); err == nil {
+		atomic.StoreInt64(&owner.serverCaps, caps)
+	}
+	if id, err := buf.ArgInt(3); err == nil {
+		c.serverID = id
+	}
 	return
 }
 
@@ -238,7 +432,7 @@ func (c *connection) readLoop() {
 			c.onError(err)
 			return
 		}
-		atomic.StoreInt64(&c.lastReadStamp, time.Now().Unix())
+		atomic.StoreInt64(&c.lastReadStamp, time.Now().UnixNano())
 	}
 }
 
@@ -254,10 +448,16 @@ func (c *connection) readReply(hdr []byte) (err error) {
 	}
 
 	version := ser.GetUInt16()
-	_ = int(ser.GetUInt16())
-	size := int(ser.GetUInt32())
+	cmd := int(ser.GetUInt16())
+	rsize := ser.GetUInt32()
 	rseq := uint32(ser.GetUInt32())
 
+	if rsize > cprotoMaxReplySize {
+		return fmt.Errorf("Invalid cproto reply size %d", rsize)
+	}
+	size := int(rsize)
+	atomic.AddInt64(&c.stats.bytesRead, int64(cprotoHdrLen+size))
+
 	compressed := (version & cprotoVersionCompressionFlag) != 0
 	version &= cprotoVersionMask
 
@@ -265,99 +465,440 @@ func (c *connection) readReply(hdr []byte) (err error) {
 		return fmt.Errorf("Unsupported cproto version '%04X'. This client expects reindexer server v1.9.8+", version)
 	}
 
-	if c.owner.compression.EnableCompression && version >= cprotoMinSnappyVersion {
-		enableSnappy := int32(1)
-		atomic.StoreInt32(&c.enableSnappy, enableSnappy)
+	if version >= cprotoMinSnappyVersion {
+		atomic.StoreInt32(&c.snappySupported, 1)
+		if c.owner.compression.EnableCompression {
+			atomic.StoreInt32(&c.enableSnappy, 1)
+		}
 	}
 
-	if !seqNumIsValid(rseq) {
-		return fmt.Errorf("invalid seq num: %d", rseq)
-	}
-	reqID := rseq % queueSize
-	if atomic.LoadUint32(&c.requests[reqID].seqNum) != rseq {
-		io.CopyN(ioutil.Discard, c.rdBuf, int64(size))
+	if cmd == cmdShutdownNotice {
+		if _, err = io.CopyN(ioutil.Discard, c.rdBuf, int64(size)); err != nil {
+			return
+		}
+		c.onShutdown(nil)
 		return
 	}
-	repCh := c.requests[reqID].repl
-	answ := newNetBuffer(size, c)
 
-	if _, err = io.ReadFull(c.rdBuf, answ.buf); err != nil {
-		return
+	if !c.seqNumIsValid(rseq) {
+		return c.discardReply(cmd, size, true)
+	}
+	reqID := rseq % c.queueSize
+	if atomic.LoadUint32(&c.requests[reqID].seqNum) != rseq {
+		// Duplicate or late reply: request is already completed
+		return c.discardReply(cmd, size, false)
 	}
+	var answ *NetBuffer
+	limit := atomic.LoadInt64(&c.requests[reqID].maxReplySize)
+	if limit > 0 && int64(size) > limit && !compressed {
+		answ = newNetBuffer(0, c)
+		answ.cmd, answ.size = cmd, size
+		answ.err = bindings.ErrReplyLimit
+		if err = c.skipOversizedReply(cmd, size, answ); err != nil {
+			return
+		}
+		c.owner.traffic.add(size, size, compressed)
+	} else {
+		answ = newNetBuffer(size, c)
+		answ.cmd, answ.size = cmd, size
+		if _, err = io.ReadFull(c.rdBuf, answ.buf); err != nil {
+			return
+		}
 
-	if compressed {
-		answ.decompress()
+		if compressed {
+			if err = answ.decompress(); err != nil {
+				return fmt.Errorf("Invalid compressed cproto reply: %v", err)
+			}
+		}
+		c.owner.traffic.add(len(answ.buf), size, compressed)
+		if limit > 0 && int64(len(answ.buf)) > limit {
+			// Compressed reply can't be skipped, because id of its server side results is known only after decompression
+			answ.reject(cmd, bindings.ErrReplyLimit)
+		}
 	}
 
+	c.deliverReply(rseq, answ)
+	return
+}
+
+// deliverReply passes reply to the request, which is waiting for it. It's called by read loop and for the requests,
+// which are rejected by write loop without sending
+func (c *connection) deliverReply(rseq uint32, answ *NetBuffer) {
+	reqID := rseq % c.queueSize
+	repCh := c.requests[reqID].repl
 	if atomic.LoadInt32(&c.requests[reqID].isAsync) != 0 {
 		c.requests[reqID].cmplLock.Lock()
 		if c.requests[reqID].cmpl != nil && atomic.LoadUint32(&c.requests[reqID].seqNum) == rseq {
 			cmpl := c.requests[reqID].cmpl
-			c.requests[reqID].cmpl = nil
-			atomic.StoreUint32(&c.requests[reqID].seqNum, maxSeqNum)
-			atomic.StoreInt32(&c.requests[reqID].isAsync, 0)
+			c.requests[reqID].completeAsync()
 			c.requests[reqID].cmplLock.Unlock()
-			c.seqs <- nextSeqNum(rseq)
-			cmpl(answ, answ.parseArgs())
+			c.seqs <- c.nextSeqNum(rseq)
+			c.complete(cmpl, answ, answ.parseArgs())
 		} else {
 			c.requests[reqID].cmplLock.Unlock()
+			c.freeStaleReply(answ)
+		}
+		return
+	}
+	// Reply channel may still hold the reply, which was not received by the completed request.
+	// Server doesn't reply to the rejected requests, so it's stale and is replaced
+	for {
+		select {
+		case repCh <- bufPtr{rseq, answ}:
+			return
+		case stale := <-repCh:
+			c.freeStaleReply(stale.buf)
+		}
+	}
+}
+
+// skipOversizedReply skips body of the reply, which exceeds size limit of its request, without reading results buffer into memory.
+// Only the tail arguments are read to close server side results of the select
+func (c *connection) skipOversizedReply(cmd int, size int, answ *NetBuffer) (err error) {
+	r := &replyReader{r: c.rdBuf, left: size}
+	defer func() {
+		if err == nil && r.left > 0 {
+			err = r.skip(r.left)
+		}
+	}()
+	var code, l, argsCount, t uint64
+	if code, err = binary.ReadUvarint(r); err != nil {
+		return
+	}
+	if l, err = binary.ReadUvarint(r); err != nil {
+		return
+	}
+	if err = r.skip(int(l)); err != nil || code != 0 {
+		return
+	}
+	if argsCount, err = binary.ReadUvarint(r); err != nil || argsCount == 0 {
+		return
+	}
+	if t, err = binary.ReadUvarint(r); err != nil || t != uint64(bindings.ValueString) {
+		return
+	}
+	if l, err = binary.ReadUvarint(r); err != nil {
+		return
+	}
+	if err = r.skip(int(l)); err != nil {
+		return
+	}
+
+	tail := make([]byte, r.left)
+	if _, err = io.ReadFull(r.r, tail); err != nil {
+		return
+	}
+	r.left = 0
+	if cmd == cmdSelect && argsCount > 1 {
+		dec := newRPCDecoder(tail)
+		if resultsID, ok := dec.intfArg().(int); ok {
+			answ.reqID = resultsID
 		}
-	} else if repCh != nil {
-		repCh <- bufPtr{rseq, answ}
-	} else {
-		return fmt.Errorf("unexpected answer: %v", answ)
 	}
 	return
 }
 
-func (c *connection) write(buf []byte) {
-	c.lock.Lock()
-	c.wrBuf.Write(buf)
-	c.lock.Unlock()
+// replyReader reads at most left bytes of the reply body
+type replyReader struct {
+	r    *bufio.Reader
+	left int
+}
+
+func (r *replyReader) ReadByte() (byte, error) {
+	if r.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	r.left--
+	return r.r.ReadByte()
+}
+
+func (r *replyReader) skip(n int) error {
+	if n > r.left {
+		return io.ErrUnexpectedEOF
+	}
+	r.left -= n
+	_, err := io.CopyN(ioutil.Discard, r.r, int64(n))
+	return err
+}
+
+func (c *connection) freeStaleReply(buf *NetBuffer) {
+	atomic.AddInt64(&c.staleReplies, 1)
+	atomic.AddInt64(&c.owner.traffic.staleReplies, 1)
+	buf.Free()
+}
+
+// write passes ownership of encoder to the write queue. Encoder is released after it's written to socket or connection is failed
+func (c *connection) write(enc *rpcEncoder) {
+	enc.queuedAt = time.Now()
+	if limit := c.owner.writeLimit.MaxPending; limit > 0 && !c.reservePending(enc, int64(limit)) {
+		return
+	}
+	select {
+	case c.wrCh <- enc:
+	case <-c.errCh:
+		enc.release()
+	}
+}
+
+// reservePending adds size of frame to pending writes. Frame, which exceeds limit, fails the connection or waits for written frames
+// up to block timeout. Urgent frames are not limited. Returns false, if frame is released without writing
+func (c *connection) reservePending(enc *rpcEncoder, limit int64) bool {
+	size := int64(len(enc.ser.Bytes()))
+	var timer *time.Timer
+	for {
+		pending := atomic.LoadInt64(&c.pendingWrites)
+		if pending == 0 || pending+size <= limit || enc.urgent {
+			if !atomic.CompareAndSwapInt64(&c.pendingWrites, pending, pending+size) {
+				continue
+			}
+			if pending+size < limit {
+				// Another waiting frame may fit too
+				c.signalWriteSpace()
+			}
+			return true
+		}
+		blockTimeout := c.owner.writeLimit.BlockTimeout
+		if blockTimeout <= 0 {
+			c.onError(errWriteLimit)
+			enc.release()
+			return false
+		}
+		if timer == nil {
+			timer = time.NewTimer(blockTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case <-c.wrSpace:
+		case <-timer.C:
+			c.rejectRequest(enc.seq, errWriteBlocked)
+			enc.release()
+			return false
+		case <-c.errCh:
+			enc.release()
+			return false
+		}
+	}
+}
+
+// releasePending subtracts size of written (or dropped) frames from pending writes
+func (c *connection) releasePending(size int) {
+	if c.owner.writeLimit.MaxPending > 0 {
+		atomic.AddInt64(&c.pendingWrites, -int64(size))
+		c.signalWriteSpace()
+	}
+}
+
+func (c *connection) signalWriteSpace() {
 	select {
-	case c.wrKick <- struct{}{}:
+	case c.wrSpace <- struct{}{}:
 	default:
 	}
 }
 
 func (c *connection) writeLoop() {
+	coalescing := c.owner.writeCoalescing
+	frames := make([]*rpcEncoder, 0, wrBatchSize)
+	bufs := make(net.Buffers, 0, wrBatchSize)
+	// WriteTo consumes buffers, so it's called on the copy of bufs
+	wrBufs := &net.Buffers{}
+	var timer *time.Timer
+	if coalescing.Delay > 0 {
+		timer = time.NewTimer(coalescing.Delay)
+		stopTimer(timer)
+	}
 	for {
 		select {
 		case <-c.errCh:
+			c.releaseQueued()
 			return
-		case <-c.wrKick:
+		case enc := <-c.wrCh:
+			frames = append(frames, enc)
 		}
-		c.lock.Lock()
-		if c.wrBuf.Len() == 0 {
-			err := c.err
-			c.lock.Unlock()
-			if err == nil {
-				continue
-			} else {
-				return
+		pending, urgent := len(frames[0].ser.Bytes()), frames[0].urgent
+	batch:
+		for len(frames) < cap(frames) {
+			select {
+			case enc := <-c.wrCh:
+				frames = append(frames, enc)
+				pending += len(enc.ser.Bytes())
+				urgent = urgent || enc.urgent
+			default:
+				break batch
 			}
 		}
-		c.wrBuf, c.wrBuf2 = c.wrBuf2, c.wrBuf
-		c.lock.Unlock()
 
-		if _, err := c.wrBuf2.WriteTo(c.conn); err != nil {
+		// Wait for subsequent frames to send them with single write
+		if timer != nil && !urgent {
+			timer.Reset(coalescing.Delay)
+		wait:
+			for len(frames) < cap(frames) && (coalescing.MaxPending <= 0 || pending < coalescing.MaxPending) {
+				select {
+				case enc := <-c.wrCh:
+					frames = append(frames, enc)
+					pending += len(enc.ser.Bytes())
+					if enc.urgent {
+						break wait
+					}
+				case <-timer.C:
+					break wait
+				case <-c.errCh:
+					stopTimer(timer)
+					for _, enc := range frames {
+						enc.release()
+					}
+					c.releaseQueued()
+					return
+				}
+			}
+			stopTimer(timer)
+		}
+
+		if frames = c.expireFrames(frames); len(frames) == 0 {
+			c.releasePending(pending)
+			continue
+		}
+		bufs = bufs[:0]
+		for _, enc := range frames {
+			bufs = append(bufs, enc.ser.Bytes())
+		}
+		*wrBufs = bufs
+		if timeout := c.owner.writeLimit.WriteTimeout; timeout > 0 {
+			// Stuck TCP window of server, which doesn't read, fails the connection with timeout error
+			c.conn.SetWriteDeadline(time.Now().Add(timeout))
+		}
+		written, err := writeBuffers(c.conn, wrBufs)
+		atomic.AddInt64(&c.stats.bytesWritten, written)
+		c.releasePending(pending)
+		for i, enc := range frames {
+			enc.release()
+			frames[i] = nil
+		}
+		frames = frames[:0]
+		if err != nil {
 			c.onError(err)
+			c.releaseQueued()
+			return
+		}
+	}
+}
+
+// expireFrames decreases exec timeouts of frames by time, which they were waiting in write queue, so server doesn't run requests
+// longer, than clients wait for them. Frames, which remaining timeout is less than deadline floor (or than 1ms), are not written:
+// their requests fail with errDeadlineFloor
+func (c *connection) expireFrames(frames []*rpcEncoder) []*rpcEncoder {
+	now := time.Now()
+	kept := frames[:0]
+	for _, enc := range frames {
+		if enc.writeWait != nil {
+			atomic.StoreInt64(enc.writeWait, int64(now.Sub(enc.queuedAt)))
+		}
+		if !enc.deadline.IsZero() {
+			remaining := enc.deadline.Sub(now)
+			if remaining < time.Millisecond || remaining < c.owner.tuned().deadlineFloor {
+				c.rejectRequest(enc.seq, errDeadlineFloor)
+				enc.release()
+				continue
+			}
+			enc.setExecTimeout(int64(remaining / time.Millisecond))
+		}
+		kept = append(kept, enc)
+	}
+	for i := len(kept); i < len(frames); i++ {
+		frames[i] = nil
+	}
+	return kept
+}
+
+// rejectRequest completes request, which frame is not written, with err
+func (c *connection) rejectRequest(seq uint32, err error) {
+	reqID := seq % c.queueSize
+	if c.requests[reqID].repl == nil || atomic.LoadUint32(&c.requests[reqID].seqNum) != seq {
+		return
+	}
+	answ := newNetBuffer(0, c)
+	answ.err = err
+	c.deliverReply(seq, answ)
+}
+
+// buffersWriter may be implemented by net.Conn wrappers, which need to intercept vectored writes
+type buffersWriter interface {
+	WriteBuffers(bufs *net.Buffers) (int64, error)
+}
+
+// writeBuffers writes bufs with single writev syscall, if it's supported by conn
+func writeBuffers(conn net.Conn, bufs *net.Buffers) (int64, error) {
+	if bw, ok := conn.(buffersWriter); ok {
+		return bw.WriteBuffers(bufs)
+	}
+	return bufs.WriteTo(conn)
+}
+
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// releaseQueued releases encoders, which were queued, but will not be written due to connection error
+func (c *connection) releaseQueued() {
+	for {
+		select {
+		case enc := <-c.wrCh:
+			enc.release()
+		default:
 			return
 		}
 	}
 }
 
-func nextSeqNum(seqNum uint32) uint32 {
-	seqNum += queueSize
-	if seqNum < maxSeqNum {
+// isUrgentCmd returns true for commands, which are written immediately regardless of write coalescing
+func isUrgentCmd(cmd int) bool {
+	switch cmd {
+	case cmdPing, cmdLogin, cmdCloseResults, cmdCommitTx, cmdRollbackTx, cmdCancelRequest:
+		return true
+	}
+	return false
+}
+
+// isCancelableCmd returns true for commands, which server stops by cmdCancelRequest
+func isCancelableCmd(cmd int) bool {
+	switch cmd {
+	case cmdSelect, cmdSelectSQL, cmdDeleteQuery, cmdUpdateQuery:
+		return true
+	}
+	return false
+}
+
+func (c *connection) nextSeqNum(seqNum uint32) uint32 {
+	seqNum += c.queueSize
+	if seqNum < c.seqNumLimit {
 		return seqNum
 	}
-	return seqNum - maxSeqNum
+	return seqNum - c.seqNumLimit
 }
 
-func (c *connection) packRPC(cmd int, seq uint32, execTimeout int, args ...interface{}) {
+// compression returns, whether request with ctx may be compressed, and min size of compressed request
+func (c *connection) compression(ctx context.Context) (enableSnappy bool, minSize int) {
+	switch bindings.Compression(ctx) {
+	case bindings.CompressionDisabled:
+		return false, 0
+	case bindings.CompressionForced:
+		return atomic.LoadInt32(&c.snappySupported) != 0, 0
+	}
+	return atomic.LoadInt32(&c.enableSnappy) != 0, c.owner.compression.Threshold
+}
 
-	in := newRPCEncoder(cmd, seq, atomic.LoadInt32(&c.enableSnappy) != 0)
+func (c *connection) packRPC(ctx context.Context, cmd int, seq uint32, execTimeout int, args ...interface{}) {
+	c.write(c.encodeRPC(ctx, cmd, seq, execTimeout, args...))
+}
+
+// encodeRPC encodes request frame. Exec timeout (ms) is decreased by time, which frame waits in write queue
+func (c *connection) encodeRPC(ctx context.Context, cmd int, seq uint32, execTimeout int, args ...interface{}) *rpcEncoder {
+	enableSnappy, minSize := c.compression(ctx)
+	in := newRPCEncoder(cmd, seq, enableSnappy)
 	for _, a := range args {
 		switch t := a.(type) {
 		case bool:
@@ -378,42 +919,119 @@ func (c *connection) packRPC(cmd int, seq uint32, execTimeout int, args ...inter
 	}
 
 	in.startArgsChunck()
-	in.int64Arg(int64(execTimeout))
+	var deadline time.Time
+	if execTimeout > 0 {
+		deadline = time.Now().Add(time.Duration(execTimeout) * time.Millisecond)
+	}
+	in.execTimeoutArg(int64(execTimeout), deadline)
+	if label := c.owner.activityLabel(ctx); label != "" {
+		in.stringArg(label)
+	}
 
-	c.write(in.bytes())
-	in.ser.Close()
+	srcSize := len(in.ser.Bytes()) - cprotoHdrLen
+	compressed := in.finish(minSize)
+	c.owner.traffic.add(srcSize, len(in.ser.Bytes())-cprotoHdrLen, compressed)
+	in.urgent = isUrgentCmd(cmd)
+	return in
 }
 
+// awaitSeqNum takes free request slot. Remaining timeout is calculated after the wait, so time spent in queue is not given to server.
+// Request, which remaining timeout is less than deadline floor, fails with errDeadlineFloor.
+// If binding fails on full queue, request, which doesn't get the slot in max queue wait, fails with errQueueFull
 func (c *connection) awaitSeqNum(ctx context.Context) (seq uint32, remainingTimeout int, err error) {
-	select {
-	case seq = <-c.seqs:
-		if err = ctx.Err(); err != nil {
-			c.seqs <- seq
-			return
+	var queueFull <-chan time.Time
+	if c.owner.failOnQueueFull {
+		select {
+		case seq = <-c.seqs:
+			return c.useSeqNum(ctx, seq)
+		default:
 		}
-		if execDeadline, ok := ctx.Deadline(); ok {
-			remainingTimeout = int(execDeadline.Sub(time.Now()) / time.Millisecond)
-			if remainingTimeout <= 0 {
-				c.seqs <- seq
-				err = context.DeadlineExceeded
-			}
+		if c.owner.queueFull.MaxQueueWait <= 0 {
+			atomic.AddInt64(&c.owner.traffic.queueFullRequests, 1)
+			return 0, 0, errQueueFull
 		}
+		timer := time.NewTimer(c.owner.queueFull.MaxQueueWait)
+		defer timer.Stop()
+		queueFull = timer.C
+	}
+	select {
+	case seq = <-c.seqs:
+		return c.useSeqNum(ctx, seq)
+	case <-queueFull:
+		atomic.AddInt64(&c.owner.traffic.queueFullRequests, 1)
+		err = errQueueFull
 	case <-ctx.Done():
 		err = ctx.Err()
+	case <-c.errCh:
+		err = c.curError()
 	}
 	return
 }
 
-func applyTimeout(ctx context.Context, timeout uint32) (context.Context, context.CancelFunc) {
-	if timeout == 0 {
+// useSeqNum checks context of request, which has taken request slot, and calculates its remaining timeout. Slot is released on error
+func (c *connection) useSeqNum(ctx context.Context, seq uint32) (_ uint32, remainingTimeout int, err error) {
+	if err = ctx.Err(); err != nil {
+		c.seqs <- seq
+		return
+	}
+	if execDeadline, ok := ctx.Deadline(); ok {
+		remainingTimeout = int(execDeadline.Sub(time.Now()) / time.Millisecond)
+		if remainingTimeout <= 0 {
+			c.seqs <- seq
+			err = context.DeadlineExceeded
+		} else if time.Duration(remainingTimeout)*time.Millisecond < c.owner.tuned().deadlineFloor {
+			c.seqs <- seq
+			err = errDeadlineFloor
+		}
+	}
+	return seq, remainingTimeout, err
+}
+
+func applyTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
 		return ctx, nil
 	}
-	return context.WithTimeout(ctx, time.Second*time.Duration(timeout))
+	return context.WithTimeout(ctx, timeout)
+}
+
+// requestTimeoutError - error of request, which isn't completed in request timeout of binding (see bindings.OptionTimeouts)
+func requestTimeoutError(cmd int, timeout time.Duration) error {
+	return bindings.NewError(fmt.Sprintf("rq: Request timeout %v of command %s is exceeded", timeout, cmdName(cmd)), bindings.ErrTimeout)
+}
+
+// isRequestTimeout returns true, if deadline of request, which is started at start, is set by request timeout instead of deadline of ctx
+func isRequestTimeout(ctx context.Context, start time.Time, netTimeout time.Duration) bool {
+	if netTimeout <= 0 {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || deadline.After(start.Add(netTimeout))
 }
 
-func (c *connection) rpcCallAsync(ctx context.Context, cmd int, netTimeout uint32, cmpl bindings.RawCompletion, args ...interface{}) {
+// timeoutError replaces context.DeadlineExceeded by requestTimeoutError, if deadline of request is set by request timeout
+func timeoutError(ctx context.Context, err error, cmd int, start time.Time, netTimeout time.Duration) error {
+	if err == context.DeadlineExceeded && isRequestTimeout(ctx, start, netTimeout) {
+		return requestTimeoutError(cmd, netTimeout)
+	}
+	return err
+}
+
+func (c *connection) rpcCallAsync(ctx context.Context, cmd int, netTimeout time.Duration, cmpl bindings.RawCompletion, args ...interface{}) {
+	atomic.AddInt64(&c.stats.requests, 1)
+	var requestSize int64
+	if span := c.startSpan(ctx, cmd); span.end != nil {
+		origCmpl := cmpl
+		cmpl = func(buf bindings.RawBuffer, err error) {
+			replySize := 0
+			if netBuf, ok := buf.(*NetBuffer); ok && netBuf != nil {
+				replySize = netBuf.size
+			}
+			span.finish(int(atomic.LoadInt64(&requestSize)), replySize, err)
+			origCmpl(buf, err)
+		}
+	}
 	if err := c.curError(); err != nil {
-		cmpl(nil, err)
+		c.complete(cmpl, nil, err)
 		return
 	}
 
@@ -422,42 +1040,137 @@ func (c *connection) rpcCallAsync(ctx context.Context, cmd int, netTimeout uint3
 		defer cancel()
 	}
 
+	start := time.Now()
 	seq, timeout, err := c.awaitSeqNum(intCtx)
+	queueWait := time.Since(start)
+	atomic.AddInt64(&c.stats.seqWait, int64(queueWait))
+	var writeWait *int64
+	if c.owner.tuned().slowRPC.Hook != nil {
+		origCmpl := cmpl
+		writeWait = new(int64)
+		cmpl = func(buf bindings.RawBuffer, err error) {
+			c.owner.onRPCDone(ctx, cmd, start, queueWait, time.Duration(atomic.LoadInt64(writeWait)), err)
+			origCmpl(buf, err)
+		}
+	}
+	// Time since start of connection, when frame was queued to write. Accessed atomically, because completion may be called by read loop
+	var sentAt int64
+	if requestLog := c.owner.tuned().requestLog.Hook; requestLog != nil {
+		origCmpl := cmpl
+		if writeWait == nil {
+			writeWait = new(int64)
+		}
+		cmpl = func(buf bindings.RawBuffer, err error) {
+			entry := bindings.RequestLogEntry{Cmd: cmd, QueueWait: queueWait, WriteWait: time.Duration(atomic.LoadInt64(writeWait)),
+				RequestSize: int(atomic.LoadInt64(&requestSize)), Err: err}
+			if entry.RoundTrip = c.sentSince(atomic.LoadInt64(&sentAt)); entry.RoundTrip != 0 {
+				entry.Seq = seq
+			}
+			if netBuf, ok := buf.(*NetBuffer); ok && netBuf != nil {
+				entry.ReplySize = netBuf.size
+			}
+			c.logRequest(ctx, requestLog, entry)
+			origCmpl(buf, err)
+		}
+	}
 	if err != nil {
-		cmpl(nil, err)
+		c.complete(cmpl, nil, timeoutError(ctx, err, cmd, start, netTimeout))
 		return
 	}
-	reqID := seq % queueSize
+	reqID := seq % c.queueSize
+	var deadline int64
+	if execDeadline, ok := intCtx.Deadline(); ok {
+		// Deadline is rounded up, so it doesn't fire before deadline of context
+		deadline = int64((execDeadline.Sub(c.start) + time.Millisecond - 1) / time.Millisecond)
+	}
 	c.requests[reqID].cmplLock.Lock()
 	c.requests[reqID].cmpl = cmpl
+	c.requests[reqID].cmd, c.requests[reqID].reqTimeout = cmd, 0
+	if isRequestTimeout(ctx, start, netTimeout) {
+		c.requests[reqID].reqTimeout = netTimeout
+	}
+	// Deadline of the previous request of the slot is reset too
+	atomic.StoreInt64(&c.requests[reqID].deadline, deadline)
 	atomic.StoreInt32(&c.requests[reqID].isAsync, 1)
 	atomic.StoreUint32(&c.requests[reqID].seqNum, seq)
 	c.requests[reqID].cmplLock.Unlock()
 
-	if timeout != 0 {
-		atomic.StoreUint32(&c.requests[reqID].deadline, atomic.LoadUint32(&c.now)+uint32(timeout))
-	}
+	enc := c.encodeRPC(ctx, cmd, seq, timeout, args...)
+	enc.writeWait = writeWait
+	// Completion may be called by read loop, which doesn't synchronize with this goroutine
+	atomic.StoreInt64(&requestSize, int64(len(enc.ser.Bytes())-cprotoHdrLen))
+	atomic.StoreInt64(&sentAt, int64(time.Since(c.start)))
+	c.write(enc)
 
-	c.packRPC(cmd, seq, timeout, args...)
+	if err = c.curError(); err != nil {
+		// Connection may fail before completion was registered, so onError could miss it
+		c.requests[reqID].cmplLock.Lock()
+		if c.requests[reqID].cmpl != nil && atomic.LoadUint32(&c.requests[reqID].seqNum) == seq {
+			c.requests[reqID].completeAsync()
+			c.requests[reqID].cmplLock.Unlock()
+			c.seqs <- c.nextSeqNum(seq)
+			c.complete(cmpl, nil, err)
+		} else {
+			c.requests[reqID].cmplLock.Unlock()
+		}
+	}
+}
 
+func (c *connection) rpcCall(ctx context.Context, cmd int, netTimeout time.Duration, args ...interface{}) (buf *NetBuffer, err error) {
+	buf, _, err = c.rpcCallSent(ctx, cmd, netTimeout, args...)
 	return
 }
 
-func (c *connection) rpcCall(ctx context.Context, cmd int, netTimeout uint32, args ...interface{}) (buf *NetBuffer, err error) {
+// rpcCallSent is rpcCall, which also reports, whether request was passed to connection for write before error
+func (c *connection) rpcCallSent(ctx context.Context, cmd int, netTimeout time.Duration, args ...interface{}) (buf *NetBuffer, sent bool, err error) {
 	intCtx, cancel := applyTimeout(ctx, netTimeout)
 	if cancel != nil {
 		defer cancel()
 	}
+	span := c.startSpan(ctx, cmd)
+	requestLog := c.owner.tuned().requestLog.Hook
+	start := time.Now()
 	seq, timeout, err := c.awaitSeqNum(intCtx)
+	queueWait := time.Since(start)
+	atomic.AddInt64(&c.stats.requests, 1)
+	atomic.AddInt64(&c.stats.seqWait, int64(queueWait))
+	var writeWait int64
+	var requestSize, replySize int
+	var sentAt time.Time
+	defer func() {
+		if err != nil {
+			atomic.AddInt64(&c.stats.errors, 1)
+		}
+		c.owner.onRPCDone(ctx, cmd, start, queueWait, time.Duration(atomic.LoadInt64(&writeWait)), err)
+		span.finish(requestSize, replySize, err)
+		if requestLog != nil {
+			entry := bindings.RequestLogEntry{Cmd: cmd, QueueWait: queueWait, WriteWait: time.Duration(atomic.LoadInt64(&writeWait)),
+				RequestSize: requestSize, ReplySize: replySize, Err: err}
+			if sent {
+				entry.Seq, entry.RoundTrip = seq, time.Since(sentAt)
+			}
+			c.logRequest(ctx, requestLog, entry)
+		}
+	}()
 	if err != nil {
-		return nil, err
+		return nil, false, timeoutError(ctx, err, cmd, start, netTimeout)
 	}
 
-	reqID := seq % queueSize
+	reqID := seq % c.queueSize
 	reply := c.requests[reqID].repl
+	sent = true
 
+	atomic.StoreInt64(&c.requests[reqID].maxReplySize, bindings.ReplyLimit(ctx))
 	atomic.StoreUint32(&c.requests[reqID].seqNum, seq)
-	c.packRPC(cmd, seq, timeout, args...)
+	enc := c.encodeRPC(ctx, cmd, seq, timeout, args...)
+	if c.owner.tuned().slowRPC.Hook != nil || requestLog != nil {
+		enc.writeWait = &writeWait
+	}
+	requestSize = len(enc.ser.Bytes()) - cprotoHdrLen
+	if requestLog != nil {
+		sentAt = time.Now()
+	}
+	c.write(enc)
 
 for_loop:
 	for {
@@ -465,9 +1178,10 @@ for_loop:
 		case bufPtr := <-reply:
 			if bufPtr.rseq == seq {
 				buf = bufPtr.buf
+				replySize = buf.size
 				break for_loop
 			} else {
-				bufPtr.buf.Free()
+				c.freeStaleReply(bufPtr.buf)
 			}
 		case <-c.errCh:
 			c.lock.RLock()
@@ -475,32 +1189,53 @@ for_loop:
 			c.lock.RUnlock()
 			break for_loop
 		case <-intCtx.Done():
-			err = intCtx.Err()
+			err = timeoutError(ctx, intCtx.Err(), cmd, start, netTimeout)
+			if ctx.Err() == context.Canceled && isCancelableCmd(cmd) {
+				// Server stops request by its exec timeout on deadline, but not on explicit cancel
+				c.owner.cancelRequest(c, seq)
+			}
 			break for_loop
 		}
 	}
 
+	// Late reply to the abandoned seq is dropped by readLoop or by the next request of the slot, which has another seq
 	atomic.StoreUint32(&c.requests[reqID].seqNum, maxSeqNum)
+	atomic.StoreInt64(&c.requests[reqID].maxReplySize, 0)
 
 	select {
 	case bufPtr := <-reply:
-		bufPtr.buf.Free()
+		c.freeStaleReply(bufPtr.buf)
 	default:
 	}
 
-	c.seqs <- nextSeqNum(seq)
+	c.seqs <- c.nextSeqNum(seq)
 	if err != nil {
 		buf.Free()
-		return nil, err
+		return nil, sent, err
 	}
 	if err = buf.parseArgs(); err != nil {
 		buf.Free()
-		return nil, err
+		return nil, sent, err
+	}
+	return buf, sent, nil
+}
+
+// isIdempotentCmd returns true for commands, which may be sent again, if their connection is broken
+func isIdempotentCmd(cmd int) bool {
+	switch cmd {
+	case cmdSelect, cmdSelectSQL, cmdFetchResults, cmdPing, cmdGetMeta, cmdEnumMeta, cmdEnumNamespaces:
+		return true
 	}
-	return buf, nil
+	return false
 }
 
-func (c *connection) rpcCallNoResults(ctx context.Context, cmd int, netTimeout uint32, args ...interface{}) error {
+// brokenMidRequestError - error of request, which connection was broken by err after request was sent
+func brokenMidRequestError(cmd int, err error) error {
+	return bindings.NewError(fmt.Sprintf("rq: Connection was broken after command %s was sent, it may be applied by server: %v", cmdName(cmd), err),
+		bindings.ErrConnBrokenMidRequest)
+}
+
+func (c *connection) rpcCallNoResults(ctx context.Context, cmd int, netTimeout time.Duration, args ...interface{}) error {
 	buf, err := c.rpcCall(ctx, cmd, netTimeout, args...)
 	buf.Free()
 	return err
@@ -513,6 +1248,12 @@ func (c *connection) onError(err error) {
 		if c.conn != nil {
 			c.conn.Close()
 		}
+		// EOF without requests in flight is graceful close by server. Connection, which is closed by client, isn't reported
+		if err == io.EOF && len(c.seqs) == cap(c.seqs) {
+			c.onShutdown(err)
+		} else if err != errConnClosed && atomic.LoadInt32(&c.established) != 0 && atomic.CompareAndSwapInt32(&c.reported, 0, 1) {
+			go c.owner.onConnLost(c, bindings.DisconnectNetworkError, err)
+		}
 		select {
 		case <-c.errCh:
 		default:
@@ -524,13 +1265,11 @@ func (c *connection) onError(err error) {
 				c.requests[i].cmplLock.Lock()
 				if c.requests[i].cmpl != nil {
 					cmpl := c.requests[i].cmpl
-					c.requests[i].cmpl = nil
 					seqNum := atomic.LoadUint32(&c.requests[i].seqNum)
-					atomic.StoreUint32(&c.requests[i].seqNum, maxSeqNum)
-					atomic.StoreInt32(&c.requests[i].isAsync, 0)
+					c.requests[i].completeAsync()
 					c.requests[i].cmplLock.Unlock()
-					c.seqs <- nextSeqNum(seqNum)
-					cmpl(nil, err)
+					c.seqs <- c.nextSeqNum(seqNum)
+					c.complete(cmpl, nil, err)
 				} else {
 					c.requests[i].cmplLock.Unlock()
 				}
@@ -540,6 +1279,24 @@ func (c *connection) onError(err error) {
 	c.lock.Unlock()
 }
 
+// onShutdown marks connection, which is closed by server shutdown, as draining: it's not used for new requests
+func (c *connection) onShutdown(err error) {
+	atomic.StoreInt32(&c.shutdown, 1)
+	if atomic.LoadInt32(&c.established) != 0 && atomic.CompareAndSwapInt32(&c.reported, 0, 1) {
+		go c.owner.onConnLost(c, bindings.DisconnectServerShutdown, err)
+	}
+}
+
+// isDraining returns true, if connection must not be used for new requests: server is going to close it or has closed it
+func (c *connection) isDraining() bool {
+	return atomic.LoadInt32(&c.shutdown) != 0
+}
+
+// isUsable returns true, if connection may be used for new requests
+func (c *connection) isUsable() bool {
+	return !c.isDraining() && !c.hasError()
+}
+
 func (c *connection) hasError() (has bool) {
 	c.lock.RLock()
 	has = c.err != nil
@@ -555,10 +1312,84 @@ func (c *connection) curError() error {
 
 func (c *connection) lastReadTime() time.Time {
 	stamp := atomic.LoadInt64(&c.lastReadStamp)
-	return time.Unix(stamp, 0)
+	return time.Unix(0, stamp)
+}
+
+// lastPingTime returns time of the last successful keepalive ping (zero time - there were no pings)
+func (c *connection) lastPingTime() time.Time {
+	if stamp := atomic.LoadInt64(&c.lastPingStamp); stamp != 0 {
+		return time.Unix(0, stamp)
+	}
+	return time.Time{}
+}
+
+// isPingPending returns true, if nothing is read from connection since keepalive ping was sent. Such connection may be broken
+func (c *connection) isPingPending() bool {
+	sent := atomic.LoadInt64(&c.pingSentStamp)
+	return sent != 0 && atomic.LoadInt64(&c.lastReadStamp) < sent
+}
+
+// keepalive pings connection, which has no replies for interval of opts, until connection is failed or finalized.
+// Ping is skipped, while all the request slots are taken: it must not delay user requests
+func (c *connection) keepalive(opts bindings.OptionPingInterval) {
+	ticker := time.NewTicker(opts.Interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.errCh:
+			return
+		case <-c.termCh:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(c.lastReadTime()) >= opts.Interval && len(c.seqs) != 0 {
+			c.ping(opts.Timeout)
+		}
+	}
+}
+
+// ping sends keepalive ping. Connection fails with errPingTimeout, if nothing is read from it until timeout
+func (c *connection) ping(timeout time.Duration) {
+	sent := time.Now().UnixNano()
+	atomic.StoreInt64(&c.pingSentStamp, sent)
+	defer atomic.StoreInt64(&c.pingSentStamp, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	buf, err := c.rpcCall(ctx, cmdPing, 0)
+	buf.Free()
+	if err == nil {
+		atomic.StoreInt64(&c.lastPingStamp, time.Now().UnixNano())
+	} else if err == context.DeadlineExceeded && atomic.LoadInt64(&c.lastReadStamp) < sent {
+		c.onError(errPingTimeout)
+	}
 }
 
 func (c *connection) Finalize() error {
-	close(c.termCh)
-	return nil
+	return c.finalize(context.Background())
+}
+
+// finalize waits for in-flight requests until ctx is done, then closes connection and stops its goroutines
+func (c *connection) finalize(ctx context.Context) (err error) {
+	ticker := time.NewTicker(finalizeCheckPeriod)
+	defer ticker.Stop()
+	for len(c.seqs) != cap(c.seqs) && !c.hasError() {
+		select {
+		case <-ticker.C:
+		case <-c.errCh:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+	c.lock.Lock()
+	select {
+	case <-c.termCh:
+	default:
+		close(c.termCh)
+	}
+	c.lock.Unlock()
+	c.onError(errConnClosed)
+	return
 }
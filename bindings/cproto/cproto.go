@@ -2,6 +2,7 @@ package cproto
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,15 +22,28 @@ const (
 	pingerTimeoutSec = 60
 	defAppName       = "Go-connector"
 
+	minConnectRetriesBackoff = 10 * time.Millisecond
+
 	opRd = 0
 	opWr = 1
+	// opBulk - read request of bulk query, which is sent via bulk connection
+	opBulk = 2
 )
 
 var logger Logger
 var logMtx sync.Mutex
 
+const (
+	// tlsScheme - scheme of DSN, which connects to server via TLS, even if OptionTLS is not passed
+	tlsScheme = "cprotos"
+	// unixScheme - scheme of DSN, which connects to server via unix domain socket (see dsnAddr)
+	unixScheme = "ucproto"
+)
+
 func init() {
 	bindings.RegisterBinding("cproto", new(NetCProto))
+	bindings.RegisterBinding(tlsScheme, new(NetCProto))
+	bindings.RegisterBinding(unixScheme, new(NetCProto))
 }
 
 type Logger interface {
@@ -40,19 +54,60 @@ type NetCProto struct {
 	dsn              dsn
 	pool             pool
 	onChangeCallback func()
+	// connectCallback of RawBindingConnecting, it's set after Init, so it's accessed atomically
+	connectCallback  atomic.Value
+	connectHook      bindings.OptionConnectHook
 	serverStartTime  int64
-	retryAttempts    bindings.OptionRetryAttempts
-	timeouts         bindings.OptionTimeouts
+	serverVersion    atomic.Value
+	serverCaps       int64
+	requeue          bindings.OptionRequeueOnConnLoss
+	discardLimit     bindings.OptionDiscardedRepliesLimit
 	connectOpts      bindings.OptionConnect
 	compression      bindings.OptionCompression
+	writeCoalescing  bindings.OptionWriteCoalescing
 	appName          string
+	labelFunc        func(ctx context.Context) string
 	termCh           chan struct{}
 	lock             sync.RWMutex
+	connectRetries   bindings.OptionConnectRetries
+	connectedCh      chan struct{}
+	connectAttempt   int32
+	traffic          trafficStats
+	queueSize        int
+	poolStrategy     int
+	tlsConfig        *tls.Config
+	dialContext      func(ctx context.Context, network, addr string) (net.Conn, error)
+	tcp              bindings.OptionTCP
+	reconnectBackoff bindings.OptionReconnectBackoff
+	failback         bindings.OptionFailback
+	pingInterval     bindings.OptionPingInterval
+	onDisconnect     func(ev bindings.DisconnectEvent)
+	resultChecksum   bool
+	bulk             bulkPool
+	breakers         circuitBreakers
+	// reconnect is delayed after failed attempt until reconnectAfter, requests fail with reconnectErr meanwhile. Guarded by lock
+	reconnectDelay time.Duration
+	reconnectAfter time.Time
+	reconnectErr   error
+	redial         redialer
+	// options, which may be changed by UpdateOptions. Accessed atomically, see tuned
+	tunables   atomic.Value
+	updateLock sync.Mutex
+	// size of the pool, which may be changed by UpdateOptions. Guarded by lock
+	poolSize int
+	// requests fail with errQueueFull instead of waiting for request slot without limit
+	failOnQueueFull bool
+	queueFull       bindings.OptionQueueFullError
+	writeLimit      bindings.OptionWriteLimit
 }
 
 type pool struct {
 	conns []*connection
 	next  uint64
+	// strategy of choice of connection, see bindings.OptionPoolStrategy
+	strategy int
+	// reconnects of connections by slots of the pool
+	reconnects []int64
 }
 
 type dsn struct {
@@ -63,6 +118,21 @@ type dsn struct {
 }
 
 func (p *pool) get() *connection {
+	switch p.strategy {
+	case bindings.PoolLeastInFlight:
+		return p.getLeastInFlight()
+	case bindings.PoolTwoRandomChoices:
+		return p.getTwoRandomChoices()
+	}
+	return p.getInTurn()
+}
+
+func (p *pool) getInTurn() *connection {
+	return p.conns[p.nextSlot()]
+}
+
+// nextSlot returns index of the next connection in turn
+func (p *pool) nextSlot() int {
 	id := atomic.AddUint64(&p.next, 1)
 
 	for id >= uint64(len(p.conns)) {
@@ -72,7 +142,36 @@ func (p *pool) get() *connection {
 			id = atomic.AddUint64(&p.next, 1)
 		}
 	}
-	return p.conns[id]
+	return int(id)
+}
+
+// slotOf returns index of connection in the pool or -1
+func (p *pool) slotOf(c *connection) int {
+	for i, conn := range p.conns {
+		if conn == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// activityLabel returns label, which is sent to the server with request
+func (binding *NetCProto) activityLabel(ctx context.Context) string {
+	if label := bindings.ActivityLabel(ctx); label != "" || binding.labelFunc == nil {
+		return label
+	}
+	return bindings.SanitizeActivityLabel(binding.labelFunc(ctx))
+}
+
+// onRPCDone calls slow RPC hook, if request, which was started at start, took longer than threshold
+func (binding *NetCProto) onRPCDone(ctx context.Context, cmd int, start time.Time, queueWait, writeWait time.Duration, err error) {
+	slowRPC := binding.tuned().slowRPC
+	if slowRPC.Hook == nil {
+		return
+	}
+	if d := time.Since(start); d >= slowRPC.Threshold {
+		slowRPC.Hook(bindings.SlowRPC{Cmd: cmd, Label: binding.activityLabel(ctx), QueueWait: queueWait, WriteWait: writeWait, Duration: d, Err: err})
+	}
 }
 
 func (binding *NetCProto) getActiveDSN() *url.URL {
@@ -87,66 +186,249 @@ func (binding *NetCProto) nextDSN() {
 func (binding *NetCProto) Init(u []url.URL, options ...interface{}) (err error) {
 	connPoolSize := defConnPoolSize
 	binding.appName = defAppName
+	tun := &tunables{}
 
 	for _, option := range options {
+		if tun.apply(option) {
+			continue
+		}
 		switch v := option.(type) {
 		case bindings.OptionConnPoolSize:
 			connPoolSize = v.ConnPoolSize
-		case bindings.OptionRetryAttempts:
-			binding.retryAttempts = v
-		case bindings.OptionTimeouts:
-			binding.timeouts = v
+		case bindings.OptionConnQueueSize:
+			binding.queueSize = v.QueueSize
+		case bindings.OptionPoolStrategy:
+			binding.poolStrategy = v.Strategy
+		case bindings.OptionRequeueOnConnLoss:
+			binding.requeue = v
+		case bindings.OptionDiscardedRepliesLimit:
+			binding.discardLimit = v
 		case bindings.OptionConnect:
 			binding.connectOpts = v
 		case bindings.OptionCompression:
 			binding.compression = v
+		case bindings.OptionWriteCoalescing:
+			binding.writeCoalescing = v
 		case bindings.OptionAppName:
 			binding.appName = v.AppName
+		case bindings.OptionActivityLabel:
+			binding.labelFunc = v.LabelFunc
+		case bindings.OptionConnectRetries:
+			binding.connectRetries = v
+		case bindings.OptionReconnectBackoff:
+			binding.reconnectBackoff = v
+		case bindings.OptionPingInterval:
+			binding.pingInterval = v
+		case bindings.OptionTLS:
+			binding.tlsConfig = v.Config
+		case bindings.OptionDialer:
+			binding.dialContext = v.DialContext
+		case bindings.OptionTCP:
+			binding.tcp = v
+		case bindings.OptionOnDisconnect:
+			binding.onDisconnect = v.Handler
+		case bindings.OptionResultChecksum:
+			binding.resultChecksum = v.Enable
+		case bindings.OptionBulkConns:
+			binding.bulk.size = v.Count
+		case bindings.OptionCircuitBreaker:
+			binding.breakers.init(v)
+		case bindings.OptionConnectHook:
+			binding.connectHook = v
+		case bindings.OptionFailback:
+			binding.failback = v
+		case bindings.OptionQueueFullError:
+			binding.failOnQueueFull, binding.queueFull = true, v
+		case bindings.OptionWriteLimit:
+			binding.writeLimit = v
 		default:
 			fmt.Printf("Unknown cproto option: %#v\n", option)
 		}
 	}
 
-	if binding.timeouts.RequestTimeout/time.Second != 0 && binding.timeouts.LoginTimeout > binding.timeouts.RequestTimeout {
-		binding.timeouts.RequestTimeout = binding.timeouts.LoginTimeout
+	if binding.pingInterval.Interval > 0 && binding.pingInterval.Timeout <= 0 {
+		binding.pingInterval.Timeout = binding.pingInterval.Interval
+	}
+	tun.normalize()
+	binding.tunables.Store(tun)
+
+	if connPoolSize <= 0 {
+		connPoolSize = defConnPoolSize
+	}
+	binding.poolSize = connPoolSize
+	if binding.queueSize <= 0 {
+		binding.queueSize = defQueueSize
+	} else if binding.queueSize > maxQueueSize {
+		binding.queueSize = maxQueueSize
 	}
 
-	if binding.retryAttempts.Read < 0 {
-		binding.retryAttempts.Read = 0
+	if err = binding.validateTCP(u); err != nil {
+		return err
 	}
-	if binding.retryAttempts.Write < 0 {
-		binding.retryAttempts.Write = 0
+
+	if binding.tlsConfig == nil && len(u) != 0 && u[0].Scheme == tlsScheme {
+		// Server is verified by system roots and host of DSN
+		binding.tlsConfig = &tls.Config{}
 	}
 
 	binding.dsn.url = u
-	if err = binding.connectDSN(context.Background(), connPoolSize); err != nil {
+	if binding.connectRetries.Attempts > 1 || binding.connectRetries.Total > 0 {
+		// Connect in background, requests will wait for the connection in getConn
+		binding.connectedCh = make(chan struct{})
+		binding.termCh = make(chan struct{})
+		go binding.connectWithRetries(binding.termCh)
+		go binding.pinger(binding.termCh)
+		binding.startFailback()
+		return
+	}
+	if binding.pool, err = binding.connectDSN(context.Background(), connPoolSize); err != nil {
 		return err
 	}
 	binding.termCh = make(chan struct{})
-	go binding.pinger()
+	go binding.pinger(binding.termCh)
+	binding.startFailback()
 	return
 }
 
-func (binding *NetCProto) newPool(ctx context.Context, connPoolSize int) error {
+func (binding *NetCProto) connectWithRetries(termCh chan struct{}) {
+	defer close(binding.connectedCh)
+
+	ctx := context.Background()
+	if binding.connectRetries.Total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, binding.connectRetries.Total)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		atomic.StoreInt32(&binding.connectAttempt, int32(attempt))
+		binding.lock.RLock()
+		connPoolSize := binding.poolSize
+		binding.lock.RUnlock()
+		// Lock is held only to replace the pool, so status and pinger are not blocked by the dial
+		p, err := binding.connectDSN(ctx, connPoolSize)
+		binding.lock.Lock()
+		binding.pool = p
+		binding.lock.Unlock()
+		if err == nil {
+			return
+		}
+		if logger != nil {
+			logger.Printf(3, "rq: connect attempt %d failed: %s\n", attempt, err.Error())
+		}
+		if binding.connectRetries.Attempts > 0 && attempt >= binding.connectRetries.Attempts {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-termCh:
+			return
+		case <-time.After(binding.connectRetriesBackoff()):
+		}
+	}
+}
+
+// connectRetriesBackoff returns delay between connect attempts. Zero backoff is limited by minConnectRetriesBackoff
+func (binding *NetCProto) connectRetriesBackoff() time.Duration {
+	if binding.connectRetries.Backoff < minConnectRetriesBackoff {
+		return minConnectRetriesBackoff
+	}
+	return binding.connectRetries.Backoff
+}
+
+// awaitConnected waits for the end of the initial connection attempts, if client is connecting in background
+func (binding *NetCProto) awaitConnected(ctx context.Context) error {
+	if binding.connectedCh == nil {
+		return nil
+	}
+	select {
+	case <-binding.connectedCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (binding *NetCProto) isConnecting() bool {
+	if binding.connectedCh == nil {
+		return false
+	}
+	select {
+	case <-binding.connectedCh:
+		return false
+	default:
+		return true
+	}
+}
+
+// newPool connects new pool. Connections of the pool are finalized, if any of them is failed
+func (binding *NetCProto) newPool(ctx context.Context, connPoolSize int) (p pool, err error) {
 	var wg sync.WaitGroup
-	binding.pool = pool{
-		conns: make([]*connection, connPoolSize),
+	p = pool{
+		conns:    make([]*connection, connPoolSize),
+		strategy: binding.poolStrategy,
 	}
 	wg.Add(connPoolSize)
+	dsn := binding.getActiveDSN()
 	for i := 0; i < connPoolSize; i++ {
 		go func(binding *NetCProto, wg *sync.WaitGroup, i int) {
 			defer wg.Done()
-			conn, _ := newConnection(ctx, binding)
-			binding.pool.conns[i] = conn
+			conn, _ := newConnection(ctx, binding, dsn)
+			p.conns[i] = conn
 		}(binding, &wg, i)
 	}
 	wg.Wait()
-	for _, conn := range binding.pool.conns {
+	for _, conn := range p.conns {
 		if conn.err != nil {
-			return conn.err
+			for _, conn := range p.conns {
+				conn.Finalize()
+			}
+			return p, conn.err
 		}
 	}
-	return nil
+	return p, nil
+}
+
+// ServerVersion returns version of server, which was received on login. Empty before the first successful login
+func (binding *NetCProto) ServerVersion() string {
+	if v, ok := binding.serverVersion.Load().(string); ok {
+		return v
+	}
+	return ""
+}
+
+// cancelRequestTimeout - timeout of cmdCancelRequest, which is sent in background
+const cancelRequestTimeout = time.Second
+
+// cancelRequest asks server to stop request seq of connection c, which context is canceled. Server handles requests of connection
+// sequentially, so cancel is sent via another connection of the pool in background. It's skipped, if there is no such connection
+func (binding *NetCProto) cancelRequest(c *connection, seq uint32) {
+	if c.serverID < 0 || binding.ServerCapabilities()&bindings.ServerCapCancelRequest == 0 {
+		return
+	}
+	var conn *connection
+	binding.lock.RLock()
+	for _, pc := range binding.pool.conns {
+		if pc != c && pc.isUsable() {
+			conn = pc
+			break
+		}
+	}
+	binding.lock.RUnlock()
+	if conn == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cancelRequestTimeout)
+		defer cancel()
+		conn.rpcCallNoResults(ctx, cmdCancelRequest, 0, c.serverID, int64(seq))
+	}()
+}
+
+// ServerCapabilities returns capabilities, which were advertised by server on login
+func (binding *NetCProto) ServerCapabilities() int64 {
+	return atomic.LoadInt64(&binding.serverCaps)
 }
 
 func (binding *NetCProto) Clone() bindings.RawBinding {
@@ -163,22 +445,25 @@ func (binding *NetCProto) BeginTx(ctx context.Context, namespace string) (txCtx
 		return
 	}
 
-	txCtx.Result = buf
-	if len(buf.args) > 0 {
-		txCtx.Id = uint64(buf.args[0].(int64))
+	id, err := buf.ArgInt(0)
+	if err != nil {
+		buf.Free()
+		return
 	}
+	txCtx.Result = buf
+	txCtx.Id = uint64(id)
 	return
 }
 
 func (binding *NetCProto) CommitTx(txCtx *bindings.TxCtx) (bindings.RawBuffer, error) {
-	return txCtx.Result.(*NetBuffer).conn.rpcCall(txCtx.UserCtx, cmdCommitTx, uint32(binding.timeouts.RequestTimeout/time.Second), int64(txCtx.Id))
+	return txCtx.Result.(*NetBuffer).conn.rpcCall(txCtx.UserCtx, cmdCommitTx, binding.tuned().timeouts.RequestTimeout, int64(txCtx.Id))
 }
 
 func (binding *NetCProto) RollbackTx(txCtx *bindings.TxCtx) error {
 	if txCtx.Result == nil {
 		return nil
 	}
-	return txCtx.Result.(*NetBuffer).conn.rpcCallNoResults(txCtx.UserCtx, cmdRollbackTx, uint32(binding.timeouts.RequestTimeout/time.Second), int64(txCtx.Id))
+	return txCtx.Result.(*NetBuffer).conn.rpcCallNoResults(txCtx.UserCtx, cmdRollbackTx, binding.tuned().timeouts.RequestTimeout, int64(txCtx.Id))
 }
 
 func (binding *NetCProto) ModifyItemTx(txCtx *bindings.TxCtx, format int, data []byte, mode int, precepts []string, stateToken int) error {
@@ -195,7 +480,7 @@ func (binding *NetCProto) ModifyItemTx(txCtx *bindings.TxCtx, format int, data [
 	}
 
 	netBuffer := txCtx.Result.(*NetBuffer)
-	return netBuffer.conn.rpcCallNoResults(txCtx.UserCtx, cmdAddTxItem, uint32(binding.timeouts.RequestTimeout/time.Second), format, data, mode, packedPercepts, stateToken, int64(txCtx.Id))
+	return netBuffer.conn.rpcCallNoResults(txCtx.UserCtx, cmdAddTxItem, binding.tuned().timeouts.RequestTimeout, format, data, mode, packedPercepts, stateToken, int64(txCtx.Id))
 }
 
 func (binding *NetCProto) ModifyItemTxAsync(txCtx *bindings.TxCtx, format int, data []byte, mode int, precepts []string, stateToken int, cmpl bindings.RawCompletion) {
@@ -212,18 +497,18 @@ func (binding *NetCProto) ModifyItemTxAsync(txCtx *bindings.TxCtx, format int, d
 	}
 
 	netBuffer := txCtx.Result.(*NetBuffer)
-	netBuffer.conn.rpcCallAsync(txCtx.UserCtx, cmdAddTxItem, uint32(binding.timeouts.RequestTimeout/time.Second), cmpl, format, data, mode, packedPercepts, stateToken, int64(txCtx.Id))
+	netBuffer.conn.rpcCallAsync(txCtx.UserCtx, cmdAddTxItem, binding.tuned().timeouts.RequestTimeout, cmpl, format, data, mode, packedPercepts, stateToken, int64(txCtx.Id))
 }
 
 func (binding *NetCProto) DeleteQueryTx(txCtx *bindings.TxCtx, rawQuery []byte) error {
 	netBuffer := txCtx.Result.(*NetBuffer)
-	return netBuffer.conn.rpcCallNoResults(txCtx.UserCtx, cmdDeleteQueryTx, uint32(binding.timeouts.RequestTimeout/time.Second), rawQuery, int64(txCtx.Id))
+	return netBuffer.conn.rpcCallNoResults(txCtx.UserCtx, cmdDeleteQueryTx, binding.tuned().timeouts.RequestTimeout, rawQuery, int64(txCtx.Id))
 
 }
 
 func (binding *NetCProto) UpdateQueryTx(txCtx *bindings.TxCtx, rawQuery []byte) error {
 	netBuffer := txCtx.Result.(*NetBuffer)
-	return netBuffer.conn.rpcCallNoResults(txCtx.UserCtx, cmdUpdateQueryTx, uint32(binding.timeouts.RequestTimeout/time.Second), rawQuery, int64(txCtx.Id))
+	return netBuffer.conn.rpcCallNoResults(txCtx.UserCtx, cmdUpdateQueryTx, binding.tuned().timeouts.RequestTimeout, rawQuery, int64(txCtx.Id))
 }
 
 func (binding *NetCProto) ModifyItem(ctx context.Context, nsHash int, namespace string, format int, data []byte, mode int, precepts []string, stateToken int) (bindings.RawBuffer, error) {
@@ -243,6 +528,40 @@ func (binding *NetCProto) ModifyItem(ctx context.Context, nsHash int, namespace
 	return binding.rpcCall(ctx, opWr, cmdModifyItem, namespace, format, data, mode, packedPercepts, stateToken, 0)
 }
 
+func (binding *NetCProto) ModifyItemAsync(ctx context.Context, nsHash int, namespace string, format int, data []byte, mode int, precepts []string, stateToken int, cmpl bindings.RawCompletion) {
+	var packedPercepts []byte
+	if len(precepts) != 0 {
+		ser1 := cjson.NewPoolSerializer()
+		defer ser1.Close()
+
+		ser1.PutVarCUInt(len(precepts))
+		for _, precept := range precepts {
+			ser1.PutVString(precept)
+		}
+		packedPercepts = ser1.Bytes()
+	}
+
+	if binding.breakers.enabled() {
+		host := binding.activeHost()
+		probe, err := binding.breakers.allow(host)
+		if err != nil {
+			cmpl(nil, err)
+			return
+		}
+		userCmpl := cmpl
+		cmpl = func(buf bindings.RawBuffer, err error) {
+			binding.breakers.done(host, probe, err)
+			userCmpl(buf, err)
+		}
+	}
+	conn, err := binding.getConn(ctx)
+	if err != nil {
+		cmpl(nil, err)
+		return
+	}
+	conn.rpcCallAsync(ctx, cmdModifyItem, binding.tuned().timeouts.RequestTimeout, cmpl, namespace, format, data, mode, packedPercepts, stateToken, 0)
+}
+
 func (binding *NetCProto) OpenNamespace(ctx context.Context, namespace string, enableStorage, dropOnFormatError bool) error {
 	storageOtps := bindings.StorageOpts{
 		EnableStorage:     enableStorage,
@@ -306,7 +625,47 @@ func (binding *NetCProto) PutMeta(ctx context.Context, namespace, key, data stri
 }
 
 func (binding *NetCProto) GetMeta(ctx context.Context, namespace, key string) (bindings.RawBuffer, error) {
-	return binding.rpcCall(ctx, opRd, cmdGetMeta, namespace, key)
+	buf, err := binding.rpcCall(ctx, opRd, cmdGetMeta, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = buf.ArgBytes(0); err != nil {
+		buf.Free()
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (binding *NetCProto) EnumMeta(ctx context.Context, namespace string) ([]string, error) {
+	buf, err := binding.rpcCall(ctx, opRd, cmdEnumMeta, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer buf.Free()
+	keys := make([]string, 0, buf.ArgsCount())
+	for i := 0; i < buf.ArgsCount(); i++ {
+		key, err := buf.ArgString(i)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (binding *NetCProto) GetSQLSuggestions(ctx context.Context, query string, pos int) ([]string, error) {
+	buf, err := binding.rpcCall(ctx, opRd, cmdGetSQLSuggestions, query, pos)
+	defer buf.Free()
+	if err != nil {
+		return nil, err
+	}
+	suggestions := make([]string, 0, buf.ArgsCount())
+	for i := 0; i < buf.ArgsCount(); i++ {
+		if s, err := buf.ArgString(i); err == nil {
+			suggestions = append(suggestions, s)
+		}
+	}
+	return suggestions, nil
 }
 
 func (binding *NetCProto) Select(ctx context.Context, query string, asJson bool, ptVersions []int32, fetchCount int) (bindings.RawBuffer, error) {
@@ -321,9 +680,24 @@ func (binding *NetCProto) Select(ctx context.Context, query string, asJson bool,
 		fetchCount = math.MaxInt32
 	}
 
-	buf, err := binding.rpcCall(ctx, opRd, cmdSelectSQL, query, flags, int32(fetchCount), ptVersions)
+	checksum := binding.needChecksum(ctx)
+	if checksum {
+		flags |= bindings.ResultsWithChecksum
+	}
+
+	buf, err := binding.rpcCall(ctx, binding.selectOp(ctx), cmdSelectSQL, query, flags, int32(fetchCount), ptVersions)
 	if buf != nil {
-		buf.reqID = buf.args[1].(int)
+		reqID, err := buf.resultsID()
+		if err != nil {
+			buf.Free()
+			return nil, err
+		}
+		buf.reqID = reqID
+		buf.checksum = checksum
+		if err = buf.verifyChecksum(); err != nil {
+			buf.Free()
+			return nil, err
+		}
 	}
 	return buf, err
 }
@@ -332,6 +706,8 @@ func (binding *NetCProto) SelectQuery(ctx context.Context, data []byte, asJson b
 	flags := 0
 	if asJson {
 		flags |= bindings.ResultsJson
+	} else if bindings.IDsOnly(ctx) {
+		flags |= bindings.ResultsPure | bindings.ResultsWithItemID
 	} else {
 		flags |= bindings.ResultsCJson | bindings.ResultsWithPayloadTypes | bindings.ResultsWithItemID
 	}
@@ -340,13 +716,53 @@ func (binding *NetCProto) SelectQuery(ctx context.Context, data []byte, asJson b
 		fetchCount = math.MaxInt32
 	}
 
-	buf, err := binding.rpcCall(ctx, opRd, cmdSelect, data, flags, int32(fetchCount), ptVersions)
+	checksum := binding.needChecksum(ctx)
+	if checksum {
+		flags |= bindings.ResultsWithChecksum
+	}
+
+	var buf *NetBuffer
+	var err error
+	if op, delay := binding.selectOp(ctx), bindings.HedgeDelay(ctx); op == opRd && delay > 0 && binding.pinnedConn(ctx) == nil {
+		buf, err = binding.hedgedSelect(ctx, delay, cmdSelect, data, flags, int32(fetchCount), ptVersions)
+	} else {
+		buf, err = binding.rpcCall(ctx, op, cmdSelect, data, flags, int32(fetchCount), ptVersions)
+	}
 	if buf != nil {
-		buf.reqID = buf.args[1].(int)
+		reqID, err := buf.resultsID()
+		if err != nil {
+			buf.Free()
+			return nil, err
+		}
+		buf.reqID = reqID
+		buf.checksum = checksum
+		if err = buf.verifyChecksum(); err != nil {
+			buf.Free()
+			return nil, err
+		}
 	}
 	return buf, err
 }
 
+// selectOp returns opBulk for bulk queries, if bulk connections are enabled
+func (binding *NetCProto) selectOp(ctx context.Context) int {
+	if binding.bulk.size > 0 && bindings.BulkConn(ctx) {
+		return opBulk
+	}
+	return opRd
+}
+
+// needChecksum returns true, if results of query with ctx must be verified by checksum
+func (binding *NetCProto) needChecksum(ctx context.Context) bool {
+	switch bindings.ResultChecksum(ctx) {
+	case bindings.ResultChecksumEnabled:
+		return true
+	case bindings.ResultChecksumDisabled:
+		return false
+	}
+	return binding.resultChecksum
+}
+
 func (binding *NetCProto) DeleteQuery(ctx context.Context, nsHash int, data []byte) (bindings.RawBuffer, error) {
 	return binding.rpcCall(ctx, opWr, cmdDeleteQuery, data)
 }
@@ -385,21 +801,32 @@ func (binding *NetCProto) EnableStorage(ctx context.Context, path string) error
 }
 
 func (binding *NetCProto) Status(ctx context.Context) bindings.Status {
-	var totalQueueSize, totalQueueUsage, connUsage int
-	var remoteAddr string
-	conns := binding.getAllConns()
-	for _, conn := range conns {
-		if conn.hasError() {
-			continue
+	if binding.isConnecting() {
+		state := fmt.Sprintf("connecting (attempt %d)", atomic.LoadInt32(&binding.connectAttempt))
+		if binding.connectRetries.Attempts > 0 {
+			state = fmt.Sprintf("connecting (attempt %d/%d)", atomic.LoadInt32(&binding.connectAttempt), binding.connectRetries.Attempts)
 		}
-		totalQueueSize += cap(conn.seqs)
-		queueUsage := cap(conn.seqs) - len(conn.seqs)
-		totalQueueUsage += queueUsage
-		if queueUsage > 0 {
-			connUsage++
+		return bindings.Status{CProto: bindings.StatusCProto{ConnState: state, CircuitBreakers: binding.breakers.status()}}
+	}
+
+	binding.lock.RLock()
+	conns := binding.pool.conns
+	_, activeHost, _ := dsnAddr(binding.getActiveDSN())
+	binding.lock.RUnlock()
+	connUsage, totalQueueSize, totalQueueUsage, remoteAddr := connsUsage(conns)
+	connHosts := make([]string, len(conns))
+	connDiscarded := make([]bindings.DiscardedReplies, len(conns))
+	for i, conn := range conns {
+		if conn != nil {
+			connHosts[i] = conn.addr
+			connDiscarded[i] = bindings.DiscardedReplies{
+				Stale:   atomic.LoadInt64(&conn.staleReplies),
+				Unknown: atomic.LoadInt64(&conn.unknownReplies),
+			}
 		}
-		remoteAddr = conn.conn.RemoteAddr().String()
 	}
+	bulkConns := binding.bulk.getAll()
+	bulkUsage, bulkQueueSize, bulkQueueUsage, _ := connsUsage(bulkConns)
 
 	return bindings.Status{
 		CProto: bindings.StatusCProto{
@@ -408,19 +835,73 @@ func (binding *NetCProto) Status(ctx context.Context) bindings.Status {
 			ConnQueueSize:  totalQueueSize,
 			ConnQueueUsage: totalQueueUsage,
 			ConnAddr:       remoteAddr,
+			ActiveHost:     activeHost,
+			ConnHosts:      connHosts,
+
+			ConnDiscardedReplies: connDiscarded,
+
+			BulkConnPoolSize:   len(bulkConns),
+			BulkConnPoolUsage:  bulkUsage,
+			BulkConnQueueSize:  bulkQueueSize,
+			BulkConnQueueUsage: bulkQueueUsage,
+			CircuitBreakers:    binding.breakers.status(),
+
+			UncompressedBytes:  atomic.LoadInt64(&binding.traffic.uncompressed),
+			CompressedSrcBytes: atomic.LoadInt64(&binding.traffic.compressedSrc),
+			CompressedBytes:    atomic.LoadInt64(&binding.traffic.compressed),
+			StaleReplies:       atomic.LoadInt64(&binding.traffic.staleReplies),
+			UnknownReplies:     atomic.LoadInt64(&binding.traffic.unknownReplies),
+			LeakedResults:      atomic.LoadInt64(&binding.traffic.leakedResults),
+			RequeuedRequests:   atomic.LoadInt64(&binding.traffic.requeuedRequests),
+			HedgedRequests:     atomic.LoadInt64(&binding.traffic.hedgedRequests),
+			HedgeWins:          atomic.LoadInt64(&binding.traffic.hedgeWins),
+			HedgeWasted:        atomic.LoadInt64(&binding.traffic.hedgeWasted),
+			QueueFullRequests:  atomic.LoadInt64(&binding.traffic.queueFullRequests),
 		},
 	}
 }
 
+// connsUsage returns count of connections with requests in flight, total size and usage of their request queues and address of server
+func connsUsage(conns []*connection) (connUsage, queueSize, queueUsage int, remoteAddr string) {
+	for _, conn := range conns {
+		if conn.hasError() {
+			continue
+		}
+		queueSize += cap(conn.seqs)
+		usage := cap(conn.seqs) - len(conn.seqs)
+		queueUsage += usage
+		if usage > 0 {
+			connUsage++
+		}
+		remoteAddr = conn.conn.RemoteAddr().String()
+	}
+	return
+}
+
 func (binding *NetCProto) Finalize() error {
+	return binding.FinalizeCtx(context.Background())
+}
+
+// FinalizeCtx waits for in-flight requests until ctx is done, then closes all the connections and stops background goroutines
+func (binding *NetCProto) FinalizeCtx(ctx context.Context) (err error) {
+	binding.lock.Lock()
 	if binding.termCh != nil {
 		close(binding.termCh)
+		binding.termCh = nil
 	}
-	conns := binding.getAllConns()
+	conns := binding.pool.conns
+	binding.lock.Unlock()
+	conns = append(conns[:len(conns):len(conns)], binding.bulk.getAll()...)
+
 	for _, conn := range conns {
-		conn.Finalize()
+		if conn == nil {
+			continue
+		}
+		if cerr := conn.finalize(ctx); cerr != nil && err == nil {
+			err = cerr
+		}
 	}
-	return nil
+	return
 }
 
 func (binding *NetCProto) getAllConns() []*connection {
@@ -430,13 +911,48 @@ func (binding *NetCProto) getAllConns() []*connection {
 }
 
 func (binding *NetCProto) getConn(ctx context.Context) (conn *connection, err error) {
+	if conn = binding.pinnedConn(ctx); conn != nil {
+		return conn, nil
+	}
+	if err = binding.awaitConnected(ctx); err != nil {
+		return nil, err
+	}
+	if lane, ok := bindings.WriteLane(ctx); ok {
+		if conn = binding.laneConn(lane); conn != nil {
+			return conn, nil
+		}
+	}
 	for {
 		binding.lock.RLock()
 		conn = binding.pool.get()
 		currVersion := binding.dsn.connVersion
 		binding.lock.RUnlock()
 
-		if conn.hasError() {
+		if conn.isDraining() {
+			// Slot of the draining connection is connected again, while its in-flight requests are finishing
+			replaced, err := binding.replaceConn(ctx, conn)
+			if err == nil {
+				if replaced == nil {
+					// Slot was already replaced by another request
+					continue
+				}
+				return replaced, nil
+			}
+			// Server isn't available, so the pool is reconnected, probably to another DSN
+		}
+
+		if conn.isPingPending() {
+			if healthy := binding.healthyConn(); healthy != nil {
+				return healthy, nil
+			}
+		}
+
+		if !conn.isUsable() && binding.reconnectBackoff.Background {
+			// Failed connections are re-dialed in background, requests are sent via the rest of the pool
+			return binding.awaitUsableConn(ctx)
+		}
+
+		if !conn.isUsable() {
 			binding.lock.Lock()
 			if currVersion == binding.dsn.connVersion {
 				if logger != nil {
@@ -454,65 +970,241 @@ func (binding *NetCProto) getConn(ctx context.Context) (conn *connection, err er
 			} else {
 				conn = binding.pool.get()
 				binding.lock.Unlock()
-				if conn.hasError() {
+				if !conn.isUsable() {
 					continue
 				}
 				return conn, nil
 			}
 		}
 
+		if binding.failOnQueueFull && len(conn.seqs) == 0 {
+			if free := binding.freeSlotConn(); free != nil {
+				return free, nil
+			}
+		}
 		return conn, nil
 	}
 }
 
-func (binding *NetCProto) connectDSN(ctx context.Context, connPoolSize int) error {
+// freeSlotConn returns usable connection of the pool, which has the most free request slots, or nil, if they are all busy
+func (binding *NetCProto) freeSlotConn() *connection {
+	binding.lock.RLock()
+	defer binding.lock.RUnlock()
+	var best *connection
+	for _, conn := range binding.pool.conns {
+		if conn == nil || !conn.isUsable() || conn.isPingPending() || len(conn.seqs) == 0 {
+			continue
+		}
+		if best == nil || len(conn.seqs) > len(best.seqs) {
+			best = conn
+		}
+	}
+	return best
+}
+
+// healthyConn returns usable connection of the pool, which has no keepalive ping in flight, or nil
+func (binding *NetCProto) healthyConn() *connection {
+	binding.lock.RLock()
+	defer binding.lock.RUnlock()
+	for _, conn := range binding.pool.conns {
+		if conn.isUsable() && !conn.isPingPending() {
+			return conn
+		}
+	}
+	return nil
+}
+
+// laneConn returns connection of the pool, which is assigned to write lane, or nil, if it's not usable
+func (binding *NetCProto) laneConn(lane int) *connection {
+	binding.lock.RLock()
+	defer binding.lock.RUnlock()
+	if len(binding.pool.conns) == 0 || lane < 0 {
+		return nil
+	}
+	if conn := binding.pool.conns[lane%len(binding.pool.conns)]; conn != nil && conn.isUsable() {
+		return conn
+	}
+	return nil
+}
+
+// replaceConn connects new connection instead of the draining one in its slot of the pool and finalizes the old one after its in-flight requests.
+// Returns nil connection, if the slot is already replaced
+func (binding *NetCProto) replaceConn(ctx context.Context, old *connection) (*connection, error) {
+	binding.lock.RLock()
+	slot, dsn := binding.pool.slotOf(old), binding.getActiveDSN()
+	binding.lock.RUnlock()
+	if slot < 0 {
+		return nil, nil
+	}
+	// Dial is made without lock, so requests to the other connections are not blocked
+	conn, err := newConnection(ctx, binding, dsn)
+	if err != nil {
+		conn.Finalize()
+		return nil, err
+	}
+	binding.lock.Lock()
+	if slot = binding.pool.slotOf(old); slot >= 0 {
+		// Slice may be iterated by getAllConns callers, so it's copied
+		binding.pool.conns = append([]*connection(nil), binding.pool.conns...)
+		binding.pool.conns[slot] = conn
+		binding.pool.addReconnect(slot)
+	}
+	binding.lock.Unlock()
+	if slot < 0 {
+		conn.Finalize()
+		return nil, nil
+	}
+	go old.Finalize()
+	if conn.isServerChanged && binding.onChangeCallback != nil {
+		binding.onChangeCallback()
+	}
+	return conn, nil
+}
+
+// onConnLost reports disconnect of connection, which was closed not by the client.
+// Slot of connection, which is closed by server shutdown, is connected again, so the next requests don't wait for reconnect
+func (binding *NetCProto) onConnLost(c *connection, reason string, err error) {
+	if binding.onDisconnect != nil {
+		binding.onDisconnect(bindings.DisconnectEvent{Addr: c.addr, Reason: reason, Err: err})
+	}
+	if reason == bindings.DisconnectServerShutdown {
+		binding.replaceConn(context.Background(), c)
+	} else if binding.reconnectBackoff.Background {
+		binding.lock.Lock()
+		binding.startRedial()
+		binding.lock.Unlock()
+	}
+}
+
+// connectDSN returns pool, connected to the first available DSN, or the last failed pool
+func (binding *NetCProto) connectDSN(ctx context.Context, connPoolSize int) (p pool, err error) {
 	errWrap := errors.New("failed to connect with provided dsn")
+	var lastErr error
 	for i := 0; i < len(binding.dsn.url); i++ {
-		err := binding.newPool(ctx, connPoolSize)
+		p, err = binding.newPool(ctx, connPoolSize)
 		if err != nil {
+			if logger != nil && len(binding.dsn.url) > 1 {
+				_, addr, _ := dsnAddr(binding.getActiveDSN())
+				logger.Printf(3, "rq: failed to connect to %s, trying the next DSN: %s\n", addr, err.Error())
+			}
 			binding.nextDSN()
 			errWrap = fmt.Errorf("%s; %s", errWrap, err)
+			lastErr = err
 			continue
 		}
 
-		errWrap = nil
-		break
+		return p, nil
 	}
 
-	return errWrap
+	return p, bindings.NewError(errWrap.Error(), connectErrCode(lastErr))
+}
+
+// connectErrCode keeps the class of the connection error (auth failure, network error, etc), when the error is wrapped
+func connectErrCode(err error) int {
+	if err == context.DeadlineExceeded {
+		return bindings.ErrTimeout
+	}
+	if rerr, ok := err.(bindings.Error); ok {
+		return rerr.Code()
+	}
+	return bindings.ErrNetwork
 }
 
 func (binding *NetCProto) reconnect(ctx context.Context) (conn *connection, err error) {
+	if binding.reconnectErr != nil && time.Now().Before(binding.reconnectAfter) {
+		return nil, binding.reconnectErr
+	}
 	if binding.dsn.connTry < 100 {
 		binding.dsn.connTry++
 	}
 
-	err = binding.connectDSN(ctx, len(binding.pool.conns))
+	oldPool := binding.pool
+	oldConns := binding.pool.conns
+	binding.pool, err = binding.connectDSN(ctx, binding.poolSize)
+	binding.pool.inheritReconnects(&oldPool)
+	for _, conn := range oldConns {
+		// Healthy connections of the old pool may still have requests in flight
+		go conn.Finalize()
+	}
 	if err != nil {
+		binding.delayReconnect(err)
 		time.Sleep(time.Duration(binding.dsn.connTry) * time.Millisecond)
 	} else {
 		binding.dsn.connTry = 0
+		binding.reconnectDelay, binding.reconnectErr = 0, nil
 	}
 	conn = binding.pool.get()
 
 	return conn, err
 }
 
+// delayReconnect schedules the next reconnect attempt according to reconnect backoff. Must be called under lock
+func (binding *NetCProto) delayReconnect(err error) {
+	backoff := binding.reconnectBackoff
+	if backoff.Min <= 0 {
+		return
+	}
+	if binding.reconnectDelay == 0 {
+		binding.reconnectDelay = backoff.Min
+	} else if binding.reconnectDelay *= 2; backoff.Max > 0 && binding.reconnectDelay > backoff.Max {
+		binding.reconnectDelay = backoff.Max
+	}
+	binding.reconnectAfter = time.Now().Add(jitterDelay(binding.reconnectDelay, backoff.Jitter))
+	binding.reconnectErr = err
+}
+
 func (binding *NetCProto) rpcCall(ctx context.Context, op int, cmd int, args ...interface{}) (buf *NetBuffer, err error) {
+	// Options are taken once, so the request isn't affected by concurrent UpdateOptions
+	tun := binding.tuned()
 	var attempts int
 	switch op {
-	case opRd:
-		attempts = binding.retryAttempts.Read + 1
+	case opRd, opBulk:
+		attempts = tun.retryAttempts.Read + 1
 	default:
-		attempts = binding.retryAttempts.Write + 1
+		attempts = tun.retryAttempts.Write + 1
+	}
+	if conn := binding.pinnedConn(ctx); conn != nil {
+		// Request of connect hook is sent without retries via the connection, which is being connected
+		buf, err = conn.rpcCall(ctx, cmd, tun.timeouts.RequestTimeout, args...)
+		return
 	}
+	requeued := 0
 	for i := 0; i < attempts; i++ {
-		var conn *connection
-		if conn, err = binding.getConn(ctx); err == nil {
-			if buf, err = conn.rpcCall(ctx, cmd, uint32(binding.timeouts.RequestTimeout/time.Second), args...); err == nil {
+		var host string
+		var probe bool
+		var sent bool
+		if binding.breakers.enabled() {
+			host = binding.activeHost()
+			if probe, err = binding.breakers.allow(host); err != nil {
 				return
 			}
 		}
+		var conn *connection
+		if op == opBulk {
+			conn, err = binding.getBulkConn(ctx)
+		} else {
+			conn, err = binding.getConn(ctx)
+		}
+		if err == nil {
+			buf, sent, err = conn.rpcCallSent(ctx, cmd, tun.timeouts.RequestTimeout, args...)
+		}
+		binding.breakers.done(host, probe, err)
+		if err == nil {
+			return
+		}
+		if binding.requeue.Attempts > 0 && sent && err == conn.curError() {
+			// Connection is broken after the request was sent
+			if !isIdempotentCmd(cmd) || requeued >= binding.requeue.Attempts {
+				return nil, brokenMidRequestError(cmd, err)
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			requeued++
+			atomic.AddInt64(&binding.traffic.requeuedRequests, 1)
+			i--
+			continue
+		}
 		switch err.(type) {
 		case net.Error, *net.OpError:
 			select {
@@ -534,26 +1226,33 @@ func (binding *NetCProto) rpcCallNoResults(ctx context.Context, op int, cmd int,
 	return err
 }
 
-func (binding *NetCProto) pinger() {
+func (binding *NetCProto) pinger(termCh chan struct{}) {
+	if binding.pingInterval.Interval > 0 {
+		// Connections are pinged by their own keepalive goroutines
+		return
+	}
 	timeout := time.Second
 	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
 	var ticksCount uint16
-	for now := range ticker.C {
-		ticksCount++
+	for {
+		var now time.Time
 		select {
-		case <-binding.termCh:
+		case <-termCh:
 			return
-		default:
+		case now = <-ticker.C:
 		}
+		ticksCount++
 		if ticksCount == pingerTimeoutSec {
 			ticksCount = 0
 			conns := binding.getAllConns()
+			conns = append(conns[:len(conns):len(conns)], binding.bulk.getAll()...)
 			for _, conn := range conns {
 				if conn.hasError() {
 					continue
 				}
 				if conn.lastReadTime().Add(timeout).Before(now) {
-					buf, _ := conn.rpcCall(context.TODO(), cmdPing, uint32(binding.timeouts.RequestTimeout*time.Second))
+					buf, _ := conn.rpcCall(context.TODO(), cmdPing, binding.tuned().timeouts.RequestTimeout)
 					buf.Free()
 				}
 			}
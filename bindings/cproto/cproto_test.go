@@ -1,16 +1,39 @@
 package cproto
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"net"
 	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unsafe"
 
+	"github.com/golang/snappy"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
 	"github.com/restream/reindexer/test/helpers"
 )
 
@@ -44,6 +67,761 @@ func BenchmarkGetConn(b *testing.B) {
 
 }
 
+func BenchmarkPackRPC(b *testing.B) {
+	data := make([]byte, 100)
+	for _, snappy := range []int32{0, 1} {
+		b.Run(fmt.Sprintf("snappy=%d", snappy), func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				c := &connection{owner: &NetCProto{}, wrCh: make(chan *rpcEncoder, 1), enableSnappy: snappy}
+				for pb.Next() {
+					c.packRPC(context.Background(), cmdModifyItem, 1, 0, "namespace", bindings.FormatCJson, data, bindings.ModeUpsert, []byte(nil), 0, 0)
+					(<-c.wrCh).release()
+				}
+			})
+		})
+	}
+}
+
+// runFakeRPCServer answers 'OK' with single empty string arg on each request. onFrame (if not nil) is called with header of each request
+func runFakeRPCServer(tb testing.TB, onFrame func(cmd int, version uint16)) (*url.URL, func()) {
+	return runFakeRPCServerFunc(tb, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if onFrame != nil {
+			onFrame(int(cmd), version)
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+}
+
+// runFakeRPCServerFunc calls onFrame with each request and its 'OK' reply, which has single empty string arg.
+// onFrame writes replies to conn, body and reply are valid only until it returns
+func runFakeRPCServerFunc(tb testing.TB, onFrame func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error) (*url.URL, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	return serveFakeRPC(l, onFrame)
+}
+
+// serveFakeRPC serves cproto connections of l like runFakeRPCServerFunc
+func serveFakeRPC(l net.Listener, onFrame func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error) (*url.URL, func()) {
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				hdr := make([]byte, cprotoHdrLen)
+				body := make([]byte, 0, 0x100)
+				reply := make([]byte, 0, 0x100)
+				for {
+					if _, err := io.ReadFull(rd, hdr); err != nil {
+						return
+					}
+					in := newRPCDecoder(hdr)
+					in.ser.GetUInt32()
+					version := in.ser.GetUInt16()
+					cmd := in.ser.GetUInt16()
+					size := int(in.ser.GetUInt32())
+					seq := in.ser.GetUInt32()
+					if cap(body) < size {
+						body = make([]byte, size)
+					}
+					body = body[:size]
+					if _, err := io.ReadFull(rd, body); err != nil {
+						return
+					}
+					reply = fakeRPCReply(reply[:0], cmd, seq, "")
+					if err := onFrame(conn, cmd, version, seq, body, reply); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "cproto", Host: l.Addr().String(), Path: "/db"}, func() { l.Close() }
+}
+
+// fakeRPCReply appends 'OK' reply with single string arg to dst
+func fakeRPCReply(dst []byte, cmd uint16, seq uint32, arg string) []byte {
+	out := cjson.NewSerializer(dst)
+	out.PutUInt32(cprotoMagic)
+	out.PutUInt16(cprotoVersion)
+	out.PutUInt16(cmd)
+	out.PutUInt32(0)
+	out.PutUInt32(seq)
+	out.PutVarUInt(0)
+	out.PutVString("")
+	out.PutVarUInt(1)
+	out.PutVarUInt(uint64(bindings.ValueString))
+	out.PutVString(arg)
+	*(*uint32)(unsafe.Pointer(&out.Bytes()[len(dst)+8])) = uint32(len(out.Bytes()) - len(dst) - cprotoHdrLen)
+	return out.Bytes()
+}
+
+func BenchmarkModifyItem(b *testing.B) {
+	u, stop := runFakeRPCServer(b, nil)
+	defer stop()
+
+	for _, compression := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compression=%v", compression), func(b *testing.B) {
+			binding := NetCProto{}
+			require.NoError(b, binding.Init([]url.URL{*u}, bindings.OptionCompression{EnableCompression: compression}))
+			defer binding.Finalize()
+
+			data := make([]byte, 100)
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					buf, err := binding.ModifyItem(ctx, 0, "namespace", bindings.FormatCJson, data, bindings.ModeUpsert, nil, 0)
+					if err != nil {
+						panic(err)
+					}
+					buf.Free()
+				}
+			})
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	t.Run("encoder", func(t *testing.T) {
+		const threshold = 300
+		for _, size := range []int{threshold, threshold + 1} {
+			enc := newRPCEncoder(cmdModifyItem, 1, true)
+			// Body is args count, arg type, data length and data
+			enc.bytesArg(make([]byte, size-4))
+			require.Equal(t, size, len(enc.ser.Bytes())-cprotoHdrLen)
+			compressed := enc.finish(threshold)
+			assert.Equal(t, size > threshold, compressed, "request of size %d", size)
+			version := *(*uint16)(unsafe.Pointer(&enc.ser.Bytes()[4]))
+			assert.Equal(t, compressed, (version&cprotoVersionCompressionFlag) != 0)
+			enc.release()
+		}
+	})
+
+	frames := make(chan bool, defQueueSize)
+	u, stop := runFakeRPCServer(t, func(cmd int, version uint16) {
+		if cmd == cmdModifyItem {
+			frames <- (version & cprotoVersionCompressionFlag) != 0
+		}
+	})
+	defer stop()
+
+	// modify returns, whether request with data of size was compressed
+	modify := func(binding *NetCProto, ctx context.Context, size int) bool {
+		buf, err := binding.ModifyItem(ctx, 0, "namespace", bindings.FormatCJson, make([]byte, size), bindings.ModeUpsert, nil, 0)
+		require.NoError(t, err)
+		buf.Free()
+		return <-frames
+	}
+	noCompression := bindings.ContextWithCompression(context.Background(), bindings.CompressionDisabled)
+	forceCompression := bindings.ContextWithCompression(context.Background(), bindings.CompressionForced)
+
+	t.Run("threshold", func(t *testing.T) {
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionCompression{EnableCompression: true, Threshold: 1000}))
+		defer binding.Finalize()
+
+		assert.False(t, modify(binding, context.Background(), 900))
+		assert.True(t, modify(binding, context.Background(), 1100))
+		assert.False(t, modify(binding, noCompression, 1100))
+		assert.True(t, modify(binding, forceCompression, 10))
+
+		status := binding.Status(context.Background()).CProto
+		assert.True(t, status.UncompressedBytes > 900+1100, "uncompressed bytes: %d", status.UncompressedBytes)
+		assert.True(t, status.CompressedSrcBytes > 1100+10, "compressed src bytes: %d", status.CompressedSrcBytes)
+		assert.True(t, status.CompressedBytes > 0, "compressed bytes: %d", status.CompressedBytes)
+	})
+
+	t.Run("compression is disabled", func(t *testing.T) {
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}))
+		defer binding.Finalize()
+
+		assert.False(t, modify(binding, context.Background(), 1100))
+		assert.True(t, modify(binding, forceCompression, 1100))
+	})
+}
+
+// runFakeResultsServer runs server of version, which replies to selects by results and compresses replies of compressed requests like reindexer.
+// Compression flags of select requests are sent to the returned channel, if it's not full. mangle changes compressed body of select reply
+func runFakeResultsServer(tb testing.TB, version uint16, results string, mangle func(body []byte) []byte) (*url.URL, func(), chan bool) {
+	body := fakeRPCReplyArgs(cmdSelect, 0, func(out *cjson.Serializer) int {
+		out.PutVarUInt(uint64(bindings.ValueString))
+		out.PutVString(results)
+		out.PutVarUInt(uint64(bindings.ValueInt))
+		out.PutVarInt(1)
+		return 2
+	})[cprotoHdrLen:]
+	compressedBody := snappy.Encode(nil, body)
+	if mangle != nil {
+		compressedBody = mangle(compressedBody)
+	}
+	frames := make(chan bool, defQueueSize)
+	u, stop := runFakeRPCServerFunc(tb, func(conn net.Conn, cmd, reqVersion uint16, seq uint32, reqBody, reply []byte) error {
+		compressed := (reqVersion & cprotoVersionCompressionFlag) != 0
+		data := reply[cprotoHdrLen:]
+		if cmd == cmdSelect {
+			select {
+			case frames <- compressed:
+			default:
+			}
+			data = body
+			if compressed {
+				data = compressedBody
+			}
+		} else if compressed {
+			data = snappy.Encode(nil, data)
+		}
+		replyVersion := version
+		if compressed {
+			replyVersion |= cprotoVersionCompressionFlag
+		}
+		out := cjson.NewSerializer(nil)
+		out.PutUInt32(cprotoMagic)
+		out.PutUInt16(replyVersion)
+		out.PutUInt16(cmd)
+		out.PutUInt32(uint32(len(data)))
+		out.PutUInt32(seq)
+		out.Write(data)
+		_, err := conn.Write(out.Bytes())
+		return err
+	})
+	return u, stop, frames
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	results := strings.Repeat(`{"id":1,"name":"item","tags":["a","b"]}`, 1000)
+	// selectResults returns results of select and whether its request was compressed
+	selectResults := func(binding *NetCProto, ctx context.Context, frames chan bool) (string, bool) {
+		buf, err := binding.SelectQuery(ctx, []byte{}, false, nil, 1)
+		require.NoError(t, err)
+		defer buf.Free()
+		return string(buf.GetBuf()), <-frames
+	}
+	forceCompression := bindings.ContextWithCompression(context.Background(), bindings.CompressionForced)
+
+	t.Run("supported by server", func(t *testing.T) {
+		u, stop, frames := runFakeResultsServer(t, cprotoVersion, results, nil)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionCompression{EnableCompression: true}))
+		defer binding.Finalize()
+
+		res, compressed := selectResults(binding, context.Background(), frames)
+		assert.True(t, compressed)
+		assert.Equal(t, results, res)
+		status := binding.Status(context.Background()).CProto
+		assert.True(t, status.CompressedSrcBytes > int64(len(results)), "compressed src bytes: %d", status.CompressedSrcBytes)
+	})
+
+	t.Run("disabled by client", func(t *testing.T) {
+		u, stop, frames := runFakeResultsServer(t, cprotoVersion, results, nil)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}))
+		defer binding.Finalize()
+
+		res, compressed := selectResults(binding, context.Background(), frames)
+		assert.False(t, compressed)
+		assert.Equal(t, results, res)
+	})
+
+	t.Run("not supported by server", func(t *testing.T) {
+		u, stop, frames := runFakeResultsServer(t, cprotoMinSnappyVersion-1, results, nil)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionCompression{EnableCompression: true}))
+		defer binding.Finalize()
+
+		// Requests and replies are not compressed, even if compression is forced
+		for _, ctx := range []context.Context{context.Background(), forceCompression} {
+			res, compressed := selectResults(binding, ctx, frames)
+			assert.False(t, compressed)
+			assert.Equal(t, results, res)
+		}
+		assert.Equal(t, int64(0), binding.Status(context.Background()).CProto.CompressedBytes)
+	})
+
+	t.Run("invalid compressed reply", func(t *testing.T) {
+		mangles := map[string]func(body []byte) []byte{
+			"truncated": func(body []byte) []byte { return body[:len(body)/2] },
+			"too large": func(body []byte) []byte {
+				out := make([]byte, binary.MaxVarintLen64)
+				return append(out[:binary.PutUvarint(out, cprotoMaxReplySize+1)], body[3:]...)
+			},
+		}
+		for name, mangle := range mangles {
+			u, stop, _ := runFakeResultsServer(t, cprotoVersion, results, mangle)
+			binding := &NetCProto{}
+			require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionCompression{EnableCompression: true}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+
+			_, err := binding.SelectQuery(context.Background(), []byte{}, false, nil, 1)
+			require.Error(t, err, name)
+			assert.Contains(t, err.Error(), "Invalid compressed cproto reply", name)
+			binding.Finalize()
+			stop()
+		}
+	})
+}
+
+func BenchmarkCompressedResults(b *testing.B) {
+	var results strings.Builder
+	for i := 0; results.Len() < 4*1024*1024; i++ {
+		fmt.Fprintf(&results, `{"id":%d,"name":"item %d","description":"description of the item","tags":["tag1","tag2"],"price":%d}`, i, i, i%1000)
+	}
+	u, stop, _ := runFakeResultsServer(b, cprotoVersion, results.String(), nil)
+	defer stop()
+
+	for _, compression := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compression=%v", compression), func(b *testing.B) {
+			binding := NetCProto{}
+			require.NoError(b, binding.Init([]url.URL{*u}, bindings.OptionCompression{EnableCompression: compression}))
+			defer binding.Finalize()
+
+			ctx := context.Background()
+			b.SetBytes(int64(results.Len()))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				buf, err := binding.SelectQuery(ctx, []byte{}, false, nil, 1)
+				if err != nil {
+					panic(err)
+				}
+				buf.Free()
+			}
+		})
+	}
+}
+
+// BenchmarkSelectReplies - select-heavy workload with results of different sizes, which are read into pooled reply buffers
+func BenchmarkSelectReplies(b *testing.B) {
+	var bodies, compressedBodies [][]byte
+	for size := 1024; size <= 256*1024; size *= 2 {
+		results := strings.Repeat(`{"id":1,"name":"item","tags":["a","b"]}`, size/40+1)
+		body := fakeRPCReplyArgs(cmdSelect, 0, func(out *cjson.Serializer) int {
+			out.PutVarUInt(uint64(bindings.ValueString))
+			out.PutVString(results)
+			out.PutVarUInt(uint64(bindings.ValueInt))
+			out.PutVarInt(-1)
+			return 2
+		})[cprotoHdrLen:]
+		bodies, compressedBodies = append(bodies, body), append(compressedBodies, snappy.Encode(nil, body))
+	}
+	u, stop := runFakeRPCServerFunc(b, func(conn net.Conn, cmd, reqVersion uint16, seq uint32, reqBody, reply []byte) error {
+		compressed := (reqVersion & cprotoVersionCompressionFlag) != 0
+		data := reply[cprotoHdrLen:]
+		version := uint16(cprotoVersion)
+		if cmd == cmdSelect {
+			if data = bodies[seq%uint32(len(bodies))]; compressed {
+				data = compressedBodies[seq%uint32(len(bodies))]
+			}
+		} else if compressed {
+			data = snappy.Encode(nil, data)
+		}
+		if compressed {
+			version |= cprotoVersionCompressionFlag
+		}
+		// Header is written separately, so server doesn't allocate copies of results
+		out := cjson.NewSerializer(reply[:0])
+		out.PutUInt32(cprotoMagic)
+		out.PutUInt16(version)
+		out.PutUInt16(cmd)
+		out.PutUInt32(uint32(len(data)))
+		out.PutUInt32(seq)
+		if _, err := conn.Write(out.Bytes()); err != nil {
+			return err
+		}
+		_, err := conn.Write(data)
+		return err
+	})
+	defer stop()
+
+	for _, compression := range []bool{false, true} {
+		b.Run(fmt.Sprintf("compression=%v", compression), func(b *testing.B) {
+			binding := NetCProto{}
+			require.NoError(b, binding.Init([]url.URL{*u}, bindings.OptionCompression{EnableCompression: compression}))
+			defer binding.Finalize()
+
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					buf, err := binding.SelectQuery(ctx, []byte{}, false, nil, 1)
+					if err != nil {
+						panic(err)
+					}
+					buf.Free()
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkPointLookupReply measures processing of reply with results of point lookup, which buffer is reused from pool
+func BenchmarkPointLookupReply(b *testing.B) {
+	c := &connection{owner: &NetCProto{}, errCh: make(chan struct{}), termCh: make(chan struct{})}
+	body := fakeRPCReplyArgs(cmdSelect, 0, func(out *cjson.Serializer) int {
+		out.PutVarUInt(uint64(bindings.ValueString))
+		out.PutVString(`{"id":1,"name":"item","tags":["a","b"]}`)
+		out.PutVarUInt(uint64(bindings.ValueInt))
+		out.PutVarInt(1000)
+		return 2
+	})[cprotoHdrLen:]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := newNetBuffer(len(body), c)
+		copy(buf.buf, body)
+		buf.cmd = cmdSelect
+		if err := buf.parseArgs(); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := buf.resultsID(); err != nil {
+			b.Fatal(err)
+		}
+		if len(buf.GetBuf()) == 0 {
+			b.Fatal("empty results")
+		}
+		buf.Free()
+	}
+}
+
+// countingConn counts socket writes
+type countingConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+func (c *countingConn) WriteBuffers(bufs *net.Buffers) (int64, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return bufs.WriteTo(c.Conn)
+}
+
+// newWriteTestConnection returns connection, which only writes requests to the local TCP socket, and server side of this socket
+func newWriteTestConnection(tb testing.TB, coalescing bindings.OptionWriteCoalescing) (*connection, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err)
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(tb, err)
+	srvConn, err := l.Accept()
+	require.NoError(tb, err)
+
+	c := &connection{
+		owner:  &NetCProto{writeCoalescing: coalescing},
+		conn:   &countingConn{Conn: conn},
+		errCh:  make(chan struct{}),
+		termCh: make(chan struct{}),
+	}
+	c.initRequests(defQueueSize)
+	go c.writeLoop()
+	return c, srvConn
+}
+
+// newPipeTestConnection returns connection, which writes to peer of synchronous pipe, so frames aren't written, while peer doesn't read
+func newPipeTestConnection(limit bindings.OptionWriteLimit) (*connection, net.Conn) {
+	conn, peer := net.Pipe()
+	c := &connection{
+		owner:  &NetCProto{writeLimit: limit},
+		conn:   conn,
+		errCh:  make(chan struct{}),
+		termCh: make(chan struct{}),
+	}
+	c.initRequests(defQueueSize)
+	go c.writeLoop()
+	return c, peer
+}
+
+func TestWriteLimit(t *testing.T) {
+	const maxPending = 1000
+	payload := make([]byte, 300)
+
+	t.Run("fail connection", func(t *testing.T) {
+		c, peer := newPipeTestConnection(bindings.OptionWriteLimit{MaxPending: maxPending})
+		defer peer.Close()
+		for i := 0; i < defQueueSize && c.curError() == nil; i++ {
+			c.packRPC(context.Background(), cmdSelect, uint32(i), 0, payload)
+			assert.True(t, atomic.LoadInt64(&c.pendingWrites) <= maxPending)
+		}
+		assert.Equal(t, errWriteLimit, c.curError())
+		// Writes to the failed connection don't block
+		c.packRPC(context.Background(), cmdSelect, 0, 0, payload)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		const blockTimeout = 50 * time.Millisecond
+		c, peer := newPipeTestConnection(bindings.OptionWriteLimit{MaxPending: maxPending, BlockTimeout: blockTimeout})
+		defer peer.Close()
+		defer c.onError(errConnClosed)
+		enc := c.encodeRPC(context.Background(), cmdSelect, 0, 0, payload)
+		frameSize := int64(len(enc.ser.Bytes()))
+		enc.release()
+		queued := int(maxPending / frameSize)
+		for i := 0; i < queued; i++ {
+			c.packRPC(context.Background(), cmdSelect, uint32(i), 0, payload)
+		}
+		start := time.Now()
+		c.packRPC(context.Background(), cmdSelect, uint32(queued), 0, payload)
+		assert.True(t, time.Since(start) >= blockTimeout, "frame doesn't wait for free space")
+		require.NoError(t, c.curError())
+		assert.Equal(t, int64(queued)*frameSize, atomic.LoadInt64(&c.pendingWrites))
+
+		// Frames are queued again, once peer reads
+		go io.Copy(ioutil.Discard, peer)
+		start = time.Now()
+		for i := 0; i < 2*queued; i++ {
+			c.packRPC(context.Background(), cmdSelect, uint32(queued+1+i), 0, payload)
+		}
+		assert.True(t, time.Since(start) < blockTimeout)
+		require.NoError(t, c.curError())
+	})
+
+	t.Run("write timeout", func(t *testing.T) {
+		const writeTimeout = 50 * time.Millisecond
+		c, peer := newPipeTestConnection(bindings.OptionWriteLimit{WriteTimeout: writeTimeout})
+		defer peer.Close()
+		c.packRPC(context.Background(), cmdSelect, 0, 0, payload)
+		select {
+		case <-c.errCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("stuck write doesn't fail connection")
+		}
+		netErr, ok := c.curError().(net.Error)
+		require.True(t, ok, "unexpected error %v", c.curError())
+		assert.True(t, netErr.Timeout())
+	})
+}
+
+func TestWriteQueueFrames(t *testing.T) {
+	const writers = 128
+	const frames = 200
+	c, srvConn := newWriteTestConnection(t, bindings.OptionWriteCoalescing{})
+	defer srvConn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		rd := bufio.NewReader(srvConn)
+		hdr := make([]byte, cprotoHdrLen)
+		next := make([]int, writers)
+		for n := 0; n < writers*frames; n++ {
+			if _, err := io.ReadFull(rd, hdr); err != nil {
+				done <- err
+				return
+			}
+			in := newRPCDecoder(hdr)
+			if magic := in.ser.GetUInt32(); magic != cprotoMagic {
+				done <- fmt.Errorf("frame %d: invalid magic %08X", n, magic)
+				return
+			}
+			in.ser.GetUInt16()
+			in.ser.GetUInt16()
+			body := make([]byte, in.ser.GetUInt32())
+			seq := int(in.ser.GetUInt32())
+			if _, err := io.ReadFull(rd, body); err != nil {
+				done <- err
+				return
+			}
+			dec := newRPCDecoder(body)
+			dec.argsCount()
+			w, i, payload := dec.intArg(), dec.intArg(), dec.bytesArg()
+			// Frames of each writer must come in order and must not be interleaved with other frames
+			if w*frames+i != seq || i != next[w] || !bytes.Equal(payload, bytes.Repeat([]byte{byte(w)}, i)) {
+				done <- fmt.Errorf("frame %d: corrupted frame of writer %d: seq %d, index %d (expected %d), payload len %d", n, w, seq, i, next[w], len(payload))
+				return
+			}
+			next[w]++
+		}
+		done <- nil
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < frames; i++ {
+				c.packRPC(context.Background(), cmdSelect, uint32(w*frames+i), 0, w, i, bytes.Repeat([]byte{byte(w)}, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("frames are not received")
+	}
+
+	c.onError(errConnClosed)
+	// Writes to the dead connection must not block
+	c.packRPC(context.Background(), cmdSelect, 0, 0)
+}
+
+func BenchmarkWriteQueue(b *testing.B) {
+	c, srvConn := newWriteTestConnection(b, bindings.OptionWriteCoalescing{})
+	defer srvConn.Close()
+	defer c.onError(errConnClosed)
+	go io.Copy(ioutil.Discard, srvConn)
+
+	data := make([]byte, 100)
+	// 128 concurrent writers
+	if p := 128 / runtime.GOMAXPROCS(0); p > 1 {
+		b.SetParallelism(p)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.packRPC(context.Background(), cmdModifyItem, 1, 0, "namespace", bindings.FormatCJson, data, bindings.ModeUpsert, []byte(nil), 0, 0)
+		}
+	})
+}
+
+// readFrameSeqs reads frames from conn and sends their seq numbers to the returned channel
+func readFrameSeqs(conn net.Conn) chan uint32 {
+	seqs := make(chan uint32, defQueueSize)
+	go func() {
+		defer close(seqs)
+		rd := bufio.NewReader(conn)
+		hdr := make([]byte, cprotoHdrLen)
+		for {
+			if _, err := io.ReadFull(rd, hdr); err != nil {
+				return
+			}
+			in := newRPCDecoder(hdr)
+			in.ser.GetUInt32()
+			in.ser.GetUInt16()
+			in.ser.GetUInt16()
+			size := in.ser.GetUInt32()
+			seq := in.ser.GetUInt32()
+			if _, err := io.CopyN(ioutil.Discard, rd, int64(size)); err != nil {
+				return
+			}
+			seqs <- seq
+		}
+	}()
+	return seqs
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	recvSeqs := func(t *testing.T, seqs chan uint32, n int, timeout time.Duration) (res []uint32) {
+		deadline := time.After(timeout)
+		for len(res) < n {
+			select {
+			case seq := <-seqs:
+				res = append(res, seq)
+			case <-deadline:
+				t.Fatalf("only %d of %d frames are received", len(res), n)
+			}
+		}
+		return
+	}
+
+	t.Run("delay", func(t *testing.T) {
+		c, srvConn := newWriteTestConnection(t, bindings.OptionWriteCoalescing{Delay: 50 * time.Millisecond})
+		defer srvConn.Close()
+		defer c.onError(errConnClosed)
+		seqs := readFrameSeqs(srvConn)
+
+		start := time.Now()
+		for i := 0; i < 10; i++ {
+			c.packRPC(context.Background(), cmdSelect, uint32(i), 0)
+		}
+		assert.Equal(t, []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, recvSeqs(t, seqs, 10, 5*time.Second))
+		assert.True(t, time.Since(start) >= 50*time.Millisecond)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&c.conn.(*countingConn).writes))
+	})
+
+	t.Run("urgent", func(t *testing.T) {
+		c, srvConn := newWriteTestConnection(t, bindings.OptionWriteCoalescing{Delay: time.Hour})
+		defer srvConn.Close()
+		defer c.onError(errConnClosed)
+		seqs := readFrameSeqs(srvConn)
+
+		c.packRPC(context.Background(), cmdSelect, 1, 0)
+		c.packRPC(context.Background(), cmdSelect, 2, 0)
+		c.packRPC(context.Background(), cmdPing, 3, 0)
+		assert.Equal(t, []uint32{1, 2, 3}, recvSeqs(t, seqs, 3, 5*time.Second))
+		assert.Equal(t, int64(1), atomic.LoadInt64(&c.conn.(*countingConn).writes))
+	})
+
+	t.Run("max pending", func(t *testing.T) {
+		c, srvConn := newWriteTestConnection(t, bindings.OptionWriteCoalescing{Delay: time.Hour, MaxPending: 1000})
+		defer srvConn.Close()
+		defer c.onError(errConnClosed)
+		seqs := readFrameSeqs(srvConn)
+
+		c.packRPC(context.Background(), cmdSelect, 1, 0, make([]byte, 600))
+		c.packRPC(context.Background(), cmdSelect, 2, 0, make([]byte, 600))
+		assert.Equal(t, []uint32{1, 2}, recvSeqs(t, seqs, 2, 5*time.Second))
+	})
+
+	t.Run("connection error", func(t *testing.T) {
+		c, srvConn := newWriteTestConnection(t, bindings.OptionWriteCoalescing{Delay: time.Hour})
+		defer srvConn.Close()
+
+		c.packRPC(context.Background(), cmdSelect, 1, 0)
+		c.onError(errConnClosed)
+		// Writes to the dead connection must not block
+		for i := 0; i < 2*defQueueSize; i++ {
+			c.packRPC(context.Background(), cmdSelect, 1, 0)
+		}
+	})
+}
+
+// BenchmarkWriteCoalescing shows number of socket writes per request and latency of request (time until it's received by the server).
+// Each writer waits for its request to be received before sending the next one, i.e. like rpcCall waits for reply
+func BenchmarkWriteCoalescing(b *testing.B) {
+	for _, delay := range []time.Duration{0, 100 * time.Microsecond, 500 * time.Microsecond} {
+		for _, writers := range []int{1, 64} {
+			b.Run(fmt.Sprintf("delay=%v/writers=%d", delay, writers), func(b *testing.B) {
+				c, srvConn := newWriteTestConnection(b, bindings.OptionWriteCoalescing{Delay: delay})
+				defer srvConn.Close()
+				defer c.onError(errConnClosed)
+
+				received := make([]chan struct{}, writers)
+				for w := range received {
+					received[w] = make(chan struct{}, 1)
+				}
+				go func() {
+					for seq := range readFrameSeqs(srvConn) {
+						received[seq] <- struct{}{}
+					}
+				}()
+
+				data := make([]byte, 100)
+				var nextWriter uint32
+				if p := writers / runtime.GOMAXPROCS(0); p > 1 {
+					b.SetParallelism(p)
+				}
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					w := atomic.AddUint32(&nextWriter, 1) - 1
+					for pb.Next() {
+						c.packRPC(context.Background(), cmdModifyItem, w, 0, "namespace", bindings.FormatCJson, data, bindings.ModeUpsert, []byte(nil), 0, 0)
+						<-received[w]
+					}
+				})
+				b.StopTimer()
+				b.ReportMetric(float64(atomic.LoadInt64(&c.conn.(*countingConn).writes))/float64(b.N), "writes/op")
+			})
+		}
+	}
+}
+
 func TestCprotoPool(t *testing.T) {
 	t.Run("success connection", func(t *testing.T) {
 		t.Skip("think about mock login")
@@ -92,39 +870,3488 @@ func TestCprotoPool(t *testing.T) {
 
 }
 
-func runTestServer() (s *testServer, addr *url.URL, err error) {
-	startPort := 40000
-	var l net.Listener
-	var port int
-	for port = startPort; port < startPort+10; port++ {
-		if l, err = net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
-			break
+func TestFetchOnBrokenConnection(t *testing.T) {
+	c := &connection{owner: &NetCProto{}, errCh: make(chan struct{}), termCh: make(chan struct{})}
+	c.onError(errConnClosed)
+
+	buf := newNetBuffer(0, c)
+	buf.reqID = 5
+	err := buf.Fetch(context.Background(), 300, 100, false)
+	lost, ok := err.(*bindings.ErrResultsLostOnReconnect)
+	require.True(t, ok, "unexpected error: %v", err)
+	assert.Equal(t, 300, lost.Consumed)
+	assert.Equal(t, errConnClosed, lost.Err)
+	assert.Equal(t, bindings.ErrNetwork, lost.Code())
+	// Results are gone with connection, so they must not be closed via it
+	assert.False(t, buf.needClose())
+	buf.Free()
+}
+
+func TestNetBufferReuse(t *testing.T) {
+	b := growBytes(nil, 100)
+	assert.Equal(t, 100, len(b))
+	assert.Equal(t, netBufMinCap, cap(b))
+	assert.Equal(t, &b[0], &growBytes(b, netBufMinCap)[0], "slice of enough capacity is reused")
+	assert.Equal(t, 4*netBufMinCap, cap(growBytes(b, 3*netBufMinCap)), "capacity is grown geometrically")
+
+	c := &connection{owner: &NetCProto{}, errCh: make(chan struct{}), termCh: make(chan struct{})}
+	body := fakeRPCReply(nil, cmdSelect, 0, "results")[cprotoHdrLen:]
+	buf := newNetBuffer(len(body), c)
+	copy(buf.buf, body)
+	require.NoError(t, buf.parseArgs())
+	assert.Equal(t, "results", string(buf.GetBuf()))
+	data := &buf.buf[0]
+	buf.Free()
+	assert.Equal(t, 0, buf.ArgsCount())
+	assert.Equal(t, data, &buf.buf[:1][0], "byte slice is kept for reuse")
+
+	buf = newNetBuffer(netBufMaxRetained+1, c)
+	buf.Free()
+	assert.Nil(t, buf.buf, "too large byte slice is not kept")
+}
+
+func TestNetBufferArgs(t *testing.T) {
+	c := &connection{owner: &NetCProto{}, errCh: make(chan struct{}), termCh: make(chan struct{})}
+	parse := func(putArgs func(out *cjson.Serializer) int) (*NetBuffer, error) {
+		body := fakeRPCReplyArgs(cmdSelect, 0, putArgs)[cprotoHdrLen:]
+		buf := newNetBuffer(len(body), c)
+		copy(buf.buf, body)
+		buf.cmd = cmdSelect
+		return buf, buf.parseArgs()
+	}
+	buf, err := parse(func(out *cjson.Serializer) int {
+		out.PutVarUInt(uint64(bindings.ValueString))
+		out.PutVString("results")
+		out.PutVarUInt(uint64(bindings.ValueInt))
+		out.PutVarInt(-1)
+		out.PutVarUInt(uint64(bindings.ValueInt64))
+		out.PutVarInt(1 << 40)
+		out.PutVarUInt(uint64(bindings.ValueBool))
+		out.PutVarInt(1)
+		out.PutVarUInt(uint64(bindings.ValueDouble))
+		out.PutDouble(1.5)
+		return 5
+	})
+	require.NoError(t, err)
+	defer buf.Free()
+
+	assert.Equal(t, 5, buf.ArgsCount())
+	b, err := buf.ArgBytes(0)
+	require.NoError(t, err)
+	assert.Equal(t, "results", string(b))
+	assert.Equal(t, &buf.buf[bytes.Index(buf.buf, []byte("results"))], &b[0], "string arg points into buffer")
+	s, err := buf.ArgString(0)
+	require.NoError(t, err)
+	assert.Equal(t, "results", s)
+	v, err := buf.ArgInt(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), v)
+	v, err = buf.ArgInt(2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<40), v)
+	assert.Equal(t, []interface{}{[]byte("results"), -1, int64(1 << 40), true, 1.5}, buf.Args())
+
+	_, err = buf.ArgInt(0)
+	assert.EqualError(t, err, "rq: Invalid reply to command Select: arg 0 has type string, but int64 is expected")
+	_, err = buf.ArgBytes(3)
+	assert.EqualError(t, err, "rq: Invalid reply to command Select: arg 3 has type bool, but string is expected")
+	_, err = buf.ArgInt(5)
+	assert.EqualError(t, err, "rq: Invalid reply to command Select: arg 5 of type int64 is expected, but reply has 5 args")
+
+	_, err = parse(func(out *cjson.Serializer) int {
+		out.PutVarUInt(100)
+		return 1
+	})
+	require.Error(t, err)
+	assert.Equal(t, bindings.ErrParseBin, err.(bindings.Error).Code())
+}
+
+func TestActivityLabel(t *testing.T) {
+	ctx := bindings.ContextWithActivityLabel(context.Background(), "req-1\n\"tenant\"=acme")
+	assert.Equal(t, "req-1__tenant_=acme", bindings.ActivityLabel(ctx))
+
+	long := bindings.ContextWithActivityLabel(context.Background(), strings.Repeat("ы", bindings.MaxActivityLabelLen))
+	assert.Equal(t, strings.Repeat("ы", bindings.MaxActivityLabelLen/2), bindings.ActivityLabel(long))
+
+	binding := NetCProto{}
+	assert.Equal(t, "", binding.activityLabel(context.Background()))
+	binding.labelFunc = func(ctx context.Context) string { return "trace=1\t2" }
+	assert.Equal(t, "trace=1_2", binding.activityLabel(context.Background()))
+	assert.Equal(t, "req-1__tenant_=acme", binding.activityLabel(ctx), "label of ctx has priority over label func")
+
+	// Label is the 2-nd arg of the context args chunk
+	c := &connection{owner: &binding, wrCh: make(chan *rpcEncoder, 1)}
+	c.packRPC(ctx, cmdSelect, 1, 100, 1)
+	out := newRPCDecoder((<-c.wrCh).ser.Bytes()[cprotoHdrLen:])
+	require.Equal(t, 1, out.argsCount())
+	assert.Equal(t, 1, out.intArg())
+	require.Equal(t, 2, out.argsCount())
+	assert.Equal(t, int64(100), out.intfArg())
+	assert.Equal(t, "req-1__tenant_=acme", out.stringArg())
+
+	binding.labelFunc = nil
+	c.packRPC(context.Background(), cmdSelect, 2, 100, 1)
+	out = newRPCDecoder((<-c.wrCh).ser.Bytes()[cprotoHdrLen:])
+	out.argsCount()
+	out.intArg()
+	assert.Equal(t, 1, out.argsCount(), "empty label is not sent")
+}
+
+func TestSeqNumAwait(t *testing.T) {
+	release := make(chan struct{})
+	var blocked int32
+	// The first write blocks server, so all the subsequent requests occupy request slots
+	u, stop := runFakeRPCServer(t, func(cmd int, version uint16) {
+		if cmd == cmdModifyItem && atomic.CompareAndSwapInt32(&blocked, 0, 1) {
+			<-release
 		}
+	})
+	defer stop()
+
+	const threshold = 40 * time.Millisecond
+	slow := make(chan bindings.SlowRPC, 2*defQueueSize)
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1},
+		bindings.OptionSlowRPC{Threshold: threshold, Hook: func(rpc bindings.SlowRPC) { slow <- rpc }}))
+	defer binding.Finalize()
+	conn, err := binding.getConn(context.Background())
+	require.NoError(t, err)
+
+	modify := func(ctx context.Context) error {
+		buf, err := binding.ModifyItem(ctx, 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0)
+		buf.Free()
+		return err
 	}
-	if err != nil {
-		return
+	errs := make(chan error, defQueueSize+1)
+	for i := 0; i < defQueueSize; i++ {
+		go func() { errs <- modify(context.Background()) }()
 	}
-	s = &testServer{l: l}
-	go s.acceptLoop()
-	addr, _ = url.Parse(fmt.Sprintf("cproto://127.0.0.1:%d", port))
-	return
+	for deadline := time.Now().Add(5 * time.Second); len(conn.seqs) != 0; time.Sleep(time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "request slots are not occupied")
+	}
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(bindings.ContextWithActivityLabel(context.Background(), "deadline"), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		assert.Equal(t, context.DeadlineExceeded, modify(ctx))
+		assert.True(t, time.Since(start) < 200*time.Millisecond, "request must not wait for the free slot after its deadline")
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		assert.Equal(t, context.Canceled, modify(ctx))
+	})
+
+	t.Run("broken connection", func(t *testing.T) {
+		c := &connection{owner: &NetCProto{}, seqs: make(chan uint32, 1), errCh: make(chan struct{})}
+		time.AfterFunc(10*time.Millisecond, func() { c.onError(errConnClosed) })
+		_, _, err := c.awaitSeqNum(context.Background())
+		assert.Equal(t, errConnClosed, err)
+	})
+
+	const queueWait = 100 * time.Millisecond
+	go func() { errs <- modify(context.Background()) }()
+	time.Sleep(queueWait)
+	close(release)
+	for i := 0; i < defQueueSize+1; i++ {
+		require.NoError(t, <-errs)
+	}
+	assert.Equal(t, defQueueSize, len(conn.seqs), "all the slots must be released")
+
+	// Both waiting requests are reported with their queue wait
+	var deadlineReported, waitReported bool
+	for len(slow) != 0 {
+		rpc := <-slow
+		assert.Equal(t, cmdModifyItem, rpc.Cmd)
+		assert.True(t, rpc.Duration >= threshold && rpc.Duration >= rpc.QueueWait)
+		if rpc.Err == context.DeadlineExceeded {
+			deadlineReported = rpc.QueueWait >= threshold
+			assert.Equal(t, "deadline", rpc.Label)
+		} else if rpc.QueueWait >= queueWait/2 {
+			waitReported = true
+		}
+	}
+	assert.True(t, deadlineReported)
+	assert.True(t, waitReported)
 }
 
-type testServer struct {
-	l     net.Listener
-	conns []net.Conn
+func TestConnQueueSize(t *testing.T) {
+	const size = 3
+	release := make(chan struct{})
+	u, stop := runFakeRPCServer(t, func(cmd int, version uint16) {
+		if cmd == cmdModifyItem {
+			<-release
+		}
+	})
+	defer stop()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionConnQueueSize{QueueSize: size}))
+	defer binding.Finalize()
+	conn, err := binding.getConn(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, size, binding.Status(context.Background()).CProto.ConnQueueSize)
+
+	modify := func(ctx context.Context) error {
+		buf, err := binding.ModifyItem(ctx, 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0)
+		buf.Free()
+		return err
+	}
+	errs := make(chan error, size)
+	for i := 0; i < size; i++ {
+		go func() { errs <- modify(context.Background()) }()
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(conn.seqs) != 0; time.Sleep(time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "request slots are not occupied")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, modify(ctx), "request must wait for the free slot")
+
+	close(release)
+	for i := 0; i < size; i++ {
+		require.NoError(t, <-errs)
+	}
+	// Seq nums cycle through all the slots
+	for i := 0; i < 3*size; i++ {
+		require.NoError(t, modify(context.Background()))
+	}
+	assert.Equal(t, size, len(conn.seqs), "all the slots must be released")
+
+	t.Run("seq nums", func(t *testing.T) {
+		for _, size := range []int{1, size, defQueueSize, maxQueueSize} {
+			c := &connection{}
+			c.initRequests(size)
+			for _, seq := range []uint32{0, uint32(size) - 1, c.seqNumLimit - 1, c.seqNumLimit - uint32(size)} {
+				next := c.nextSeqNum(seq)
+				assert.True(t, c.seqNumIsValid(next) && next < maxSeqNum)
+				assert.Equal(t, seq%c.queueSize, next%c.queueSize, "seq num %d of queue size %d moves to another slot", seq, size)
+			}
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		for size, expected := range map[int]int{0: defQueueSize, -1: defQueueSize, 2 * maxQueueSize: maxQueueSize} {
+			binding := &NetCProto{}
+			require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionConnQueueSize{QueueSize: size}))
+			assert.Equal(t, expected, binding.Status(context.Background()).CProto.ConnQueueSize)
+			binding.Finalize()
+		}
+	})
 }
 
-func (s *testServer) acceptLoop() {
-	for {
-		conn, err := s.l.Accept()
-		if err != nil {
-			return
+// execTimeoutOf decodes exec timeout from context args of request body
+func execTimeoutOf(body []byte) int64 {
+	in := newRPCDecoder(body)
+	for n := in.argsCount(); n > 0; n-- {
+		in.intfArg()
+	}
+	in.argsCount()
+	return in.intfArg().(int64)
+}
+
+func TestDeadlineFloor(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	const floor = 500 * time.Millisecond
+	timeouts := make(chan int64, defQueueSize)
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdModifyItem {
+			timeouts <- execTimeoutOf(body)
 		}
-		s.conns = append(s.conns, conn)
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	slow := make(chan bindings.SlowRPC, defQueueSize)
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1},
+		bindings.OptionWriteCoalescing{Delay: delay}, bindings.OptionDeadlineFloor{Floor: floor},
+		bindings.OptionSlowRPC{Threshold: time.Nanosecond, Hook: func(rpc bindings.SlowRPC) {
+			if rpc.Cmd == cmdModifyItem {
+				slow <- rpc
+			}
+		}}))
+	defer binding.Finalize()
+	modify := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		buf, err := binding.ModifyItem(ctx, 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0)
+		buf.Free()
+		return err
+	}
+
+	t.Run("write queue wait", func(t *testing.T) {
+		require.NoError(t, modify(2*time.Second))
+		timeout := <-timeouts
+		assert.True(t, timeout > 0 && timeout <= int64((2*time.Second-delay)/time.Millisecond), "exec timeout %d is not decreased", timeout)
+		rpc := <-slow
+		assert.True(t, rpc.WriteWait >= delay*9/10 && rpc.Duration >= rpc.WriteWait, "write wait %v", rpc.WriteWait)
+	})
+
+	t.Run("slot", func(t *testing.T) {
+		start := time.Now()
+		assert.Equal(t, errDeadlineFloor, modify(floor/2))
+		assert.True(t, time.Since(start) < delay, "request must fail without sending")
+		assert.Equal(t, errDeadlineFloor, (<-slow).Err)
+	})
+
+	t.Run("write", func(t *testing.T) {
+		// Remaining timeout is above the floor, when request takes slot, and below it after the coalescing delay
+		assert.Equal(t, errDeadlineFloor, modify(floor+delay/2))
+		assert.Equal(t, errDeadlineFloor, (<-slow).Err)
+	})
+
+	require.NoError(t, modify(2*time.Second))
+	<-timeouts
+	assert.Equal(t, 0, len(timeouts), "rejected requests must not be sent")
+}
+
+func TestQueueFullError(t *testing.T) {
+	release := make(chan struct{})
+	u, stop := runFakeRPCServer(t, func(cmd int, version uint16) {
+		if cmd == cmdModifyItem {
+			<-release
+		}
+	})
+	defer stop()
+
+	const queueSize = 2
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2}, bindings.OptionConnQueueSize{QueueSize: queueSize},
+		bindings.OptionQueueFullError{}))
+	defer binding.Finalize()
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+	_, err := binding.getConn(context.Background())
+	require.NoError(t, err)
+	conns := binding.pool.conns
+	require.Len(t, conns, 2)
+
+	modify := func(ctx context.Context) error {
+		buf, err := binding.ModifyItem(ctx, 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0)
+		buf.Free()
+		return err
+	}
+	errs := make(chan error, 2*queueSize)
+	start := func(ctx context.Context, inFlight int) {
+		go func() { errs <- modify(ctx) }()
+		for deadline := time.Now().Add(5 * time.Second); conns[0].inFlight()+conns[1].inFlight() != inFlight; time.Sleep(time.Millisecond) {
+			require.True(t, time.Now().Before(deadline), "request doesn't take slot")
+		}
+	}
+	// The first connection is filled via write lane, so the next requests take slots of the second one, whichever connection is in turn
+	for i := 0; i < queueSize; i++ {
+		start(bindings.ContextWithWriteLane(context.Background(), 0), i+1)
+	}
+	require.Equal(t, queueSize, conns[0].inFlight())
+	for i := 0; i < queueSize; i++ {
+		start(context.Background(), queueSize+i+1)
+	}
+	require.Equal(t, queueSize, conns[1].inFlight())
+
+	t.Run("no wait", func(t *testing.T) {
+		begin := time.Now()
+		err := modify(context.Background())
+		assert.Equal(t, errQueueFull, err)
+		assert.True(t, errors.Is(err, bindings.ErrorQueueFull))
+		assert.True(t, time.Since(begin) < 50*time.Millisecond, "request must not wait for the free slot")
+	})
+
+	const maxWait = 50 * time.Millisecond
+	binding.queueFull.MaxQueueWait = maxWait
+	t.Run("max queue wait", func(t *testing.T) {
+		begin := time.Now()
+		assert.Equal(t, errQueueFull, modify(context.Background()))
+		assert.True(t, time.Since(begin) >= maxWait)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), maxWait/5)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, modify(ctx))
+	})
+
+	assert.Equal(t, int64(2), binding.Status(context.Background()).CProto.QueueFullRequests)
+	unblock()
+	for i := 0; i < 2*queueSize; i++ {
+		require.NoError(t, <-errs)
+	}
+	require.NoError(t, modify(context.Background()))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdSelectSQL || cmd == cmdModifyItem {
+			// Server doesn't reply until timeout of client
+			return nil
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionTimeouts{RequestTimeout: timeout}))
+	defer binding.Finalize()
+	checkErr := func(err error, cmd int, elapsed time.Duration) {
+		require.Error(t, err)
+		assert.Equal(t, bindings.ErrTimeout, err.(bindings.Error).Code())
+		assert.Contains(t, err.Error(), fmt.Sprintf("timeout %v of command %s", timeout, cmdName(cmd)))
+		assert.True(t, elapsed >= timeout && elapsed < timeout*3/2, "timeout fired after %v", elapsed)
+	}
+
+	t.Run("sync", func(t *testing.T) {
+		start := time.Now()
+		_, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+		checkErr(err, cmdSelectSQL, time.Since(start))
+	})
+
+	t.Run("async", func(t *testing.T) {
+		start := time.Now()
+		done := make(chan error, 1)
+		binding.ModifyItemAsync(context.Background(), 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0,
+			func(buf bindings.RawBuffer, err error) {
+				done <- err
+			})
+		checkErr(<-done, cmdModifyItem, time.Since(start))
+	})
+
+	t.Run("deadline of context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout/2)
+		defer cancel()
+		_, err := binding.rpcCall(ctx, opRd, cmdSelectSQL, "")
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		done := make(chan error, 1)
+		binding.ModifyItemAsync(ctx, 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0,
+			func(buf bindings.RawBuffer, err error) {
+				done <- err
+			})
+		assert.Equal(t, context.DeadlineExceeded, <-done)
+	})
+}
+
+func TestAsyncDeadlineRace(t *testing.T) {
+	const queueSize = 4
+	const workers = 8
+	const requests = 1000
+	var wrLock sync.Mutex
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd != cmdModifyItem {
+			_, err := conn.Write(reply)
+			return err
+		}
+		// Replies arrive around deadlines of requests, some of them after request slots are reused
+		reply = append([]byte(nil), reply...)
+		delay := time.Duration(seq*7919%25) * time.Millisecond
+		go func() {
+			time.Sleep(delay)
+			wrLock.Lock()
+			conn.Write(reply)
+			wrLock.Unlock()
+		}()
+		return nil
+	})
+	defer stop()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionConnQueueSize{QueueSize: queueSize}))
+	defer binding.Finalize()
+	conn, err := binding.getConn(context.Background())
+	require.NoError(t, err)
+
+	var calls [requests]int32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < requests; i += workers {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(1+i%20)*time.Millisecond)
+				done := make(chan struct{})
+				i := i
+				binding.ModifyItemAsync(ctx, 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0,
+					func(buf bindings.RawBuffer, err error) {
+						if buf != nil {
+							buf.Free()
+						}
+						if atomic.AddInt32(&calls[i], 1) == 1 {
+							close(done)
+						}
+					})
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					assert.Fail(t, "completion of request is not called", "request %d", i)
+				}
+				cancel()
+			}
+		}(w)
+	}
+	wg.Wait()
+	// Late completions would be called by the next ticks of deadline ticker
+	time.Sleep(3 * deadlineCheckPeriod)
+	for i := range calls {
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls[i]), "completion of request %d must be called once", i)
+	}
+
+	// All the slots are released
+	for deadline := time.Now().Add(5 * time.Second); len(conn.seqs) != queueSize; time.Sleep(time.Millisecond) {
+		require.True(t, time.Now().Before(deadline), "request slots are not released")
+	}
+	buf, err := binding.ModifyItem(context.Background(), 0, "namespace", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0)
+	require.NoError(t, err)
+	buf.Free()
+}
+
+func TestCancelRequest(t *testing.T) {
+	type cancelReq struct {
+		from     net.Conn
+		serverID int64
+		seq      uint32
+	}
+	var connIDs sync.Map
+	var nextID int64
+	canceled := make(chan cancelReq, 10)
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		in := newRPCDecoder(body)
+		switch cmd {
+		case cmdLogin:
+			id := atomic.AddInt64(&nextID, 1)
+			connIDs.Store(conn, id)
+			reply = fakeRPCReplyArgs(cmdLogin, seq, func(out *cjson.Serializer) int {
+				out.PutVarUInt(uint64(bindings.ValueString))
+				out.PutVString("v2.9.1")
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(1)
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(bindings.ServerCapCancelRequest)
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(id)
+				return 4
+			})
+		case cmdCancelRequest:
+			in.argsCount()
+			canceled <- cancelReq{from: conn, serverID: in.intfArg().(int64), seq: uint32(in.intfArg().(int64))}
+		case cmdSelectSQL:
+			in.argsCount()
+			if query := string(in.bytesArg()); query == "slow" {
+				// Server replies after cancel, so the reply is late
+				time.Sleep(100 * time.Millisecond)
+				reply = fakeRPCReply(nil, cmd, seq, "late")
+			} else {
+				reply = fakeRPCReply(nil, cmd, seq, query)
+			}
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2}))
+	defer binding.Finalize()
+	conn := binding.pool.conns[0]
+	require.True(t, conn.serverID > 0)
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		start := time.Now()
+		_, err := conn.rpcCall(ctx, cmdSelectSQL, 0, "slow")
+		assert.Equal(t, context.Canceled, err)
+		assert.True(t, time.Since(start) < 100*time.Millisecond, "request must not wait for the reply after cancel")
+
+		select {
+		case req := <-canceled:
+			assert.Equal(t, conn.serverID, req.serverID)
+			id, _ := connIDs.Load(req.from)
+			assert.NotEqual(t, conn.serverID, id, "cancel must be sent via another connection")
+		case <-time.After(5 * time.Second):
+			t.Fatal("cancel is not received by server")
+		}
+
+		// Late reply to the canceled request is not received by the next request of the slot
+		for i := 0; i < 2*defQueueSize; i++ {
+			buf, err := conn.rpcCall(context.Background(), cmdSelectSQL, 0, "fast")
+			require.NoError(t, err)
+			assert.Equal(t, []interface{}{[]byte("fast")}, buf.Args())
+			buf.Free()
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := conn.rpcCall(ctx, cmdSelectSQL, 0, "slow")
+		assert.Equal(t, context.DeadlineExceeded, err)
+		// Server stops request by exec timeout
+		select {
+		case <-canceled:
+			t.Error("cancel must not be sent on deadline")
+		case <-time.After(200 * time.Millisecond):
+		}
+	})
+}
+
+func TestHedgedSelect(t *testing.T) {
+	const slowDelay = 200 * time.Millisecond
+	var connIDs, canceledSeqs sync.Map
+	var nextID, slowID, deletes int64
+	canceled := make(chan uint32, 100)
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		in := newRPCDecoder(body)
+		id, _ := connIDs.Load(conn)
+		slow := id != nil && (id.(int64) == atomic.LoadInt64(&slowID) || atomic.LoadInt64(&slowID) < 0)
+		switch cmd {
+		case cmdLogin:
+			id := atomic.AddInt64(&nextID, 1)
+			connIDs.Store(conn, id)
+			reply = fakeRPCReplyArgs(cmdLogin, seq, func(out *cjson.Serializer) int {
+				out.PutVarUInt(uint64(bindings.ValueString))
+				out.PutVString("v2.9.1")
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(1)
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(bindings.ServerCapCancelRequest)
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(id)
+				return 4
+			})
+		case cmdCancelRequest:
+			in.argsCount()
+			in.intfArg()
+			seq := uint32(in.intfArg().(int64))
+			canceledSeqs.Store(seq, true)
+			canceled <- seq
+		case cmdSelect, cmdDeleteQuery:
+			if cmd == cmdDeleteQuery {
+				atomic.AddInt64(&deletes, 1)
+			}
+			reply = fakeRPCReplyArgs(cmd, seq, func(out *cjson.Serializer) int {
+				out.PutVarUInt(uint64(bindings.ValueString))
+				out.PutVString("results")
+				out.PutVarUInt(uint64(bindings.ValueInt))
+				out.PutVarInt(-1)
+				return 2
+			})
+			// Slow connection is stalled, e.g. by GC pause of server. Canceled request is stopped
+			for deadline := time.Now().Add(slowDelay); slow && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+				if _, ok := canceledSeqs.Load(seq); ok {
+					reply = fakeRPCErrorReply(cmd, seq, bindings.ErrCanceled, "canceled")
+					break
+				}
+			}
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2}))
+	defer binding.Finalize()
+	atomic.StoreInt64(&slowID, binding.pool.conns[0].serverID)
+
+	// maxLatency returns max latency of selects, half of which are sent via the slow connection
+	maxLatency := func(t *testing.T, ctx context.Context, count int) (max time.Duration) {
+		for i := 0; i < count; i++ {
+			start := time.Now()
+			buf, err := binding.SelectQuery(ctx, []byte{}, false, nil, 1)
+			if d := time.Since(start); d > max {
+				max = d
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "results", string(buf.GetBuf()))
+			buf.Free()
+		}
+		return max
+	}
+
+	t.Run("without hedging", func(t *testing.T) {
+		assert.True(t, maxLatency(t, context.Background(), 4) >= slowDelay)
+	})
+
+	t.Run("slow connection", func(t *testing.T) {
+		const requests = 20
+		max := maxLatency(t, bindings.ContextWithHedge(context.Background(), 10*time.Millisecond), requests)
+		assert.True(t, max < slowDelay/2, "max latency of hedged selects is %v", max)
+
+		status := binding.Status(context.Background()).CProto
+		// Selects are sent via connections of the pool in turn
+		assert.Equal(t, int64(requests/2), status.HedgedRequests)
+		assert.Equal(t, status.HedgedRequests, status.HedgeWins)
+		assert.Equal(t, status.HedgedRequests, status.HedgeWasted)
+		// Losing requests on the slow connection are canceled on server
+		for i := 0; i < requests/2; i++ {
+			select {
+			case <-canceled:
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "losing request is not canceled", "%d of %d", i, requests/2)
+			}
+		}
+		// Late replies to the losers are discarded, connection is still usable
+		for deadline := time.Now().Add(5 * time.Second); binding.Status(context.Background()).CProto.StaleReplies != requests/2; time.Sleep(time.Millisecond) {
+			require.True(t, time.Now().Before(deadline), "late replies are not discarded")
+		}
+		assert.True(t, maxLatency(t, context.Background(), 2) >= slowDelay)
+	})
+
+	t.Run("fast reply", func(t *testing.T) {
+		atomic.StoreInt64(&slowID, 0)
+		before := binding.Status(context.Background()).CProto.HedgedRequests
+		maxLatency(t, bindings.ContextWithHedge(context.Background(), slowDelay), 4)
+		assert.Equal(t, before, binding.Status(context.Background()).CProto.HedgedRequests, "select, which is answered in delay, isn't hedged")
+	})
+
+	t.Run("mutations", func(t *testing.T) {
+		// Both connections are slow
+		atomic.StoreInt64(&slowID, -1)
+		before := binding.Status(context.Background()).CProto.HedgedRequests
+		buf, err := binding.DeleteQuery(bindings.ContextWithHedge(context.Background(), time.Millisecond), 0, []byte{})
+		require.NoError(t, err)
+		buf.Free()
+		assert.Equal(t, int64(1), atomic.LoadInt64(&deletes))
+		assert.Equal(t, before, binding.Status(context.Background()).CProto.HedgedRequests)
+	})
+
+	t.Run("both are slow", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), slowDelay/4)
+		defer cancel()
+		_, err := binding.SelectQuery(bindings.ContextWithHedge(ctx, time.Millisecond), []byte{}, false, nil, 1)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+}
+
+func TestStaleReplies(t *testing.T) {
+	const requests = 100
+	// Server echoes request body in the string arg of reply
+	echo := func(cmd uint16, seq uint32, body []byte) []byte {
+		return fakeRPCReply(nil, cmd, seq, string(body))
+	}
+	// Only replies to test requests are broken, so login and other requests of binding succeed
+	server := func(t *testing.T, onSelect func(conn net.Conn, cmd uint16, seq uint32, body, reply []byte) error) (*url.URL, func()) {
+		return runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+			if cmd != cmdSelectSQL {
+				_, err := conn.Write(reply)
+				return err
+			}
+			return onSelect(conn, cmd, seq, body, reply)
+		})
+	}
+	connect := func(t *testing.T, u *url.URL) (*NetCProto, *connection) {
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+		conn, err := binding.getConn(context.Background())
+		require.NoError(t, err)
+		return binding, conn
+	}
+	call := func(t *testing.T, conn *connection, i int) {
+		marker := fmt.Sprintf("<req-%d>", i)
+		buf, err := conn.rpcCall(context.Background(), cmdSelectSQL, 0, marker)
+		require.NoError(t, err)
+		defer buf.Free()
+		require.Equal(t, 1, buf.ArgsCount())
+		arg, _ := buf.ArgBytes(0)
+		assert.Contains(t, string(arg), marker, "reply of another request is received")
+	}
+
+	t.Run("duplicate replies", func(t *testing.T) {
+		u, stop := server(t, func(conn net.Conn, cmd uint16, seq uint32, body, reply []byte) error {
+			out := echo(cmd, seq+defQueueSize, []byte("stale"))
+			out = append(out, echo(cmd, maxSeqNum+1, []byte("invalid"))...)
+			out = append(out, echo(cmd, seq, body)...)
+			out = append(out, echo(cmd, seq, body)...)
+			_, err := conn.Write(out)
+			return err
+		})
+		defer stop()
+		binding, conn := connect(t, u)
+		defer binding.Finalize()
+
+		for i := 0; i < requests; i++ {
+			call(t, conn, i)
+		}
+		assert.False(t, conn.hasError())
+		status := binding.Status(context.Background()).CProto
+		assert.True(t, status.StaleReplies >= 2*requests, "stale replies: %d", status.StaleReplies)
+		assert.Equal(t, int64(requests), status.UnknownReplies)
+		require.Len(t, status.ConnDiscardedReplies, 1)
+		assert.Equal(t, bindings.DiscardedReplies{Stale: status.StaleReplies, Unknown: requests}, status.ConnDiscardedReplies[0])
+	})
+
+	t.Run("discarded replies limit", func(t *testing.T) {
+		log := &capturingLogger{}
+		var unknown int32
+		u, stop := server(t, func(conn net.Conn, cmd uint16, seq uint32, body, reply []byte) error {
+			out := echo(cmd, seq, body)
+			// Late reply of the previous request is benign, replies with unknown seq are sent by desynced server after it
+			if seq > 0 {
+				out = append(out, echo(cmd, seq-1, []byte("late"))...)
+			}
+			for i := int32(0); i < atomic.LoadInt32(&unknown); i++ {
+				out = append(out, echo(cmd, maxSeqNum+1, []byte("unknown"))...)
+			}
+			_, err := conn.Write(out)
+			return err
+		})
+		defer stop()
+		binding := &NetCProto{}
+		binding.EnableLogger(log)
+		defer binding.DisableLogger()
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1},
+			bindings.OptionDiscardedRepliesLimit{Count: 2 * requests, Interval: time.Minute}))
+		defer binding.Finalize()
+		conn, err := binding.getConn(context.Background())
+		require.NoError(t, err)
+
+		for i := 0; i < requests; i++ {
+			call(t, conn, i)
+		}
+		assert.False(t, conn.hasError())
+		// Discards are logged once per interval
+		assert.Equal(t, []string{"rq: connection to " + conn.addr + " discarded 1 late and 0 unknown replies (SelectSQL: 1)\n"}, log.messages())
+
+		atomic.StoreInt32(&unknown, 10)
+		for i := 0; i < 2*requests && !conn.hasError(); i++ {
+			conn.rpcCall(context.Background(), cmdSelectSQL, 0, "")
+		}
+		require.True(t, conn.hasError(), "connection isn't failed by discarded replies")
+		assert.Contains(t, conn.curError().Error(), "out of sync with server")
+		status := binding.Status(context.Background()).CProto
+		assert.True(t, status.UnknownReplies > 0, "unknown replies: %d", status.UnknownReplies)
+
+		// Connection is reconnected by the next request
+		atomic.StoreInt32(&unknown, 0)
+		newConn, err := binding.getConn(context.Background())
+		require.NoError(t, err)
+		assert.False(t, newConn == conn)
+		call(t, newConn, 0)
+	})
+
+	t.Run("out of order replies", func(t *testing.T) {
+		// Replies to each pair of requests are sent in reverse order, reply to the first one is duplicated
+		var lock sync.Mutex
+		var held []byte
+		u, stop := server(t, func(conn net.Conn, cmd uint16, seq uint32, body, reply []byte) error {
+			lock.Lock()
+			defer lock.Unlock()
+			if held == nil {
+				held = echo(cmd, seq, body)
+				return nil
+			}
+			out := append(echo(cmd, seq, body), held...)
+			out = append(out, held...)
+			held = nil
+			_, err := conn.Write(out)
+			return err
+		})
+		defer stop()
+		binding, conn := connect(t, u)
+		defer binding.Finalize()
+
+		var wg sync.WaitGroup
+		for w := 0; w < 2; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < requests; i++ {
+					call(t, conn, 2*i+w)
+				}
+			}(w)
+		}
+		wg.Wait()
+		assert.False(t, conn.hasError())
+		assert.Equal(t, defQueueSize, len(conn.seqs), "all the slots must be released")
+		stale := binding.Status(context.Background()).CProto.StaleReplies
+		assert.True(t, stale > 0, "stale replies: %d", stale)
+	})
+
+	t.Run("malformed reply", func(t *testing.T) {
+		u, stop := server(t, func(conn net.Conn, cmd uint16, seq uint32, body, reply []byte) error {
+			reply[0] ^= 0xFF
+			_, err := conn.Write(reply)
+			return err
+		})
+		defer stop()
+		binding, conn := connect(t, u)
+		defer binding.Finalize()
+
+		_, err := conn.rpcCall(context.Background(), cmdSelectSQL, 0, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Invalid cproto magic")
+		assert.True(t, conn.hasError())
+	})
+}
+
+// capturingLogger records messages of cproto logger
+type capturingLogger struct {
+	lock sync.Mutex
+	msgs []string
+}
+
+func (l *capturingLogger) Printf(level int, format string, msg ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, msg...))
+}
+
+func (l *capturingLogger) messages() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), l.msgs...)
+}
+
+func TestReplyLimit(t *testing.T) {
+	const resultsID = 7
+	results := strings.Repeat("x", 10*1024)
+	// Select reply carries results buffer and id of server side results
+	selectReply := func(seq uint32, compressed bool) []byte {
+		body := cjson.NewSerializer(nil)
+		body.PutVarUInt(0)
+		body.PutVString("")
+		body.PutVarUInt(2)
+		body.PutVarUInt(uint64(bindings.ValueString))
+		body.PutVString(results)
+		body.PutVarUInt(uint64(bindings.ValueInt))
+		body.PutVarInt(resultsID)
+		data, version := body.Bytes(), uint16(cprotoVersion)
+		if compressed {
+			data, version = snappy.Encode(nil, data), version|cprotoVersionCompressionFlag
+		}
+		out := cjson.NewSerializer(nil)
+		out.PutUInt32(cprotoMagic)
+		out.PutUInt16(version)
+		out.PutUInt16(cmdSelect)
+		out.PutUInt32(uint32(len(data)))
+		out.PutUInt32(seq)
+		out.Write(data)
+		return out.Bytes()
+	}
+
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed=%v", compressed), func(t *testing.T) {
+			closed := make(chan int, 10)
+			u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+				switch cmd {
+				case cmdSelect:
+					reply = selectReply(seq, compressed)
+				case cmdCloseResults:
+					dec := newRPCDecoder(body)
+					dec.argsCount()
+					closed <- dec.intfArg().(int)
+				}
+				_, err := conn.Write(reply)
+				return err
+			})
+			defer stop()
+			binding := &NetCProto{}
+			require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+			defer binding.Finalize()
+
+			ctx := bindings.ContextWithReplyLimit(context.Background(), 1024)
+			_, err := binding.SelectQuery(ctx, []byte{}, false, nil, 1)
+			assert.Equal(t, bindings.ErrReplyLimit, err)
+			select {
+			case id := <-closed:
+				assert.Equal(t, resultsID, id, "results of the rejected reply must be closed")
+			case <-time.After(5 * time.Second):
+				require.Fail(t, "results of the rejected reply are not closed")
+			}
+
+			// Connection is still usable
+			ctx = bindings.ContextWithReplyLimit(context.Background(), 1024*1024)
+			buf, err := binding.SelectQuery(ctx, []byte{}, false, nil, 1)
+			require.NoError(t, err)
+			assert.Equal(t, results, string(buf.GetBuf()))
+			buf.Free()
+			assert.Equal(t, resultsID, <-closed)
+		})
+	}
+}
+
+func TestCloseResults(t *testing.T) {
+	const resultsID = 5
+	runServer := func(closeErr bool) (*url.URL, func(), chan int) {
+		closed := make(chan int, 10)
+		u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+			switch cmd {
+			case cmdSelectSQL:
+				reply = fakeRPCReplyArgs(cmd, seq, func(out *cjson.Serializer) int {
+					out.PutVarUInt(uint64(bindings.ValueString))
+					out.PutVString("results")
+					out.PutVarUInt(uint64(bindings.ValueInt))
+					out.PutVarInt(resultsID)
+					return 2
+				})
+			case cmdCloseResults:
+				dec := newRPCDecoder(body)
+				dec.argsCount()
+				closed <- dec.intfArg().(int)
+				if closeErr {
+					reply = fakeRPCErrorReply(cmd, seq, bindings.ErrLogic, "results are busy")
+				}
+			}
+			_, err := conn.Write(reply)
+			return err
+		})
+		return u, stop, closed
+	}
+	awaitClose := func(closed chan int) {
+		select {
+		case id := <-closed:
+			assert.Equal(t, resultsID, id)
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "results are not closed")
+		}
+	}
+
+	t.Run("canceled context", func(t *testing.T) {
+		u, stop, closed := runServer(false)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+		defer binding.Finalize()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		buf, err := binding.Select(ctx, "SELECT * FROM items", false, nil, 1)
+		require.NoError(t, err)
+		cancel()
+		buf.Free()
+		awaitClose(closed)
+		assert.Equal(t, int64(0), binding.Status(context.Background()).CProto.LeakedResults)
+	})
+
+	t.Run("failed close", func(t *testing.T) {
+		u, stop, closed := runServer(true)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+		defer binding.Finalize()
+
+		buf, err := binding.Select(context.Background(), "SELECT * FROM items", false, nil, 1)
+		require.NoError(t, err)
+		buf.Free()
+		// Close is retried once on alive connection
+		awaitClose(closed)
+		awaitClose(closed)
+		assert.Equal(t, int64(1), binding.Status(context.Background()).CProto.LeakedResults)
+	})
+}
+
+func TestPingInterval(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	type server struct {
+		pings      int32
+		replyPings int32
+	}
+	run := func(t *testing.T, srv *server) (*url.URL, func()) {
+		return runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+			if cmd == cmdPing {
+				atomic.AddInt32(&srv.pings, 1)
+				if atomic.LoadInt32(&srv.replyPings) == 0 {
+					return nil
+				}
+			}
+			if cmd == cmdSelectSQL && bytes.Contains(body, []byte("<block>")) {
+				return nil
+			}
+			_, err := conn.Write(reply)
+			return err
+		})
+	}
+
+	t.Run("idle connection is pinged", func(t *testing.T) {
+		srv := &server{replyPings: 1}
+		u, stop := run(t, srv)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionPingInterval{Interval: interval}))
+		defer binding.Finalize()
+		conn, err := binding.getConn(context.Background())
+		require.NoError(t, err)
+
+		time.Sleep(5 * interval)
+		assert.True(t, atomic.LoadInt32(&srv.pings) >= 2, "pings: %d", atomic.LoadInt32(&srv.pings))
+		assert.True(t, time.Since(conn.lastPingTime()) < 2*interval, "last ping: %v", conn.lastPingTime())
+		assert.False(t, conn.hasError())
+	})
+
+	t.Run("connection without ping reply is failed", func(t *testing.T) {
+		srv := &server{}
+		u, stop := run(t, srv)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionPingInterval{Interval: interval, Timeout: interval}))
+		defer binding.Finalize()
+		conn, err := binding.getConn(context.Background())
+		require.NoError(t, err)
+
+		for deadline := time.Now().Add(5 * time.Second); !conn.hasError(); time.Sleep(time.Millisecond) {
+			require.True(t, time.Now().Before(deadline), "connection is not failed")
+		}
+		assert.Equal(t, errPingTimeout, conn.curError())
+		assert.True(t, conn.lastPingTime().IsZero())
+		// The next request is sent via the new connection
+		_, err = binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("ping doesn't take the last request slot", func(t *testing.T) {
+		srv := &server{replyPings: 1}
+		u, stop := run(t, srv)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionConnQueueSize{QueueSize: 1},
+			bindings.OptionPingInterval{Interval: interval}))
+		defer binding.Finalize()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*interval)
+		defer cancel()
+		_, err := binding.rpcCall(ctx, opRd, cmdSelectSQL, "<block>")
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&srv.pings))
+	})
+
+	t.Run("connection with ping in flight isn't chosen", func(t *testing.T) {
+		srv := &server{replyPings: 1}
+		u, stop := run(t, srv)
+		defer stop()
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2}))
+		defer binding.Finalize()
+		conns := binding.getAllConns()
+		require.Len(t, conns, 2)
+
+		atomic.StoreInt64(&conns[0].pingSentStamp, time.Now().UnixNano())
+		for i := 0; i < 10; i++ {
+			conn, err := binding.getConn(context.Background())
+			require.NoError(t, err)
+			assert.True(t, conn == conns[1])
+		}
+		atomic.StoreInt64(&conns[0].pingSentStamp, 0)
+	})
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	// Server drops connection on the test request
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdSelectSQL {
+			return errConnClosed
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1},
+		bindings.OptionReconnectBackoff{Min: 200 * time.Millisecond, Max: 300 * time.Millisecond}))
+	defer binding.Finalize()
+
+	_, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+	require.Error(t, err)
+	stop()
+	conn, err := binding.getConn(context.Background())
+	for err == nil && !conn.hasError() {
+		// Connection error is delivered by read loop
+		time.Sleep(time.Millisecond)
+		conn, err = binding.getConn(context.Background())
+	}
+	_, err = binding.getConn(context.Background())
+	require.Error(t, err, "reconnect to the stopped server must fail")
+
+	binding.lock.RLock()
+	reconnectErr, delay := binding.reconnectErr, binding.reconnectDelay
+	binding.lock.RUnlock()
+	require.NotNil(t, reconnectErr)
+	assert.Equal(t, 200*time.Millisecond, delay)
+
+	// Reconnect is not attempted until the delay is over
+	_, err = binding.getConn(context.Background())
+	assert.Equal(t, reconnectErr, err)
+	binding.lock.RLock()
+	assert.Equal(t, 200*time.Millisecond, binding.reconnectDelay)
+	binding.lock.RUnlock()
+
+	time.Sleep(250 * time.Millisecond)
+	_, err = binding.getConn(context.Background())
+	assert.Error(t, err)
+	binding.lock.RLock()
+	assert.Equal(t, 300*time.Millisecond, binding.reconnectDelay, "delay is doubled up to max")
+	binding.lock.RUnlock()
+}
+
+func TestBackgroundReconnect(t *testing.T) {
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2},
+		bindings.OptionReconnectBackoff{Min: 50 * time.Millisecond, Max: 100 * time.Millisecond, Jitter: 0.5, Background: true, DownWindow: 300 * time.Millisecond}))
+	defer binding.Finalize()
+
+	t.Run("failed connection", func(t *testing.T) {
+		conns := binding.getAllConns()
+		require.Len(t, conns, 2)
+		conns[0].onError(io.ErrUnexpectedEOF)
+		// Requests are sent via the rest of the pool, while the failed connection is re-dialed
+		for i := 0; i < 10; i++ {
+			conn, err := binding.getConn(context.Background())
+			require.NoError(t, err)
+			assert.False(t, conn == conns[0])
+		}
+		_, err := binding.rpcCall(context.Background(), opRd, cmdPing)
+		assert.NoError(t, err)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for restored := binding.getAllConns()[0]; restored == conns[0] || restored.hasError(); restored = binding.getAllConns()[0] {
+			require.True(t, time.Now().Before(deadline), "connection is not re-dialed")
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.True(t, binding.getAllConns()[1] == conns[1], "alive connection is not replaced")
+	})
+
+	t.Run("all connections are down", func(t *testing.T) {
+		stop()
+		for _, conn := range binding.getAllConns() {
+			conn.onError(io.ErrUnexpectedEOF)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := binding.getConn(ctx)
+		cancel()
+		assert.Equal(t, context.DeadlineExceeded, err)
+
+		start := time.Now()
+		_, err = binding.getConn(context.Background())
+		assert.Equal(t, errAllConnsDown, err)
+		assert.True(t, time.Since(start) > 200*time.Millisecond, "requests wait for re-dial up to down window")
+		assert.Equal(t, bindings.ErrNetwork, err.(bindings.Error).Code())
+
+		// Down window is counted since the pool is down, so the next request fails immediately
+		start = time.Now()
+		_, err = binding.getConn(context.Background())
+		assert.Equal(t, errAllConnsDown, err)
+		assert.True(t, time.Since(start) < 50*time.Millisecond)
+	})
+}
+
+func TestRequeueOnConnLoss(t *testing.T) {
+	// Server drops connection on the first 'drops' requests of each command
+	var drops, selects, modifies int32
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		var count int32
+		switch cmd {
+		case cmdSelectSQL:
+			count = atomic.AddInt32(&selects, 1)
+		case cmdModifyItem:
+			count = atomic.AddInt32(&modifies, 1)
+		}
+		if count != 0 && count <= atomic.LoadInt32(&drops) {
+			return errConnClosed
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	newBinding := func(t *testing.T, options ...interface{}) *NetCProto {
+		atomic.StoreInt32(&selects, 0)
+		atomic.StoreInt32(&modifies, 0)
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, append(options, bindings.OptionConnPoolSize{ConnPoolSize: 2})...))
+		return binding
+	}
+	requirePoolSize := func(t *testing.T, binding *NetCProto) {
+		// Broken connection is replaced before the next test
+		_, err := binding.rpcCall(context.Background(), opRd, cmdPing)
+		require.NoError(t, err)
+	}
+
+	t.Run("idempotent", func(t *testing.T) {
+		atomic.StoreInt32(&drops, 1)
+		binding := newBinding(t, bindings.OptionRequeueOnConnLoss{Attempts: 2})
+		defer binding.Finalize()
+		buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+		require.NoError(t, err)
+		buf.Free()
+		assert.Equal(t, int32(2), atomic.LoadInt32(&selects))
+		assert.Equal(t, int64(1), binding.Status(context.Background()).CProto.RequeuedRequests)
+		requirePoolSize(t, binding)
+	})
+
+	t.Run("attempts", func(t *testing.T) {
+		atomic.StoreInt32(&drops, 100)
+		binding := newBinding(t, bindings.OptionRequeueOnConnLoss{Attempts: 2})
+		defer binding.Finalize()
+		_, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+		require.Error(t, err)
+		assert.Equal(t, bindings.ErrConnBrokenMidRequest, err.(bindings.Error).Code())
+		assert.Equal(t, int32(3), atomic.LoadInt32(&selects))
+	})
+
+	t.Run("not idempotent", func(t *testing.T) {
+		atomic.StoreInt32(&drops, 1)
+		binding := newBinding(t, bindings.OptionRequeueOnConnLoss{Attempts: 2}, bindings.OptionRetryAttempts{Write: 2})
+		defer binding.Finalize()
+		_, err := binding.rpcCall(context.Background(), opWr, cmdModifyItem, "ns")
+		require.Error(t, err)
+		assert.Equal(t, bindings.ErrConnBrokenMidRequest, err.(bindings.Error).Code())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&modifies), "request is not retried")
+		requirePoolSize(t, binding)
+		_, err = binding.rpcCall(context.Background(), opWr, cmdModifyItem, "ns")
+		assert.NoError(t, err)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		atomic.StoreInt32(&drops, 1)
+		binding := newBinding(t)
+		defer binding.Finalize()
+		_, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+		require.Error(t, err)
+		rerr, ok := err.(bindings.Error)
+		assert.False(t, ok && rerr.Code() == bindings.ErrConnBrokenMidRequest)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&selects))
+	})
+}
+
+func TestJitterDelay(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, jitterDelay(100*time.Millisecond, 0))
+	for i := 0; i < 100; i++ {
+		d := jitterDelay(100*time.Millisecond, 0.5)
+		assert.True(t, d >= 50*time.Millisecond && d <= 150*time.Millisecond, "delay %v", d)
+	}
+}
+
+func TestConnectRetriesDontBlock(t *testing.T) {
+	// Server accepts connections, but doesn't answer, so each connect attempt lasts until login timeout
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{{Scheme: "cproto", Host: l.Addr().String(), Path: "/db"}},
+		bindings.OptionConnectRetries{Attempts: 2}, bindings.OptionTimeouts{LoginTimeout: time.Second}))
+	defer binding.Finalize()
+
+	time.Sleep(100 * time.Millisecond)
+	require.True(t, binding.isConnecting())
+	start := time.Now()
+	binding.getAllConns()
+	assert.Contains(t, binding.Status(context.Background()).CProto.ConnState, "connecting")
+	assert.True(t, time.Since(start) < 200*time.Millisecond, "pool is locked by connect attempt")
+	assert.Equal(t, minConnectRetriesBackoff, binding.connectRetriesBackoff(), "zero backoff is limited")
+}
+
+// newTestCert returns self-signed certificate for 127.0.0.1
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "reindexer test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestTLS(t *testing.T) {
+	cert, roots := newTestCert(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	u, stop := serveFakeRPC(tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}}),
+		func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+			_, err := conn.Write(fakeRPCReply(nil, cmd, seq, string(body)))
+			return err
+		})
+	defer stop()
+
+	t.Run("trusted server", func(t *testing.T) {
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionTLS{Config: &tls.Config{RootCAs: roots}}))
+		defer binding.Finalize()
+		buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "<tls>")
+		require.NoError(t, err)
+		defer buf.Free()
+		arg, _ := buf.ArgBytes(0)
+		assert.Contains(t, string(arg), "<tls>")
+	})
+
+	t.Run("unknown authority", func(t *testing.T) {
+		binding := &NetCProto{}
+		err := binding.Init([]url.URL{*u}, bindings.OptionTLS{Config: &tls.Config{}},
+			bindings.OptionTimeouts{LoginTimeout: 2 * time.Second})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "certificate")
+	})
+
+	t.Run("cprotos scheme", func(t *testing.T) {
+		su := *u
+		su.Scheme = "cprotos"
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{su}, bindings.OptionTLS{Config: &tls.Config{RootCAs: roots}}))
+		defer binding.Finalize()
+		buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "<cprotos>")
+		require.NoError(t, err)
+		buf.Free()
+
+		// Without OptionTLS server is verified by system roots
+		err = (&NetCProto{}).Init([]url.URL{su}, bindings.OptionTimeouts{LoginTimeout: 2 * time.Second})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "certificate")
+	})
+
+	t.Run("client certificate", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		serverConfig := &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: roots}
+		u, stop := serveFakeRPC(tls.NewListener(l, serverConfig),
+			func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+				_, err := conn.Write(fakeRPCReply(nil, cmd, seq, string(body)))
+				return err
+			})
+		defer stop()
+
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionTLS{Config: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}}}))
+		defer binding.Finalize()
+		buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "<mtls>")
+		require.NoError(t, err)
+		arg, _ := buf.ArgBytes(0)
+		assert.Contains(t, string(arg), "<mtls>")
+		buf.Free()
+
+		// Server rejects client without certificate
+		err = (&NetCProto{}).Init([]url.URL{*u}, bindings.OptionTLS{Config: &tls.Config{RootCAs: roots}},
+			bindings.OptionTimeouts{LoginTimeout: 2 * time.Second})
+		require.Error(t, err)
+	})
+}
+
+func TestServerShutdown(t *testing.T) {
+	const requests = 100
+	connect := func(t *testing.T, u *url.URL) (*NetCProto, chan bindings.DisconnectEvent) {
+		events := make(chan bindings.DisconnectEvent, 16)
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2},
+			bindings.OptionOnDisconnect{Handler: func(ev bindings.DisconnectEvent) { events <- ev }}))
+		return binding, events
+	}
+	waitEvent := func(t *testing.T, events chan bindings.DisconnectEvent) bindings.DisconnectEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("disconnect event is not reported")
+		}
+		return bindings.DisconnectEvent{}
+	}
+	// Requests, started after the event, must succeed on the rest of connections or the new ones
+	callAll := func(t *testing.T, binding *NetCProto) {
+		var wg sync.WaitGroup
+		var failed int32
+		for w := 0; w < 10; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < requests/10; i++ {
+					buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "<req>")
+					if err != nil {
+						atomic.AddInt32(&failed, 1)
+						continue
+					}
+					buf.Free()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(0), failed)
+	}
+
+	t.Run("shutdown notice", func(t *testing.T) {
+		var lock sync.Mutex
+		var draining net.Conn
+		var afterNotice int
+		u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+			lock.Lock()
+			defer lock.Unlock()
+			if conn == draining && cmd != cmdPing {
+				afterNotice++
+			}
+			if cmd != cmdSelectSQL || !bytes.Contains(body, []byte("<shutdown>")) {
+				_, err := conn.Write(reply)
+				return err
+			}
+			// Connection is closed by server in a while after notice to let client finish the requests in progress
+			draining = conn
+			_, err := conn.Write(append(reply, fakeRPCReply(nil, cmdShutdownNotice, 0, "")...))
+			time.AfterFunc(100*time.Millisecond, func() { conn.Close() })
+			return err
+		})
+		defer stop()
+		binding, events := connect(t, u)
+		defer binding.Finalize()
+
+		buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "<shutdown>")
+		require.NoError(t, err)
+		buf.Free()
+		ev := waitEvent(t, events)
+		assert.Equal(t, bindings.DisconnectServerShutdown, ev.Reason)
+		assert.Equal(t, u.Host, ev.Addr)
+		assert.NoError(t, ev.Err)
+
+		callAll(t, binding)
+		time.Sleep(200 * time.Millisecond)
+		callAll(t, binding)
+		assert.Len(t, events, 0, "connection is reported twice")
+		lock.Lock()
+		assert.Equal(t, 0, afterNotice, "requests are sent to draining connection")
+		lock.Unlock()
+	})
+
+	t.Run("idle connection is closed", func(t *testing.T) {
+		u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+			if _, err := conn.Write(reply); err != nil {
+				return err
+			}
+			if cmd == cmdSelectSQL && bytes.Contains(body, []byte("<shutdown>")) {
+				time.AfterFunc(50*time.Millisecond, func() { conn.Close() })
+			}
+			return nil
+		})
+		defer stop()
+		binding, events := connect(t, u)
+		defer binding.Finalize()
+
+		buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "<shutdown>")
+		require.NoError(t, err)
+		buf.Free()
+		ev := waitEvent(t, events)
+		assert.Equal(t, bindings.DisconnectServerShutdown, ev.Reason)
+		assert.Equal(t, io.EOF, ev.Err)
+
+		callAll(t, binding)
+		assert.Len(t, events, 0)
+	})
+
+	t.Run("connection is closed with requests in progress", func(t *testing.T) {
+		u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+			if cmd == cmdSelectSQL && bytes.Contains(body, []byte("<close>")) {
+				return io.EOF
+			}
+			_, err := conn.Write(reply)
+			return err
+		})
+		defer stop()
+		binding, events := connect(t, u)
+		defer binding.Finalize()
+
+		_, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "<close>")
+		require.Error(t, err)
+		ev := waitEvent(t, events)
+		assert.Equal(t, bindings.DisconnectNetworkError, ev.Reason)
+		assert.Error(t, ev.Err)
+
+		callAll(t, binding)
+	})
+}
+
+// fakeLoginReply returns reply to login, which advertises server capabilities caps
+func fakeLoginReply(seq uint32, caps int64) []byte {
+	return fakeRPCReplyArgs(cmdLogin, seq, func(out *cjson.Serializer) int {
+		out.PutVarUInt(uint64(bindings.ValueString))
+		out.PutVString("v2.9.1")
+		out.PutVarUInt(uint64(bindings.ValueInt64))
+		out.PutVarInt(1)
+		out.PutVarUInt(uint64(bindings.ValueInt64))
+		out.PutVarInt(caps)
+		return 3
+	})
+}
+
+// fakeRPCReplyArgs returns 'OK' reply with args, which are put by putArgs
+func fakeRPCReplyArgs(cmd uint16, seq uint32, putArgs func(out *cjson.Serializer) int) []byte {
+	args := cjson.NewSerializer(nil)
+	count := putArgs(&args)
+	out := cjson.NewSerializer(nil)
+	out.PutUInt32(cprotoMagic)
+	out.PutUInt16(cprotoVersion)
+	out.PutUInt16(cmd)
+	out.PutUInt32(0)
+	out.PutUInt32(seq)
+	out.PutVarUInt(0)
+	out.PutVString("")
+	out.PutVarUInt(uint64(count))
+	out.Write(args.Bytes())
+	*(*uint32)(unsafe.Pointer(&out.Bytes()[8])) = uint32(len(out.Bytes()) - cprotoHdrLen)
+	return out.Bytes()
+}
+
+// fakeRPCErrorReply returns reply with error code and message
+func fakeRPCErrorReply(cmd uint16, seq uint32, code int, msg string) []byte {
+	out := cjson.NewSerializer(nil)
+	out.PutUInt32(cprotoMagic)
+	out.PutUInt16(cprotoVersion)
+	out.PutUInt16(cmd)
+	out.PutUInt32(0)
+	out.PutUInt32(seq)
+	out.PutVarUInt(uint64(code))
+	out.PutVString(msg)
+	out.PutVarUInt(0)
+	*(*uint32)(unsafe.Pointer(&out.Bytes()[8])) = uint32(len(out.Bytes()) - cprotoHdrLen)
+	return out.Bytes()
+}
+
+// checksumServer - fake server, which replies to select and fetches by chunks of results with checksums.
+// Results of select have chunks count of chunks, bit of chunk corrupt (if it's not negative) is flipped after checksum is calculated
+type checksumServer struct {
+	caps       int64
+	chunks     int
+	corrupt    int
+	noChecksum bool
+	chunkSize  int
+	lock       sync.Mutex
+	flags      []int
+	closed     int
+}
+
+func (srv *checksumServer) chunk(i int) []byte {
+	return bytes.Repeat([]byte{byte('a' + i)}, srv.chunkSize)
+}
+
+func (srv *checksumServer) run(tb testing.TB) (*url.URL, func()) {
+	chunks, checksums := make([][]byte, srv.chunks), make([]uint32, srv.chunks)
+	for i := range chunks {
+		chunks[i] = srv.chunk(i)
+		checksums[i] = bindings.ResultsChecksum(chunks[i])
+		if i == srv.corrupt {
+			chunks[i][len(chunks[i])/2] ^= 0x10
+		}
+	}
+	return runFakeRPCServerFunc(tb, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		var index int
+		switch cmd {
+		case cmdLogin:
+			reply = fakeLoginReply(seq, srv.caps)
+		case cmdCloseResults:
+			srv.lock.Lock()
+			srv.closed++
+			srv.lock.Unlock()
+		case cmdSelectSQL, cmdFetchResults:
+			dec := newRPCDecoder(body)
+			dec.argsCount()
+			dec.intfArg()
+			flags := dec.intArg()
+			if cmd == cmdFetchResults {
+				index = dec.intArg()
+			}
+			srv.lock.Lock()
+			srv.flags = append(srv.flags, flags)
+			srv.lock.Unlock()
+			reqID := 1
+			if index == srv.chunks-1 {
+				reqID = -1
+			}
+			reply = fakeRPCReplyArgs(cmd, seq, func(out *cjson.Serializer) int {
+				out.PutVarUInt(uint64(bindings.ValueString))
+				out.PutVBytes(chunks[index])
+				out.PutVarUInt(uint64(bindings.ValueInt))
+				out.PutVarInt(int64(reqID))
+				if flags&bindings.ResultsWithChecksum == 0 || srv.caps&bindings.ServerCapResultChecksum == 0 || srv.noChecksum {
+					return 2
+				}
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(int64(checksums[index]))
+				return 3
+			})
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+}
+
+// fetchAll selects results of srv and fetches the rest of chunks by offset of chunk index
+func (srv *checksumServer) fetchAll(ctx context.Context, binding *NetCProto) ([][]byte, error) {
+	res, err := binding.Select(ctx, "SELECT * FROM items", false, nil, 1)
+	if err != nil {
+		return nil, err
+	}
+	buf := res.(*NetBuffer)
+	defer buf.Free()
+	chunks := [][]byte{append([]byte(nil), buf.GetBuf()...)}
+	for i := 1; i < srv.chunks; i++ {
+		if err = buf.Fetch(ctx, i, 1, false); err != nil {
+			return chunks, err
+		}
+		chunks = append(chunks, append([]byte(nil), buf.GetBuf()...))
+	}
+	return chunks, nil
+}
+
+func (srv *checksumServer) sentFlags() []int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return append([]int(nil), srv.flags...)
+}
+
+func TestResultChecksum(t *testing.T) {
+	connect := func(t *testing.T, u *url.URL, opts ...interface{}) *NetCProto {
+		binding := &NetCProto{}
+		require.NoError(t, binding.Init([]url.URL{*u}, append(opts, bindings.OptionConnPoolSize{ConnPoolSize: 1})...))
+		return binding
+	}
+	newServer := func() *checksumServer {
+		return &checksumServer{caps: bindings.ServerCapResultChecksum, chunks: 3, corrupt: -1, chunkSize: 256}
+	}
+	ctx := context.Background()
+
+	t.Run("verified", func(t *testing.T) {
+		srv := newServer()
+		u, stop := srv.run(t)
+		defer stop()
+		binding := connect(t, u, bindings.OptionResultChecksum{Enable: true})
+		defer binding.Finalize()
+		chunks, err := srv.fetchAll(ctx, binding)
+		require.NoError(t, err)
+		require.Len(t, chunks, srv.chunks)
+		for i, chunk := range chunks {
+			assert.Equal(t, srv.chunk(i), chunk)
+		}
+		for _, flags := range srv.sentFlags() {
+			assert.NotEqual(t, 0, flags&bindings.ResultsWithChecksum)
+		}
+	})
+
+	for _, corrupt := range []int{0, 2} {
+		corrupt := corrupt
+		t.Run(fmt.Sprintf("corrupted chunk #%d", corrupt), func(t *testing.T) {
+			srv := newServer()
+			srv.corrupt = corrupt
+			u, stop := srv.run(t)
+			defer stop()
+			binding := connect(t, u, bindings.OptionResultChecksum{Enable: true})
+			defer binding.Finalize()
+			chunks, err := srv.fetchAll(ctx, binding)
+			require.Error(t, err)
+			rerr, ok := err.(*bindings.ErrResultCorrupted)
+			require.True(t, ok, "unexpected error: %v", err)
+			assert.Equal(t, corrupt, rerr.Chunk)
+			assert.Equal(t, bindings.ResultsChecksum(srv.chunk(corrupt)), rerr.Expected)
+			assert.NotEqual(t, rerr.Expected, rerr.Actual)
+			assert.Contains(t, err.Error(), fmt.Sprintf("chunk #%d", corrupt))
+			assert.Len(t, chunks, corrupt)
+			// Server-side results are closed, unless the last chunk is corrupted
+			srv.lock.Lock()
+			assert.Equal(t, corrupt < srv.chunks-1, srv.closed == 1)
+			srv.lock.Unlock()
+		})
+	}
+
+	t.Run("per query mode", func(t *testing.T) {
+		srv := newServer()
+		srv.corrupt = 1
+		u, stop := srv.run(t)
+		defer stop()
+		binding := connect(t, u)
+		defer binding.Finalize()
+		_, err := srv.fetchAll(ctx, binding)
+		assert.NoError(t, err, "results are verified by default")
+		_, err = srv.fetchAll(bindings.ContextWithResultChecksum(ctx, bindings.ResultChecksumEnabled), binding)
+		assert.IsType(t, &bindings.ErrResultCorrupted{}, err)
+
+		verified := connect(t, u, bindings.OptionResultChecksum{Enable: true})
+		defer verified.Finalize()
+		_, err = srv.fetchAll(bindings.ContextWithResultChecksum(ctx, bindings.ResultChecksumDisabled), verified)
+		assert.NoError(t, err)
+	})
+
+	t.Run("missing checksum", func(t *testing.T) {
+		srv := newServer()
+		srv.noChecksum = true
+		u, stop := srv.run(t)
+		defer stop()
+		binding := connect(t, u, bindings.OptionResultChecksum{Enable: true})
+		defer binding.Finalize()
+		_, err := srv.fetchAll(ctx, binding)
+		rerr, ok := err.(*bindings.ErrResultCorrupted)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.True(t, rerr.Missing)
+	})
+
+	t.Run("unsupported by server", func(t *testing.T) {
+		srv := newServer()
+		srv.caps = 0
+		u, stop := srv.run(t)
+		defer stop()
+		binding := connect(t, u, bindings.OptionResultChecksum{Enable: true})
+		defer binding.Finalize()
+		_, err := srv.fetchAll(ctx, binding)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not supported by server")
+	})
+}
+
+// BenchmarkResultChecksum measures overhead of results verification on select of chunk of bench.chunkSize bytes from fake server
+func BenchmarkResultChecksum(b *testing.B) {
+	for _, chunkSize := range []int{4 << 10, 256 << 10} {
+		srv := &checksumServer{caps: bindings.ServerCapResultChecksum, chunks: 1, corrupt: -1, chunkSize: chunkSize}
+		u, stop := srv.run(b)
+		for _, enable := range []bool{false, true} {
+			b.Run(fmt.Sprintf("chunk=%dKB/checksum=%v", chunkSize>>10, enable), func(b *testing.B) {
+				binding := &NetCProto{}
+				require.NoError(b, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionResultChecksum{Enable: enable}))
+				defer binding.Finalize()
+				b.SetBytes(int64(chunkSize))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					res, err := binding.Select(context.Background(), "SELECT * FROM items", false, nil, 1)
+					if err != nil {
+						b.Fatal(err)
+					}
+					res.Free()
+				}
+			})
+		}
+		stop()
+	}
+}
+
+// BenchmarkResultsChecksumCalc measures CRC-32C calculation itself
+func BenchmarkResultsChecksumCalc(b *testing.B) {
+	chunk := bytes.Repeat([]byte("results"), (256<<10)/7)
+	b.SetBytes(int64(len(chunk)))
+	for i := 0; i < b.N; i++ {
+		bindings.ResultsChecksum(chunk)
+	}
+}
+
+// bulkServer - fake server, which records connections of selects and fetches. Results of 'SELECT slow' are replied after release is closed,
+// results of the other selects have chunks count of chunks (0 - infinite). Chunks are of 16 bytes, except results of 'SELECT big' and fetches,
+// which are of chunkSize bytes. pieceDelay limits bandwidth of connections
+type bulkServer struct {
+	chunks     int
+	chunkSize  int
+	pieceDelay time.Duration
+	release    chan struct{}
+	lock       sync.Mutex
+	connIDs    map[net.Conn]int
+	reqs       []bulkReq
+	replies    map[bulkReply][]byte
+}
+
+type bulkReply struct {
+	cmd   uint16
+	reqID int
+	big   bool
+}
+
+type bulkReq struct {
+	cmd    uint16
+	connID int
+	query  string
+}
+
+func (srv *bulkServer) run(tb testing.TB) (*url.URL, func()) {
+	srv.connIDs = make(map[net.Conn]int)
+	srv.replies = make(map[bulkReply][]byte)
+	for _, cmd := range []uint16{cmdSelectSQL, cmdFetchResults} {
+		for _, reqID := range []int{-1, 1} {
+			for _, big := range []bool{false, true} {
+				chunk := bytes.Repeat([]byte{'a'}, 16)
+				if big {
+					chunk = bytes.Repeat([]byte{'a'}, srv.chunkSize)
+				}
+				srv.replies[bulkReply{cmd, reqID, big}] = fakeRPCReplyArgs(cmd, 0, func(out *cjson.Serializer) int {
+					out.PutVarUInt(uint64(bindings.ValueString))
+					out.PutVBytes(chunk)
+					out.PutVarUInt(uint64(bindings.ValueInt))
+					out.PutVarInt(int64(reqID))
+					return 2
+				})
+			}
+		}
+	}
+	return runFakeRPCServerFunc(tb, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		srv.lock.Lock()
+		connID, ok := srv.connIDs[conn]
+		if !ok {
+			connID = len(srv.connIDs)
+			srv.connIDs[conn] = connID
+		}
+		srv.lock.Unlock()
+
+		switch cmd {
+		case cmdLogin:
+			reply = fakeLoginReply(seq, 0)
+		case cmdCloseResults:
+			srv.lock.Lock()
+			srv.reqs = append(srv.reqs, bulkReq{cmd: cmd, connID: connID})
+			srv.lock.Unlock()
+		case cmdSelectSQL, cmdFetchResults:
+			dec := newRPCDecoder(body)
+			dec.argsCount()
+			req := bulkReq{cmd: cmd, connID: connID}
+			var index int
+			if cmd == cmdSelectSQL {
+				query, _ := dec.intfArg().([]byte)
+				req.query = string(query)
+			} else {
+				// Args of fetch: results ID, flags, offset and limit
+				dec.intArg()
+				dec.intArg()
+				index = dec.intArg()
+			}
+			srv.lock.Lock()
+			srv.reqs = append(srv.reqs, req)
+			srv.lock.Unlock()
+			reqID := 1
+			if req.query == "SELECT slow" || (srv.chunks > 0 && index >= srv.chunks-1) {
+				reqID = -1
+			}
+			reply = srv.reply(bulkReply{cmd, reqID, cmd == cmdFetchResults || req.query == "SELECT big"}, seq)
+			if req.query == "SELECT slow" {
+				// Next requests of the connection are not blocked
+				go func() {
+					<-srv.release
+					conn.Write(reply)
+				}()
+				return nil
+			}
+		}
+		return srv.write(conn, reply)
+	})
+}
+
+// write writes reply by pieces of 64KB with delay srv.pieceDelay between them
+func (srv *bulkServer) write(conn net.Conn, reply []byte) error {
+	const pieceSize = 64 << 10
+	for len(reply) > pieceSize && srv.pieceDelay > 0 {
+		if _, err := conn.Write(reply[:pieceSize]); err != nil {
+			return err
+		}
+		reply = reply[pieceSize:]
+		time.Sleep(srv.pieceDelay)
+	}
+	_, err := conn.Write(reply)
+	return err
+}
+
+// reply returns copy of precomputed reply with seq
+func (srv *bulkServer) reply(key bulkReply, seq uint32) []byte {
+	reply := append([]byte(nil), srv.replies[key]...)
+	*(*uint32)(unsafe.Pointer(&reply[12])) = seq
+	return reply
+}
+
+// takeReqs returns requests, received since the previous call
+func (srv *bulkServer) takeReqs() []bulkReq {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	reqs := srv.reqs
+	srv.reqs = nil
+	return reqs
+}
+
+func (srv *bulkServer) connsCount() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return len(srv.connIDs)
+}
+
+func TestBulkConns(t *testing.T) {
+	ctx := context.Background()
+	bulkCtx := bindings.ContextWithBulkConn(ctx)
+	srv := &bulkServer{chunks: 3, chunkSize: 16, release: make(chan struct{})}
+	u, stop := srv.run(t)
+	defer stop()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionBulkConns{Count: 2}))
+	defer binding.Finalize()
+	// Login of the main pool
+	require.Equal(t, 1, srv.connsCount())
+	assert.Equal(t, 0, binding.Status(ctx).CProto.BulkConnPoolSize, "bulk connections are connected on demand")
+
+	t.Run("bulk query and its fetches", func(t *testing.T) {
+		res, err := binding.Select(bulkCtx, "SELECT * FROM items", false, nil, 1)
+		require.NoError(t, err)
+		buf := res.(*NetBuffer)
+		for i := 1; i < srv.chunks; i++ {
+			// Fetches are sent via connection of results regardless of ctx
+			require.NoError(t, buf.Fetch(ctx, i, 1, false))
+		}
+		buf.Free()
+		reqs := srv.takeReqs()
+		require.Len(t, reqs, srv.chunks)
+		for _, req := range reqs {
+			assert.Equal(t, 1, req.connID)
+		}
+		status := binding.Status(ctx).CProto
+		assert.Equal(t, 1, status.BulkConnPoolSize)
+		assert.Equal(t, 0, status.BulkConnPoolUsage)
+		assert.Equal(t, 1, status.ConnPoolSize)
+	})
+
+	t.Run("close of bulk results", func(t *testing.T) {
+		res, err := binding.Select(bulkCtx, "SELECT * FROM items", false, nil, 1)
+		require.NoError(t, err)
+		buf := res.(*NetBuffer)
+		require.NoError(t, buf.Fetch(ctx, 1, 1, false))
+		// Results are not fetched till the end, so they are closed via their connection
+		buf.Free()
+		reqs := srv.takeReqs()
+		require.Len(t, reqs, 3)
+		assert.Equal(t, uint16(cmdCloseResults), reqs[2].cmd)
+		for _, req := range reqs {
+			assert.Equal(t, 1, req.connID)
+		}
+	})
+
+	t.Run("other queries", func(t *testing.T) {
+		res, err := binding.Select(ctx, "SELECT * FROM items", false, nil, 1)
+		require.NoError(t, err)
+		res.Free()
+		reqs := srv.takeReqs()
+		require.Len(t, reqs, 2)
+		for _, req := range reqs {
+			assert.Equal(t, 0, req.connID)
+		}
+	})
+
+	t.Run("stats of bulk connections", func(t *testing.T) {
+		requests := func(bulk bool) (count int64) {
+			for _, st := range binding.Stats() {
+				if st.Bulk == bulk {
+					count += st.Requests
+				}
+			}
+			return count
+		}
+		mainRequests, bulkRequests := requests(false), requests(true)
+		res, err := binding.Select(bulkCtx, "SELECT * FROM items", false, nil, 1)
+		require.NoError(t, err)
+		require.NoError(t, res.(*NetBuffer).Fetch(ctx, 1, 1, false))
+		res.Free()
+		srv.takeReqs()
+		// Query, fetch and close of results
+		assert.Equal(t, bulkRequests+3, requests(true))
+		assert.Equal(t, mainRequests, requests(false))
+	})
+
+	t.Run("concurrent bulk queries", func(t *testing.T) {
+		const queries = 3
+		var wg sync.WaitGroup
+		errs := make(chan error, queries)
+		for i := 0; i < queries; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res, err := binding.Select(bulkCtx, "SELECT slow", false, nil, 1)
+				if err == nil {
+					res.Free()
+				}
+				errs <- err
+			}()
+		}
+		var reqs []bulkReq
+		for deadline := time.Now().Add(5 * time.Second); len(reqs) < queries && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			reqs = append(reqs, srv.takeReqs()...)
+		}
+		status := binding.Status(ctx).CProto
+		close(srv.release)
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+		require.Len(t, reqs, queries)
+
+		// New connection is connected only while all the bulk connections are busy, up to Count
+		assert.Equal(t, 2, status.BulkConnPoolSize)
+		assert.Equal(t, 2, status.BulkConnPoolUsage)
+		assert.Equal(t, queries, status.BulkConnQueueUsage)
+		assert.Equal(t, 2*defQueueSize, status.BulkConnQueueSize)
+		assert.Equal(t, 3, srv.connsCount())
+		for _, req := range reqs {
+			assert.NotEqual(t, 0, req.connID)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		plain := &NetCProto{}
+		require.NoError(t, plain.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+		defer plain.Finalize()
+		res, err := plain.Select(bulkCtx, "SELECT * FROM items", false, nil, 1)
+		require.NoError(t, err)
+		res.Free()
+		assert.Equal(t, 0, plain.Status(ctx).CProto.BulkConnPoolSize)
+		assert.Equal(t, 4, srv.connsCount())
+	})
+}
+
+// BenchmarkBulkConns measures latency of point selects via single connection of the main pool, while 2 goroutines fetch results of bulk query by chunks of 1MB.
+// Bandwidth of connections is limited to about 1Gbit/s, so replies to selects wait for the chunks, which are sent before them via the same connection
+func BenchmarkBulkConns(b *testing.B) {
+	srv := &bulkServer{chunkSize: 1 << 20, pieceDelay: 500 * time.Microsecond}
+	u, stop := srv.run(b)
+	defer stop()
+	for _, bulkConns := range []int{0, 1} {
+		b.Run(fmt.Sprintf("bulk_conns=%d", bulkConns), func(b *testing.B) {
+			binding := &NetCProto{}
+			require.NoError(b, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionBulkConns{Count: bulkConns}))
+			defer binding.Finalize()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					res, err := binding.Select(bindings.ContextWithBulkConn(ctx), "SELECT big", false, nil, 1)
+					if err != nil {
+						return
+					}
+					buf := res.(*NetBuffer)
+					defer buf.Free()
+					for i := 1; ctx.Err() == nil; i++ {
+						if buf.Fetch(ctx, i, 1, false) != nil {
+							return
+						}
+					}
+				}()
+			}
+			latencies := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				start := time.Now()
+				res, err := binding.Select(context.Background(), "SELECT * FROM items WHERE id = 1", false, nil, 1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				latencies = append(latencies, time.Since(start))
+				res.Free()
+			}
+			b.StopTimer()
+			cancel()
+			wg.Wait()
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-us")
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	const openDuration = 300 * time.Millisecond
+	var hang int32
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdLogin {
+			reply = fakeLoginReply(seq, 0)
+		} else if atomic.LoadInt32(&hang) != 0 {
+			return nil
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	var lock sync.Mutex
+	var events []string
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionCircuitBreaker{
+		FailureThreshold: 2,
+		OpenDuration:     openDuration,
+		OnStateChange: func(ev bindings.CircuitBreakerEvent) {
+			lock.Lock()
+			events = append(events, ev.From+"->"+ev.To)
+			lock.Unlock()
+		},
+	}))
+	defer binding.Finalize()
+	ping := func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return binding.Ping(ctx)
+	}
+	breakerState := func() bindings.CircuitBreakerStatus {
+		breakers := binding.Status(context.Background()).CProto.CircuitBreakers
+		require.Len(t, breakers, 1)
+		return breakers[0]
+	}
+
+	require.NoError(t, ping(time.Second))
+	assert.Equal(t, bindings.CircuitBreakerStatus{Host: u.Host, State: bindings.CircuitClosed}, breakerState())
+
+	atomic.StoreInt32(&hang, 1)
+	assert.Equal(t, context.DeadlineExceeded, ping(100*time.Millisecond))
+	assert.Equal(t, bindings.CircuitBreakerStatus{Host: u.Host, State: bindings.CircuitClosed, Failures: 1}, breakerState())
+	assert.Equal(t, context.DeadlineExceeded, ping(100*time.Millisecond))
+	assert.Equal(t, bindings.CircuitOpen, breakerState().State)
+
+	start := time.Now()
+	err := ping(time.Second)
+	assert.True(t, time.Since(start) < 50*time.Millisecond, "request must fail without waiting for server")
+	require.IsType(t, &bindings.ErrCircuitOpen{}, err)
+	assert.Equal(t, u.Host, err.(*bindings.ErrCircuitOpen).Host)
+	assert.True(t, err.(*bindings.ErrCircuitOpen).RetryAfter > 0)
+	_, err = binding.Select(context.Background(), "SELECT * FROM items", false, nil, 1)
+	assert.IsType(t, &bindings.ErrCircuitOpen{}, err)
+
+	t.Run("failed probe", func(t *testing.T) {
+		time.Sleep(openDuration)
+		probeErr := make(chan error, 1)
+		go func() { probeErr <- ping(200 * time.Millisecond) }()
+		for deadline := time.Now().Add(time.Second); breakerState().State != bindings.CircuitHalfOpen && time.Now().Before(deadline); {
+			time.Sleep(5 * time.Millisecond)
+		}
+		// Only one probe is in flight
+		err := ping(time.Second)
+		require.IsType(t, &bindings.ErrCircuitOpen{}, err)
+		assert.Equal(t, time.Duration(0), err.(*bindings.ErrCircuitOpen).RetryAfter)
+		assert.Equal(t, context.DeadlineExceeded, <-probeErr)
+		assert.Equal(t, bindings.CircuitOpen, breakerState().State)
+	})
+
+	t.Run("successful probe", func(t *testing.T) {
+		atomic.StoreInt32(&hang, 0)
+		time.Sleep(openDuration)
+		require.NoError(t, ping(time.Second))
+		assert.Equal(t, bindings.CircuitBreakerStatus{Host: u.Host, State: bindings.CircuitClosed}, breakerState())
+		require.NoError(t, ping(time.Second))
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"closed->open", "open->half_open", "half_open->open", "open->half_open", "half_open->closed"}, events)
+}
+
+func runTestServer() (s *testServer, addr *url.URL, err error) {
+	startPort := 40000
+	var l net.Listener
+	var port int
+	for port = startPort; port < startPort+10; port++ {
+		if l, err = net.Listen("tcp", fmt.Sprintf(":%d", port)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	s = &testServer{l: l}
+	go s.acceptLoop()
+	addr, _ = url.Parse(fmt.Sprintf("cproto://127.0.0.1:%d", port))
+	return
+}
+
+type testServer struct {
+	l     net.Listener
+	conns []net.Conn
+}
+
+func (s *testServer) acceptLoop() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.conns = append(s.conns, conn)
 	}
 }
 
 func (s *testServer) Close() {
 	s.l.Close()
 }
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cproto_unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sock := dir + "/reindexer.sock"
+	l, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	var logins []string
+	_, stop := serveFakeRPC(l, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdLogin {
+			lock.Lock()
+			logins = append(logins, string(body))
+			lock.Unlock()
+			reply = fakeLoginReply(seq, 0)
+		}
+		if _, err := conn.Write(reply); err != nil {
+			return err
+		}
+		if cmd != cmdLogin {
+			// Client must reconnect on the next request
+			return io.EOF
+		}
+		return nil
+	})
+	defer stop()
+
+	u, err := url.Parse("ucproto://" + sock + ":/testdb")
+	require.NoError(t, err)
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+	defer binding.Finalize()
+	for i := 0; i < 3; i++ {
+		err = binding.Ping(context.Background())
+		if err != nil {
+			// Request, which is sent to the closed connection, fails
+			require.NoError(t, binding.Ping(context.Background()))
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.True(t, len(logins) > 1, "client is not reconnected")
+	for _, login := range logins {
+		assert.Contains(t, login, "testdb")
+		assert.NotContains(t, login, "reindexer.sock")
+	}
+}
+
+func TestServerErrorCodes(t *testing.T) {
+	// Server fails select, commit of transaction and items of transaction with error of code
+	var code int32
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		switch cmd {
+		case cmdStartTransaction:
+			reply = fakeRPCReplyArgs(cmd, seq, func(out *cjson.Serializer) int {
+				out.PutVarUInt(uint64(bindings.ValueInt64))
+				out.PutVarInt(1)
+				return 1
+			})
+		case cmdSelectSQL, cmdCommitTx, cmdAddTxItem:
+			reply = fakeRPCErrorReply(cmd, seq, int(atomic.LoadInt32(&code)), fmt.Sprintf("error of command %d", cmd))
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}))
+	defer binding.Finalize()
+
+	ctx := context.Background()
+	errs := func() map[string]error {
+		errs := make(map[string]error)
+		_, errs["select"] = binding.rpcCall(ctx, opRd, cmdSelectSQL, "")
+		txCtx, err := binding.BeginTx(ctx, "items")
+		require.NoError(t, err)
+		txCtx.UserCtx = ctx
+		done := make(chan error, 1)
+		binding.ModifyItemTxAsync(&txCtx, 0, []byte{}, 0, nil, 0, func(buf bindings.RawBuffer, err error) {
+			done <- err
+		})
+		errs["tx item"] = <-done
+		_, errs["commit"] = binding.CommitTx(&txCtx)
+		return errs
+	}
+
+	for _, tc := range []struct {
+		code     int
+		sentinel bindings.CodeError
+	}{
+		{bindings.ErrParseSQL, bindings.ErrorParseSQL},
+		{bindings.ErrQueryExec, bindings.ErrorQueryExec},
+		{bindings.ErrParams, bindings.ErrorParams},
+		{bindings.ErrLogic, bindings.ErrorLogic},
+		{bindings.ErrParseJson, bindings.ErrorParseJson},
+		{bindings.ErrParseDSL, bindings.ErrorParseDSL},
+		{bindings.ErrConflict, bindings.ErrorConflict},
+		{bindings.ErrParseBin, bindings.ErrorParseBin},
+		{bindings.ErrForbidden, bindings.ErrorForbidden},
+		{bindings.ErrWasRelock, bindings.ErrorWasRelock},
+		{bindings.ErrNotValid, bindings.ErrorNotValid},
+		{bindings.ErrNetwork, bindings.ErrorNetwork},
+		{bindings.ErrNotFound, bindings.ErrorNotFound},
+		{bindings.ErrStateInvalidated, bindings.ErrorStateInvalidated},
+		{bindings.ErrBadTransaction, bindings.ErrorBadTransaction},
+		{bindings.ErrOutdatedWAL, bindings.ErrorOutdatedWAL},
+		{bindings.ErrNoWAL, bindings.ErrorNoWAL},
+		{bindings.ErrDataHashMismatch, bindings.ErrorDataHashMismatch},
+		{bindings.ErrTagsMissmatch, bindings.ErrorTagsMissmatch},
+		{bindings.ErrReplParams, bindings.ErrorReplParams},
+		{bindings.ErrNsInvalidated, bindings.ErrorNsInvalidated},
+		{bindings.ErrStateMismatch, bindings.ErrorStateMismatch},
+		{1000, bindings.CodeError(1000)},
+	} {
+		atomic.StoreInt32(&code, int32(tc.code))
+		for path, err := range errs() {
+			require.Error(t, err, "code %d of %s", tc.code, path)
+			assert.True(t, errors.Is(err, tc.sentinel), "code %d of %s: %v", tc.code, path, err)
+			if tc.code != bindings.ErrParams {
+				assert.False(t, errors.Is(err, bindings.ErrorParams), "code %d of %s", tc.code, path)
+			}
+			var codeErr bindings.CodeError
+			require.True(t, errors.As(err, &codeErr), "code %d of %s", tc.code, path)
+			assert.Equal(t, tc.code, codeErr.Code())
+			assert.Equal(t, tc.code != 1000, codeErr.Known())
+			rerr, ok := err.(bindings.Error)
+			require.True(t, ok, "code %d of %s: %T", tc.code, path, err)
+			assert.Equal(t, tc.code, rerr.Code())
+			assert.Contains(t, rerr.Error(), "error of command")
+		}
+	}
+	assert.Equal(t, "rq: error with code 1000", bindings.CodeError(1000).Error())
+
+	// Timeout and cancel are context errors
+	atomic.StoreInt32(&code, bindings.ErrTimeout)
+	for path, err := range errs() {
+		assert.Equal(t, context.DeadlineExceeded, err, path)
+	}
+	atomic.StoreInt32(&code, bindings.ErrCanceled)
+	for path, err := range errs() {
+		assert.Equal(t, context.Canceled, err, path)
+	}
+}
+
+func TestMalformedReplyArgs(t *testing.T) {
+	// Server replies with args of wrong count or types, as server of another version may do
+	var replies sync.Map
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if putArgs, ok := replies.Load(cmd); ok {
+			reply = fakeRPCReplyArgs(cmd, seq, putArgs.(func(out *cjson.Serializer) int))
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	putString := func(out *cjson.Serializer, s string) {
+		out.PutVarUInt(uint64(bindings.ValueString))
+		out.PutVString(s)
+	}
+	putInt := func(out *cjson.Serializer, v int64) {
+		out.PutVarUInt(uint64(bindings.ValueInt))
+		out.PutVarInt(v)
+	}
+
+	t.Run("login", func(t *testing.T) {
+		replies.Store(uint16(cmdLogin), func(out *cjson.Serializer) int {
+			putString(out, "v2.9.1")
+			putString(out, "1")
+			return 2
+		})
+		defer replies.Delete(uint16(cmdLogin))
+		binding := &NetCProto{}
+		err := binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1})
+		defer binding.Finalize()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Invalid reply to command Login: arg 1 has type string, but int64 is expected")
+	})
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+	defer binding.Finalize()
+	ctx := context.Background()
+	conn := binding.pool.conns[0]
+
+	for _, tc := range []struct {
+		name    string
+		cmd     uint16
+		putArgs func(out *cjson.Serializer) int
+		call    func() error
+		err     string
+	}{
+		{"tx id of wrong type", cmdStartTransaction, func(out *cjson.Serializer) int {
+			putString(out, "1")
+			return 1
+		}, func() error {
+			_, err := binding.BeginTx(ctx, "items")
+			return err
+		}, "Invalid reply to command StartTransaction: arg 0 has type string, but int64 is expected"},
+		{"missing tx id", cmdStartTransaction, func(out *cjson.Serializer) int {
+			return 0
+		}, func() error {
+			_, err := binding.BeginTx(ctx, "items")
+			return err
+		}, "Invalid reply to command StartTransaction: arg 0 of type int64 is expected, but reply has 0 args"},
+		{"results id of wrong type", cmdSelectSQL, func(out *cjson.Serializer) int {
+			putString(out, "results")
+			putString(out, "5")
+			return 2
+		}, func() error {
+			_, err := binding.Select(ctx, "SELECT * FROM items", false, nil, 1)
+			return err
+		}, "Invalid reply to command SelectSQL: arg 1 has type string, but int64 is expected"},
+		{"results of wrong type", cmdSelect, func(out *cjson.Serializer) int {
+			putInt(out, 5)
+			putInt(out, 5)
+			return 2
+		}, func() error {
+			_, err := binding.SelectQuery(ctx, nil, false, nil, 1)
+			return err
+		}, "Invalid reply to command Select: arg 0 has type int, but string is expected"},
+		{"arg of unknown type", cmdSelect, func(out *cjson.Serializer) int {
+			out.PutVarUInt(100)
+			return 1
+		}, func() error {
+			_, err := binding.SelectQuery(ctx, nil, false, nil, 1)
+			return err
+		}, "Malformed reply to command Select"},
+		{"missing meta", cmdGetMeta, func(out *cjson.Serializer) int {
+			return 0
+		}, func() error {
+			_, err := binding.GetMeta(ctx, "items", "key")
+			return err
+		}, "Invalid reply to command GetMeta: arg 0 of type string is expected, but reply has 0 args"},
+		{"meta key of wrong type", cmdEnumMeta, func(out *cjson.Serializer) int {
+			putString(out, "key")
+			putInt(out, 5)
+			return 2
+		}, func() error {
+			_, err := binding.EnumMeta(ctx, "items")
+			return err
+		}, "Invalid reply to command EnumMeta: arg 1 has type int, but string is expected"},
+		{"missing fetched results id", cmdFetchResults, func(out *cjson.Serializer) int {
+			putString(out, "results")
+			return 1
+		}, func() error {
+			replies.Store(uint16(cmdSelectSQL), func(out *cjson.Serializer) int {
+				putString(out, "results")
+				putInt(out, 5)
+				return 2
+			})
+			defer replies.Delete(uint16(cmdSelectSQL))
+			buf, err := binding.Select(ctx, "SELECT * FROM items", false, nil, 1)
+			require.NoError(t, err)
+			defer buf.Free()
+			return buf.(*NetBuffer).Fetch(ctx, 1, 1, false)
+		}, "Invalid reply to command FetchResults: arg 1 of type int64 is expected, but reply has 1 args"},
+	} {
+		replies.Store(tc.cmd, tc.putArgs)
+		var err error
+		assert.NotPanics(t, func() { err = tc.call() }, tc.name)
+		replies.Delete(tc.cmd)
+		require.Error(t, err, tc.name)
+		assert.Contains(t, err.Error(), tc.err, tc.name)
+		assert.True(t, errors.Is(err, bindings.ErrorParseBin), tc.name)
+		// Connection is still usable
+		require.NoError(t, binding.Ping(ctx), tc.name)
+		assert.True(t, conn == binding.pool.conns[0], tc.name)
+		assert.NoError(t, conn.curError(), tc.name)
+	}
+}
+
+func TestConnectHook(t *testing.T) {
+	// Server records commands of each connection and drops connection on select, while drop is set
+	var lock sync.Mutex
+	var conns []net.Conn
+	cmds := make(map[net.Conn][]uint16)
+	var drop int32
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		lock.Lock()
+		if cmd == cmdLogin {
+			conns = append(conns, conn)
+		}
+		cmds[conn] = append(cmds[conn], cmd)
+		lock.Unlock()
+		if cmd == cmdSelectSQL && atomic.LoadInt32(&drop) != 0 {
+			return errConnClosed
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	connCmds := func(i int) []uint16 {
+		lock.Lock()
+		defer lock.Unlock()
+		require.True(t, i < len(conns), "connections: %d", len(conns))
+		return cmds[conns[i]]
+	}
+
+	var calls int32
+	var hookErr atomic.Value
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionConnectHook{
+		OnConnect: func(ctx context.Context, rb bindings.RawBinding) error {
+			atomic.AddInt32(&calls, 1)
+			if err, _ := hookErr.Load().(error); err != nil {
+				return err
+			}
+			return rb.Ping(ctx)
+		},
+	}))
+	defer binding.Finalize()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, []uint16{cmdLogin, cmdPing}, connCmds(0))
+
+	// Requests of the callback of client and of the hook are sent via the new connection before the other requests
+	var callbacks int32
+	binding.OnConnectCallback(func(ctx context.Context, rb bindings.RawBinding) error {
+		atomic.AddInt32(&callbacks, 1)
+		return rb.Ping(ctx)
+	})
+	atomic.StoreInt32(&drop, 1)
+	_, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+	require.Error(t, err)
+	atomic.StoreInt32(&drop, 0)
+	buf, err := binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+	require.NoError(t, err)
+	buf.Free()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callbacks))
+	assert.Equal(t, []uint16{cmdLogin, cmdPing, cmdPing, cmdSelectSQL}, connCmds(1))
+
+	// Error of hook fails the connection
+	hookErr.Store(bindings.NewError("hook failed", bindings.ErrLogic))
+	atomic.StoreInt32(&drop, 1)
+	_, err = binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+	require.Error(t, err)
+	atomic.StoreInt32(&drop, 0)
+	_, err = binding.rpcCall(context.Background(), opRd, cmdSelectSQL, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hook failed")
+}
+
+func TestDialer(t *testing.T) {
+	u, stop := runFakeRPCServer(t, nil)
+	defer stop()
+	var dials int32
+	var deadlineSet int32
+	dialErr := errors.New("proxy is down")
+	var fail int32
+	dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		if _, ok := ctx.Deadline(); ok {
+			atomic.StoreInt32(&deadlineSet, 1)
+		}
+		if atomic.LoadInt32(&fail) != 0 {
+			return nil, dialErr
+		}
+		assert.Equal(t, "tcp", network)
+		assert.Equal(t, u.Host, addr)
+		conn, err := net.Dial(network, addr)
+		// Connection of proxy is not *net.TCPConn
+		return struct{ net.Conn }{conn}, err
+	}
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2},
+		bindings.OptionTimeouts{LoginTimeout: time.Second}, bindings.OptionDialer{DialContext: dialer}))
+	defer binding.Finalize()
+	require.NoError(t, binding.Ping(context.Background()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&dials))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&deadlineSet))
+
+	atomic.StoreInt32(&fail, 1)
+	failed := &NetCProto{}
+	err := failed.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionDialer{DialContext: dialer})
+	defer failed.Finalize()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), dialErr.Error())
+}
+
+func TestTCPOptions(t *testing.T) {
+	u, stop := runFakeRPCServer(t, nil)
+	defer stop()
+
+	for _, tc := range []struct {
+		dsn  url.URL
+		opts bindings.OptionTCP
+	}{
+		{*u, bindings.OptionTCP{ReadBufSize: -1}},
+		{*u, bindings.OptionTCP{WriteBufSize: -1}},
+		{*u, bindings.OptionTCP{KeepAlivePeriod: time.Millisecond}},
+		{url.URL{Scheme: unixScheme, Path: "/tmp/rx.sock:/db"}, bindings.OptionTCP{KeepAlivePeriod: time.Second}},
+	} {
+		binding := &NetCProto{}
+		err := binding.Init([]url.URL{tc.dsn}, tc.opts)
+		require.Error(t, err, "%+v", tc.opts)
+		assert.Equal(t, bindings.ErrParams, err.(bindings.Error).Code(), "%+v", tc.opts)
+		binding.Finalize()
+	}
+
+	const readBufSize = 1 << 20
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1},
+		bindings.OptionTCP{KeepAlivePeriod: 5 * time.Second, ReadBufSize: readBufSize, WriteBufSize: readBufSize / 2}))
+	defer binding.Finalize()
+	require.NoError(t, binding.Ping(context.Background()))
+	assert.Equal(t, readBufSize, binding.getAllConns()[0].rdBuf.Size())
+
+	// Connections of custom dialer aren't tuned
+	dialed := &NetCProto{}
+	require.NoError(t, dialed.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, bindings.OptionTCP{KeepAlivePeriod: -1},
+		bindings.OptionDialer{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			return struct{ net.Conn }{conn}, err
+		}}))
+	defer dialed.Finalize()
+	require.NoError(t, dialed.Ping(context.Background()))
+	assert.Equal(t, bufsCap, dialed.getAllConns()[0].rdBuf.Size())
+}
+
+func TestFailover(t *testing.T) {
+	// Preferred server is down at start, then it's started on the same address, then its connections are dropped
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	preferredAddr := l.Addr().String()
+	l.Close()
+	var preferredPings, down int32
+	replica, stopReplica := runFakeRPCServer(t, nil)
+	defer stopReplica()
+
+	binding := &NetCProto{}
+	preferred := url.URL{Scheme: "cproto", Host: preferredAddr, Path: "/db"}
+	require.NoError(t, binding.Init([]url.URL{preferred, *replica}, bindings.OptionConnPoolSize{ConnPoolSize: 1},
+		bindings.OptionFailback{ProbeInterval: 20 * time.Millisecond}))
+	defer binding.Finalize()
+	status := binding.Status(context.Background()).CProto
+	assert.Equal(t, replica.Host, status.ActiveHost)
+	assert.Equal(t, []string{replica.Host}, status.ConnHosts)
+	require.NoError(t, binding.Ping(context.Background()))
+
+	l, err = net.Listen("tcp", preferredAddr)
+	require.NoError(t, err)
+	_, stopPreferred := serveFakeRPC(l, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if atomic.LoadInt32(&down) != 0 {
+			return errConnClosed
+		}
+		if cmd == cmdPing {
+			atomic.AddInt32(&preferredPings, 1)
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stopPreferred()
+	for i := 0; i < 100 && binding.Status(context.Background()).CProto.ActiveHost != preferredAddr; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, preferredAddr, binding.Status(context.Background()).CProto.ActiveHost)
+	// Drained connection is connected to the preferred server by the next request
+	require.NoError(t, binding.Ping(context.Background()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&preferredPings))
+	assert.Equal(t, []string{preferredAddr}, binding.Status(context.Background()).CProto.ConnHosts)
+
+	// Requests fail over to the replica, when the preferred server goes away
+	stopPreferred()
+	atomic.StoreInt32(&down, 1)
+	err = binding.Ping(context.Background())
+	for i := 0; i < 3 && err != nil; i++ {
+		err = binding.Ping(context.Background())
+	}
+	require.NoError(t, err)
+	status = binding.Status(context.Background()).CProto
+	assert.Equal(t, replica.Host, status.ActiveHost)
+	assert.Equal(t, []string{replica.Host}, status.ConnHosts)
+}
+
+func TestPoolStrategy(t *testing.T) {
+	newPool := func(strategy int) *pool {
+		p := &pool{strategy: strategy}
+		for i := 0; i < 3; i++ {
+			c := &connection{}
+			c.initRequests(4)
+			p.conns = append(p.conns, c)
+		}
+		return p
+	}
+	// load takes seq nums of connections like requests in flight
+	load := func(p *pool, inFlight ...int) {
+		for i, n := range inFlight {
+			for j := 0; j < n; j++ {
+				<-p.conns[i].seqs
+			}
+		}
+	}
+	counts := func(p *pool, gets int) []int {
+		counts := make([]int, len(p.conns))
+		for i := 0; i < gets; i++ {
+			counts[p.slotOf(p.get())]++
+		}
+		return counts
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		p := newPool(bindings.PoolRoundRobin)
+		load(p, 2, 1, 0)
+		assert.Equal(t, []int{2, 2, 2}, counts(p, 6))
+	})
+
+	t.Run("least in flight", func(t *testing.T) {
+		p := newPool(bindings.PoolLeastInFlight)
+		assert.Equal(t, []int{2, 2, 2}, counts(p, 6))
+		load(p, 2, 1, 0)
+		assert.Equal(t, []int{0, 0, 6}, counts(p, 6))
+		load(p, 0, 0, 3)
+		assert.Equal(t, []int{0, 6, 0}, counts(p, 6))
+		// Failed connection is returned in its turn to be connected again
+		p.conns[1].err = errors.New("connection is broken")
+		assert.Equal(t, []int{4, 2, 0}, counts(p, 6))
+	})
+
+	t.Run("two random choices", func(t *testing.T) {
+		p := newPool(bindings.PoolTwoRandomChoices)
+		load(p, 2, 1, 0)
+		c := counts(p, 3000)
+		assert.Equal(t, 0, c[0])
+		assert.True(t, c[2] > c[1] && c[1] > 0, "counts %v", c)
+		// Failed connection is returned, when it's chosen
+		p.conns[1].err = errors.New("connection is broken")
+		c = counts(p, 3000)
+		assert.Equal(t, 0, c[0])
+		assert.True(t, c[1] > c[2] && c[2] > 0, "counts %v", c)
+	})
+}
+
+// BenchmarkPoolStrategy reports latency percentiles of fast requests, which are mixed with slow ones (1 of 20 requests takes 2ms on server)
+func BenchmarkPoolStrategy(b *testing.B) {
+	u, stop := runFakeRPCServerFunc(b, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdPutMeta {
+			time.Sleep(2 * time.Millisecond)
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	strategies := map[string]int{"round-robin": bindings.PoolRoundRobin, "least-in-flight": bindings.PoolLeastInFlight, "two-random-choices": bindings.PoolTwoRandomChoices}
+	for _, name := range []string{"round-robin", "least-in-flight", "two-random-choices"} {
+		b.Run(name, func(b *testing.B) {
+			binding := NetCProto{}
+			require.NoError(b, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 8}, bindings.OptionPoolStrategy{Strategy: strategies[name]}))
+			defer binding.Finalize()
+
+			ctx := context.Background()
+			var lock sync.Mutex
+			var latencies []time.Duration
+			var n int64
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var local []time.Duration
+				for pb.Next() {
+					if atomic.AddInt64(&n, 1)%20 == 0 {
+						if err := binding.PutMeta(ctx, "namespace", "key", "data"); err != nil {
+							panic(err)
+						}
+						continue
+					}
+					start := time.Now()
+					if err := binding.Ping(ctx); err != nil {
+						panic(err)
+					}
+					local = append(local, time.Since(start))
+				}
+				lock.Lock()
+				latencies = append(latencies, local...)
+				lock.Unlock()
+			})
+			b.StopTimer()
+			if len(latencies) == 0 {
+				return
+			}
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-us")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-us")
+		})
+	}
+}
+
+func BenchmarkHedgedSelect(b *testing.B) {
+	var n int64
+	u, stop := runFakeRPCServerFunc(b, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdSelect {
+			// 1 of 500 selects stalls connection
+			if atomic.AddInt64(&n, 1)%500 == 0 {
+				time.Sleep(20 * time.Millisecond)
+			}
+			reply = fakeRPCReplyArgs(cmd, seq, func(out *cjson.Serializer) int {
+				out.PutVarUInt(uint64(bindings.ValueString))
+				out.PutVString("results")
+				out.PutVarUInt(uint64(bindings.ValueInt))
+				out.PutVarInt(-1)
+				return 2
+			})
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	for _, delay := range []time.Duration{0, 2 * time.Millisecond} {
+		b.Run(fmt.Sprintf("delay=%v", delay), func(b *testing.B) {
+			binding := NetCProto{}
+			require.NoError(b, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 4}))
+			defer binding.Finalize()
+
+			ctx := context.Background()
+			if delay > 0 {
+				ctx = bindings.ContextWithHedge(ctx, delay)
+			}
+			var lock sync.Mutex
+			var latencies []time.Duration
+			b.SetParallelism(2)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var local []time.Duration
+				for pb.Next() {
+					start := time.Now()
+					buf, err := binding.SelectQuery(ctx, []byte{}, false, nil, 1)
+					if err != nil {
+						panic(err)
+					}
+					buf.Free()
+					local = append(local, time.Since(start))
+				}
+				lock.Lock()
+				latencies = append(latencies, local...)
+				lock.Unlock()
+			})
+			b.StopTimer()
+			if len(latencies) == 0 {
+				return
+			}
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-us")
+			b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-us")
+			b.ReportMetric(float64(latencies[len(latencies)*999/1000].Microseconds()), "p999-us")
+			b.ReportMetric(float64(binding.Status(ctx).CProto.HedgedRequests), "hedges")
+		})
+	}
+}
+
+func TestUpdateOptions(t *testing.T) {
+	const oldTimeout, newTimeout = 300 * time.Millisecond, 50 * time.Millisecond
+	release := make(chan struct{})
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		switch cmd {
+		case cmdSelectSQL:
+			// Server doesn't reply until timeout of client
+			return nil
+		case cmdSelect:
+			go func(reply []byte) {
+				<-release
+				conn.Write(reply)
+			}(append([]byte(nil), reply...))
+			return nil
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2}, bindings.OptionTimeouts{RequestTimeout: oldTimeout}))
+	defer binding.Finalize()
+	ctx := context.Background()
+
+	t.Run("request timeout", func(t *testing.T) {
+		const inFlight = 4
+		done := make(chan time.Duration, inFlight)
+		start := time.Now()
+		for i := 0; i < inFlight; i++ {
+			go func() {
+				_, err := binding.rpcCall(ctx, opRd, cmdSelectSQL, "")
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), fmt.Sprintf("timeout %v of command", oldTimeout))
+				}
+				done <- time.Since(start)
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, binding.UpdateOptions(bindings.OptionTimeouts{RequestTimeout: newTimeout}))
+
+		newStart := time.Now()
+		_, err := binding.rpcCall(ctx, opRd, cmdSelectSQL, "")
+		elapsed := time.Since(newStart)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), fmt.Sprintf("timeout %v of command", newTimeout))
+		assert.True(t, elapsed < oldTimeout/2, "new request is timed out after %v", elapsed)
+		// In-flight requests keep their deadline
+		assert.Equal(t, 0, len(done))
+		for i := 0; i < inFlight; i++ {
+			elapsed = <-done
+			assert.True(t, elapsed >= oldTimeout, "in-flight request is timed out after %v", elapsed)
+		}
+	})
+
+	t.Run("immutable options", func(t *testing.T) {
+		err := binding.UpdateOptions(bindings.OptionTimeouts{RequestTimeout: time.Second}, bindings.OptionTLS{})
+		require.Error(t, err)
+		assert.Equal(t, bindings.ErrParams, err.(bindings.Error).Code())
+		assert.Contains(t, err.Error(), "OptionTLS")
+		require.Error(t, binding.UpdateOptions(bindings.OptionConnPoolSize{ConnPoolSize: 0}))
+		assert.Equal(t, newTimeout, binding.tuned().timeouts.RequestTimeout)
+		assert.Equal(t, 2, len(binding.getAllConns()))
+	})
+
+	t.Run("pool size", func(t *testing.T) {
+		require.NoError(t, binding.UpdateOptions(bindings.OptionConnPoolSize{ConnPoolSize: 4}))
+		conns := binding.getAllConns()
+		require.Equal(t, 4, len(conns))
+		for _, conn := range conns {
+			assert.True(t, conn.isUsable())
+		}
+
+		removed := conns[1:]
+		replied := make(chan error, 1)
+		go func() {
+			buf, err := removed[0].rpcCall(ctx, cmdSelect, time.Second, "")
+			buf.Free()
+			replied <- err
+		}()
+		for i := 0; i < 100 && removed[0].inFlight() == 0; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		require.NoError(t, binding.UpdateOptions(bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+		assert.Equal(t, conns[:1], binding.getAllConns())
+		// Removed connection is closed after its in-flight request
+		time.Sleep(20 * time.Millisecond)
+		assert.NoError(t, removed[0].curError())
+		close(release)
+		require.NoError(t, <-replied)
+		for _, conn := range removed {
+			for i := 0; i < 100 && conn.curError() == nil; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			assert.Equal(t, errConnClosed, conn.curError())
+		}
+		require.NoError(t, binding.Ping(ctx))
+	})
+}
+
+func TestWriteLane(t *testing.T) {
+	const lanes = 8
+	const writes = 40
+	var lock sync.Mutex
+	applied := make(map[int]int)
+	laneConns := make(map[int]net.Conn)
+	var reordered []string
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdModifyItem {
+			// Connections are served concurrently with different delays, so writes of different connections overtake each other
+			time.Sleep(time.Duration(seq%3) * time.Millisecond)
+			in := newRPCDecoder(body)
+			in.argsCount()
+			in.intfArg()
+			in.intfArg()
+			var lane, n int
+			fmt.Sscanf(string(in.intfArg().([]byte)), "%d:%d", &lane, &n)
+			lock.Lock()
+			if n <= applied[lane] {
+				reordered = append(reordered, fmt.Sprintf("lane %d: write %d after %d", lane, n, applied[lane]))
+			}
+			applied[lane] = n
+			if prev, ok := laneConns[lane]; ok && prev != conn {
+				reordered = append(reordered, fmt.Sprintf("lane %d: write %d via another connection", lane, n))
+			}
+			laneConns[lane] = conn
+			lock.Unlock()
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 4}))
+	defer binding.Finalize()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, lanes*writes)
+	for lane := 0; lane < lanes; lane++ {
+		wg.Add(1)
+		go func(lane int) {
+			defer wg.Done()
+			ctx := bindings.ContextWithWriteLane(context.Background(), lane)
+			var pending sync.WaitGroup
+			for n := 1; n <= writes; n++ {
+				pending.Add(1)
+				binding.ModifyItemAsync(ctx, 0, "namespace", bindings.FormatCJson, []byte(fmt.Sprintf("%d:%d", lane, n)), bindings.ModeUpsert, nil, 0,
+					func(buf bindings.RawBuffer, err error) {
+						buf.Free()
+						errs <- err
+						pending.Done()
+					})
+			}
+			pending.Wait()
+		}(lane)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Empty(t, reordered)
+	for lane := 0; lane < lanes; lane++ {
+		assert.Equal(t, writes, applied[lane], "lane %d", lane)
+	}
+	assert.Len(t, laneConns, lanes)
+}
+
+func TestPing(t *testing.T) {
+	var silent int32
+	pings := make(chan struct{}, defQueueSize)
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdPing {
+			pings <- struct{}{}
+			if atomic.LoadInt32(&silent) != 0 {
+				return nil
+			}
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+	defer binding.Finalize()
+
+	require.NoError(t, binding.Ping(context.Background()))
+	assert.Len(t, pings, 1, "ping must be sent to server")
+
+	atomic.StoreInt32(&silent, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, binding.Ping(ctx))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, binding.Ping(ctx))
+
+	atomic.StoreInt32(&silent, 0)
+	assert.NoError(t, binding.Ping(context.Background()))
+}
+
+func TestEnumMeta(t *testing.T) {
+	namespaces := make(chan string, 1)
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		if cmd == cmdEnumMeta {
+			in := newRPCDecoder(body)
+			in.argsCount()
+			namespaces <- string(in.intfArg().([]byte))
+			reply = fakeRPCReplyArgs(cmd, seq, func(out *cjson.Serializer) int {
+				for _, key := range []string{"version", "owner"} {
+					out.PutVarUInt(uint64(bindings.ValueString))
+					out.PutVString(key)
+				}
+				return 2
+			})
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}))
+	defer binding.Finalize()
+
+	keys, err := binding.EnumMeta(context.Background(), "items")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"version", "owner"}, keys)
+	assert.Equal(t, "items", <-namespaces)
+}
+
+// tracedRPC - span, which is recorded by tracer of TestTracer
+type tracedRPC struct {
+	bindings.RPCSpan
+	bindings.RPCSpanEnd
+	trace interface{}
+	ended bool
+}
+
+type traceKey struct{}
+
+func TestTracer(t *testing.T) {
+	var lock sync.Mutex
+	var spans []*tracedRPC
+	tracer := bindings.OptionTracer{StartSpan: func(ctx context.Context, span bindings.RPCSpan) func(res bindings.RPCSpanEnd) {
+		traced := &tracedRPC{RPCSpan: span, trace: ctx.Value(traceKey{})}
+		lock.Lock()
+		spans = append(spans, traced)
+		lock.Unlock()
+		return func(res bindings.RPCSpanEnd) {
+			lock.Lock()
+			defer lock.Unlock()
+			assert.False(t, traced.ended, "span is ended twice")
+			traced.RPCSpanEnd, traced.ended = res, true
+		}
+	}}
+	recorded := func() []tracedRPC {
+		lock.Lock()
+		defer lock.Unlock()
+		res := make([]tracedRPC, 0, len(spans))
+		for _, span := range spans {
+			res = append(res, *span)
+		}
+		spans = nil
+		return res
+	}
+
+	srv := &checksumServer{chunks: 3, corrupt: -1, chunkSize: 256}
+	u, stop := srv.run(t)
+	defer stop()
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, tracer))
+	defer binding.Finalize()
+	login := recorded()
+	require.Len(t, login, 1)
+	assert.Equal(t, "Login", login[0].Name)
+	assert.True(t, login[0].ended)
+
+	t.Run("fetches of results", func(t *testing.T) {
+		ctx := bindings.ContextWithActivityLabel(context.WithValue(context.Background(), traceKey{}, "trace-1"), "report")
+		_, err := srv.fetchAll(ctx, binding)
+		require.NoError(t, err)
+		traced := recorded()
+		var cmds []int
+		for _, span := range traced {
+			cmds = append(cmds, span.Cmd)
+			assert.Equal(t, cmdName(span.Cmd), span.Name)
+			assert.Equal(t, "trace-1", span.trace, "context of request isn't passed to tracer")
+			assert.Equal(t, "report", span.Label)
+			assert.Equal(t, u.Host, span.Addr)
+			assert.True(t, span.ended)
+			assert.NoError(t, span.Err)
+			assert.True(t, span.RequestSize > 0)
+			assert.True(t, span.ReplySize > srv.chunkSize, "reply size %d", span.ReplySize)
+		}
+		assert.Equal(t, []int{cmdSelectSQL, cmdFetchResults, cmdFetchResults}, cmds)
+	})
+
+	t.Run("async request", func(t *testing.T) {
+		ctx := context.WithValue(context.Background(), traceKey{}, "trace-2")
+		done := make(chan []tracedRPC, 1)
+		binding.ModifyItemAsync(ctx, 0, "items", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0,
+			func(buf bindings.RawBuffer, err error) {
+				assert.NoError(t, err)
+				// Span is ended before completion
+				done <- recorded()
+			})
+		traced := <-done
+		require.Len(t, traced, 1)
+		assert.Equal(t, "ModifyItem", traced[0].Name)
+		assert.Equal(t, "trace-2", traced[0].trace)
+		assert.True(t, traced[0].ended)
+		assert.True(t, traced[0].RequestSize > 0)
+		assert.True(t, traced[0].ReplySize > 0)
+	})
+
+	t.Run("failed request", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		conn := binding.pool.conns[0]
+		_, err := conn.rpcCall(ctx, cmdPing, 0)
+		require.Error(t, err)
+		traced := recorded()
+		require.Len(t, traced, 1)
+		assert.Equal(t, err, traced[0].Err)
+		assert.Equal(t, 0, traced[0].RequestSize)
+		assert.Equal(t, 0, traced[0].ReplySize)
+	})
+
+	t.Run("disabled by update", func(t *testing.T) {
+		require.NoError(t, binding.UpdateOptions(bindings.OptionTracer{}))
+		require.NoError(t, binding.Ping(context.Background()))
+		assert.Empty(t, recorded())
+	})
+}
+
+func TestRequestLog(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	seqs := make(chan uint32, 10)
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		switch cmd {
+		case cmdSelectSQL:
+			seqs <- seq
+			time.Sleep(delay)
+		case cmdModifyItem:
+			reply = fakeRPCErrorReply(cmd, seq, bindings.ErrLogic, "failed")
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+
+	var lock sync.Mutex
+	var entries []bindings.RequestLogEntry
+	requestLog := bindings.OptionRequestLog{Hook: func(entry bindings.RequestLogEntry) {
+		lock.Lock()
+		defer lock.Unlock()
+		entries = append(entries, entry)
+	}}
+	logged := func() []bindings.RequestLogEntry {
+		lock.Lock()
+		defer lock.Unlock()
+		res := entries
+		entries = nil
+		return res
+	}
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 1}, requestLog))
+	defer binding.Finalize()
+	login := logged()
+	require.Len(t, login, 1)
+	assert.Equal(t, "Login", login[0].Name)
+
+	t.Run("request", func(t *testing.T) {
+		ctx := bindings.ContextWithActivityLabel(context.Background(), "report")
+		buf, err := binding.rpcCall(ctx, opRd, cmdSelectSQL, "SELECT * FROM items")
+		require.NoError(t, err)
+		buf.Free()
+		entries := logged()
+		require.Len(t, entries, 1)
+		entry := entries[0]
+		assert.Equal(t, cmdSelectSQL, entry.Cmd)
+		assert.Equal(t, "SelectSQL", entry.Name)
+		assert.Equal(t, <-seqs, entry.Seq)
+		assert.Equal(t, "report", entry.Label)
+		assert.Equal(t, u.Host, entry.Addr)
+		assert.True(t, entry.RoundTrip >= delay, "round trip %v", entry.RoundTrip)
+		assert.True(t, entry.QueueWait < entry.RoundTrip)
+		assert.True(t, entry.RequestSize > 0)
+		assert.True(t, entry.ReplySize > 0)
+		assert.NoError(t, entry.Err)
+	})
+
+	t.Run("async request", func(t *testing.T) {
+		done := make(chan []bindings.RequestLogEntry, 1)
+		binding.ModifyItemAsync(context.Background(), 0, "items", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0,
+			func(buf bindings.RawBuffer, err error) {
+				assert.Error(t, err)
+				// Request is logged before completion
+				done <- logged()
+			})
+		entries := <-done
+		require.Len(t, entries, 1)
+		assert.Equal(t, "ModifyItem", entries[0].Name)
+		assert.Equal(t, bindings.ErrLogic, entries[0].Err.(bindings.Error).Code())
+		assert.True(t, entries[0].RoundTrip > 0)
+		assert.True(t, entries[0].RequestSize > 0)
+		assert.True(t, entries[0].ReplySize > 0)
+	})
+
+	t.Run("request isn't sent", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := binding.pool.conns[0].rpcCall(ctx, cmdPing, 0)
+		require.Error(t, err)
+		entries := logged()
+		require.Len(t, entries, 1)
+		assert.Equal(t, "Ping", entries[0].Name)
+		assert.Equal(t, err, entries[0].Err)
+		assert.Equal(t, time.Duration(0), entries[0].RoundTrip)
+		assert.Equal(t, uint32(0), entries[0].Seq)
+		assert.Equal(t, 0, entries[0].RequestSize)
+	})
+
+	t.Run("disabled by update", func(t *testing.T) {
+		require.NoError(t, binding.UpdateOptions(bindings.OptionRequestLog{}))
+		require.NoError(t, binding.Ping(context.Background()))
+		assert.Empty(t, logged())
+	})
+}
+
+func TestConnStats(t *testing.T) {
+	const requests = 10
+	u, stop := runFakeRPCServerFunc(t, func(conn net.Conn, cmd, version uint16, seq uint32, body, reply []byte) error {
+		switch cmd {
+		case cmdModifyItem, cmdSelectSQL:
+			reply = fakeRPCErrorReply(cmd, seq, bindings.ErrLogic, "failed")
+		case cmdGetMeta:
+			// Requests wait for each other in the single request slot
+			time.Sleep(20 * time.Millisecond)
+		}
+		_, err := conn.Write(reply)
+		return err
+	})
+	defer stop()
+	binding := &NetCProto{}
+	require.NoError(t, binding.Init([]url.URL{*u}, bindings.OptionConnPoolSize{ConnPoolSize: 2}, bindings.OptionConnQueueSize{QueueSize: 1}))
+	defer binding.Finalize()
+
+	for i := 0; i < requests; i++ {
+		require.NoError(t, binding.Ping(context.Background()))
+	}
+	_, err := binding.Select(context.Background(), "SELECT * FROM items", false, nil, 1)
+	require.Error(t, err)
+	done := make(chan error, 1)
+	binding.ModifyItemAsync(context.Background(), 0, "items", bindings.FormatCJson, make([]byte, 10), bindings.ModeUpsert, nil, 0,
+		func(buf bindings.RawBuffer, err error) { done <- err })
+	require.Error(t, <-done)
+
+	stats := binding.Stats()
+	require.Len(t, stats, 2)
+	var total bindings.ConnStats
+	for i, st := range stats {
+		assert.Equal(t, i, st.Slot)
+		assert.False(t, st.Bulk)
+		assert.Equal(t, u.Host, st.Addr)
+		assert.NoError(t, st.Err)
+		assert.Equal(t, 0, st.InFlight)
+		assert.Equal(t, int64(0), st.Reconnects)
+		assert.False(t, st.Connected.IsZero())
+		// Replies to login are counted
+		assert.True(t, st.BytesRead > 0 && st.BytesWritten > 0, "slot %d: read %d, written %d", i, st.BytesRead, st.BytesWritten)
+		assert.False(t, st.LastRead.IsZero())
+		total.Requests += st.Requests
+		total.Errors += st.Errors
+	}
+	// Logins of both connections are counted too
+	assert.Equal(t, int64(requests+2+2), total.Requests)
+	assert.Equal(t, int64(2), total.Errors)
+
+	t.Run("seq wait", func(t *testing.T) {
+		ctx := bindings.ContextWithWriteLane(context.Background(), 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				binding.GetMeta(ctx, "items", "key")
+			}()
+		}
+		wg.Wait()
+		wait := binding.Stats()[0].SeqWait
+		assert.True(t, wait >= 20*time.Millisecond, "seq wait: %v", wait)
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		prev := binding.Stats()
+		binding.pool.conns[0].onError(errors.New("failed"))
+		st := binding.Stats()[0]
+		assert.Error(t, st.Err)
+		assert.Equal(t, prev[0].Requests, st.Requests)
+
+		// Failed connection is found by one of the requests, which reconnects the whole pool
+		for i := 0; i < 2; i++ {
+			require.NoError(t, binding.Ping(context.Background()))
+		}
+		for _, st := range binding.Stats() {
+			assert.NoError(t, st.Err)
+			assert.Equal(t, int64(1), st.Reconnects, "slot %d", st.Slot)
+			assert.True(t, st.Requests < prev[st.Slot].Requests, "counters of slot %d must be reset", st.Slot)
+		}
+	})
+}
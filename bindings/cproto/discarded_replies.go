@@ -0,0 +1,94 @@
+package cproto
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// discardedRepliesLogInterval - min interval between warnings about replies, which are discarded by connection
+const discardedRepliesLogInterval = 10 * time.Second
+
+// discardedReplies tracks replies, which are discarded by read loop of connection, for warnings and for OptionDiscardedRepliesLimit.
+// It's used only by read loop
+type discardedReplies struct {
+	// times of the last Count+1 discards in nanoseconds, ring buffer
+	stamps []int64
+	next   int
+	// discards since the last warning
+	stale   int
+	unknown int
+	cmds    map[int]int
+	warned  time.Time
+}
+
+// discardReply skips body of the reply, which has no waiting request. unknown - seq number of reply was never used by connection,
+// so it's a sign of protocol desync, otherwise it's reply of completed request, e.g. of timed out one
+func (c *connection) discardReply(cmd int, size int, unknown bool) (err error) {
+	if unknown {
+		atomic.AddInt64(&c.unknownReplies, 1)
+		atomic.AddInt64(&c.owner.traffic.unknownReplies, 1)
+	} else {
+		atomic.AddInt64(&c.staleReplies, 1)
+		atomic.AddInt64(&c.owner.traffic.staleReplies, 1)
+	}
+	if _, err = io.CopyN(ioutil.Discard, c.rdBuf, int64(size)); err != nil {
+		return
+	}
+	return c.discarded.add(c, cmd, unknown)
+}
+
+// add records discarded reply. It logs discards once per discardedRepliesLogInterval and returns error, if discards exceed limit
+func (d *discardedReplies) add(c *connection, cmd int, unknown bool) error {
+	now := time.Now()
+	if unknown {
+		d.unknown++
+	} else {
+		d.stale++
+	}
+	if d.cmds == nil {
+		d.cmds = make(map[int]int)
+	}
+	d.cmds[cmd]++
+	if logger != nil && now.Sub(d.warned) >= discardedRepliesLogInterval {
+		logger.Printf(2, "rq: connection to %s discarded %d late and %d unknown replies (%s)\n", c.addr, d.stale, d.unknown, d.commands())
+		d.stale, d.unknown, d.cmds, d.warned = 0, 0, nil, now
+	}
+
+	limit := c.owner.discardLimit
+	if limit.Count <= 0 || limit.Interval <= 0 {
+		return nil
+	}
+	if d.stamps == nil {
+		d.stamps = make([]int64, limit.Count+1)
+	}
+	d.stamps[d.next] = now.UnixNano()
+	d.next = (d.next + 1) % len(d.stamps)
+	if oldest := d.stamps[d.next]; oldest != 0 && now.UnixNano()-oldest <= int64(limit.Interval) {
+		return bindings.NewError(fmt.Sprintf("rq: Connection discarded %d replies in %v, it's out of sync with server", len(d.stamps), limit.Interval),
+			bindings.ErrNetwork)
+	}
+	return nil
+}
+
+// commands returns counts of discarded replies by commands, e.g. 'Select: 2, ModifyItem: 1'
+func (d *discardedReplies) commands() string {
+	names := make([]string, 0, len(d.cmds))
+	counts := make(map[string]int, len(d.cmds))
+	for cmd, count := range d.cmds {
+		name := cmdName(cmd)
+		names = append(names, name)
+		counts[name] = count
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%s: %d", name, counts[name])
+	}
+	return strings.Join(names, ", ")
+}
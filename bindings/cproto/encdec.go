@@ -2,6 +2,8 @@ package cproto
 
 import (
 	"fmt"
+	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/restream/reindexer/bindings"
@@ -9,22 +11,58 @@ import (
 	"github.com/golang/snappy"
 )
 
+// Encoders with larger buffers are not returned to the pool to avoid retention of memory after huge requests
+const maxPooledEncoderBufSize = 1 << 20
+
+// execTimeoutLen - length of varint of exec timeout, which may be decreased in place before write (see execTimeoutArg).
+// Server decodes overlong varints, 5 bytes fit timeouts up to ~198 days
+const execTimeoutLen = 5
+
+var encoderPool sync.Pool
+
 type rpcEncoder struct {
 	lastArgsChunckStart int
-	ser                 *cjson.Serializer
+	ser                 cjson.Serializer
+	arrSer              cjson.Serializer
+	snappyBuf           []byte
 	enableSnappy        bool
+	// urgent frames are not delayed by write coalescing
+	urgent bool
+	seq    uint32
+	// deadline of request (zero - no deadline), position of exec timeout varint in uncompressed frame (-1 - can't be changed)
+	// and time, when frame was queued to write
+	deadline   time.Time
+	timeoutPos int
+	queuedAt   time.Time
+	// writeWait (if not nil) receives time, which frame was waiting in write queue. Accessed atomically
+	writeWait *int64
 }
 
 type rpcDecoder struct {
 	ser cjson.Serializer
 }
 
-func newRPCEncoder(cmd int, seq uint32, enableSnappy bool) rpcEncoder {
-	enc := rpcEncoder{ser: cjson.NewPoolSerializer(), enableSnappy: enableSnappy}
+// newRPCEncoder gets encoder from the pool. Bytes of encoder are written to socket without copy by write loop,
+// so encoder is released after write (see connection.write)
+func newRPCEncoder(cmd int, seq uint32, enableSnappy bool) *rpcEncoder {
+	enc, _ := encoderPool.Get().(*rpcEncoder)
+	if enc == nil {
+		enc = &rpcEncoder{}
+	}
+	enc.ser = cjson.NewSerializer(enc.ser.Bytes()[:0])
+	enc.enableSnappy = enableSnappy
+	enc.seq, enc.deadline, enc.timeoutPos, enc.writeWait = seq, time.Time{}, -1, nil
 	enc.start(cmd, seq)
 	return enc
 }
 
+func (r *rpcEncoder) release() {
+	if cap(r.ser.Bytes()) > maxPooledEncoderBufSize || cap(r.arrSer.Bytes()) > maxPooledEncoderBufSize || cap(r.snappyBuf) > maxPooledEncoderBufSize {
+		return
+	}
+	encoderPool.Put(r)
+}
+
 func (r *rpcEncoder) start(cmd int, seq uint32) {
 	r.ser.PutUInt32(cprotoMagic)
 	vers := cprotoVersion
@@ -76,12 +114,12 @@ func (r *rpcEncoder) boolArg(v bool) {
 func (r *rpcEncoder) int32ArrArg(v []int32) {
 	r.ser.PutVarUInt(uint64(bindings.ValueString))
 
-	aser := cjson.Serializer{}
-	aser.PutVarCUInt(len(v))
+	r.arrSer = cjson.NewSerializer(r.arrSer.Bytes()[:0])
+	r.arrSer.PutVarCUInt(len(v))
 	for _, e := range v {
-		aser.PutVarCUInt(int(e))
+		r.arrSer.PutVarCUInt(int(e))
 	}
-	r.ser.PutVBytes(aser.Bytes())
+	r.ser.PutVBytes(r.arrSer.Bytes())
 	r.update()
 }
 
@@ -91,20 +129,58 @@ func (r *rpcEncoder) int64Arg(v int64) {
 	r.update()
 }
 
+// execTimeoutArg writes exec timeout in ms. Timeout of request with deadline is written as varint of fixed length,
+// so it's decreased by setExecTimeout, when frame is written
+func (r *rpcEncoder) execTimeoutArg(timeout int64, deadline time.Time) {
+	r.deadline = deadline
+	if timeout <= 0 || timeout >= 1<<(7*execTimeoutLen-1) {
+		r.int64Arg(timeout)
+		return
+	}
+	r.ser.PutVarUInt(uint64(bindings.ValueInt64))
+	r.timeoutPos = len(r.ser.Bytes())
+	r.ser.Write(make([]byte, execTimeoutLen))
+	r.setExecTimeout(timeout)
+	r.update()
+}
+
+// setExecTimeout replaces exec timeout, which is written by execTimeoutArg, if frame is not compressed
+func (r *rpcEncoder) setExecTimeout(timeout int64) {
+	if r.timeoutPos < 0 {
+		return
+	}
+	// Zigzag encoding of non-negative value
+	v := uint64(timeout) << 1
+	b := r.ser.Bytes()[r.timeoutPos : r.timeoutPos+execTimeoutLen]
+	for i := 0; i < execTimeoutLen-1; i++ {
+		b[i] = byte(v) | 0x80
+		v >>= 7
+	}
+	b[execTimeoutLen-1] = byte(v)
+}
+
 func (r *rpcEncoder) update() {
 	r.ser.Bytes()[r.lastArgsChunckStart]++
 	*(*uint32)(unsafe.Pointer(&r.ser.Bytes()[8])) = uint32(len(r.ser.Bytes()) - cprotoHdrLen)
 }
 
-func (r *rpcEncoder) bytes() []byte {
-	if r.enableSnappy {
-		out := snappy.Encode(nil, r.ser.Bytes()[cprotoHdrLen:])
+// finish compresses request, if snappy is enabled and request body is larger than minSize.
+// Request bytes are in r.ser after it. Returns true, if request was compressed
+func (r *rpcEncoder) finish(minSize int) bool {
+	if r.enableSnappy && len(r.ser.Bytes())-cprotoHdrLen > minSize {
+		src := r.ser.Bytes()[cprotoHdrLen:]
+		if n := snappy.MaxEncodedLen(len(src)); cap(r.snappyBuf) < n {
+			r.snappyBuf = make([]byte, n)
+		}
+		out := snappy.Encode(r.snappyBuf[:cap(r.snappyBuf)], src)
 		r.ser.Truncate(cprotoHdrLen)
 		r.ser.Write(out)
 		*(*uint16)(unsafe.Pointer(&r.ser.Bytes()[4])) |= cprotoVersionCompressionFlag
 		*(*uint32)(unsafe.Pointer(&r.ser.Bytes()[8])) = uint32(len(r.ser.Bytes()) - cprotoHdrLen)
+		r.timeoutPos = -1
+		return true
 	}
-	return r.ser.Bytes()
+	return false
 }
 
 func newRPCDecoder(buf []byte) rpcDecoder {
@@ -148,6 +224,25 @@ func (r *rpcDecoder) intArg() int {
 	return int(r.ser.GetVarInt())
 }
 
+// skipArg skips arg of reply and returns its position in buffer of decoder
+func (r *rpcDecoder) skipArg() netArg {
+	t := int(r.ser.GetVarUInt())
+	pos := r.ser.Pos()
+	switch t {
+	case bindings.ValueInt, bindings.ValueBool, bindings.ValueInt64:
+		r.ser.GetVarInt()
+	case bindings.ValueString:
+		// Value follows its length
+		v := r.ser.GetVBytes()
+		pos = r.ser.Pos() - len(v)
+	case bindings.ValueDouble:
+		r.ser.GetDouble()
+	default:
+		panic(fmt.Errorf("cproto: Unexpected arg type %d", t))
+	}
+	return netArg{typ: t, pos: pos, end: r.ser.Pos()}
+}
+
 func (r *rpcDecoder) intfArg() interface{} {
 	t := r.ser.GetVarUInt()
 	switch int(t) {
@@ -0,0 +1,75 @@
+package cproto
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// startFailback starts probe of the first DSN, if it's enabled by bindings.OptionFailback
+func (binding *NetCProto) startFailback() {
+	if binding.failback.ProbeInterval > 0 && len(binding.dsn.url) > 1 {
+		go binding.failbackLoop(binding.termCh)
+	}
+}
+
+// failbackLoop dials server of the first DSN each probe interval, while the pool is connected to another DSN. Once it's reachable,
+// the first DSN becomes active and connections of the pool are drained: their slots are connected again by the next requests
+func (binding *NetCProto) failbackLoop(termCh chan struct{}) {
+	ticker := time.NewTicker(binding.failback.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-termCh:
+			return
+		case <-ticker.C:
+		}
+		binding.lock.RLock()
+		active := binding.dsn.active
+		binding.lock.RUnlock()
+		if active == 0 || binding.isConnecting() || !binding.probeDSN(0) {
+			continue
+		}
+
+		binding.lock.Lock()
+		if binding.dsn.active == 0 {
+			binding.lock.Unlock()
+			continue
+		}
+		binding.dsn.active = 0
+		binding.dsn.connVersion++
+		for _, conn := range binding.pool.conns {
+			if conn != nil {
+				conn.drain()
+			}
+		}
+		binding.lock.Unlock()
+		if logger != nil {
+			_, addr, _ := dsnAddr(&binding.dsn.url[0])
+			logger.Printf(3, "rq: preferred host %s is reachable, connections are moved to it\n", addr)
+		}
+	}
+}
+
+// probeDSN returns true, if server of DSN i accepts connections
+func (binding *NetCProto) probeDSN(i int) bool {
+	timeout := binding.tuned().timeouts.LoginTimeout
+	if timeout <= 0 || timeout > binding.failback.ProbeInterval {
+		timeout = binding.failback.ProbeInterval
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	network, addr, _ := dsnAddr(&binding.dsn.url[i])
+	conn, err := binding.dial(ctx, network, addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// drain marks connection as draining like onShutdown, but without disconnect report: it's not used for new requests,
+// and the next request connects its slot of the pool again
+func (c *connection) drain() {
+	atomic.StoreInt32(&c.shutdown, 1)
+}
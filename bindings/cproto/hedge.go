@@ -0,0 +1,120 @@
+package cproto
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// hedgeReply - reply to one of requests of hedged select
+type hedgeReply struct {
+	conn  *connection
+	buf   *NetBuffer
+	err   error
+	hedge bool
+}
+
+// hedgedSelect sends select via connection of the pool and, if it's not answered in delay, sends the same select via another connection.
+// The first successful reply is returned, the other request is canceled and its results are closed.
+// Select, which fails by broken connection before hedge is sent, is sent again as usual request with retries
+func (binding *NetCProto) hedgedSelect(ctx context.Context, delay time.Duration, cmd int, args ...interface{}) (*NetBuffer, error) {
+	tun := binding.tuned()
+	var host string
+	var probe bool
+	var err error
+	if binding.breakers.enabled() {
+		host = binding.activeHost()
+		if probe, err = binding.breakers.allow(host); err != nil {
+			return nil, err
+		}
+	}
+	first, err := binding.getConn(ctx)
+	if err != nil {
+		binding.breakers.done(host, probe, err)
+		return nil, err
+	}
+
+	// Buffered, so the loser doesn't block, if nobody waits for it
+	replies := make(chan hedgeReply, 2)
+	cancels := make([]context.CancelFunc, 0, 2)
+	send := func(conn *connection, hedge bool) {
+		reqCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		go func() {
+			buf, err := conn.rpcCall(reqCtx, cmd, tun.timeouts.RequestTimeout, args...)
+			replies <- hedgeReply{conn: conn, buf: buf, err: err, hedge: hedge}
+		}()
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	send(first, false)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	var failed *hedgeReply
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if second := binding.hedgeConn(first); second != nil && ctx.Err() == nil {
+				atomic.AddInt64(&binding.traffic.hedgedRequests, 1)
+				send(second, true)
+				pending++
+			}
+		case r := <-replies:
+			pending--
+			if r.err == nil {
+				if r.hedge {
+					atomic.AddInt64(&binding.traffic.hedgeWins, 1)
+				}
+				if pending > 0 {
+					// Loser is canceled by deferred cancel
+					atomic.AddInt64(&binding.traffic.hedgeWasted, 1)
+					go binding.dropHedgeLoser(replies)
+				}
+				binding.breakers.done(host, probe, nil)
+				return r.buf, nil
+			}
+			if failed == nil {
+				failed = &r
+			}
+		}
+	}
+	binding.breakers.done(host, probe, failed.err)
+	if len(cancels) == 1 && failed.err == failed.conn.curError() && ctx.Err() == nil {
+		// Connection is broken before hedge was sent
+		return binding.rpcCall(ctx, opRd, cmd, args...)
+	}
+	return nil, failed.err
+}
+
+// dropHedgeLoser waits for request of hedged select, which lost, and closes its results
+func (binding *NetCProto) dropHedgeLoser(replies chan hedgeReply) {
+	r := <-replies
+	if r.buf == nil {
+		return
+	}
+	if resultsID, err := r.buf.resultsID(); err == nil {
+		r.buf.reqID = resultsID
+	}
+	r.buf.Free()
+}
+
+// hedgeConn returns usable connection of the pool other than conn, which has the least requests in flight, or nil
+func (binding *NetCProto) hedgeConn(conn *connection) *connection {
+	binding.lock.RLock()
+	defer binding.lock.RUnlock()
+	var best *connection
+	for _, c := range binding.pool.conns {
+		if c == conn || c == nil || !c.isUsable() || c.isPingPending() {
+			continue
+		}
+		if best == nil || c.inFlight() < best.inFlight() {
+			best = c
+		}
+	}
+	return best
+}
@@ -4,67 +4,155 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/restream/reindexer/bindings"
 	"github.com/golang/snappy"
 )
 
+// bufPool - reply buffers, which are released by Free. Their byte slices are reused by the next replies
 var bufPool sync.Pool
 
+const (
+	// netBufMinCap - min capacity of byte slices of reply buffers
+	netBufMinCap = 4 * 1024
+	// netBufMaxRetained - byte slices of larger capacity are not kept by Free for reuse
+	netBufMaxRetained = 4 * 1024 * 1024
+	// netBufPoisonByte - value, which released byte slices are filled with by Free in debug mode (build tag reindexer_netbuf_debug)
+	netBufPoisonByte = 0xDD
+)
+
 type NetBuffer struct {
 	buf   []byte
 	conn  *connection
 	reqID int
-	args  []interface{}
+	cmd   int // command of reply, which is reported by errors of its args
+	args  []netArg // args of reply, which point into buf (see ArgInt)
+	err   error // reply was rejected by read loop
+	size  int   // size of reply body, which was read from connection
+	// checksum - results chunks are verified by checksum, chunk - index of the current chunk of results
+	checksum bool
+	chunk    int
+	// spare byte slice, which replies are decompressed into
+	spare []byte
 }
 
 func (buf *NetBuffer) Fetch(ctx context.Context, offset, limit int, asJson bool) (err error) {
 	flags := 0
 	if asJson {
 		flags |= bindings.ResultsJson
+	} else if bindings.IDsOnly(ctx) {
+		flags |= bindings.ResultsPure | bindings.ResultsWithItemID
 	} else {
 		flags |= bindings.ResultsCJson | bindings.ResultsWithItemID
 	}
+	if buf.checksum {
+		flags |= bindings.ResultsWithChecksum
+	}
 	// fmt.Printf("cmdFetchResults(reqId=%d, offset=%d, limit=%d, json=%v, flags=%v)\n", buf.reqID, offset, limit, asJson, flags)
-	netTimeout := uint32(buf.conn.owner.timeouts.RequestTimeout / time.Second)
+	// Results are bound to the connection, so they can't be fetched via another one after reconnect
+	if connErr := buf.conn.curError(); connErr != nil {
+		buf.reqID = -1
+		return &bindings.ErrResultsLostOnReconnect{Consumed: offset, Err: connErr}
+	}
+	netTimeout := buf.conn.owner.tuned().timeouts.RequestTimeout
 	fetchBuf, err := buf.conn.rpcCall(ctx, cmdFetchResults, netTimeout, buf.reqID, flags, offset, limit)
 	defer fetchBuf.Free()
 	if err != nil {
+		if buf.conn.curError() != nil {
+			buf.reqID = -1
+			return &bindings.ErrResultsLostOnReconnect{Consumed: offset, Err: err}
+		}
 		buf.close()
 		return
 	}
 	fetchBuf.buf, buf.buf = buf.buf, fetchBuf.buf
 
+	buf.cmd = cmdFetchResults
 	if err = buf.parseArgs(); err != nil {
 		buf.close()
 		return
 	}
-	if buf.args[1].(int) == -1 {
+	resultsID, err := buf.resultsID()
+	if err != nil {
+		buf.close()
+		return
+	}
+	if resultsID == -1 {
 		buf.reqID = -1
 	}
+	buf.chunk++
+	if err = buf.verifyChecksum(); err != nil {
+		buf.close()
+	}
 	return
 }
 
+// Free closes results of reply and releases buffer for reuse. Args of reply and slice, which is returned by GetBuf, point into buffer,
+// so they must not be used after Free. In debug mode (build tag reindexer_netbuf_debug) released memory is poisoned, so such use is visible
 func (buf *NetBuffer) Free() {
 	if buf != nil {
 		buf.close()
+		buf.args = buf.args[:0]
+		buf.buf = releasedBytes(buf.buf)
+		buf.spare = releasedBytes(buf.spare)
 		bufPool.Put(buf)
 	}
 }
 
+// releasedBytes returns b to be reused by the next reply or nil, if it's too large to be kept
+func releasedBytes(b []byte) []byte {
+	if cap(b) > netBufMaxRetained {
+		return nil
+	}
+	if netBufPoison {
+		b = b[:cap(b)]
+		for i := range b {
+			b[i] = netBufPoisonByte
+		}
+	}
+	return b[:0]
+}
+
+// growBytes returns b of len size. Capacity is grown geometrically, so buffers fit the next replies of close sizes too
+func growBytes(b []byte, size int) []byte {
+	if cap(b) >= size {
+		return b[:size]
+	}
+	c := 2 * cap(b)
+	if c < netBufMinCap {
+		c = netBufMinCap
+	}
+	for c < size {
+		c *= 2
+	}
+	return make([]byte, size, c)
+}
+
+// GetBuf returns the first arg of reply (e.g. results of select), which points into buffer and is valid until Free
 func (buf *NetBuffer) GetBuf() []byte {
-	return buf.args[0].([]byte)
+	b, _ := buf.ArgBytes(0)
+	return b
 }
 
 func (buf *NetBuffer) needClose() bool {
 	return buf.reqID != -1
 }
 
+// parseArgs checks error code of reply and finds positions of its args without decoding them, so args are decoded
+// only by typed accessors (see ArgInt) and don't allocate
 func (buf *NetBuffer) parseArgs() (err error) {
-	if buf.args != nil {
-		buf.args = buf.args[:0]
+	buf.args = buf.args[:0]
+	if buf.err != nil {
+		return buf.err
 	}
+	defer func() {
+		if r := recover(); r != nil {
+			buf.args = buf.args[:0]
+			err = malformedReplyError(buf.cmd, r)
+		}
+	}()
 	dec := newRPCDecoder(buf.buf)
 	if err = dec.errCode(); err != nil {
 		if rerr, ok := err.(bindings.Error); ok {
@@ -77,24 +165,70 @@ func (buf *NetBuffer) parseArgs() (err error) {
 		return
 	}
 	retCount := dec.argsCount()
-	if retCount > 0 {
-		for i := 0; i < retCount; i++ {
-			buf.args = append(buf.args, dec.intfArg())
-		}
+	for i := 0; i < retCount; i++ {
+		buf.args = append(buf.args, dec.skipArg())
 	}
 	return
 }
 
+// verifyChecksum checks checksum of results chunk, which server sends as the last arg of reply
+func (buf *NetBuffer) verifyChecksum() error {
+	if !buf.checksum {
+		return nil
+	}
+	if len(buf.args) < 3 {
+		if buf.conn.owner.ServerCapabilities()&bindings.ServerCapResultChecksum == 0 {
+			return bindings.NewError("rq: results checksum is not supported by server", bindings.ErrParams)
+		}
+		return &bindings.ErrResultCorrupted{Chunk: buf.chunk, Missing: true}
+	}
+	chunk, _ := buf.ArgBytes(0)
+	expected, err := buf.ArgInt(2)
+	if actual := bindings.ResultsChecksum(chunk); err != nil || uint32(expected) != actual {
+		return &bindings.ErrResultCorrupted{Chunk: buf.chunk, Expected: uint32(expected), Actual: actual}
+	}
+	return nil
+}
+
 func (buf *NetBuffer) close() {
+	if buf.needClose() && buf.conn.curError() != nil {
+		// Results were closed by server together with connection
+		buf.reqID = -1
+	}
 	if buf.needClose() {
-		netTimeout := uint32(buf.conn.owner.timeouts.RequestTimeout / time.Second)
-		closeBuf, err := buf.conn.rpcCall(context.TODO(), cmdCloseResults, netTimeout, buf.reqID)
+		err := buf.closeResults()
+		if err != nil && !buf.conn.hasError() {
+			// Results are bound to the connection, so close is retried only while it's alive
+			err = buf.closeResults()
+		}
+		if err != nil && !buf.conn.hasError() {
+			atomic.AddInt64(&buf.conn.owner.traffic.leakedResults, 1)
+			fmt.Printf("rx: query close error: %v\n", err)
+		}
 		buf.reqID = -1
-		if err != nil {
-			fmt.Printf("rx: query close error: %v", err)
+	}
+}
+
+// closeResultsTimeout - timeout of cmdCloseResults. It doesn't depend on context of request, which may be already canceled on close
+const closeResultsTimeout = 2 * time.Second
+
+func (buf *NetBuffer) closeResults() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeResultsTimeout)
+	defer cancel()
+	closeBuf, err := buf.conn.rpcCall(ctx, cmdCloseResults, closeResultsTimeout, buf.reqID)
+	closeBuf.Free()
+	return err
+}
+
+// reject drops reply of cmd with err. Server side results of the select are closed on Free
+func (buf *NetBuffer) reject(cmd int, err error) {
+	if cmd == cmdSelect && buf.parseArgs() == nil {
+		if resultsID, err := buf.ArgInt(1); err == nil {
+			buf.reqID = int(resultsID)
 		}
-		closeBuf.Free()
 	}
+	buf.buf = buf.buf[:0]
+	buf.err = err
 }
 
 func newNetBuffer(size int, conn *connection) (buf *NetBuffer) {
@@ -105,21 +239,32 @@ func newNetBuffer(size int, conn *connection) (buf *NetBuffer) {
 	} else {
 		buf = &NetBuffer{}
 	}
-	if cap(buf.buf) >= size {
-		buf.buf = buf.buf[:size]
-	} else {
-		buf.buf = make([]byte, size)
-	}
+	buf.buf = growBytes(buf.buf, size)
 	buf.conn = conn
 	buf.reqID = -1
-	if len(buf.args) > 0 {
-		buf.args = buf.args[:0]
-	}
+	buf.cmd = 0
+	buf.err = nil
+	buf.checksum = false
+	buf.chunk = 0
+	buf.args = buf.args[:0]
 
 	return buf
 }
 
+// decompress replaces compressed body of reply by decoded one. Size of decoded body is limited like size of reply
 func (buf *NetBuffer) decompress() (err error) {
-	buf.buf, err = snappy.Decode(nil, buf.buf)
-	return err
+	size, err := snappy.DecodedLen(buf.buf)
+	if err != nil {
+		return err
+	}
+	if size > cprotoMaxReplySize {
+		return fmt.Errorf("decoded size %d is too large", size)
+	}
+	decoded, err := snappy.Decode(growBytes(buf.spare, size), buf.buf)
+	if err != nil {
+		return err
+	}
+	// Compressed body is kept as spare slice for the next decompression
+	buf.buf, buf.spare = decoded, buf.buf
+	return nil
 }
@@ -0,0 +1,6 @@
+// +build !reindexer_netbuf_debug
+
+package cproto
+
+// netBufPoison - NetBuffer.Free poisons released memory
+const netBufPoison = false
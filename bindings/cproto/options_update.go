@@ -0,0 +1,164 @@
+package cproto
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// tunables - options of binding, which may be changed by UpdateOptions. They are replaced as a whole, so request, which has loaded them,
+// uses the same values until it's done
+type tunables struct {
+	timeouts      bindings.OptionTimeouts
+	retryAttempts bindings.OptionRetryAttempts
+	slowRPC       bindings.OptionSlowRPC
+	tracer        bindings.OptionTracer
+	requestLog    bindings.OptionRequestLog
+	deadlineFloor time.Duration
+}
+
+var defaultTunables tunables
+
+// tuned returns the current tunables of binding
+func (binding *NetCProto) tuned() *tunables {
+	if tun, _ := binding.tunables.Load().(*tunables); tun != nil {
+		return tun
+	}
+	return &defaultTunables
+}
+
+// apply sets option, if it's one of tunables. Zero timeouts of bindings.OptionTimeouts are not changed
+func (tun *tunables) apply(option interface{}) bool {
+	switch v := option.(type) {
+	case bindings.OptionTimeouts:
+		if v.LoginTimeout != 0 {
+			tun.timeouts.LoginTimeout = v.LoginTimeout
+		}
+		if v.RequestTimeout != 0 {
+			tun.timeouts.RequestTimeout = v.RequestTimeout
+		}
+	case bindings.OptionRetryAttempts:
+		tun.retryAttempts = v
+	case bindings.OptionSlowRPC:
+		tun.slowRPC = v
+	case bindings.OptionTracer:
+		tun.tracer = v
+	case bindings.OptionRequestLog:
+		tun.requestLog = v
+	case bindings.OptionDeadlineFloor:
+		tun.deadlineFloor = v.Floor
+	default:
+		return false
+	}
+	return true
+}
+
+func (tun *tunables) normalize() {
+	if tun.timeouts.RequestTimeout != 0 && tun.timeouts.LoginTimeout > tun.timeouts.RequestTimeout {
+		tun.timeouts.RequestTimeout = tun.timeouts.LoginTimeout
+	}
+	if tun.retryAttempts.Read < 0 {
+		tun.retryAttempts.Read = 0
+	}
+	if tun.retryAttempts.Write < 0 {
+		tun.retryAttempts.Write = 0
+	}
+}
+
+// UpdateOptions changes timeouts, retry attempts, slow RPC hook, tracer, request log hook, deadline floor and size of the pool at runtime.
+// New values are used by the subsequent requests, in-flight requests keep the old ones. Other options are rejected
+// with ErrParams, nothing is changed in this case
+func (binding *NetCProto) UpdateOptions(options ...interface{}) error {
+	binding.updateLock.Lock()
+	defer binding.updateLock.Unlock()
+
+	tun := *binding.tuned()
+	poolSize := 0
+	for _, option := range options {
+		if tun.apply(option) {
+			continue
+		}
+		if v, ok := option.(bindings.OptionConnPoolSize); ok {
+			if v.ConnPoolSize <= 0 {
+				return bindings.NewError(fmt.Sprintf("rq: Invalid size of connections pool %d", v.ConnPoolSize), bindings.ErrParams)
+			}
+			poolSize = v.ConnPoolSize
+			continue
+		}
+		return bindings.NewError(fmt.Sprintf("rq: Option %T of cproto binding can't be changed at runtime", option), bindings.ErrParams)
+	}
+	tun.normalize()
+	if poolSize != 0 {
+		if err := binding.resizePool(poolSize); err != nil {
+			return err
+		}
+	}
+	binding.tunables.Store(&tun)
+	return nil
+}
+
+// resizePool grows pool by new connections to the active DSN or shrinks it. Removed connections aren't used for new requests,
+// they are closed after their in-flight requests
+func (binding *NetCProto) resizePool(size int) error {
+	binding.lock.Lock()
+	oldSize := binding.poolSize
+	binding.poolSize = size
+	conns := binding.pool.conns
+	if len(conns) == 0 || len(conns) == size {
+		// Pool isn't connected yet, it's connected with the new size
+		binding.lock.Unlock()
+		return nil
+	}
+	if size < len(conns) {
+		binding.pool.conns = append([]*connection(nil), conns[:size]...)
+		if len(binding.pool.reconnects) > size {
+			binding.pool.reconnects = append([]int64(nil), binding.pool.reconnects[:size]...)
+		}
+		binding.lock.Unlock()
+		for _, conn := range conns[size:] {
+			go conn.Finalize()
+		}
+		return nil
+	}
+	connVersion, dsn := binding.dsn.connVersion, binding.getActiveDSN()
+	binding.lock.Unlock()
+
+	added := make([]*connection, size-len(conns))
+	var wg sync.WaitGroup
+	wg.Add(len(added))
+	for i := range added {
+		go func(i int) {
+			defer wg.Done()
+			added[i], _ = newConnection(context.Background(), binding, dsn)
+		}(i)
+	}
+	wg.Wait()
+	for _, conn := range added {
+		if err := conn.curError(); err != nil {
+			for _, conn := range added {
+				conn.Finalize()
+			}
+			binding.lock.Lock()
+			if binding.poolSize == size {
+				binding.poolSize = oldSize
+			}
+			binding.lock.Unlock()
+			return err
+		}
+	}
+
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	if binding.dsn.connVersion != connVersion || len(binding.pool.conns) == 0 {
+		// Pool is reconnected meanwhile, it's already of the new size
+		for _, conn := range added {
+			go conn.Finalize()
+		}
+		return nil
+	}
+	binding.pool.conns = append(append([]*connection(nil), binding.pool.conns...), added...)
+	return nil
+}
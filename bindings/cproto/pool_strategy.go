@@ -0,0 +1,62 @@
+package cproto
+
+import (
+	"sync/atomic"
+)
+
+// inFlight returns count of requests in flight: each of them takes seq num slot until its reply
+func (c *connection) inFlight() int {
+	return int(c.queueSize) - len(c.seqs)
+}
+
+// getLeastInFlight returns usable connection with the least count of requests in flight. Search starts from the next connection
+// in turn, so idle connections are used evenly. Connection in turn, which is not usable, is returned as is, so it's connected again
+// like with round robin strategy
+func (p *pool) getLeastInFlight() *connection {
+	start := p.nextSlot()
+	best := p.conns[start]
+	if !best.isUsable() {
+		return best
+	}
+	bestInFlight := best.inFlight()
+	for i := 1; i < len(p.conns) && bestInFlight != 0; i++ {
+		conn := p.conns[(start+i)%len(p.conns)]
+		if !conn.isUsable() {
+			continue
+		}
+		if inFlight := conn.inFlight(); inFlight < bestInFlight {
+			best, bestInFlight = conn, inFlight
+		}
+	}
+	return best
+}
+
+// getTwoRandomChoices returns the less loaded of two random connections. Connection, which is not usable, is returned as is,
+// so it's connected again like with round robin strategy
+func (p *pool) getTwoRandomChoices() *connection {
+	n := uint64(len(p.conns))
+	if n == 1 {
+		return p.conns[0]
+	}
+	r := splitMix64(atomic.AddUint64(&p.next, 1))
+	i := r % n
+	j := (i + 1 + (r>>32)%(n-1)) % n
+	a, b := p.conns[i], p.conns[j]
+	switch {
+	case !a.isUsable():
+		return a
+	case !b.isUsable():
+		return b
+	case b.inFlight() < a.inFlight():
+		return b
+	}
+	return a
+}
+
+// splitMix64 mixes bits of x, so sequential values of counter become pseudo-random
+func splitMix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
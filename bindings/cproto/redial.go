@@ -0,0 +1,157 @@
+package cproto
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// errAllConnsDown - all the connections of the pool are failed and are not re-dialed in down window of reconnect backoff
+var errAllConnsDown = bindings.NewError("rq: All connections are down longer than reconnect down window", bindings.ErrNetwork)
+
+// redialer - state of background re-dial of failed connections of the pool (see bindings.OptionReconnectBackoff.Background). Guarded by lock of binding
+type redialer struct {
+	running bool
+	// downSince - time, when all the connections of the pool were found failed (zero time - the pool has usable connection)
+	downSince time.Time
+	// restoredCh is closed and replaced after each re-dial of connection
+	restoredCh chan struct{}
+}
+
+// jitterDelay changes delay by random share of it in [-jitter, jitter]
+func jitterDelay(delay time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return delay
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return time.Duration(float64(delay) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// startRedial starts re-dial of failed connections of the pool, if it's not running yet. Must be called under lock
+func (binding *NetCProto) startRedial() {
+	if binding.redial.running || binding.termCh == nil {
+		return
+	}
+	binding.redial.running = true
+	go binding.redialLoop(binding.termCh)
+}
+
+// redialLoop re-dials failed and draining connections of the pool with exponential backoff, until all of them are connected or binding is finalized.
+// Failed connections stay in their slots meanwhile, so the requests are sent via the rest of the pool. If all the connections are failed
+// and nothing is connected, the next attempt is made to the next DSN
+func (binding *NetCProto) redialLoop(termCh chan struct{}) {
+	backoff := binding.reconnectBackoff
+	delay := backoff.Min
+	if delay <= 0 {
+		delay = minConnectRetriesBackoff
+	}
+	for {
+		timer := time.NewTimer(jitterDelay(delay, backoff.Jitter))
+		select {
+		case <-termCh:
+			timer.Stop()
+			binding.lock.Lock()
+			binding.redial.running = false
+			binding.lock.Unlock()
+			return
+		case <-timer.C:
+		}
+
+		restored := 0
+		for _, old := range binding.getAllConns() {
+			if old.isUsable() || isClosed(termCh) {
+				continue
+			}
+			if conn, err := binding.replaceConn(context.Background(), old); err == nil && conn != nil {
+				restored++
+			}
+		}
+
+		binding.lock.Lock()
+		failed, alive := 0, 0
+		for _, conn := range binding.pool.conns {
+			if !conn.isUsable() {
+				failed++
+			} else {
+				alive++
+			}
+		}
+		if restored != 0 {
+			if binding.redial.restoredCh != nil {
+				close(binding.redial.restoredCh)
+			}
+			binding.redial.restoredCh = make(chan struct{})
+			binding.redial.downSince = time.Time{}
+		}
+		if failed == 0 {
+			binding.redial.running = false
+			binding.lock.Unlock()
+			return
+		}
+		if alive == 0 && len(binding.dsn.url) > 1 {
+			binding.nextDSN()
+		}
+		binding.lock.Unlock()
+
+		if restored != 0 {
+			delay = backoff.Min
+			if delay <= 0 {
+				delay = minConnectRetriesBackoff
+			}
+		} else if delay *= 2; backoff.Max > 0 && delay > backoff.Max {
+			delay = backoff.Max
+		}
+	}
+}
+
+// awaitUsableConn returns usable connection of the pool. If all of them are failed, it waits for re-dial of connection until ctx is done
+// or down window of reconnect backoff is over, then fails with errAllConnsDown
+func (binding *NetCProto) awaitUsableConn(ctx context.Context) (*connection, error) {
+	for {
+		binding.lock.Lock()
+		for i := 0; i < len(binding.pool.conns); i++ {
+			if conn := binding.pool.getInTurn(); conn.isUsable() {
+				binding.redial.downSince = time.Time{}
+				binding.lock.Unlock()
+				return conn, nil
+			}
+		}
+		binding.startRedial()
+		if binding.redial.downSince.IsZero() {
+			binding.redial.downSince = time.Now()
+		}
+		if binding.redial.restoredCh == nil {
+			binding.redial.restoredCh = make(chan struct{})
+		}
+		restoredCh := binding.redial.restoredCh
+		wait := time.Until(binding.redial.downSince.Add(binding.reconnectBackoff.DownWindow))
+		binding.lock.Unlock()
+		if wait <= 0 {
+			return nil, errAllConnsDown
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-restoredCh:
+			timer.Stop()
+		case <-timer.C:
+			return nil, errAllConnsDown
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
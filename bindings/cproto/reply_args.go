@@ -0,0 +1,160 @@
+package cproto
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+var cmdNames = map[int]string{
+	cmdPing:              "Ping",
+	cmdLogin:             "Login",
+	cmdOpenDatabase:      "OpenDatabase",
+	cmdCloseDatabase:     "CloseDatabase",
+	cmdDropDatabase:      "DropDatabase",
+	cmdOpenNamespace:     "OpenNamespace",
+	cmdCloseNamespace:    "CloseNamespace",
+	cmdDropNamespace:     "DropNamespace",
+	cmdTruncateNamespace: "TruncateNamespace",
+	cmdRenameNamespace:   "RenameNamespace",
+	cmdAddIndex:          "AddIndex",
+	cmdEnumNamespaces:    "EnumNamespaces",
+	cmdDropIndex:         "DropIndex",
+	cmdUpdateIndex:       "UpdateIndex",
+	cmdAddTxItem:         "AddTxItem",
+	cmdCommitTx:          "CommitTx",
+	cmdRollbackTx:        "RollbackTx",
+	cmdStartTransaction:  "StartTransaction",
+	cmdDeleteQueryTx:     "DeleteQueryTx",
+	cmdUpdateQueryTx:     "UpdateQueryTx",
+	cmdCommit:            "Commit",
+	cmdModifyItem:        "ModifyItem",
+	cmdDeleteQuery:       "DeleteQuery",
+	cmdUpdateQuery:       "UpdateQuery",
+	cmdSelect:            "Select",
+	cmdSelectSQL:         "SelectSQL",
+	cmdFetchResults:      "FetchResults",
+	cmdCloseResults:      "CloseResults",
+	cmdGetMeta:           "GetMeta",
+	cmdPutMeta:           "PutMeta",
+	cmdEnumMeta:          "EnumMeta",
+	cmdGetSQLSuggestions: "GetSQLSuggestions",
+	cmdCancelRequest:     "CancelRequest",
+	cmdShutdownNotice:    "ShutdownNotice",
+}
+
+// cmdName returns name of command for errors and logs
+func cmdName(cmd int) string {
+	if name, ok := cmdNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", cmd)
+}
+
+// netArg - arg of reply, which is decoded on demand from buffer of reply by typed accessors (e.g. ArgInt).
+// Value of string arg is buf[pos:end], value of the other args is encoded in buf[pos:end]
+type netArg struct {
+	typ int
+	pos int
+	end int
+}
+
+// ArgsCount returns count of args of reply
+func (buf *NetBuffer) ArgsCount() int {
+	return len(buf.args)
+}
+
+// ArgInt returns arg i of integer type. Accessors return error instead of panic, if server replied with fewer args
+// or with args of other types (e.g. server of another version)
+func (buf *NetBuffer) ArgInt(i int) (int64, error) {
+	if i >= len(buf.args) {
+		return 0, buf.missingArgError(i, "int64")
+	}
+	switch a := buf.args[i]; a.typ {
+	case bindings.ValueInt, bindings.ValueInt64:
+		v, _ := binary.Varint(buf.buf[a.pos:a.end])
+		return v, nil
+	}
+	return 0, buf.argTypeError(i, "int64")
+}
+
+// ArgBytes returns arg i of string type, which points into buffer and is valid until Free
+func (buf *NetBuffer) ArgBytes(i int) ([]byte, error) {
+	if i >= len(buf.args) {
+		return nil, buf.missingArgError(i, "string")
+	}
+	if a := buf.args[i]; a.typ == bindings.ValueString {
+		return buf.buf[a.pos:a.end], nil
+	}
+	return nil, buf.argTypeError(i, "string")
+}
+
+// ArgString returns copy of arg i of string type
+func (buf *NetBuffer) ArgString(i int) (string, error) {
+	v, err := buf.ArgBytes(i)
+	return string(v), err
+}
+
+// Args returns values of all the args of reply: int, int64, bool, float64 or []byte, which points into buffer.
+// Values are boxed on each call, so typed accessors should be used instead
+func (buf *NetBuffer) Args() []interface{} {
+	args := make([]interface{}, len(buf.args))
+	for i, a := range buf.args {
+		ser := cjson.NewSerializer(buf.buf[a.pos:a.end])
+		switch a.typ {
+		case bindings.ValueInt:
+			args[i] = int(ser.GetVarInt())
+		case bindings.ValueInt64:
+			args[i] = ser.GetVarInt()
+		case bindings.ValueBool:
+			args[i] = ser.GetVarInt() != 0
+		case bindings.ValueDouble:
+			args[i] = ser.GetDouble()
+		case bindings.ValueString:
+			args[i] = buf.buf[a.pos:a.end]
+		}
+	}
+	return args
+}
+
+func (buf *NetBuffer) missingArgError(i int, expected string) error {
+	return bindings.NewError(fmt.Sprintf("rq: Invalid reply to command %s: arg %d of type %s is expected, but reply has %d args",
+		cmdName(buf.cmd), i, expected, len(buf.args)), bindings.ErrParseBin)
+}
+
+func (buf *NetBuffer) argTypeError(i int, expected string) error {
+	return bindings.NewError(fmt.Sprintf("rq: Invalid reply to command %s: arg %d has type %s, but %s is expected",
+		cmdName(buf.cmd), i, argTypeName(buf.args[i].typ), expected), bindings.ErrParseBin)
+}
+
+func argTypeName(typ int) string {
+	switch typ {
+	case bindings.ValueString:
+		return "string"
+	case bindings.ValueInt:
+		return "int"
+	case bindings.ValueInt64:
+		return "int64"
+	case bindings.ValueBool:
+		return "bool"
+	case bindings.ValueDouble:
+		return "double"
+	}
+	return fmt.Sprintf("%d", typ)
+}
+
+// resultsID checks args of reply with results chunk and returns id of server side results
+func (buf *NetBuffer) resultsID() (int, error) {
+	if _, err := buf.ArgBytes(0); err != nil {
+		return -1, err
+	}
+	id, err := buf.ArgInt(1)
+	return int(id), err
+}
+
+// malformedReplyError - error of reply to command, which args can't be decoded
+func malformedReplyError(cmd int, cause interface{}) error {
+	return bindings.NewError(fmt.Sprintf("rq: Malformed reply to command %s: %v", cmdName(cmd), cause), bindings.ErrParseBin)
+}
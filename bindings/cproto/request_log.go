@@ -0,0 +1,24 @@
+package cproto
+
+import (
+	"context"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// logRequest passes done request to request log hook of binding. Name, label and address of entry are set here
+func (c *connection) logRequest(ctx context.Context, hook func(entry bindings.RequestLogEntry), entry bindings.RequestLogEntry) {
+	entry.Name = cmdName(entry.Cmd)
+	entry.Label = c.owner.activityLabel(ctx)
+	entry.Addr = c.addr
+	hook(entry)
+}
+
+// sentSince returns time since moment, which is given as time since start of connection (see rpcCallAsync). Zero - request wasn't sent
+func (c *connection) sentSince(sentAt int64) time.Duration {
+	if sentAt == 0 {
+		return 0
+	}
+	return time.Since(c.start) - time.Duration(sentAt)
+}
@@ -0,0 +1,95 @@
+package cproto
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// connStats - counters of connection, which are reported by Stats. Accessed atomically
+type connStats struct {
+	requests     int64
+	errors       int64
+	bytesRead    int64
+	bytesWritten int64
+	// total time of waiting for free request slot in nanoseconds
+	seqWait int64
+}
+
+// complete calls completion of async request and counts its error
+func (c *connection) complete(cmpl bindings.RawCompletion, buf bindings.RawBuffer, err error) {
+	if err != nil {
+		atomic.AddInt64(&c.stats.errors, 1)
+	}
+	cmpl(buf, err)
+}
+
+// connStats returns statistics of connection in slot of pool
+func (c *connection) connStats(slot int, bulk bool, reconnects int64) bindings.ConnStats {
+	st := bindings.ConnStats{
+		Slot:         slot,
+		Bulk:         bulk,
+		Addr:         c.addr,
+		Connected:    c.start,
+		Reconnects:   reconnects,
+		InFlight:     cap(c.seqs) - len(c.seqs),
+		Requests:     atomic.LoadInt64(&c.stats.requests),
+		Errors:       atomic.LoadInt64(&c.stats.errors),
+		BytesRead:    atomic.LoadInt64(&c.stats.bytesRead),
+		BytesWritten: atomic.LoadInt64(&c.stats.bytesWritten),
+		SeqWait:      time.Duration(atomic.LoadInt64(&c.stats.seqWait)),
+		Err:          c.curError(),
+	}
+	if stamp := atomic.LoadInt64(&c.lastReadStamp); stamp != 0 {
+		st.LastRead = time.Unix(0, stamp)
+	}
+	return st
+}
+
+// Stats returns statistics of connections of the pool by its slots and of connections of bulk pool
+func (binding *NetCProto) Stats() []bindings.ConnStats {
+	binding.lock.RLock()
+	conns := binding.pool.conns
+	reconnects := append([]int64(nil), binding.pool.reconnects...)
+	binding.lock.RUnlock()
+	bulk := binding.bulk.getAll()
+
+	stats := make([]bindings.ConnStats, 0, len(conns)+len(bulk))
+	for i, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		var count int64
+		if i < len(reconnects) {
+			count = reconnects[i]
+		}
+		stats = append(stats, conn.connStats(i, false, count))
+	}
+	for i, conn := range bulk {
+		stats = append(stats, conn.connStats(i, true, 0))
+	}
+	return stats
+}
+
+// inheritReconnects counts reconnect of each slot of the pool, which replaces the old pool
+func (p *pool) inheritReconnects(old *pool) {
+	if len(old.conns) == 0 {
+		return
+	}
+	p.reconnects = make([]int64, len(p.conns))
+	copy(p.reconnects, old.reconnects)
+	for i := range p.reconnects {
+		if i < len(old.conns) {
+			p.reconnects[i]++
+		}
+	}
+}
+
+// addReconnect counts reconnect of slot of the pool
+func (p *pool) addReconnect(slot int) {
+	if n := slot + 1 - len(p.reconnects); n > 0 {
+		p.reconnects = append(p.reconnects, make([]int64, n)...)
+	}
+	p.reconnects[slot]++
+}
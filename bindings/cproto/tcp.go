@@ -0,0 +1,71 @@
+package cproto
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// validateTCP checks bindings.OptionTCP against DSNs of binding
+func (binding *NetCProto) validateTCP(u []url.URL) error {
+	opts := binding.tcp
+	if opts == (bindings.OptionTCP{}) {
+		return nil
+	}
+	if opts.ReadBufSize < 0 || opts.WriteBufSize < 0 {
+		return bindings.NewError(fmt.Sprintf("rq: Invalid socket buffer sizes %d/%d of TCP option", opts.ReadBufSize, opts.WriteBufSize), bindings.ErrParams)
+	}
+	if opts.KeepAlivePeriod > 0 && opts.KeepAlivePeriod < time.Second {
+		return bindings.NewError(fmt.Sprintf("rq: Keepalive period %v of TCP option is less than 1s", opts.KeepAlivePeriod), bindings.ErrParams)
+	}
+	for i := range u {
+		if u[i].Scheme == unixScheme {
+			return bindings.NewError(fmt.Sprintf("rq: TCP option isn't applicable to unix socket DSN '%s'", u[i].String()), bindings.ErrParams)
+		}
+	}
+	return nil
+}
+
+// tuneTCP applies bindings.OptionTCP to the dialed connection. Connections of custom dialer, which are not *net.TCPConn, are not tuned
+func (binding *NetCProto) tuneTCP(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	tcpConn.SetNoDelay(true)
+	opts := binding.tcp
+	if opts.KeepAlivePeriod < 0 {
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if opts.KeepAlivePeriod > 0 {
+		if err := tcpConn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tcpConn.SetKeepAlivePeriod(opts.KeepAlivePeriod); err != nil {
+			return err
+		}
+	}
+	if opts.ReadBufSize > 0 {
+		if err := tcpConn.SetReadBuffer(opts.ReadBufSize); err != nil {
+			return err
+		}
+	}
+	if opts.WriteBufSize > 0 {
+		if err := tcpConn.SetWriteBuffer(opts.WriteBufSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBufSize returns size of buffered reader of connection: it's not less than socket receive buffer
+func (binding *NetCProto) readBufSize() int {
+	if binding.tcp.ReadBufSize > bufsCap {
+		return binding.tcp.ReadBufSize
+	}
+	return bufsCap
+}
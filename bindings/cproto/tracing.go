@@ -0,0 +1,27 @@
+package cproto
+
+import (
+	"context"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// rpcSpan - request, which is traced by bindings.OptionTracer. Zero value traces nothing
+type rpcSpan struct {
+	end func(res bindings.RPCSpanEnd)
+}
+
+// startSpan starts span of request, if binding has tracer
+func (c *connection) startSpan(ctx context.Context, cmd int) rpcSpan {
+	tracer := c.owner.tuned().tracer
+	if tracer.StartSpan == nil {
+		return rpcSpan{}
+	}
+	return rpcSpan{end: tracer.StartSpan(ctx, bindings.RPCSpan{Cmd: cmd, Name: cmdName(cmd), Label: c.owner.activityLabel(ctx), Addr: c.addr})}
+}
+
+func (s rpcSpan) finish(requestSize, replySize int, err error) {
+	if s.end != nil {
+		s.end(bindings.RPCSpanEnd{RequestSize: requestSize, ReplySize: replySize, Err: err})
+	}
+}
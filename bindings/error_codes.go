@@ -0,0 +1,85 @@
+package bindings
+
+import "fmt"
+
+// CodeError - class of reindexer errors by their code. Error of reindexer unwraps to CodeError of its code, so errors of the class
+// are matched by errors.Is(err, ErrorNotFound) and the code of unknown class is retrieved by errors.As(err, &codeErr).
+// Timeout and cancel of request by server are returned as context.DeadlineExceeded and context.Canceled
+type CodeError int
+
+// Classes of errors by codes of server
+const (
+	ErrorParseSQL         = CodeError(ErrParseSQL)
+	ErrorQueryExec        = CodeError(ErrQueryExec)
+	ErrorParams           = CodeError(ErrParams)
+	ErrorLogic            = CodeError(ErrLogic)
+	ErrorParseJson        = CodeError(ErrParseJson)
+	ErrorParseDSL         = CodeError(ErrParseDSL)
+	ErrorConflict         = CodeError(ErrConflict)
+	ErrorParseBin         = CodeError(ErrParseBin)
+	ErrorForbidden        = CodeError(ErrForbidden)
+	ErrorWasRelock        = CodeError(ErrWasRelock)
+	ErrorNotValid         = CodeError(ErrNotValid)
+	ErrorNetwork          = CodeError(ErrNetwork)
+	ErrorNotFound         = CodeError(ErrNotFound)
+	ErrorStateInvalidated = CodeError(ErrStateInvalidated)
+	ErrorBadTransaction   = CodeError(ErrBadTransaction)
+	ErrorOutdatedWAL      = CodeError(ErrOutdatedWAL)
+	ErrorNoWAL            = CodeError(ErrNoWAL)
+	ErrorDataHashMismatch = CodeError(ErrDataHashMismatch)
+	ErrorTimeout          = CodeError(ErrTimeout)
+	ErrorCanceled         = CodeError(ErrCanceled)
+	ErrorTagsMissmatch    = CodeError(ErrTagsMissmatch)
+	ErrorReplParams       = CodeError(ErrReplParams)
+	ErrorNsInvalidated    = CodeError(ErrNsInvalidated)
+	ErrorStateMismatch    = CodeError(ErrStateMismatch)
+
+	ErrorConnBrokenMidRequest = CodeError(ErrConnBrokenMidRequest)
+	ErrorQueueFull            = CodeError(ErrQueueFull)
+)
+
+var codeErrorNames = map[CodeError]string{
+	ErrorParseSQL:             "SQL parse error",
+	ErrorQueryExec:            "query execution error",
+	ErrorParams:               "invalid parameters",
+	ErrorLogic:                "logic error",
+	ErrorParseJson:            "JSON parse error",
+	ErrorParseDSL:             "DSL parse error",
+	ErrorConflict:             "conflict",
+	ErrorParseBin:             "binary parse error",
+	ErrorForbidden:            "forbidden",
+	ErrorWasRelock:            "lock was changed",
+	ErrorNotValid:             "not valid",
+	ErrorNetwork:              "network error",
+	ErrorNotFound:             "not found",
+	ErrorStateInvalidated:     "state invalidated",
+	ErrorBadTransaction:       "bad transaction",
+	ErrorOutdatedWAL:          "outdated WAL",
+	ErrorNoWAL:                "no WAL",
+	ErrorDataHashMismatch:     "data hash mismatch",
+	ErrorTimeout:              "timeout",
+	ErrorCanceled:             "canceled",
+	ErrorTagsMissmatch:        "tags mismatch",
+	ErrorReplParams:           "invalid replication parameters",
+	ErrorNsInvalidated:        "namespace invalidated",
+	ErrorStateMismatch:        "state mismatch",
+	ErrorConnBrokenMidRequest: "connection broken mid request",
+	ErrorQueueFull:            "request queue is full",
+}
+
+func (c CodeError) Error() string {
+	if name, ok := codeErrorNames[c]; ok {
+		return "rq: " + name
+	}
+	return fmt.Sprintf("rq: error with code %d", int(c))
+}
+
+func (c CodeError) Code() int {
+	return int(c)
+}
+
+// Known returns false for codes, which are unknown to the client (e.g. new codes of server)
+func (c CodeError) Known() bool {
+	_, ok := codeErrorNames[c]
+	return ok
+}
@@ -0,0 +1,23 @@
+package bindings
+
+import (
+	"context"
+	"time"
+)
+
+type hedgeKey struct{}
+
+// ContextWithHedge returns copy of ctx, which makes network bindings send select query again via another connection,
+// if it's not answered in delay. Only select queries are hedged
+func ContextWithHedge(ctx context.Context, delay time.Duration) context.Context {
+	return context.WithValue(ctx, hedgeKey{}, delay)
+}
+
+// HedgeDelay returns delay of hedged request from ctx or 0, if request isn't hedged
+func HedgeDelay(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+	delay, _ := ctx.Value(hedgeKey{}).(time.Duration)
+	return delay
+}
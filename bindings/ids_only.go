@@ -0,0 +1,20 @@
+package bindings
+
+import "context"
+
+type idsOnlyKey struct{}
+
+// ContextWithIDsOnly returns copy of ctx, which requests query results with ids of items only, without items data.
+// Network bindings request ResultsPure format, other bindings may still return items data
+func ContextWithIDsOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idsOnlyKey{}, true)
+}
+
+// IDsOnly returns true, if ctx requests query results without items data
+func IDsOnly(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	idsOnly, _ := ctx.Value(idsOnlyKey{}).(bool)
+	return idsOnly
+}
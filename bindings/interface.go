@@ -2,6 +2,9 @@ package bindings
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
 	"net/url"
 	"time"
 
@@ -137,6 +140,77 @@ func (e Error) Code() int {
 	return e.code
 }
 
+// Unwrap returns CodeError of code of e
+func (e Error) Unwrap() error {
+	if e.code == ErrOK {
+		return nil
+	}
+	return CodeError(e.code)
+}
+
+// ErrResultsLostOnReconnect - server-side query results were lost together with the connection, which owned them
+// Consumed - count of items, which were already read from the results
+type ErrResultsLostOnReconnect struct {
+	Consumed int
+	Err      error
+}
+
+func (e *ErrResultsLostOnReconnect) Error() string {
+	return fmt.Sprintf("rq: query results are lost on reconnect after %d items: %v", e.Consumed, e.Err)
+}
+
+func (e *ErrResultsLostOnReconnect) Code() int {
+	return ErrNetwork
+}
+
+func (e *ErrResultsLostOnReconnect) Unwrap() error {
+	return e.Err
+}
+
+// ErrCircuitOpen - request is not sent, because circuit breaker of the host is open or all the probes of half-open breaker are in flight.
+// RetryAfter - time until breaker becomes half-open (0 for half-open breaker)
+type ErrCircuitOpen struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("rq: circuit breaker of host '%s' is open, retry after %s", e.Host, e.RetryAfter)
+}
+
+func (e *ErrCircuitOpen) Code() int {
+	return ErrNetwork
+}
+
+func (e *ErrCircuitOpen) Unwrap() error {
+	return ErrorNetwork
+}
+
+// ErrResultCorrupted - checksum of query results chunk doesn't match the one, which was sent by server
+// Chunk - index of the chunk: 0 - reply to select, 1 and more - fetches of the rest of results
+type ErrResultCorrupted struct {
+	Chunk    int
+	Expected uint32
+	Actual   uint32
+	// Missing - reply has no checksum
+	Missing bool
+}
+
+func (e *ErrResultCorrupted) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("rq: query results are corrupted: chunk #%d has no checksum", e.Chunk)
+	}
+	return fmt.Sprintf("rq: query results are corrupted: checksum of chunk #%d is %08X, expected %08X", e.Chunk, e.Actual, e.Expected)
+}
+
+func (e *ErrResultCorrupted) Code() int {
+	return ErrNetwork
+}
+
+func (e *ErrResultCorrupted) Unwrap() error {
+	return ErrorNetwork
+}
+
 type Stats struct {
 	CountGetItem int
 	TimeGetItem  time.Duration
@@ -195,6 +269,51 @@ type RawBindingChanging interface {
 	OnChangeCallback(f func())
 }
 
+// RawBindingConnecting - binding, which calls connect callback after login of each new connection (see OptionConnectHook)
+type RawBindingConnecting interface {
+	OnConnectCallback(f func(ctx context.Context, binding RawBinding) error)
+}
+
+// RawBindingOptionsUpdating - binding, which changes some of its options at runtime. The other options are rejected with ErrParams
+type RawBindingOptionsUpdating interface {
+	UpdateOptions(options ...interface{}) error
+}
+
+// RawBindingVersion - binding, which knows version of reindexer server (or of linked library for builtin bindings)
+type RawBindingVersion interface {
+	ServerVersion() string
+}
+
+// RawBindingCapabilities - binding, which knows capabilities (ServerCap* bits) advertised by reindexer server
+type RawBindingCapabilities interface {
+	ServerCapabilities() int64
+}
+
+// RawBindingModifyItemAsync - binding, which sends item without waiting for reply. data may be reused right after return. cmpl is called with the reply
+type RawBindingModifyItemAsync interface {
+	ModifyItemAsync(ctx context.Context, nsHash int, namespace string, format int, data []byte, mode int, percepts []string, stateToken int, cmpl RawCompletion)
+}
+
+// RawBindingFinalizerCtx - binding, which is able to finalize gracefully: wait for in-flight requests until ctx is done
+type RawBindingFinalizerCtx interface {
+	FinalizeCtx(ctx context.Context) error
+}
+
+// RawBindingStats - binding, which reports statistics of its connections
+type RawBindingStats interface {
+	Stats() []ConnStats
+}
+
+// RawBindingSQLSuggestions - binding, which suggests completions of SQL query token, which ends at byte position pos
+type RawBindingSQLSuggestions interface {
+	GetSQLSuggestions(ctx context.Context, query string, pos int) ([]string, error)
+}
+
+// RawBindingEnumMeta - binding, which enumerates keys of meta of namespace
+type RawBindingEnumMeta interface {
+	EnumMeta(ctx context.Context, namespace string) ([]string, error)
+}
+
 var availableBindings = make(map[string]RawBinding)
 
 func RegisterBinding(name string, binding RawBinding) {
@@ -221,8 +340,29 @@ type OptionConnPoolSize struct {
 	ConnPoolSize int
 }
 
+// Strategies of choice of cproto connection for request from the pool
+const (
+	// Connections are chosen in turn
+	PoolRoundRobin = iota
+	// Usable connection with the least count of requests in flight is chosen
+	PoolLeastInFlight
+	// The less loaded of two random usable connections is chosen
+	PoolTwoRandomChoices
+)
+
+// OptionPoolStrategy - strategy of choice of cproto connection for each request (default PoolRoundRobin)
+type OptionPoolStrategy struct {
+	Strategy int
+}
+
+// OptionConnQueueSize - max count of concurrent requests of each connection (default 512, max 4096).
+// Requests above the limit wait for the free slot of connection (see QueueWait of SlowRPC)
+type OptionConnQueueSize struct {
+	QueueSize int
+}
+
 //OptionTimeouts sets client-side network timeouts on login(connect) and requests
-//Timer resolution here is 1 second
+//Timer resolution here is 10 ms. Request, which isn't completed in RequestTimeout, fails with ErrTimeout, which contains the timeout and command code
 type OptionTimeouts struct {
 	LoginTimeout   time.Duration
 	RequestTimeout time.Duration
@@ -256,8 +396,195 @@ type OptionConnect struct {
 
 // OptionCompression - DB connect options for server
 // EnableCompression - request compress traffic by snappy library
+// Threshold - only requests with larger size in bytes are compressed (0 - all requests)
 type OptionCompression struct {
 	EnableCompression bool
+	Threshold         int
+}
+
+// OptionConnectRetries - retries of the initial connection establishment
+// Attempts - max connect attempts count (0 - unlimited within Total)
+// Backoff - delay between attempts
+// Total - total time budget for all the attempts (0 - unlimited within Attempts)
+type OptionConnectRetries struct {
+	Attempts int
+	Backoff  time.Duration
+	Total    time.Duration
+}
+
+// OptionReconnectBackoff - delay of reconnect attempts after failed reconnect of cproto binding.
+// Delay starts from Min and is doubled after each failed attempt up to Max. While reconnect is delayed, requests fail with the last connect error.
+// Jitter - random share of delay in [0, 1], which is added to or subtracted from it, so clients don't reconnect to restarted server simultaneously.
+// Background - failed connections are re-dialed in background with the same delays instead of reconnect of the pool by the next request:
+// requests are sent via the rest of the pool meanwhile. If all the connections are failed, requests wait for re-dial up to DownWindow
+// since the pool is down, then fail with ErrNetwork 'All connections are down' (DownWindow 0 - fail immediately)
+type OptionReconnectBackoff struct {
+	Min        time.Duration
+	Max        time.Duration
+	Jitter     float64
+	Background bool
+	DownWindow time.Duration
+}
+
+// OptionPingInterval - keepalive pings of cproto connections, e.g. to keep them open behind NAT and to detect silently dropped ones.
+// Connection, which has no replies for Interval, is pinged. If nothing is read from it in Timeout (default Interval) after ping, connection is failed
+// and is reconnected by the next request. Connection with ping in flight is not chosen for requests, while the pool has another usable one
+type OptionPingInterval struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// OptionRequeueOnConnLoss - handling of requests of cproto binding, which connection is broken after they were sent.
+// Idempotent requests (selects, results fetches, pings, meta reads and namespaces enumeration) are sent again via another connection
+// of the pool up to Attempts times with the same context. The other requests fail with ErrConnBrokenMidRequest, because they may be
+// applied by server already, so they are not retried by OptionRetryAttempts too. Results fetches are bound to connection of query,
+// so they fail with ErrResultsLostOnReconnect instead of requeue
+type OptionRequeueOnConnLoss struct {
+	Attempts int
+}
+
+// OptionDiscardedRepliesLimit - limit of replies, which are discarded by cproto connection without waiting requests: late replies of
+// timed out requests and replies with unknown seq numbers. Connection, which discards more than Count replies in Interval, is considered
+// out of sync with server: it's failed with ErrNetwork and is reconnected by the next request
+type OptionDiscardedRepliesLimit struct {
+	Count    int
+	Interval time.Duration
+}
+
+// Reasons of disconnects, which are reported to disconnect handler
+const (
+	DisconnectNetworkError   = "network_error"
+	DisconnectServerShutdown = "server_shutdown"
+)
+
+// DisconnectEvent - cproto connection, which was closed not by the client
+// Reason - DisconnectServerShutdown, if server sent shutdown notice or closed idle connection, DisconnectNetworkError otherwise
+// Err - error of the connection (nil for shutdown notice)
+type DisconnectEvent struct {
+	Addr   string
+	Reason string
+	Err    error
+}
+
+// OptionOnDisconnect - handler of disconnects of cproto connections
+type OptionOnDisconnect struct {
+	Handler func(ev DisconnectEvent)
+}
+
+// States of circuit breaker of cproto binding
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// OptionCircuitBreaker - client-side circuit breaker of cproto binding, which is tracked per host of server.
+// After FailureThreshold consecutive network errors and timeouts breaker is open for OpenDuration (default 5s): requests to the host fail immediately with ErrCircuitOpen.
+// Then breaker is half-open: up to HalfOpenProbes (default 1) requests in flight are sent to server as probes, the rest fail with ErrCircuitOpen.
+// Successful probe closes breaker, failed one opens it again. OnStateChange (optional) is called on each transition
+type OptionCircuitBreaker struct {
+	FailureThreshold int
+	OpenDuration     time.Duration
+	HalfOpenProbes   int
+	OnStateChange    func(ev CircuitBreakerEvent)
+}
+
+// CircuitBreakerEvent - transition of circuit breaker of host from state From to state To. Err - the last failure, which opened breaker
+type CircuitBreakerEvent struct {
+	Host string
+	From string
+	To   string
+	Err  error
+}
+
+// CircuitBreakerStatus - state of circuit breaker of host and count of consecutive failures
+type CircuitBreakerStatus struct {
+	Host     string
+	State    string
+	Failures int
+}
+
+// OptionBulkConns - reserve connections of cproto binding for bulk queries, e.g. long exports, so their fetches don't delay short requests of the main pool
+// Count - max count of bulk connections, they are connected lazily on demand
+// MinLimit - select queries with limit not less than MinLimit are bulk (0 - only queries with Query.UseDedicatedConn)
+type OptionBulkConns struct {
+	Count    int
+	MinLimit int
+}
+
+// OptionBulkFetchThreshold - select queries, which chunks of results are expected to be not less than Bytes, are bulk (see OptionBulkConns).
+// Size of chunk is estimated at execution of query by its fetch count (or limit) and by average size of items in the previous results of namespace
+type OptionBulkFetchThreshold struct {
+	Bytes int
+}
+
+// OptionResultChecksum - verify each chunk of query results by checksum, which is sent by server. Server must support ServerCapResultChecksum
+type OptionResultChecksum struct {
+	Enable bool
+}
+
+// OptionTLS - connect to server via TLS. If Config.ServerName is empty, host of DSN is used
+type OptionTLS struct {
+	Config *tls.Config
+}
+
+// OptionDialer - dial connections to server by DialContext instead of net.Dialer (e.g. via SOCKS proxy or with custom name resolution).
+// Deadline of ctx is set by login timeout. TLS handshake of OptionTLS is made over the dialed connection
+type OptionDialer struct {
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// OptionTCP - tuning of TCP sockets of cproto connections. KeepAlivePeriod - period of TCP keepalive probes (0 - default, negative - keepalive
+// is disabled). ReadBufSize and WriteBufSize - sizes of kernel socket buffers (0 - default), buffered reader of connection isn't less than ReadBufSize
+type OptionTCP struct {
+	KeepAlivePeriod time.Duration
+	ReadBufSize     int
+	WriteBufSize    int
+}
+
+// OptionDisableObjCache - disable object cache of all the namespaces
+type OptionDisableObjCache struct{}
+
+// OptionDisableRegistrationAudit - disable recording of callers of OpenNamespace and RegisterNamespace (see RegistrationInfo)
+type OptionDisableRegistrationAudit struct{}
+
+// OptionQueryHooks - hooks of select queries for application-level results cache. Key is Query.CacheKey.
+// BeforeExec (optional) is called before query is sent to server, served result is returned instead of the server one.
+// AfterExec (optional) is called with metadata of results, which are received from server.
+// Queries with joins or merges, queries with RequireState and queries of transactions bypass hooks
+type OptionQueryHooks struct {
+	BeforeExec func(key uint64, q QueryDescription) (served bool, result CachedResult)
+	AfterExec  func(key uint64, res ResultSummary)
+}
+
+// QueryDescription - query, which is passed to OptionQueryHooks
+type QueryDescription struct {
+	Namespace string
+	Label     string
+	// JSON is true for queries, which are executed by ExecToJson: CachedResult.JSON is returned instead of Items
+	JSON bool
+}
+
+// CachedResult - result of query, which is served by OptionQueryHooks
+type CachedResult struct {
+	// Items - objects of type of namespace (values or pointers)
+	Items []interface{}
+	// JSON - JSON documents of items
+	JSON       [][]byte
+	TotalCount int
+	// Aggregations - aggregation results in JSON, like in ResultSummary
+	Aggregations [][]byte
+}
+
+// ResultSummary - metadata of results of query, which is executed by server
+type ResultSummary struct {
+	Namespace  string
+	JSON       bool
+	Count      int
+	TotalCount int
+	// Aggregations - aggregation results in JSON, as they are sent by server
+	Aggregations [][]byte
+	Err          error
 }
 
 // AppName - Application name, which will be used in server connect info
@@ -265,10 +592,418 @@ type OptionAppName struct {
 	AppName string
 }
 
+// OptionActivityLabel - derives activity label for requests, which context doesn't have label set by ContextWithActivityLabel
+type OptionActivityLabel struct {
+	LabelFunc func(ctx context.Context) string
+}
+
+// OptionWriteCoalescing - batching of small requests into one socket write. Disabled by default
+// Delay - max time, which the first queued request waits for the subsequent ones
+// MaxPending - requests are written as soon as this amount of bytes is pending (0 - limited only by Delay)
+type OptionWriteCoalescing struct {
+	Delay      time.Duration
+	MaxPending int
+}
+
+// OptionAutoReexecute - re-run query on the healthy connection, if results were lost on reconnect during iteration
+type OptionAutoReexecute struct {
+	AutoReexecute bool
+}
+
+// OptionAsyncWrites - limit of async writes of the client, which are waiting for reply
+// MaxInFlight - max count of waiting writes (0 - default)
+// FailFast - fail new writes instead of blocking, when the limit is reached
+type OptionAsyncWrites struct {
+	MaxInFlight int
+	FailFast    bool
+}
+
+// OptionMaxResultBufferBytes - limit of total size of query results buffers, retained by the client's iterators
+type OptionMaxResultBufferBytes struct {
+	MaxBytes int
+}
+
+// Handling of NaN and Inf values of float fields
+const (
+	// Values are stored as is and rendered as null in JSON output (default)
+	FloatNaNKeep = iota + 1
+	// Values are stored as null
+	FloatNaNNull
+	// Items with such values are rejected
+	FloatNaNError
+)
+
+// FloatFormat - format of float fields
+// Precision - max digits after decimal point in JSON output (0 - not limited)
+// Round - round values to Precision on encode, so stored values are rounded too
+// NaN - handling of NaN and Inf values (0 - FloatNaNKeep)
+type FloatFormat struct {
+	Precision int
+	Round     bool
+	NaN       int
+}
+
+// Override returns format f with the fields, which are set in other
+func (f FloatFormat) Override(other FloatFormat) FloatFormat {
+	if other.Precision != 0 {
+		f.Precision = other.Precision
+	}
+	if other.NaN != 0 {
+		f.NaN = other.NaN
+	}
+	f.Round = f.Round || other.Round
+	return f
+}
+
+// OptionFloatFormat - default format of float fields, which is overridden by 'precision=N', 'round' and 'nan=keep|null|error' options of reindex tag
+type OptionFloatFormat struct {
+	Format FloatFormat
+}
+
+// OptionIntTruncation - truncate stored integer values, which don't fit into type of the field, on decode instead of ErrValueOverflow
+type OptionIntTruncation struct {
+	Truncate bool
+}
+
+// OptionConnectHook - hook, which is called by cproto binding after login of each new or re-established connection, before the connection
+// is used by the other requests. Requests of binding, which are made with ctx of hook, are sent via this connection. Error of hook fails the connection
+type OptionConnectHook struct {
+	OnConnect func(ctx context.Context, binding RawBinding) error
+}
+
+// OptionFailback - probe of the first (preferred) DSN of multi DSN config, when cproto binding is connected to another one.
+// Server of preferred DSN is dialed each ProbeInterval, once it's reachable, connections of the pool are moved to it
+type OptionFailback struct {
+	ProbeInterval time.Duration
+}
+
+// OptionOnNamespaceInvalidated - handler, which is called, when registered namespace disappears from server or is recreated there
+// (e.g. dropped and created again by another client). Reopen - open the namespace again with the registered type
+type OptionOnNamespaceInvalidated struct {
+	Handler func(namespace string, reason error)
+	Reopen  bool
+}
+
+// OptionLogLevel - max level of messages (ERROR, WARNING, INFO or TRACE), which are passed to logger of the client. 0 - no messages
+type OptionLogLevel struct {
+	Level int
+}
+
+// OptionSlowRPC - hook, which is called for each network request, which took at least Threshold (including wait for the free request slot)
+type OptionSlowRPC struct {
+	Threshold time.Duration
+	Hook      func(rpc SlowRPC)
+}
+
+// SlowRPC - info about slow network request
+// Label - activity label of request (see reindexer.CtxWithActivityLabel and Query.Label)
+// QueueWait - time, which request was waiting for the free request slot of connection
+// WriteWait - time, which request frame was waiting in write queue of connection (coalescing and congestion)
+// Duration - total time of request
+type SlowRPC struct {
+	Cmd       int
+	Label     string
+	QueueWait time.Duration
+	WriteWait time.Duration
+	Duration  time.Duration
+	Err       error
+}
+
+// OptionTracer - tracer of network requests. StartSpan is called with context of each request before it waits for the free request slot,
+// returned end (if not nil) is called once, when the request is done. End of async request is called before its completion
+type OptionTracer struct {
+	StartSpan func(ctx context.Context, span RPCSpan) (end func(res RPCSpanEnd))
+}
+
+// RPCSpan - network request, which is traced by OptionTracer
+// Name - name of command, e.g. 'Select' or 'FetchResults'
+// Label - activity label of request (see reindexer.CtxWithActivityLabel and Query.Label)
+// Addr - address of server
+type RPCSpan struct {
+	Cmd   int
+	Name  string
+	Label string
+	Addr  string
+}
+
+// RPCSpanEnd - result of network request, which is traced by OptionTracer
+// RequestSize, ReplySize - sizes of bodies of request and reply frames, as they're sent via network (i.e. compressed ones).
+// Zero - request wasn't sent or reply wasn't received
+type RPCSpanEnd struct {
+	RequestSize int
+	ReplySize   int
+	Err         error
+}
+
+// OptionRequestLog - hook, which is called with each network request, when it's done. Hook is called synchronously by requests
+// (by read loop of connection for async ones), so it must be fast
+type OptionRequestLog struct {
+	Hook func(entry RequestLogEntry)
+}
+
+// RequestLogEntry - network request, which is passed to OptionRequestLog
+// Name - name of command, e.g. 'Select' or 'ModifyItem'
+// Seq - seq number of request in its connection, it is valid only if request was sent (RoundTrip is not zero)
+// Label - activity label of request (see reindexer.CtxWithActivityLabel and Query.Label)
+// QueueWait - time, which request was waiting for the free request slot of connection
+// WriteWait - time, which request frame was waiting in write queue of connection
+// RoundTrip - time since request frame was queued to write until reply (or error). Zero - request wasn't sent
+// RequestSize, ReplySize - sizes of bodies of request and reply frames, as in RPCSpanEnd
+type RequestLogEntry struct {
+	Cmd         int
+	Name        string
+	Seq         uint32
+	Label       string
+	Addr        string
+	QueueWait   time.Duration
+	WriteWait   time.Duration
+	RoundTrip   time.Duration
+	RequestSize int
+	ReplySize   int
+	Err         error
+}
+
+// OptionShutdownReport - report of operations, which are running or have open results (iterators), when client is closed.
+// Close waits up to DrainTimeout (bounded by context of CloseCtx) for them before connections are closed, then Report is called once
+type OptionShutdownReport struct {
+	DrainTimeout time.Duration
+	Report       func(report ShutdownReport)
+}
+
+// Kinds of operations of ShutdownReport
+const (
+	ShutdownOpSelect = "select"
+	ShutdownOpDelete = "delete"
+	ShutdownOpUpdate = "update"
+	ShutdownOpModify = "modify"
+)
+
+// ShutdownReport - operations, which were running or had open results, when client was closed
+// DrainTime - time, which Close was waiting for the operations
+type ShutdownReport struct {
+	Operations []ShutdownOperation
+	DrainTime  time.Duration
+}
+
+// ShutdownOperation - operation of ShutdownReport
+// Kind - ShutdownOpSelect (from execution until Close of its iterator), ShutdownOpDelete, ShutdownOpUpdate or ShutdownOpModify (network request)
+// Label - activity label of operation (see reindexer.CtxWithActivityLabel and Query.Label)
+// Consumed - count of items, which were read from iterator of select
+// Elapsed - time since start of operation until its end or until report
+// Drained - operation was completed before connections were closed (or by graceful close of connections), false - it was aborted by Close
+// Err - error of completed operation
+type ShutdownOperation struct {
+	ID        uint64
+	Kind      string
+	Namespace string
+	Label     string
+	Consumed  int
+	Elapsed   time.Duration
+	Drained   bool
+	Err       error
+}
+
+// OptionDeadlineFloor - requests with deadline, which have less than Floor of remaining time, when they get request slot
+// or when their frames are written to connection, fail with ErrTimeout without sending. Timeout of request, which is sent to server,
+// is decreased by time of waiting in write queue in any case
+type OptionDeadlineFloor struct {
+	Floor time.Duration
+}
+
+// OptionQueueFullError - request of cproto binding, which doesn't get free request slot of connection in MaxQueueWait
+// (0 - if all the slots are busy), fails with ErrQueueFull instead of waiting for the slot, so application can shed the load.
+// Request also prefers another connection of the pool with free slots to the connection in turn, which has no free slots
+type OptionQueueFullError struct {
+	MaxQueueWait time.Duration
+}
+
+// OptionWriteLimit - limits of writes of cproto connection, which server doesn't read.
+// MaxPending - max size in bytes of frames, which are queued to write or are being written (0 - unlimited). Frame, which exceeds it,
+// fails the connection with ErrNetwork (it's connected again by the next request) or, if BlockTimeout is set, waits up to BlockTimeout
+// for the queued frames to be written, then its request fails with ErrQueueFull. Frame is queued to the empty queue whatever its size is.
+// WriteTimeout - max time of each write to socket (0 - unlimited): connection, which write isn't completed in it, fails with timeout error
+type OptionWriteLimit struct {
+	MaxPending   int
+	BlockTimeout time.Duration
+	WriteTimeout time.Duration
+}
+
+// Types of destructive operations, which are checked by destructive guard
+const (
+	DestructiveDropNamespace     = "drop_namespace"
+	DestructiveTruncateNamespace = "truncate_namespace"
+	DestructiveDropIndex         = "drop_index"
+)
+
+// DestructiveOp - destructive operation, which is checked by destructive guard before request is sent
+// Index - name of index for DestructiveDropIndex
+// Ctx - context of the call, e.g. to check confirmation value
+// Implicit - namespace is dropped without explicit call: by OpenNamespace on indexes conflict (see DropOnIndexesConflict)
+// or it may be dropped by server on storage format error (see DropOnFileFormatError)
+type DestructiveOp struct {
+	Type      string
+	Namespace string
+	Index     string
+	Ctx       context.Context
+	Implicit  bool
+}
+
+// OptionDestructiveGuard - guard, which is called before destructive operations. Error of the guard aborts the operation
+type OptionDestructiveGuard struct {
+	Guard func(op DestructiveOp) error
+}
+
+// Kinds of write operations, which are reported to write journal
+const (
+	WriteOpInsert      = "insert"
+	WriteOpUpdate      = "update"
+	WriteOpUpsert      = "upsert"
+	WriteOpDelete      = "delete"
+	WriteOpUpdateQuery = "update_query"
+	WriteOpDeleteQuery = "delete_query"
+	WriteOpCommitTx    = "commit_tx"
+)
+
+// WriteJournalEntry - write operation, which is reported to write journal after its outcome is known
+// PK - values of primary key fields of item (nil, if they can't be derived, e.g. for JSON items and queries)
+// PayloadSize - size of encoded item or query, or total size of items of transaction
+// Count - count of modified items
+// LSN - LSN of modified item, reported by server (0 - unknown)
+type WriteJournalEntry struct {
+	Namespace   string
+	Op          string
+	PK          []interface{}
+	PayloadSize int
+	Precepts    []string
+	Count       int
+	LSN         int64
+	Err         error
+	Duration    time.Duration
+}
+
+// OptionWriteJournal - journal of write operations. Journal is called by background goroutine from bounded queue,
+// entries, which don't fit into the queue, are dropped. System namespaces are excluded, unless IncludeSystem is set
+type OptionWriteJournal struct {
+	Journal       func(entry WriteJournalEntry)
+	QueueSize     int
+	IncludeSystem bool
+}
+
+// Actions of rate limit rule, when the limit is reached
+const (
+	// Wait until request is allowed or context is done
+	RateLimitBlock = iota
+	// Fail request with ErrRateLimited
+	RateLimitError
+)
+
+// Command classes of rate limit rules
+const (
+	// Queries and meta reads
+	CommandClassRead = "read"
+	// Items modifications, update and delete queries, transactions and meta writes
+	CommandClassWrite = "write"
+	// Namespaces and indexes management
+	CommandClassSchema = "schema"
+)
+
+// RateMatch - requests, which rate limit rule is applied to. Empty field matches any value
+type RateMatch struct {
+	Namespace    string
+	CommandClass string
+}
+
+// RateRule - token bucket rate limit of matching requests
+// RPS - allowed requests per second
+// Burst - max count of requests, which may be sent at once (0 - 1)
+// OnLimit - RateLimitBlock or RateLimitError
+type RateRule struct {
+	Match   RateMatch
+	RPS     float64
+	Burst   int
+	OnLimit int
+}
+
+// OptionRateLimit - client-side rate limits of requests. Control commands (ping, login, status) are not limited
+type OptionRateLimit struct {
+	Rules []RateRule
+}
+
 type Status struct {
 	Err     error
 	CProto  StatusCProto
 	Builtin StatusBuiltin
+
+	// Counters of client-side rate limit rules in the order of rules
+	RateLimit []StatusRateLimit
+	// Counters of write journal
+	WriteJournal StatusWriteJournal
+	// Counters of upsert deduplication of all the namespaces
+	UpsertDedup StatusUpsertDedup
+	// Counters of object cache of all the namespaces
+	ObjCache StatusObjCache
+	// Registrations of namespaces, which are sorted by namespaces
+	Registrations []RegistrationInfo
+}
+
+// RegistrationInfo - the last registration of namespace by OpenNamespace or RegisterNamespace of the client
+type RegistrationInfo struct {
+	Namespace string
+	// Type of struct of namespace
+	Type string
+	// Caller of OpenNamespace or RegisterNamespace, which is the nearest one outside of the client. Empty, if it's unknown
+	Package  string
+	File     string
+	Line     int
+	Function string
+	// Options of namespace by names of NamespaceOptions methods, e.g. DropOnIndexesConflict, NoStorage
+	Options []string
+	// Actions of the last OpenNamespace, which migrated namespace on server to the struct, e.g. update of index
+	Actions []string
+	// Namespace is opened by the last OpenNamespace. False for RegisterNamespace
+	Opened bool
+	// Error of the last OpenNamespace
+	Err  error
+	Time time.Time
+	// Registrations with other structs, which were made from other code locations
+	Conflicts int
+}
+
+// StatusUpsertDedup - counters of upsert deduplication, see NamespaceOptions.SkipUnchangedUpserts
+// Skipped - upserts, which were not sent, because items were not changed
+// Entries - PKs of items, which hashes are remembered
+type StatusUpsertDedup struct {
+	Skipped int64
+	Entries int64
+}
+
+// StatusObjCache - counters of object cache
+// Hits - items of results, which were taken from object cache
+// Misses - items of results, which were decoded and stored to object cache
+// Entries - items, which are stored in object cache
+type StatusObjCache struct {
+	Hits    int64
+	Misses  int64
+	Entries int64
+}
+
+// StatusWriteJournal - counters of write journal
+// Delivered - entries, which were passed to journal
+// Dropped - entries, which were dropped because of full queue or closed client
+type StatusWriteJournal struct {
+	Delivered int64
+	Dropped   int64
+}
+
+// StatusRateLimit - counters of rate limit rule
+// Allowed - requests, which were sent without waiting
+// Delayed - requests, which waited for the rule with RateLimitBlock
+// Rejected - requests, which failed by the rule with RateLimitError or by context while waiting
+type StatusRateLimit struct {
+	Rule     RateRule
+	Allowed  int64
+	Delayed  int64
+	Rejected int64
 }
 
 type StatusCProto struct {
@@ -277,6 +1012,72 @@ type StatusCProto struct {
 	ConnQueueSize  int
 	ConnQueueUsage int
 	ConnAddr       string
+	ConnState      string
+	// Host of active DSN and hosts of DSNs, which connections of the pool are bound to, by slots of the pool
+	ActiveHost string
+	ConnHosts  []string
+	// Late and unknown replies, which were discarded by connections, by slots of the pool. Counters are reset by reconnect of slot
+	ConnDiscardedReplies []DiscardedReplies
+	// Utilization of connections for bulk queries, see OptionBulkConns
+	BulkConnPoolSize   int
+	BulkConnPoolUsage  int
+	BulkConnQueueSize  int
+	BulkConnQueueUsage int
+	// Circuit breakers of hosts, which were requested, see OptionCircuitBreaker
+	CircuitBreakers []CircuitBreakerStatus
+
+	// Sizes in bytes of requests and replies, sent and received without compression
+	UncompressedBytes int64
+	// Sizes in bytes of compressed requests and replies before and after compression
+	CompressedSrcBytes int64
+	CompressedBytes    int64
+	// Duplicate and late replies, which were discarded without waiting requests
+	StaleReplies int64
+	// Replies with seq numbers, which were never used by connections: they are discarded and are signs of protocol desync
+	UnknownReplies int64
+	// Server side query results, which close failed on alive connection, so they may stay on server until disconnect
+	LeakedResults int64
+	// Requests, which were sent again after their connection was broken, see OptionRequeueOnConnLoss
+	RequeuedRequests int64
+	// Hedges of select queries, which were sent after hedge delay, hedges, which were answered before the first request,
+	// and losing requests, which were canceled or which replies were dropped (see ContextWithHedge)
+	HedgedRequests int64
+	HedgeWins      int64
+	HedgeWasted    int64
+	// Requests, which failed with ErrQueueFull, see OptionQueueFullError
+	QueueFullRequests int64
+}
+
+// ConnStats - statistics of connection of cproto binding. Counters are reset by reconnect of slot, so they are counted since Connected
+// Slot - slot of connection in the pool or in bulk pool (Bulk)
+// Reconnects - count of reconnects of slot (including the failed ones)
+// InFlight - requests, which are waiting for replies
+// Requests, Errors - requests, which were sent via connection, and the failed ones (including errors of server)
+// SeqWait - total time, which requests were waiting for free request slot of connection (see OptionConnQueueSize)
+// LastRead - time of the last reply (zero - nothing is read)
+// Err - error of failed connection (nil - connection is usable)
+type ConnStats struct {
+	Slot         int
+	Bulk         bool
+	Addr         string
+	Connected    time.Time
+	Reconnects   int64
+	InFlight     int
+	Requests     int64
+	Errors       int64
+	BytesRead    int64
+	BytesWritten int64
+	SeqWait      time.Duration
+	LastRead     time.Time
+	Err          error
+}
+
+// DiscardedReplies - counters of replies, which were discarded by connection
+// Stale - duplicate and late replies of completed requests, e.g. of timed out ones
+// Unknown - replies with seq numbers, which were never used by connection
+type DiscardedReplies struct {
+	Stale   int64
+	Unknown int64
 }
 
 type StatusBuiltin struct {
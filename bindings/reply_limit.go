@@ -0,0 +1,23 @@
+package bindings
+
+import "context"
+
+// ErrReplyLimit is returned, when reply with query results exceeds limit, which was set by ContextWithReplyLimit
+var ErrReplyLimit = NewError("rq: query results buffer limit is exceeded, reduce FetchCount or add Limit", ErrParams)
+
+type replyLimitKey struct{}
+
+// ContextWithReplyLimit returns copy of ctx, which limits size of replies with query results.
+// Network bindings check size of reply before reading it into memory
+func ContextWithReplyLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, replyLimitKey{}, limit)
+}
+
+// ReplyLimit returns limit of reply size from ctx or 0, if replies are not limited
+func ReplyLimit(ctx context.Context) int64 {
+	if ctx == nil {
+		return 0
+	}
+	limit, _ := ctx.Value(replyLimitKey{}).(int64)
+	return limit
+}
@@ -0,0 +1,39 @@
+package bindings
+
+import (
+	"context"
+	"hash/crc32"
+)
+
+// Modes of results checksum verification
+const (
+	// ResultChecksumDefault - results are verified according to OptionResultChecksum
+	ResultChecksumDefault = iota
+	// ResultChecksumDisabled - results are not verified
+	ResultChecksumDisabled
+	// ResultChecksumEnabled - each chunk of results is verified by checksum, which is sent by server
+	ResultChecksumEnabled
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ResultsChecksum returns CRC-32C of results chunk, which server sends with ResultsWithChecksum flag
+func ResultsChecksum(chunk []byte) uint32 {
+	return crc32.Checksum(chunk, crc32cTable)
+}
+
+type resultChecksumKey struct{}
+
+// ContextWithResultChecksum returns copy of ctx with checksum verification mode of query results
+func ContextWithResultChecksum(ctx context.Context, mode int) context.Context {
+	return context.WithValue(ctx, resultChecksumKey{}, mode)
+}
+
+// ResultChecksum returns checksum verification mode from ctx or ResultChecksumDefault
+func ResultChecksum(ctx context.Context) int {
+	if ctx == nil {
+		return ResultChecksumDefault
+	}
+	mode, _ := ctx.Value(resultChecksumKey{}).(int)
+	return mode
+}
@@ -0,0 +1,20 @@
+package bindings
+
+import "context"
+
+type writeLaneKey struct{}
+
+// ContextWithWriteLane returns copy of ctx, which routes write to the connection of lane: writes of the same lane are sent
+// via the same connection of the pool, while it's usable, so the server applies them in order of sending
+func ContextWithWriteLane(ctx context.Context, lane int) context.Context {
+	return context.WithValue(ctx, writeLaneKey{}, lane)
+}
+
+// WriteLane returns lane of write from ctx or false, if write is not routed by lane
+func WriteLane(ctx context.Context) (int, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	lane, ok := ctx.Value(writeLaneKey{}).(int)
+	return lane, ok
+}
@@ -0,0 +1,225 @@
+package cjson
+
+import "unsafe"
+
+const (
+	// ArenaBlockSize - size in bytes of blocks of arena
+	ArenaBlockSize = 64 * 1024
+	// arenaMaxSlice - slices of more elements are allocated separately
+	arenaMaxSlice = 64
+	// arenaMaxString - strings of more bytes are allocated separately
+	arenaMaxString = ArenaBlockSize / 4
+	// arenaRetainedBlocks - max count of blocks of each kind, which are kept by Reset for reuse
+	arenaRetainedBlocks = 16
+	// arenaPoisonByte - value, which memory of arena is filled with by Reset in debug mode (build tag reindexer_arena_debug)
+	arenaPoisonByte = 0xDD
+	// ArenaPoisonString - value, which strings of slices of arena are replaced with by Reset in debug mode
+	ArenaPoisonString = "<released by arena>"
+)
+
+// Arena - blocks of memory, which strings and small slices of decoded objects are placed into instead of separate allocations.
+// Values are valid until Reset, then their memory is reused. Arena isn't safe for concurrent use.
+// Methods of nil arena allocate each value separately
+type Arena struct {
+	bytes arenaBlocks
+	// strings are kept separately, so they are scanned by GC
+	strs  [][]string
+	free  [][]string
+	curSt []string
+	// 8-byte values without pointers: int, int64, float64
+	words arenaWords
+	// blocks, which were allocated by arena
+	allocs int
+}
+
+type arenaBlocks struct {
+	used [][]byte
+	free [][]byte
+	cur  []byte
+}
+
+type arenaWords struct {
+	used [][]uint64
+	free [][]uint64
+	cur  []uint64
+}
+
+// NewArena returns empty arena
+func NewArena() *Arena {
+	return &Arena{}
+}
+
+// Blocks returns count of blocks, which were allocated by arena
+func (a *Arena) Blocks() int {
+	if a == nil {
+		return 0
+	}
+	return a.allocs
+}
+
+// String returns copy of b, which is placed into arena
+func (a *Arena) String(b []byte) string {
+	if a == nil || len(b) > arenaMaxString {
+		return string(b)
+	}
+	if len(b) == 0 {
+		return ""
+	}
+	bl := &a.bytes
+	if len(bl.cur)+len(b) > cap(bl.cur) {
+		if bl.cur != nil {
+			bl.used = append(bl.used, bl.cur)
+		}
+		if n := len(bl.free); n > 0 {
+			bl.cur, bl.free = bl.free[n-1][:0], bl.free[:n-1]
+		} else {
+			bl.cur = make([]byte, 0, ArenaBlockSize)
+			a.allocs++
+		}
+	}
+	off := len(bl.cur)
+	bl.cur = append(bl.cur, b...)
+	s := bl.cur[off:len(bl.cur):len(bl.cur)]
+	return *(*string)(unsafe.Pointer(&s))
+}
+
+// Strings returns slice of n strings, which is placed into arena
+func (a *Arena) Strings(n int) []string {
+	if a == nil || n > arenaMaxSlice {
+		return make([]string, n)
+	}
+	if len(a.curSt)+n > cap(a.curSt) {
+		if a.curSt != nil {
+			a.strs = append(a.strs, a.curSt)
+		}
+		if l := len(a.free); l > 0 {
+			a.curSt, a.free = a.free[l-1][:0], a.free[:l-1]
+		} else {
+			a.curSt = make([]string, 0, ArenaBlockSize/16)
+			a.allocs++
+		}
+	}
+	off := len(a.curSt)
+	a.curSt = a.curSt[:off+n]
+	return a.curSt[off : off+n : off+n]
+}
+
+// wordsSlice returns slice of n zero 8-byte values, which is placed into arena
+func (a *Arena) wordsSlice(n int) []uint64 {
+	w := &a.words
+	if len(w.cur)+n > cap(w.cur) {
+		if w.cur != nil {
+			w.used = append(w.used, w.cur)
+		}
+		if l := len(w.free); l > 0 {
+			w.cur, w.free = w.free[l-1][:0], w.free[:l-1]
+		} else {
+			w.cur = make([]uint64, 0, ArenaBlockSize/8)
+			a.allocs++
+		}
+	}
+	off := len(w.cur)
+	w.cur = w.cur[:off+n]
+	s := w.cur[off : off+n : off+n]
+	for i := range s {
+		s[i] = 0
+	}
+	return s
+}
+
+// Ints returns slice of n ints, which is placed into arena
+func (a *Arena) Ints(n int) []int {
+	if a == nil || n > arenaMaxSlice || unsafe.Sizeof(int(0)) != 8 {
+		return make([]int, n)
+	}
+	s := a.wordsSlice(n)
+	return *(*[]int)(unsafe.Pointer(&s))
+}
+
+// Int64s returns slice of n int64s, which is placed into arena
+func (a *Arena) Int64s(n int) []int64 {
+	if a == nil || n > arenaMaxSlice {
+		return make([]int64, n)
+	}
+	s := a.wordsSlice(n)
+	return *(*[]int64)(unsafe.Pointer(&s))
+}
+
+// Float64s returns slice of n float64s, which is placed into arena
+func (a *Arena) Float64s(n int) []float64 {
+	if a == nil || n > arenaMaxSlice {
+		return make([]float64, n)
+	}
+	s := a.wordsSlice(n)
+	return *(*[]float64)(unsafe.Pointer(&s))
+}
+
+// readVString reads string from serializer into arena
+func (a *Arena) readVString(rdser *Serializer) string {
+	if a == nil {
+		return rdser.GetVString()
+	}
+	return a.String(rdser.GetVBytes())
+}
+
+// Reset makes memory of arena reusable. Values, which were placed into arena, must not be used after Reset.
+// In debug mode (build tag reindexer_arena_debug) memory is poisoned, so such use is visible
+func (a *Arena) Reset() {
+	if a == nil {
+		return
+	}
+	bl := &a.bytes
+	if bl.cur != nil {
+		bl.used = append(bl.used, bl.cur)
+		bl.cur = nil
+	}
+	for _, b := range bl.used {
+		if arenaPoison {
+			b = b[:cap(b)]
+			for i := range b {
+				b[i] = arenaPoisonByte
+			}
+		}
+		if len(bl.free) < arenaRetainedBlocks {
+			bl.free = append(bl.free, b)
+		}
+	}
+	bl.used = bl.used[:0]
+
+	if a.curSt != nil {
+		a.strs = append(a.strs, a.curSt)
+		a.curSt = nil
+	}
+	for _, s := range a.strs {
+		// Strings are cleared, so they don't retain memory, which is allocated separately
+		fill := ""
+		if arenaPoison {
+			fill = ArenaPoisonString
+		}
+		for i := range s {
+			s[i] = fill
+		}
+		if len(a.free) < arenaRetainedBlocks {
+			a.free = append(a.free, s)
+		}
+	}
+	a.strs = a.strs[:0]
+
+	w := &a.words
+	if w.cur != nil {
+		w.used = append(w.used, w.cur)
+		w.cur = nil
+	}
+	for _, s := range w.used {
+		if arenaPoison {
+			s = s[:cap(s)]
+			for i := range s {
+				s[i] = 0xDDDDDDDDDDDDDDDD
+			}
+		}
+		if len(w.free) < arenaRetainedBlocks {
+			w.free = append(w.free, s)
+		}
+	}
+	w.used = w.used[:0]
+}
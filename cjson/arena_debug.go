@@ -0,0 +1,6 @@
+// +build reindexer_arena_debug
+
+package cjson
+
+// arenaPoison - Arena.Reset poisons released memory
+const arenaPoison = true
@@ -0,0 +1,284 @@
+package cjson
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ColumnsWriter receives values of the requested fields of item. col is index of the field in the list of the requested fields
+type ColumnsWriter interface {
+	PutInt(col int, v int64)
+	PutFloat(col int, v float64)
+	PutString(col int, v string)
+	PutBool(col int, v bool)
+}
+
+// ColumnsDecoder extracts values of scalar fields from items by JSON paths without decoding of the whole items.
+// It's not safe for concurrent use
+type ColumnsDecoder struct {
+	paths []string
+	// tree of tags paths of the requested fields, built for tags matcher of state
+	state    *StateData
+	tagsLen  int
+	root     *columnsNode
+	fieldcnt []int
+}
+
+type columnsNode struct {
+	children map[int]*columnsNode
+	// index of the requested field (-1 - intermediate object) and its JSON path
+	col  int
+	path string
+}
+
+// NewColumnsDecoder creates decoder of the fields with JSON paths paths
+func NewColumnsDecoder(paths []string) *ColumnsDecoder {
+	return &ColumnsDecoder{paths: paths, fieldcnt: make([]int, 64, 64)}
+}
+
+// FieldTypeByPath returns type of the field of struct t by JSON path (nested fields are separated by '.')
+func FieldTypeByPath(t reflect.Type, path string) (reflect.Type, bool) {
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, false
+		}
+		sf, ok := fieldByTag(t, name)
+		if !ok {
+			return nil, false
+		}
+		t = sf.Type
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, true
+}
+
+// FieldValueByPath returns value of the field of struct v by JSON path (nested fields are separated by '.').
+// Returns false, if there is no such field or nil pointer is met on the path
+func FieldValueByPath(v reflect.Value, path string) (reflect.Value, bool) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		sf, ok := fieldByTag(v.Type(), name)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		if v, ok = fieldByIndex(v, sf.Index); !ok {
+			return reflect.Value{}, false
+		}
+	}
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex, which doesn't panic on nil embedded pointers
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 {
+			for v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					return reflect.Value{}, false
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// ZeroFieldByPath sets the field of addressable struct v by JSON path to zero value. Pointers on the path are not followed,
+// so the field of shallow copy of struct is changed without change of the original. Missing field is not encoded, so it's not an error.
+// Returns false, if the field is behind pointer
+func ZeroFieldByPath(v reflect.Value, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return false
+		}
+		sf, ok := fieldByTag(v.Type(), name)
+		if !ok {
+			return true
+		}
+		for _, x := range sf.Index {
+			if v.Kind() != reflect.Struct {
+				return false
+			}
+			v = v.Field(x)
+		}
+	}
+	if !v.CanSet() {
+		return false
+	}
+	v.Set(reflect.Zero(v.Type()))
+	return true
+}
+
+// build rebuilds tree of tags paths, if tags matcher of state is changed. Must be called under state lock
+func (cd *ColumnsDecoder) build(state *State) {
+	tm := &state.tagsMatcher
+	if cd.state == state.StateData && cd.tagsLen == len(tm.Tags) {
+		return
+	}
+	cd.state, cd.tagsLen = state.StateData, len(tm.Tags)
+	cd.root = &columnsNode{col: -1}
+	for col, path := range cd.paths {
+		node := cd.root
+		for _, name := range strings.Split(path, ".") {
+			tag := tm.name2tag(name, false)
+			if tag == 0 {
+				// Field is not present in any item
+				node = nil
+				break
+			}
+			if node.children == nil {
+				node.children = make(map[int]*columnsNode)
+			}
+			child, ok := node.children[tag]
+			if !ok {
+				child = &columnsNode{col: -1}
+				node.children[tag] = child
+			}
+			node = child
+		}
+		if node != nil {
+			node.col, node.path = col, path
+		}
+	}
+}
+
+// Decode passes values of the requested fields of item to w. Item is either payload of builtin binding (cptr) or cjson data
+func (cd *ColumnsDecoder) Decode(state *State, cptr uintptr, data []byte, w ColumnsWriter) (err error) {
+	state.lock.RLock()
+	defer state.lock.RUnlock()
+	cd.build(state)
+
+	var pl *payloadIface
+	if cptr != 0 {
+		pl = &payloadIface{p: cptr, t: &state.payloadType}
+		data = pl.getBytes(0, 0)
+	}
+	ser := &Serializer{buf: data}
+
+	defer func() {
+		if ret := recover(); ret != nil {
+			if e, ok := ret.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("cjson: Can't decode columns: %v\nData dump:\n%s", ret, hex.Dump(data))
+			}
+		}
+	}()
+
+	for i := range cd.fieldcnt {
+		cd.fieldcnt[i] = 0
+	}
+	dec := Decoder{state: state}
+	tag := ctag(ser.GetVarUInt())
+	if tag.Type() != TAG_OBJECT {
+		return fmt.Errorf("cjson: Item is not an object: %s", tag.Dump())
+	}
+	cd.decodeObject(&dec, pl, ser, cd.root, w)
+	return nil
+}
+
+func (cd *ColumnsDecoder) decodeObject(dec *Decoder, pl *payloadIface, rdser *Serializer, node *columnsNode, w ColumnsWriter) {
+	for {
+		tag := ctag(rdser.GetVarUInt())
+		if tag.Type() == TAG_END {
+			return
+		}
+		child := node.children[tag.Name()]
+		if child == nil {
+			dec.skipStruct(pl, rdser, cd.fieldcnt, tag)
+			continue
+		}
+		cd.decodeField(dec, pl, rdser, child, tag, w)
+	}
+}
+
+func (cd *ColumnsDecoder) decodeField(dec *Decoder, pl *payloadIface, rdser *Serializer, node *columnsNode, tag ctag, w ColumnsWriter) {
+	tagType := tag.Type()
+	if field := tag.Field(); field >= 0 {
+		// indexed value from payload
+		cnt := &cd.fieldcnt[field]
+		if tagType == TAG_ARRAY {
+			count := int(rdser.GetVarUInt())
+			if node.col >= 0 && count != 0 {
+				panic(fmt.Errorf("cjson: Field '%s' is an array, only scalar fields can be decoded to columns", node.path))
+			}
+			*cnt += count
+			return
+		}
+		if node.col >= 0 {
+			cd.putPayloadValue(pl, field, *cnt, node.col, w)
+		}
+		(*cnt)++
+		return
+	}
+
+	switch tagType {
+	case TAG_OBJECT:
+		if node.children == nil {
+			panic(fmt.Errorf("cjson: Field '%s' is an object, only scalar fields can be decoded to columns", node.path))
+		}
+		cd.decodeObject(dec, pl, rdser, node, w)
+	case TAG_ARRAY:
+		if node.col >= 0 {
+			panic(fmt.Errorf("cjson: Field '%s' is an array, only scalar fields can be decoded to columns", node.path))
+		}
+		dec.skipStruct(pl, rdser, cd.fieldcnt, tag)
+	case TAG_NULL:
+	default:
+		if node.col < 0 {
+			skipTag(rdser, tagType)
+			return
+		}
+		switch tagType {
+		case TAG_VARINT:
+			w.PutInt(node.col, rdser.GetVarInt())
+		case TAG_DOUBLE:
+			w.PutFloat(node.col, rdser.GetDouble())
+		case TAG_BOOL:
+			w.PutBool(node.col, rdser.GetVarUInt() != 0)
+		case TAG_STRING:
+			w.PutString(node.col, rdser.GetVString())
+		default:
+			panic(fmt.Errorf("cjson: Invalid tag type %s of field '%s'", tagTypeName(tagType), node.path))
+		}
+	}
+}
+
+func (cd *ColumnsDecoder) putPayloadValue(pl *payloadIface, field, idx, col int, w ColumnsWriter) {
+	switch pl.t.Fields[field].Type {
+	case valueBool:
+		w.PutBool(col, pl.getBool(field, idx))
+	case valueInt:
+		w.PutInt(col, int64(pl.getInt(field, idx)))
+	case valueInt64:
+		w.PutInt(col, pl.getInt64(field, idx))
+	case valueDouble:
+		w.PutFloat(col, pl.getFloat64(field, idx))
+	case valueString:
+		w.PutString(col, pl.getString(field, idx))
+	default:
+		panic(fmt.Errorf("Unknown key value type %d", pl.t.Fields[field].Type))
+	}
+}
@@ -70,6 +70,26 @@ func (pt *payloadType) Read(ser *Serializer, skip bool) {
 type payloadIface struct {
 	p uintptr
 	t *payloadType
+	// truncate integer values, which don't fit into type of the field, instead of ErrValueOverflow
+	truncateInts bool
+	// strings and small slices are placed into arena, if it's set
+	arena *Arena
+}
+
+// checkInt panics with ErrValueOverflow, if value v of indexed field doesn't fit into integer kind k
+func (pl *payloadIface) checkInt(field int, v int64, k reflect.Kind) int64 {
+	if !pl.truncateInts && intOverflows(v, k) {
+		panic(ErrValueOverflow{Field: pl.t.Fields[field].Name, Value: v, Type: k.String()})
+	}
+	return v
+}
+
+// elemKind returns kind of slice elements, dereferencing pointer elements
+func elemKind(t reflect.Type) reflect.Kind {
+	if t = t.Elem(); t.Kind() == reflect.Ptr {
+		return t.Elem().Kind()
+	}
+	return t.Kind()
 }
 
 // direct c reindexer payload manipulation
@@ -133,7 +153,7 @@ func (pl *payloadIface) getBytes(field, idx int) []byte {
 }
 
 func (pl *payloadIface) getString(field, idx int) string {
-	return string(pl.getBytes(field, idx))
+	return pl.arena.String(pl.getBytes(field, idx))
 }
 
 func (pl *payloadIface) getArrayLen(field int) int {
@@ -148,28 +168,59 @@ func (pl *payloadIface) getArrayLen(field int) int {
 	return int((*ArrayHeader)(p).len)
 }
 
+// getScalarIface returns single element of field as go value of the same type, as decoder uses
+// for tuple values of interface{} fields
+func (pl *payloadIface) getScalarIface(field, idx int) interface{} {
+	switch pl.t.Fields[field].Type {
+	case valueBool:
+		return pl.getBool(field, idx)
+	case valueInt:
+		return pl.getInt(field, idx)
+	case valueInt64:
+		v := pl.getInt64(field, idx)
+		if v < int64(minInt) || v > int64(maxInt) {
+			return v
+		}
+		return int(v)
+	case valueDouble:
+		return pl.getFloat64(field, idx)
+	case valueString:
+		return pl.getString(field, idx)
+	default:
+		panic(fmt.Errorf("Unknown key value type %d", pl.t.Fields[field].Type))
+	}
+}
+
 // get c reflect value and set to go reflect valie
 func (pl *payloadIface) getValue(field int, idx int, v reflect.Value) {
 
 	k := v.Type().Kind()
+	if k == reflect.Interface {
+		// indexed field inside of schemaless object
+		v.Set(reflect.ValueOf(pl.getScalarIface(field, idx)))
+		return
+	}
 	switch pl.t.Fields[field].Type {
 	case valueBool:
 		v.SetBool(pl.getBool(field, idx))
 	case valueInt:
 		switch k {
 		case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int8:
-			v.SetInt(int64(pl.getInt(field, idx)))
-		case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint8:
-			v.SetUint(uint64(pl.getInt(field, idx)))
+			v.SetInt(pl.checkInt(field, int64(pl.getInt(field, idx)), k))
+		case reflect.Uint32:
+			// int index keeps bits of uint32 values
+			v.SetUint(uint64(uint32(pl.getInt(field, idx))))
+		case reflect.Uint, reflect.Uint16, reflect.Uint64, reflect.Uint8:
+			v.SetUint(uint64(pl.checkInt(field, int64(pl.getInt(field, idx)), k)))
 		default:
 			panic(fmt.Errorf("Can't set int to %s", k.String()))
 		}
 	case valueInt64:
 		switch k {
 		case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Int8:
-			v.SetInt(int64(pl.getInt64(field, idx)))
+			v.SetInt(pl.checkInt(field, pl.getInt64(field, idx), k))
 		case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uint8:
-			v.SetUint(uint64(pl.getInt64(field, idx)))
+			v.SetUint(uint64(pl.checkInt(field, pl.getInt64(field, idx), k)))
 		default:
 			panic(fmt.Errorf("Can't set int to %s", k.String()))
 		}
@@ -188,6 +239,16 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 		return
 	}
 
+	if v.Kind() == reflect.Interface || v.Type().Elem().Kind() == reflect.Interface {
+		// indexed array inside of schemaless object
+		a := make([]interface{}, cnt, cnt)
+		for i := 0; i < cnt; i++ {
+			a[i] = pl.getScalarIface(field, i+startIdx)
+		}
+		v.Set(reflect.ValueOf(a).Convert(v.Type()))
+		return
+	}
+
 	ptr := pl.ptr(field, startIdx, pl.t.Fields[field].Type)
 	l := pl.getArrayLen(field) - startIdx
 
@@ -197,7 +258,7 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 		pu := (*[1 << 27]Cunsigned)(ptr)[:l:l]
 		switch a := v.Addr().Interface().(type) {
 		case *[]int:
-			*a = make([]int, cnt, cnt)
+			*a = pl.arena.Ints(cnt)
 			for i := 0; i < cnt; i++ {
 				(*a)[i] = int(pi[i])
 			}
@@ -209,12 +270,12 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 		case *[]int16:
 			*a = make([]int16, cnt, cnt)
 			for i := 0; i < cnt; i++ {
-				(*a)[i] = int16(pi[i])
+				(*a)[i] = int16(pl.checkInt(field, int64(pi[i]), reflect.Int16))
 			}
 		case *[]uint16:
 			*a = make([]uint16, cnt, cnt)
 			for i := 0; i < cnt; i++ {
-				(*a)[i] = uint16(pu[i])
+				(*a)[i] = uint16(pl.checkInt(field, int64(pi[i]), reflect.Uint16))
 			}
 		case *[]int32:
 			*a = make([]int32, cnt, cnt)
@@ -229,12 +290,12 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 		case *[]int8:
 			*a = make([]int8, cnt, cnt)
 			for i := 0; i < cnt; i++ {
-				(*a)[i] = int8(pi[i])
+				(*a)[i] = int8(pl.checkInt(field, int64(pi[i]), reflect.Int8))
 			}
 		case *[]uint8:
 			*a = make([]uint8, cnt, cnt)
 			for i := 0; i < cnt; i++ {
-				(*a)[i] = uint8(pu[i])
+				(*a)[i] = uint8(pl.checkInt(field, int64(pi[i]), reflect.Uint8))
 			}
 		case *[]bool:
 			*a = make([]bool, cnt, cnt)
@@ -243,16 +304,20 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 			}
 		default:
 			slice := reflect.MakeSlice(v.Type(), cnt, cnt)
-			switch v.Type().Elem().Kind() {
+			switch ek := elemKind(v.Type()); ek {
 			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 				for i := 0; i < cnt; i++ {
+					u := uint64(pu[i])
+					if ek == reflect.Uint8 || ek == reflect.Uint16 {
+						u = uint64(pl.checkInt(field, int64(pi[i]), ek))
+					}
 					sv := slice.Index(i)
 					if sv.Type().Kind() == reflect.Ptr {
 						el := reflect.New(reflect.New(sv.Type().Elem()).Elem().Type())
-						el.Elem().SetUint(uint64(pu[i]))
+						el.Elem().SetUint(u)
 						sv.Set(el)
 					} else {
-						sv.SetUint(uint64(pu[i]))
+						sv.SetUint(u)
 					}
 				}
 			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
@@ -260,10 +325,10 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 					sv := slice.Index(i)
 					if sv.Type().Kind() == reflect.Ptr {
 						el := reflect.New(reflect.New(sv.Type().Elem()).Elem().Type())
-						el.Elem().SetInt(int64(pi[i]))
+						el.Elem().SetInt(pl.checkInt(field, int64(pi[i]), ek))
 						sv.Set(el)
 					} else {
-						sv.SetInt(int64(pi[i]))
+						sv.SetInt(pl.checkInt(field, int64(pi[i]), ek))
 					}
 				}
 			default:
@@ -275,7 +340,7 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 		switch a := v.Addr().Interface().(type) {
 		case *[]int64:
 			pi := (*[1 << 27]int64)(ptr)[:l:l]
-			*a = make([]int64, cnt, cnt)
+			*a = pl.arena.Int64s(cnt)
 			copy(*a, pi)
 		case *[]uint64:
 			pi := (*[1 << 27]uint64)(ptr)[:l:l]
@@ -295,29 +360,28 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 			}
 		default:
 			slice := reflect.MakeSlice(v.Type(), cnt, cnt)
-			switch v.Type().Elem().Kind() {
+			pi := (*[1 << 27]int64)(ptr)[:l:l]
+			switch ek := elemKind(v.Type()); ek {
 			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-				pi := (*[1 << 27]uint64)(ptr)[:l:l]
 				for i := 0; i < cnt; i++ {
 					sv := slice.Index(i)
 					if sv.Type().Kind() == reflect.Ptr {
 						el := reflect.New(reflect.New(sv.Type().Elem()).Elem().Type())
-						el.Elem().SetUint(uint64(pi[i]))
+						el.Elem().SetUint(uint64(pl.checkInt(field, pi[i], ek)))
 						sv.Set(el)
 					} else {
-						sv.SetUint(uint64(pi[i]))
+						sv.SetUint(uint64(pl.checkInt(field, pi[i], ek)))
 					}
 				}
 			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				pi := (*[1 << 27]int64)(ptr)[:l:l]
 				for i := 0; i < cnt; i++ {
 					sv := slice.Index(i)
 					if sv.Type().Kind() == reflect.Ptr {
 						el := reflect.New(reflect.New(sv.Type().Elem()).Elem().Type())
-						el.Elem().SetInt(int64(pi[i]))
+						el.Elem().SetInt(pl.checkInt(field, pi[i], ek))
 						sv.Set(el)
 					} else {
-						sv.SetInt(int64(pi[i]))
+						sv.SetInt(pl.checkInt(field, pi[i], ek))
 					}
 				}
 			default:
@@ -329,7 +393,7 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 		pi := (*[1 << 27]Cdouble)(ptr)[:l:l]
 		switch a := v.Addr().Interface().(type) {
 		case *[]float64:
-			*a = make([]float64, cnt, cnt)
+			*a = pl.arena.Float64s(cnt)
 			for i := 0; i < cnt; i++ {
 				(*a)[i] = float64(pi[i])
 			}
@@ -376,7 +440,7 @@ func (pl *payloadIface) getArray(field int, startIdx int, cnt int, v reflect.Val
 		}
 	case valueString:
 		if a, ok := v.Addr().Interface().(*[]string); ok {
-			*a = make([]string, cnt, cnt)
+			*a = pl.arena.Strings(cnt)
 			for i := 0; i < cnt; i++ {
 				(*a)[i] = pl.getString(field, i+startIdx)
 			}
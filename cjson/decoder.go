@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 	"unsafe"
+
+	"github.com/restream/reindexer/bindings"
 )
 
 var (
@@ -24,6 +27,50 @@ type Decoder struct {
 	state      *State
 	ctagsCache *ctagsCache
 	logger     Logger
+	// truncate integer values, which don't fit into type of the field, instead of ErrValueOverflow
+	truncateInts bool
+	// fields, which are present in decoded document. nil - presence is not tracked
+	presence FieldsPresence
+	// handling of fields, which are not in the struct (UnknownFieldsDrop, ...)
+	unknownFields int
+	// strings and small slices are placed into arena, if it's set
+	arena *Arena
+}
+
+// FieldsPresence - JSON paths of top-level and one-level-nested fields, which are present in decoded document (e.g. "price", "info.price").
+// Value is true for fields with null value. Field of objects in array is present, if it's present in some of the objects, and is null, if it's null in all of them.
+// Nested fields of objects, which are not decoded to the destination struct, are not tracked
+type FieldsPresence map[string]bool
+
+// Has returns true, if field is present in document, including null fields
+func (p FieldsPresence) Has(path string) bool {
+	_, ok := p[path]
+	return ok
+}
+
+// IsNull returns true, if field is present in document with null value
+func (p FieldsPresence) IsNull(path string) bool {
+	return p[path]
+}
+
+// maxPresenceDepth - nesting depth of fields, which presence is tracked
+const maxPresenceDepth = 2
+
+// ErrValueOverflow is returned by decoder, when stored value doesn't fit into integer type of the field
+type ErrValueOverflow struct {
+	// JSON path of the field or name of index for indexed fields
+	Field string
+	Value int64
+	// Go type of the field
+	Type string
+}
+
+func (e ErrValueOverflow) Error() string {
+	return fmt.Sprintf("cjson: Value %d of field '%s' overflows %s", e.Value, e.Field, e.Type)
+}
+
+func (e ErrValueOverflow) Code() int {
+	return bindings.ErrParams
 }
 
 func fieldByTag(t reflect.Type, tag string) (result reflect.StructField, ok bool) {
@@ -32,6 +79,9 @@ func fieldByTag(t reflect.Type, tag string) (result reflect.StructField, ok bool
 	}
 	for i := 0; i < t.NumField(); i++ {
 		result = t.Field(i)
+		if isUnknownFieldsField(result) {
+			continue
+		}
 		if ftag := result.Tag.Get("json"); len(ftag) > 0 {
 			ftag, _, _ = splitStr(ftag, ',')
 			if tag == ftag {
@@ -132,6 +182,68 @@ func asInt(rdser *Serializer, tagType int) int64 {
 	}
 }
 
+// intOverflows returns true, if v doesn't fit into integer kind k. uint and uint64 values are stored as int64 bits, so they never overflow
+func intOverflows(v int64, k reflect.Kind) bool {
+	switch k {
+	case reflect.Int:
+		return v != int64(int(v))
+	case reflect.Int8:
+		return v != int64(int8(v))
+	case reflect.Int16:
+		return v != int64(int16(v))
+	case reflect.Int32:
+		return v != int64(int32(v))
+	case reflect.Uint8:
+		return v != int64(uint8(v))
+	case reflect.Uint16:
+		return v != int64(uint16(v))
+	case reflect.Uint32:
+		return v != int64(uint32(v))
+	}
+	return false
+}
+
+// SetIntTruncation makes decoder to truncate integer values, which don't fit into type of the field, instead of ErrValueOverflow
+func (dec *Decoder) SetIntTruncation(truncate bool) {
+	dec.truncateInts = truncate
+}
+
+// SetArena makes decoder place strings and small slices of decoded object into arena, which must outlive the object. nil - disables arena
+func (dec *Decoder) SetArena(arena *Arena) {
+	dec.arena = arena
+}
+
+// checkInt panics with ErrValueOverflow, if v doesn't fit into integer kind k of the field with tags path cctagsPath
+// TrackFieldsPresence makes decoder fill presence by fields of the decoded document
+func (dec *Decoder) TrackFieldsPresence(presence FieldsPresence) {
+	dec.presence = presence
+}
+
+// markPresent adds field with tag name, which is nested to cctagsPath, to presence
+func (dec *Decoder) markPresent(cctagsPath []int, name int, null bool) {
+	if name == 0 || len(cctagsPath) >= maxPresenceDepth {
+		return
+	}
+	path := dec.state.tagsMatcher.tag2name(name)
+	if len(cctagsPath) != 0 {
+		path = dec.state.tagsMatcher.tag2name(cctagsPath[0]) + "." + path
+	}
+	if _, ok := dec.presence[path]; !ok || !null {
+		dec.presence[path] = null
+	}
+}
+
+func (dec *Decoder) checkInt(v int64, k reflect.Kind, cctagsPath []int) int64 {
+	if !dec.truncateInts && intOverflows(v, k) {
+		names := make([]string, 0, len(cctagsPath))
+		for _, tag := range cctagsPath {
+			names = append(names, dec.state.tagsMatcher.tag2name(tag))
+		}
+		panic(ErrValueOverflow{Field: strings.Join(names, "."), Value: v, Type: k.String()})
+	}
+	return v
+}
+
 func asFloat(rdser *Serializer, tagType int) float64 {
 	switch tagType {
 	case TAG_VARINT:
@@ -143,10 +255,10 @@ func asFloat(rdser *Serializer, tagType int) float64 {
 	}
 }
 
-func asString(rdser *Serializer, tagType int) string {
+func asString(rdser *Serializer, tagType int, arena *Arena) string {
 	switch tagType {
 	case TAG_STRING:
-		return rdser.GetVString()
+		return arena.readVString(rdser)
 	default:
 		panic(fmt.Errorf("Can't convert tagType %s to string", tagTypeName(tagType)))
 	}
@@ -177,14 +289,14 @@ func asIface(rdser *Serializer, tagType int) interface{} {
 	}
 }
 
-func mkSlice(v *reflect.Value, count int) {
+func mkSlice(v *reflect.Value, count int, arena *Arena) {
 	switch a := v.Addr().Interface().(type) {
 	case *[]string:
-		*a = make([]string, count, count)
+		*a = arena.Strings(count)
 	case *[]int:
-		*a = make([]int, count, count)
+		*a = arena.Ints(count)
 	case *[]int64:
-		*a = make([]int64, count, count)
+		*a = arena.Int64s(count)
 	case *[]int32:
 		*a = make([]int32, count, count)
 	case *[]int16:
@@ -202,7 +314,7 @@ func mkSlice(v *reflect.Value, count int) {
 	case *[]uint8:
 		*a = make([]uint8, count, count)
 	case *[]float64:
-		*a = make([]float64, count, count)
+		*a = arena.Float64s(count)
 	case *[]float32:
 		*a = make([]float32, count, count)
 	case *[]bool:
@@ -243,12 +355,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 	k := v.Kind()
 	switch k {
 	case reflect.Slice:
-		mkSlice(v, count)
+		mkSlice(v, count, dec.arena)
 		ptr = unsafe.Pointer(v.Pointer())
 	case reflect.Interface:
 		origV = *v
 		*v = reflect.ValueOf(reflect.New(ifaceSliceType).Interface()).Elem()
-		mkSlice(v, count)
+		mkSlice(v, count, dec.arena)
 		ptr = unsafe.Pointer(v.Pointer())
 	case reflect.Array:
 		if v.Len() < count {
@@ -271,12 +383,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 28]int)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = int(asInt(rdser, subtag))
+					sl[i] = int(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 				}
 			} else {
 				sl := (*[1 << 28]*int)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					u := int(asInt(rdser, subtag))
+					u := int(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 					sl[i] = &u
 				}
 			}
@@ -323,12 +435,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 28]int32)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = int32(asInt(rdser, subtag))
+					sl[i] = int32(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 				}
 			} else {
 				sl := (*[1 << 28]*int32)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					u := int32(asInt(rdser, subtag))
+					u := int32(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 					sl[i] = &u
 				}
 			}
@@ -336,12 +448,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 28]uint32)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = uint32(asInt(rdser, subtag))
+					sl[i] = uint32(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 				}
 			} else {
 				sl := (*[1 << 28]*uint32)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					u := uint32(asInt(rdser, subtag))
+					u := uint32(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 					sl[i] = &u
 				}
 			}
@@ -349,12 +461,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 29]int16)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = int16(asInt(rdser, subtag))
+					sl[i] = int16(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 				}
 			} else {
 				sl := (*[1 << 28]*int16)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					u := int16(asInt(rdser, subtag))
+					u := int16(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 					sl[i] = &u
 				}
 			}
@@ -362,12 +474,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 29]uint16)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = uint16(asInt(rdser, subtag))
+					sl[i] = uint16(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 				}
 			} else {
 				sl := (*[1 << 28]*uint16)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					u := uint16(asInt(rdser, subtag))
+					u := uint16(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 					sl[i] = &u
 				}
 			}
@@ -375,12 +487,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 30]int8)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = int8(asInt(rdser, subtag))
+					sl[i] = int8(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 				}
 			} else {
 				sl := (*[1 << 28]*int8)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					u := int8(asInt(rdser, subtag))
+					u := int8(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 					sl[i] = &u
 				}
 			}
@@ -388,12 +500,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 30]uint8)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = uint8(asInt(rdser, subtag))
+					sl[i] = uint8(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 				}
 			} else {
 				sl := (*[1 << 28]*uint8)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					u := uint8(asInt(rdser, subtag))
+					u := uint8(dec.checkInt(asInt(rdser, subtag), k, cctagsPath))
 					sl[i] = &u
 				}
 			}
@@ -440,12 +552,12 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 			if !isPtr {
 				sl := (*[1 << 27]string)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					sl[i] = asString(rdser, subtag)
+					sl[i] = asString(rdser, subtag, dec.arena)
 				}
 			} else {
 				sl := (*[1 << 28]*string)(ptr)[:count:count]
 				for i := 0; i < count; i++ {
-					s := asString(rdser, subtag)
+					s := asString(rdser, subtag, dec.arena)
 					sl[i] = &s
 				}
 			}
@@ -469,19 +581,28 @@ func (dec *Decoder) decodeSlice(pl *payloadIface, rdser *Serializer, v *reflect.
 }
 
 func (dec *Decoder) decodeValue(pl *payloadIface, rdser *Serializer, v reflect.Value, fieldsoutcnt []int, cctagsPath []int) bool {
+	return dec.decodeTag(pl, rdser, ctag(rdser.GetVarUInt()), v, fieldsoutcnt, cctagsPath)
+}
 
-	ctag := ctag(rdser.GetVarUInt())
+// decodeTag decodes value of field with already read ctag to v
+func (dec *Decoder) decodeTag(pl *payloadIface, rdser *Serializer, ctag ctag, v reflect.Value, fieldsoutcnt []int, cctagsPath []int) bool {
 	ctagType := ctag.Type()
 
 	switch ctagType {
 	case TAG_END:
 		return false
 	case TAG_NULL:
+		if dec.presence != nil {
+			dec.markPresent(cctagsPath, ctag.Name(), true)
+		}
 		return true
 	}
 
 	ctagField := ctag.Field()
 	ctagName := ctag.Name()
+	if dec.presence != nil {
+		dec.markPresent(cctagsPath, ctagName, false)
+	}
 
 	k := v.Kind()
 	if k == reflect.Ptr {
@@ -539,7 +660,7 @@ func (dec *Decoder) decodeValue(pl *payloadIface, rdser *Serializer, v reflect.V
 					v = v.Field((*idx)[0])
 				}
 			} else {
-				return dec.skipStruct(pl, rdser, fieldsoutcnt, ctag)
+				return dec.decodeUnknown(pl, rdser, v, fieldsoutcnt, cctagsPath, ctag)
 			}
 		} else {
 			panic(fmt.Errorf("Err: intf=%s, name='%s' %s", v.Type().Name(), dec.state.tagsMatcher.tag2name(ctagName), ctag.Dump()))
@@ -577,7 +698,7 @@ func (dec *Decoder) decodeValue(pl *payloadIface, rdser *Serializer, v reflect.V
 			for dec.decodeValue(pl, rdser, v, fieldsoutcnt, cctagsPath) {
 			}
 		case TAG_STRING:
-			str := rdser.GetVString()
+			str := dec.arena.readVString(rdser)
 			switch {
 			case k == reflect.String:
 				v.SetString(str)
@@ -600,9 +721,9 @@ func (dec *Decoder) decodeValue(pl *payloadIface, rdser *Serializer, v reflect.V
 			case reflect.Float32, reflect.Float64:
 				v.SetFloat(asFloat(rdser, ctagType))
 			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int64, reflect.Int32:
-				v.SetInt(asInt(rdser, ctagType))
+				v.SetInt(dec.checkInt(asInt(rdser, ctagType), k, cctagsPath))
 			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint64, reflect.Uint32:
-				v.SetUint(uint64(asInt(rdser, ctagType)))
+				v.SetUint(uint64(dec.checkInt(asInt(rdser, ctagType), k, cctagsPath)))
 			case reflect.Interface:
 				v.Set(reflect.ValueOf(asIface(rdser, ctagType)))
 			case reflect.Bool:
@@ -619,9 +740,11 @@ func (dec *Decoder) decodeValue(pl *payloadIface, rdser *Serializer, v reflect.V
 		switch mv.Type().Key().Kind() {
 		case reflect.Int64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
 			nameint, _ := strconv.Atoi(name)
+			dec.checkInt(int64(nameint), mv.Type().Key().Kind(), cctagsPath)
 			mv.SetMapIndex(reflect.ValueOf(nameint).Convert(mv.Type().Key()), v)
 		case reflect.Uint64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
 			nameuint, _ := strconv.Atoi(name)
+			dec.checkInt(int64(nameuint), mv.Type().Key().Kind(), cctagsPath)
 			mv.SetMapIndex(reflect.ValueOf(nameuint).Convert(mv.Type().Key()), v)
 		case reflect.String:
 			mv.SetMapIndex(reflect.ValueOf(name), v)
@@ -635,7 +758,7 @@ func (dec *Decoder) decodeValue(pl *payloadIface, rdser *Serializer, v reflect.V
 
 func (dec *Decoder) DecodeCPtr(cptr uintptr, dest interface{}) (err error) {
 
-	pl := &payloadIface{p: cptr, t: &dec.state.payloadType}
+	pl := &payloadIface{p: cptr, t: &dec.state.payloadType, truncateInts: dec.truncateInts, arena: dec.arena}
 
 	dec.state.lock.RLock()
 	defer dec.state.lock.RUnlock()
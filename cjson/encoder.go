@@ -3,17 +3,22 @@ package cjson
 import (
 	"encoding/base64"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
 	"unsafe"
+
+	"github.com/restream/reindexer/bindings"
 )
 
 type Encoder struct {
 	state       *State
 	tagsMatcher *tagsMatcher
 	tmUpdated   bool
+	floatFormat bindings.FloatFormat
+	err         error
 }
 
 type fieldInfo struct {
@@ -26,6 +31,12 @@ type fieldInfo struct {
 	isOmitEmpty bool
 	isTime      bool
 	isPtr       bool
+	// []uint8 with 'int_array' option of reindex tag is encoded as array of integers instead of base64 string
+	isIntArray bool
+	// float format options of reindex tag
+	floatFormat bindings.FloatFormat
+	// UnknownFields carrier, which values are encoded as fields of the struct
+	isUnknown bool
 }
 
 func mkFieldInfo(v reflect.Value, ctagName int, anon bool) fieldInfo {
@@ -105,8 +116,9 @@ func (enc *Encoder) encodeStruct(v reflect.Value, rdser *Serializer, idx []int)
 			vv := v.Field(field)
 			f := v.Type().Field(field)
 			name, skip, omitempty := parseStructField(f)
+			isUnknown := isUnknownFieldsField(f)
 			ctagName := 0
-			if !skip {
+			if !skip && !isUnknown {
 				ctagName = enc.name2tag(name)
 			}
 			if enc.tmUpdated {
@@ -115,17 +127,74 @@ func (enc *Encoder) encodeStruct(v reflect.Value, rdser *Serializer, idx []int)
 			}
 
 			ce.fieldInfo = mkFieldInfo(vv, ctagName, f.Anonymous)
-			ce.isPrivate = len(f.PkgPath) != 0 || skip
+			ce.isPrivate = len(f.PkgPath) != 0 || skip || isUnknown
+			ce.isUnknown = isUnknown
 			ce.isOmitEmpty = omitempty
+			if isFloatKind(ce.kind) || isFloatKind(ce.elemKind) {
+				ce.floatFormat = floatFormatFromTag(f.Tag.Get("reindex"))
+			} else if ce.elemKind == reflect.Uint8 {
+				ce.isIntArray = isIntArrayTag(f.Tag.Get("reindex"))
+			}
 		}
 
-		if !ce.isPrivate {
+		if ce.isUnknown {
+			enc.encodeMap(v.Field(field), rdser, nil)
+		} else if !ce.isPrivate {
 			// process field, except private unexported fields
 			enc.encodeValue(v.Field(field), rdser, ce.fieldInfo, iidx)
 		}
 	}
 }
 
+// isIntArrayTag returns true, if reindex tag has 'int_array' option
+func isIntArrayTag(tag string) bool {
+	parts := strings.SplitN(tag, ",", 3)
+	if len(parts) < 3 {
+		return false
+	}
+	for _, opt := range strings.Split(parts[2], ",") {
+		if opt == "int_array" {
+			return true
+		}
+	}
+	return false
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// SetFloatFormat sets default format of float fields, which is overridden by options of reindex tag
+func (enc *Encoder) SetFloatFormat(format bindings.FloatFormat) {
+	enc.floatFormat = format
+}
+
+// hasFloatFormat returns true, if values of float field f are modified or checked on encode
+func (enc *Encoder) hasFloatFormat(f *fieldInfo) bool {
+	format := enc.floatFormat.Override(f.floatFormat)
+	return format.NaN > bindings.FloatNaNKeep || (format.Round && format.Precision > 0)
+}
+
+// float applies float format of field f to val. Returns false, if val must be encoded as null
+func (enc *Encoder) float(val float64, f *fieldInfo) (float64, bool) {
+	format := enc.floatFormat.Override(f.floatFormat)
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		switch format.NaN {
+		case bindings.FloatNaNNull:
+			return 0, false
+		case bindings.FloatNaNError:
+			if enc.err == nil {
+				enc.err = bindings.NewError(fmt.Sprintf("cjson: Invalid value %v of float field '%s'", val, enc.tagsMatcher.tag2name(f.ctagName)), bindings.ErrParams)
+			}
+		}
+		return val, true
+	}
+	if format.Round && format.Precision > 0 {
+		val = RoundFloat(val, format.Precision)
+	}
+	return val, true
+}
+
 func (enc *Encoder) name2tag(name string) int {
 
 	tagName := enc.tagsMatcher.name2tag(name, false)
@@ -177,16 +246,18 @@ func (enc *Encoder) encodeSlice(v reflect.Value, rdser *Serializer, f fieldInfo,
 	if l == 0 && f.isOmitEmpty {
 		return
 	}
-	if f.elemKind == reflect.Uint8 {
+	if f.elemKind == reflect.Uint8 && !f.isIntArray {
 		rdser.PutVarUInt(mkctag(TAG_STRING, f.ctagName, 0))
 		rdser.PutVString(base64.StdEncoding.EncodeToString(v.Bytes()))
+	} else if isFloatKind(f.elemKind) && enc.hasFloatFormat(&f) {
+		enc.encodeFloatSlice(v, rdser, f)
 	} else {
 		rdser.PutVarUInt(mkctag(TAG_ARRAY, f.ctagName, 0))
 
 		subTag := TAG_OBJECT
 		switch f.elemKind {
 		case reflect.Int, reflect.Int16, reflect.Int64, reflect.Int8, reflect.Int32,
-			reflect.Uint, reflect.Uint16, reflect.Uint64, reflect.Uint32:
+			reflect.Uint, reflect.Uint16, reflect.Uint64, reflect.Uint32, reflect.Uint8:
 			subTag = TAG_VARINT
 		case reflect.Float32, reflect.Float64:
 			subTag = TAG_DOUBLE
@@ -251,6 +322,11 @@ func (enc *Encoder) encodeSlice(v reflect.Value, rdser *Serializer, f fieldInfo,
 			for _, v := range sl {
 				rdser.PutVarInt(int64(v))
 			}
+		case reflect.Uint8:
+			sl := (*[1 << 30]uint8)(ptr)[:l:l]
+			for _, v := range sl {
+				rdser.PutVarInt(int64(v))
+			}
 		case reflect.Float32:
 			sl := (*[1 << 28]float32)(ptr)[:l:l]
 			for _, v := range sl {
@@ -291,6 +367,40 @@ func (enc *Encoder) encodeSlice(v reflect.Value, rdser *Serializer, f fieldInfo,
 	}
 }
 
+// encodeFloatSlice encodes float array, applying float format. Array with null values is encoded as array of mixed types
+func (enc *Encoder) encodeFloatSlice(v reflect.Value, rdser *Serializer, f fieldInfo) {
+	l := v.Len()
+	vals := make([]float64, l)
+	var nulls []bool
+	for i := range vals {
+		var ok bool
+		if vals[i], ok = enc.float(v.Index(i).Float(), &f); !ok {
+			if nulls == nil {
+				nulls = make([]bool, l)
+			}
+			nulls[i] = true
+		}
+	}
+
+	rdser.PutVarUInt(mkctag(TAG_ARRAY, f.ctagName, 0))
+	if nulls == nil {
+		rdser.PutUInt32(mkcarraytag(l, TAG_DOUBLE))
+		for _, val := range vals {
+			rdser.PutDouble(val)
+		}
+		return
+	}
+	rdser.PutUInt32(mkcarraytag(l, TAG_OBJECT))
+	for i, val := range vals {
+		if nulls[i] {
+			rdser.PutVarUInt(mkctag(TAG_NULL, 0, 0))
+		} else {
+			rdser.PutVarUInt(mkctag(TAG_DOUBLE, 0, 0))
+			rdser.PutDouble(val)
+		}
+	}
+}
+
 func (enc *Encoder) encodeValue(v reflect.Value, rdser *Serializer, f fieldInfo, idx []int) {
 
 	if f.isNullable && v.IsNil() {
@@ -319,8 +429,12 @@ func (enc *Encoder) encodeValue(v reflect.Value, rdser *Serializer, f fieldInfo,
 	case reflect.Float32, reflect.Float64:
 		val := v.Float()
 		if val != 0 || !f.isOmitEmpty {
-			rdser.PutVarUInt(mkctag(TAG_DOUBLE, f.ctagName, 0))
-			rdser.PutDouble(val)
+			if val, ok := enc.float(val, &f); ok {
+				rdser.PutVarUInt(mkctag(TAG_DOUBLE, f.ctagName, 0))
+				rdser.PutDouble(val)
+			} else {
+				rdser.PutVarUInt(mkctag(TAG_NULL, f.ctagName, 0))
+			}
 		}
 	case reflect.Bool:
 		vv := 0
@@ -376,6 +490,7 @@ func (enc *Encoder) Encode(src interface{}, wrser *Serializer) (stateToken int,
 	wrser.PutUInt32(0)
 	enc.tagsMatcher = &enc.state.tagsMatcher
 	enc.tmUpdated = false
+	enc.err = nil
 	enc.encodeValue(v, wrser, mkFieldInfo(v, 0, false), make([]int, 0, 10))
 
 	if enc.tmUpdated {
@@ -385,6 +500,7 @@ func (enc *Encoder) Encode(src interface{}, wrser *Serializer) (stateToken int,
 		wrser.TruncateStart(int(unsafe.Sizeof(uint32(0))) + 1)
 	}
 	stateToken = int(enc.state.StateToken)
+	err = enc.err
 
 	enc.state.lock.Unlock()
 	return
@@ -395,6 +511,7 @@ func (enc *Encoder) EncodeRaw(src interface{}, wrser *Serializer) error {
 	v := reflect.ValueOf(src)
 	enc.state.lock.Lock()
 	enc.tmUpdated = false
+	enc.err = nil
 
 	enc.tagsMatcher = &enc.state.tagsMatcher
 	enc.encodeValue(v, wrser, mkFieldInfo(v, 0, false), make([]int, 0, 10))
@@ -404,6 +521,6 @@ func (enc *Encoder) EncodeRaw(src interface{}, wrser *Serializer) error {
 
 	enc.state.lock.Unlock()
 
-	return nil
+	return enc.err
 
 }
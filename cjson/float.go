@@ -0,0 +1,78 @@
+package cjson
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+const maxFloatPrecision = 15
+
+// ParseFloatOption applies float format option of reindex tag ('precision=N', 'round' or 'nan=keep|null|error') to format.
+// Returns false, if opt is not a float format option
+func ParseFloatOption(opt string, format *bindings.FloatFormat) (bool, error) {
+	kv := strings.SplitN(opt, "=", 2)
+	switch kv[0] {
+	case "round":
+		if len(kv) != 1 {
+			return true, fmt.Errorf("Invalid float format option '%s'", opt)
+		}
+		format.Round = true
+	case "precision":
+		precision := 0
+		if len(kv) == 2 {
+			precision, _ = strconv.Atoi(kv[1])
+		}
+		if precision <= 0 || precision > maxFloatPrecision {
+			return true, fmt.Errorf("Invalid float format option '%s': precision must be in range 1..%d", opt, maxFloatPrecision)
+		}
+		format.Precision = precision
+	case "nan":
+		if len(kv) != 2 {
+			return true, fmt.Errorf("Invalid float format option '%s'", opt)
+		}
+		switch kv[1] {
+		case "keep":
+			format.NaN = bindings.FloatNaNKeep
+		case "null":
+			format.NaN = bindings.FloatNaNNull
+		case "error":
+			format.NaN = bindings.FloatNaNError
+		default:
+			return true, fmt.Errorf("Invalid float format option '%s': expected 'nan=keep', 'nan=null' or 'nan=error'", opt)
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// floatFormatFromTag returns float format options of reindex tag. Tag is validated on namespace registration, so invalid options are ignored
+func floatFormatFromTag(tag string) (format bindings.FloatFormat) {
+	parts := strings.SplitN(tag, ",", 3)
+	if len(parts) < 3 {
+		return
+	}
+	for opts := parts[2]; len(opts) != 0; {
+		var opt string
+		if pos := strings.IndexByte(opts, ','); pos != -1 {
+			opt, opts = opts[:pos], opts[pos+1:]
+		} else {
+			opt, opts = opts, ""
+		}
+		ParseFloatOption(opt, &format)
+	}
+	return
+}
+
+// RoundFloat rounds v to precision digits after decimal point. Values, which can't be rounded without overflow, are returned as is
+func RoundFloat(v float64, precision int) float64 {
+	p := math.Pow10(precision)
+	if r := math.Round(v*p) / p; !math.IsInf(r, 0) && !math.IsNaN(r) {
+		return r
+	}
+	return v
+}
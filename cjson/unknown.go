@@ -0,0 +1,103 @@
+package cjson
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// Handling of fields of decoded document, which are not in the destination struct
+const (
+	// Fields are skipped (default)
+	UnknownFieldsDrop = iota
+	// Fields are decoded to UnknownFields field of struct (if it has one) and encoded back with the struct
+	UnknownFieldsPreserve
+	// Decoding fails with ErrUnknownField
+	UnknownFieldsError
+)
+
+// UnknownFields - carrier of fields of document, which are not in the struct. Struct field of this type is not encoded as
+// field itself: its values are encoded as fields of the struct, so they survive read-modify-write of document.
+// Values are decoded like values of interface{} fields: objects and arrays are map[string]interface{} and []interface{}
+type UnknownFields map[string]interface{}
+
+var unknownFieldsType = reflect.TypeOf(UnknownFields(nil))
+
+// Copy returns deep copy of fields, e.g. for DeepCopy of struct
+func (u UnknownFields) Copy() UnknownFields {
+	if u == nil {
+		return nil
+	}
+	return copyUnknownValue(map[string]interface{}(u)).(map[string]interface{})
+}
+
+func copyUnknownValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			m[k] = copyUnknownValue(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(vv))
+		for i, e := range vv {
+			s[i] = copyUnknownValue(e)
+		}
+		return s
+	}
+	return v
+}
+
+// ErrUnknownField is returned by decoder with UnknownFieldsError policy, when document has field, which is not in the struct
+type ErrUnknownField struct {
+	// JSON path of the field
+	Field string
+}
+
+func (e ErrUnknownField) Error() string {
+	return fmt.Sprintf("cjson: Field '%s' of document is not in the struct", e.Field)
+}
+
+func (e ErrUnknownField) Code() int {
+	return bindings.ErrParams
+}
+
+// SetUnknownFields sets handling of unknown fields of document: UnknownFieldsDrop, UnknownFieldsPreserve or UnknownFieldsError
+func (dec *Decoder) SetUnknownFields(policy int) {
+	dec.unknownFields = policy
+}
+
+// isUnknownFieldsField returns true for exported struct field of UnknownFields type
+func isUnknownFieldsField(f reflect.StructField) bool {
+	return f.Type == unknownFieldsType && len(f.PkgPath) == 0
+}
+
+// unknownFieldsIndex returns index of UnknownFields field of struct type t, or -1
+func unknownFieldsIndex(t reflect.Type) int {
+	for i := 0; i < t.NumField(); i++ {
+		if isUnknownFieldsField(t.Field(i)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// decodeUnknown decodes field with tag, which is not in struct v, by policy of decoder. cctagsPath includes the field
+func (dec *Decoder) decodeUnknown(pl *payloadIface, rdser *Serializer, v reflect.Value, fieldsoutcnt []int, cctagsPath []int, tag ctag) bool {
+	switch dec.unknownFields {
+	case UnknownFieldsPreserve:
+		if i := unknownFieldsIndex(v.Type()); i >= 0 {
+			return dec.decodeTag(pl, rdser, tag, v.Field(i), fieldsoutcnt, cctagsPath[:len(cctagsPath)-1])
+		}
+	case UnknownFieldsError:
+		names := make([]string, 0, len(cctagsPath))
+		for _, name := range cctagsPath {
+			names = append(names, dec.state.tagsMatcher.tag2name(name))
+		}
+		panic(ErrUnknownField{Field: strings.Join(names, ".")})
+	}
+	return dec.skipStruct(pl, rdser, fieldsoutcnt, tag)
+}
@@ -0,0 +1,141 @@
+package reindexer
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// closedBinding replaces binding of the closed client. All the calls return ErrClientClosed
+type closedBinding struct{}
+
+func (closedBinding) Init(u []url.URL, options ...interface{}) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) Clone() bindings.RawBinding {
+	return closedBinding{}
+}
+
+func (closedBinding) OpenNamespace(ctx context.Context, namespace string, enableStorage, dropOnFileFormatError bool) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) CloseNamespace(ctx context.Context, namespace string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) DropNamespace(ctx context.Context, namespace string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) TruncateNamespace(ctx context.Context, namespace string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) RenameNamespace(ctx context.Context, srcNs string, dstNs string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) EnableStorage(ctx context.Context, namespace string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) AddIndex(ctx context.Context, namespace string, indexDef bindings.IndexDef) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) UpdateIndex(ctx context.Context, namespace string, indexDef bindings.IndexDef) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) DropIndex(ctx context.Context, namespace, index string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) BeginTx(ctx context.Context, namespace string) (bindings.TxCtx, error) {
+	return bindings.TxCtx{}, ErrClientClosed
+}
+
+func (closedBinding) CommitTx(txCtx *bindings.TxCtx) (bindings.RawBuffer, error) {
+	return nil, ErrClientClosed
+}
+
+func (closedBinding) RollbackTx(tx *bindings.TxCtx) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) ModifyItemTx(txCtx *bindings.TxCtx, format int, data []byte, mode int, percepts []string, stateToken int) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) ModifyItemTxAsync(txCtx *bindings.TxCtx, format int, data []byte, mode int, percepts []string, stateToken int, cmpl bindings.RawCompletion) {
+	cmpl(nil, ErrClientClosed)
+}
+
+func (closedBinding) DeleteQueryTx(txCtx *bindings.TxCtx, rawQuery []byte) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) UpdateQueryTx(txCtx *bindings.TxCtx, rawQuery []byte) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) PutMeta(ctx context.Context, namespace, key, data string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) GetMeta(ctx context.Context, namespace, key string) (bindings.RawBuffer, error) {
+	return nil, ErrClientClosed
+}
+
+func (closedBinding) ModifyItem(ctx context.Context, nsHash int, namespace string, format int, data []byte, mode int, percepts []string, stateToken int) (bindings.RawBuffer, error) {
+	return nil, ErrClientClosed
+}
+
+func (closedBinding) Select(ctx context.Context, query string, asJson bool, ptVersions []int32, fetchCount int) (bindings.RawBuffer, error) {
+	return nil, ErrClientClosed
+}
+
+func (closedBinding) SelectQuery(ctx context.Context, rawQuery []byte, asJson bool, ptVersions []int32, fetchCount int) (bindings.RawBuffer, error) {
+	return nil, ErrClientClosed
+}
+
+func (closedBinding) DeleteQuery(ctx context.Context, nsHash int, rawQuery []byte) (bindings.RawBuffer, error) {
+	return nil, ErrClientClosed
+}
+
+func (closedBinding) UpdateQuery(ctx context.Context, nsHash int, rawQuery []byte) (bindings.RawBuffer, error) {
+	return nil, ErrClientClosed
+}
+
+func (closedBinding) Commit(ctx context.Context, namespace string) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) EnableLogger(logger bindings.Logger) {
+}
+
+func (closedBinding) DisableLogger() {
+}
+
+func (closedBinding) ReopenLogFiles() error {
+	return ErrClientClosed
+}
+
+func (closedBinding) Ping(ctx context.Context) error {
+	return ErrClientClosed
+}
+
+func (closedBinding) Finalize() error {
+	return nil
+}
+
+func (closedBinding) Status(ctx context.Context) bindings.Status {
+	return bindings.Status{Err: ErrClientClosed}
+}
+
+func (closedBinding) GetSQLSuggestions(ctx context.Context, query string, pos int) ([]string, error) {
+	return nil, ErrClientClosed
+}
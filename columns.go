@@ -0,0 +1,310 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// ColumnsSpec - fields, which are extracted by ExecToColumns
+type ColumnsSpec struct {
+	// Names of indexes or JSON paths of scalar fields of namespace type
+	Fields []string
+	// Values of the fields, which are missing in items or null, by the same names as in Fields. Zero value of the column type is used by default
+	Defaults map[string]interface{}
+}
+
+// Columns - values of the fields of query results, stored by columns.
+// Integer fields (including unsigned) are stored as int64, float fields as float64
+type Columns struct {
+	count   int
+	fields  []string
+	columns []column
+}
+
+type column struct {
+	kind    reflect.Kind
+	ints    []int64
+	floats  []float64
+	strings []string
+	bools   []bool
+	// bit i is set, if the field is present in i-th item
+	valid []uint64
+	def   reflect.Value
+	// value of the current item is set
+	set bool
+}
+
+func columnKind(k reflect.Kind) reflect.Kind {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return reflect.Int64
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	case reflect.String, reflect.Bool:
+		return k
+	}
+	return reflect.Invalid
+}
+
+// columnPaths returns JSON paths of the fields, which are given by JSON paths or names of indexes
+func columnPaths(ns *reindexerNamespace, fields []string) []string {
+	paths := make([]string, len(fields))
+	for i, field := range fields {
+		paths[i] = field
+		for _, index := range ns.indexes {
+			if index.Name == field && len(index.JSONPaths) == 1 {
+				paths[i] = index.JSONPaths[0]
+				break
+			}
+		}
+	}
+	return paths
+}
+
+func newColumns(t reflect.Type, spec ColumnsSpec, paths []string, count int) (*Columns, error) {
+	c := &Columns{fields: spec.Fields, columns: make([]column, len(spec.Fields))}
+	for i, field := range spec.Fields {
+		ft, ok := cjson.FieldTypeByPath(t, paths[i])
+		if !ok {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Field '%s' is not found in type %s", field, t.Name()), ErrCodeParams)
+		}
+		col := &c.columns[i]
+		if col.kind = columnKind(ft.Kind()); col.kind == reflect.Invalid {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Field '%s' of type %s can't be extracted to column", field, ft), ErrCodeParams)
+		}
+		colType := reflect.TypeOf(int64(0))
+		switch col.kind {
+		case reflect.Float64:
+			colType = reflect.TypeOf(float64(0))
+			col.floats = make([]float64, 0, count)
+		case reflect.String:
+			colType = reflect.TypeOf("")
+			col.strings = make([]string, 0, count)
+		case reflect.Bool:
+			colType = reflect.TypeOf(false)
+			col.bools = make([]bool, 0, count)
+		default:
+			col.ints = make([]int64, 0, count)
+		}
+		col.def = reflect.Zero(colType)
+		if def, ok := spec.Defaults[field]; ok {
+			v := reflect.ValueOf(def)
+			defKind := reflect.Invalid
+			if v.IsValid() {
+				defKind = columnKind(v.Kind())
+			}
+			numeric := (defKind == reflect.Int64 || defKind == reflect.Float64) && (col.kind == reflect.Int64 || col.kind == reflect.Float64)
+			if defKind != col.kind && !numeric {
+				return nil, bindings.NewError(fmt.Sprintf("rq: Invalid default value %#v of field '%s' of type %s", def, field, ft), ErrCodeParams)
+			}
+			col.def = v.Convert(colType)
+		}
+		col.valid = make([]uint64, 0, (count+63)/64)
+	}
+	return c, nil
+}
+
+// Len returns count of rows
+func (c *Columns) Len() int {
+	return c.count
+}
+
+func (c *Columns) column(field string, kind reflect.Kind) *column {
+	for i := range c.fields {
+		if c.fields[i] == field && c.columns[i].kind == kind {
+			return &c.columns[i]
+		}
+	}
+	return nil
+}
+
+// Int64 returns values of integer field. Returns nil, if there is no such integer column
+func (c *Columns) Int64(field string) []int64 {
+	if col := c.column(field, reflect.Int64); col != nil {
+		return col.ints
+	}
+	return nil
+}
+
+// Float64 returns values of float field. Returns nil, if there is no such float column
+func (c *Columns) Float64(field string) []float64 {
+	if col := c.column(field, reflect.Float64); col != nil {
+		return col.floats
+	}
+	return nil
+}
+
+// String returns values of string field. Returns nil, if there is no such string column
+func (c *Columns) String(field string) []string {
+	if col := c.column(field, reflect.String); col != nil {
+		return col.strings
+	}
+	return nil
+}
+
+// Bool returns values of bool field. Returns nil, if there is no such bool column
+func (c *Columns) Bool(field string) []bool {
+	if col := c.column(field, reflect.Bool); col != nil {
+		return col.bools
+	}
+	return nil
+}
+
+// Valid returns validity bitmap of field: bit i%64 of word i/64 is set, if the field is present in i-th row
+func (c *Columns) Valid(field string) []uint64 {
+	for i := range c.fields {
+		if c.fields[i] == field {
+			return c.columns[i].valid
+		}
+	}
+	return nil
+}
+
+// IsValid returns true, if the field is present in i-th row
+func (c *Columns) IsValid(field string, i int) bool {
+	valid := c.Valid(field)
+	return i >= 0 && i/64 < len(valid) && valid[i/64]&(1<<uint(i%64)) != 0
+}
+
+// columnsWriter puts values of the current row to columns
+type columnsWriter struct {
+	c   *Columns
+	err error
+}
+
+func (w *columnsWriter) put(col int, kind reflect.Kind, value interface{}) *column {
+	c := &w.c.columns[col]
+	if c.set {
+		return nil
+	}
+	if c.kind != kind {
+		if w.err == nil {
+			w.err = bindings.NewError(fmt.Sprintf("rq: Can't put value %#v to %s column '%s'", value, c.kind, w.c.fields[col]), ErrCodeParams)
+		}
+		return nil
+	}
+	c.set = true
+	row := w.c.count
+	if row%64 == 0 {
+		c.valid = append(c.valid, 0)
+	}
+	c.valid[row/64] |= 1 << uint(row%64)
+	return c
+}
+
+func (w *columnsWriter) PutInt(col int, v int64) {
+	switch w.c.columns[col].kind {
+	case reflect.Float64:
+		w.PutFloat(col, float64(v))
+	case reflect.Bool:
+		w.PutBool(col, v != 0)
+	default:
+		if c := w.put(col, reflect.Int64, v); c != nil {
+			c.ints = append(c.ints, v)
+		}
+	}
+}
+
+func (w *columnsWriter) PutFloat(col int, v float64) {
+	if c := w.put(col, reflect.Float64, v); c != nil {
+		c.floats = append(c.floats, v)
+	}
+}
+
+func (w *columnsWriter) PutString(col int, v string) {
+	if c := w.put(col, reflect.String, v); c != nil {
+		c.strings = append(c.strings, v)
+	}
+}
+
+func (w *columnsWriter) PutBool(col int, v bool) {
+	if c := w.put(col, reflect.Bool, v); c != nil {
+		c.bools = append(c.bools, v)
+	}
+}
+
+// endRow puts defaults to the columns, which are missing in the current row
+func (w *columnsWriter) endRow() {
+	row := w.c.count
+	for i := range w.c.columns {
+		c := &w.c.columns[i]
+		if c.set {
+			c.set = false
+			continue
+		}
+		if row%64 == 0 {
+			c.valid = append(c.valid, 0)
+		}
+		switch c.kind {
+		case reflect.Int64:
+			c.ints = append(c.ints, c.def.Int())
+		case reflect.Float64:
+			c.floats = append(c.floats, c.def.Float())
+		case reflect.String:
+			c.strings = append(c.strings, c.def.String())
+		case reflect.Bool:
+			c.bools = append(c.bools, c.def.Bool())
+		}
+	}
+	w.c.count++
+}
+
+// ExecToColumns executes query and extracts values of scalar fields of results to typed columns.
+// Items are not decoded to structs and object cache is not used. Joins and merges are not supported
+func (q *Query) ExecToColumns(ctx context.Context, spec ColumnsSpec) (*Columns, error) {
+	if q.root != nil {
+		q = q.root
+	}
+	if len(spec.Fields) == 0 {
+		return nil, bindings.NewError("rq: No fields to extract to columns", ErrCodeParams)
+	}
+	if len(q.joinQueries) != 0 || len(q.mergedQueries) != 0 {
+		return nil, bindings.NewError("rq: Joins and merges are not supported by ExecToColumns", ErrCodeParams)
+	}
+	it := q.Select(spec.Fields...).ExecCtx(ctx)
+	defer it.Close()
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	return it.readColumns(spec)
+}
+
+// readColumns extracts the fields of the rest of items, fetching them chunk by chunk
+func (it *Iterator) readColumns(spec ColumnsSpec) (*Columns, error) {
+	paths := columnPaths(it.nsArray[0].reindexerNamespace, spec.Fields)
+	c, err := newColumns(it.nsArray[0].rtype, spec, paths, it.rawQueryParams.qcount-it.ptr)
+	if err != nil {
+		return nil, err
+	}
+	dec := cjson.NewColumnsDecoder(paths)
+	w := &columnsWriter{c: c}
+	for it.ptr < it.rawQueryParams.qcount {
+		if it.needMore() {
+			if it.fetchResults(); it.err != nil {
+				return nil, it.err
+			}
+		}
+		params := it.ser.readRawtItemParams()
+		if (it.rawQueryParams.flags & bindings.ResultsWithJoined) != 0 {
+			it.ser.GetVarUInt()
+		}
+		if params.cptr == 0 && params.data == nil {
+			return nil, bindings.NewError("rq: Results don't contain items data", ErrCodeLogic)
+		}
+		if err = dec.Decode(&it.nsArray[params.nsid].localCjsonState, params.cptr, params.data, w); err != nil {
+			return nil, err
+		}
+		if w.err != nil {
+			return nil, w.err
+		}
+		w.endRow()
+		it.resPtr++
+		it.ptr++
+	}
+	return c, nil
+}
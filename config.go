@@ -0,0 +1,189 @@
+package reindexer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/bindings/builtinserver/config"
+)
+
+// Config client configuration. Can be passed to Open as option instead of separate With... options
+// Zero value of each field means 'binding default'
+type Config struct {
+	// Connections pool size of cproto binding
+	ConnPoolSize int
+	// Timeout on connect and login to server. Timer resolution here is 10 ms
+	LoginTimeout time.Duration
+	// Timeout on each network request to server. Timer resolution here is 10 ms
+	RequestTimeout time.Duration
+	// Count of retry attempts on read/write requests in case of network errors
+	RetryAttempts bindings.OptionRetryAttempts
+	// Retries of the initial connection establishment
+	ConnectRetries bindings.OptionConnectRetries
+	// Delays of reconnect attempts after failed reconnect of cproto binding
+	ReconnectBackoff bindings.OptionReconnectBackoff
+	// TLS config of cproto connections (nil - TLS is not used)
+	TLS *tls.Config
+	// Max count of concurrent cgo calls of builtin binding
+	CgoLimit int
+	// Create DB on connect if DB doesn't exist already
+	CreateDBIfMissing bool
+	// Compress network traffic by snappy library
+	NetCompression bool
+	// Min size in bytes of the compressed request, if NetCompression is set
+	NetCompressionThreshold int
+	// Application name, which will be used in server connect info
+	AppName string
+	// Embedded server config of builtinserver binding
+	ServerConfig *config.ServerConfig
+	// Embedded server startup timeout of builtinserver binding
+	ServerStartupTimeout time.Duration
+	// Disable object cache of all the namespaces
+	DisableObjCache bool
+	// Observer of metrics of cproto requests, which is called after each request with its command, duration and error
+	RPCObserver func(rpc bindings.SlowRPC)
+	// Logger for reindexer logs
+	Logger Logger
+}
+
+type optionLogger struct {
+	log Logger
+}
+
+// Validate checks config values
+func (cfg *Config) Validate() error {
+	switch {
+	case cfg.ConnPoolSize < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid connections pool size %d", cfg.ConnPoolSize), ErrCodeParams)
+	case cfg.LoginTimeout < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid login timeout %v", cfg.LoginTimeout), ErrCodeParams)
+	case cfg.RequestTimeout < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid request timeout %v", cfg.RequestTimeout), ErrCodeParams)
+	case cfg.RetryAttempts.Read < 0 || cfg.RetryAttempts.Write < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid retry attempts %+v", cfg.RetryAttempts), ErrCodeParams)
+	case cfg.ConnectRetries.Attempts < 0 || cfg.ConnectRetries.Backoff < 0 || cfg.ConnectRetries.Total < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid connect retries %+v", cfg.ConnectRetries), ErrCodeParams)
+	case cfg.ConnectRetries.Backoff == 0 && (cfg.ConnectRetries.Attempts > 1 || cfg.ConnectRetries.Total > 0):
+		return bindings.NewError(fmt.Sprintf("rq: Connect retries %+v require non-zero backoff", cfg.ConnectRetries), ErrCodeParams)
+	case cfg.ReconnectBackoff.Min < 0 || cfg.ReconnectBackoff.Max < 0 || (cfg.ReconnectBackoff.Max != 0 && cfg.ReconnectBackoff.Max < cfg.ReconnectBackoff.Min) ||
+		cfg.ReconnectBackoff.Jitter < 0 || cfg.ReconnectBackoff.Jitter > 1 || cfg.ReconnectBackoff.DownWindow < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid reconnect backoff %+v", cfg.ReconnectBackoff), ErrCodeParams)
+	case cfg.CgoLimit < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid cgo limit %d", cfg.CgoLimit), ErrCodeParams)
+	case cfg.NetCompressionThreshold < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid net compression threshold %d", cfg.NetCompressionThreshold), ErrCodeParams)
+	case cfg.ServerStartupTimeout < 0:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid server startup timeout %v", cfg.ServerStartupTimeout), ErrCodeParams)
+	case cfg.ServerStartupTimeout != 0 && cfg.ServerConfig == nil:
+		return bindings.NewError("rq: Server startup timeout is set without server config", ErrCodeParams)
+	}
+	return nil
+}
+
+// options translates config to bindings options
+func (cfg *Config) options() []interface{} {
+	var options []interface{}
+	if cfg.ConnPoolSize != 0 {
+		options = append(options, bindings.OptionConnPoolSize{ConnPoolSize: cfg.ConnPoolSize})
+	}
+	if cfg.LoginTimeout != 0 || cfg.RequestTimeout != 0 {
+		options = append(options, bindings.OptionTimeouts{LoginTimeout: cfg.LoginTimeout, RequestTimeout: cfg.RequestTimeout})
+	}
+	if cfg.RetryAttempts.Read != 0 || cfg.RetryAttempts.Write != 0 {
+		options = append(options, cfg.RetryAttempts)
+	}
+	if cfg.ConnectRetries != (bindings.OptionConnectRetries{}) {
+		options = append(options, cfg.ConnectRetries)
+	}
+	if cfg.ReconnectBackoff != (bindings.OptionReconnectBackoff{}) {
+		options = append(options, cfg.ReconnectBackoff)
+	}
+	if cfg.TLS != nil {
+		options = append(options, bindings.OptionTLS{Config: cfg.TLS})
+	}
+	if cfg.CgoLimit != 0 {
+		options = append(options, bindings.OptionCgoLimit{CgoLimit: cfg.CgoLimit})
+	}
+	if cfg.CreateDBIfMissing {
+		options = append(options, bindings.OptionConnect{CreateDBIfMissing: true})
+	}
+	if cfg.NetCompression {
+		options = append(options, bindings.OptionCompression{EnableCompression: true, Threshold: cfg.NetCompressionThreshold})
+	}
+	if len(cfg.AppName) != 0 {
+		options = append(options, bindings.OptionAppName{AppName: cfg.AppName})
+	}
+	if cfg.ServerConfig != nil {
+		options = append(options, bindings.OptionBuiltinWithServer{ServerConfig: cfg.ServerConfig, StartupTimeout: cfg.ServerStartupTimeout})
+	}
+	if cfg.DisableObjCache {
+		options = append(options, bindings.OptionDisableObjCache{})
+	}
+	if cfg.RPCObserver != nil {
+		options = append(options, bindings.OptionSlowRPC{Hook: cfg.RPCObserver})
+	}
+	if cfg.Logger != nil {
+		options = append(options, optionLogger{cfg.Logger})
+	}
+	return options
+}
+
+// prepareOptions expands Config options, merges partial timeouts and checks, that the same option is not set twice
+func prepareOptions(options []interface{}) (bindingOptions []interface{}, log Logger, err error) {
+	expanded := make([]interface{}, 0, len(options))
+	for _, option := range options {
+		switch v := option.(type) {
+		case Config:
+			if err = v.Validate(); err != nil {
+				return nil, nil, err
+			}
+			expanded = append(expanded, v.options()...)
+		case *Config:
+			if err = v.Validate(); err != nil {
+				return nil, nil, err
+			}
+			expanded = append(expanded, v.options()...)
+		default:
+			expanded = append(expanded, option)
+		}
+	}
+
+	var timeouts *bindings.OptionTimeouts
+	seen := make(map[reflect.Type]struct{}, len(expanded))
+	bindingOptions = make([]interface{}, 0, len(expanded))
+	for _, option := range expanded {
+		switch v := option.(type) {
+		case bindings.OptionTimeouts:
+			if timeouts == nil {
+				timeouts = &bindings.OptionTimeouts{}
+			}
+			if (v.LoginTimeout != 0 && timeouts.LoginTimeout != 0) || (v.RequestTimeout != 0 && timeouts.RequestTimeout != 0) {
+				return nil, nil, bindings.NewError("rq: Timeouts option is set twice", ErrCodeParams)
+			}
+			if v.LoginTimeout != 0 {
+				timeouts.LoginTimeout = v.LoginTimeout
+			}
+			if v.RequestTimeout != 0 {
+				timeouts.RequestTimeout = v.RequestTimeout
+			}
+			continue
+		}
+		t := reflect.TypeOf(option)
+		if _, ok := seen[t]; ok {
+			return nil, nil, bindings.NewError(fmt.Sprintf("rq: Option %s is set twice", t.Name()), ErrCodeParams)
+		}
+		seen[t] = struct{}{}
+		if v, ok := option.(optionLogger); ok {
+			log = v.log
+			continue
+		}
+		bindingOptions = append(bindingOptions, option)
+	}
+	if timeouts != nil {
+		bindingOptions = append(bindingOptions, *timeouts)
+	}
+	return bindingOptions, log, nil
+}
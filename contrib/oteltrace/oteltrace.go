@@ -0,0 +1,94 @@
+// Package oteltrace traces network requests of reindexer's cproto binding by OpenTelemetry.
+//
+//	db := reindexer.NewReindex("cproto://127.0.0.1:6534/testdb", oteltrace.WithTracing())
+//
+// Each request (including fetches of query results, logins and pings) becomes client span, which is child of span of request's context
+package oteltrace
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// instrumentationName - name of tracer, which is requested from TracerProvider
+const instrumentationName = "github.com/restream/reindexer/contrib/oteltrace"
+
+// Attributes of spans
+const (
+	AttrSystem      = attribute.Key("db.system")
+	AttrOperation   = attribute.Key("db.operation")
+	AttrAddress     = attribute.Key("server.address")
+	AttrLabel       = attribute.Key("reindexer.label")
+	AttrRequestSize = attribute.Key("reindexer.request.size")
+	AttrReplySize   = attribute.Key("reindexer.reply.size")
+	AttrErrorCode   = attribute.Key("reindexer.error.code")
+)
+
+const (
+	systemReindexer = "reindexer"
+	// spanNamePrefix - prefix of names of spans, which are followed by name of command, e.g. 'reindexer.SelectSQL'
+	spanNamePrefix = "reindexer."
+)
+
+type config struct {
+	provider trace.TracerProvider
+	filter   func(ctx context.Context, span bindings.RPCSpan) bool
+}
+
+// Option - option of WithTracing
+type Option func(cfg *config)
+
+// WithTracerProvider sets provider of tracer. Default is global provider of otel
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(cfg *config) {
+		cfg.provider = provider
+	}
+}
+
+// WithFilter sets filter of requests: request isn't traced, if filter returns false.
+// E.g. it may skip pings or requests without span in context
+func WithFilter(filter func(ctx context.Context, span bindings.RPCSpan) bool) Option {
+	return func(cfg *config) {
+		cfg.filter = filter
+	}
+}
+
+// WithTracing returns option of reindexer.NewReindex (or of Reindexer.UpdateOptions), which traces requests by OpenTelemetry
+func WithTracing(opts ...Option) interface{} {
+	cfg := config{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.provider == nil {
+		cfg.provider = otel.GetTracerProvider()
+	}
+	tracer := cfg.provider.Tracer(instrumentationName)
+
+	return bindings.OptionTracer{StartSpan: func(ctx context.Context, rpc bindings.RPCSpan) func(res bindings.RPCSpanEnd) {
+		if cfg.filter != nil && !cfg.filter(ctx, rpc) {
+			return nil
+		}
+		attrs := []attribute.KeyValue{AttrSystem.String(systemReindexer), AttrOperation.String(rpc.Name), AttrAddress.String(rpc.Addr)}
+		if rpc.Label != "" {
+			attrs = append(attrs, AttrLabel.String(rpc.Label))
+		}
+		_, span := tracer.Start(ctx, spanNamePrefix+rpc.Name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+		return func(res bindings.RPCSpanEnd) {
+			span.SetAttributes(AttrRequestSize.Int(res.RequestSize), AttrReplySize.Int(res.ReplySize))
+			if res.Err != nil {
+				if rerr, ok := res.Err.(bindings.Error); ok {
+					span.SetAttributes(AttrErrorCode.Int(rerr.Code()))
+				}
+				span.RecordError(res.Err)
+				span.SetStatus(codes.Error, res.Err.Error())
+			}
+			span.End()
+		}
+	}}
+}
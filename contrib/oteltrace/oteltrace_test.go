@@ -0,0 +1,90 @@
+package oteltrace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+func newTracer(t *testing.T, opts ...Option) (bindings.OptionTracer, *tracetest.InMemoryExporter, trace.Tracer) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer, ok := WithTracing(append([]Option{WithTracerProvider(provider)}, opts...)...).(bindings.OptionTracer)
+	require.True(t, ok)
+	return tracer, exporter, provider.Tracer("test")
+}
+
+func attrs(span tracetest.SpanStub) map[string]string {
+	res := make(map[string]string, len(span.Attributes))
+	for _, kv := range span.Attributes {
+		res[string(kv.Key)] = kv.Value.Emit()
+	}
+	return res
+}
+
+func TestWithTracing(t *testing.T) {
+	t.Run("span of request", func(t *testing.T) {
+		tracer, exporter, parentTracer := newTracer(t)
+		ctx, parent := parentTracer.Start(context.Background(), "handler")
+		end := tracer.StartSpan(ctx, bindings.RPCSpan{Cmd: 48, Name: "SelectSQL", Label: "report", Addr: "127.0.0.1:6534"})
+		require.NotNil(t, end)
+		assert.Len(t, exporter.GetSpans(), 0, "span is exported before its end")
+		end(bindings.RPCSpanEnd{RequestSize: 20, ReplySize: 1000})
+		parent.End()
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 2)
+		span := spans[0]
+		assert.Equal(t, "reindexer.SelectSQL", span.Name)
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID(), "span isn't child of span of request's context")
+		assert.Equal(t, map[string]string{
+			"db.system":              "reindexer",
+			"db.operation":           "SelectSQL",
+			"server.address":         "127.0.0.1:6534",
+			"reindexer.label":        "report",
+			"reindexer.request.size": "20",
+			"reindexer.reply.size":   "1000",
+		}, attrs(span))
+		assert.Equal(t, codes.Unset, span.Status.Code)
+		assert.Len(t, span.Events, 0)
+	})
+
+	t.Run("failed request", func(t *testing.T) {
+		tracer, exporter, _ := newTracer(t)
+		err := bindings.NewError("rq: Request timeout", bindings.ErrTimeout)
+		tracer.StartSpan(context.Background(), bindings.RPCSpan{Name: "ModifyItem"})(bindings.RPCSpanEnd{RequestSize: 20, Err: err})
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		span := spans[0]
+		assert.Equal(t, codes.Error, span.Status.Code)
+		assert.Equal(t, err.Error(), span.Status.Description)
+		require.Len(t, span.Events, 1)
+		assert.Equal(t, "exception", span.Events[0].Name)
+		a := attrs(span)
+		assert.Equal(t, "0", a["reindexer.reply.size"])
+		assert.NotContains(t, a, "reindexer.label")
+		assert.Contains(t, a, "reindexer.error.code")
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		tracer, exporter, _ := newTracer(t, WithFilter(func(ctx context.Context, span bindings.RPCSpan) bool {
+			return span.Name != "Ping"
+		}))
+		assert.Nil(t, tracer.StartSpan(context.Background(), bindings.RPCSpan{Name: "Ping"}))
+		tracer.StartSpan(context.Background(), bindings.RPCSpan{Name: "FetchResults"})(bindings.RPCSpanEnd{})
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "reindexer.FetchResults", spans[0].Name)
+	})
+}
@@ -593,6 +593,27 @@ reindexer_ret reindexer_get_meta(uintptr_t rx, reindexer_string ns, reindexer_st
 	return ret2c(res, out);
 }
 
+reindexer_ret reindexer_get_sql_suggestions(uintptr_t rx, reindexer_string query, int pos, reindexer_ctx_info ctx_info) {
+	reindexer_resbuffer out{0, 0, 0};
+	Error res = err_not_init;
+	if (rx) {
+		CGORdxCtxKeeper rdxKeeper(rx, ctx_info, ctx_pool);
+		QueryResultsWrapper* results = new_results();
+		if (!results) {
+			return ret2c(err_too_many_queries, out);
+		}
+
+		vector<string> suggestions;
+		res = rdxKeeper.db().GetSqlSuggestions(str2cv(query), pos, suggestions);
+		results->ser.PutVarUint(suggestions.size());
+		for (auto& s : suggestions) results->ser.PutVString(s);
+		out.len = results->ser.Len();
+		out.data = uintptr_t(results->ser.Buf());
+		out.results_ptr = uintptr_t(results);
+	}
+	return ret2c(res, out);
+}
+
 reindexer_error reindexer_commit(uintptr_t rx, reindexer_string nsName) {
 	auto db = reinterpret_cast<Reindexer*>(rx);
 	return error2c(!db ? err_not_init : db->Commit(str2cv(nsName)));
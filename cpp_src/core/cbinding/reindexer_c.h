@@ -53,6 +53,7 @@ reindexer_error reindexer_commit(uintptr_t rx, reindexer_string nsName);
 reindexer_error reindexer_put_meta(uintptr_t rx, reindexer_string ns, reindexer_string key, reindexer_string data,
 								   reindexer_ctx_info ctx_info);
 reindexer_ret reindexer_get_meta(uintptr_t rx, reindexer_string ns, reindexer_string key, reindexer_ctx_info ctx_info);
+reindexer_ret reindexer_get_sql_suggestions(uintptr_t rx, reindexer_string query, int pos, reindexer_ctx_info ctx_info);
 
 reindexer_error reindexer_cancel_context(reindexer_ctx_info ctx_info, ctx_cancel_type how);
 
@@ -55,6 +55,12 @@ void WrResultSerializer::putExtraParams(const QueryResults* results) {
 		PutVarUint(QueryResultExplain);
 		PutSlice(results->explainResults);
 	}
+
+	if (results->nsStateLSN >= 0) {
+		PutVarUint(QueryResultNsState);
+		auto slicePosSaver = StartSlice();
+		PutVarint(results->nsStateLSN);
+	}
 	PutVarUint(QueryResultEnd);
 }
 
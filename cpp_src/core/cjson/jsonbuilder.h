@@ -1,5 +1,6 @@
 #pragma once
 
+#include <cmath>
 #include "estl/span.h"
 #include "tagsmatcher.h"
 
@@ -50,12 +51,23 @@ public:
 	JsonBuilder &Put(string_view name, const Variant &arg);
 	JsonBuilder &Put(string_view name, string_view arg);
 	JsonBuilder &Put(string_view name, const char *arg) { return Put(name, string_view(arg)); }
-	template <typename T, typename std::enable_if<std::is_integral<T>::value || std::is_floating_point<T>::value>::type * = nullptr>
+	template <typename T, typename std::enable_if<std::is_integral<T>::value>::type * = nullptr>
 	JsonBuilder &Put(string_view name, T arg) {
 		putName(name);
 		(*ser_) << arg;
 		return *this;
 	}
+	template <typename T, typename std::enable_if<std::is_floating_point<T>::value>::type * = nullptr>
+	JsonBuilder &Put(string_view name, T arg) {
+		putName(name);
+		// NaN and Inf are not representable in JSON
+		if (std::isfinite(arg)) {
+			(*ser_) << double(arg);
+		} else {
+			(*ser_) << "null";
+		}
+		return *this;
+	}
 	template <typename T>
 	JsonBuilder &Put(int tagName, const T &arg) {
 		return Put(getNameByTag(tagName), arg);
@@ -44,6 +44,7 @@ Error DBConfigProvider::FromJSON(const gason::JsonNode &root) {
 				data.noQueryIdleThreshold = nsNode["unload_idle_threshold"].As<int>();
 				data.logLevel = logLevelFromString(nsNode["log_level"].As<string>("none"));
 				data.cacheMode = str2cacheMode(nsNode["join_cache_mode"].As<string>("off"));
+				data.queryCountCache = nsNode["query_count_cache"].As<bool>(data.queryCountCache);
 				data.startCopyPolicyTxSize = nsNode["start_copy_policy_tx_size"].As<int>(data.startCopyPolicyTxSize);
 				data.copyPolicyMultiplier = nsNode["copy_policy_multiplier"].As<int>(data.copyPolicyMultiplier);
 				data.txSizeToAlwaysCopy = nsNode["tx_size_to_always_copy"].As<int>(data.txSizeToAlwaysCopy);
@@ -34,6 +34,7 @@ struct NamespaceConfigData {
 	int noQueryIdleThreshold = 0;
 	LogLevel logLevel = LogNone;
 	CacheMode cacheMode = CacheModeOff;
+	bool queryCountCache = true;
 	int startCopyPolicyTxSize = 10000;
 	int copyPolicyMultiplier = 5;
 	int txSizeToAlwaysCopy = 100000;
@@ -619,6 +619,11 @@ void NamespaceImpl::Update(const Query &query, QueryResults &result, const NsCon
 	selCtx.functions = &func;
 	selCtx.contextCollectingMode = true;
 	selecter(result, selCtx, ctx.rdxContext);
+	if (query.IsDryRun()) {
+		result.getTagsMatcher(0) = tagsMatcher_;
+		result.lockResults();
+		return;
+	}
 
 	auto tmStart = high_resolution_clock::now();
 
@@ -805,6 +810,7 @@ void NamespaceImpl::Delete(const Query &q, QueryResults &result, const NsContext
 	selCtx.functions = &func;
 	selecter(result, selCtx, ctx.rdxContext);
 	result.lockResults();
+	if (q.IsDryRun()) return;
 
 	auto tmStart = high_resolution_clock::now();
 	for (auto &r : result.Items()) {
@@ -245,6 +245,7 @@ protected:
 
 	void copyContentsFrom(const NamespaceImpl &);
 	ReplicationState getReplState() const;
+	int64_t lastLSN() const noexcept { return repl_.slaveMode ? repl_.lastLsn : wal_.LSNCounter() - 1; }
 	std::string sysRecordName(string_view sysTag, uint64_t version);
 	void writeSysRecToStorage(string_view data, string_view sysTag, uint64_t &version, bool direct);
 	void saveIndexesToStorage();
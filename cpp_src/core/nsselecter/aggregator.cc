@@ -43,6 +43,15 @@ static void copy(It begin, It end, std::vector<FacetResult> &facets) {
 	}
 }
 
+// Numbers are compared as doubles, strings - by their text
+static int compareMinMax(const Variant &lhs, const Variant &rhs) {
+	if (lhs.Type() != KeyValueString && rhs.Type() != KeyValueString && lhs.Type() != rhs.Type()) {
+		const double l = lhs.As<double>(), r = rhs.As<double>();
+		return (l == r) ? 0 : ((l > r) ? 1 : -1);
+	}
+	return lhs.RelaxCompare(rhs);
+}
+
 class Aggregator::MultifieldComparator {
 public:
 	MultifieldComparator(const h_vector<SortingEntry, 1> &, const FieldsSet &, const PayloadType &);
@@ -252,9 +261,12 @@ AggregationResult Aggregator::GetResult() const {
 			ret.value = double(hitCount_ == 0 ? 0 : (result_ / hitCount_));
 			break;
 		case AggSum:
+			ret.value = result_;
+			break;
 		case AggMin:
 		case AggMax:
 			ret.value = result_;
+			ret.typedValue = minMax_;
 			break;
 		case AggFacet:
 			if (multifieldFacets_) {
@@ -314,10 +326,14 @@ void Aggregator::aggregate(const Variant &v) {
 			hitCount_++;
 			break;
 		case AggMin:
-			result_ = std::min(v.As<double>(), result_);
-			break;
 		case AggMax:
-			result_ = std::max(v.As<double>(), result_);
+			if (v.Type() != KeyValueString) {
+				result_ = (aggType_ == AggMin) ? std::min(v.As<double>(), result_) : std::max(v.As<double>(), result_);
+			}
+			if (minMax_.Type() == KeyValueNull || compareMinMax(v, minMax_) * (aggType_ == AggMin ? -1 : 1) > 0) {
+				minMax_ = v;
+				minMax_.EnsureHold();
+			}
 			break;
 		case AggFacet:
 			assert(singlefieldFacets_);
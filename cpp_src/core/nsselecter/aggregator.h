@@ -46,6 +46,7 @@ protected:
 	PayloadType payloadType_;
 	FieldsSet fields_;
 	double result_ = 0;
+	Variant minMax_;
 	int hitCount_ = 0;
 	AggType aggType_;
 	h_vector<string, 1> names_;
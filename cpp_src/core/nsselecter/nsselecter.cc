@@ -22,6 +22,11 @@ static int GetMaxIterations(const SelectIteratorContainer &iterators, bool withZ
 }
 
 void NsSelecter::operator()(QueryResults &result, SelectCtx &ctx, const RdxContext &rdxCtx) {
+	const int64_t lsn = ns_->lastLSN();
+	if (ctx.query.RequiredState() >= 0 && ctx.query.RequiredState() != lsn) {
+		throw Error(errStateMismatch, "State of namespace '%s' is changed: LSN is %ld, required %ld", ns_->name_, lsn, ctx.query.RequiredState());
+	}
+	if (result.nsStateLSN < 0) result.nsStateLSN = lsn;
 	ctx.sortingContext.enableSortOrders = ns_->sortOrdersBuilt_;
 	ctx.sortingContext.forcedMode = !ctx.query.forcedSortOrder_.empty();
 	if (ns_->config_.logLevel > ctx.query.debugLevel) {
@@ -35,7 +40,9 @@ void NsSelecter::operator()(QueryResults &result, SelectCtx &ctx, const RdxConte
 	bool needCalcTotal = ctx.query.calcTotal == ModeAccurateTotal;
 
 	QueryCacheKey ckey;
-	if (ctx.query.calcTotal == ModeCachedTotal) {
+	if (ctx.query.calcTotal == ModeCachedTotal && !ns_->config_.queryCountCache) {
+		needCalcTotal = true;
+	} else if (ctx.query.calcTotal == ModeCachedTotal) {
 		ckey = QueryCacheKey{ctx.query};
 
 		auto cached = ns_->queryCache_->Get(ckey);
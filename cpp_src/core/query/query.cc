@@ -175,6 +175,12 @@ void Query::deserialize(Serializer &ser, bool &hasJoinConditions) {
 			case QueryWithRank:
 				withRank_ = true;
 				break;
+			case QueryDryRun:
+				dryRun_ = true;
+				break;
+			case QueryRequireState:
+				requiredState_ = ser.GetVarint();
+				break;
 			case QuerySelectFunction:
 				selectFunctions_.push_back(string(ser.GetVString()));
 				break;
@@ -308,6 +314,15 @@ void Query::Serialize(WrSerializer &ser, uint8_t mode) const {
 		ser.PutVarUint(QueryWithRank);
 	}
 
+	if (dryRun_) {
+		ser.PutVarUint(QueryDryRun);
+	}
+
+	if (requiredState_ >= 0) {
+		ser.PutVarUint(QueryRequireState);
+		ser.PutVarint(requiredState_);
+	}
+
 	for (const UpdateEntry &field : updateFields_) {
 		if (field.mode == FieldModeSet) {
 			ser.PutVarUint(QueryUpdateField);
@@ -453,6 +453,22 @@ public:
 	}
 	bool IsWithRank() const noexcept { return withRank_; }
 
+	/// Update and delete queries only select matching items without modifying them
+	/// @return Query object
+	Query &DryRun(bool on = true) noexcept {
+		dryRun_ = on;
+		return *this;
+	}
+	bool IsDryRun() const noexcept { return dryRun_; }
+
+	/// Select query fails with errStateMismatch, if LSN of namespace is not equal to lsn
+	/// @return Query object
+	Query &RequireState(int64_t lsn) noexcept {
+		requiredState_ = lsn;
+		return *this;
+	}
+	int64_t RequiredState() const noexcept { return requiredState_; }
+
 	/// Serializes query data to stream.
 	/// @param ser - serializer object for write.
 	/// @param mode - serialization mode.
@@ -496,6 +512,8 @@ public:
 private:
 	h_vector<UpdateEntry, 0> updateFields_;	 /// List of fields (and values) for update.
 	bool withRank_ = false;
+	bool dryRun_ = false;
+	int64_t requiredState_ = -1;
 
 	friend class SQLParser;
 };
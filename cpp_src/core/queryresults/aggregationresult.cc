@@ -49,6 +49,26 @@ void AggregationResult::GetJSON(WrSerializer &ser) const {
 	if (value != 0) builder.Put("value", value);
 	builder.Put("type", aggTypeToStr(type));
 
+	if (type == AggMin || type == AggMax) {
+		switch (typedValue.Type()) {
+			case KeyValueInt:
+			case KeyValueInt64:
+			case KeyValueBool:
+				builder.Object("typed_value").Put("kind", "int64"_sv).Put("value", typedValue.As<int64_t>());
+				break;
+			case KeyValueDouble:
+				builder.Object("typed_value").Put("kind", "double"_sv).Put("value", typedValue.As<double>());
+				break;
+			case KeyValueString:
+				builder.Object("typed_value").Put("kind", "string"_sv).Put("value", string_view(typedValue));
+				break;
+			default:
+				// There were no values to aggregate
+				builder.Object("typed_value").Put("kind", "null"_sv);
+				break;
+		}
+	}
+
 	if (facets.size()) {
 		auto arrNode = builder.Array("facets");
 		for (auto &facet : facets) {
@@ -80,6 +100,18 @@ Error AggregationResult::FromJSON(span<char> json) {
 		value = root["value"].As<double>();
 		type = strToAggType(root["type"].As<string>());
 
+		const auto &typedNode = root["typed_value"];
+		if (!typedNode.empty()) {
+			string_view kind = typedNode["kind"].As<string_view>();
+			if (kind == "int64"_sv) {
+				typedValue = Variant(typedNode["value"].As<int64_t>());
+			} else if (kind == "double"_sv) {
+				typedValue = Variant(typedNode["value"].As<double>());
+			} else if (kind == "string"_sv) {
+				typedValue = Variant(typedNode["value"].As<string>());
+			}
+		}
+
 		for (auto &subElem : root["fields"]) {
 			fields.push_back(subElem.As<string>());
 		}
@@ -1,6 +1,7 @@
 #pragma once
 
 #include <string>
+#include "core/keyvalue/variant.h"
 #include "core/type_consts.h"
 #include "estl/h_vector.h"
 #include "estl/span.h"
@@ -23,6 +24,8 @@ struct AggregationResult {
 	AggType type = AggSum;
 	h_vector<string, 1> fields;
 	double value = 0;
+	// Exact value of MIN/MAX aggregation with its original type. Null, if there were no values
+	Variant typedValue;
 	std::vector<FacetResult> facets;
 	std::vector<std::string> distincts;
 
@@ -105,6 +105,8 @@ public:
 	int GetJoinedNsCtxIndex(int nsid) const;
 
 	string explainResults;
+	// LSN of main namespace at the moment of query execution
+	int64_t nsStateLSN = -1;
 
 protected:
 	class EncoderDatasourceWithJoins;
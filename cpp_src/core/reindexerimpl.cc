@@ -472,7 +472,7 @@ Error ReindexerImpl::Update(const Query& q, QueryResults& result, const Internal
 		const auto rdxCtx = ctx.CreateRdxContext(ctx.NeedTraceActivity() ? q.GetSQL(ser).Slice() : ""_sv, activities_, result);
 		auto ns = getNamespace(q._namespace, rdxCtx);
 		ns->Update(q, result, rdxCtx);
-		if (ns->IsSystem(rdxCtx)) {
+		if (!q.IsDryRun() && ns->IsSystem(rdxCtx)) {
 			for (auto it = result.begin(); it != result.end(); ++it) {
 				auto item = it.GetItem();
 				updateToSystemNamespace(ns->GetName(), item, rdxCtx);
@@ -1145,6 +1145,7 @@ std::vector<string> defDBConfig = {
 				"lazyload":false,
 				"unload_idle_threshold":0,
 				"join_cache_mode":"off",
+				"query_count_cache":true,
 				"start_copy_policy_tx_size":10000
 				"copy_policy_multiplier":5
 				"tx_size_to_always_copy":100000,
@@ -63,6 +63,9 @@ typedef enum QueryItemType {
 	QueryDropField,
 	QueryUpdateObject,
 	QueryWithRank,
+	// Query items of this fork start from 0x100, so they don't clash with items of upstream reindexer
+	QueryDryRun = 0x100,
+	QueryRequireState,
 } QueryItemType;
 
 typedef enum QuerySerializeMode {
@@ -112,6 +115,7 @@ enum ErrorCode {
 	errTagsMissmatch = 21,
 	errReplParams = 22,
 	errNamespaceInvalidated = 23,
+	errStateMismatch = 24,
 };
 
 enum QueryType { QuerySelect, QueryDelete, QueryUpdate, QueryTruncate };
@@ -128,7 +132,13 @@ enum CalcTotalMode { ModeNoTotal, ModeCachedTotal, ModeAccurateTotal };
 
 enum DataFormat { FormatJson, FormatCJson };
 
-enum QueryResultItemType { QueryResultEnd, QueryResultAggregation, QueryResultExplain };
+enum QueryResultItemType {
+	QueryResultEnd,
+	QueryResultAggregation,
+	QueryResultExplain,
+	// Result items of this fork start from 0x100, so they don't clash with items of upstream reindexer
+	QueryResultNsState = 0x100,
+};
 
 enum CacheMode { CacheModeOn = 0, CacheModeAggressive = 1, CacheModeOff = 2 };
 
@@ -154,6 +164,9 @@ enum {
 	kResultsWithJoined = 0x100,
 	kResultsWithRaw = 0x200,
 	kResultsNeedOutputRank = 0x400,
+	// Result flags of this fork start from 0x10000, so they don't clash with flags of upstream reindexer
+	// Reply to select or fetch has CRC-32C of results chunk as the last arg
+	kResultsWithChecksum = 0x10000,
 };
 
 typedef enum IndexOpt { kIndexOptPK = 1 << 7, kIndexOptArray = 1 << 6, kIndexOptDense = 1 << 5, kIndexOptSparse = 1 << 3 } IndexOpt;
@@ -258,6 +271,15 @@ typedef enum ConnectOpt {
 	kConnectOptWarnVersion = 1 << 4,
 } ConnectOpt;
 
+// Capabilities of this fork, which are advertised to clients on login
+typedef enum ServerCapability {
+	kServerCapDryRun = 1 << 0,
+	kServerCapStateToken = 1 << 1,
+	kServerCapResultChecksum = 1 << 2,
+	kServerCapCancelRequest = 1 << 3,
+	kServerCapabilities = kServerCapDryRun | kServerCapStateToken | kServerCapResultChecksum | kServerCapCancelRequest,
+} ServerCapability;
+
 typedef enum StorageTypeOpt {
 	kStorageTypeOptLevelDB = 0,
 	kStorageTypeOptRocksDB = 1,
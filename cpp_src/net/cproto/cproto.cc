@@ -78,6 +78,8 @@ string_view CmdName(uint16_t cmd) {
 			return "Updates"_sv;
 		case kCmdGetSQLSuggestions:
 			return "GetSQLSuggestions"_sv;
+		case kCmdCancelRequest:
+			return "CancelRequest"_sv;
 		default:
 			return "Unknown"_sv;
 	}
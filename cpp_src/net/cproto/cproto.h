@@ -50,6 +50,9 @@ enum CmdCode : uint16_t {
 
 	kCmdGetSQLSuggestions = 92,
 
+	// Cancels running request of another connection of the same user. Args: connection ID (is returned by login), seq of request
+	kCmdCancelRequest = 93,
+
 	kCmdCodeMax = 128
 };
 
@@ -25,6 +25,10 @@ struct RPCCall {
 	uint32_t seq;
 	Args args;
 	milliseconds execTimeout_;
+	// Label of client activity, passed as the 2-nd context arg
+	std::string activityLabel_;
+	// Storage for activity tracer with label. Must outlive DB handle, created for this call
+	std::string activityTracer_;
 };
 
 struct ClientData {
@@ -12,6 +12,7 @@ namespace cproto {
 const auto kCProtoTimeoutSec = 300.;
 const auto kUpdatesResendTimeout = 0.1;
 const auto kMaxUpdatesBufSize = 1024 * 1024 * 8;
+const size_t kMaxActivityLabelLen = 128;
 
 ServerConnection::ServerConnection(int fd, ev::dynamic_loop &loop, Dispatcher &dispatcher, bool enableStat)
 	: net::ConnectionST(fd, loop, enableStat), dispatcher_(dispatcher) {
@@ -142,6 +143,7 @@ void ServerConnection::onRead() {
 				ser = Serializer(uncompressed);
 			}
 			ctx.call->execTimeout_ = milliseconds(0);
+			ctx.call->activityLabel_.clear();
 
 			ctx.call->args.Unpack(ser);
 
@@ -151,6 +153,12 @@ void ServerConnection::onRead() {
 				if (ctxArgs.size() > 0) {
 					ctx.call->execTimeout_ = milliseconds(int64_t(ctxArgs[0]));
 				}
+				if (ctxArgs.size() > 1 && ctxArgs[1].Type() == KeyValueString) {
+					ctx.call->activityLabel_.assign(string_view(ctxArgs[1]).substr(0, kMaxActivityLabelLen));
+					for (auto &c : ctx.call->activityLabel_) {
+						if (static_cast<unsigned char>(c) < 0x20 || c == 0x7F || c == '"' || c == '\'' || c == '\\') c = '_';
+					}
+				}
 			}
 
 			handleRPC(ctx);
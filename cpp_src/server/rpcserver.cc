@@ -8,6 +8,7 @@
 #include "net/cproto/serverconnection.h"
 #include "net/listener.h"
 #include "reindexer_version.h"
+#include "tools/customhash.h"
 
 namespace reindexer_server {
 const reindexer::SemVersion kMinTxReplSupportRxVersion("2.6.0");
@@ -68,6 +69,11 @@ Error RPCServer::Login(cproto::Context &ctx, p_string login, p_string password,
 		});
 	}
 
+	{
+		std::lock_guard<std::mutex> lck(cancelTargetsMtx_);
+		cancelTargets_[clientData->connID] = CancelTarget{clientData->auth.Login(), clientData->cancelCtx};
+	}
+
 	std::shared_ptr<ConnectionStat> connetcionStat = ctx.writer->GetConnectionStat();
 	if (clientsStats_) {
 		clientsStats_->AddConnection(connetcionStat, clientData->connID, ctx.clientAddr.data(), clientData->auth.Login(),
@@ -75,6 +81,7 @@ Error RPCServer::Login(cproto::Context &ctx, p_string login, p_string password,
 									 clientData->rxVersion.StrippedString(), appName.hasValue() ? appName.value().toString() : string());
 	}
 
+	const int64_t connID = clientData->connID;
 	ctx.SetClientData(std::move(clientData));
 	if (statsWatcher_) {
 		statsWatcher_->OnClientConnected(dbName, statsSourceName());
@@ -84,7 +91,8 @@ Error RPCServer::Login(cproto::Context &ctx, p_string login, p_string password,
 
 	status = db.length() ? OpenDatabase(ctx, db, createDBIfMissing) : errOK;
 	if (status.ok()) {
-		ctx.Return({cproto::Arg(p_string(&version)), cproto::Arg(startTs)}, status);
+		ctx.Return({cproto::Arg(p_string(&version)), cproto::Arg(startTs), cproto::Arg(int64_t(kServerCapabilities)), cproto::Arg(connID)},
+				   status);
 	}
 
 	return status;
@@ -149,6 +157,10 @@ void RPCServer::OnClose(cproto::Context &ctx, const Error &err) {
 		auto clientData = dynamic_cast<RPCClientData *>(ctx.GetClientData());
 		if (clientData) clientsStats_->DeleteConnection(clientData->connID);
 	}
+	if (auto clientData = getClientDataUnsafe(ctx)) {
+		std::lock_guard<std::mutex> lck(cancelTargetsMtx_);
+		cancelTargets_.erase(clientData->connID);
+	}
 	logger_.info("RPC: Client disconnected");
 }
 
@@ -175,6 +187,9 @@ void RPCServer::Logger(cproto::Context &ctx, const Error &err, const cproto::Arg
 	}
 
 	if (ctx.call) {
+		if (!ctx.call->activityLabel_.empty()) {
+			ser << "label='"_sv << ctx.call->activityLabel_ << "' "_sv;
+		}
 		ser << cproto::CmdName(ctx.call->cmd) << " "_sv;
 		ctx.call->args.Dump(ser);
 	} else {
@@ -506,6 +521,24 @@ Error RPCServer::UpdateQuery(cproto::Context &ctx, p_string queryBin) {
 	return sendResults(ctx, qres, -1, opts);
 }
 
+// isCancelableCmd returns true for commands, which may be canceled by kCmdCancelRequest
+static bool isCancelableCmd(cproto::CmdCode cmd) {
+	return cmd == cproto::kCmdSelect || cmd == cproto::kCmdSelectSQL || cmd == cproto::kCmdDeleteQuery || cmd == cproto::kCmdUpdateQuery;
+}
+
+Error RPCServer::CancelRequest(cproto::Context &ctx, int64_t connID, int64_t seq) {
+	auto clientData = getClientDataSafe(ctx);
+	std::lock_guard<std::mutex> lck(cancelTargetsMtx_);
+	auto it = cancelTargets_.find(int(connID));
+	// Connection may be already closed. Requests of the other users are not canceled
+	if (it != cancelTargets_.end() && it->second.login == clientData->auth.Login()) {
+		if (auto cancelCtx = it->second.cancelCtx.lock()) {
+			cancelCtx->Cancel(uint32_t(seq));
+		}
+	}
+	return errOK;
+}
+
 Reindexer RPCServer::getDB(cproto::Context &ctx, UserRole role) {
 	auto rawClientData = ctx.GetClientData();
 	if (rawClientData) {
@@ -517,9 +550,22 @@ Reindexer RPCServer::getDB(cproto::Context &ctx, UserRole role) {
 				throw status;
 			}
 			if (db != nullptr) {
-				return db->NeedTraceActivity() ? db->WithTimeout(ctx.call->execTimeout_)
-													 .WithActivityTracer(ctx.clientAddr, clientData->auth.Login(), clientData->connID)
-											   : db->WithTimeout(ctx.call->execTimeout_);
+				const bool cancelable = isCancelableCmd(ctx.call->cmd);
+				if (cancelable) {
+					clientData->cancelCtx->Start(ctx.call->seq);
+				}
+				Reindexer rx = cancelable ? db->WithContext(clientData->cancelCtx.get()).WithTimeout(ctx.call->execTimeout_)
+										  : db->WithTimeout(ctx.call->execTimeout_);
+				if (!db->NeedTraceActivity()) {
+					return rx;
+				}
+				if (ctx.call->activityLabel_.empty()) {
+					return rx.WithActivityTracer(ctx.clientAddr, clientData->auth.Login(), clientData->connID);
+				}
+				ctx.call->activityTracer_.assign(ctx.clientAddr.data(), ctx.clientAddr.size());
+				ctx.call->activityTracer_ += ' ';
+				ctx.call->activityTracer_ += ctx.call->activityLabel_;
+				return rx.WithActivityTracer(ctx.call->activityTracer_, clientData->auth.Login(), clientData->connID);
 			}
 		}
 	}
@@ -537,7 +583,11 @@ Error RPCServer::sendResults(cproto::Context &ctx, QueryResults &qres, int reqId
 	}
 
 	string_view resSlice = rser.Slice();
-	ctx.Return({cproto::Arg(p_string(&resSlice)), cproto::Arg(int(reqId))});
+	if (opts.flags & kResultsWithChecksum) {
+		ctx.Return({cproto::Arg(p_string(&resSlice)), cproto::Arg(int(reqId)), cproto::Arg(int64_t(Crc32c(resSlice.data(), resSlice.size())))});
+	} else {
+		ctx.Return({cproto::Arg(p_string(&resSlice)), cproto::Arg(int(reqId))});
+	}
 	return errOK;
 }
 
@@ -768,6 +818,7 @@ bool RPCServer::Start(const string &addr, ev::dynamic_loop &loop, bool enableSta
 	dispatcher_.Register(cproto::kCmdCloseResults, this, &RPCServer::CloseResults);
 
 	dispatcher_.Register(cproto::kCmdGetSQLSuggestions, this, &RPCServer::GetSQLSuggestions);
+	dispatcher_.Register(cproto::kCmdCancelRequest, this, &RPCServer::CancelRequest);
 
 	dispatcher_.Register(cproto::kCmdGetMeta, this, &RPCServer::GetMeta);
 	dispatcher_.Register(cproto::kCmdPutMeta, this, &RPCServer::PutMeta);
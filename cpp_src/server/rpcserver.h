@@ -1,6 +1,8 @@
 #pragma once
 
+#include <atomic>
 #include <memory>
+#include <mutex>
 #include <unordered_map>
 #include "core/cbinding/resultserializer.h"
 #include "core/keyvalue/variant.h"
@@ -20,6 +22,24 @@ using std::pair;
 using namespace reindexer::net;
 using namespace reindexer;
 
+// RPCCancelContext - cancel context of the running request of connection. Requests of connection are handled sequentially,
+// so request is canceled by kCmdCancelRequest from another connection
+class RPCCancelContext : public IRdxCancelContext {
+public:
+	void Start(uint32_t seq) noexcept { running_.store(seq, std::memory_order_relaxed); }
+	// Request may be canceled before its start
+	void Cancel(uint32_t seq) noexcept { canceled_.store(seq, std::memory_order_relaxed); }
+	CancelType GetCancelType() const noexcept override {
+		auto running = running_.load(std::memory_order_relaxed);
+		return running != kNoSeq && running == canceled_.load(std::memory_order_relaxed) ? CancelType::Explicit : CancelType::None;
+	}
+	bool IsCancelable() const noexcept override { return true; }
+
+private:
+	static constexpr int64_t kNoSeq = -1;
+	std::atomic<int64_t> running_{kNoSeq}, canceled_{kNoSeq};
+};
+
 struct RPCClientData : public cproto::ClientData {
 	~RPCClientData();
 	h_vector<pair<QueryResults, bool>, 1> results;
@@ -30,6 +50,7 @@ struct RPCClientData : public cproto::ClientData {
 	int connID;
 	bool subscribed;
 	SemVersion rxVersion;
+	std::shared_ptr<RPCCancelContext> cancelCtx = std::make_shared<RPCCancelContext>();
 };
 
 class RPCServer {
@@ -85,6 +106,7 @@ public:
 	Error FetchResults(cproto::Context &ctx, int reqId, int flags, int offset, int limit);
 	Error CloseResults(cproto::Context &ctx, int reqId);
 	Error GetSQLSuggestions(cproto::Context &ctx, p_string query, int pos);
+	Error CancelRequest(cproto::Context &ctx, int64_t connID, int64_t seq);
 
 	Error GetMeta(cproto::Context &ctx, p_string ns, p_string key);
 	Error PutMeta(cproto::Context &ctx, p_string ns, p_string key, p_string data);
@@ -121,6 +143,14 @@ protected:
 	IClientsStats *clientsStats_;
 
 	std::chrono::system_clock::time_point startTs_;
+
+	// Cancel contexts of logged in connections by their IDs for kCmdCancelRequest
+	struct CancelTarget {
+		string login;
+		std::weak_ptr<RPCCancelContext> cancelCtx;
+	};
+	std::mutex cancelTargetsMtx_;
+	std::unordered_map<int, CancelTarget> cancelTargets_;
 };
 
 }  // namespace reindexer_server
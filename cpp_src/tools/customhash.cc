@@ -137,4 +137,36 @@ uint32_t collateHash(const string_view& s, CollateMode collateMode) noexcept {
 }
 
 uint32_t HashTreGram(const wchar_t* ptr) noexcept { return _Hash_bytes(ptr, 3 * sizeof(wchar_t)); }
+
+namespace {
+// Tables of slicing-by-8 CRC-32C calculation (little-endian hosts)
+struct Crc32cTables {
+	Crc32cTables() noexcept {
+		for (uint32_t i = 0; i < 256; i++) {
+			uint32_t crc = i;
+			for (int j = 0; j < 8; j++) crc = (crc >> 1) ^ (0x82F63B78 & (0 - (crc & 1)));
+			t[0][i] = crc;
+		}
+		for (uint32_t i = 0; i < 256; i++) {
+			for (int j = 1; j < 8; j++) t[j][i] = (t[j - 1][i] >> 8) ^ t[0][t[j - 1][i] & 0xFF];
+		}
+	}
+	uint32_t t[8][256];
+};
+}  // namespace
+
+uint32_t Crc32c(const void* ptr, size_t len) noexcept {
+	static const Crc32cTables tables;
+	const auto& t = tables.t;
+	const uint8_t* buf = static_cast<const uint8_t*>(ptr);
+	uint32_t crc = 0xFFFFFFFF;
+	for (; len >= 8; buf += 8, len -= 8) {
+		uint32_t lo = unaligned_load(reinterpret_cast<const char*>(buf)) ^ crc;
+		uint32_t hi = unaligned_load(reinterpret_cast<const char*>(buf + 4));
+		crc = t[7][lo & 0xFF] ^ t[6][(lo >> 8) & 0xFF] ^ t[5][(lo >> 16) & 0xFF] ^ t[4][lo >> 24] ^ t[3][hi & 0xFF] ^
+			  t[2][(hi >> 8) & 0xFF] ^ t[1][(hi >> 16) & 0xFF] ^ t[0][hi >> 24];
+	}
+	for (; len; buf++, len--) crc = t[0][(crc ^ *buf) & 0xFF] ^ (crc >> 8);
+	return crc ^ 0xFFFFFFFF;
+}
 }  // namespace reindexer
@@ -12,5 +12,7 @@ uint32_t Hash(const wstring &s) noexcept;
 uint32_t collateHash(const string_view &s, CollateMode collateMode) noexcept;
 uint32_t HashTreGram(const wchar_t *ptr) noexcept;
 uint32_t _Hash_bytes(const void *ptr, uint32_t len);
+// CRC-32C (Castagnoli) checksum, e.g. of query results chunks
+uint32_t Crc32c(const void *ptr, size_t len) noexcept;
 
 }  // namespace reindexer
@@ -0,0 +1,122 @@
+package reindexer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+const (
+	defaultBatchDeleteSize       = 1000
+	defaultBatchDeleteRetries    = 3
+	defaultBatchDeleteBackoff    = 100 * time.Millisecond
+	defaultBatchDeleteMaxBackoff = 5 * time.Second
+)
+
+// BatchDeleteOptions - options of Query.DeleteBatched
+type BatchDeleteOptions struct {
+	// BatchSize - max count of items, which are deleted by one delete query (default 1000)
+	BatchSize int
+	// Pause - delay between batches
+	Pause time.Duration
+	// MaxRate - max average count of deleted items per second (0 - unlimited)
+	MaxRate float64
+	// Progress (optional) is called after each batch with total count of deleted items
+	Progress func(deleted int64)
+	// Retries - max count of retries of batch, which failed with network error or timeout (default 3, -1 - no retries).
+	// Retries are made with backoff, which is doubled from 100ms up to 5s
+	Retries int
+}
+
+// DeleteBatched deletes items, which match query, by batches of BatchSize items: delete query with limit is executed
+// again and again until it deletes nothing or ctx is done, so items, which match query concurrently, are deleted too.
+// Limit of query is replaced by BatchSize. Returns total count of deleted items, which is returned with error too.
+// Items of batch, which failed mid request and was retried, may be not counted
+func (q *Query) DeleteBatched(ctx context.Context, opts BatchDeleteOptions) (int64, error) {
+	if q.root != nil || len(q.joinQueries) != 0 {
+		return 0, errors.New("Delete does not support joined queries")
+	}
+	if q.closed {
+		panic(errors.New("Delete call on already closed query. You shoud create new Query"))
+	}
+	defer q.close()
+	if q.tx != nil {
+		return 0, errors.New("DeleteBatched is not supported in transactions")
+	}
+	if q.dryRun {
+		return 0, errors.New("DeleteBatched is not supported with DryRun")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchDeleteSize
+	}
+	if opts.Retries == 0 {
+		opts.Retries = defaultBatchDeleteRetries
+	}
+
+	var total int64
+	start := time.Now()
+	for {
+		count, err := q.deleteBatch(ctx, &opts)
+		if err != nil {
+			return total, err
+		}
+		if count == 0 {
+			return total, nil
+		}
+		total += int64(count)
+		if opts.Progress != nil {
+			opts.Progress(total)
+		}
+		wait := opts.Pause
+		if opts.MaxRate > 0 {
+			if d := time.Duration(float64(total)/opts.MaxRate*float64(time.Second)) - time.Since(start); d > wait {
+				wait = d
+			}
+		}
+		if err = sleepCtx(ctx, wait); err != nil {
+			return total, err
+		}
+	}
+}
+
+// deleteBatch deletes one batch of items by copy of query. Batch is retried, if it fails with transient error
+func (q *Query) deleteBatch(ctx context.Context, opts *BatchDeleteOptions) (int, error) {
+	backoff := defaultBatchDeleteBackoff
+	for attempt := 0; ; attempt++ {
+		bq := q.makeCopy(q.db, nil)
+		bq.Limit(opts.BatchSize)
+		count, _, err := bq.deleteCtx(ctx)
+		if err == nil || attempt >= opts.Retries || ctx.Err() != nil || !isBatchDeleteTransient(err) {
+			return count, err
+		}
+		if err = sleepCtx(ctx, backoff); err != nil {
+			return 0, err
+		}
+		if backoff *= 2; backoff > defaultBatchDeleteMaxBackoff {
+			backoff = defaultBatchDeleteMaxBackoff
+		}
+	}
+}
+
+// isBatchDeleteTransient returns true for errors, after which delete query may succeed
+func isBatchDeleteTransient(err error) bool {
+	return errors.Is(err, bindings.ErrorNetwork) || errors.Is(err, bindings.ErrorConnBrokenMidRequest) ||
+		errors.Is(err, bindings.ErrorTimeout) || err == context.DeadlineExceeded
+}
+
+// sleepCtx waits for d or until ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
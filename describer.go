@@ -5,15 +5,6 @@ import (
 	"strings"
 )
 
-const (
-	ConfigNamespaceName           = "#config"
-	MemstatsNamespaceName         = "#memstats"
-	NamespacesNamespaceName       = "#namespaces"
-	PerfstatsNamespaceName        = "#perfstats"
-	QueriesperfstatsNamespaceName = "#queriesperfstats"
-	ClientsStatsNamespaceName     = "#clientsstats"
-)
-
 // Map from cond name to index type
 var queryTypes = map[string]int{
 	"EQ":     EQ,
@@ -261,6 +252,25 @@ type ClientConnectionStat struct {
 	ClientVersion string `json:"client_version"`
 }
 
+// ActivityStat is information about currently executing query
+// and located in '#activitystats' system namespace
+type ActivityStat struct {
+	// Client address and activity label, passed by CtxWithActivityLabel
+	Client string `json:"client"`
+	// User name
+	User string `json:"user"`
+	// Query in SQL format
+	Query string `json:"query"`
+	// Query identifier
+	QueryID int64 `json:"query_id"`
+	// Query start time
+	QueryStart string `json:"query_start"`
+	// Query execution state
+	State string `json:"state"`
+	// Description of awaited lock
+	LockDescription string `json:"lock_description,omitempty"`
+}
+
 // QueryPerfStat is information about query's performance statistics
 // and located in '#queriesperfstats' system namespace
 type QueryPerfStat struct {
@@ -286,6 +296,8 @@ type DBProfilingConfig struct {
 	PerfStats bool `json:"perfstats"`
 	// Enables record queries perofrmance statistics
 	QueriesPerfStats bool `json:"queriesperfstats"`
+	// Enables tracking of currently executing queries in #activitystats namespace
+	ActivityStats bool `json:"activitystats"`
 }
 
 // DBNamespacesConfig is part of reindexer configuration contains namespaces options
@@ -296,6 +308,8 @@ type DBNamespacesConfig struct {
 	LogLevel string `json:"log_level"`
 	// Join cache mode. Can be one of on, off, aggressive
 	JoinCacheMode string `json:"join_cache_mode"`
+	// Enable cache of total count for queries with CachedTotal. If not set, cache is enabled
+	QueryCountCache *bool `json:"query_count_cache,omitempty"`
 	// Enable namespace lazy load (namespace shoud be loaded from disk on first call, not at reindexer startup)
 	Lazyload bool `json:"lazyload"`
 	// Unload namespace data from RAM after this idle timeout in seconds. If 0, then data should not be unloaded
@@ -312,6 +326,21 @@ type DBNamespacesConfig struct {
 	OptimizationSortWorkers int `json:"optimization_sort_workers"`
 }
 
+// Join cache modes of namespace
+const (
+	JoinCacheModeOff        = "off"
+	JoinCacheModeOn         = "on"
+	JoinCacheModeAggressive = "aggressive"
+)
+
+// NamespaceCacheConfig is cache options of namespace. See SetNamespaceCacheConfig
+type NamespaceCacheConfig struct {
+	// Join cache mode. Can be one of JoinCacheModeOff, JoinCacheModeOn, JoinCacheModeAggressive
+	JoinCacheMode string
+	// Enable cache of total count for queries with CachedTotal
+	QueryCountCache bool
+}
+
 // DBReplicationConfig is part of reindexer configuration contains replication options
 type DBReplicationConfig struct {
 	// Replication role. One of  none, slave, master
@@ -0,0 +1,78 @@
+package reindexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// Snapshot - hashes of items of query results by their keys, which is made by DiffResults and passed to its next call.
+// It's small (only keys are stored) and can be saved between runs, e.g. as JSON
+type Snapshot struct {
+	Hashes map[string]uint64
+	// State - state token of namespace at query execution ("" - unknown)
+	State StateToken
+}
+
+// DiffResults executes q and compares its results with prev snapshot of previous results: added and changed items are returned,
+// removed ones are returned by keys, because their content isn't stored in snapshot. Results are read one by one, only the added
+// and changed items are kept. Hash of item is FNV-1a of its encoding/json encoding, so it's stable across runs, but changes
+// of fields, which are not in T, are not detected. Items of results must be of type T (e.g. *Item).
+// If namespace state is not changed since prev (see StateToken), query is not executed and prev is returned as next.
+// It's skipped for queries with joins or merges, because state of the other namespaces is not checked
+func DiffResults[T any](ctx context.Context, db *Reindexer, q *Query, prev Snapshot, keyFn func(T) string) (added, changed []T, removed []string, next Snapshot, err error) {
+	if len(prev.State) != 0 && len(q.joinQueries) == 0 && len(q.mergedQueries) == 0 {
+		if state, err := db.Query(q.Namespace).StateToken(ctx); err == nil && state == prev.State {
+			return nil, nil, nil, prev, nil
+		}
+	}
+
+	it := q.ExecCtx(ctx)
+	defer it.Close()
+	if it.Error() != nil {
+		return nil, nil, nil, Snapshot{}, it.Error()
+	}
+	next = Snapshot{Hashes: make(map[string]uint64, it.Count())}
+	for it.Next() {
+		item, ok := it.Object().(T)
+		if !ok {
+			return nil, nil, nil, Snapshot{}, ErrWrongType
+		}
+		key := keyFn(item)
+		if _, ok = next.Hashes[key]; ok {
+			return nil, nil, nil, Snapshot{}, bindings.NewError(fmt.Sprintf("rq: Duplicate key '%s' in results of DiffResults", key), ErrCodeParams)
+		}
+		data, err := json.Marshal(item)
+		if err != nil {
+			return nil, nil, nil, Snapshot{}, err
+		}
+		h := fnv.New64a()
+		h.Write(data)
+		hash := h.Sum64()
+		next.Hashes[key] = hash
+
+		if prevHash, ok := prev.Hashes[key]; !ok {
+			added = append(added, item)
+		} else if prevHash != hash {
+			changed = append(changed, item)
+		}
+	}
+	if it.Error() != nil {
+		return nil, nil, nil, Snapshot{}, it.Error()
+	}
+	for key := range prev.Hashes {
+		if _, ok := next.Hashes[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+	if next.State, err = it.StateToken(); err != nil {
+		// Fast path is not available for the next call
+		next.State = ""
+	}
+	return added, changed, removed, next, nil
+}
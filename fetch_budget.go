@@ -0,0 +1,82 @@
+package reindexer
+
+import (
+	"sync/atomic"
+)
+
+const (
+	// Count of items in the first reply of query with fetch budget, while average size of items of namespace is unknown
+	fetchBudgetInitialCount = 10
+	fetchBudgetMinCount     = 1
+	fetchBudgetMaxCount     = 10000
+)
+
+// fetchBudgetCount returns count of items, which fit into budget by average item size (0 - size is unknown)
+func fetchBudgetCount(budget int, itemSize int64) int {
+	if itemSize <= 0 {
+		return fetchBudgetInitialCount
+	}
+	count := int64(budget) / itemSize
+	if count < fetchBudgetMinCount {
+		return fetchBudgetMinCount
+	}
+	if count > fetchBudgetMaxCount {
+		return fetchBudgetMaxCount
+	}
+	return int(count)
+}
+
+// observeFetchItemSize updates average size of encoded items in results of namespace.
+// It's shared by all the queries with fetch budget to the namespace
+func (ns *reindexerNamespace) observeFetchItemSize(bytes, count int) {
+	if count <= 0 {
+		return
+	}
+	sample := int64(bytes / count)
+	if sample == 0 {
+		sample = 1
+	}
+	for {
+		prev := atomic.LoadInt64(&ns.fetchItemSize)
+		next := sample
+		if prev > 0 {
+			next = (prev + sample) / 2
+		}
+		if atomic.CompareAndSwapInt64(&ns.fetchItemSize, prev, next) {
+			return
+		}
+	}
+}
+
+// nextFetchCount returns count of items to fetch by the next request of query results
+func (q *Query) nextFetchCount() int {
+	if q.fetchBudget <= 0 || len(q.nsArray) == 0 {
+		return q.fetchCount
+	}
+	return fetchBudgetCount(q.fetchBudget, atomic.LoadInt64(&q.nsArray[0].fetchItemSize))
+}
+
+// observeFetchBudget measures average item size by results buffer of query with fetch budget or of any query with threshold of bulk fetches
+func (q *Query) observeFetchBudget(bytes, count int) {
+	if (q.fetchBudget > 0 || q.db.bulkFetchBytes > 0) && len(q.nsArray) != 0 {
+		q.nsArray[0].observeFetchItemSize(bytes, count)
+	}
+}
+
+// bulkFetch returns true, if chunks of fetchCount items of query results are expected to be not less than threshold (see WithBulkFetchThreshold).
+// Size is unknown until the first results of namespace. Size of chunk of query, which fetches all the results by one chunk (e.g. FetchCount(-1)
+// and dry runs), is unknown too, unless it's limited, so such query isn't bulk
+func (q *Query) bulkFetch(threshold, fetchCount int) bool {
+	if threshold <= 0 || len(q.nsArray) == 0 {
+		return false
+	}
+	itemSize := atomic.LoadInt64(&q.nsArray[0].fetchItemSize)
+	if itemSize <= 0 {
+		return false
+	}
+	count := int64(fetchCount)
+	if q.limit >= 0 && (count <= 0 || int64(q.limit) < count) {
+		count = int64(q.limit)
+	}
+	return count > 0 && count*itemSize >= int64(threshold)
+}
@@ -0,0 +1,196 @@
+package reindexer
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// jsonFloatFormatter limits precision of float values in JSON documents of namespace
+type jsonFloatFormatter struct {
+	// json path -> precision. Fields of the type, which are not in map, are formatted with default precision
+	fields    map[string]int
+	precision int
+}
+
+// newJSONFloatFormatter returns nil, if precision of JSON output is not limited by default format and float formats of the fields
+func newJSONFloatFormatter(defaultFormat bindings.FloatFormat, fields map[string]bindings.FloatFormat) *jsonFloatFormatter {
+	ff := &jsonFloatFormatter{fields: make(map[string]int, len(fields)), precision: defaultFormat.Precision}
+	limited := ff.precision > 0
+	for path, format := range fields {
+		format = defaultFormat.Override(format)
+		ff.fields[path] = format.Precision
+		limited = limited || format.Precision > 0
+	}
+	if !limited {
+		return nil
+	}
+	return ff
+}
+
+// format appends doc with rounded float values to dst. Malformed doc is appended as is
+func (ff *jsonFloatFormatter) format(dst, doc []byte) []byte {
+	w := jsonFloatWriter{ff: ff, src: doc, dst: dst}
+	if !w.value() {
+		return append(dst, doc...)
+	}
+	w.dst = append(w.dst, w.src[w.pos:]...)
+	return w.dst
+}
+
+type jsonFloatWriter struct {
+	ff   *jsonFloatFormatter
+	src  []byte
+	pos  int
+	dst  []byte
+	path []byte
+}
+
+func (w *jsonFloatWriter) skipSpaces() {
+	start := w.pos
+	for w.pos < len(w.src) {
+		if c := w.src[w.pos]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			break
+		}
+		w.pos++
+	}
+	w.dst = append(w.dst, w.src[start:w.pos]...)
+}
+
+// expect copies c, if it's the next non-space char
+func (w *jsonFloatWriter) expect(c byte) bool {
+	w.skipSpaces()
+	if w.pos < len(w.src) && w.src[w.pos] == c {
+		w.dst = append(w.dst, c)
+		w.pos++
+		return true
+	}
+	return false
+}
+
+// str copies string and returns its raw content
+func (w *jsonFloatWriter) str() ([]byte, bool) {
+	start := w.pos
+	for w.pos++; w.pos < len(w.src); w.pos++ {
+		switch w.src[w.pos] {
+		case '\\':
+			w.pos++
+		case '"':
+			w.pos++
+			w.dst = append(w.dst, w.src[start:w.pos]...)
+			return w.src[start+1 : w.pos-1], true
+		}
+	}
+	return nil, false
+}
+
+func (w *jsonFloatWriter) value() bool {
+	w.skipSpaces()
+	if w.pos >= len(w.src) {
+		return false
+	}
+	switch w.src[w.pos] {
+	case '{':
+		w.dst = append(w.dst, '{')
+		w.pos++
+		if w.expect('}') {
+			return true
+		}
+		parentLen := len(w.path)
+		for {
+			w.skipSpaces()
+			if w.pos >= len(w.src) || w.src[w.pos] != '"' {
+				return false
+			}
+			key, ok := w.str()
+			if !ok || !w.expect(':') {
+				return false
+			}
+			if parentLen != 0 {
+				w.path = append(w.path, '.')
+			}
+			w.path = appendKey(w.path, key)
+			if !w.value() {
+				return false
+			}
+			w.path = w.path[:parentLen]
+			if w.expect('}') {
+				return true
+			}
+			if !w.expect(',') {
+				return false
+			}
+		}
+	case '[':
+		w.dst = append(w.dst, '[')
+		w.pos++
+		if w.expect(']') {
+			return true
+		}
+		for {
+			if !w.value() {
+				return false
+			}
+			if w.expect(']') {
+				return true
+			}
+			if !w.expect(',') {
+				return false
+			}
+		}
+	case '"':
+		_, ok := w.str()
+		return ok
+	default:
+		start := w.pos
+		isFloat := false
+		for ; w.pos < len(w.src); w.pos++ {
+			c := w.src[w.pos]
+			if c == ',' || c == '}' || c == ']' || c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+				break
+			}
+			isFloat = isFloat || c == '.' || c == 'e' || c == 'E'
+		}
+		w.literal(w.src[start:w.pos], isFloat)
+		return w.pos > start
+	}
+}
+
+// literal copies literal. Float numbers are rounded to precision of the current field
+func (w *jsonFloatWriter) literal(lit []byte, isFloat bool) {
+	precision := w.ff.precision
+	if p, ok := w.ff.fields[string(w.path)]; ok {
+		precision = p
+	}
+	if isFloat && precision > 0 {
+		if v, err := strconv.ParseFloat(string(lit), 64); err == nil {
+			w.dst = appendFloat(w.dst, cjson.RoundFloat(v, precision))
+			return
+		}
+	}
+	w.dst = append(w.dst, lit...)
+}
+
+// appendFloat formats value like server does: exponent is used only for very small and very large values
+func appendFloat(dst []byte, v float64) []byte {
+	if abs := math.Abs(v); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.AppendFloat(dst, v, 'g', -1, 64)
+	}
+	return strconv.AppendFloat(dst, v, 'f', -1, 64)
+}
+
+func appendKey(path, key []byte) []byte {
+	for _, c := range key {
+		if c == '\\' {
+			var s string
+			if err := json.Unmarshal(append(append([]byte{'"'}, key...), '"'), &s); err == nil {
+				return append(path, s...)
+			}
+			break
+		}
+	}
+	return append(path, key...)
+}
@@ -0,0 +1,86 @@
+package reindexer
+
+import (
+	"context"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// ExecIDsOnly executes query and returns internal ids of items of results. Items are not decoded and object cache is not used.
+// cproto binding requests results without items data, so it's much cheaper than full fetch for wide items.
+// Joins are applied as filters, joined items are skipped. Merges are not supported
+func (q *Query) ExecIDsOnly(ctx context.Context) ([]int64, error) {
+	if q.root != nil {
+		q = q.root
+	}
+	if len(q.mergedQueries) != 0 {
+		return nil, bindings.NewError("rq: Merges are not supported by ExecIDsOnly", ErrCodeParams)
+	}
+	it := q.ExecCtx(bindings.ContextWithIDsOnly(ctx))
+	defer it.Close()
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	return it.readIDs()
+}
+
+// ExecPKs executes query and extracts values of primary key fields of results to columns: one column for the plain primary key
+// or a column for each field of the composite one. Items are not decoded to structs, see ExecToColumns
+func (q *Query) ExecPKs(ctx context.Context) (*Columns, error) {
+	if q.root != nil {
+		q = q.root
+	}
+	ns, err := q.db.getNS(q.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for _, index := range ns.indexes {
+		if !index.IsPK {
+			continue
+		}
+		if index.FieldType == "composite" {
+			fields = append(fields, index.JSONPaths...)
+		} else {
+			fields = append(fields, index.Name)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, ErrNoPK
+	}
+	return q.ExecToColumns(ctx, ColumnsSpec{Fields: fields})
+}
+
+// readIDs reads ids of the rest of items, fetching them chunk by chunk
+func (it *Iterator) readIDs() ([]int64, error) {
+	ids := make([]int64, 0, it.rawQueryParams.qcount-it.ptr)
+	for it.ptr < it.rawQueryParams.qcount {
+		if it.needMore() {
+			if it.fetchResults(); it.err != nil {
+				return nil, it.err
+			}
+		}
+		if (it.rawQueryParams.flags & bindings.ResultsWithItemID) == 0 {
+			return nil, bindings.NewError("rq: Results don't contain items ids", ErrCodeLogic)
+		}
+		params := it.ser.readRawtItemParams()
+		if (it.rawQueryParams.flags & bindings.ResultsWithJoined) != 0 {
+			it.skipJoined()
+		}
+		ids = append(ids, int64(params.id))
+		it.resPtr++
+		it.ptr++
+	}
+	return ids, nil
+}
+
+// skipJoined skips joined items of the current item
+func (it *Iterator) skipJoined() {
+	subNSRes := int(it.ser.GetVarUInt())
+	for nsIndex := 0; nsIndex < subNSRes; nsIndex++ {
+		siRes := int(it.ser.GetVarUInt())
+		for i := 0; i < siRes; i++ {
+			it.ser.readRawtItemParams()
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// SampleOptions - queries, which are explained by IndexUsageReport
+type SampleOptions struct {
+	// Representative queries of namespace, which are executed with explain. Queries are closed by report
+	Queries []*Query
+	// Duration of sampling of live select queries of namespace, which are executed by this client (0 - live queries are not sampled)
+	Duration time.Duration
+	// Share of live queries, which are executed with explain, in (0, 1]. 0 - all the queries
+	Rate float64
+	// Enable record of queries performance statistics on server during sampling. Statistics of namespace queries from
+	// '#queriesperfstats' is added to report, profiling config is restored after sampling
+	EnableProfiling bool
+}
+
+// IndexUsageReport - usage of indexes of namespace by sampled queries. It's serializable to JSON
+type IndexUsageReport struct {
+	Namespace string `json:"namespace"`
+	// Count of explained queries
+	Queries int          `json:"queries"`
+	Indexes []IndexUsage `json:"indexes"`
+	// Statistics of namespace queries of all the clients, if SampleOptions.EnableProfiling is set
+	ServerQueries []QueryPerfStat `json:"server_queries,omitempty"`
+}
+
+// IndexUsage - usage of index by explained queries
+type IndexUsage struct {
+	Name string `json:"name"`
+	// Count of queries, which selected or sorted items by index
+	Hits int `json:"hits"`
+	// Time of the last query, which used index (zero time - index was not used)
+	LastUsed time.Time `json:"last_used"`
+	// Index was not used by explained queries
+	Unused bool `json:"unused"`
+	// Queries, which scanned items by condition on the field of index instead of selection by index: label of query or hex of its CacheKey
+	FullScans []string `json:"full_scans,omitempty"`
+}
+
+// indexUsageSampler collects explains of queries of namespace
+type indexUsageSampler struct {
+	rate    float64
+	lock    sync.Mutex
+	queries int
+	// usage of indexes by names and names of indexes by lowercase names and JSON paths
+	usage  []IndexUsage
+	byName map[string]int
+}
+
+func newIndexUsageSampler(indexes []bindings.IndexDef, rate float64) *indexUsageSampler {
+	s := &indexUsageSampler{rate: rate, byName: make(map[string]int, len(indexes))}
+	for _, index := range indexes {
+		if _, ok := s.byName[strings.ToLower(index.Name)]; ok {
+			continue
+		}
+		s.byName[strings.ToLower(index.Name)] = len(s.usage)
+		for _, path := range index.JSONPaths {
+			s.byName[strings.ToLower(path)] = len(s.usage)
+		}
+		s.usage = append(s.usage, IndexUsage{Name: index.Name})
+	}
+	return s
+}
+
+// add counts usage of indexes by explain of query, which is identified by id
+func (s *indexUsageSampler) add(id string, explain *ExplainResults, at time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.queries++
+	used := make(map[int]bool)
+	if i, ok := s.byName[strings.ToLower(explain.SortIndex)]; ok {
+		used[i] = true
+	}
+	for _, sel := range explain.Selectors {
+		i, ok := s.byName[selectorIndex(sel.Field)]
+		if !ok {
+			continue
+		}
+		if sel.Method == "scan" {
+			s.usage[i].FullScans = appendMissing(s.usage[i].FullScans, id)
+		} else {
+			used[i] = true
+		}
+	}
+	for i := range used {
+		s.usage[i].Hits++
+		s.usage[i].LastUsed = at
+	}
+}
+
+// selectorIndex returns lowercase name of field of explain selector without operation prefixes, e.g. 'id' for ' or id' or 'not id'
+func selectorIndex(field string) string {
+	field = strings.ToLower(strings.TrimSpace(field))
+	for _, prefix := range []string{"or ", "not ", "and "} {
+		field = strings.TrimSpace(strings.TrimPrefix(field, prefix))
+	}
+	return field
+}
+
+func appendMissing(ids []string, id string) []string {
+	for _, v := range ids {
+		if v == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+// sampleExplain puts explain to serialized query q, if live queries of its namespace are sampled
+func (db *reindexerImpl) sampleExplain(q *Query, ser *cjson.Serializer) {
+	if atomic.LoadInt32(&db.usageSampling) == 0 {
+		return
+	}
+	db.usageSamplersLock.Lock()
+	s := db.usageSamplers[strings.ToLower(q.Namespace)]
+	db.usageSamplersLock.Unlock()
+	if s == nil || (s.rate > 0 && rand.Float64() >= s.rate) {
+		return
+	}
+	ser.PutVarCUInt(queryExplain)
+	q.usageSampler = s
+}
+
+// addSampledExplain passes explain of q, which was sampled by sampleExplain, to its sampler
+func (q *Query) addSampledExplain(rawExplain []byte) {
+	if q.usageSampler == nil {
+		return
+	}
+	if explain, err := parseExplainResults(rawExplain); err == nil && explain != nil {
+		q.usageSampler.add(queryUsageID(q), explain, time.Now())
+	}
+	q.usageSampler = nil
+}
+
+// queryUsageID returns label of q or hex of its CacheKey
+func queryUsageID(q *Query) string {
+	if len(q.label) != 0 {
+		return q.label
+	}
+	key, _ := q.CacheKey()
+	return fmt.Sprintf("%016x", key)
+}
+
+func (db *reindexerImpl) indexUsageReport(ctx context.Context, namespace string, sample SampleOptions) (report IndexUsageReport, err error) {
+	for _, q := range sample.Queries {
+		if !strings.EqualFold(q.Namespace, namespace) {
+			err = bindings.NewError(fmt.Sprintf("rq: Query of namespace '%s' is passed to index usage report of namespace '%s'", q.Namespace, namespace), ErrCodeParams)
+			break
+		}
+	}
+	if err == nil && (sample.Rate < 0 || sample.Rate > 1) {
+		err = bindings.NewError(fmt.Sprintf("rq: Invalid rate %v of index usage sampling", sample.Rate), ErrCodeParams)
+	}
+	var s *indexUsageSampler
+	if err == nil {
+		s, err = db.newNamespaceSampler(ctx, namespace, sample.Rate)
+	}
+	if err != nil {
+		for _, q := range sample.Queries {
+			q.close()
+		}
+		return report, err
+	}
+
+	for i, q := range sample.Queries {
+		id := queryUsageID(q)
+		it := q.Explain().ExecCtx(ctx)
+		explain, eerr := it.GetExplainResults()
+		if err = it.Error(); err == nil {
+			err = eerr
+		}
+		it.Close()
+		if err != nil {
+			for _, q := range sample.Queries[i+1:] {
+				q.close()
+			}
+			return report, err
+		}
+		if explain != nil {
+			s.add(id, explain, time.Now())
+		}
+	}
+
+	if sample.Duration > 0 {
+		if sample.EnableProfiling {
+			var restore func() error
+			if restore, err = db.enableQueriesPerfStats(ctx); err != nil {
+				return report, err
+			}
+			defer func() {
+				if rerr := restore(); err == nil {
+					err = rerr
+				}
+			}()
+		}
+		if err = db.sampleLive(ctx, namespace, s, sample.Duration); err != nil {
+			return report, err
+		}
+		if sample.EnableProfiling {
+			if report.ServerQueries, err = db.namespaceQueriesPerfStats(ctx, namespace); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	report.Namespace, report.Queries = namespace, s.queries
+	report.Indexes = append(report.Indexes, s.usage...)
+	for i := range report.Indexes {
+		report.Indexes[i].Unused = report.Indexes[i].Hits == 0
+	}
+	return report, nil
+}
+
+// newNamespaceSampler makes sampler of indexes, which are registered by client or are described by server
+func (db *reindexerImpl) newNamespaceSampler(ctx context.Context, namespace string, rate float64) (*indexUsageSampler, error) {
+	ns, err := db.getNS(namespace)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := db.describeNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	indexes := append([]bindings.IndexDef(nil), ns.indexes...)
+	for _, index := range desc.Indexes {
+		indexes = append(indexes, bindings.IndexDef(index.IndexDef))
+	}
+	return newIndexUsageSampler(indexes, rate), nil
+}
+
+// sampleLive explains live select queries of namespace for duration or until ctx is done
+func (db *reindexerImpl) sampleLive(ctx context.Context, namespace string, s *indexUsageSampler, duration time.Duration) error {
+	namespace = strings.ToLower(namespace)
+	db.usageSamplersLock.Lock()
+	if _, ok := db.usageSamplers[namespace]; ok {
+		db.usageSamplersLock.Unlock()
+		return bindings.NewError(fmt.Sprintf("rq: Index usage of namespace '%s' is already sampled", namespace), ErrCodeParams)
+	}
+	if db.usageSamplers == nil {
+		db.usageSamplers = make(map[string]*indexUsageSampler)
+	}
+	db.usageSamplers[namespace] = s
+	atomic.AddInt32(&db.usageSampling, 1)
+	db.usageSamplersLock.Unlock()
+	defer func() {
+		db.usageSamplersLock.Lock()
+		delete(db.usageSamplers, namespace)
+		atomic.AddInt32(&db.usageSampling, -1)
+		db.usageSamplersLock.Unlock()
+	}()
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enableQueriesPerfStats turns on record of all the queries to '#queriesperfstats'. Returned func restores the previous profiling config
+func (db *reindexerImpl) enableQueriesPerfStats(ctx context.Context) (func() error, error) {
+	prev := DBConfigItem{Type: "profiling", Profiling: &DBProfilingConfig{}}
+	item, err := db.query(ConfigNamespaceName).WhereString("type", EQ, "profiling").ExecCtx(ctx).FetchOne()
+	if err == nil {
+		if citem := item.(*DBConfigItem); citem.Profiling != nil {
+			profiling := *citem.Profiling
+			prev.Profiling = &profiling
+		}
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+	profiling := *prev.Profiling
+	profiling.QueriesPerfStats, profiling.QueriesThresholdUS = true, 0
+	if err = db.upsert(ctx, ConfigNamespaceName, &DBConfigItem{Type: "profiling", Profiling: &profiling}); err != nil {
+		return nil, err
+	}
+	return func() error {
+		// Config is restored even if ctx of report is done
+		return db.upsert(context.Background(), ConfigNamespaceName, &prev)
+	}, nil
+}
+
+// namespaceQueriesPerfStats returns statistics of '#queriesperfstats' of queries, which select from namespace
+func (db *reindexerImpl) namespaceQueriesPerfStats(ctx context.Context, namespace string) ([]QueryPerfStat, error) {
+	items, err := db.query(QueriesperfstatsNamespaceName).ExecCtx(ctx).FetchAll()
+	if err != nil {
+		return nil, err
+	}
+	from := "from " + strings.ToLower(namespace)
+	var stats []QueryPerfStat
+	for _, item := range items {
+		stat := item.(*QueryPerfStat)
+		if q := strings.ToLower(stat.Query); strings.Contains(q, from+" ") || strings.HasSuffix(q, from) {
+			stats = append(stats, *stat)
+		}
+	}
+	return stats, nil
+}
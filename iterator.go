@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync/atomic"
 
 	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
 )
 
 // ExplainResults presents query plan
@@ -61,6 +63,7 @@ func errJSONIterator(err error) *JSONIterator {
 
 func newIterator(
 	userCtx context.Context,
+	db *reindexerImpl,
 	q *Query,
 	result bindings.RawBuffer,
 	nsArray []nsArrayEntry,
@@ -74,15 +77,27 @@ func newIterator(
 	} else {
 		it = &Iterator{}
 	}
+	it.db = db
+	it.bufferBytes = 0
+	it.maxBufferBytes = 0
+	if q != nil {
+		it.maxBufferBytes = q.maxBufferBytes
+	}
 	it.nsArray = nsArray
 	it.joinToFields = joinToFields
 	it.joinHandlers = joinHandlers
 	it.queryContext = queryContext
 	it.resPtr = 0
 	it.ptr = 0
+	it.fetchBase = 0
 	it.err = nil
 	it.userCtx = userCtx
+	it.cancel = nil
 	it.allowUnsafe = false
+	it.trackPresence = false
+	it.useArena = false
+	it.op = nil
+	it.current.presence = nil
 	joinObjSize := len(it.joinToFields)
 	if q != nil {
 		for _, mq := range q.mergedQueries {
@@ -100,7 +115,7 @@ func newIterator(
 	return
 }
 
-func newJSONIterator(ctx context.Context, q *Query, json []byte, jsonOffsets []int, explain []byte) *JSONIterator {
+func newJSONIterator(ctx context.Context, db *reindexerImpl, q *Query, json []byte, jsonOffsets []int, namespace string, params rawResultQueryParams) *JSONIterator {
 	var ji *JSONIterator
 	if q != nil {
 		ji = &q.jsonIterator
@@ -111,9 +126,23 @@ func newJSONIterator(ctx context.Context, q *Query, json []byte, jsonOffsets []i
 	ji.jsonOffsets = jsonOffsets
 	ji.ptr = -1
 	ji.query = q
-	ji.explain = explain
+	ji.explain = params.explainResults
 	ji.err = nil
 	ji.userCtx = ctx
+	ji.cancel = nil
+	ji.db = db
+	ji.namespace = namespace
+	ji.nsStateLSN = params.nsStateLSN
+	ji.bufferBytes = 0
+	maxBufferBytes := 0
+	if q != nil {
+		maxBufferBytes = q.maxBufferBytes
+	}
+	if err := db.reserveResultBytes(len(json), maxBufferBytes); err != nil {
+		ji.err = err
+	} else {
+		ji.bufferBytes = len(json)
+	}
 
 	return ji
 }
@@ -129,15 +158,25 @@ type Iterator struct {
 	queryContext   interface{}
 	query          *Query
 	allowUnsafe    bool
+	trackPresence  bool
+	useArena       bool
+	arena          *cjson.Arena // arena of decoded objects, which is reused by the next queries of pooled iterator
+	op             *liveOp      // operation of shutdown report, which is finished by Close
 	resPtr         int
 	ptr            int
+	fetchBase      int // count of items, consumed before query was re-executed
+	db             *reindexerImpl
+	bufferBytes    int // size of the retained results buffer, accounted in db.resultBufferBytes
+	maxBufferBytes int
 	current        struct {
-		obj     interface{}
-		joinObj [][]interface{}
-		rank    int
+		obj      interface{}
+		joinObj  [][]interface{}
+		rank     int
+		presence FieldsPresence
 	}
 	err     error
 	userCtx context.Context
+	cancel  context.CancelFunc
 }
 
 func (it *Iterator) setBuffer(result bindings.RawBuffer) {
@@ -145,7 +184,80 @@ func (it *Iterator) setBuffer(result bindings.RawBuffer) {
 	it.result = result
 	it.rawQueryParams = it.ser.readRawQueryParams(func(nsid int) {
 		it.nsArray[nsid].localCjsonState = it.nsArray[nsid].cjsonState.ReadPayloadType(&it.ser.Serializer)
+		if it.db != nil {
+			it.db.observeNsState(it.nsArray[nsid].reindexerNamespace, it.nsArray[nsid].localCjsonState.StateToken)
+		}
 	})
+	it.rawQueryParams.qcount += it.fetchBase
+	if it.rawQueryParams.nsStateLSN < 0 && it.query != nil {
+		it.rawQueryParams.nsStateLSN = it.query.stateLSN
+	}
+	it.trackBuffer(len(result.GetBuf()))
+	if it.query != nil {
+		it.query.observeFetchBudget(len(result.GetBuf()), it.rawQueryParams.count)
+	}
+}
+
+// trackBuffer replaces size of the retained results buffer and checks per-query and client-wide limits
+func (it *Iterator) trackBuffer(size int) {
+	it.releaseBuffer()
+	if err := it.db.reserveResultBytes(size, it.maxBufferBytes); err != nil {
+		it.err = err
+		return
+	}
+	it.bufferBytes = size
+}
+
+func (it *Iterator) releaseBuffer() {
+	it.db.releaseResultBytes(it.bufferBytes)
+	it.bufferBytes = 0
+}
+
+// reserveResultBytes accounts size of the retained results buffer in client-wide limit. db may be nil
+func (db *reindexerImpl) reserveResultBytes(size, maxBufferBytes int) error {
+	if maxBufferBytes > 0 && size > maxBufferBytes {
+		return ErrResultBufferLimit
+	}
+	if db != nil {
+		if total, max := atomic.AddInt64(&db.resultBufferBytes, int64(size)), db.maxResultBytes(); max > 0 && total > max {
+			atomic.AddInt64(&db.resultBufferBytes, -int64(size))
+			return ErrResultBufferLimit
+		}
+	}
+	return nil
+}
+
+// maxResultBytes returns client-wide limit of results buffers (0 - no limit). db may be nil
+func (db *reindexerImpl) maxResultBytes() int64 {
+	if db == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&db.maxResultBufferBytes)
+}
+
+func (db *reindexerImpl) releaseResultBytes(size int) {
+	if db != nil && size != 0 {
+		atomic.AddInt64(&db.resultBufferBytes, -int64(size))
+	}
+}
+
+// withReplyLimit makes binding reject reply with results buffer, which doesn't fit into the rest of per-query and client-wide limits,
+// before it's read. retained - size of the buffer, which is going to be replaced by the reply
+func (db *reindexerImpl) withReplyLimit(ctx context.Context, maxBufferBytes, retained int) (context.Context, error) {
+	limit := int64(maxBufferBytes)
+	if max := db.maxResultBytes(); max > 0 {
+		rest := max - atomic.LoadInt64(&db.resultBufferBytes) + int64(retained)
+		if rest <= 0 {
+			return ctx, ErrResultBufferLimit
+		}
+		if limit == 0 || rest < limit {
+			limit = rest
+		}
+	}
+	if limit == 0 {
+		return ctx, nil
+	}
+	return bindings.ContextWithReplyLimit(ctx, limit), nil
 }
 
 // Next moves iterator pointer to the next element.
@@ -167,6 +279,7 @@ func (it *Iterator) NextObj(obj interface{}) (hasNext bool) {
 	}
 	it.resPtr++
 	it.ptr++
+	it.op.consume()
 	return it.ptr <= it.rawQueryParams.qcount
 }
 
@@ -206,13 +319,21 @@ func (it *Iterator) readItem(toObj interface{}) (item interface{}, rank int) {
 	if (it.rawQueryParams.flags & bindings.ResultsWithJoined) != 0 {
 		subNSRes = int(it.ser.GetVarUInt())
 	}
-	item, it.err = unpackItem(&it.nsArray[params.nsid], &params, it.allowUnsafe && (subNSRes == 0), (it.rawQueryParams.flags&bindings.ResultsWithItemID) == 0, toObj)
+	it.current.presence = nil
+	if it.trackPresence {
+		it.current.presence = make(FieldsPresence)
+	}
+	item, it.err = unpackItem(&it.nsArray[params.nsid], &params, it.allowUnsafe && (subNSRes == 0), (it.rawQueryParams.flags&bindings.ResultsWithItemID) == 0, toObj, it.current.presence, it.decodeArena())
 	if it.err != nil {
 		return
 	}
 
 	nsIndexOffset := it.joinedNsIndexOffset(params.nsid)
 
+	for nsIndex := range it.current.joinObj {
+		// Joined items of the previous item must not be returned for the joins without matches
+		it.current.joinObj[nsIndex] = nil
+	}
 	for nsIndex := 0; nsIndex < subNSRes; nsIndex++ {
 		siRes := int(it.ser.GetVarUInt())
 		if siRes == 0 {
@@ -221,7 +342,7 @@ func (it *Iterator) readItem(toObj interface{}) (item interface{}, rank int) {
 		subitems := make([]interface{}, siRes)
 		for i := 0; i < siRes; i++ {
 			subparams := it.ser.readRawtItemParams()
-			subitems[i], it.err = unpackItem(&it.nsArray[nsIndex+nsIndexOffset], &subparams, it.allowUnsafe, (it.rawQueryParams.flags&bindings.ResultsWithItemID) == 0, toObj)
+			subitems[i], it.err = unpackItem(&it.nsArray[nsIndex+nsIndexOffset], &subparams, it.allowUnsafe, (it.rawQueryParams.flags&bindings.ResultsWithItemID) == 0, toObj, nil, it.decodeArena())
 			if it.err != nil {
 				return
 			}
@@ -244,10 +365,19 @@ func (it *Iterator) fetchResults() {
 	if fetchMore, ok := it.result.(bindings.FetchMore); ok {
 		fetchCount := defaultFetchCount
 		if it.query != nil {
-			fetchCount = it.query.fetchCount
+			fetchCount = it.query.nextFetchCount()
 		}
 
-		if it.err = fetchMore.Fetch(it.userCtx, it.ptr, fetchCount, false); it.err != nil {
+		ctx, err := it.db.withReplyLimit(it.userCtx, it.maxBufferBytes, it.bufferBytes)
+		if err != nil {
+			it.err = err
+			return
+		}
+		if it.err = fetchMore.Fetch(ctx, it.ptr-it.fetchBase, fetchCount, false); it.err != nil {
+			if lost, ok := it.err.(*bindings.ErrResultsLostOnReconnect); ok {
+				lost.Consumed = it.ptr
+				it.reexecute()
+			}
 			return
 		}
 		it.resPtr = 0
@@ -258,6 +388,27 @@ func (it *Iterator) fetchResults() {
 	return
 }
 
+// reexecute continues iteration with results of the same query, skipping already consumed items
+func (it *Iterator) reexecute() {
+	q := it.query
+	if q == nil || !q.db.autoReexecute {
+		return
+	}
+	ctx, err := q.db.withReplyLimit(it.userCtx, it.maxBufferBytes, it.bufferBytes)
+	if err != nil {
+		return
+	}
+	result, err := q.db.reexecuteQuery(ctx, q, q.startOffset+it.ptr, it.rawQueryParams.qcount-it.ptr)
+	if err != nil {
+		return
+	}
+	it.result.Free()
+	it.err = nil
+	it.fetchBase = it.ptr
+	it.resPtr = 0
+	it.setBuffer(result)
+}
+
 func (it *Iterator) join(nsIndex, nsIndexOffset, parentNsID int, item interface{}) {
 	var field string
 	var handler JoinHandler
@@ -313,7 +464,7 @@ func (it *Iterator) Rank() int {
 	return it.current.rank
 }
 
-// JoinedObjects returns objects slice, that result of join for the given field
+// JoinedObjects returns objects slice, that result of join for the given field (alias of join)
 func (it *Iterator) JoinedObjects(field string) (objects []interface{}, err error) {
 	if it.resPtr == 0 {
 		return nil, errIteratorNotReady
@@ -346,9 +497,44 @@ func (it *Iterator) AllowUnsafe(allow bool) *Iterator {
 	return it
 }
 
+// WithFieldsPresence enables tracking of fields, which are present in the stored documents, e.g. to distinguish absent field from field with zero value.
+// Objects are decoded bypassing object cache, while it's enabled
+func (it *Iterator) WithFieldsPresence(enable bool) *Iterator {
+	it.trackPresence = enable
+	return it
+}
+
+// WithArena enables decoding of strings and small slices of objects into memory blocks of the iterator instead of separate allocations.
+// Such values are valid only until Close, then their memory is reused by the next queries, so objects must not be retained after Close.
+// Objects, which are stored in object cache, don't use arena. FetchAll, FetchOne and FetchAllWithRank disable arena, because they close the iterator
+func (it *Iterator) WithArena(enable bool) *Iterator {
+	it.useArena = enable
+	if enable && it.arena == nil {
+		it.arena = cjson.NewArena()
+	}
+	return it
+}
+
+func (it *Iterator) decodeArena() *cjson.Arena {
+	if !it.useArena {
+		return nil
+	}
+	return it.arena
+}
+
+// FieldsPresence returns fields, which are present in the current object, or nil, if tracking is not enabled by WithFieldsPresence.
+// Will panic when pointer was not moved, Next() must be called before.
+func (it *Iterator) FieldsPresence() FieldsPresence {
+	if it.resPtr == 0 {
+		panic(errIteratorNotReady)
+	}
+	return it.current.presence
+}
+
 // FetchAll returns all query results as slice []interface{} and closes the iterator.
 func (it *Iterator) FetchAll() (items []interface{}, err error) {
 	defer it.Close()
+	it.useArena = false
 	if !it.Next() {
 		return nil, it.err
 	}
@@ -366,6 +552,7 @@ func (it *Iterator) FetchAll() (items []interface{}, err error) {
 // When it's impossible (count is 0) err will be ErrNotFound.
 func (it *Iterator) FetchOne() (item interface{}, err error) {
 	defer it.Close()
+	it.useArena = false
 	if it.Next() {
 		return it.Object(), it.err
 	}
@@ -379,6 +566,7 @@ func (it *Iterator) FetchOne() (item interface{}, err error) {
 // Closes iterator after use.
 func (it *Iterator) FetchAllWithRank() (items []interface{}, ranks []int, err error) {
 	defer it.Close()
+	it.useArena = false
 	if !it.Next() {
 		return nil, nil, it.err
 	}
@@ -427,9 +615,13 @@ func (it *Iterator) GetAggreatedValue(idx int) float64 {
 
 // GetExplainResults returns JSON bytes with explain results
 func (it *Iterator) GetExplainResults() (*ExplainResults, error) {
-	if len(it.rawQueryParams.explainResults) > 0 {
+	return parseExplainResults(it.rawQueryParams.explainResults)
+}
+
+func parseExplainResults(raw []byte) (*ExplainResults, error) {
+	if len(raw) > 0 {
 		explain := &ExplainResults{}
-		if err := json.Unmarshal(it.rawQueryParams.explainResults, explain); err != nil {
+		if err := json.Unmarshal(raw, explain); err != nil {
 			return nil, fmt.Errorf("Explain query results is broken: %v", err)
 		}
 		return explain, nil
@@ -437,6 +629,18 @@ func (it *Iterator) GetExplainResults() (*ExplainResults, error) {
 	return nil, nil
 }
 
+// StateToken returns token of namespace state, captured at query execution. Pass it to Query.RequireState of the next query
+// to detect namespace changes. For servers, which don't support state tokens, it's read from memstats before query execution (best-effort)
+func (it *Iterator) StateToken() (StateToken, error) {
+	if it.err != nil {
+		return "", it.err
+	}
+	if len(it.nsArray) == 0 {
+		return "", errIteratorNotReady
+	}
+	return stateToken(it.rawQueryParams.nsStateLSN)
+}
+
 // Error returns query error if it's present.
 func (it *Iterator) Error() error {
 	return it.err
@@ -444,9 +648,22 @@ func (it *Iterator) Error() error {
 
 // Close closes the iterator and freed CGO resources
 func (it *Iterator) Close() {
+	if it.op != nil {
+		it.op.finish(it.db, it.err)
+		it.op = nil
+	}
+	if it.cancel != nil {
+		it.cancel()
+		it.cancel = nil
+	}
 	if it.result != nil {
+		it.releaseBuffer()
 		it.result.Free()
 		it.result = nil
+		if it.useArena {
+			it.arena.Reset()
+			it.useArena = false
+		}
 		if it.query != nil {
 			it.query.close()
 		}
@@ -472,6 +689,11 @@ type JSONIterator struct {
 	ptr         int
 	explain     []byte
 	userCtx     context.Context
+	cancel      context.CancelFunc
+	db          *reindexerImpl
+	namespace   string
+	nsStateLSN  int64
+	bufferBytes int // size of the retained json, accounted in db.resultBufferBytes
 }
 
 // Next moves iterator pointer to the next element.
@@ -514,6 +736,14 @@ func (it *JSONIterator) GetExplainResults() (*ExplainResults, error) {
 	return nil, nil
 }
 
+// StateToken returns token of namespace state, captured at query execution. See Iterator.StateToken
+func (it *JSONIterator) StateToken() (StateToken, error) {
+	if it.err != nil {
+		return "", it.err
+	}
+	return stateToken(it.nsStateLSN)
+}
+
 // Count returns count if query results
 func (it *JSONIterator) Count() int {
 	return len(it.jsonOffsets)
@@ -526,6 +756,12 @@ func (it *JSONIterator) Error() error {
 
 // Close closes the iterator.
 func (it *JSONIterator) Close() {
+	if it.cancel != nil {
+		it.cancel()
+		it.cancel = nil
+	}
+	it.db.releaseResultBytes(it.bufferBytes)
+	it.bufferBytes = 0
 	if it.query != nil {
 		it.query.close()
 		it.query = nil
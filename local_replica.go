@@ -0,0 +1,446 @@
+package reindexer
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+const (
+	defaultReplicaPollInterval = time.Second
+	// defaultReplicaStalePolls - default MaxStaleness of local replica in poll intervals
+	defaultReplicaStalePolls = 5
+)
+
+// LocalReplicaOptions - options of NamespaceOptions.LocalReplica
+type LocalReplicaOptions struct {
+	// PollInterval - interval of checks of namespace state token (default 1s). Replica is reloaded, when the token is changed.
+	// If server doesn't report state tokens, replica is reloaded each interval
+	PollInterval time.Duration
+	// MaxStaleness - queries are sent to server, if replica wasn't checked or reloaded for longer time (default 5 poll intervals)
+	MaxStaleness time.Duration
+}
+
+// localReplica - copy of all the items of namespace, which serves simple queries (see NamespaceOptions.LocalReplica)
+type localReplica struct {
+	opts LocalReplicaOptions
+	lock sync.RWMutex
+	// items in order of server, state token of items (false - server doesn't report it)
+	items    []interface{}
+	token    StateToken
+	hasToken bool
+	// items are loaded after the last write of this client, time of the last successful check of items
+	valid   bool
+	checked time.Time
+	// epoch is changed by each write of this client
+	epoch uint64
+
+	kickCh    chan struct{}
+	stopCh    chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// replicaQuery - query, which is executed by local replica
+type replicaQuery struct {
+	where    []replicaCond
+	sort     []replicaSort
+	offset   int
+	limit    int
+	reqTotal bool
+}
+
+// replicaCond - EQ or SET condition, which is matched by any of keys
+type replicaCond struct {
+	path string
+	keys []interface{}
+}
+
+type replicaSort struct {
+	path string
+	desc bool
+}
+
+func newLocalReplica(opts LocalReplicaOptions) *localReplica {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultReplicaPollInterval
+	}
+	if opts.MaxStaleness <= 0 {
+		opts.MaxStaleness = defaultReplicaStalePolls * opts.PollInterval
+	}
+	return &localReplica{opts: opts, kickCh: make(chan struct{}, 1), stopCh: make(chan struct{})}
+}
+
+// start loads replica of opened namespace and starts its refresh. Queries are sent to server, if load fails, until it's reloaded
+func (r *localReplica) start(ctx context.Context, db *reindexerImpl, ns *reindexerNamespace) {
+	if r == nil {
+		return
+	}
+	r.startOnce.Do(func() {
+		r.reload(ctx, db, ns)
+		go r.run(db, ns)
+	})
+}
+
+func (r *localReplica) stop() {
+	if r == nil {
+		return
+	}
+	r.stopOnce.Do(func() { close(r.stopCh) })
+}
+
+// invalidate is called before and after each write of this client: queries are sent to server until replica is reloaded after the write
+func (r *localReplica) invalidate() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	r.epoch++
+	r.valid = false
+	r.lock.Unlock()
+	select {
+	case r.kickCh <- struct{}{}:
+	default:
+	}
+}
+
+// run refreshes replica each poll interval and after writes of this client, until namespace is closed
+func (r *localReplica) run(db *reindexerImpl, ns *reindexerNamespace) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+		case <-r.kickCh:
+		}
+		if cur, err := db.getNS(ns.name); err != nil || cur != ns {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.opts.MaxStaleness)
+		// Replica, which can't be refreshed, becomes stale after MaxStaleness
+		r.refresh(ctx, db, ns)
+		cancel()
+	}
+}
+
+// refresh checks state token of namespace and reloads replica, if it's changed, invalidated or the token isn't known
+func (r *localReplica) refresh(ctx context.Context, db *reindexerImpl, ns *reindexerNamespace) error {
+	r.lock.RLock()
+	valid, hasToken, token, epoch := r.valid, r.hasToken, r.token, r.epoch
+	r.lock.RUnlock()
+	if valid && hasToken {
+		start := time.Now()
+		actual, err := db.query(ns.name).StateToken(ctx)
+		if err != nil {
+			return err
+		}
+		if actual == token {
+			r.lock.Lock()
+			if r.epoch == epoch {
+				r.checked = start
+			}
+			r.lock.Unlock()
+			return nil
+		}
+	}
+	return r.reload(ctx, db, ns)
+}
+
+// reload replaces items of replica by all the items of namespace. Items, which were loaded concurrently with write of this client, are discarded
+func (r *localReplica) reload(ctx context.Context, db *reindexerImpl, ns *reindexerNamespace) error {
+	r.lock.RLock()
+	epoch := r.epoch
+	r.lock.RUnlock()
+	start := time.Now()
+	// Objects of replica are decoded without object cache, so they aren't shared with results of the other queries
+	q := db.query(ns.name).BypassObjCache(true).BypassLocalReplica()
+	it := q.ExecCtx(ctx)
+	defer it.Close()
+	var items []interface{}
+	for it.Next() {
+		items = append(items, it.Object())
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	token, tokenErr := it.StateToken()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.epoch != epoch {
+		select {
+		case r.kickCh <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	r.items, r.token, r.hasToken = items, token, tokenErr == nil
+	r.valid, r.checked = true, start
+	return nil
+}
+
+// serve executes query by replica. Returns false, if query isn't supported by replica or replica is stale
+func (r *localReplica) serve(ns *reindexerNamespace, q *Query) (res bindings.CachedResult, lsn int64, ok bool) {
+	if r == nil {
+		return res, -1, false
+	}
+	rq, ok := ns.replicaQuery(q)
+	if !ok {
+		return res, -1, false
+	}
+	r.lock.RLock()
+	if !r.valid || time.Since(r.checked) > r.opts.MaxStaleness {
+		r.lock.RUnlock()
+		return res, -1, false
+	}
+	items, token, hasToken := r.items, r.token, r.hasToken
+	r.lock.RUnlock()
+
+	matched := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if rq.match(item) {
+			matched = append(matched, item)
+		}
+	}
+	if len(rq.sort) != 0 {
+		sort.SliceStable(matched, func(i, j int) bool { return rq.less(matched[i], matched[j]) })
+	}
+	if rq.reqTotal {
+		res.TotalCount = len(matched)
+	}
+	if rq.offset >= len(matched) {
+		matched = matched[:0]
+	} else {
+		matched = matched[rq.offset:]
+	}
+	if rq.limit >= 0 && rq.limit < len(matched) {
+		matched = matched[:rq.limit]
+	}
+	res.Items = matched
+	lsn = -1
+	if hasToken {
+		lsn, _ = token.lsn()
+	}
+	return res, lsn, true
+}
+
+// replicaQuery converts query to replicaQuery. Returns false for queries, which are not supported by local replica:
+// conditions other than AND of EQ and SET, conditions and sorting by fields, which are not scalar indexes without collation,
+// joins, merges, aggregations, select filters and functions, explain, fulltext and the other options, which are sent to server
+func (ns *reindexerNamespace) replicaQuery(q *Query) (rq replicaQuery, ok bool) {
+	if q.noReplica || q.tx != nil || len(q.joinQueries) != 0 || len(q.mergedQueries) != 0 || len(q.requiredState) != 0 ||
+		len(q.relTimes) != 0 || q.stableSort || q.includeDeleted || q.usageSampler != nil {
+		return rq, false
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			ok = false
+		}
+	}()
+	ser := cjson.NewSerializer(q.ser.Bytes())
+	ser.GetVString()
+	rq.limit = -1
+	for !ser.Eof() {
+		switch tag := int(ser.GetVarUInt()); tag {
+		case queryCondition:
+			name, op, cond := ser.GetVString(), int(ser.GetVarUInt()), int(ser.GetVarUInt())
+			path, t, indexed := ns.replicaField(name)
+			if !indexed || op != opAND || (cond != EQ && cond != SET) {
+				return rq, false
+			}
+			c := replicaCond{path: path, keys: make([]interface{}, ser.GetVarUInt())}
+			for i := range c.keys {
+				if c.keys[i], ok = replicaKey(&ser, t); !ok {
+					return rq, false
+				}
+			}
+			rq.where = append(rq.where, c)
+		case querySortIndex:
+			name, desc := ser.GetVString(), ser.GetVarUInt() != 0
+			path, _, indexed := ns.replicaField(name)
+			if !indexed || ser.GetVarUInt() != 0 {
+				// Sorting by forced values isn't supported
+				return rq, false
+			}
+			rq.sort = append(rq.sort, replicaSort{path: path, desc: desc})
+		case queryLimit:
+			rq.limit = int(ser.GetVarUInt())
+		case queryOffset:
+			rq.offset = int(ser.GetVarUInt())
+		case queryReqTotal:
+			rq.reqTotal = ser.GetVarUInt() != modeNoCalc
+		case queryDebugLevel:
+			ser.GetVarUInt()
+		default:
+			return rq, false
+		}
+	}
+	return rq, true
+}
+
+// replicaField returns JSON path and type of field of index, which may be used by local replica: scalar index of field without collation
+func (ns *reindexerNamespace) replicaField(index string) (string, reflect.Type, bool) {
+	for _, indexDef := range ns.indexes {
+		if !strings.EqualFold(indexDef.Name, index) {
+			continue
+		}
+		if len(indexDef.JSONPaths) != 1 || indexDef.IsArray || indexDef.IsSparse || len(indexDef.CollateMode) != 0 {
+			return "", nil, false
+		}
+		switch indexDef.IndexType {
+		case "", "hash", "tree", "-":
+		default:
+			return "", nil, false
+		}
+		t, ok := cjson.FieldTypeByPath(ns.rtype, indexDef.JSONPaths[0])
+		if !ok {
+			return "", nil, false
+		}
+		switch t.Kind() {
+		case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return indexDef.JSONPaths[0], t, true
+		}
+		return "", nil, false
+	}
+	return "", nil, false
+}
+
+// replicaKey reads value of condition and converts it to the type of field. Returns false, if value is not comparable with the field
+func replicaKey(ser *cjson.Serializer, t reflect.Type) (interface{}, bool) {
+	switch ser.GetVarUInt() {
+	case valueInt, valueInt64:
+		v := ser.GetVarInt()
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return float64(v), true
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return v, true
+		}
+	case valueDouble:
+		v := ser.GetDouble()
+		if k := t.Kind(); k == reflect.Float32 || k == reflect.Float64 {
+			return v, true
+		}
+	case valueString:
+		v := ser.GetVString()
+		if t.Kind() == reflect.String {
+			return v, true
+		}
+	case valueBool:
+		v := ser.GetVarUInt() != 0
+		if t.Kind() == reflect.Bool {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// replicaValue returns value of field of item in the type of replicaKey
+func replicaValue(item interface{}, path string) interface{} {
+	v, ok := cjson.FieldValueByPath(reflect.ValueOf(item), path)
+	if !ok {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.String:
+		return v.String()
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// Values are stored by server as signed integers
+		return int64(v.Uint())
+	}
+	return nil
+}
+
+func compareReplicaValues(a, b interface{}) int {
+	switch av := a.(type) {
+	case bool:
+		if bv := b.(bool); av != bv {
+			if av {
+				return 1
+			}
+			return -1
+		}
+	case string:
+		return strings.Compare(av, b.(string))
+	case float64:
+		if bv := b.(float64); av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	case int64:
+		if bv := b.(int64); av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (rq *replicaQuery) match(item interface{}) bool {
+	for _, c := range rq.where {
+		v := replicaValue(item, c.path)
+		matched := false
+		for _, key := range c.keys {
+			if v != nil && compareReplicaValues(v, key) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (rq *replicaQuery) less(a, b interface{}) bool {
+	for _, s := range rq.sort {
+		va, vb := replicaValue(a, s.path), replicaValue(b, s.path)
+		if va == nil || vb == nil {
+			continue
+		}
+		if cmp := compareReplicaValues(va, vb); cmp != 0 {
+			return (cmp < 0) != s.desc
+		}
+	}
+	return false
+}
+
+// replicaIterator returns iterator of query, which is served by local replica of its namespace, or nil, if query must be sent to server
+func (db *reindexerImpl) replicaIterator(ctx context.Context, q *Query) *Iterator {
+	ns, err := db.getNS(q.Namespace)
+	if err != nil || ns.replica == nil {
+		return nil
+	}
+	res, lsn, ok := ns.replica.serve(ns, q)
+	if !ok {
+		return nil
+	}
+	q.stateLSN = lsn
+	return db.cachedIterator(ctx, q, res)
+}
+
+// BypassLocalReplica - query is sent to server, even if it may be served by local replica of namespace (see NamespaceOptions.LocalReplica)
+func (q *Query) BypassLocalReplica() *Query {
+	q.noReplica = true
+	return q
+}
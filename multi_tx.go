@@ -0,0 +1,527 @@
+package reindexer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+const (
+	multiTxJournalVersion = 1
+	// journal of multi-namespace transaction is stored in meta of its first namespace by key multiTxMetaPrefix+ID,
+	// IDs of not completed journals of the namespace are stored by key multiTxPendingKey
+	multiTxMetaPrefix = "multitx:"
+	multiTxPendingKey = "multitx:pending"
+)
+
+// multiTxOp - modification of journal. Item is JSON document
+type multiTxOp struct {
+	Mode     int             `json:"mode"`
+	Item     json.RawMessage `json:"item"`
+	Precepts []string        `json:"precepts,omitempty"`
+}
+
+// multiTxStep - changes of namespace in journal and flags, which are set after they are applied or compensated
+type multiTxStep struct {
+	Namespace     string      `json:"namespace"`
+	Ops           []multiTxOp `json:"ops"`
+	Compensations []multiTxOp `json:"compensations,omitempty"`
+	Committed     bool        `json:"committed,omitempty"`
+	Compensated   bool        `json:"compensated,omitempty"`
+}
+
+// multiTxJournal - journal of multi-namespace transaction. Steps are in order of commit
+type multiTxJournal struct {
+	Version      int           `json:"version"`
+	ID           string        `json:"id"`
+	Compensating bool          `json:"compensating,omitempty"`
+	Steps        []multiTxStep `json:"steps"`
+}
+
+// MultiTx - multi-namespace transaction, which is made by BeginMultiTx. It's not thread safe.
+// Changes of each namespace are applied atomically by transaction of the namespace, but changes of different namespaces
+// are not: they are committed one by one and become visible to the other clients at different moments.
+// Precepts are rejected, because changes may be applied again by RecoverMultiTx
+type MultiTx struct {
+	db       *reindexerImpl
+	ctx      context.Context
+	journal  multiTxJournal
+	txs      []*Tx
+	finished bool
+}
+
+// ErrMultiTx - error of MultiTx commit
+type ErrMultiTx struct {
+	ID string
+	// Namespace - namespace, which changes were not applied
+	Namespace string
+	// Committed - namespaces, which changes were applied and were not compensated
+	Committed []string
+	// Pending - journal is kept, changes are applied or compensated by RecoverMultiTx
+	Pending bool
+	Err     error
+}
+
+func (e *ErrMultiTx) Error() string {
+	if e.Pending {
+		return fmt.Sprintf("rq: multi-namespace transaction '%s' is not completed on namespace '%s' (committed: %v), it's completed by recovery: %v", e.ID, e.Namespace, e.Committed, e.Err)
+	}
+	return fmt.Sprintf("rq: multi-namespace transaction '%s' is aborted on namespace '%s': %v", e.ID, e.Namespace, e.Err)
+}
+
+func (e *ErrMultiTx) Code() int {
+	if rerr, ok := e.Err.(Error); ok {
+		return rerr.Code()
+	}
+	return ErrCodeLogic
+}
+
+func (e *ErrMultiTx) Unwrap() error {
+	return e.Err
+}
+
+// MultiTxRecovery - result of recovery of multi-namespace transaction
+type MultiTxRecovery struct {
+	ID string
+	// Reapplied - namespaces, which changes were applied by recovery, Compensated - namespaces, which changes were compensated
+	Reapplied   []string
+	Compensated []string
+	// Rejected - error, which rejected changes of namespace, if changes were compensated
+	Rejected error
+}
+
+var errMultiTxFinished = bindings.NewError("rq: Multi-namespace transaction is already committed or rolled back", ErrCodeParams)
+
+// isMultiTxPending returns true, if result of operation is not known, so journal must be kept for recovery
+func isMultiTxPending(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return true
+	}
+	if rerr, ok := err.(Error); ok {
+		switch rerr.Code() {
+		case ErrCodeNetwork, ErrCodeTimeout, bindings.ErrCanceled:
+			return true
+		}
+	}
+	return false
+}
+
+func newMultiTxID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (db *reindexerImpl) beginMultiTx(ctx context.Context, namespaces []string) (*MultiTx, error) {
+	if len(namespaces) == 0 {
+		return nil, ErrEmptyNamespace
+	}
+	id, err := newMultiTxID()
+	if err != nil {
+		return nil, err
+	}
+	mtx := &MultiTx{db: db, ctx: ctx, journal: multiTxJournal{Version: multiTxJournalVersion, ID: id}}
+	for _, namespace := range namespaces {
+		if mtx.step(namespace) >= 0 {
+			mtx.rollback()
+			return nil, bindings.NewError(fmt.Sprintf("rq: Namespace '%s' is passed to multi-namespace transaction twice", namespace), ErrCodeParams)
+		}
+		tx, err := db.beginTx(ctx, namespace)
+		if err != nil {
+			mtx.rollback()
+			return nil, err
+		}
+		mtx.txs = append(mtx.txs, tx)
+		mtx.journal.Steps = append(mtx.journal.Steps, multiTxStep{Namespace: namespace})
+	}
+	return mtx, nil
+}
+
+// ID returns ID of transaction, which is its ID in journal
+func (mtx *MultiTx) ID() string {
+	return mtx.journal.ID
+}
+
+// step returns index of namespace in transaction, or -1
+func (mtx *MultiTx) step(namespace string) int {
+	for i := range mtx.journal.Steps {
+		if strings.EqualFold(mtx.journal.Steps[i].Namespace, namespace) {
+			return i
+		}
+	}
+	return -1
+}
+
+// multiTxItem returns JSON document of item: JSON of []byte is returned as is, the other values are marshaled with encoding/json
+func multiTxItem(item interface{}) (json.RawMessage, error) {
+	if data, ok := item.([]byte); ok {
+		if !json.Valid(data) {
+			return nil, bindings.NewError("rq: Invalid JSON document in multi-namespace transaction", ErrCodeParams)
+		}
+		return append(json.RawMessage(nil), data...), nil
+	}
+	return json.Marshal(item)
+}
+
+func (mtx *MultiTx) stage(namespace string, item interface{}, mode int, compensation bool, precepts []string) error {
+	if mtx.finished {
+		return errMultiTxFinished
+	}
+	i := mtx.step(namespace)
+	if i < 0 {
+		return bindings.NewError(fmt.Sprintf("rq: Namespace '%s' is not in multi-namespace transaction", namespace), ErrCodeParams)
+	}
+	if len(precepts) != 0 {
+		// Recovery may apply changes twice, and precepts (e.g. serial() or now()) would be evaluated again with the other values
+		return bindings.NewError("rq: Precepts are not supported by multi-namespace transaction", ErrCodeParams)
+	}
+	data, err := multiTxItem(item)
+	if err != nil {
+		return err
+	}
+	op := multiTxOp{Mode: mode, Item: data, Precepts: append([]string(nil), precepts...)}
+	step := &mtx.journal.Steps[i]
+	if compensation {
+		step.Compensations = append(step.Compensations, op)
+		return nil
+	}
+	// Transaction gets the same JSON, as journal, so recovery applies exactly the same changes
+	if err = mtx.txs[i].modifyInternal(nil, data, mode, precepts...); err != nil {
+		return err
+	}
+	step.Ops = append(step.Ops, op)
+	return nil
+}
+
+// Insert item to namespace
+func (mtx *MultiTx) Insert(namespace string, item interface{}, precepts ...string) error {
+	return mtx.stage(namespace, item, modeInsert, false, precepts)
+}
+
+// Update item of namespace
+func (mtx *MultiTx) Update(namespace string, item interface{}, precepts ...string) error {
+	return mtx.stage(namespace, item, modeUpdate, false, precepts)
+}
+
+// Upsert (Insert or Update) item to namespace
+func (mtx *MultiTx) Upsert(namespace string, item interface{}, precepts ...string) error {
+	return mtx.stage(namespace, item, modeUpsert, false, precepts)
+}
+
+// Delete item from namespace
+func (mtx *MultiTx) Delete(namespace string, item interface{}, precepts ...string) error {
+	return mtx.stage(namespace, item, modeDelete, false, precepts)
+}
+
+// InsertJSON inserts JSON document to namespace
+func (mtx *MultiTx) InsertJSON(namespace string, json []byte, precepts ...string) error {
+	return mtx.stage(namespace, json, modeInsert, false, precepts)
+}
+
+// UpdateJSON updates item of namespace by JSON document
+func (mtx *MultiTx) UpdateJSON(namespace string, json []byte, precepts ...string) error {
+	return mtx.stage(namespace, json, modeUpdate, false, precepts)
+}
+
+// UpsertJSON (Insert or Update) JSON document to namespace
+func (mtx *MultiTx) UpsertJSON(namespace string, json []byte, precepts ...string) error {
+	return mtx.stage(namespace, json, modeUpsert, false, precepts)
+}
+
+// DeleteJSON removes item of JSON document from namespace
+func (mtx *MultiTx) DeleteJSON(namespace string, json []byte) error {
+	return mtx.stage(namespace, json, modeDelete, false, nil)
+}
+
+// CompensateUpsert adds upsert of item (value or JSON document as []byte) to namespace, which is made, if changes of namespace
+// were committed, but the other namespace rejected its changes
+func (mtx *MultiTx) CompensateUpsert(namespace string, item interface{}) error {
+	return mtx.stage(namespace, item, modeUpsert, true, nil)
+}
+
+// CompensateDelete adds delete of item (value or JSON document as []byte) from namespace, which is made, if changes of namespace
+// were committed, but the other namespace rejected its changes
+func (mtx *MultiTx) CompensateDelete(namespace string, item interface{}) error {
+	return mtx.stage(namespace, item, modeDelete, true, nil)
+}
+
+// Rollback transactions of all the namespaces. It is safe to call Rollback after Commit
+func (mtx *MultiTx) Rollback() error {
+	if mtx.finished {
+		return nil
+	}
+	mtx.finished = true
+	return mtx.rollback()
+}
+
+func (mtx *MultiTx) rollback() (err error) {
+	for _, tx := range mtx.txs {
+		if tx.finished {
+			continue
+		}
+		if rerr := tx.Rollback(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Commit writes journal and commits transactions of namespaces in order of BeginMultiTx.
+// If commit of namespace is rejected, changes of the already committed namespaces are compensated and *ErrMultiTx is returned.
+// If result of commit or of journal update is not known (e.g. on network error), the rest of transactions are rolled back
+// and *ErrMultiTx with Pending flag is returned: journal is kept and transaction is completed by RecoverMultiTx
+func (mtx *MultiTx) Commit() error {
+	if mtx.finished {
+		return errMultiTxFinished
+	}
+	mtx.finished = true
+	db, ctx, j := mtx.db, mtx.ctx, &mtx.journal
+	db.multiTxActive(j.ID, true)
+	defer db.multiTxActive(j.ID, false)
+
+	// Changes are durable after journal is written, commits are repeated by recovery from this point
+	if err := db.addMultiTxJournal(ctx, j); err != nil {
+		mtx.rollback()
+		return err
+	}
+	for i := range j.Steps {
+		step := &j.Steps[i]
+		_, err := mtx.txs[i].CommitWithCount()
+		if err == nil {
+			step.Committed = true
+			err = db.putMultiTxJournal(ctx, j)
+		}
+		if err != nil {
+			mtx.rollback()
+			if isMultiTxPending(err) {
+				return &ErrMultiTx{ID: j.ID, Namespace: step.Namespace, Committed: j.committed(), Pending: true, Err: err}
+			}
+			if cerr := db.compensateMultiTx(ctx, j, nil); cerr != nil {
+				return &ErrMultiTx{ID: j.ID, Namespace: step.Namespace, Committed: j.committed(), Pending: true, Err: cerr}
+			}
+			return &ErrMultiTx{ID: j.ID, Namespace: step.Namespace, Err: err}
+		}
+	}
+	// Changes are applied, so error of journal removal is not returned: recovery removes it
+	db.removeMultiTxJournal(ctx, j)
+	return nil
+}
+
+// committed returns namespaces, which changes were committed and were not compensated
+func (j *multiTxJournal) committed() (namespaces []string) {
+	for _, step := range j.Steps {
+		if step.Committed && !step.Compensated {
+			namespaces = append(namespaces, step.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// compensateMultiTx applies compensations of committed namespaces in reverse order and removes journal.
+// Compensated namespaces are added to rec, if it's not nil
+func (db *reindexerImpl) compensateMultiTx(ctx context.Context, j *multiTxJournal, rec *MultiTxRecovery) error {
+	if !j.Compensating {
+		j.Compensating = true
+		if err := db.putMultiTxJournal(ctx, j); err != nil {
+			return err
+		}
+	}
+	for i := len(j.Steps) - 1; i >= 0; i-- {
+		step := &j.Steps[i]
+		if !step.Committed || step.Compensated {
+			continue
+		}
+		if err := db.applyMultiTxOps(ctx, step.Namespace, step.Compensations); err != nil {
+			return err
+		}
+		step.Compensated = true
+		if err := db.putMultiTxJournal(ctx, j); err != nil {
+			return err
+		}
+		if rec != nil {
+			rec.Compensated = append(rec.Compensated, step.Namespace)
+		}
+	}
+	return db.removeMultiTxJournal(ctx, j)
+}
+
+// applyMultiTxOps applies ops to namespace by transaction
+func (db *reindexerImpl) applyMultiTxOps(ctx context.Context, namespace string, ops []multiTxOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	tx, err := db.beginTx(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		if err = tx.modifyInternal(nil, op.Item, op.Mode, op.Precepts...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// multiTxActive marks journal as used by commit of this client, so recovery skips it
+func (db *reindexerImpl) multiTxActive(id string, active bool) {
+	db.multiTxLock.Lock()
+	defer db.multiTxLock.Unlock()
+	if !active {
+		delete(db.multiTxs, id)
+		return
+	}
+	if db.multiTxs == nil {
+		db.multiTxs = make(map[string]struct{})
+	}
+	db.multiTxs[id] = struct{}{}
+}
+
+func (db *reindexerImpl) putMultiTxJournal(ctx context.Context, j *multiTxJournal) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return db.putMeta(ctx, j.Steps[0].Namespace, multiTxMetaPrefix+j.ID, data)
+}
+
+// pendingMultiTxs returns IDs of not completed journals of namespace. Must be called under multiTxLock
+func (db *reindexerImpl) pendingMultiTxs(ctx context.Context, namespace string) (ids []string, err error) {
+	data, err := db.getMeta(ctx, namespace, multiTxPendingKey)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("rq: invalid list of multi-namespace transactions of namespace '%s': %v", namespace, err)
+	}
+	return ids, nil
+}
+
+// updatePendingMultiTxs adds or removes ID of journal in list of not completed journals of its namespace
+func (db *reindexerImpl) updatePendingMultiTxs(ctx context.Context, j *multiTxJournal, add bool) error {
+	namespace := j.Steps[0].Namespace
+	db.multiTxLock.Lock()
+	defer db.multiTxLock.Unlock()
+	ids, err := db.pendingMultiTxs(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	updated := ids[:0]
+	for _, id := range ids {
+		if id != j.ID {
+			updated = append(updated, id)
+		}
+	}
+	if add {
+		updated = append(updated, j.ID)
+	}
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return err
+	}
+	return db.putMeta(ctx, namespace, multiTxPendingKey, data)
+}
+
+func (db *reindexerImpl) addMultiTxJournal(ctx context.Context, j *multiTxJournal) error {
+	if err := db.putMultiTxJournal(ctx, j); err != nil {
+		return err
+	}
+	return db.updatePendingMultiTxs(ctx, j, true)
+}
+
+// removeMultiTxJournal removes journal from list and then clears it (meta can't be deleted)
+func (db *reindexerImpl) removeMultiTxJournal(ctx context.Context, j *multiTxJournal) error {
+	if err := db.updatePendingMultiTxs(ctx, j, false); err != nil {
+		return err
+	}
+	return db.putMeta(ctx, j.Steps[0].Namespace, multiTxMetaPrefix+j.ID, nil)
+}
+
+// recoverMultiTx completes not completed journals of opened namespaces, except the ones of running commits of this client
+func (db *reindexerImpl) recoverMultiTx(ctx context.Context) (recs []MultiTxRecovery, err error) {
+	db.lock.RLock()
+	namespaces := make([]string, 0, len(db.ns))
+	for _, ns := range db.ns {
+		if !IsSystemNamespace(ns.name) {
+			namespaces = append(namespaces, ns.name)
+		}
+	}
+	db.lock.RUnlock()
+
+	for _, namespace := range namespaces {
+		db.multiTxLock.Lock()
+		ids, err := db.pendingMultiTxs(ctx, namespace)
+		var active []bool
+		for _, id := range ids {
+			_, ok := db.multiTxs[id]
+			active = append(active, ok)
+		}
+		db.multiTxLock.Unlock()
+		if err != nil {
+			return recs, err
+		}
+		for i, id := range ids {
+			if active[i] {
+				continue
+			}
+			rec, err := db.recoverMultiTxJournal(ctx, namespace, id)
+			if rec != nil {
+				recs = append(recs, *rec)
+			}
+			if err != nil {
+				return recs, err
+			}
+		}
+	}
+	return recs, nil
+}
+
+// recoverMultiTxJournal applies not committed changes of journal, or compensates committed ones, if changes are rejected
+func (db *reindexerImpl) recoverMultiTxJournal(ctx context.Context, namespace string, id string) (*MultiTxRecovery, error) {
+	data, err := db.getMeta(ctx, namespace, multiTxMetaPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	var j multiTxJournal
+	if len(data) == 0 {
+		// Journal was cleared, but was not removed from list
+		j = multiTxJournal{ID: id, Steps: []multiTxStep{{Namespace: namespace}}}
+		return nil, db.updatePendingMultiTxs(ctx, &j, false)
+	}
+	if err = json.Unmarshal(data, &j); err != nil {
+		return nil, fmt.Errorf("rq: invalid journal of multi-namespace transaction '%s': %v", id, err)
+	}
+	if j.Version > multiTxJournalVersion || len(j.Steps) == 0 {
+		return nil, fmt.Errorf("rq: unsupported version %d of journal of multi-namespace transaction '%s'", j.Version, id)
+	}
+	rec := &MultiTxRecovery{ID: id}
+	if !j.Compensating {
+		for i := range j.Steps {
+			step := &j.Steps[i]
+			if step.Committed {
+				continue
+			}
+			if err = db.applyMultiTxOps(ctx, step.Namespace, step.Ops); err != nil {
+				if isMultiTxPending(err) {
+					return rec, err
+				}
+				rec.Rejected = err
+				return rec, db.compensateMultiTx(ctx, &j, rec)
+			}
+			step.Committed = true
+			if err = db.putMultiTxJournal(ctx, &j); err != nil {
+				return rec, err
+			}
+			rec.Reapplied = append(rec.Reapplied, step.Namespace)
+		}
+		return rec, db.removeMultiTxJournal(ctx, &j)
+	}
+	return rec, db.compensateMultiTx(ctx, &j, rec)
+}
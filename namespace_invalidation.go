@@ -0,0 +1,132 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// serverStateChanged records token of server tags state from results of namespace.
+// Returns true, if another token was seen before: namespace was recreated on server
+func (ns *reindexerNamespace) serverStateChanged(token int32) bool {
+	prev := atomic.LoadInt32(&ns.serverStateToken)
+	if prev == token || !atomic.CompareAndSwapInt32(&ns.serverStateToken, prev, token) {
+		return false
+	}
+	if prev < 0 {
+		// Namespace is found again after it was reported as missing
+		atomic.StoreInt32(&ns.invalidated, 0)
+		return false
+	}
+	return true
+}
+
+// missingOnServer returns true, if err is the first server error, which reports, that namespace doesn't exist.
+// System namespaces are not registered by user, so they are never reported
+func (ns *reindexerNamespace) missingOnServer(err error) bool {
+	if IsSystemNamespace(ns.name) {
+		return false
+	}
+	rerr, ok := err.(bindings.Error)
+	if !ok || (rerr.Code() != bindings.ErrParams && rerr.Code() != bindings.ErrNotFound) {
+		return false
+	}
+	if !strings.Contains(strings.ToLower(rerr.Error()), "namespace '"+ns.name+"' does not exist") {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&ns.invalidated, 0, 1) {
+		return false
+	}
+	atomic.StoreInt32(&ns.serverStateToken, -1)
+	return true
+}
+
+func errNsRecreated(ns *reindexerNamespace) error {
+	return bindings.NewError(fmt.Sprintf("rq: Namespace '%s' is recreated on server", ns.name), ErrCodeStateInvalidated)
+}
+
+// observeNsState checks token of server tags state in results of namespace. It may be called while results are unpacked
+func (db *reindexerImpl) observeNsState(ns *reindexerNamespace, token int32) {
+	if ns.serverStateChanged(token) {
+		db.invalidateNamespaceAsync(ns, errNsRecreated(ns))
+	}
+}
+
+// checkNsError checks, whether err of request reports, that one of the namespaces doesn't exist on server
+func (db *reindexerImpl) checkNsError(ctx context.Context, err error, nsArray ...*reindexerNamespace) {
+	if err == nil {
+		return
+	}
+	for _, ns := range nsArray {
+		if ns.missingOnServer(err) {
+			db.invalidateNamespace(ctx, ns, err)
+			return
+		}
+	}
+}
+
+// invalidateNamespace flushes client-side state of namespace, which is changed on server, calls invalidation handler and reopens namespace
+func (db *reindexerImpl) invalidateNamespace(ctx context.Context, ns *reindexerNamespace, reason error) {
+	ns.flushState()
+	db.notifyNsInvalidated(ctx, ns, reason)
+}
+
+// invalidateNamespaceAsync flushes client-side state of namespace at once, so the results being unpacked don't get stale objects,
+// but calls invalidation handler and reopens namespace in background. Background invalidations of namespace are not run concurrently
+func (db *reindexerImpl) invalidateNamespaceAsync(ns *reindexerNamespace, reason error) {
+	ns.flushState()
+	if !atomic.CompareAndSwapInt32(&ns.invalidating, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&ns.invalidating, 0)
+		db.notifyNsInvalidated(context.Background(), ns, reason)
+	}()
+}
+
+// flushState flushes object cache and cjson state of namespace
+func (ns *reindexerNamespace) flushState() {
+	ns.cacheLock.Lock()
+	if ns.cacheItems != nil {
+		ns.cacheItems = make(map[int]cacheItem)
+	}
+	ns.cacheLock.Unlock()
+	ns.cjsonState.Reset()
+	ns.upsertDedup.flush()
+	ns.replica.invalidate()
+}
+
+// notifyNsInvalidated calls invalidation handler and reopens namespace, if it's requested by options
+func (db *reindexerImpl) notifyNsInvalidated(ctx context.Context, ns *reindexerNamespace, reason error) {
+	db.lock.Lock()
+	registered := db.ns[ns.name] == ns
+	if registered {
+		// Namespace must be opened on server again by the next OpenNamespace
+		ns.opened = false
+	}
+	db.lock.Unlock()
+
+	opt := db.onNsInvalidated
+	if opt.Handler != nil {
+		opt.Handler(ns.name, reason)
+	}
+	if !opt.Reopen {
+		return
+	}
+	if !registered {
+		// Namespace was dropped or closed by this client
+		return
+	}
+	opts := ns.opts
+	if err := db.openNamespace(ctx, ns.name, &opts, reflect.New(ns.rtype).Elem().Interface()); err != nil {
+		if opt.Handler != nil {
+			opt.Handler(ns.name, err)
+		}
+		return
+	}
+	atomic.StoreInt32(&ns.invalidated, 0)
+}
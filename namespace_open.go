@@ -0,0 +1,142 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// ErrNamespaceStructMismatch is returned by OpenNamespace and RegisterNamespace, when namespace is already registered with another type
+type ErrNamespaceStructMismatch struct {
+	Namespace string
+	// Type, which namespace is registered with
+	Registered string
+	// Type, which was passed to the call
+	Requested string
+}
+
+func (e ErrNamespaceStructMismatch) Error() string {
+	return fmt.Sprintf("rq: Namespace '%s' is already registered with type %s, not %s", e.Namespace, e.Registered, e.Requested)
+}
+
+func (e ErrNamespaceStructMismatch) Code() int {
+	return ErrCodeParams
+}
+
+// nsOpenCall is open of namespace, which is shared by concurrent OpenNamespace calls
+type nsOpenCall struct {
+	rtype reflect.Type
+	done  chan struct{}
+	err   error
+}
+
+func nsStructType(s interface{}) reflect.Type {
+	t := reflect.TypeOf(s)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func nsStructMismatch(namespace string, registered, requested reflect.Type) error {
+	return ErrNamespaceStructMismatch{Namespace: namespace, Registered: registered.String(), Requested: requested.String()}
+}
+
+// openNamespace opens namespace once for concurrent callers: they wait for the running open and get its result.
+// Namespace, which is already opened by this client with the same type and options, is not opened again
+func (db *reindexerImpl) openNamespace(ctx context.Context, namespace string, opts *NamespaceOptions, s interface{}) error {
+	namespace = strings.ToLower(namespace)
+	t := nsStructType(s)
+	if err := checkSystemOpen(namespace, t); err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	if ns, ok := db.ns[namespace]; ok {
+		if ns.rtype != t {
+			db.lock.Unlock()
+			return db.structMismatch(namespace, opts, ns.rtype, t)
+		}
+		if ns.opened && ns.opts == *opts {
+			db.lock.Unlock()
+			return nil
+		}
+	}
+	if call, ok := db.nsOpening[namespace]; ok {
+		db.lock.Unlock()
+		if call.rtype != t {
+			return db.structMismatch(namespace, opts, call.rtype, t)
+		}
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	call := &nsOpenCall{rtype: t, done: make(chan struct{})}
+	if db.nsOpening == nil {
+		db.nsOpening = make(map[string]*nsOpenCall)
+	}
+	db.nsOpening[namespace] = call
+	db.lock.Unlock()
+
+	defer func() {
+		db.lock.Lock()
+		delete(db.nsOpening, namespace)
+		db.lock.Unlock()
+		close(call.done)
+	}()
+	reg := db.registration(namespace, t, opts)
+	reg.Actions, call.err = db.openNamespaceImpl(ctx, namespace, opts, s)
+	if _, ok := call.err.(ErrNamespaceStructMismatch); ok {
+		db.registrations.mismatch(reg)
+	} else {
+		reg.Opened, reg.Err = call.err == nil, call.err
+		db.registrations.record(reg, true)
+	}
+	return call.err
+}
+
+// markNotOpened makes the next OpenNamespace call open namespace on server again, e.g. when its indexes are changed
+func (db *reindexerImpl) markNotOpened(namespace string) {
+	db.lock.Lock()
+	if ns, ok := db.ns[strings.ToLower(namespace)]; ok {
+		ns.opened = false
+	}
+	db.lock.Unlock()
+}
+
+// reopenNamespaces opens namespaces, which are opened by this client, and adds their indexes on new connection of binding (e.g. after
+// restart of server). Namespace, which is rejected by server, is opened again by the next OpenNamespace call; the other errors fail the connection
+func (db *reindexerImpl) reopenNamespaces(ctx context.Context, binding bindings.RawBinding) error {
+	db.lock.RLock()
+	nsArray := make([]*reindexerNamespace, 0, len(db.ns))
+	for _, ns := range db.ns {
+		if ns.opened && !IsSystemNamespace(ns.name) {
+			nsArray = append(nsArray, ns)
+		}
+	}
+	db.lock.RUnlock()
+
+	for _, ns := range nsArray {
+		err := binding.OpenNamespace(ctx, ns.name, ns.opts.enableStorage, false)
+		for i := 0; err == nil && i < len(ns.indexes); i++ {
+			err = binding.AddIndex(ctx, ns.name, ns.indexes[i])
+		}
+		if err == nil {
+			continue
+		}
+		if rerr, ok := err.(bindings.Error); !ok || rerr.Code() == bindings.ErrNetwork || ctx.Err() != nil {
+			return err
+		}
+		db.markNotOpened(ns.name)
+		if db.onNsInvalidated.Handler != nil {
+			db.onNsInvalidated.Handler(ns.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package reindexer
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// logLevel - max level of messages, which are passed to logger (see WithLogLevel). Accessed atomically
+var logLevel int32 = TRACE
+
+// levelLogger drops messages of logger with level above logLevel
+type levelLogger struct {
+	log Logger
+}
+
+func (l levelLogger) Printf(level int, fmt string, msg ...interface{}) {
+	if level <= int(atomic.LoadInt32(&logLevel)) {
+		l.log.Printf(level, fmt, msg...)
+	}
+}
+
+// limiter returns rate limiter of the client (nil - requests aren't limited)
+func (db *reindexerImpl) limiter() *rateLimiter {
+	rl, _ := db.rateLimit.Load().(*rateLimiter)
+	return rl
+}
+
+// updateOptions validates all the options, then passes options of binding to it and applies options of the client,
+// so nothing is changed, if any option is rejected
+func (db *reindexerImpl) updateOptions(options ...interface{}) error {
+	expanded, log, err := prepareOptions(options)
+	if err != nil {
+		return err
+	}
+	var limiter *rateLimiter
+	var setLimiter bool
+	maxResultBytes, level := int64(-1), -1
+	bindingOptions := make([]interface{}, 0, len(expanded))
+	for _, option := range expanded {
+		switch v := option.(type) {
+		case bindings.OptionRateLimit:
+			if limiter, err = newRateLimiter(v.Rules); err != nil {
+				return err
+			}
+			setLimiter = true
+		case bindings.OptionMaxResultBufferBytes:
+			maxResultBytes = int64(v.MaxBytes)
+		case bindings.OptionLogLevel:
+			level = v.Level
+		case bindings.OptionTimeouts, bindings.OptionRetryAttempts, bindings.OptionSlowRPC, bindings.OptionTracer, bindings.OptionRequestLog, bindings.OptionDeadlineFloor,
+			bindings.OptionConnPoolSize:
+			bindingOptions = append(bindingOptions, option)
+		default:
+			return bindings.NewError(fmt.Sprintf("rq: Option %T can't be changed at runtime", option), ErrCodeParams)
+		}
+	}
+	if len(bindingOptions) != 0 {
+		updating, ok := db.getBinding().(bindings.RawBindingOptionsUpdating)
+		if !ok {
+			return bindings.NewError(fmt.Sprintf("rq: Options %v can't be changed at runtime by the binding", bindingOptions), ErrCodeParams)
+		}
+		if err = updating.UpdateOptions(bindingOptions...); err != nil {
+			return err
+		}
+	}
+	if setLimiter {
+		db.rateLimit.Store(limiter)
+	}
+	if maxResultBytes >= 0 {
+		atomic.StoreInt64(&db.maxResultBufferBytes, maxResultBytes)
+	}
+	if level >= 0 {
+		atomic.StoreInt32(&logLevel, int32(level))
+	}
+	if log != nil {
+		db.setLogger(log)
+	}
+	return nil
+}
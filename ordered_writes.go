@@ -0,0 +1,59 @@
+package reindexer
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// defaultOrderedWriteLanes - default count of lanes of NamespaceOptions.OrderedWrites
+const defaultOrderedWriteLanes = 64
+
+// writeLanes - FIFO locks of writes, which are selected by hash of PK of item (see NamespaceOptions.OrderedWrites).
+// Lane is a channel with buffer of one element: blocked senders are woken in order of their arrival, so writes of lane are sent in order of submission
+type writeLanes struct {
+	lanes []chan struct{}
+}
+
+// orderedWrite - write, which holds its lane. Zero value holds nothing
+type orderedWrite struct {
+	lane chan struct{}
+}
+
+func newWriteLanes(count int) *writeLanes {
+	l := &writeLanes{lanes: make([]chan struct{}, count)}
+	for i := range l.lanes {
+		l.lanes[i] = make(chan struct{}, 1)
+	}
+	return l
+}
+
+// beginOrderedWrite waits for lane of item and returns ctx, which routes the write to the connection of lane.
+// JSON items and items without PK are written without lane
+func (ns *reindexerNamespace) beginOrderedWrite(ctx context.Context, item interface{}) (context.Context, orderedWrite, error) {
+	l := ns.writeLanes
+	if l == nil {
+		return ctx, orderedWrite{}, nil
+	}
+	key, ok := ns.itemKey(item)
+	if !ok {
+		return ctx, orderedWrite{}, nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	i := int(h.Sum32() % uint32(len(l.lanes)))
+	select {
+	case l.lanes[i] <- struct{}{}:
+	case <-ctx.Done():
+		return ctx, orderedWrite{}, ctx.Err()
+	}
+	return bindings.ContextWithWriteLane(ctx, i), orderedWrite{lane: l.lanes[i]}, nil
+}
+
+// end releases lane for the next write
+func (w orderedWrite) end() {
+	if w.lane != nil {
+		<-w.lane
+	}
+}
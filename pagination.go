@@ -0,0 +1,270 @@
+package reindexer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+const (
+	// cursorVersion - prefix of version of cursor format
+	cursorVersion = "v1."
+	// cursorChecksumSize - size of checksum of cursors, which are not signed by key
+	cursorChecksumSize = 8
+)
+
+// Cursor is an opaque URL-safe position of page of Paginate, which is returned after the last item of page
+type Cursor string
+
+// PaginateOptions - options of Paginate
+type PaginateOptions struct {
+	// PageSize - max count of items of page
+	PageSize int
+	// After - cursor of the previous page. Empty cursor starts from the first page
+	After Cursor
+	// Key - key of HMAC-SHA256 signature of cursors (optional). Cursors without key are protected by checksum against corruption, but not against forgery
+	Key []byte
+}
+
+// cursorValue - value of sort key of the last item of page with its type
+type cursorValue struct {
+	I *int64   `json:"i,omitempty"`
+	F *float64 `json:"f,omitempty"`
+	S *string  `json:"s,omitempty"`
+	B *bool    `json:"b,omitempty"`
+}
+
+// cursorPayload - content of cursor. Cursor is valid only for queries of the same namespace with the same sort
+type cursorPayload struct {
+	Namespace string        `json:"ns"`
+	Sort      []string      `json:"sort"`
+	Values    []cursorValue `json:"values"`
+}
+
+// paginationKey - sort entry of paginated query, which value of item is stored to cursor
+type paginationKey struct {
+	index string
+	path  string
+	desc  bool
+}
+
+func newCursorValue(v interface{}) (cv cursorValue, ok bool) {
+	switch v := v.(type) {
+	case int64:
+		cv.I = &v
+	case float64:
+		cv.F = &v
+	case string:
+		cv.S = &v
+	case bool:
+		cv.B = &v
+	default:
+		return cv, false
+	}
+	return cv, true
+}
+
+func (cv cursorValue) value() interface{} {
+	switch {
+	case cv.I != nil:
+		return *cv.I
+	case cv.F != nil:
+		return *cv.F
+	case cv.S != nil:
+		return *cv.S
+	case cv.B != nil:
+		return *cv.B
+	}
+	return nil
+}
+
+func cursorSignature(payload []byte, key []byte) []byte {
+	if len(key) == 0 {
+		sum := sha256.Sum256(payload)
+		return sum[:cursorChecksumSize]
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func encodeCursor(p *cursorPayload, key []byte) (Cursor, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return Cursor(cursorVersion + base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(cursorSignature(payload, key))), nil
+}
+
+func errInvalidCursor(reason string) error {
+	return bindings.NewError("rq: Invalid cursor of Paginate: "+reason, ErrCodeParams)
+}
+
+func decodeCursor(c Cursor, key []byte) (*cursorPayload, error) {
+	if !strings.HasPrefix(string(c), cursorVersion) {
+		return nil, errInvalidCursor("unsupported version")
+	}
+	parts := strings.Split(string(c)[len(cursorVersion):], ".")
+	if len(parts) != 2 {
+		return nil, errInvalidCursor("malformed cursor")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidCursor("malformed cursor")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, cursorSignature(payload, key)) {
+		return nil, errInvalidCursor("signature mismatch")
+	}
+	p := &cursorPayload{}
+	if err = json.Unmarshal(payload, p); err != nil {
+		return nil, errInvalidCursor(err.Error())
+	}
+	return p, nil
+}
+
+// isFieldPath returns true, if name is JSON path of field, but not sort expression
+func isFieldPath(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// paginationKeys returns sort entries of query followed by ascending tie-breaker of StableSort
+func (q *Query) paginationKeys() ([]paginationKey, error) {
+	ns, err := q.db.getNS(q.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	sorts := append([]querySort(nil), q.sorts...)
+	tieBreaker, err := q.tieBreakerFields()
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range tieBreaker {
+		if !q.sorted(field) {
+			sorts = append(sorts, querySort{index: field})
+		}
+	}
+	keys := make([]paginationKey, 0, len(sorts))
+	for _, s := range sorts {
+		if s.forced {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Sort by '%s' with forced values is not supported by Paginate", s.index), ErrCodeParams)
+		}
+		key := paginationKey{index: s.index, path: s.index, desc: s.desc}
+		indexed := false
+		for _, index := range ns.indexes {
+			if !strings.EqualFold(index.Name, s.index) {
+				continue
+			}
+			if index.FieldType == "composite" || index.IsArray || len(index.JSONPaths) != 1 {
+				return nil, bindings.NewError(fmt.Sprintf("rq: Sort by composite or array index '%s' is not supported by Paginate", s.index), ErrCodeParams)
+			}
+			key.path, indexed = index.JSONPaths[0], true
+			break
+		}
+		if !indexed && !isFieldPath(s.index) {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Sort expression '%s' is not supported by Paginate", s.index), ErrCodeParams)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// cursorSort returns sort of keys, which cursor is bound to
+func cursorSort(keys []paginationKey) []string {
+	sort := make([]string, len(keys))
+	for i, key := range keys {
+		sort[i] = strings.ToLower(key.index)
+		if key.desc {
+			sort[i] += " desc"
+		}
+	}
+	return sort
+}
+
+// putAfter adds condition of items after values of keys: (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., where '<' is used by descending sort
+func (q *Query) putAfter(keys []paginationKey, values []cursorValue) {
+	q.OpenBracket()
+	for i, key := range keys {
+		if i != 0 {
+			q.Or()
+		}
+		q.OpenBracket()
+		for j := 0; j < i; j++ {
+			q.Where(keys[j].index, EQ, values[j].value())
+		}
+		cond := GT
+		if key.desc {
+			cond = LT
+		}
+		q.Where(key.index, cond, values[i].value())
+		q.CloseBracket()
+	}
+	q.CloseBracket()
+}
+
+// Paginate executes select query and returns page of at most PageSize items after cursor After, and cursor of the next page
+// or empty cursor after the last page. Unlike pagination by Offset, pages don't overlap or miss items, which are inserted or deleted between
+// the calls: the next page is selected by conditions on sort keys of the last item of the previous page. Query is sorted by primary key
+// (or by tie-breaker of StableSort) after its sort entries. Sort by expressions, by composite or array indexes, with forced values, Limit and Offset
+// are rejected with ErrCodeParams, as well as cursors of another query or ones, which are tampered or signed by another Key
+func (q *Query) Paginate(ctx context.Context, opts PaginateOptions) (items []interface{}, next Cursor, err error) {
+	if q.root != nil {
+		q = q.root
+	}
+	if opts.PageSize <= 0 {
+		return nil, "", bindings.NewError("rq: PageSize of Paginate must be positive", ErrCodeParams)
+	}
+	if q.limit >= 0 || q.startOffset != 0 || len(q.mergedQueries) != 0 {
+		return nil, "", bindings.NewError("rq: Query of Paginate must not have limit, offset and merged queries", ErrCodeParams)
+	}
+	keys, err := q.paginationKeys()
+	if err != nil {
+		return nil, "", err
+	}
+	sort := cursorSort(keys)
+	namespace := strings.ToLower(q.Namespace)
+	if opts.After != "" {
+		after, err := decodeCursor(opts.After, opts.Key)
+		if err != nil {
+			return nil, "", err
+		}
+		if after.Namespace != namespace || strings.Join(after.Sort, ",") != strings.Join(sort, ",") || len(after.Values) != len(keys) {
+			return nil, "", errInvalidCursor("cursor belongs to another query")
+		}
+		q.putAfter(keys, after.Values)
+	}
+	q.stableSort = true
+	// The extra item shows, that the next page exists
+	q.Limit(opts.PageSize + 1)
+	if items, err = q.ExecCtx(ctx).FetchAll(); err != nil {
+		return nil, "", err
+	}
+	if len(items) <= opts.PageSize {
+		return items, "", nil
+	}
+	items = items[:opts.PageSize]
+	last := &cursorPayload{Namespace: namespace, Sort: sort, Values: make([]cursorValue, len(keys))}
+	for i, key := range keys {
+		v, ok := newCursorValue(replicaValue(items[len(items)-1], key.path))
+		if !ok {
+			return nil, "", bindings.NewError(fmt.Sprintf("rq: Item of namespace '%s' has no scalar value of sort field '%s' for cursor of Paginate", namespace, key.index), ErrCodeParams)
+		}
+		last.Values[i] = v
+	}
+	if next, err = encodeCursor(last, opts.Key); err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
+}
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/restream/reindexer/bindings"
@@ -39,6 +41,8 @@ const (
 	queryDropField         = bindings.QueryDropField
 	queryUpdateObject      = bindings.QueryUpdateObject
 	queryWithRank          = bindings.QueryWithRank
+	queryDryRun            = bindings.QueryDryRun
+	queryRequireState      = bindings.QueryRequireState
 )
 
 // Constants for calc total
@@ -81,6 +85,15 @@ const (
 type nsArrayEntry struct {
 	*reindexerNamespace
 	localCjsonState cjson.State
+	// items of namespace are decoded without object cache
+	noObjCache bool
+}
+
+// querySort - sort entry of query
+type querySort struct {
+	index  string
+	desc   bool
+	forced bool // sort has forced values
 }
 
 // Query to DB object
@@ -108,9 +121,36 @@ type Query struct {
 	totalName       string
 	executed        bool
 	fetchCount      int
+	fetchBudget     int
+	startOffset     int
+	maxBufferBytes  int
+	compression     int
+	minMaxFields    []string
 	queriesCount    int
 	opennedBrackets []int
 	tx              *Tx
+	userCtx         context.Context
+	timeout         time.Duration
+	updateObject    bool
+	dryRun          bool
+	requiredState   StateToken
+	stateLSN        int64 // LSN of namespace, which was read from memstats before execution. -1 - it wasn't read
+	label           string
+	noObjCache      bool
+	resultChecksum  int
+	limit           int // limit of query. -1 - it wasn't set
+	dedicatedConn   bool
+	hedgeDelay      time.Duration
+	sorts           []querySort
+	stableSort      bool
+	tieBreaker      []string
+	includeDeleted  bool
+	noReplica       bool               // query isn't served by local replica of namespace
+	jsonNS          bool               // JSON query of namespace, which may be not registered by OpenNamespace
+	nsErr           error              // error of open of tenant namespace (see Tenant.Query), which is returned by execution
+	usageSampler    *indexUsageSampler // sampler of IndexUsageReport, which explain of query is passed to
+	relTimes        []relTimeValue
+	resolvedSer     cjson.Serializer // query with RelTime values, which are resolved on the last execution
 }
 
 var queryPool sync.Pool
@@ -123,7 +163,7 @@ func newQuery(db *reindexerImpl, namespace string, tx *Tx) *Query {
 		q = obj.(*Query)
 	}
 	if q == nil {
-		q = &Query{}
+		q = &Query{stateLSN: -1, limit: -1}
 		q.ser = cjson.NewSerializer(q.initBuf[:0])
 	} else {
 		q.tx = nil
@@ -143,6 +183,31 @@ func newQuery(db *reindexerImpl, namespace string, tx *Tx) *Query {
 		q.nsArray = q.nsArray[:0]
 		q.queriesCount = 0
 		q.opennedBrackets = q.opennedBrackets[:0]
+		q.timeout = 0
+		q.updateObject = false
+		q.dryRun = false
+		q.requiredState = ""
+		q.stateLSN = -1
+		q.label = ""
+		q.startOffset = 0
+		q.fetchBudget = 0
+		q.maxBufferBytes = 0
+		q.compression = bindings.CompressionDefault
+		q.resultChecksum = bindings.ResultChecksumDefault
+		q.minMaxFields = q.minMaxFields[:0]
+		q.noObjCache = false
+		q.limit = -1
+		q.dedicatedConn = false
+		q.hedgeDelay = 0
+		q.sorts = q.sorts[:0]
+		q.stableSort = false
+		q.tieBreaker = q.tieBreaker[:0]
+		q.includeDeleted = false
+		q.noReplica = false
+		q.jsonNS = false
+		q.nsErr = nil
+		q.usageSampler = nil
+		q.relTimes = q.relTimes[:0]
 	}
 
 	q.Namespace = namespace
@@ -150,14 +215,85 @@ func newQuery(db *reindexerImpl, namespace string, tx *Tx) *Query {
 	q.nextOp = opAND
 	q.fetchCount = defaultFetchCount
 	q.tx = tx
+	q.userCtx = context.Background()
+	if tx != nil && tx.ctx.UserCtx != nil {
+		q.userCtx = tx.ctx.UserCtx
+	}
 
 	q.ser.PutVString(namespace)
+	if ns, err := db.getNS(namespace); err == nil {
+		q.applyDefaults(&ns.opts.queryDefaults)
+	}
 	return q
 }
 
+func (q *Query) applyDefaults(defaults *NamespaceQueryDefaults) {
+	if defaults.FetchCount != 0 {
+		q.fetchCount = defaults.FetchCount
+	}
+	if defaults.Debug != 0 {
+		q.Debug(defaults.Debug)
+	}
+	q.timeout = defaults.Timeout
+	q.noObjCache = defaults.BypassObjCache
+}
+
+// withCompression sets compression mode, activity label, results checksum mode and bulk connection flag of the query to ctx
+func (q *Query) withCompression(ctx context.Context) context.Context {
+	if q.dedicatedConn || (q.db.bulkMinLimit > 0 && q.limit >= q.db.bulkMinLimit) {
+		ctx = bindings.ContextWithBulkConn(ctx)
+	}
+	if len(q.label) != 0 {
+		ctx = bindings.ContextWithActivityLabel(ctx, q.label)
+	}
+	if q.resultChecksum != bindings.ResultChecksumDefault {
+		ctx = bindings.ContextWithResultChecksum(ctx, q.resultChecksum)
+	}
+	if q.compression == bindings.CompressionDefault {
+		return ctx
+	}
+	return bindings.ContextWithCompression(ctx, q.compression)
+}
+
+// withHedge sets hedge delay of select query to ctx. Queries of transactions are not hedged
+func (q *Query) withHedge(ctx context.Context) context.Context {
+	if q.hedgeDelay <= 0 || q.tx != nil {
+		return ctx
+	}
+	return bindings.ContextWithHedge(ctx, q.hedgeDelay)
+}
+
+// withTimeout applies the least of default timeouts of query namespaces, if ctx has no deadline
+func (q *Query) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, nil
+	}
+	timeout := q.timeout
+	least := func(sq *Query) {
+		if sq.timeout != 0 && (timeout == 0 || sq.timeout < timeout) {
+			timeout = sq.timeout
+		}
+	}
+	for _, jq := range q.joinQueries {
+		least(jq)
+	}
+	for _, mq := range q.mergedQueries {
+		least(mq)
+		for _, jq := range mq.joinQueries {
+			least(jq)
+		}
+	}
+	if timeout == 0 {
+		return ctx, nil
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 // MakeCopy -  copy of query with same or other db, resets query context
 func (q *Query) MakeCopy(db *Reindexer) *Query {
-	return q.makeCopy(db.impl, nil)
+	qC := q.makeCopy(db.impl, nil)
+	qC.userCtx = db.ctx
+	return qC
 }
 
 func (q *Query) makeCopy(db *reindexerImpl, root *Query) *Query {
@@ -192,6 +328,34 @@ func (q *Query) makeCopy(db *reindexerImpl, root *Query) *Query {
 	qC.totalName = q.totalName
 	qC.executed = q.executed
 	qC.fetchCount = q.fetchCount
+	qC.fetchBudget = q.fetchBudget
+	qC.userCtx = q.userCtx
+	qC.timeout = q.timeout
+	qC.updateObject = q.updateObject
+	qC.noObjCache = q.noObjCache
+	qC.noReplica = q.noReplica
+	qC.jsonNS = q.jsonNS
+	qC.dryRun = q.dryRun
+	qC.requiredState = q.requiredState
+	qC.stateLSN = q.stateLSN
+	qC.label = q.label
+	qC.startOffset = q.startOffset
+	qC.maxBufferBytes = q.maxBufferBytes
+	qC.compression = q.compression
+	qC.resultChecksum = q.resultChecksum
+	qC.limit = q.limit
+	qC.dedicatedConn = q.dedicatedConn
+	qC.hedgeDelay = q.hedgeDelay
+	qC.nsErr = q.nsErr
+	qC.sorts = append(qC.sorts[:0], q.sorts...)
+	qC.stableSort = q.stableSort
+	qC.tieBreaker = append(qC.tieBreaker[:0], q.tieBreaker...)
+	qC.minMaxFields = append(qC.minMaxFields[:0], q.minMaxFields...)
+	qC.queriesCount = q.queriesCount
+	qC.opennedBrackets = append(qC.opennedBrackets[:0], q.opennedBrackets...)
+	qC.relTimes = append(qC.relTimes[:0], q.relTimes...)
+	// Copy may be made for another db, so it's not bound to transaction of the query
+	qC.tx = nil
 
 	qC.closed = q.closed
 	if q.root != nil && root == nil {
@@ -231,15 +395,27 @@ func (q *Query) Where(index string, condition int, keys interface{}) *Query {
 	} else if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
 		q.ser.PutVarCUInt(v.Len())
 		for i := 0; i < v.Len(); i++ {
-			q.putValue(v.Index(i))
+			q.putCondValue(index, v.Index(i))
 		}
 	} else {
 		q.ser.PutVarCUInt(1)
-		q.putValue(v)
+		q.putCondValue(index, v)
 	}
 	return q
 }
 
+// putCondValue puts value of Where condition and records it, if it's RelTime
+func (q *Query) putCondValue(index string, v reflect.Value) {
+	offset := len(q.ser.Bytes())
+	q.putValue(v)
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.IsValid() && v.Type() == reflect.TypeOf(RelTime(0)) {
+		q.relTimes = append(q.relTimes, relTimeValue{index: index, d: time.Duration(v.Int()), offset: offset, size: len(q.ser.Bytes()) - offset})
+	}
+}
+
 // OpenBracket - Open bracket for where condition to DB query
 func (q *Query) OpenBracket() *Query {
 	q.ser.PutVarCUInt(queryOpenBracket)
@@ -296,9 +472,13 @@ func (q *Query) putValue(v reflect.Value) error {
 	case reflect.Int16, reflect.Int32, reflect.Int8:
 		q.ser.PutVarCUInt(valueInt)
 		q.ser.PutVarInt(v.Int())
-	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+	case reflect.Uint8, reflect.Uint16:
 		q.ser.PutVarCUInt(valueInt)
 		q.ser.PutVarInt(int64(v.Uint()))
+	case reflect.Uint32:
+		// uint32 values may not fit into int
+		q.ser.PutVarCUInt(valueInt64)
+		q.ser.PutVarInt(int64(v.Uint()))
 	case reflect.Int64:
 		q.ser.PutVarCUInt(valueInt64)
 		q.ser.PutVarInt(v.Int())
@@ -433,10 +613,28 @@ func (q *Query) AggregateAvg(field string) {
 
 func (q *Query) AggregateMin(field string) {
 	q.ser.PutVarCUInt(queryAggregation).PutVarCUInt(AggMin).PutVarCUInt(1).PutVString(field)
+	q.minMaxFields = append(q.minMaxFields, field)
 }
 
 func (q *Query) AggregateMax(field string) {
 	q.ser.PutVarCUInt(queryAggregation).PutVarCUInt(AggMax).PutVarCUInt(1).PutVString(field)
+	q.minMaxFields = append(q.minMaxFields, field)
+}
+
+// minMaxError makes error of servers, which can't aggregate MIN/MAX of string fields, descriptive
+func (q *Query) minMaxError(err error) error {
+	rerr, ok := err.(bindings.Error)
+	if !ok || len(q.nsArray) == 0 || !strings.Contains(rerr.Error(), "to number") {
+		return err
+	}
+	for _, field := range q.minMaxFields {
+		for _, index := range q.nsArray[0].indexes {
+			if index.Name == field && index.FieldType == "string" {
+				return bindings.NewError(fmt.Sprintf("rq: server does not support MIN/MAX aggregation of string field '%s': %s", field, rerr.Error()), rerr.Code())
+			}
+		}
+	}
+	return err
 }
 
 type AggregateFacetRequest struct {
@@ -491,10 +689,20 @@ func (q *Query) Sort(sortIndex string, desc bool, values ...interface{}) *Query
 	for i := 0; i < len(values); i++ {
 		q.putValue(reflect.ValueOf(values[i]))
 	}
+	q.sorts = append(q.sorts, querySort{index: sortIndex, desc: desc, forced: len(values) != 0})
 
 	return q
 }
 
+// StableSort makes order of query results deterministic: ascending sort by tieBreaker fields, or by primary key of namespace, if tieBreaker is not specified,
+// is added after all the other sort entries at query execution, so pages of results don't overlap or miss items with equal sort keys.
+// Query fails with ErrCodeParams, if tieBreaker is not specified and namespace has no scalar primary key. Fields, which query is already sorted by, are skipped
+func (q *Query) StableSort(tieBreaker ...string) *Query {
+	q.stableSort = true
+	q.tieBreaker = append(q.tieBreaker[:0], tieBreaker...)
+	return q
+}
+
 // OR - next condition will added with OR
 // Implements short-circuiting:
 // if the previous condition is successful the next will not be evaluated, but except Join conditions
@@ -517,6 +725,56 @@ func (q *Query) Distinct(distinctIndex string) *Query {
 	return q
 }
 
+// DistinctValues executes query and returns unique values of the field among matched items in the server's order.
+// Values of array fields are flattened. Matched items themselves are not fetched (query limit is replaced by 0)
+func (q *Query) DistinctValues(ctx context.Context, field string) ([]string, error) {
+	it := q.Distinct(field).Limit(0).ExecCtx(ctx)
+	defer it.Close()
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	for _, agg := range it.AggResults() {
+		if agg.Type == "distinct" && len(agg.Fields) == 1 && agg.Fields[0] == field {
+			return agg.Distincts, nil
+		}
+	}
+	return nil, nil
+}
+
+// DistinctValuesInt64 is DistinctValues for integer fields
+func (q *Query) DistinctValuesInt64(ctx context.Context, field string) ([]int64, error) {
+	values, err := q.DistinctValues(ctx, field)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]int64, 0, len(values))
+	for _, v := range values {
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, bindings.NewError(fmt.Sprintf("rq: distinct value '%s' of field '%s' is not an integer", v, field), ErrCodeParams)
+		}
+		res = append(res, i)
+	}
+	return res, nil
+}
+
+// DistinctValuesFloat64 is DistinctValues for numeric fields
+func (q *Query) DistinctValuesFloat64(ctx context.Context, field string) ([]float64, error) {
+	values, err := q.DistinctValues(ctx, field)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]float64, 0, len(values))
+	for _, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, bindings.NewError(fmt.Sprintf("rq: distinct value '%s' of field '%s' is not a number", v, field), ErrCodeParams)
+		}
+		res = append(res, f)
+	}
+	return res, nil
+}
+
 // ReqTotal Request total items calculation
 func (q *Query) ReqTotal(totalNames ...string) *Query {
 	q.ser.PutVarCUInt(queryReqTotal)
@@ -543,6 +801,7 @@ func (q *Query) Limit(limitItems int) *Query {
 		limitItems = cInt32Max
 	}
 	q.ser.PutVarCUInt(queryLimit).PutVarCUInt(limitItems)
+	q.limit = limitItems
 	return q
 }
 
@@ -552,6 +811,7 @@ func (q *Query) Offset(startOffset int) *Query {
 		startOffset = cInt32Max
 	}
 	q.ser.PutVarCUInt(queryOffset).PutVarCUInt(startOffset)
+	q.startOffset = startOffset
 	return q
 }
 
@@ -567,6 +827,41 @@ func (q *Query) Explain() *Query {
 	return q
 }
 
+// DryRun makes Update and Delete queries only select matching items without modifying them.
+// Servers, which don't support it, execute equivalent select query instead
+func (q *Query) DryRun() *Query {
+	q.dryRun = true
+	return q
+}
+
+// RequireState makes select query fail with ErrStateMismatch, if namespace was changed since token was captured by Iterator.StateToken,
+// JSONIterator.StateToken or Query.StateToken. It allows to restart pagination, which is made by queries with increasing offset.
+// Servers, which don't support it, are checked by namespace LSN from memstats before query execution (best-effort)
+func (q *Query) RequireState(token StateToken) *Query {
+	q.requiredState = token
+	return q
+}
+
+// StateToken returns current state token of query namespace without executing query
+func (q *Query) StateToken(ctx context.Context) (StateToken, error) {
+	if q.root != nil {
+		q = q.root
+	}
+	if q.db.checkFeature(featureStateToken) != nil {
+		lsn, err := q.db.nsStateLSN(ctx, q.Namespace)
+		if err != nil {
+			return "", err
+		}
+		return makeStateToken(lsn), nil
+	}
+	it := q.db.query(q.Namespace).Limit(0).ExecCtx(ctx)
+	defer it.Close()
+	if it.Error() != nil {
+		return "", it.Error()
+	}
+	return it.StateToken()
+}
+
 // Output fulltext rank
 // Allowed only with fulltext query
 func (q *Query) WithRank() *Query {
@@ -584,9 +879,18 @@ func (q *Query) SetContext(ctx interface{}) *Query {
 	return q
 }
 
+// defaultCtx returns context of Reindexer (or Tx), which created the root query
+func (q *Query) defaultCtx() context.Context {
+	if q.root != nil {
+		q = q.root
+	}
+	return q.userCtx
+}
+
 // Exec will execute query, and return slice of items
+// Query is executed with context of Reindexer (see WithContext) or Tx, which created it
 func (q *Query) Exec() *Iterator {
-	return q.ExecCtx(context.Background())
+	return q.ExecCtx(q.defaultCtx())
 }
 
 // ExecCtx will execute query, and return slice of items
@@ -606,12 +910,21 @@ func (q *Query) ExecCtx(ctx context.Context) *Iterator {
 
 	q.executed = true
 
-	return q.db.execQuery(ctx, q)
+	ctx, cancel := q.withTimeout(q.withHedge(q.withCompression(ctx)))
+	it := q.db.execQuery(ctx, q)
+	if cancel != nil {
+		if it.err != nil {
+			cancel()
+		} else {
+			it.cancel = cancel
+		}
+	}
+	return it
 }
 
 // ExecToJson will execute query, and return iterator
 func (q *Query) ExecToJson(jsonRoots ...string) *JSONIterator {
-	return q.ExecToJsonCtx(context.Background())
+	return q.ExecToJsonCtx(q.defaultCtx())
 }
 
 // ExecToJsonCtx will execute query, and return iterator
@@ -635,7 +948,99 @@ func (q *Query) ExecToJsonCtx(ctx context.Context, jsonRoots ...string) *JSONIte
 		jsonRoot = jsonRoots[0]
 	}
 
-	return q.db.execJSONQuery(ctx, q, jsonRoot)
+	ctx, cancel := q.withTimeout(q.withHedge(q.withCompression(ctx)))
+	it := q.db.execJSONQuery(ctx, q, jsonRoot)
+	if cancel != nil {
+		if it.err != nil {
+			cancel()
+		} else {
+			it.cancel = cancel
+		}
+	}
+	return it
+}
+
+// tieBreakerFields returns fields of StableSort: tieBreaker or scalar primary key of namespace
+func (q *Query) tieBreakerFields() ([]string, error) {
+	if len(q.tieBreaker) != 0 {
+		return q.tieBreaker, nil
+	}
+	ns, err := q.db.getNS(q.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range ns.indexes {
+		if index.IsPK && index.FieldType != "composite" {
+			return []string{index.Name}, nil
+		}
+	}
+	return nil, bindings.NewError(fmt.Sprintf("rq: Namespace '%s' has no scalar primary key, tie-breaker of StableSort must be specified", q.Namespace), ErrCodeParams)
+}
+
+// sorted returns true, if query is sorted by field
+func (q *Query) sorted(field string) bool {
+	for _, s := range q.sorts {
+		if s.index == field {
+			return true
+		}
+	}
+	return false
+}
+
+// putTieBreaker appends sort entries of StableSort to serialized query
+func (q *Query) putTieBreaker(ser *cjson.Serializer) error {
+	if !q.stableSort {
+		return nil
+	}
+	fields, err := q.tieBreakerFields()
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if !q.sorted(field) {
+			ser.PutVarCUInt(querySortIndex).PutVString(field).PutVarUInt(0).PutVarCUInt(0)
+		}
+	}
+	return nil
+}
+
+// putSubQueries finishes serialization of the main query and appends joined and merged queries
+// Soft delete filters are appended to each of them. resolved - RelTime values of sub queries are resolved (see resolveRelTimes)
+func (q *Query) putSubQueries(ser *cjson.Serializer, resolved bool) {
+	subSer := func(sq *Query) cjson.Serializer {
+		if resolved {
+			return sq.execSer()
+		}
+		return sq.ser
+	}
+	q.putSoftDeleteFilter(ser)
+	ser.PutVarCUInt(queryEnd)
+	for _, sq := range q.joinQueries {
+		ser.PutVarCUInt(sq.joinType)
+		ser.Append(subSer(sq))
+		sq.putSoftDeleteFilter(ser)
+		ser.PutVarCUInt(queryEnd)
+	}
+
+	for _, mq := range q.mergedQueries {
+		ser.PutVarCUInt(merge)
+		ser.Append(subSer(mq))
+		mq.putSoftDeleteFilter(ser)
+		ser.PutVarCUInt(queryEnd)
+		for _, sq := range mq.joinQueries {
+			ser.PutVarCUInt(sq.joinType)
+			ser.Append(subSer(sq))
+			sq.putSoftDeleteFilter(ser)
+			ser.PutVarCUInt(queryEnd)
+		}
+	}
+}
+
+func (q *Query) putPtVersions() {
+	q.ptVersions = q.ptVersions[:0]
+	for _, ns := range q.nsArray {
+		q.ptVersions = append(q.ptVersions, ns.localCjsonState.Version^ns.localCjsonState.StateToken)
+	}
 }
 
 func (q *Query) close() {
@@ -670,14 +1075,35 @@ func (q *Query) close() {
 // Delete will execute query, and delete items, matches query
 // On sucess return number of deleted elements
 func (q *Query) Delete() (int, error) {
-	return q.DeleteCtx(context.Background())
+	return q.DeleteCtx(q.defaultCtx())
 }
 
 // DeleteCtx will execute query, and delete items, matches query
 // On sucess return number of deleted elements
 func (q *Query) DeleteCtx(ctx context.Context) (int, error) {
+	count, _, err := q.deleteCtx(ctx)
+	return count, err
+}
+
+// DeleteExplain will execute delete query and return number of deleted elements and explain results
+func (q *Query) DeleteExplain() (int, *ExplainResults, error) {
+	return q.DeleteExplainCtx(q.defaultCtx())
+}
+
+// DeleteExplainCtx will execute delete query and return number of deleted elements and explain results
+func (q *Query) DeleteExplainCtx(ctx context.Context) (int, *ExplainResults, error) {
+	q.Explain()
+	count, rawExplain, err := q.deleteCtx(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	explain, err := parseExplainResults(rawExplain)
+	return count, explain, err
+}
+
+func (q *Query) deleteCtx(ctx context.Context) (int, []byte, error) {
 	if q.root != nil || len(q.joinQueries) != 0 {
-		return 0, errors.New("Delete does not support joined queries")
+		return 0, nil, errors.New("Delete does not support joined queries")
 	}
 	if q.closed {
 		panic(errors.New("Delete call on already closed query. You shoud create new Query"))
@@ -685,7 +1111,21 @@ func (q *Query) DeleteCtx(ctx context.Context) (int, error) {
 
 	defer q.close()
 	if q.tx != nil {
-		return q.db.deleteQueryTx(ctx, q, q.tx)
+		if q.dryRun {
+			return 0, nil, errors.New("DryRun is not supported in transactions")
+		}
+		if q.tx.inRun && q.tx.finished {
+			return 0, nil, errTxReused
+		}
+		if err := q.db.checkFeature(featureQueriesInTx); err != nil {
+			return 0, nil, err
+		}
+		count, err := q.db.deleteQueryTx(ctx, q, q.tx)
+		return count, nil, err
+	}
+	ctx, cancel := q.withTimeout(q.withCompression(ctx))
+	if cancel != nil {
+		defer cancel()
 	}
 	return q.db.deleteQuery(ctx, q)
 }
@@ -699,6 +1139,9 @@ func getValueJSON(value interface{}) string {
 		objectJSON = []byte("{}")
 	} else if t.Kind() == reflect.Struct || t.Kind() == reflect.Map {
 		objectJSON, err = json.Marshal(value)
+		if err == nil && t.Kind() == reflect.Struct {
+			objectJSON, err = appendUnknownJSON(reflect.ValueOf(value), objectJSON)
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -708,8 +1151,37 @@ func getValueJSON(value interface{}) string {
 	return string(objectJSON)
 }
 
+// appendUnknownJSON adds fields of Unknown carrier of struct v to its JSON, because carrier is skipped by encoding/json
+func appendUnknownJSON(v reflect.Value, objectJSON []byte) ([]byte, error) {
+	var unknown Unknown
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Type().Field(i); f.Type == reflect.TypeOf(unknown) && len(f.PkgPath) == 0 {
+			unknown = v.Field(i).Interface().(Unknown)
+		}
+	}
+	if len(unknown) == 0 {
+		return objectJSON, nil
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(objectJSON, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range unknown {
+		if _, ok := fields[name]; ok {
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = data
+	}
+	return json.Marshal(fields)
+}
+
 // SetObject adds update of object field request for update query
 func (q *Query) SetObject(field string, values interface{}) *Query {
+	q.updateObject = true
 	size := 1
 	isArray := false
 	t := reflect.TypeOf(values)
@@ -798,7 +1270,7 @@ func (q *Query) SetExpression(field string, value string) *Query {
 // Update will execute query, and update fields in items, which matches query
 // On sucess return number of update elements
 func (q *Query) Update() *Iterator {
-	return q.UpdateCtx(context.Background())
+	return q.UpdateCtx(q.defaultCtx())
 }
 
 // UpdateCtx will execute query, and update fields in items, which matches query
@@ -811,16 +1283,41 @@ func (q *Query) UpdateCtx(ctx context.Context) *Iterator {
 		panic(errors.New("Update call on already closed query. You shoud create new Query"))
 	}
 	q.executed = true
+	if q.updateObject {
+		if err := q.db.checkFeature(featureUpdateObject); err != nil {
+			q.close()
+			return errIterator(err)
+		}
+	}
 	if q.tx != nil {
+		defer q.close()
+		if q.dryRun {
+			return errIterator(errors.New("DryRun is not supported in transactions"))
+		}
+		if q.tx.inRun && q.tx.finished {
+			return errIterator(errTxReused)
+		}
+		if err := q.db.checkFeature(featureQueriesInTx); err != nil {
+			return errIterator(err)
+		}
 		return q.db.updateQueryTx(ctx, q, q.tx)
 	}
 
-	return q.db.updateQuery(ctx, q)
+	ctx, cancel := q.withTimeout(q.withCompression(ctx))
+	it := q.db.updateQuery(ctx, q)
+	if cancel != nil {
+		if it.err != nil {
+			cancel()
+		} else {
+			it.cancel = cancel
+		}
+	}
+	return it
 }
 
 // MustExec will execute query, and return iterator, panic on error
 func (q *Query) MustExec() *Iterator {
-	return q.MustExecCtx(context.Background())
+	return q.MustExecCtx(q.defaultCtx())
 }
 
 // MustExecCtx will execute query, and return iterator, panic on error
@@ -834,7 +1331,7 @@ func (q *Query) MustExecCtx(ctx context.Context) *Iterator {
 
 // Get will execute query, and return 1 st item, panic on error
 func (q *Query) Get() (item interface{}, found bool) {
-	return q.GetCtx(context.Background())
+	return q.GetCtx(q.defaultCtx())
 }
 
 // GetCtx will execute query, and return 1 st item, panic on error
@@ -849,7 +1346,7 @@ func (q *Query) GetCtx(ctx context.Context) (item interface{}, found bool) {
 
 // GetJson will execute query, and return 1 st item, panic on error
 func (q *Query) GetJson() (json []byte, found bool) {
-	return q.GetJsonCtx(context.Background())
+	return q.GetJsonCtx(q.defaultCtx())
 }
 
 // GetJsonCtx will execute query, and return 1 st item, panic on error
@@ -890,8 +1387,8 @@ func (q *Query) join(q2 *Query, field string, joinType int) *Query {
 // InnerJoin joins 2 queries
 // Items from the 1-st query are filtered by and expanded with the data from the 2-nd query
 //
-// `field` parameter serves as unique identifier for the join between `q` and `q2`
-// One of the conditions below must hold for `field` parameter in order for InnerJoin to work:
+// `field` parameter serves as unique identifier (alias) for the join between `q` and `q2`, so the same namespace may be joined
+// several times with different fields. Items of several joins with the same field are put to the same field. One of the conditions below must hold for `field` parameter in order for InnerJoin to work:
 // - namespace of `q2` contains `field` as one of its fields marked as `joined`
 // - `q` has a join handler (registered via `q.JoinHandler(...)` call) with the same `field` value
 func (q *Query) InnerJoin(q2 *Query, field string) *Query {
@@ -912,25 +1409,22 @@ func (q *Query) Join(q2 *Query, field string) *Query {
 // LeftJoin joins 2 queries
 // Items from the 1-st query are expanded with the data from the 2-nd query
 //
-// `field` parameter serves as unique identifier for the join between `q` and `q2`
-// One of the conditions below must hold for `field` parameter in order for LeftJoin to work:
+// `field` parameter serves as unique identifier (alias) for the join between `q` and `q2`, so the same namespace may be joined
+// several times with different fields. Items of several joins with the same field are put to the same field. One of the conditions below must hold for `field` parameter in order for LeftJoin to work:
 // - namespace of `q2` contains `field` as one of its fields marked as `joined`
 // - `q` has a join handler (registered via `q.JoinHandler(...)` call) with the same `field` value
 func (q *Query) LeftJoin(q2 *Query, field string) *Query {
 	return q.join(q2, field, leftJoin)
 }
 
-// JoinHandler registers join handler that will be called when join, registered on `field` value, finds a match
+// JoinHandler registers join handler that will be called when join, registered on `field` value, finds a match.
+// If several joins are registered on the same `field`, handler is called for each of them
 func (q *Query) JoinHandler(field string, handler JoinHandler) *Query {
-	index := -1
 	for i := range q.joinToFields {
 		if strings.EqualFold(q.joinToFields[i], field) {
-			index = i
+			q.joinHandlers[i] = handler
 		}
 	}
-	if index != -1 {
-		q.joinHandlers[index] = handler
-	}
 	return q
 }
 
@@ -969,6 +1463,67 @@ func (q *Query) On(index string, condition int, joinIndex string) *Query {
 	return q
 }
 
+// MaxResultBufferBytes limits size of results buffer, retained by the query iterator.
+// When the limit is exceeded, iterator fails with ErrResultBufferLimit. cproto binding rejects oversized reply before reading it. 0 - no limit
+func (q *Query) MaxResultBufferBytes(maxBytes int) *Query {
+	q.maxBufferBytes = maxBytes
+	return q
+}
+
+// BypassObjCache makes query decode items of results without object cache:
+// items are neither taken from cache nor stored to it. Overrides BypassObjCache of namespace query defaults
+func (q *Query) BypassObjCache(bypass bool) *Query {
+	q.noObjCache = bypass
+	return q
+}
+
+// NoCompression disables compression of query requests and results for cproto binding, e.g. for latency-critical lookups
+func (q *Query) NoCompression() *Query {
+	q.compression = bindings.CompressionDisabled
+	return q
+}
+
+// ForceCompression enables compression of query requests and results for cproto binding regardless of
+// WithNetCompression and WithNetCompressionThreshold options, e.g. for huge exports. Server must support compression
+func (q *Query) ForceCompression() *Query {
+	q.compression = bindings.CompressionForced
+	return q
+}
+
+// ResultChecksum enables or disables verification of query results by checksum for cproto binding regardless of WithResultChecksum option.
+// Mismatch fails query or iterator with ErrResultCorrupted. Server must support results checksum
+func (q *Query) ResultChecksum(enable bool) *Query {
+	q.resultChecksum = bindings.ResultChecksumDisabled
+	if enable {
+		q.resultChecksum = bindings.ResultChecksumEnabled
+	}
+	return q
+}
+
+// UseDedicatedConn makes cproto binding send query and fetches of its results via connection for bulk queries (see WithBulkConns),
+// so they don't delay short requests of the main pool. It's ignored, if bulk connections are not enabled
+func (q *Query) UseDedicatedConn() *Query {
+	q.dedicatedConn = true
+	return q
+}
+
+// Hedged makes cproto binding send the select again via another connection of the pool, if it's not answered in delay,
+// and take the first successful reply, e.g. for latency-critical lookups by PK. The other request is canceled, its results are closed.
+// Only selects are hedged: Delete and Update of query are sent once. Server may run the query twice, so hedged fulltext queries
+// may update statistics of fulltext indexes twice
+func (q *Query) Hedged(delay time.Duration) *Query {
+	q.hedgeDelay = delay
+	return q
+}
+
+// Label sets activity label of query requests, which overrides label of context (see CtxWithActivityLabel).
+// cproto binding shows it in 'client' field of '#activitystats', in server's RPC log and in SlowRPC of WithSlowRPCHook.
+// Non-printable characters and quotes are replaced with '_', label is truncated to bindings.MaxActivityLabelLen bytes
+func (q *Query) Label(label string) *Query {
+	q.label = bindings.SanitizeActivityLabel(label)
+	return q
+}
+
 // Select add filter to  fields of result's objects
 func (q *Query) Select(fields ...string) *Query {
 	for _, field := range fields {
@@ -984,6 +1539,15 @@ func (q *Query) FetchCount(n int) *Query {
 	return q
 }
 
+// FetchBudgetBytes makes query adapt number of items, fetched by one operation, to stay near n bytes per reply.
+// The first reply contains few items, the next counts are calculated by average size of items in the previous replies.
+// Average size is shared by the queries to the same namespace, so the next executions start with adapted count.
+// Takes priority over FetchCount. When n <= 0 FetchCount is used
+func (q *Query) FetchBudgetBytes(n int) *Query {
+	q.fetchBudget = n
+	return q
+}
+
 // Select add filter to  fields of result's objects
 func (q *Query) Functions(fields ...string) *Query {
 	for _, field := range fields {
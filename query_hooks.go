@@ -0,0 +1,151 @@
+package reindexer
+
+import (
+	"context"
+	"hash/fnv"
+	"reflect"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// cacheKeyVersion is hashed before query, it must be changed only with incompatible change of the key
+const cacheKeyVersion = 1
+
+// CacheKey returns deterministic hash of serialized query (FNV-1a), e.g. to use it as key of application-level results cache.
+// Key is the same in different processes and versions of client. Conditions, sorting, limits and the other parts of query,
+// which are sent to server, are hashed; context, timeout, label and fetch parameters are not
+func (q *Query) CacheKey() (uint64, error) {
+	if q.root != nil {
+		q = q.root
+	}
+	ser := cjson.NewSerializer(nil)
+	ser.PutVarCUInt(cacheKeyVersion)
+	ser.Write(q.ser.Bytes())
+	if err := q.putTieBreaker(&ser); err != nil {
+		return 0, err
+	}
+	q.putSubQueries(&ser, false)
+	q.putRelTimesKey(&ser)
+	h := fnv.New64a()
+	h.Write(ser.Bytes())
+	return h.Sum64(), nil
+}
+
+// cachedBuffer - results buffer, which is made from result of query hooks
+type cachedBuffer []byte
+
+func (buf cachedBuffer) GetBuf() []byte {
+	return buf
+}
+
+func (buf cachedBuffer) Free() {
+}
+
+// queryHooksKey returns cache key of query, if query is passed to query hooks
+func (db *reindexerImpl) queryHooksKey(q *Query) (uint64, bool) {
+	if db.queryHooks.BeforeExec == nil && db.queryHooks.AfterExec == nil {
+		return 0, false
+	}
+	if q.tx != nil || len(q.joinQueries) != 0 || len(q.mergedQueries) != 0 || len(q.requiredState) != 0 {
+		return 0, false
+	}
+	key, err := q.CacheKey()
+	if err != nil {
+		// Query fails on execution with the same error
+		return 0, false
+	}
+	return key, true
+}
+
+func (db *reindexerImpl) beforeExec(key uint64, q *Query, asJSON bool) (bool, bindings.CachedResult) {
+	if db.queryHooks.BeforeExec == nil {
+		return false, bindings.CachedResult{}
+	}
+	return db.queryHooks.BeforeExec(key, bindings.QueryDescription{Namespace: q.Namespace, Label: q.label, JSON: asJSON})
+}
+
+func (db *reindexerImpl) afterExec(key uint64, q *Query, asJSON bool, params rawResultQueryParams, err error) {
+	if db.queryHooks.AfterExec == nil {
+		return
+	}
+	res := bindings.ResultSummary{Namespace: q.Namespace, JSON: asJSON, Count: params.qcount, TotalCount: params.totalcount, Err: err}
+	for _, agg := range params.aggResults {
+		// Aggregations refer to results buffer, which is reused by iterator
+		res.Aggregations = append(res.Aggregations, append([]byte(nil), agg...))
+	}
+	db.queryHooks.AfterExec(key, res)
+}
+
+// putCachedResultParams writes header of results buffer with count of items and with aggregations of res
+func putCachedResultParams(ser *cjson.Serializer, format int, count int, res *bindings.CachedResult) {
+	ser.PutVarUInt(uint64(format))
+	ser.PutVarUInt(uint64(res.TotalCount))
+	ser.PutVarUInt(uint64(count))
+	ser.PutVarUInt(uint64(count))
+	for _, agg := range res.Aggregations {
+		ser.PutVarUInt(bindings.QueryResultAggregation)
+		ser.PutUInt32(uint32(len(agg)))
+		ser.Write(agg)
+	}
+	ser.PutVarUInt(bindings.QueryResultEnd)
+}
+
+// cachedIterator returns iterator of items of res. Items are encoded to results buffer like the server ones,
+// so objects of iterator are decoded copies of items, like objects of query, which bypasses object cache
+func (db *reindexerImpl) cachedIterator(ctx context.Context, q *Query, res bindings.CachedResult) *Iterator {
+	ns, err := db.getNS(q.Namespace)
+	if err != nil {
+		return errIterator(err)
+	}
+	// Tags of cached items are not known by server, so they are encoded with their own state
+	state := cjson.NewState()
+	enc := state.NewEncoder()
+	enc.SetFloatFormat(ns.floatFormat)
+	ser := cjson.NewSerializer(nil)
+	putCachedResultParams(&ser, bindings.ResultsCJson, len(res.Items), &res)
+	data := cjson.NewSerializer(nil)
+	for _, item := range res.Items {
+		if !ns.isItemType(item) {
+			return errIterator(ErrWrongType)
+		}
+		data = cjson.NewSerializer(data.Bytes()[:0])
+		if err = enc.EncodeRaw(item, &data); err != nil {
+			return errIterator(err)
+		}
+		ser.PutUInt32(uint32(len(data.Bytes())))
+		ser.Write(data.Bytes())
+	}
+	q.nsArray = append(q.nsArray, nsArrayEntry{ns, state, true})
+	return newIterator(ctx, db, q, cachedBuffer(ser.Bytes()), q.nsArray, q.joinToFields, q.joinHandlers, q.context)
+}
+
+// cachedJSONIterator returns JSON iterator of JSON documents of res
+func (db *reindexerImpl) cachedJSONIterator(ctx context.Context, q *Query, jsonRoot string, res bindings.CachedResult) *JSONIterator {
+	ns, err := db.getNS(q.Namespace)
+	if err != nil {
+		return errJSONIterator(err)
+	}
+	ser := cjson.NewSerializer(nil)
+	putCachedResultParams(&ser, bindings.ResultsJson, len(res.JSON), &res)
+	for _, doc := range res.JSON {
+		ser.PutUInt32(uint32(len(doc)))
+		ser.Write(doc)
+	}
+	q.nsArray = append(q.nsArray, nsArrayEntry{ns, ns.cjsonState.Copy(), false})
+	var params rawResultQueryParams
+	if q.json, q.jsonOffsets, params, err = db.rawResultToJson(ser.Bytes(), q.nsArray, jsonRoot, q.totalName, q.json, q.jsonOffsets); err != nil {
+		return errJSONIterator(err)
+	}
+	params.nsStateLSN = q.stateLSN
+	return newJSONIterator(ctx, db, q, q.json, q.jsonOffsets, q.Namespace, params)
+}
+
+// isItemType returns true, if item is value or pointer of type of namespace
+func (ns *reindexerNamespace) isItemType(item interface{}) bool {
+	t := reflect.TypeOf(item)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == ns.rtype
+}
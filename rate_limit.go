@@ -0,0 +1,171 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// ErrRateLimited is returned, when request is rejected by client-side rate limit rule with RateLimitError action
+type ErrRateLimited struct {
+	// Namespace and command class of the rejected request
+	Namespace    string
+	CommandClass string
+	// Rule, which rejected the request
+	Rule RateRule
+}
+
+func (e ErrRateLimited) Error() string {
+	return fmt.Sprintf("rq: rate limit of %g requests per second is exceeded by %s request to namespace '%s'", e.Rule.RPS, e.CommandClass, e.Namespace)
+}
+
+func (e ErrRateLimited) Code() int {
+	return ErrCodeLogic
+}
+
+// rateBucket is a token bucket of one rule. Tokens may become negative: requests, which are waiting for the rule, borrow them
+type rateBucket struct {
+	// counters are first to be aligned for atomic operations
+	allowed  int64
+	delayed  int64
+	rejected int64
+	rule     RateRule
+	lock     sync.Mutex
+	tokens   float64
+	last     time.Time
+}
+
+type rateLimiter struct {
+	buckets []*rateBucket
+}
+
+type rateReservation struct {
+	bucket  *rateBucket
+	delayed bool
+}
+
+func newRateLimiter(rules []RateRule) (*rateLimiter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rl := &rateLimiter{buckets: make([]*rateBucket, 0, len(rules))}
+	for _, rule := range rules {
+		if !(rule.RPS > 0) || rule.Burst < 0 || (rule.OnLimit != RateLimitBlock && rule.OnLimit != RateLimitError) {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Invalid rate limit rule %+v", rule), ErrCodeParams)
+		}
+		if rule.Burst == 0 {
+			rule.Burst = 1
+		}
+		rl.buckets = append(rl.buckets, &rateBucket{rule: rule, tokens: float64(rule.Burst)})
+	}
+	return rl, nil
+}
+
+func (b *rateBucket) matches(namespace, class string) bool {
+	m := &b.rule.Match
+	return (len(m.Namespace) == 0 || strings.EqualFold(m.Namespace, namespace)) && (len(m.CommandClass) == 0 || m.CommandClass == class)
+}
+
+// refill adds tokens, accumulated since the last call. Must be called under lock
+func (b *rateBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rule.RPS
+		if burst := float64(b.rule.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
+
+// wait takes tokens of all the rules, which match the request, waiting for them if necessary. Nil limiter allows everything
+func (rl *rateLimiter) wait(ctx context.Context, namespace, class string) error {
+	if rl == nil {
+		return nil
+	}
+	var reserved []rateReservation
+	var delay time.Duration
+	now := time.Now()
+	for _, b := range rl.buckets {
+		if !b.matches(namespace, class) {
+			continue
+		}
+		b.lock.Lock()
+		b.refill(now)
+		if b.tokens >= 1 {
+			b.tokens--
+			b.lock.Unlock()
+			reserved = append(reserved, rateReservation{bucket: b})
+			continue
+		}
+		if b.rule.OnLimit == RateLimitError {
+			b.lock.Unlock()
+			atomic.AddInt64(&b.rejected, 1)
+			rl.cancel(reserved)
+			return ErrRateLimited{Namespace: namespace, CommandClass: class, Rule: b.rule}
+		}
+		if d := time.Duration((1 - b.tokens) / b.rule.RPS * float64(time.Second)); d > delay {
+			delay = d
+		}
+		b.tokens--
+		b.lock.Unlock()
+		reserved = append(reserved, rateReservation{bucket: b, delayed: true})
+	}
+
+	if delay > 0 {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			rl.cancel(reserved)
+			return context.DeadlineExceeded
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			rl.cancel(reserved)
+			return ctx.Err()
+		}
+	}
+	for _, r := range reserved {
+		if r.delayed {
+			atomic.AddInt64(&r.bucket.delayed, 1)
+		} else {
+			atomic.AddInt64(&r.bucket.allowed, 1)
+		}
+	}
+	return nil
+}
+
+// cancel returns tokens of the request, which was not sent
+func (rl *rateLimiter) cancel(reserved []rateReservation) {
+	for _, r := range reserved {
+		r.bucket.lock.Lock()
+		if r.bucket.tokens++; r.bucket.tokens > float64(r.bucket.rule.Burst) {
+			r.bucket.tokens = float64(r.bucket.rule.Burst)
+		}
+		r.bucket.lock.Unlock()
+		if r.delayed {
+			atomic.AddInt64(&r.bucket.rejected, 1)
+		}
+	}
+}
+
+func (rl *rateLimiter) status() []bindings.StatusRateLimit {
+	if rl == nil {
+		return nil
+	}
+	res := make([]bindings.StatusRateLimit, 0, len(rl.buckets))
+	for _, b := range rl.buckets {
+		res = append(res, bindings.StatusRateLimit{
+			Rule:     b.rule,
+			Allowed:  atomic.LoadInt64(&b.allowed),
+			Delayed:  atomic.LoadInt64(&b.delayed),
+			Rejected: atomic.LoadInt64(&b.rejected),
+		})
+	}
+	return res
+}
@@ -35,15 +35,15 @@ type indexOptions struct {
 	isSparse    bool
 }
 
-func parseIndex(namespace string, st reflect.Type, joined *map[string][]int) (indexDefs []bindings.IndexDef, err error) {
-	if err = parse(&indexDefs, st, false, "", "", joined, nil); err != nil {
+func parseIndex(namespace string, st reflect.Type, joined *map[string][]int, floats map[string]bindings.FloatFormat, times map[string]timeRepr) (indexDefs []bindings.IndexDef, err error) {
+	if err = parse(&indexDefs, st, false, "", "", joined, floats, times, nil); err != nil {
 		return nil, err
 	}
 
 	return indexDefs, nil
 }
 
-func parse(indexDefs *[]bindings.IndexDef, st reflect.Type, subArray bool, reindexBasePath, jsonBasePath string, joined *map[string][]int, parsed *map[string]bool) (err error) {
+func parse(indexDefs *[]bindings.IndexDef, st reflect.Type, subArray bool, reindexBasePath, jsonBasePath string, joined *map[string][]int, floats map[string]bindings.FloatFormat, times map[string]timeRepr, parsed *map[string]bool) (err error) {
 	if len(jsonBasePath) != 0 && !strings.HasSuffix(jsonBasePath, ".") {
 		jsonBasePath = jsonBasePath + "."
 	}
@@ -101,6 +101,30 @@ func parse(indexDefs *[]bindings.IndexDef, st reflect.Type, subArray bool, reind
 			opts.isArray = true
 		}
 
+		if floatFormat, isSet, err := parseFloatFormat(&idxSettings); err != nil {
+			return fmt.Errorf("%s on field %s", err.Error(), st.Field(i).Name)
+		} else if isSet {
+			if fieldType, _ := getFieldType(t); fieldType != "double" {
+				return fmt.Errorf("Float format options are allowed only on float fields: Invalid tags %v on field %s", tagsSlice, st.Field(i).Name)
+			}
+			floats[jsonPath] = floatFormat
+		}
+
+		if repr, isSet, err := parseTimeRepr(&idxSettings); err != nil {
+			return fmt.Errorf("%s on field %s", err.Error(), st.Field(i).Name)
+		} else if isSet {
+			if !repr.allowedOn(t) {
+				return fmt.Errorf("Time option '%s' is not allowed on field of type %s: Invalid tags %v on field %s", repr, t, tagsSlice, st.Field(i).Name)
+			}
+			times[jsonPath] = repr
+		}
+
+		if parseByKeyWord(&idxSettings, "int_array") {
+			if (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) || t.Elem().Kind() != reflect.Uint8 {
+				return fmt.Errorf("'int_array' tag allowed only on []uint8 fields: Invalid tags %v on field %s", tagsSlice, st.Field(i).Name)
+			}
+		}
+
 		if opts.isPk && strings.TrimSpace(idxName) == "" {
 			return fmt.Errorf("No index name is specified for primary key in field %s", st.Field(i).Name)
 		}
@@ -115,7 +139,7 @@ func parse(indexDefs *[]bindings.IndexDef, st reflect.Type, subArray bool, reind
 				return err
 			}
 		} else if t.Kind() == reflect.Struct {
-			if err := parse(indexDefs, t, subArray, reindexPath, jsonPath, joined, parsed); err != nil {
+			if err := parse(indexDefs, t, subArray, reindexPath, jsonPath, joined, floats, times, parsed); err != nil {
 				return err
 			}
 		} else if (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) &&
@@ -123,7 +147,7 @@ func parse(indexDefs *[]bindings.IndexDef, st reflect.Type, subArray bool, reind
 			// Check if field nested slice of struct
 			if parseByKeyWord(&idxSettings, "joined") && len(idxName) > 0 {
 				(*joined)[tagsSlice[0]] = st.Field(i).Index
-			} else if err := parse(indexDefs, t.Elem(), true, reindexPath, jsonPath, joined, parsed); err != nil {
+			} else if err := parse(indexDefs, t.Elem(), true, reindexPath, jsonPath, joined, floats, times, parsed); err != nil {
 				return err
 			}
 		} else if len(idxName) > 0 {
@@ -251,6 +275,47 @@ func parseCollate(idxSettingsBuf *[]string) (int, string) {
 	return collateMode, sortOrderLetters
 }
 
+func parseFloatFormat(idxSettingsBuf *[]string) (format bindings.FloatFormat, isSet bool, err error) {
+	newIdxSettingsBuf := make([]string, 0)
+
+	for _, idxSetting := range *idxSettingsBuf {
+		if ok, err := cjson.ParseFloatOption(idxSetting, &format); err != nil {
+			return format, false, err
+		} else if ok {
+			isSet = true
+			continue
+		}
+		newIdxSettingsBuf = append(newIdxSettingsBuf, idxSetting)
+	}
+
+	*idxSettingsBuf = newIdxSettingsBuf
+
+	return format, isSet, nil
+}
+
+func parseTimeRepr(idxSettingsBuf *[]string) (repr timeRepr, isSet bool, err error) {
+	newIdxSettingsBuf := make([]string, 0)
+
+	for _, idxSetting := range *idxSettingsBuf {
+		kv := strings.SplitN(idxSetting, "=", 2)
+		if kv[0] != "time" {
+			newIdxSettingsBuf = append(newIdxSettingsBuf, idxSetting)
+			continue
+		}
+		if len(kv) != 2 {
+			return repr, false, fmt.Errorf("Invalid time option '%s'", idxSetting)
+		}
+		if repr, err = parseTimeReprName(kv[1]); err != nil {
+			return repr, false, err
+		}
+		isSet = true
+	}
+
+	*idxSettingsBuf = newIdxSettingsBuf
+
+	return repr, isSet, nil
+}
+
 func parseExpireAfter(str string) int {
 	expireAfter := 0
 	if len(str) > 0 {
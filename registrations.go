@@ -0,0 +1,170 @@
+package reindexer
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// clientPackage - package of the client, which frames are skipped on search of caller of registration
+var clientPackage = reflect.TypeOf(Reindexer{}).PkgPath()
+
+// registrationAudit - the last registrations of namespaces by OpenNamespace and RegisterNamespace (see Registrations)
+type registrationAudit struct {
+	disabled bool
+	lock     sync.Mutex
+	regs     map[string]*bindings.RegistrationInfo
+}
+
+// funcPackage returns package of full name of function, e.g. 'github.com/restream/reindexer' of 'github.com/restream/reindexer.(*Reindexer).OpenNamespace'
+func funcPackage(name string) string {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot]
+	}
+	return name
+}
+
+// caller returns registration with the nearest caller outside of the client. Caller is empty, if it's unknown,
+// e.g. when namespace is reopened by the client itself
+func (a *registrationAudit) caller() (reg bindings.RegistrationInfo) {
+	var pcs [16]uintptr
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs[:])])
+	for {
+		f, more := frames.Next()
+		if pkg := funcPackage(f.Function); pkg != "" && pkg != clientPackage && pkg != "runtime" {
+			return bindings.RegistrationInfo{Package: pkg, File: f.File, Line: f.Line, Function: f.Function}
+		}
+		if !more {
+			return reg
+		}
+	}
+}
+
+// nsOptionNames returns names of NamespaceOptions methods, which set opts
+func nsOptionNames(opts *NamespaceOptions) (names []string) {
+	flags := []struct {
+		set  bool
+		name string
+	}{
+		{!opts.enableStorage, "NoStorage"},
+		{opts.dropOnIndexesConflict, "DropOnIndexesConflict"},
+		{opts.dropOnFileFormatError, "DropOnFileFormatError"},
+		{opts.disableObjCache, "DisableObjCache"},
+		{opts.queryDefaults != NamespaceQueryDefaults{}, "QueryDefaults"},
+		{opts.unknownFields != 0, "UnknownFields"},
+		{opts.softDelete != SoftDeleteOptions{}, "SoftDelete"},
+		{opts.upsertDedupEntries != 0, "SkipUnchangedUpserts"},
+		{opts.orderedWriteLanes != 0, "OrderedWrites"},
+		{opts.replicaEnabled, "LocalReplica"},
+		{opts.schemaCheck, "CheckSchemaEvolution"},
+		{opts.acknowledgeSchema, "AcknowledgeSchemaChange"},
+	}
+	for _, f := range flags {
+		if f.set {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// conflict counts registration of namespace of prev with another struct from another code location and returns warning about it or ""
+func (a *registrationAudit) conflict(prev *bindings.RegistrationInfo, reg *bindings.RegistrationInfo) string {
+	if prev.Type == reg.Type || prev.File == "" || reg.File == "" || prev.File == reg.File && prev.Line == reg.Line {
+		return ""
+	}
+	prev.Conflicts++
+	return fmt.Sprintf("rq: Namespace '%s' is registered with type %s at %s:%d and with type %s at %s:%d",
+		reg.Namespace, prev.Type, prev.File, prev.Line, reg.Type, reg.File, reg.Line)
+}
+
+// record replaces registration of namespace by reg of OpenNamespace (open) or RegisterNamespace. RegisterNamespace of registered struct
+// doesn't change registration. Registration with another struct from another code location is warned
+func (a *registrationAudit) record(reg bindings.RegistrationInfo, open bool) {
+	if a.disabled {
+		return
+	}
+	reg.Time = time.Now()
+	a.lock.Lock()
+	prev, ok := a.regs[reg.Namespace]
+	var warning string
+	if ok {
+		if !open && prev.Type == reg.Type {
+			a.lock.Unlock()
+			return
+		}
+		warning = a.conflict(prev, &reg)
+		reg.Conflicts = prev.Conflicts
+		if reg.File == "" {
+			reg.Package, reg.File, reg.Line, reg.Function = prev.Package, prev.File, prev.Line, prev.Function
+		}
+	}
+	if a.regs == nil {
+		a.regs = make(map[string]*bindings.RegistrationInfo)
+	}
+	a.regs[reg.Namespace] = &reg
+	a.lock.Unlock()
+	if warning != "" {
+		logger.Printf(bindings.WARNING, "%s\n", warning)
+	}
+}
+
+// mismatch warns about registration reg, which failed by ErrNamespaceStructMismatch, if it's made from another code location
+func (a *registrationAudit) mismatch(reg bindings.RegistrationInfo) {
+	if a.disabled {
+		return
+	}
+	a.lock.Lock()
+	var warning string
+	if prev, ok := a.regs[reg.Namespace]; ok {
+		warning = a.conflict(prev, &reg)
+	}
+	a.lock.Unlock()
+	if warning != "" {
+		logger.Printf(bindings.WARNING, "%s\n", warning)
+	}
+}
+
+// list returns copies of registrations, which are sorted by namespaces
+func (a *registrationAudit) list() []bindings.RegistrationInfo {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.regs) == 0 {
+		return nil
+	}
+	regs := make([]bindings.RegistrationInfo, 0, len(a.regs))
+	for _, reg := range a.regs {
+		regs = append(regs, *reg)
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].Namespace < regs[j].Namespace })
+	return regs
+}
+
+// registration returns registration of namespace with struct t and opts by caller of OpenNamespace or RegisterNamespace
+func (db *reindexerImpl) registration(namespace string, t reflect.Type, opts *NamespaceOptions) (reg bindings.RegistrationInfo) {
+	if db.registrations.disabled {
+		return reg
+	}
+	reg = db.registrations.caller()
+	reg.Namespace, reg.Type, reg.Options = namespace, t.String(), nsOptionNames(opts)
+	return reg
+}
+
+// structMismatch returns ErrNamespaceStructMismatch of OpenNamespace and warns about it (see registrationAudit.mismatch)
+func (db *reindexerImpl) structMismatch(namespace string, opts *NamespaceOptions, registered, requested reflect.Type) error {
+	db.registrations.mismatch(db.registration(namespace, requested, opts))
+	return nsStructMismatch(namespace, registered, requested)
+}
+
+// Registrations returns the last registrations of namespaces by OpenNamespace and RegisterNamespace of the client, which are sorted by namespaces.
+// Each one contains caller of registration, struct, options and actions of open. Registrations are kept after close of namespaces,
+// so registration with another struct from another code location is warned by logger (see WithDisableRegistrationAudit)
+func (db *Reindexer) Registrations() []RegistrationInfo {
+	return db.impl.registrations.list()
+}
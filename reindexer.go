@@ -2,9 +2,13 @@ package reindexer
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/restream/reindexer/bindings"
 	_ "github.com/restream/reindexer/bindings/cproto"
+	"github.com/restream/reindexer/cjson"
 	"github.com/restream/reindexer/dsl"
 	// _ "github.com/restream/reindexer/bindings/builtinserver"
 )
@@ -73,7 +77,12 @@ const (
 	ErrCodeNetwork          = bindings.ErrNetwork
 	ErrCodeNotFound         = bindings.ErrNotFound
 	ErrCodeStateInvalidated = bindings.ErrStateInvalidated
+	ErrCodeStateMismatch    = bindings.ErrStateMismatch
 	ErrCodeTimeout          = bindings.ErrTimeout
+	// Connection was broken after request was sent, so request may be applied by server (see WithRequeueOnConnLoss)
+	ErrCodeConnBrokenMidRequest = bindings.ErrConnBrokenMidRequest
+	// All the request slots of connection are busy (see WithQueueFullError)
+	ErrCodeQueueFull = bindings.ErrQueueFull
 )
 
 var logger Logger = &nullLogger{}
@@ -93,6 +102,133 @@ type Error interface {
 	Code() int
 }
 
+// ErrResultsLostOnReconnect - error of iteration, when cproto connection, which owned query results, was broken.
+// Use WithAutoReexecute to continue iteration on the other connection
+type ErrResultsLostOnReconnect = bindings.ErrResultsLostOnReconnect
+
+// ErrResultCorrupted - error of query execution or iteration, when checksum of results chunk doesn't match. See WithResultChecksum
+type ErrResultCorrupted = bindings.ErrResultCorrupted
+
+// ErrCircuitOpen - error of request, which is not sent to server, because its circuit breaker is open. See WithCircuitBreaker
+type ErrCircuitOpen = bindings.ErrCircuitOpen
+
+// CircuitBreakerEvent - transition of circuit breaker of cproto binding. See WithCircuitBreaker
+type CircuitBreakerEvent = bindings.CircuitBreakerEvent
+
+// QueryDescription - query, which is passed to query hooks. See WithQueryHooks
+type QueryDescription = bindings.QueryDescription
+
+// CachedResult - result of query, which is served by query hooks. See WithQueryHooks
+type CachedResult = bindings.CachedResult
+
+// ResultSummary - metadata of results of query, which is passed to query hooks. See WithQueryHooks
+type ResultSummary = bindings.ResultSummary
+
+// ErrValueOverflow - error of decoding, when stored value doesn't fit into integer type of the field. See WithIntTruncation
+type ErrValueOverflow = cjson.ErrValueOverflow
+
+// FieldsPresence - fields, which are present in the stored document. See Iterator.WithFieldsPresence
+type FieldsPresence = cjson.FieldsPresence
+
+// Unknown - carrier of fields of stored document, which are not in the struct. See NamespaceOptions.UnknownFields
+type Unknown = cjson.UnknownFields
+
+// ErrUnknownField - error of decoding of document with field, which is not in the struct. See NamespaceOptions.UnknownFields
+type ErrUnknownField = cjson.ErrUnknownField
+
+// RateRule - client-side rate limit rule. See WithRateLimit
+type RateRule = bindings.RateRule
+
+// RateMatch - requests, which rate limit rule is applied to
+type RateMatch = bindings.RateMatch
+
+// SlowRPC - info about slow network request. See WithSlowRPCHook
+type SlowRPC = bindings.SlowRPC
+
+// RPCSpan - traced network request. See WithTracer
+type RPCSpan = bindings.RPCSpan
+
+// RPCSpanEnd - result of traced network request. See WithTracer
+type RPCSpanEnd = bindings.RPCSpanEnd
+
+// RequestLogEntry - network request, which is passed to hook of WithRequestLog
+type RequestLogEntry = bindings.RequestLogEntry
+
+// ShutdownReport - operations, which were live, when client was closed (see WithShutdownReport)
+type ShutdownReport = bindings.ShutdownReport
+
+// ShutdownOperation - operation of ShutdownReport
+type ShutdownOperation = bindings.ShutdownOperation
+
+// RegistrationInfo - registration of namespace and its caller (see Registrations)
+type RegistrationInfo = bindings.RegistrationInfo
+
+// DestructiveOp - destructive operation, which is checked by destructive guard. See WithDestructiveGuard
+type DestructiveOp = bindings.DestructiveOp
+
+// Types of destructive operations
+const (
+	DestructiveDropNamespace     = bindings.DestructiveDropNamespace
+	DestructiveTruncateNamespace = bindings.DestructiveTruncateNamespace
+	DestructiveDropIndex         = bindings.DestructiveDropIndex
+)
+
+// DisconnectEvent - cproto connection, which was closed not by the client. See WithOnDisconnect
+type DisconnectEvent = bindings.DisconnectEvent
+
+// Reasons of disconnects
+const (
+	DisconnectNetworkError   = bindings.DisconnectNetworkError
+	DisconnectServerShutdown = bindings.DisconnectServerShutdown
+)
+
+// Strategies of choice of cproto connection for request. See WithPoolStrategy
+const (
+	PoolRoundRobin       = bindings.PoolRoundRobin
+	PoolLeastInFlight    = bindings.PoolLeastInFlight
+	PoolTwoRandomChoices = bindings.PoolTwoRandomChoices
+)
+
+// WriteJournalEntry - write operation, which is reported to write journal. See WithWriteJournal
+type WriteJournalEntry = bindings.WriteJournalEntry
+
+// Kinds of write operations
+const (
+	WriteOpInsert      = bindings.WriteOpInsert
+	WriteOpUpdate      = bindings.WriteOpUpdate
+	WriteOpUpsert      = bindings.WriteOpUpsert
+	WriteOpDelete      = bindings.WriteOpDelete
+	WriteOpUpdateQuery = bindings.WriteOpUpdateQuery
+	WriteOpDeleteQuery = bindings.WriteOpDeleteQuery
+	WriteOpCommitTx    = bindings.WriteOpCommitTx
+)
+
+// FloatFormat - format of float fields. See WithFloatFormat
+type FloatFormat = bindings.FloatFormat
+
+// Handling of fields of stored documents, which are not in the struct of namespace. See NamespaceOptions.UnknownFields
+const (
+	DropUnknownFields     = cjson.UnknownFieldsDrop
+	PreserveUnknownFields = cjson.UnknownFieldsPreserve
+	ErrorOnUnknownFields  = cjson.UnknownFieldsError
+)
+
+// Handling of NaN and Inf values of float fields
+const (
+	FloatNaNKeep  = bindings.FloatNaNKeep
+	FloatNaNNull  = bindings.FloatNaNNull
+	FloatNaNError = bindings.FloatNaNError
+)
+
+// Actions of rate limit rule and command classes of requests
+const (
+	RateLimitBlock     = bindings.RateLimitBlock
+	RateLimitError     = bindings.RateLimitError
+	CommandClassRead   = bindings.CommandClassRead
+	CommandClassWrite  = bindings.CommandClassWrite
+	CommandClassSchema = bindings.CommandClassSchema
+)
+
 // Joinable is an interface for append joined items
 type Joinable interface {
 	Join(field string, subitems []interface{}, context interface{})
@@ -124,7 +260,6 @@ func (nullLogger) Printf(level int, fmt string, msg ...interface{}) {
 
 var (
 	errNsNotFound          = bindings.NewError("rq: Namespace is not found", ErrCodeNotFound)
-	errNsExists            = bindings.NewError("rq: Namespace is already exists", ErrCodeParams)
 	errInvalidReflection   = bindings.NewError("rq: Invalid reflection type of index", ErrCodeParams)
 	errStorageNotEnabled   = bindings.NewError("rq: Storage is not enabled, can't save", ErrCodeLogic)
 	errIteratorNotReady    = bindings.NewError("rq: Iterator not ready. Next() must be called before", ErrCodeLogic)
@@ -136,7 +271,10 @@ var (
 	ErrOpInvalid           = bindings.NewError("rq: op is invalid", ErrCodeParams)
 	ErrAggInvalid          = bindings.NewError("rq: agg is invalid", ErrCodeParams)
 	ErrNoPK                = bindings.NewError("rq: No pk field in struct", ErrCodeParams)
+	ErrAsyncWritesLimit    = bindings.NewError("rq: too many async writes are waiting for reply", ErrCodeLogic)
+	ErrResultBufferLimit   = bindings.ErrReplyLimit
 	ErrWrongType           = bindings.NewError("rq: Wrong type of item", ErrCodeParams)
+	ErrClientClosed        = bindings.NewError("rq: Client is closed", ErrCodeLogic)
 	ErrMustBePointer       = bindings.NewError("rq: Argument must be a pointer to element, not element", ErrCodeParams)
 	ErrNotFound            = bindings.NewError("rq: Not found", ErrCodeNotFound)
 	ErrDeepCopyType        = bindings.NewError("rq: DeepCopy() returns wrong type", ErrCodeParams)
@@ -151,6 +289,8 @@ type AggregationResult struct {
 		Count  int      `json:"count"`
 	} `json:"facets,omitempty"`
 	Distincts []string `json:"distincts,omitempty"`
+	// Exact value of MIN/MAX aggregation. Use AggMinValue/AggMaxValue to get it
+	TypedValue *Variant `json:"typed_value,omitempty"`
 }
 
 // NewReindex Create new instanse of Reindexer DB
@@ -163,6 +303,58 @@ func NewReindex(dsn interface{}, options ...interface{}) *Reindexer {
 	return rx
 }
 
+// Open Create new instanse of Reindexer DB and verify connectivity
+// Unlike NewReindex it does not panic and does not postpone errors to the first operation:
+// bad DSN or unknown binding returns error with ErrCodeParams, auth failure - ErrCodeForbidden,
+// unreachable host - ErrCodeNetwork (or ErrCodeTimeout)
+// Options are the same as for NewReindex, also Config is accepted. Setting the same option twice is an error with ErrCodeParams
+func Open(ctx context.Context, dsn interface{}, options ...interface{}) (*Reindexer, error) {
+	scheme, dsnParsed, err := dsnParse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	binding := bindings.GetBinding(scheme)
+	if binding == nil {
+		return nil, bindings.NewError(fmt.Sprintf("rq: Reindex binding '%s' is not available, can't create DB", scheme), ErrCodeParams)
+	}
+
+	bindingOptions, log, err := prepareOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	rx := &Reindexer{
+		impl: newReindexImplWithBinding(binding.Clone(), dsnParsed, bindingOptions...),
+		ctx:  context.TODO(),
+	}
+	if log != nil {
+		rx.impl.setLogger(log)
+	}
+	if err = rx.impl.status; err == nil {
+		err = rx.impl.ping(ctx)
+	}
+	if err != nil {
+		rx.impl.close(ctx)
+		return nil, err
+	}
+	return rx, nil
+}
+
+// MustOpen Create new instanse of Reindexer DB and verify connectivity, panic on error
+func MustOpen(dsn interface{}, options ...interface{}) *Reindexer {
+	rx, err := Open(context.Background(), dsn, options...)
+	if err != nil {
+		panic(err)
+	}
+	return rx
+}
+
+// ServerVersion returns version of reindexer server. Empty string, if version is unknown yet (there was no successful connect)
+func (db *Reindexer) ServerVersion() string {
+	return db.impl.serverVersion()
+}
+
 // Status will return current db status
 func (db *Reindexer) Status() bindings.Status {
 	return db.impl.getStatus(db.ctx)
@@ -173,18 +365,47 @@ func (db *Reindexer) SetLogger(log Logger) {
 	db.impl.setLogger(log)
 }
 
+// UpdateOptions changes options of the client at runtime without reconnect. Subsequent operations use the new values,
+// in-flight ones keep the old ones (e.g. deadline of request, which is set by request timeout). Options, which may be changed:
+// WithTimeouts, WithLoginTimeout, WithRequestTimeout, WithRetryAttempts, WithSlowRPCHook, WithTracer, WithRequestLog, WithDeadlineFloor,
+// WithConnPoolSize, WithRateLimit, WithMaxResultBufferBytes, WithLogger and WithLogLevel. Options of cproto binding aren't supported by builtin bindings.
+// The other options (DSN, TLS, etc) are rejected with ErrCodeParams, nothing is changed in this case
+func (db *Reindexer) UpdateOptions(options ...interface{}) error {
+	return db.impl.updateOptions(options...)
+}
+
 // ReopenLogFiles reopens log files
 func (db *Reindexer) ReopenLogFiles() error {
 	return db.impl.reopenLogFiles()
 }
 
+// BindingStats returns statistics of connections of cproto binding by slots of the pool, e.g. for periodic export to monitoring.
+// Counters are atomic and are always updated, so call is cheap. It's nil for builtin bindings
+func (db *Reindexer) BindingStats() []bindings.ConnStats {
+	return db.impl.bindingStats()
+}
+
 // Ping checks connection with reindexer
 func (db *Reindexer) Ping() error {
 	return db.impl.ping(db.ctx)
 }
 
-func (db *Reindexer) Close() {
-	db.impl.close()
+// PingCtx checks connection with reindexer like Ping, but is bounded by ctx, e.g. for health checks and readiness probes.
+// cproto binding sends ping via connection of the pool, which is connected and logged in first, if it's needed.
+// Ping fails with context.DeadlineExceeded or context.Canceled, when ctx is done, like the other requests
+func (db *Reindexer) PingCtx(ctx context.Context) error {
+	return db.impl.ping(ctx)
+}
+
+// Close closes all the connections, stops background goroutines and frees resources
+// All the subsequent calls will return ErrClientClosed
+func (db *Reindexer) Close() error {
+	return db.impl.close(db.ctx)
+}
+
+// CloseCtx closes client like Close, but waits for in-flight network requests until ctx is done
+func (db *Reindexer) CloseCtx(ctx context.Context) error {
+	return db.impl.close(ctx)
 }
 
 func (db *Reindexer) RenameNs(srcNsName string, dstNsName string) {
@@ -208,8 +429,24 @@ type NamespaceOptions struct {
 	dropOnIndexesConflict bool
 	// Drop on file errors
 	dropOnFileFormatError bool
+	// Defaults of queries to namespace
+	queryDefaults NamespaceQueryDefaults
 	// Disable object cache
 	disableObjCache bool
+	// Soft deletes of items
+	softDelete SoftDeleteOptions
+	// Handling of unknown fields of documents
+	unknownFields int
+	// Limit of PKs of upsert deduplication (0 - upserts are not deduplicated)
+	upsertDedupEntries int
+	// Count of lanes of ordered writes (0 - writes are not ordered)
+	orderedWriteLanes int
+	// Local replica of namespace
+	localReplica   LocalReplicaOptions
+	replicaEnabled bool
+	// Check of changes of stored schema and acknowledge of them
+	schemaCheck       bool
+	acknowledgeSchema bool
 }
 
 // DefaultNamespaceOptions return defailt namespace options
@@ -237,8 +474,117 @@ func (opts *NamespaceOptions) DisableObjCache() *NamespaceOptions {
 	return opts
 }
 
+// QueryDefaults sets default options, which are applied to each query to namespace, including joined and merged queries
+func (opts *NamespaceOptions) QueryDefaults(defaults NamespaceQueryDefaults) *NamespaceOptions {
+	opts.queryDefaults = defaults
+	return opts
+}
+
+// UnknownFields sets handling of fields of stored documents, which are not in the struct of namespace, e.g. fields added by
+// other services: DropUnknownFields (default), ErrorOnUnknownFields or PreserveUnknownFields. With PreserveUnknownFields fields
+// are decoded to exported field of type reindexer.Unknown of the struct (or of its nested struct) and are written back with the item
+func (opts *NamespaceOptions) UnknownFields(policy int) *NamespaceOptions {
+	opts.unknownFields = policy
+	return opts
+}
+
+// SoftDelete enables soft deletes: items with true value of field are excluded from queries to namespace and Delete sets field
+// to true instead of deleting item. See SoftDeleteOptions
+func (opts *NamespaceOptions) SoftDelete(field string) *NamespaceOptions {
+	opts.softDelete = SoftDeleteOptions{Field: field}
+	return opts
+}
+
+// SoftDeleteWith enables soft deletes with options
+func (opts *NamespaceOptions) SoftDeleteWith(softDelete SoftDeleteOptions) *NamespaceOptions {
+	opts.softDelete = softDelete
+	return opts
+}
+
+// SkipUnchangedUpserts - Upsert and Tx.Upsert of item struct are not sent to server, if the item is the same as in the last upsert
+// of its PK by this client. Fields, which are set by precepts, are not compared. Items are compared by 128-bit hashes of their
+// encoded content, which are kept for up to 100000 the most recently written PKs (see SkipUnchangedUpsertsLimit).
+// Hash is forgotten by the other writes of the item and by writes, which may change any items: queries, JSON items, truncate.
+// Changes of items by other clients are not seen, so namespace must be written only by this client.
+// Skipped upserts are counted in Status().UpsertDedup
+func (opts *NamespaceOptions) SkipUnchangedUpserts() *NamespaceOptions {
+	return opts.SkipUnchangedUpsertsLimit(defaultUpsertDedupEntries)
+}
+
+// SkipUnchangedUpsertsLimit - SkipUnchangedUpserts, which keeps hashes for up to entries PKs (0 - upserts are not deduplicated)
+func (opts *NamespaceOptions) SkipUnchangedUpsertsLimit(entries int) *NamespaceOptions {
+	opts.upsertDedupEntries = entries
+	return opts
+}
+
+// OrderedWrites - writes of item struct are serialized by its PK: Upsert, Insert, Update, Delete and their async variants
+// of the same PK are sent in order of submission via the same connection of the pool, so the server applies them in that order,
+// while writes of different PKs are sent in parallel. PKs are mapped to 64 lanes by hash (see OrderedWritesLanes).
+// Write waits for completion of the previous synchronous write of its lane and async write waits until the previous write of lane is sent,
+// so throughput of writes of the same PK (and of PKs, which share lane) is limited by round trip to server. Order isn't guaranteed
+// for async writes, which are resent after refresh of tags state, and for JSON items, queries and transactions
+func (opts *NamespaceOptions) OrderedWrites() *NamespaceOptions {
+	return opts.OrderedWritesLanes(defaultOrderedWriteLanes)
+}
+
+// OrderedWritesLanes - OrderedWrites with lanes count of lanes (0 - writes are not ordered)
+func (opts *NamespaceOptions) OrderedWritesLanes(lanes int) *NamespaceOptions {
+	opts.orderedWriteLanes = lanes
+	return opts
+}
+
+// LocalReplica - client keeps copy of all the items of namespace, which is loaded by OpenNamespace, and serves queries
+// with AND of EQ and SET conditions, sorting, offset, limit and total count from it, if they use only scalar indexes without collation.
+// The other queries (joins, fulltext, aggregations, select filters, etc), JSON queries and queries of transactions are sent to server.
+// Replica is checked by namespace state token each poll interval and reloaded, when namespace is changed. Replica, which can't be checked
+// for MaxStaleness, isn't used until the next successful check. Writes of this client make replica stale until it's reloaded, so the client reads its own writes.
+// Changes of other clients are seen by queries with delay up to the poll interval. It's intended for small namespaces, which are rarely changed
+func (opts *NamespaceOptions) LocalReplica(replica LocalReplicaOptions) *NamespaceOptions {
+	opts.localReplica, opts.replicaEnabled = replica, true
+	return opts
+}
+
+// CheckSchemaEvolution - OpenNamespace stores fingerprint of fields and indexes of struct in meta of namespace and compares it with
+// the stored one on the next opens: removed, renamed or changed fields and indexes fail OpenNamespace with ErrSchemaEvolution,
+// so data of renamed field doesn't become invisible silently. Added fields and indexes are allowed and are stored. Order of fields doesn't matter
+func (opts *NamespaceOptions) CheckSchemaEvolution() *NamespaceOptions {
+	opts.schemaCheck = true
+	return opts
+}
+
+// AcknowledgeSchemaChange - CheckSchemaEvolution, which accepts changes of schema: fingerprint of the new struct is stored instead of the old one
+func (opts *NamespaceOptions) AcknowledgeSchemaChange() *NamespaceOptions {
+	opts.schemaCheck, opts.acknowledgeSchema = true, true
+	return opts
+}
+
+// SoftDeleteOptions is options of soft deletes of namespace
+type SoftDeleteOptions struct {
+	// Field - bool field, which marks deleted items. Items without it are not deleted
+	Field string
+	// TimeField - optional int64 field, which is set to unix time of soft delete. It's required by Purge with olderThan
+	TimeField string
+	// HardDelete - Delete removes items as usual, items are soft deleted by application with update of Field
+	HardDelete bool
+}
+
+// NamespaceQueryDefaults is default options of queries to namespace. Zero values are not applied
+// Each of them can be overridden per query: by Query.FetchCount, Query.Debug, Query.BypassObjCache or by deadline of ExecCtx context
+type NamespaceQueryDefaults struct {
+	// Count of items, fetched by one network request
+	FetchCount int
+	// Query execution timeout. Applied only if context of query has no deadline
+	Timeout time.Duration
+	// Query debug level
+	Debug int
+	// Decode items of results without object cache
+	BypassObjCache bool
+}
+
 // OpenNamespace Open or create new namespace and indexes based on passed struct.
 // IndexDef fields of struct are marked by `reindex:` tag
+// Concurrent calls for the same namespace share one open, namespace opened with the same struct and options is not opened again.
+// If namespace is registered with another struct, ErrNamespaceStructMismatch is returned
 func (db *Reindexer) OpenNamespace(namespace string, opts *NamespaceOptions, s interface{}) (err error) {
 	return db.impl.openNamespace(db.ctx, namespace, opts, s)
 }
@@ -275,6 +621,31 @@ func (db *Reindexer) Upsert(namespace string, item interface{}, precepts ...stri
 	return db.impl.upsert(db.ctx, namespace, item, precepts...)
 }
 
+// UpsertAsync (Insert or Update) item to namespace without waiting for reply. cmpl is called with the result of operation.
+// Item is encoded before return, but it may be encoded again, if namespace's payload type has been changed, so it should not be modified until cmpl.
+// cmpl may be called from the network reply handler, so it must not block or make synchronous requests to reindexer.
+// Order of async writes of the same item is not guaranteed. Count of writes waiting for reply is limited by WithAsyncWritesLimit.
+// Precepts are applied, but item is not updated with their results. Close waits for completions of all the async writes.
+// Bindings, which can't send items asynchronously (builtin), write item synchronously and call cmpl before return
+func (db *Reindexer) UpsertAsync(ctx context.Context, namespace string, item interface{}, cmpl bindings.Completion, precepts ...string) error {
+	return db.impl.modifyItemAsync(ctx, namespace, item, modeUpsert, cmpl, precepts...)
+}
+
+// InsertAsync item to namespace without waiting for reply. See UpsertAsync
+func (db *Reindexer) InsertAsync(ctx context.Context, namespace string, item interface{}, cmpl bindings.Completion, precepts ...string) error {
+	return db.impl.modifyItemAsync(ctx, namespace, item, modeInsert, cmpl, precepts...)
+}
+
+// UpdateAsync item in namespace without waiting for reply. See UpsertAsync
+func (db *Reindexer) UpdateAsync(ctx context.Context, namespace string, item interface{}, cmpl bindings.Completion, precepts ...string) error {
+	return db.impl.modifyItemAsync(ctx, namespace, item, modeUpdate, cmpl, precepts...)
+}
+
+// DeleteAsync - remove item from namespace without waiting for reply. See UpsertAsync
+func (db *Reindexer) DeleteAsync(ctx context.Context, namespace string, item interface{}, cmpl bindings.Completion, precepts ...string) error {
+	return db.impl.modifyItemAsync(ctx, namespace, item, modeDelete, cmpl, precepts...)
+}
+
 // Insert item to namespace by PK
 // Item must be the same type as item passed to OpenNamespace, or []byte with json data
 // Return 0, if no item was inserted, 1 if item was inserted
@@ -294,10 +665,47 @@ func (db *Reindexer) Update(namespace string, item interface{}, precepts ...stri
 // Delete - remove single item from namespace by PK
 // Item must be the same type as item passed to OpenNamespace, or []byte with json data
 // If the precepts are provided and the item is a pointer, the value pointed by item will be updated
+// Item of namespace with soft deletes is marked as deleted by update query, precepts are not applied (see NamespaceOptions.SoftDelete)
 func (db *Reindexer) Delete(namespace string, item interface{}, precepts ...string) error {
 	return db.impl.delete(db.ctx, namespace, item, precepts...)
 }
 
+// IndexUsageReport explains sampled queries of namespace and reports, which of its indexes are used by them. Queries of sample are
+// executed first, then live select queries of this client are sampled for sample.Duration. Report doesn't include queries of other clients,
+// except the server statistics of SampleOptions.EnableProfiling
+func (db *Reindexer) IndexUsageReport(ctx context.Context, namespace string, sample SampleOptions) (IndexUsageReport, error) {
+	return db.impl.indexUsageReport(ctx, namespace, sample)
+}
+
+// Purge removes soft deleted items of namespace (see NamespaceOptions.SoftDelete), which were deleted more than olderThan ago.
+// olderThan > 0 requires SoftDeleteOptions.TimeField. Return count of removed items
+func (db *Reindexer) Purge(ctx context.Context, namespace string, olderThan time.Duration) (int, error) {
+	return db.impl.purge(ctx, namespace, olderThan)
+}
+
+// UpsertJSON (Insert or Update) raw JSON item to namespace. Item is parsed by server, so namespace may be not registered by OpenNamespace.
+// Parse and validation errors of server are returned as is
+func (db *Reindexer) UpsertJSON(ctx context.Context, namespace string, json []byte, precepts ...string) error {
+	_, err := db.impl.modifyJSON(ctx, namespace, json, modeUpsert, precepts...)
+	return err
+}
+
+// InsertJSON inserts raw JSON item to namespace like UpsertJSON. Return 0, if no item was inserted, 1 if item was inserted
+func (db *Reindexer) InsertJSON(ctx context.Context, namespace string, json []byte, precepts ...string) (int, error) {
+	return db.impl.modifyJSON(ctx, namespace, json, modeInsert, precepts...)
+}
+
+// UpdateJSON updates item of namespace by raw JSON item like UpsertJSON. Return 0, if no item was updated, 1 if item was updated
+func (db *Reindexer) UpdateJSON(ctx context.Context, namespace string, json []byte, precepts ...string) (int, error) {
+	return db.impl.modifyJSON(ctx, namespace, json, modeUpdate, precepts...)
+}
+
+// DeleteJSON removes item by PK of raw JSON item like UpsertJSON
+func (db *Reindexer) DeleteJSON(ctx context.Context, namespace string, json []byte, precepts ...string) error {
+	_, err := db.impl.modifyJSON(ctx, namespace, json, modeDelete, precepts...)
+	return err
+}
+
 // ConfigureIndex - congigure index.
 // config argument must be struct with index configuration
 // Deprecated: Use UpdateIndex instead.
@@ -325,9 +733,23 @@ func (db *Reindexer) SetDefaultQueryDebug(namespace string, level int) error {
 	return db.impl.setDefaultQueryDebug(db.ctx, namespace, level)
 }
 
+// SetNamespaceCacheConfig sets cache options of namespace in '#config' system namespace.
+// Options are applied by server without reopening of the namespace
+func (db *Reindexer) SetNamespaceCacheConfig(namespace string, cfg NamespaceCacheConfig) error {
+	return db.impl.setNamespaceCacheConfig(db.ctx, namespace, cfg)
+}
+
+// GetNamespaceCacheConfig returns cache options of namespace from '#config' system namespace,
+// or options of '*' entry, if namespace has no own entry
+func (db *Reindexer) GetNamespaceCacheConfig(namespace string) (NamespaceCacheConfig, error) {
+	return db.impl.getNamespaceCacheConfig(db.ctx, namespace)
+}
+
 // Query Create new Query for building request
 func (db *Reindexer) Query(namespace string) *Query {
-	return db.impl.query(namespace)
+	q := db.impl.query(namespace)
+	q.userCtx = db.ctx
+	return q
 }
 
 // ExecSQL make query to database. Query is a SQL statement.
@@ -356,9 +778,28 @@ func (db *Reindexer) MustBeginTx(namespace string) *Tx {
 	return db.impl.mustBeginTx(db.ctx, namespace)
 }
 
+// BeginMultiTx - start transaction of several namespaces, which are committed in the passed order.
+// It's not ACID transaction: changes of each namespace are committed atomically, but the other clients can see changes
+// of some namespaces without the others. Journal of changes is written to meta of the first namespace before commits,
+// so after crash changes are completed (or compensated) by RecoverMultiTx. See MultiTx
+func (db *Reindexer) BeginMultiTx(ctx context.Context, namespaces ...string) (*MultiTx, error) {
+	return db.impl.beginMultiTx(ctx, namespaces)
+}
+
+// RecoverMultiTx completes multi-namespace transactions, which were not completed by commit (e.g. because of crash), by their journals.
+// Not committed changes are applied again, if changes are rejected, the committed ones are compensated. Journals are searched
+// in meta of namespaces, opened by the client, so it should be called on start after namespaces are opened
+func (db *Reindexer) RecoverMultiTx(ctx context.Context) ([]MultiTxRecovery, error) {
+	return db.impl.recoverMultiTx(ctx)
+}
+
 // QueryFrom - create query from DSL and execute it
 func (db *Reindexer) QueryFrom(d dsl.DSL) (*Query, error) {
-	return db.impl.queryFrom(d)
+	q, err := db.impl.queryFrom(d)
+	if q != nil {
+		q.userCtx = db.ctx
+	}
+	return q, err
 }
 
 // GetStats Get local thread reindexer usage stats
@@ -387,7 +828,30 @@ func (db *Reindexer) GetMeta(namespace, key string) ([]byte, error) {
 	return db.impl.getMeta(db.ctx, namespace, key)
 }
 
+// ExportSnapshot writes snapshot of namespace to w: its indexes, meta and documents, which are read by queries of SnapshotOptions.PageSize items
+// after PK of the last document of the previous page. Namespace without PK is rejected with ErrCodeParams.
+// Documents are written as JSON, so snapshot may be imported to server with another tags state. Snapshot isn't isolated from concurrent writes.
+// Namespace may be not registered by OpenNamespace
+func (db *Reindexer) ExportSnapshot(ctx context.Context, namespace string, w io.Writer, opts SnapshotOptions) error {
+	return db.impl.exportSnapshot(ctx, namespace, w, opts)
+}
+
+// ImportSnapshot creates namespace with indexes and meta of snapshot of ExportSnapshot and loads its documents by transactions between markers.
+// Checksum of documents is verified before commit of each transaction. Namespace is not registered by OpenNamespace.
+// Import of overwritten namespace (see SnapshotConflictOverwrite), which was registered by OpenNamespace, must be followed by OpenNamespace
+func (db *Reindexer) ImportSnapshot(ctx context.Context, namespace string, r io.Reader, opts ImportSnapshotOptions) error {
+	return db.impl.importSnapshot(ctx, namespace, r, opts)
+}
+
+// GetSQLSuggestions returns completions of SQL query token, which ends at byte position pos (len(query)-1 to complete the last one):
+// SQL keywords, namespaces, indexes, etc. Suggestions are ordered as they are returned by server. Returns ErrServerUnsupported, if it's not supported by server or binding
+func (db *Reindexer) GetSQLSuggestions(ctx context.Context, query string, pos int) ([]string, error) {
+	return db.impl.getSQLSuggestions(ctx, query, pos)
+}
+
 // WithContext Add context to next method call
+// Returned view shares connections, caches and namespaces with db. Query, BeginTx and other calls of the view use ctx by default,
+// explicit context of ...Ctx methods overrides it. Closing of db invalidates all of its views
 func (db *Reindexer) WithContext(ctx context.Context) *Reindexer {
 	dbC := &Reindexer{
 		impl: db.impl,
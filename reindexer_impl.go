@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/restream/reindexer/bindings"
 	"github.com/restream/reindexer/cjson"
@@ -26,17 +27,75 @@ type reindexerNamespace struct {
 	cjsonState    cjson.State
 	nsHash        int
 	opened        bool
+	// default format of float fields and precision of JSON output (nil - not limited)
+	floatFormat bindings.FloatFormat
+	jsonFloats  *jsonFloatFormatter
+	// truncate integer values, which don't fit into type of the field, on decode
+	truncateInts bool
+	// token of server tags state, seen in the last results (-1 - unknown), flag of reported invalidation
+	// and flag of running background invalidation. Accessed atomically
+	serverStateToken int32
+	invalidated      int32
+	invalidating     int32
+	// average size of encoded items in results of queries with fetch budget (0 - unknown). Accessed atomically
+	fetchItemSize int64
+	// hashes of upserted items (nil - upserts are not deduplicated)
+	upsertDedup *upsertDedup
+	// lanes of ordered writes (nil - writes are not ordered)
+	writeLanes *writeLanes
+	// local copy of items (nil - namespace isn't replicated)
+	replica *localReplica
+	// time representations of fields by JSON paths, which are set by 'time=...' option of reindex tag
+	timeFields map[string]timeRepr
+	// counters of object cache. Accessed atomically
+	objCacheHits   int64
+	objCacheMisses int64
 }
 
 // reindexerImpl The reindxer state struct
 type reindexerImpl struct {
 	lock          sync.RWMutex
 	ns            map[string]*reindexerNamespace
+	nsOpening     map[string]*nsOpenCall
 	storagePath   string
 	binding       bindings.RawBinding
 	debugLevels   map[string]int
 	nsHashCounter int
 	status        error
+	autoReexecute bool
+	// size of results buffers, retained by open iterators, and its limit. Accessed atomically
+	resultBufferBytes    int64
+	maxResultBufferBytes int64
+	asyncWritesOpts      bindings.OptionAsyncWrites
+	asyncWritesSem       chan struct{}
+	asyncWrites          sync.WaitGroup
+	rateLimit            atomic.Value // *rateLimiter, it's replaced by UpdateOptions
+	floatFormat          bindings.FloatFormat
+	truncateInts         bool
+	onNsInvalidated      bindings.OptionOnNamespaceInvalidated
+	destructiveGuard     func(op bindings.DestructiveOp) error
+	writeJournal         *writeJournal
+	disableObjCache      bool
+	queryHooks           bindings.OptionQueryHooks
+	// multiTxLock guards lists of not completed multi-namespace transactions and IDs of the ones, which are committed by the client
+	multiTxLock sync.Mutex
+	multiTxs    map[string]struct{}
+	// select queries with limit not less than bulkMinLimit are sent via bulk connections of binding (0 - disabled)
+	bulkMinLimit int
+	// select queries, which chunks of results are expected to be not less than bulkFetchBytes, are bulk too (0 - disabled)
+	bulkFetchBytes int
+	// samplers of live queries of IndexUsageReport by namespaces and count of running samplings (accessed atomically)
+	usageSamplersLock sync.Mutex
+	usageSamplers     map[string]*indexUsageSampler
+	usageSampling     int32
+	// templates of tenant namespaces (see RegisterTenantNamespace)
+	tenants tenantRegistry
+	// operations for shutdown report (see WithShutdownReport)
+	liveOps liveOps
+	// registrations of namespaces and their callers (see Registrations)
+	registrations registrationAudit
+	// client is closed, calls go to closedBinding instead of binding. Accessed atomically
+	closed int32
 }
 
 type cacheItem struct {
@@ -48,121 +107,300 @@ type cacheItem struct {
 // NewReindexImpl Create new instanse of Reindexer DB
 // Returns pointer to created instance
 func newReindexImpl(dsn interface{}, options ...interface{}) *reindexerImpl {
-	scheme, dsnParsed := dsnParse(dsn)
+	scheme, dsnParsed, err := dsnParse(dsn)
+	if err != nil {
+		panic(err)
+	}
 
 	binding := bindings.GetBinding(scheme)
 	if binding == nil {
 		panic(fmt.Errorf("Reindex binding '%s' is not available, can't create DB", scheme))
 	}
 
-	binding = binding.Clone()
+	return newReindexImplWithBinding(binding.Clone(), dsnParsed, options...)
+}
+
+func newReindexImplWithBinding(binding bindings.RawBinding, dsnParsed []url.URL, options ...interface{}) *reindexerImpl {
 	rx := &reindexerImpl{
 		ns:      make(map[string]*reindexerNamespace, 100),
 		binding: binding,
 	}
 
-	if err := binding.Init(dsnParsed, options...); err != nil {
+	// Options of the client itself are not passed to the binding
+	var optErr error
+	var limiter *rateLimiter
+	bindingOptions := make([]interface{}, 0, len(options))
+	for _, option := range options {
+		switch v := option.(type) {
+		case bindings.OptionAutoReexecute:
+			rx.autoReexecute = v.AutoReexecute
+		case bindings.OptionMaxResultBufferBytes:
+			rx.maxResultBufferBytes = int64(v.MaxBytes)
+		case bindings.OptionAsyncWrites:
+			rx.asyncWritesOpts = v
+		case bindings.OptionRateLimit:
+			var err error
+			if limiter, err = newRateLimiter(v.Rules); err != nil && optErr == nil {
+				optErr = err
+			}
+		case bindings.OptionFloatFormat:
+			if f := v.Format; f.Precision < 0 || f.NaN < 0 || f.NaN > bindings.FloatNaNError {
+				if optErr == nil {
+					optErr = bindings.NewError(fmt.Sprintf("rq: Invalid float format %+v", f), ErrCodeParams)
+				}
+			} else {
+				rx.floatFormat = f
+			}
+		case bindings.OptionIntTruncation:
+			rx.truncateInts = v.Truncate
+		case bindings.OptionOnNamespaceInvalidated:
+			rx.onNsInvalidated = v
+		case bindings.OptionDestructiveGuard:
+			rx.destructiveGuard = v.Guard
+		case bindings.OptionWriteJournal:
+			rx.writeJournal = newWriteJournal(v)
+		case bindings.OptionDisableObjCache:
+			rx.disableObjCache = true
+		case bindings.OptionDisableRegistrationAudit:
+			rx.registrations.disabled = true
+		case bindings.OptionQueryHooks:
+			rx.queryHooks = v
+		case bindings.OptionShutdownReport:
+			rx.liveOps.opts = v
+		case bindings.OptionBulkConns:
+			// Connections are reserved by binding, so the option is passed to it too
+			rx.bulkMinLimit = v.MinLimit
+			bindingOptions = append(bindingOptions, option)
+		case bindings.OptionBulkFetchThreshold:
+			rx.bulkFetchBytes = v.Bytes
+		case bindings.OptionLogLevel:
+			atomic.StoreInt32(&logLevel, int32(v.Level))
+		default:
+			bindingOptions = append(bindingOptions, option)
+		}
+	}
+	rx.rateLimit.Store(limiter)
+	if err := binding.Init(dsnParsed, bindingOptions...); err != nil {
 		rx.status = err
+	} else {
+		rx.status = optErr
 	}
+	if rx.asyncWritesOpts.MaxInFlight <= 0 {
+		rx.asyncWritesOpts.MaxInFlight = defaultMaxAsyncWrites
+	}
+	rx.asyncWritesSem = make(chan struct{}, rx.asyncWritesOpts.MaxInFlight)
 
 	if changing, ok := binding.(bindings.RawBindingChanging); ok {
 		changing.OnChangeCallback(rx.resetCaches)
 	}
+	if connecting, ok := binding.(bindings.RawBindingConnecting); ok {
+		connecting.OnConnectCallback(rx.reopenNamespaces)
+	}
 
-	rx.registerNamespaceImpl(NamespacesNamespaceName, &NamespaceOptions{}, NamespaceDescription{})
-	rx.registerNamespaceImpl(PerfstatsNamespaceName, &NamespaceOptions{}, NamespacePerfStat{})
-	rx.registerNamespaceImpl(MemstatsNamespaceName, &NamespaceOptions{}, NamespaceMemStat{})
-	rx.registerNamespaceImpl(QueriesperfstatsNamespaceName, &NamespaceOptions{}, QueryPerfStat{})
-	rx.registerNamespaceImpl(ConfigNamespaceName, &NamespaceOptions{}, DBConfigItem{})
-	rx.registerNamespaceImpl(ClientsStatsNamespaceName, &NamespaceOptions{}, ClientConnectionStat{})
+	for _, sys := range systemNamespaces {
+		rx.registerNamespaceImpl(sys.name, &NamespaceOptions{}, sys.item)
+	}
 	return rx
 }
 
+// getBinding returns binding of the client or closedBinding, if the client is closed
+func (db *reindexerImpl) getBinding() bindings.RawBinding {
+	if atomic.LoadInt32(&db.closed) != 0 {
+		return closedBinding{}
+	}
+	return db.binding
+}
+
 // getStatus will return current db status
 func (db *reindexerImpl) getStatus(ctx context.Context) bindings.Status {
-	status := db.binding.Status(ctx)
+	status := db.getBinding().Status(ctx)
 	status.Err = db.status
+	if atomic.LoadInt32(&db.closed) != 0 {
+		status.Err = ErrClientClosed
+	}
+	status.RateLimit = db.limiter().status()
+	status.WriteJournal = db.writeJournal.status()
+	status.UpsertDedup = db.upsertDedupStatus()
+	status.ObjCache = db.objCacheStatus()
+	status.Registrations = db.registrations.list()
 	return status
 }
 
 // setLogger sets logger interface for output reindexer logs
 func (db *reindexerImpl) setLogger(log Logger) {
 	if log != nil {
-		logger = log
-		db.binding.EnableLogger(log)
+		logger = levelLogger{log}
+		db.getBinding().EnableLogger(levelLogger{log})
 	} else {
 		logger = &nullLogger{}
-		db.binding.DisableLogger()
+		db.getBinding().DisableLogger()
 	}
 }
 
 func (db *reindexerImpl) reopenLogFiles() error {
-	return db.binding.ReopenLogFiles()
+	return db.getBinding().ReopenLogFiles()
+}
+
+func (db *reindexerImpl) bindingStats() []bindings.ConnStats {
+	if binding, ok := db.getBinding().(bindings.RawBindingStats); ok {
+		return binding.Stats()
+	}
+	return nil
 }
 
 // ping checks connection with reindexer
 func (db *reindexerImpl) ping(ctx context.Context) error {
-	return db.binding.Ping(ctx)
+	return db.getBinding().Ping(ctx)
 }
 
-func (db *reindexerImpl) close() {
-	if err := db.binding.Finalize(); err != nil {
-		panic(err)
+// close finalizes binding (gracefully, bounded by ctx), frees caches and makes all the subsequent calls return ErrClientClosed
+func (db *reindexerImpl) close(ctx context.Context) (err error) {
+	if atomic.LoadInt32(&db.closed) != 0 {
+		return nil
+	}
+	// Operations are drained, while client isn't closed yet, so open iterators may fetch the rest of their results
+	liveOps, drainTime := db.drainLiveOps(ctx)
+
+	db.lock.Lock()
+	binding := db.binding
+	if atomic.LoadInt32(&db.closed) != 0 {
+		db.lock.Unlock()
+		return nil
+	}
+	atomic.StoreInt32(&db.closed, 1)
+	defer db.reportShutdown(liveOps, drainTime)
+	for _, ns := range db.ns {
+		ns.cacheLock.Lock()
+		ns.cacheItems = nil
+		ns.cacheLock.Unlock()
+		ns.replica.stop()
+	}
+	db.lock.Unlock()
+
+	// Async writes are completed by the binding, so it's finalized after all the completions
+	asyncDone := make(chan struct{})
+	go func() {
+		db.asyncWrites.Wait()
+		close(asyncDone)
+	}()
+	select {
+	case <-asyncDone:
+	case <-ctx.Done():
+	}
+	db.writeJournal.close(ctx)
+
+	if finalizer, ok := binding.(bindings.RawBindingFinalizerCtx); ok {
+		return finalizer.FinalizeCtx(ctx)
 	}
+	return binding.Finalize()
 }
 
-// openNamespace Open or create new namespace and indexes based on passed struct.
-// IndexDef fields of struct are marked by `reindex:` tag
-func (db *reindexerImpl) openNamespace(ctx context.Context, namespace string, opts *NamespaceOptions, s interface{}) (err error) {
-	namespace = strings.ToLower(namespace)
+// openNamespaceImpl Open or create new namespace and indexes based on passed struct.
+// IndexDef fields of struct are marked by `reindex:` tag. Returns actions, which migrated namespace to the struct (see RegistrationInfo)
+func (db *reindexerImpl) openNamespaceImpl(ctx context.Context, namespace string, opts *NamespaceOptions, s interface{}) (actions []string, err error) {
 	if err = db.registerNamespaceImpl(namespace, opts, s); err != nil {
+		if _, ok := err.(ErrNamespaceStructMismatch); ok {
+			return nil, err
+		}
 		panic(err)
 	}
 
 	ns, err := db.getNS(namespace)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err = db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return nil, err
 	}
 
+	dropOnFileFormatError := opts.dropOnFileFormatError
+	if dropOnFileFormatError && db.checkDestructive(bindings.DestructiveOp{Type: DestructiveDropNamespace, Namespace: namespace, Ctx: ctx, Implicit: true}) != nil {
+		// Namespace is opened without recovery, so storage format error is returned instead of drop
+		dropOnFileFormatError = false
+	}
 	for retry := 0; retry < 2; retry++ {
-		if err = db.binding.OpenNamespace(ctx, namespace, opts.enableStorage, opts.dropOnFileFormatError); err != nil {
+		if err = db.getBinding().OpenNamespace(ctx, namespace, opts.enableStorage, dropOnFileFormatError); err != nil {
 			break
 		}
+		var updatedIndexes map[string]bool
+		if opts.schemaCheck {
+			// Schema is checked before indexes are added, so indexes of renamed fields aren't created
+			if updatedIndexes, err = db.checkSchemaEvolution(ctx, ns, opts.acknowledgeSchema); err != nil {
+				db.getBinding().CloseNamespace(ctx, namespace)
+				if _, ok := err.(ErrSchemaEvolution); ok {
+					// Namespace may be opened with the previous struct
+					db.lock.Lock()
+					if db.ns[namespace] == ns {
+						delete(db.ns, namespace)
+					}
+					db.lock.Unlock()
+				}
+				break
+			}
+		}
 
+		added := 0
 		for _, indexDef := range ns.indexes {
-			if err = db.binding.AddIndex(ctx, namespace, indexDef); err != nil {
+			if updatedIndexes[indexDef.Name] {
+				if err = db.getBinding().UpdateIndex(ctx, namespace, indexDef); err == nil {
+					actions = append(actions, fmt.Sprintf("update index '%s'", indexDef.Name))
+				}
+			} else if err = db.getBinding().AddIndex(ctx, namespace, indexDef); err == nil {
+				added++
+			}
+			if err != nil {
 				break
 			}
 		}
+		if added != 0 && err == nil {
+			actions = append(actions, fmt.Sprintf("add %d indexes", added))
+		}
 
 		if err != nil {
 			rerr, ok := err.(bindings.Error)
 			if ok && rerr.Code() == bindings.ErrConflict && opts.dropOnIndexesConflict {
-				db.binding.DropNamespace(ctx, namespace)
-				continue
+				if err = db.checkDestructive(bindings.DestructiveOp{Type: DestructiveDropNamespace, Namespace: namespace, Ctx: ctx, Implicit: true}); err == nil {
+					db.getBinding().DropNamespace(ctx, namespace)
+					actions = append(actions, "drop namespace on conflict of indexes: "+rerr.Error())
+					continue
+				}
 			}
-			db.binding.CloseNamespace(ctx, namespace)
+			db.getBinding().CloseNamespace(ctx, namespace)
 			break
 		}
 
 		break
 	}
 
-	return err
+	db.lock.Lock()
+	if db.ns[namespace] == ns {
+		ns.opened = err == nil
+	}
+	db.lock.Unlock()
+	if err == nil {
+		ns.replica.start(ctx, db, ns)
+	}
+	return actions, err
 }
 
 // RegisterNamespace Register go type against namespace. There are no data and indexes changes will be performed
 func (db *reindexerImpl) registerNamespace(namespace string, opts *NamespaceOptions, s interface{}) (err error) {
 	namespace = strings.ToLower(namespace)
-	return db.registerNamespaceImpl(namespace, opts, s)
+	if err = checkSystemOpen(namespace, nsStructType(s)); err != nil {
+		return err
+	}
+	reg := db.registration(namespace, nsStructType(s), opts)
+	if err = db.registerNamespaceImpl(namespace, opts, s); err == nil {
+		db.registrations.record(reg, false)
+	} else if _, ok := err.(ErrNamespaceStructMismatch); ok {
+		db.registrations.mismatch(reg)
+	}
+	return err
 }
 
 // registerNamespace Register go type against namespace. There are no data and indexes changes will be performed
 func (db *reindexerImpl) registerNamespaceImpl(namespace string, opts *NamespaceOptions, s interface{}) (err error) {
-	t := reflect.TypeOf(s)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
+	t := nsStructType(s)
 	namespace = strings.ToLower(namespace)
 
 	db.lock.Lock()
@@ -171,15 +409,15 @@ func (db *reindexerImpl) registerNamespaceImpl(namespace string, opts *Namespace
 	oldNs, ok := db.ns[namespace]
 	if ok {
 		// Ns exists, and have different type
-		if oldNs.rtype.Name() != t.Name() {
-			return errNsExists
+		if oldNs.rtype != t {
+			return nsStructMismatch(namespace, oldNs.rtype, t)
 		}
 		// Ns exists, and have the same type.
 		return nil
 	}
 	haveDeepCopy := false
 
-	if !opts.disableObjCache {
+	if !opts.disableObjCache && !db.disableObjCache {
 		var copier DeepCopy
 		copier, haveDeepCopy = reflect.New(t).Interface().(DeepCopy)
 		if haveDeepCopy {
@@ -201,6 +439,8 @@ func (db *reindexerImpl) registerNamespaceImpl(namespace string, opts *Namespace
 		deepCopyIface: haveDeepCopy,
 		nsHash:        db.nsHashCounter,
 		opened:        false,
+		// tags state of server is unknown until the first results
+		serverStateToken: -1,
 	}
 
 	validator := cjson.Validator{}
@@ -208,36 +448,85 @@ func (db *reindexerImpl) registerNamespaceImpl(namespace string, opts *Namespace
 	if err = validator.Validate(s); err != nil {
 		return err
 	}
-	if ns.indexes, err = parseIndex(namespace, ns.rtype, &ns.joined); err != nil {
+	floats := make(map[string]bindings.FloatFormat)
+	ns.timeFields = make(map[string]timeRepr)
+	if ns.indexes, err = parseIndex(namespace, ns.rtype, &ns.joined, floats, ns.timeFields); err != nil {
 		return err
 	}
+	ns.floatFormat = db.floatFormat
+	ns.jsonFloats = newJSONFloatFormatter(db.floatFormat, floats)
+	ns.truncateInts = db.truncateInts
+	if opts.upsertDedupEntries > 0 {
+		ns.upsertDedup = newUpsertDedup(opts.upsertDedupEntries)
+	}
+	if opts.orderedWriteLanes > 0 {
+		ns.writeLanes = newWriteLanes(opts.orderedWriteLanes)
+	}
+	if opts.replicaEnabled {
+		ns.replica = newLocalReplica(opts.localReplica)
+	}
 
 	db.nsHashCounter++
 	db.ns[namespace] = ns
 	return nil
 }
 
+// checkDestructive calls destructive guard before destructive operation
+func (db *reindexerImpl) checkDestructive(op bindings.DestructiveOp) error {
+	if db.destructiveGuard == nil {
+		return nil
+	}
+	return db.destructiveGuard(op)
+}
+
 // dropNamespace - drop whole namespace from DB
 func (db *reindexerImpl) dropNamespace(ctx context.Context, namespace string) error {
 	namespace = strings.ToLower(namespace)
+	if err := db.checkDestructive(bindings.DestructiveOp{Type: DestructiveDropNamespace, Namespace: namespace, Ctx: ctx}); err != nil {
+		return err
+	}
+	if err := db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return err
+	}
 	db.lock.Lock()
+	if ns, ok := db.ns[namespace]; ok {
+		ns.replica.stop()
+	}
 	delete(db.ns, namespace)
 	db.lock.Unlock()
 
-	return db.binding.DropNamespace(ctx, namespace)
+	return db.getBinding().DropNamespace(ctx, namespace)
 }
 
 // truncateNamespace - delete all items from namespace
 func (db *reindexerImpl) truncateNamespace(ctx context.Context, namespace string) error {
 	namespace = strings.ToLower(namespace)
-	return db.binding.TruncateNamespace(ctx, namespace)
+	if err := db.checkDestructive(bindings.DestructiveOp{Type: DestructiveTruncateNamespace, Namespace: namespace, Ctx: ctx}); err != nil {
+		return err
+	}
+	if err := db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return err
+	}
+	if ns, err := db.getNS(namespace); err == nil {
+		dw := ns.upsertDedup.beginClear()
+		defer dw.end(nil)
+		ns.replica.invalidate()
+		defer ns.replica.invalidate()
+	}
+	return db.getBinding().TruncateNamespace(ctx, namespace)
 }
 
 // RenameNamespace - Rename namespace. If namespace with dstNsName exists, then it is replaced.
 func (db *reindexerImpl) renameNamespace(ctx context.Context, srcNsName string, dstNsName string) error {
 	srcNsName = strings.ToLower(srcNsName)
 	dstNsName = strings.ToLower(dstNsName)
-	err := db.binding.RenameNamespace(ctx, srcNsName, dstNsName)
+	if err := db.checkFeature(featureRenameNamespace); err != nil {
+		return err
+	}
+	if err := db.limiter().wait(ctx, srcNsName, CommandClassSchema); err != nil {
+		return err
+	}
+	err := db.getBinding().RenameNamespace(ctx, srcNsName, dstNsName)
 	if err != nil {
 		return err
 	}
@@ -257,11 +546,17 @@ func (db *reindexerImpl) renameNamespace(ctx context.Context, srcNsName string,
 // closeNamespace - close namespace, but keep storage
 func (db *reindexerImpl) closeNamespace(ctx context.Context, namespace string) error {
 	namespace = strings.ToLower(namespace)
+	if err := db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return err
+	}
 	db.lock.Lock()
+	if ns, ok := db.ns[namespace]; ok {
+		ns.replica.stop()
+	}
 	delete(db.ns, namespace)
 	db.lock.Unlock()
 
-	return db.binding.CloseNamespace(ctx, namespace)
+	return db.getBinding().CloseNamespace(ctx, namespace)
 }
 
 // upsert (Insert or Update) item to index
@@ -288,10 +583,18 @@ func (db *reindexerImpl) update(ctx context.Context, namespace string, item inte
 // delete - remove single item from namespace by PK
 // Item must be the same type as item passed to OpenNamespace, or []byte with json data
 func (db *reindexerImpl) delete(ctx context.Context, namespace string, item interface{}, precepts ...string) error {
+	if ns, err := db.getNS(namespace); err == nil && len(ns.opts.softDelete.Field) != 0 && !ns.opts.softDelete.HardDelete {
+		return db.softDelete(ctx, ns, item)
+	}
 	_, err := db.modifyItem(ctx, namespace, nil, item, nil, modeDelete, precepts...)
 	return err
 }
 
+// modifyJSON sends raw JSON item to namespace, which may be not registered by OpenNamespace. Item is parsed by server
+func (db *reindexerImpl) modifyJSON(ctx context.Context, namespace string, json []byte, mode int, precepts ...string) (int, error) {
+	return db.modifyItem(ctx, namespace, db.jsonNS(namespace), nil, json, mode, precepts...)
+}
+
 // configureIndex - congigure index.
 // config argument must be struct with index configuration
 // Deprecated: Use UpdateIndex instead.
@@ -305,7 +608,10 @@ func (db *reindexerImpl) configureIndex(ctx context.Context, namespace, index st
 	for _, iDef := range nsDef.Indexes {
 		if strings.ToLower(iDef.Name) == index {
 			iDef.Config = config
-			return db.binding.UpdateIndex(ctx, namespace, bindings.IndexDef(iDef.IndexDef))
+			if err := db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+				return err
+			}
+			return db.getBinding().UpdateIndex(ctx, namespace, bindings.IndexDef(iDef.IndexDef))
 		}
 	}
 	return fmt.Errorf("rq: Index '%s' not found in namespace %s", index, namespace)
@@ -313,8 +619,11 @@ func (db *reindexerImpl) configureIndex(ctx context.Context, namespace, index st
 
 // addIndex - add index.
 func (db *reindexerImpl) addIndex(ctx context.Context, namespace string, indexDef ...IndexDef) error {
+	if err := db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return err
+	}
 	for _, index := range indexDef {
-		if err := db.binding.AddIndex(ctx, namespace, bindings.IndexDef(index)); err != nil {
+		if err := db.getBinding().AddIndex(ctx, namespace, bindings.IndexDef(index)); err != nil {
 			return err
 		}
 	}
@@ -323,12 +632,23 @@ func (db *reindexerImpl) addIndex(ctx context.Context, namespace string, indexDe
 
 // updateIndex - update index.
 func (db *reindexerImpl) updateIndex(ctx context.Context, namespace string, indexDef IndexDef) error {
-	return db.binding.UpdateIndex(ctx, namespace, bindings.IndexDef(indexDef))
+	if err := db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return err
+	}
+	db.markNotOpened(namespace)
+	return db.getBinding().UpdateIndex(ctx, namespace, bindings.IndexDef(indexDef))
 }
 
 // dropIndex - drop index.
 func (db *reindexerImpl) dropIndex(ctx context.Context, namespace, index string) error {
-	return db.binding.DropIndex(ctx, namespace, index)
+	if err := db.checkDestructive(bindings.DestructiveOp{Type: DestructiveDropIndex, Namespace: strings.ToLower(namespace), Index: index, Ctx: ctx}); err != nil {
+		return err
+	}
+	if err := db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return err
+	}
+	db.markNotOpened(namespace)
+	return db.getBinding().DropIndex(ctx, namespace, index)
 }
 
 func loglevelToString(logLevel int) string {
@@ -350,25 +670,38 @@ func loglevelToString(logLevel int) string {
 
 // setDefaultQueryDebug sets default debug level for queries to namespaces
 func (db *reindexerImpl) setDefaultQueryDebug(ctx context.Context, namespace string, level int) error {
-	citem := &DBConfigItem{Type: "namespaces"}
+	return db.updateNamespaceConfig(ctx, namespace, func(nsCfg *DBNamespacesConfig) {
+		nsCfg.LogLevel = loglevelToString(level)
+	})
+}
+
+// getNamespacesConfig reads 'namespaces' item of '#config'
+func (db *reindexerImpl) getNamespacesConfig(ctx context.Context) (*DBConfigItem, error) {
 	item, err := db.query(ConfigNamespaceName).WhereString("type", EQ, "namespaces").ExecCtx(ctx).FetchOne()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	citem = item.(*DBConfigItem)
-	defaultCfg := DBNamespacesConfig{}
-	found := false
-
+	citem := item.(*DBConfigItem)
 	if citem.Namespaces == nil {
 		namespaces := make([]DBNamespacesConfig, 0, 1)
 		citem.Namespaces = &namespaces
 	}
+	return citem, nil
+}
+
+// updateNamespaceConfig modifies config entry of namespace in '#config'. Entry is copied from '*', if namespace has no own entry
+func (db *reindexerImpl) updateNamespaceConfig(ctx context.Context, namespace string, update func(nsCfg *DBNamespacesConfig)) error {
+	citem, err := db.getNamespacesConfig(ctx)
+	if err != nil {
+		return err
+	}
 
+	defaultCfg := DBNamespacesConfig{}
+	found := false
 	for i := range *citem.Namespaces {
 		switch (*citem.Namespaces)[i].Namespace {
 		case namespace:
-			(*citem.Namespaces)[i].LogLevel = loglevelToString(level)
+			update(&(*citem.Namespaces)[i])
 			found = true
 		case "*":
 			defaultCfg = (*citem.Namespaces)[i]
@@ -377,12 +710,56 @@ func (db *reindexerImpl) setDefaultQueryDebug(ctx context.Context, namespace str
 	if !found {
 		nsCfg := defaultCfg
 		nsCfg.Namespace = namespace
-		nsCfg.LogLevel = loglevelToString(level)
+		update(&nsCfg)
 		*citem.Namespaces = append(*citem.Namespaces, nsCfg)
 	}
 	return db.upsert(ctx, ConfigNamespaceName, citem)
 }
 
+// setNamespaceCacheConfig sets cache options of namespace
+func (db *reindexerImpl) setNamespaceCacheConfig(ctx context.Context, namespace string, cfg NamespaceCacheConfig) error {
+	switch cfg.JoinCacheMode {
+	case JoinCacheModeOff, JoinCacheModeOn, JoinCacheModeAggressive:
+	default:
+		return bindings.NewError(fmt.Sprintf("rq: Invalid join cache mode '%s'", cfg.JoinCacheMode), ErrCodeParams)
+	}
+	return db.updateNamespaceConfig(ctx, namespace, func(nsCfg *DBNamespacesConfig) {
+		queryCountCache := cfg.QueryCountCache
+		nsCfg.JoinCacheMode = cfg.JoinCacheMode
+		nsCfg.QueryCountCache = &queryCountCache
+	})
+}
+
+// getNamespaceCacheConfig returns cache options of namespace
+func (db *reindexerImpl) getNamespaceCacheConfig(ctx context.Context, namespace string) (NamespaceCacheConfig, error) {
+	citem, err := db.getNamespacesConfig(ctx)
+	if err != nil {
+		return NamespaceCacheConfig{}, err
+	}
+	var nsCfg *DBNamespacesConfig
+	for i := range *citem.Namespaces {
+		switch (*citem.Namespaces)[i].Namespace {
+		case namespace:
+			nsCfg = &(*citem.Namespaces)[i]
+		case "*":
+			if nsCfg == nil {
+				nsCfg = &(*citem.Namespaces)[i]
+			}
+		}
+	}
+	cfg := NamespaceCacheConfig{JoinCacheMode: JoinCacheModeOff, QueryCountCache: true}
+	if nsCfg == nil {
+		return cfg, nil
+	}
+	if len(nsCfg.JoinCacheMode) != 0 {
+		cfg.JoinCacheMode = nsCfg.JoinCacheMode
+	}
+	if nsCfg.QueryCountCache != nil {
+		cfg.QueryCountCache = *nsCfg.QueryCountCache
+	}
+	return cfg, nil
+}
+
 // query Create new Query for building request
 func (db *reindexerImpl) query(namespace string) *Query {
 	return newQuery(db, namespace, nil)
@@ -400,7 +777,7 @@ func (db *reindexerImpl) execSQL(ctx context.Context, query string) *Iterator {
 	if err != nil {
 		return errIterator(err)
 	}
-	iter := newIterator(ctx, nil, result, nsArray, nil, nil, nil)
+	iter := newIterator(ctx, db, nil, result, nsArray, nil, nil, nil)
 	return iter
 }
 
@@ -408,16 +785,16 @@ func (db *reindexerImpl) execSQL(ctx context.Context, query string) *Iterator {
 // Return JSONIterator.
 func (db *reindexerImpl) execSQLToJSON(ctx context.Context, query string) *JSONIterator {
 	namespace := getQueryNamespace(query)
-	result, _, err := db.prepareSQL(ctx, namespace, query, true)
+	result, nsArray, err := db.prepareSQL(ctx, namespace, query, true)
 	if err != nil {
 		return errJSONIterator(err)
 	}
 	defer result.Free()
-	json, jsonOffsets, explain, err := db.rawResultToJson(result.GetBuf(), namespace, "total", nil, nil)
+	json, jsonOffsets, params, err := db.rawResultToJson(result.GetBuf(), nsArray, namespace, "total", nil, nil)
 	if err != nil {
 		return errJSONIterator(err)
 	}
-	return newJSONIterator(ctx, nil, json, jsonOffsets, explain)
+	return newJSONIterator(ctx, db, nil, json, jsonOffsets, namespace, params)
 }
 
 func getQueryNamespace(query string) string {
@@ -522,20 +899,20 @@ func (db *reindexerImpl) queryFrom(d dsl.DSL) (*Query, error) {
 	return q, nil
 }
 
-func dsnParse(dsn interface{}) (string, []url.URL) {
+func dsnParse(dsn interface{}) (string, []url.URL, error) {
 	var dsnSlice []string
 	var scheme string
 
 	switch v := dsn.(type) {
 	case string:
-		dsnSlice = []string{v}
+		dsnSlice = splitDSNList(v)
 	case []string:
 		if len(v) == 0 {
-			panic(fmt.Errorf("Empty multi DSN config. DSN: '%#v'. ", dsn))
+			return "", nil, bindings.NewError(fmt.Sprintf("rq: Empty multi DSN config. DSN: '%#v'. ", dsn), ErrCodeParams)
 		}
 		dsnSlice = v
 	default:
-		panic(fmt.Errorf("DSN format not supported. Support []string or string. DSN: '%#v'. ", dsn))
+		return "", nil, bindings.NewError(fmt.Sprintf("rq: DSN format not supported. Support []string or string. DSN: '%#v'. ", dsn), ErrCodeParams)
 	}
 
 	dsnParsed := make([]url.URL, 0, len(dsnSlice))
@@ -546,16 +923,30 @@ func dsnParse(dsn interface{}) (string, []url.URL) {
 
 		u, err := url.Parse(dsnSlice[i])
 		if err != nil {
-			panic(fmt.Errorf("Can't parse DB DSN '%s'", dsn))
+			return "", nil, bindings.NewError(fmt.Sprintf("rq: Can't parse DB DSN '%s'", dsn), ErrCodeParams)
 		}
 		if scheme != "" && scheme != u.Scheme {
-			panic(fmt.Sprintf("DSN has a different schemas. %s", dsn))
+			return "", nil, bindings.NewError(fmt.Sprintf("rq: DSN has a different schemas. %s", dsn), ErrCodeParams)
 		}
 		dsnParsed = append(dsnParsed, *u)
 		scheme = u.Scheme
 	}
 
-	return scheme, dsnParsed
+	return scheme, dsnParsed, nil
+}
+
+// splitDSNList splits comma separated list of DSNs, e.g. 'cproto://a:6534/db,cproto://b:6534/db'.
+// Comma, which isn't followed by scheme, is a part of DSN (e.g. of password)
+func splitDSNList(dsn string) []string {
+	var dsnSlice []string
+	for _, part := range strings.Split(dsn, ",") {
+		if len(dsnSlice) != 0 && !strings.Contains(part, "://") {
+			dsnSlice[len(dsnSlice)-1] += "," + part
+			continue
+		}
+		dsnSlice = append(dsnSlice, part)
+	}
+	return dsnSlice
 }
 
 // GetStats Get local thread reindexer usage stats
@@ -574,5 +965,5 @@ func (db *reindexerImpl) resetStats() {
 // Deprecated: storage path should be passed as DSN part to reindexer.NewReindex (""), e.g. reindexer.NewReindexer ("builtin:///tmp/reindex").
 func (db *reindexerImpl) enableStorage(ctx context.Context, storagePath string) error {
 	log.Println("Deprecated function reindexer.EnableStorage call")
-	return db.binding.EnableStorage(ctx, storagePath)
+	return db.getBinding().EnableStorage(ctx, storagePath)
 }
@@ -0,0 +1,67 @@
+// +build reindexer_arena_debug
+
+package reindexertest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/cjson"
+)
+
+type ArenaItem struct {
+	ID     int       `reindex:"id,,pk" json:"id"`
+	Name   string    `reindex:"name" json:"name"`
+	Tags   []string  `json:"tags"`
+	Scores []int     `json:"scores"`
+	Counts []int64   `json:"counts"`
+	Rates  []float64 `json:"rates"`
+}
+
+const arenaNs = "arena_items"
+
+func newArenaItem(i int) *ArenaItem {
+	return &ArenaItem{
+		ID:     i,
+		Name:   fmt.Sprintf("item_%d_%s", i, strings.Repeat("x", 50+i%50)),
+		Tags:   []string{fmt.Sprintf("tag%d", i%10), fmt.Sprintf("tag%d", i%7)},
+		Scores: []int{i, i * 2, i * 3},
+		Counts: []int64{int64(i) << 33},
+		Rates:  []float64{float64(i) / 4},
+	}
+}
+
+func prepareArenaNs(t testing.TB, db *reindexer.Reindexer, count int) {
+	require.NoError(t, db.OpenNamespace(arenaNs, reindexer.DefaultNamespaceOptions(), ArenaItem{}))
+	for i := 0; i < count; i++ {
+		require.NoError(t, db.Upsert(arenaNs, newArenaItem(i)))
+	}
+}
+
+func TestDecodeArenaPoison(t *testing.T) {
+	db := NewInMemory()
+	defer db.Close()
+	prepareArenaNs(t, db, 10)
+
+	it := db.Query(arenaNs).Sort("id", false).Exec().WithArena(true)
+	items := []*ArenaItem{}
+	for it.Next() {
+		items = append(items, it.Object().(*ArenaItem))
+	}
+	require.NoError(t, it.Error())
+	require.Len(t, items, 10)
+	assert.Equal(t, newArenaItem(3), items[3])
+	it.Close()
+
+	// Memory of arena is poisoned by Close, so objects, which are retained after it, are visibly broken
+	for _, item := range items {
+		assert.Equal(t, strings.Repeat("\xdd", len(item.Name)), item.Name)
+		assert.Equal(t, []string{cjson.ArenaPoisonString, cjson.ArenaPoisonString}, item.Tags)
+		assert.NotEqual(t, newArenaItem(item.ID).Scores, item.Scores)
+	}
+}
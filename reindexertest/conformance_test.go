@@ -0,0 +1,2258 @@
+package reindexertest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	_ "github.com/restream/reindexer/bindings/cproto"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+type ConformanceNested struct {
+	Code  string `json:"code"`
+	Level int    `json:"level"`
+}
+
+type ConformanceItem struct {
+	ID     int               `reindex:"id,,pk" json:"id"`
+	Name   string            `reindex:"name" json:"name"`
+	Year   int               `reindex:"year,tree" json:"year"`
+	Rate   float64           `reindex:"rate,tree" json:"rate"`
+	Genres []int             `reindex:"genres" json:"genres"`
+	Active bool              `reindex:"active" json:"active"`
+	Nested ConformanceNested `json:"nested"`
+	Tags   []string          `json:"tags"`
+}
+
+const conformanceNs = "conformance_items"
+
+// conformanceTargets - DBs, which must behave in the same way for the supported subset
+var conformanceTargets = map[string]func(t *testing.T) (*reindexer.Reindexer, func()){
+	"inmemory": func(t *testing.T) (*reindexer.Reindexer, func()) {
+		db := NewInMemory()
+		return db, func() { db.Close() }
+	},
+	"builtinserver": func(t *testing.T) (*reindexer.Reindexer, func()) {
+		srv := helpers.TestServer{T: t, RpcPort: "6691", HttpPort: "9991", DbName: "reindexertest"}
+		require.NoError(t, srv.Run())
+		db := reindexer.NewReindex(fmt.Sprintf("cproto://%s/%s_%s", srv.Addr(), srv.DbName, srv.RpcPort), reindexer.WithCreateDBIfMissing())
+		require.NoError(t, db.Status().Err)
+		return db, func() {
+			db.Close()
+			srv.Stop()
+			srv.Clean()
+		}
+	},
+}
+
+func prepareConformanceNs(t *testing.T, db *reindexer.Reindexer) {
+	db.DropNamespace(conformanceNs)
+	require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Upsert(conformanceNs, &ConformanceItem{
+			ID:     i,
+			Name:   fmt.Sprintf("name_%d", i%7),
+			Year:   2000 + i%5,
+			Rate:   float64(i) / 2,
+			Genres: []int{i % 3, 10 + i%4},
+			Active: i%2 == 0,
+			Nested: ConformanceNested{Code: fmt.Sprintf("c%d", i%3), Level: i % 4},
+			Tags:   []string{"t", fmt.Sprintf("t%d", i%2)},
+		}))
+	}
+}
+
+func ids(t *testing.T, it *reindexer.Iterator) []int {
+	defer it.Close()
+	require.NoError(t, it.Error())
+	res := []int{}
+	for it.Next() {
+		res = append(res, it.Object().(*ConformanceItem).ID)
+	}
+	require.NoError(t, it.Error())
+	return res
+}
+
+func TestConformance(t *testing.T) {
+	for name, target := range conformanceTargets {
+		target := target
+		t.Run(name, func(t *testing.T) {
+			db, cleanup := target(t)
+			defer cleanup()
+			prepareConformanceNs(t, db)
+
+			t.Run("modify", func(t *testing.T) {
+				cnt, err := db.Insert(conformanceNs, &ConformanceItem{ID: 1, Name: "dup"})
+				require.NoError(t, err)
+				assert.Equal(t, 0, cnt)
+				cnt, err = db.Update(conformanceNs, &ConformanceItem{ID: 100, Name: "missing"})
+				require.NoError(t, err)
+				assert.Equal(t, 0, cnt)
+				cnt, err = db.Insert(conformanceNs, &ConformanceItem{ID: 100, Name: "new", Year: 1990})
+				require.NoError(t, err)
+				assert.Equal(t, 1, cnt)
+				cnt, err = db.Update(conformanceNs, &ConformanceItem{ID: 100, Name: "updated", Year: 1990})
+				require.NoError(t, err)
+				assert.Equal(t, 1, cnt)
+
+				item, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 100).Get()
+				require.True(t, found)
+				assert.Equal(t, "updated", item.(*ConformanceItem).Name)
+
+				require.NoError(t, db.Delete(conformanceNs, &ConformanceItem{ID: 100}))
+				_, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 100).Get()
+				assert.False(t, found)
+
+				require.NoError(t, db.Upsert(conformanceNs, []byte(`{"id":101,"name":"json","year":1980,"nested":{"code":"j"}}`)))
+				item, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 101).Get()
+				require.True(t, found)
+				assert.Equal(t, "json", item.(*ConformanceItem).Name)
+				assert.Equal(t, "j", item.(*ConformanceItem).Nested.Code)
+				require.NoError(t, db.Delete(conformanceNs, []byte(`{"id":101}`)))
+			})
+
+			t.Run("where", func(t *testing.T) {
+				assert.Equal(t, []int{3, 10, 17}, ids(t, db.Query(conformanceNs).WhereString("name", reindexer.EQ, "name_3").Exec()))
+				assert.Equal(t, []int{1, 5, 7}, ids(t, db.Query(conformanceNs).WhereInt("id", reindexer.SET, 7, 1, 5).Sort("id", false).Exec()))
+				assert.Equal(t, []int{2, 3, 4}, ids(t, db.Query(conformanceNs).WhereDouble("rate", reindexer.RANGE, 1, 2).Sort("id", false).Exec()))
+				assert.Equal(t, []int{18, 19}, ids(t, db.Query(conformanceNs).WhereInt("id", reindexer.GT, 17).Sort("id", false).Exec()))
+				assert.Equal(t, []int{0, 1}, ids(t, db.Query(conformanceNs).WhereInt("id", reindexer.LT, 2).Sort("id", false).Exec()))
+				assert.Equal(t, []int{3, 7, 11, 15, 19}, ids(t, db.Query(conformanceNs).WhereInt("genres", reindexer.EQ, 13).Sort("id", false).Exec()))
+				assert.Equal(t, []int{4, 9, 14, 19}, ids(t, db.Query(conformanceNs).Where("year", reindexer.EQ, 2004).Sort("id", false).Exec()))
+				assert.Equal(t, []int{0, 1, 18, 19}, ids(t, db.Query(conformanceNs).
+					WhereInt("id", reindexer.LT, 2).Or().WhereInt("id", reindexer.GT, 17).Sort("id", false).Exec()))
+				assert.Equal(t, []int{1, 3}, ids(t, db.Query(conformanceNs).
+					WhereInt("id", reindexer.LT, 5).Not().WhereBool("active", reindexer.EQ, true).
+					OpenBracket().WhereInt("year", reindexer.EQ, 2001).Or().WhereInt("year", reindexer.EQ, 2003).CloseBracket().
+					Sort("id", false).Exec()))
+			})
+
+			t.Run("sort and paging", func(t *testing.T) {
+				assert.Equal(t, []int{19, 18, 17}, ids(t, db.Query(conformanceNs).Sort("rate", true).Limit(3).Exec()))
+				assert.Equal(t, []int{3, 4, 5}, ids(t, db.Query(conformanceNs).Sort("id", false).Offset(3).Limit(3).Exec()))
+				assert.Equal(t, []int{4, 9, 14, 19, 3}, ids(t, db.Query(conformanceNs).Sort("year", true).Sort("id", false).Limit(5).Exec()))
+
+				it := db.Query(conformanceNs).WhereInt("year", reindexer.EQ, 2001).Sort("id", false).Limit(2).ReqTotal().Exec()
+				assert.Equal(t, 4, it.TotalCount())
+				assert.Equal(t, []int{1, 6}, ids(t, it))
+			})
+
+			t.Run("ids only", func(t *testing.T) {
+				// Items are inserted in order of primary keys, so their ids are the same
+				ids, err := db.Query(conformanceNs).WhereInt("id", reindexer.SET, 7, 1, 5).Sort("id", false).ExecIDsOnly(context.Background())
+				require.NoError(t, err)
+				assert.Equal(t, []int64{1, 5, 7}, ids)
+				ids, err = db.Query(conformanceNs).Sort("rate", true).Limit(3).FetchCount(2).ExecIDsOnly(context.Background())
+				require.NoError(t, err)
+				assert.Equal(t, []int64{19, 18, 17}, ids)
+
+				pks, err := db.Query(conformanceNs).Sort("id", false).Offset(3).Limit(3).ExecPKs(context.Background())
+				require.NoError(t, err)
+				assert.Equal(t, []int64{3, 4, 5}, pks.Int64("id"))
+			})
+
+			t.Run("fields presence", func(t *testing.T) {
+				docs := []string{
+					`{"id":400,"name":"omitted"}`,
+					`{"id":401,"name":"null","nested":{"code":null,"level":1},"tags":null}`,
+					`{"id":402,"name":"","year":0,"rate":0,"active":false,"genres":[],"nested":{"code":"","level":0},"tags":[]}`,
+				}
+				for _, doc := range docs {
+					require.NoError(t, db.Upsert(conformanceNs, []byte(doc)))
+				}
+				defer db.Query(conformanceNs).WhereInt("id", reindexer.GE, 400).Delete()
+
+				it := db.Query(conformanceNs).WhereInt("id", reindexer.GE, 400).Sort("id", false).Exec().WithFieldsPresence(true)
+				defer it.Close()
+				var presence []reindexer.FieldsPresence
+				for it.Next() {
+					presence = append(presence, it.FieldsPresence())
+				}
+				require.NoError(t, it.Error())
+				require.Len(t, presence, len(docs))
+
+				assert.True(t, presence[0].Has("id"))
+				assert.True(t, presence[0].Has("name"))
+				for _, path := range []string{"year", "rate", "active", "genres", "nested", "nested.code", "tags"} {
+					assert.False(t, presence[0].Has(path), path)
+				}
+
+				assert.True(t, presence[1].Has("nested"))
+				assert.False(t, presence[1].IsNull("nested"))
+				assert.True(t, presence[1].Has("nested.code"))
+				assert.True(t, presence[1].IsNull("nested.code"))
+				assert.False(t, presence[1].IsNull("nested.level"))
+				assert.True(t, presence[1].IsNull("tags"))
+				assert.False(t, presence[1].Has("genres"))
+
+				for _, path := range []string{"id", "name", "year", "rate", "active", "genres", "nested", "nested.code", "nested.level", "tags"} {
+					assert.True(t, presence[2].Has(path), path)
+					assert.False(t, presence[2].IsNull(path), path)
+				}
+
+				it = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 400).Exec()
+				defer it.Close()
+				require.True(t, it.Next())
+				assert.Nil(t, it.FieldsPresence(), "presence is tracked only on demand")
+			})
+
+			t.Run("json", func(t *testing.T) {
+				json, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 2).GetJson()
+				require.True(t, found)
+				assert.Contains(t, string(json), `"nested":{"code":"c2","level":2}`)
+			})
+
+			t.Run("raw json", func(t *testing.T) {
+				ctx := context.Background()
+				cnt, err := db.InsertJSON(ctx, conformanceNs, []byte(`{"id":400,"name":"raw","year":1970,"genres":[1,2],"nested":{"code":"r","level":3},"tags":["a"]}`))
+				require.NoError(t, err)
+				assert.Equal(t, 1, cnt)
+				item, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 400).Get()
+				require.True(t, found)
+				assert.Equal(t, &ConformanceItem{ID: 400, Name: "raw", Year: 1970, Genres: []int{1, 2}, Nested: ConformanceNested{Code: "r", Level: 3}, Tags: []string{"a"}}, item)
+				cnt, err = db.InsertJSON(ctx, conformanceNs, []byte(`{"id":400,"name":"dup"}`))
+				require.NoError(t, err)
+				assert.Equal(t, 0, cnt)
+
+				// Cached object of the item must be replaced
+				cnt, err = db.UpdateJSON(ctx, conformanceNs, []byte(`{"id":400,"name":"raw updated"}`))
+				require.NoError(t, err)
+				assert.Equal(t, 1, cnt)
+				item, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 400).Get()
+				require.True(t, found)
+				assert.Equal(t, "raw updated", item.(*ConformanceItem).Name)
+				assert.Equal(t, 0, item.(*ConformanceItem).Year)
+				require.NoError(t, db.UpsertJSON(ctx, conformanceNs, []byte(`{"id":400,"name":"raw upserted"}`)))
+				item, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 400).Get()
+				require.True(t, found)
+				assert.Equal(t, "raw upserted", item.(*ConformanceItem).Name)
+
+				assert.Error(t, db.UpsertJSON(ctx, conformanceNs, []byte(`{"id":401,"nested":{"code":}}`)))
+				_, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 401).Get()
+				assert.False(t, found)
+
+				tx, err := db.BeginTx(conformanceNs)
+				require.NoError(t, err)
+				require.NoError(t, tx.InsertJSON([]byte(`{"id":401,"name":"tx raw"}`)))
+				require.NoError(t, tx.UpdateJSON([]byte(`{"id":400,"name":"tx raw updated"}`)))
+				cnt, err = tx.CommitWithCount()
+				require.NoError(t, err)
+				assert.Equal(t, 2, cnt)
+				assert.Equal(t, []int{400, 401}, ids(t, db.Query(conformanceNs).Where("name", reindexer.SET, []string{"tx raw", "tx raw updated"}).Sort("id", false).Exec()))
+
+				require.NoError(t, db.DeleteJSON(ctx, conformanceNs, []byte(`{"id":400}`)))
+				require.NoError(t, db.DeleteJSON(ctx, conformanceNs, []byte(`{"id":401}`)))
+				_, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 400).Get()
+				assert.False(t, found)
+			})
+
+			t.Run("queries", func(t *testing.T) {
+				cnt, err := db.Query(conformanceNs).WhereInt("id", reindexer.GE, 18).Delete()
+				require.NoError(t, err)
+				assert.Equal(t, 2, cnt)
+
+				it := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 17).Set("name", "set").Update()
+				require.NoError(t, it.Error())
+				assert.Equal(t, 1, it.Count())
+				it.Close()
+				item, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 17).Get()
+				require.True(t, found)
+				assert.Equal(t, "set", item.(*ConformanceItem).Name)
+			})
+
+			t.Run("tx", func(t *testing.T) {
+				tx, err := db.BeginTx(conformanceNs)
+				require.NoError(t, err)
+				require.NoError(t, tx.Upsert(&ConformanceItem{ID: 200, Name: "tx"}))
+				require.NoError(t, tx.Delete(&ConformanceItem{ID: 0}))
+				_, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 200).Get()
+				assert.False(t, found, "changes of tx must be invisible before commit")
+				cnt, err := tx.CommitWithCount()
+				require.NoError(t, err)
+				assert.Equal(t, 2, cnt)
+				_, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 200).Get()
+				assert.True(t, found)
+				_, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 0).Get()
+				assert.False(t, found)
+
+				tx, err = db.BeginTx(conformanceNs)
+				require.NoError(t, err)
+				require.NoError(t, tx.Upsert(&ConformanceItem{ID: 201, Name: "rollback"}))
+				require.NoError(t, tx.Rollback())
+				_, found = db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 201).Get()
+				assert.False(t, found)
+			})
+
+			t.Run("async", func(t *testing.T) {
+				const count = 100
+				errs := make(chan error, count+1)
+				for i := 0; i < count; i++ {
+					require.NoError(t, db.UpsertAsync(context.Background(), conformanceNs, &ConformanceItem{ID: 300 + i, Name: "async"}, func(err error) {
+						errs <- err
+					}))
+				}
+				// Invalid item must be rejected by server
+				require.NoError(t, db.UpsertAsync(context.Background(), conformanceNs, []byte(`{"id":`), func(err error) {
+					errs <- err
+				}))
+				failed := 0
+				for i := 0; i < count+1; i++ {
+					if err := <-errs; err != nil {
+						failed++
+					}
+				}
+				assert.Equal(t, 1, failed)
+				assert.Len(t, ids(t, db.Query(conformanceNs).Where("name", reindexer.EQ, "async").Exec()), count)
+
+				for i := 0; i < count; i++ {
+					require.NoError(t, db.DeleteAsync(context.Background(), conformanceNs, &ConformanceItem{ID: 300 + i}, func(err error) {
+						errs <- err
+					}))
+				}
+				for i := 0; i < count; i++ {
+					require.NoError(t, <-errs)
+				}
+				assert.Len(t, ids(t, db.Query(conformanceNs).Where("name", reindexer.EQ, "async").Exec()), 0)
+			})
+
+			t.Run("meta", func(t *testing.T) {
+				require.NoError(t, db.PutMeta(conformanceNs, "key", []byte("value")))
+				data, err := db.GetMeta(conformanceNs, "key")
+				require.NoError(t, err)
+				assert.Equal(t, "value", string(data))
+				data, err = db.GetMeta(conformanceNs, "missing")
+				require.NoError(t, err)
+				assert.Len(t, data, 0)
+			})
+
+			t.Run("namespaces", func(t *testing.T) {
+				require.NoError(t, db.TruncateNamespace(conformanceNs))
+				assert.Equal(t, []int{}, ids(t, db.Query(conformanceNs).Exec()))
+				require.NoError(t, db.DropNamespace(conformanceNs))
+				assert.Error(t, db.Query(conformanceNs).Exec().Error())
+			})
+		})
+	}
+}
+
+func TestHooks(t *testing.T) {
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks))
+	defer db.Close()
+	prepareConformanceNs(t, db)
+
+	injected := bindings.NewError("injected", bindings.ErrLogic)
+	hooks.InjectError(OpModifyItem, injected)
+	assert.Equal(t, injected, db.Upsert(conformanceNs, &ConformanceItem{ID: 1}))
+	assert.NoError(t, db.Query(conformanceNs).Exec().Error())
+	hooks.InjectError(OpModifyItem, nil)
+	assert.NoError(t, db.Upsert(conformanceNs, &ConformanceItem{ID: 1}))
+
+	hooks.InjectError(OpCommitTx, injected)
+	tx, err := db.BeginTx(conformanceNs)
+	require.NoError(t, err)
+	require.NoError(t, tx.Upsert(&ConformanceItem{ID: 300}))
+	assert.Equal(t, injected, tx.Commit())
+
+	hooks.InjectLatency(OpSelect, 50*time.Millisecond)
+	start := time.Now()
+	assert.NoError(t, db.Query(conformanceNs).Exec().Error())
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+
+	hooks.InjectLatency(OpSelect, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = db.Query(conformanceNs).ExecCtx(ctx).Error()
+	require.Error(t, err)
+	assert.Equal(t, reindexer.ErrCodeTimeout, err.(reindexer.Error).Code())
+
+	assert.True(t, hooks.Calls(OpSelect) >= 2)
+	hooks.Reset()
+	assert.Equal(t, 0, hooks.Calls(OpSelect))
+	assert.NoError(t, db.Query(conformanceNs).Exec().Error())
+}
+
+func TestUnsupported(t *testing.T) {
+	db := NewInMemory()
+	defer db.Close()
+	prepareConformanceNs(t, db)
+
+	q := db.Query(conformanceNs)
+	q.AggregateSum("rate")
+	err := q.Exec().Error()
+	require.Error(t, err)
+	assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+
+	q = db.Query(conformanceNs)
+	q.InnerJoin(db.Query(conformanceNs), "joined")
+	assert.Error(t, q.Exec().Error())
+
+	_, err = db.ExecSQL("SELECT * FROM " + conformanceNs).FetchAll()
+	assert.Error(t, err)
+}
+
+func TestAsyncWritesLimit(t *testing.T) {
+	hooks := &Hooks{}
+	hooks.InjectLatency(OpModifyItem, 200*time.Millisecond)
+
+	// startSlowWrite occupies the only async writes slot for 200ms
+	startSlowWrite := func(db *reindexer.Reindexer) chan error {
+		res := make(chan error, 1)
+		go db.UpsertAsync(context.Background(), conformanceNs, &ConformanceItem{ID: 1}, func(err error) { res <- err })
+		time.Sleep(50 * time.Millisecond)
+		return res
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		db := NewInMemory(WithHooks(hooks), reindexer.WithAsyncWritesLimit(1, true))
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+
+		res := startSlowWrite(db)
+		assert.Equal(t, reindexer.ErrAsyncWritesLimit, db.UpsertAsync(context.Background(), conformanceNs, &ConformanceItem{ID: 2}, nil))
+		assert.NoError(t, <-res)
+		assert.NoError(t, db.UpsertAsync(context.Background(), conformanceNs, &ConformanceItem{ID: 2}, nil))
+	})
+
+	t.Run("blocking", func(t *testing.T) {
+		db := NewInMemory(WithHooks(hooks), reindexer.WithAsyncWritesLimit(1, false))
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+
+		res := startSlowWrite(db)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, db.UpsertAsync(ctx, conformanceNs, &ConformanceItem{ID: 2}, nil))
+
+		start := time.Now()
+		done := make(chan error, 1)
+		require.NoError(t, db.UpsertAsync(context.Background(), conformanceNs, &ConformanceItem{ID: 2}, func(err error) { done <- err }))
+		assert.True(t, time.Since(start) >= 50*time.Millisecond, "write must wait for the free slot")
+		assert.NoError(t, <-res)
+		assert.NoError(t, <-done)
+	})
+
+	t.Run("close waits for completions", func(t *testing.T) {
+		db := NewInMemory(WithHooks(hooks))
+		require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+		// Synchronous write refreshes state token, so the slow write is not retried after Close
+		require.NoError(t, db.Upsert(conformanceNs, &ConformanceItem{ID: 1}))
+
+		res := startSlowWrite(db)
+		require.NoError(t, db.Close())
+		select {
+		case err := <-res:
+			assert.NoError(t, err)
+		default:
+			t.Fatal("Close returned before completion of async write")
+		}
+		assert.Equal(t, reindexer.ErrClientClosed, db.UpsertAsync(context.Background(), conformanceNs, &ConformanceItem{ID: 2}, nil))
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	const rps = 100
+	const burst = 10
+	const workers = 4
+
+	// run calls req from several goroutines until stop returns true and counts results
+	run := func(req func() error, stop func(done int64) bool) (ok, rejected int64, errs []error) {
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					lock.Lock()
+					if stop(ok + rejected) {
+						lock.Unlock()
+						return
+					}
+					lock.Unlock()
+					err := req()
+					lock.Lock()
+					if err == nil {
+						ok++
+					} else if _, limited := err.(reindexer.ErrRateLimited); limited {
+						rejected++
+					} else {
+						errs = append(errs, err)
+					}
+					lock.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		return
+	}
+
+	t.Run("block", func(t *testing.T) {
+		const count = 160
+		db := NewInMemory(reindexer.WithRateLimit(reindexer.RateRule{
+			Match: reindexer.RateMatch{Namespace: conformanceNs, CommandClass: reindexer.CommandClassWrite},
+			RPS:   rps,
+			Burst: burst,
+		}))
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+
+		var id int64
+		start := time.Now()
+		ok, rejected, errs := run(func() error {
+			return db.Upsert(conformanceNs, &ConformanceItem{ID: int(atomic.AddInt64(&id, 1))})
+		}, func(int64) bool { return atomic.LoadInt64(&id) >= count })
+		elapsed := time.Since(start)
+		require.Empty(t, errs)
+		assert.Equal(t, int64(0), rejected)
+		assert.Equal(t, int64(count), ok)
+		expected := time.Duration(float64(count-burst) / rps * float64(time.Second))
+		assert.InDelta(t, expected.Seconds(), elapsed.Seconds(), 0.15*expected.Seconds(), "elapsed %v, expected %v", elapsed, expected)
+
+		status := db.Status()
+		require.NoError(t, status.Err)
+		require.Equal(t, 1, len(status.RateLimit))
+		assert.Equal(t, int64(count), status.RateLimit[0].Allowed+status.RateLimit[0].Delayed)
+		assert.True(t, status.RateLimit[0].Delayed >= count-burst)
+
+		// Reads are not matched by the rule
+		start = time.Now()
+		for i := 0; i < count; i++ {
+			_, err := db.Query(conformanceNs).Limit(1).Exec().FetchAll()
+			require.NoError(t, err)
+		}
+		assert.True(t, time.Since(start) < expected/2)
+
+		for i := 0; i < burst; i++ {
+			require.NoError(t, db.Upsert(conformanceNs, &ConformanceItem{ID: i}))
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		defer cancel()
+		start = time.Now()
+		assert.Equal(t, context.DeadlineExceeded, db.WithContext(ctx).Upsert(conformanceNs, &ConformanceItem{ID: 1}))
+		assert.True(t, time.Since(start) < 5*time.Millisecond, "request must fail without waiting for the deadline")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		const duration = time.Second
+		db := NewInMemory(reindexer.WithRateLimit(reindexer.RateRule{
+			Match:   reindexer.RateMatch{CommandClass: reindexer.CommandClassRead},
+			RPS:     rps,
+			Burst:   burst,
+			OnLimit: reindexer.RateLimitError,
+		}))
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+		require.NoError(t, db.Upsert(conformanceNs, &ConformanceItem{ID: 1}))
+		// Opening of namespace may read its meta
+		before := db.Status().RateLimit[0]
+
+		start := time.Now()
+		ok, rejected, errs := run(func() error {
+			_, err := db.Query(conformanceNs).Exec().FetchAll()
+			return err
+		}, func(int64) bool { return time.Since(start) >= duration })
+		elapsed := time.Since(start)
+		require.Empty(t, errs)
+		assert.True(t, rejected > 0)
+		allowed := burst + rps*elapsed.Seconds()
+		assert.InDelta(t, allowed, float64(ok), 0.15*allowed, "%d requests are allowed in %v", ok, elapsed)
+
+		status := db.Status()
+		require.Equal(t, 1, len(status.RateLimit))
+		assert.Equal(t, ok, status.RateLimit[0].Allowed-before.Allowed)
+		assert.Equal(t, rejected, status.RateLimit[0].Rejected-before.Rejected)
+		assert.Equal(t, int64(0), status.RateLimit[0].Delayed)
+
+		// Ping is not limited
+		for i := 0; i < 2*burst; i++ {
+			require.NoError(t, db.Ping())
+		}
+	})
+
+	t.Run("invalid rule", func(t *testing.T) {
+		db := NewInMemory(reindexer.WithRateLimit(reindexer.RateRule{RPS: 0}))
+		defer db.Close()
+		assert.Error(t, db.Status().Err)
+	})
+}
+
+func TestNamespaceInvalidated(t *testing.T) {
+	missing := bindings.NewError(fmt.Sprintf("Namespace '%s' does not exist", conformanceNs), bindings.ErrParams)
+	type call struct {
+		ns     string
+		reason error
+	}
+	// newDB returns client, which records calls of invalidation handler
+	newDB := func(hooks *Hooks, reopen bool) (*reindexer.Reindexer, func() []call) {
+		var lock sync.Mutex
+		var calls []call
+		db := NewInMemory(WithHooks(hooks), reindexer.WithOnNamespaceInvalidated(func(ns string, reason error) {
+			lock.Lock()
+			calls = append(calls, call{ns, reason})
+			lock.Unlock()
+		}, reopen))
+		prepareConformanceNs(t, db)
+		return db, func() []call {
+			lock.Lock()
+			defer lock.Unlock()
+			return append([]call{}, calls...)
+		}
+	}
+
+	t.Run("missing namespace is reported once", func(t *testing.T) {
+		hooks := &Hooks{}
+		db, calls := newDB(hooks, false)
+		defer db.Close()
+		assert.Len(t, ids(t, db.Query(conformanceNs).Exec()), 20)
+
+		hooks.InjectError(OpSelect, missing)
+		assert.Equal(t, missing, db.Query(conformanceNs).Exec().Error())
+		assert.Equal(t, missing, db.Query(conformanceNs).Exec().Error())
+		assert.Equal(t, []call{{conformanceNs, missing}}, calls())
+
+		hooks.InjectError(OpSelect, nil)
+		assert.Len(t, ids(t, db.Query(conformanceNs).Exec()), 20)
+		hooks.InjectError(OpModifyItem, missing)
+		assert.Equal(t, missing, db.Upsert(conformanceNs, &ConformanceItem{ID: 1}))
+		assert.Len(t, calls(), 2, "namespace is found again, so the next error is reported")
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		hooks := &Hooks{}
+		db, calls := newDB(hooks, true)
+		defer db.Close()
+		opened := hooks.Calls(OpOpenNamespace)
+
+		hooks.InjectError(OpSelect, missing)
+		assert.Equal(t, missing, db.Query(conformanceNs).Exec().Error())
+		assert.Equal(t, []call{{conformanceNs, missing}}, calls())
+		assert.Equal(t, opened+1, hooks.Calls(OpOpenNamespace))
+
+		hooks.InjectError(OpSelect, nil)
+		assert.Len(t, ids(t, db.Query(conformanceNs).Exec()), 20)
+	})
+
+	t.Run("reopen error", func(t *testing.T) {
+		hooks := &Hooks{}
+		db, calls := newDB(hooks, true)
+		defer db.Close()
+
+		injected := bindings.NewError("injected", bindings.ErrLogic)
+		hooks.InjectError(OpOpenNamespace, injected)
+		hooks.InjectError(OpDeleteQuery, missing)
+		_, err := db.Query(conformanceNs).Delete()
+		assert.Equal(t, missing, err)
+		assert.Equal(t, []call{{conformanceNs, missing}, {conformanceNs, injected}}, calls())
+	})
+}
+
+func TestDestructiveGuard(t *testing.T) {
+	type confirmKey struct{}
+	var lock sync.Mutex
+	var ops []reindexer.DestructiveOp
+	rejected := errors.New("destructive operation is not confirmed")
+	guard := func(op reindexer.DestructiveOp) error {
+		ctx := op.Ctx
+		op.Ctx = nil
+		lock.Lock()
+		ops = append(ops, op)
+		lock.Unlock()
+		if op.Implicit {
+			return rejected
+		}
+		if confirmed, _ := ctx.Value(confirmKey{}).(bool); !confirmed {
+			return rejected
+		}
+		return nil
+	}
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks), reindexer.WithDestructiveGuard(guard))
+	defer db.Close()
+	prepareConformanceNs(t, db)
+	lastOp := func() reindexer.DestructiveOp {
+		lock.Lock()
+		defer lock.Unlock()
+		require.NotEmpty(t, ops)
+		return ops[len(ops)-1]
+	}
+	confirmed := db.WithContext(context.WithValue(context.Background(), confirmKey{}, true))
+
+	t.Run("not confirmed operations are blocked", func(t *testing.T) {
+		hooks.Reset()
+		assert.Equal(t, rejected, db.DropIndex(conformanceNs, "year"))
+		assert.Equal(t, reindexer.DestructiveOp{Type: reindexer.DestructiveDropIndex, Namespace: conformanceNs, Index: "year"}, lastOp())
+		assert.Equal(t, rejected, db.TruncateNamespace(conformanceNs))
+		assert.Equal(t, reindexer.DestructiveOp{Type: reindexer.DestructiveTruncateNamespace, Namespace: conformanceNs}, lastOp())
+		assert.Equal(t, rejected, db.DropNamespace(strings.ToUpper(conformanceNs)))
+		assert.Equal(t, reindexer.DestructiveOp{Type: reindexer.DestructiveDropNamespace, Namespace: conformanceNs}, lastOp())
+
+		assert.Equal(t, 0, hooks.Calls(OpDropIndex)+hooks.Calls(OpTruncateNamespace)+hooks.Calls(OpDropNamespace))
+		assert.Len(t, ids(t, db.Query(conformanceNs).Exec()), 20)
+	})
+
+	t.Run("confirmed operations are allowed", func(t *testing.T) {
+		hooks.Reset()
+		assert.NoError(t, confirmed.DropIndex(conformanceNs, "year"))
+		assert.NoError(t, confirmed.TruncateNamespace(conformanceNs))
+		assert.Empty(t, ids(t, db.Query(conformanceNs).Exec()))
+		assert.NoError(t, confirmed.DropNamespace(conformanceNs))
+		assert.Equal(t, 1, hooks.Calls(OpDropIndex))
+		assert.Equal(t, 1, hooks.Calls(OpTruncateNamespace))
+		assert.Equal(t, 1, hooks.Calls(OpDropNamespace))
+	})
+
+	t.Run("implicit drop on indexes conflict", func(t *testing.T) {
+		prepareConformanceNs(t, db)
+		hooks.Reset()
+		hooks.InjectError(OpAddIndex, bindings.NewError("rq: Index already exists with different settings", bindings.ErrConflict))
+		defer hooks.InjectError(OpAddIndex, nil)
+		err := db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions().DropOnIndexesConflict(), ConformanceItem{})
+		assert.Equal(t, rejected, err)
+		assert.Equal(t, reindexer.DestructiveOp{Type: reindexer.DestructiveDropNamespace, Namespace: conformanceNs, Implicit: true}, lastOp())
+		assert.Equal(t, 0, hooks.Calls(OpDropNamespace))
+		assert.Equal(t, 1, hooks.Calls(OpCloseNamespace))
+	})
+
+	t.Run("implicit drop on storage format error", func(t *testing.T) {
+		lock.Lock()
+		ops = nil
+		lock.Unlock()
+		require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+		assert.Empty(t, ops, "guard is not called without drop on storage format error")
+
+		require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions().DropOnFileFormatError(), ConformanceItem{}))
+		assert.Equal(t, reindexer.DestructiveOp{Type: reindexer.DestructiveDropNamespace, Namespace: conformanceNs, Implicit: true}, lastOp())
+		assert.Len(t, ids(t, db.Query(conformanceNs).Exec()), 20)
+	})
+}
+
+func TestConcurrentOpenNamespace(t *testing.T) {
+	const nsCount = 10
+	const goroutines = 50
+	type OtherItem struct {
+		ID int `reindex:"id,,pk"`
+	}
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks))
+	defer db.Close()
+	nsName := func(i int) string { return fmt.Sprintf("concurrent_open_%d", i) }
+
+	// Opens are slow, so the concurrent callers find them running
+	hooks.InjectLatency(OpOpenNamespace, 20*time.Millisecond)
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*nsCount)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < nsCount; i++ {
+				errs <- db.OpenNamespace(nsName(i), reindexer.DefaultNamespaceOptions(), ConformanceItem{})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	assert.Equal(t, nsCount, hooks.Calls(OpOpenNamespace), "each namespace must be opened once")
+
+	t.Run("opened namespace is not opened again", func(t *testing.T) {
+		hooks.Reset()
+		require.NoError(t, db.OpenNamespace(nsName(0), reindexer.DefaultNamespaceOptions(), &ConformanceItem{}))
+		assert.Equal(t, 0, hooks.Calls(OpOpenNamespace))
+		assert.Equal(t, 0, hooks.Calls(OpAddIndex))
+	})
+
+	t.Run("another struct", func(t *testing.T) {
+		err := db.OpenNamespace(nsName(0), reindexer.DefaultNamespaceOptions(), OtherItem{})
+		require.Error(t, err)
+		mismatch, ok := err.(reindexer.ErrNamespaceStructMismatch)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.Equal(t, nsName(0), mismatch.Namespace)
+		assert.Equal(t, reindexer.ErrCodeParams, mismatch.Code())
+		assert.Equal(t, err, db.RegisterNamespace(nsName(0), reindexer.DefaultNamespaceOptions(), OtherItem{}))
+	})
+
+	t.Run("reopen after close", func(t *testing.T) {
+		hooks.Reset()
+		require.NoError(t, db.CloseNamespace(nsName(1)))
+		require.NoError(t, db.OpenNamespace(nsName(1), reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+		assert.Equal(t, 1, hooks.Calls(OpOpenNamespace))
+
+		// Closed namespace may be registered with another struct
+		require.NoError(t, db.CloseNamespace(nsName(3)))
+		require.NoError(t, db.RegisterNamespace(nsName(3), reindexer.DefaultNamespaceOptions(), OtherItem{}))
+	})
+
+	t.Run("reopen after index is dropped", func(t *testing.T) {
+		hooks.Reset()
+		require.NoError(t, db.DropIndex(nsName(2), "year"))
+		require.NoError(t, db.OpenNamespace(nsName(2), reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+		assert.Equal(t, 1, hooks.Calls(OpOpenNamespace))
+	})
+}
+
+func TestRunInTx(t *testing.T) {
+	ctx := context.Background()
+	conflict := bindings.NewError("rq: injected conflict", bindings.ErrStateMismatch)
+	opts := reindexer.TxRetryOptions{Attempts: 5, Backoff: time.Millisecond}
+	getItem := func(t *testing.T, db *reindexer.Reindexer, id int) *ConformanceItem {
+		item, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, id).Get()
+		require.True(t, found)
+		return item.(*ConformanceItem)
+	}
+
+	t.Run("concurrent writer", func(t *testing.T) {
+		db := NewInMemory()
+		defer db.Close()
+		prepareConformanceNs(t, db)
+		// Writer changes the item between read and check of the first attempts
+		writes := make(chan int)
+		written := make(chan struct{})
+		go func() {
+			for rate := range writes {
+				assert.NoError(t, db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 1).Set("rate", float64(rate)).Update().Error())
+				written <- struct{}{}
+			}
+		}()
+		defer close(writes)
+
+		attempts := 0
+		err := reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			attempts++
+			item := *getItem(t, db, 1)
+			if attempts < 3 {
+				writes <- 100 + attempts
+				<-written
+			}
+			if getItem(t, db, 1).Rate != item.Rate {
+				return reindexer.ErrStateMismatch{Namespace: conformanceNs}
+			}
+			item.Year++
+			return tx.Upsert(&item)
+		}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+		item := getItem(t, db, 1)
+		assert.Equal(t, 2000+1+1, item.Year)
+		assert.Equal(t, float64(102), item.Rate, "update of concurrent writer is lost")
+	})
+
+	t.Run("commit conflicts", func(t *testing.T) {
+		hooks := &Hooks{}
+		db := NewInMemory(WithHooks(hooks))
+		defer db.Close()
+		prepareConformanceNs(t, db)
+
+		hooks.InjectError(OpCommitTx, conflict)
+		attempts := 0
+		err := reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			if attempts++; attempts == 3 {
+				hooks.InjectError(OpCommitTx, nil)
+			}
+			return tx.Upsert(&ConformanceItem{ID: 100, Year: attempts})
+		}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, 3, hooks.Calls(OpCommitTx))
+		assert.Equal(t, 3, getItem(t, db, 100).Year)
+	})
+
+	t.Run("attempts are exhausted", func(t *testing.T) {
+		hooks := &Hooks{}
+		db := NewInMemory(WithHooks(hooks))
+		defer db.Close()
+		prepareConformanceNs(t, db)
+
+		hooks.InjectError(OpCommitTx, conflict)
+		err := reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			return tx.Upsert(&ConformanceItem{ID: 100})
+		}, reindexer.TxRetryOptions{Attempts: 2, Backoff: time.Millisecond})
+		require.Error(t, err)
+		rerr, ok := err.(*reindexer.ErrTxAttempts)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.Equal(t, 2, rerr.Attempts)
+		assert.Equal(t, conflict, rerr.Err)
+		assert.Equal(t, reindexer.ErrCodeStateMismatch, rerr.Code())
+		assert.Equal(t, 2, hooks.Calls(OpCommitTx))
+	})
+
+	t.Run("custom conflicts", func(t *testing.T) {
+		db := NewInMemory()
+		defer db.Close()
+		prepareConformanceNs(t, db)
+
+		retryable := errors.New("retryable")
+		attempts := 0
+		err := reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			if attempts++; attempts < 2 {
+				return retryable
+			}
+			return nil
+		}, reindexer.TxRetryOptions{Backoff: time.Millisecond, IsConflict: func(err error) bool { return err == retryable }})
+		require.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("other errors are not retried", func(t *testing.T) {
+		hooks := &Hooks{}
+		db := NewInMemory(WithHooks(hooks))
+		defer db.Close()
+		prepareConformanceNs(t, db)
+
+		failed := errors.New("failed")
+		err := reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			require.NoError(t, tx.Upsert(&ConformanceItem{ID: 100}))
+			return failed
+		}, opts)
+		require.Error(t, err)
+		assert.Equal(t, 1, err.(*reindexer.ErrTxAttempts).Attempts)
+		assert.Equal(t, failed, err.(*reindexer.ErrTxAttempts).Err)
+		assert.Equal(t, 1, hooks.Calls(OpRollbackTx))
+		assert.Equal(t, 0, hooks.Calls(OpCommitTx))
+		_, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 100).Get()
+		assert.False(t, found)
+	})
+
+	t.Run("misuse", func(t *testing.T) {
+		hooks := &Hooks{}
+		db := NewInMemory(WithHooks(hooks))
+		defer db.Close()
+		prepareConformanceNs(t, db)
+
+		err := reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			return tx.Commit()
+		}, opts)
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+		assert.Equal(t, 1, err.(*reindexer.ErrTxAttempts).Attempts)
+
+		// Transaction of the finished attempt is saved outside and is used by the next attempt
+		hooks.InjectError(OpCommitTx, conflict)
+		var saved *reindexer.Tx
+		err = reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			if saved == nil {
+				saved = tx
+			}
+			return saved.Upsert(&ConformanceItem{ID: 100})
+		}, opts)
+		require.Error(t, err)
+		assert.Equal(t, 2, err.(*reindexer.ErrTxAttempts).Attempts)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+		assert.Error(t, saved.Upsert(&ConformanceItem{ID: 101}))
+	})
+
+	t.Run("context is canceled on backoff", func(t *testing.T) {
+		hooks := &Hooks{}
+		db := NewInMemory(WithHooks(hooks))
+		defer db.Close()
+		prepareConformanceNs(t, db)
+
+		hooks.InjectError(OpCommitTx, conflict)
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := reindexer.RunInTx(ctx, db, conformanceNs, func(tx *reindexer.Tx) error {
+			return tx.Upsert(&ConformanceItem{ID: 100})
+		}, reindexer.TxRetryOptions{Attempts: 100, Backoff: time.Minute})
+		require.Error(t, err)
+		assert.Equal(t, 1, err.(*reindexer.ErrTxAttempts).Attempts)
+		assert.True(t, time.Since(start) < 10*time.Second)
+	})
+}
+
+type stableSortItem struct {
+	Key   int      `reindex:"key" json:"key"`
+	Value int      `reindex:"value" json:"value"`
+	_     struct{} `reindex:"key+value,,composite,pk"`
+}
+
+func TestStableSort(t *testing.T) {
+	const count, pageSize = 30, 4
+	db := NewInMemory()
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+	// Order of insertion differs from order of primary keys, there are 3 distinct sort keys
+	for i := 0; i < count; i++ {
+		require.NoError(t, db.Upsert(conformanceNs, &ConformanceItem{ID: (i * 7) % count, Year: 2000 + i%3}))
+	}
+
+	// readPages reads all the pages of query results. Items of each page are rewritten after it's read,
+	// so order of items with equal sort keys, which depends on storage, is changed
+	readPages := func(t *testing.T, query func() *reindexer.Query) []int {
+		var res []int
+		for offset := 0; offset < count; offset += pageSize {
+			page := ids(t, query().Offset(offset).Limit(pageSize).Exec())
+			for _, id := range page {
+				item, found := db.Query(conformanceNs).WhereInt("id", reindexer.EQ, id).Get()
+				require.True(t, found)
+				require.NoError(t, db.Delete(conformanceNs, item))
+				require.NoError(t, db.Upsert(conformanceNs, item))
+			}
+			res = append(res, page...)
+		}
+		return res
+	}
+	uniq := func(ids []int) map[int]bool {
+		res := make(map[int]bool)
+		for _, id := range ids {
+			res[id] = true
+		}
+		return res
+	}
+
+	t.Run("without tie-breaker", func(t *testing.T) {
+		res := readPages(t, func() *reindexer.Query { return db.Query(conformanceNs).Sort("year", false) })
+		assert.Len(t, res, count)
+		assert.True(t, len(uniq(res)) < count, "pages must overlap: %v", res)
+	})
+
+	t.Run("stable sort", func(t *testing.T) {
+		res := readPages(t, func() *reindexer.Query { return db.Query(conformanceNs).Sort("year", false).StableSort() })
+		require.Len(t, uniq(res), count, "pages must not overlap: %v", res)
+		for i := 1; i < count; i++ {
+			prevYear, year := 2000+(res[i-1]*13)%count%3, 2000+(res[i]*13)%count%3
+			assert.True(t, prevYear < year || (prevYear == year && res[i-1] < res[i]), "%v", res)
+		}
+	})
+
+	t.Run("forced sort", func(t *testing.T) {
+		res := ids(t, db.Query(conformanceNs).Sort("year", false, 2002).StableSort().Limit(count/3+1).Exec())
+		for i, id := range res[:count/3] {
+			assert.Equal(t, 2002, 2000+(id*13)%count%3, "%v", res)
+			if i > 0 {
+				assert.True(t, res[i-1] < id, "%v", res)
+			}
+		}
+		assert.NotEqual(t, 2002, 2000+(res[count/3]*13)%count%3)
+	})
+
+	t.Run("sorted by pk", func(t *testing.T) {
+		res := ids(t, db.Query(conformanceNs).Sort("id", true).StableSort().Limit(3).Exec())
+		assert.Equal(t, []int{29, 28, 27}, res)
+	})
+
+	t.Run("composite pk", func(t *testing.T) {
+		const ns = "stable_sort_composite_pk"
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), stableSortItem{}))
+		for i := 0; i < 5; i++ {
+			require.NoError(t, db.Upsert(ns, []byte(fmt.Sprintf(`{"key":%d,"value":%d}`, i%2, 5-i))))
+		}
+		it := db.Query(ns).Sort("key", false).StableSort().Exec()
+		assert.Equal(t, reindexer.ErrCodeParams, it.Error().(reindexer.Error).Code())
+		it.Close()
+
+		it = db.Query(ns).Sort("key", false).StableSort("value").Exec()
+		defer it.Close()
+		var values []int
+		for it.Next() {
+			values = append(values, it.Object().(*stableSortItem).Value)
+		}
+		require.NoError(t, it.Error())
+		assert.Equal(t, []int{1, 3, 5, 2, 4}, values)
+	})
+}
+
+func TestRawJSON(t *testing.T) {
+	var invalidated []string
+	db := NewInMemory(reindexer.WithOnNamespaceInvalidated(func(ns string, reason error) {
+		invalidated = append(invalidated, ns)
+	}, false))
+	defer db.Close()
+	prepareConformanceNs(t, db)
+	ctx := context.Background()
+
+	t.Run("error path", func(t *testing.T) {
+		err := db.UpsertJSON(ctx, conformanceNs, []byte(`{"id":1,"nested":{"code":}}`))
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParseJson, err.(reindexer.Error).Code())
+		assert.Contains(t, err.Error(), "field 'nested.code'")
+	})
+
+	t.Run("not registered namespace", func(t *testing.T) {
+		// Items are sent to server, which reports missing namespace
+		err := db.UpsertJSON(ctx, "raw_items", []byte(`{"id":1}`))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'raw_items' does not exist")
+		_, err = db.BeginTx("raw_items")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'raw_items' does not exist")
+		assert.Empty(t, invalidated)
+	})
+}
+
+func TestQueryCacheKey(t *testing.T) {
+	db := NewInMemory()
+	defer db.Close()
+	prepareConformanceNs(t, db)
+	key := func(q *reindexer.Query) uint64 {
+		k, err := q.CacheKey()
+		require.NoError(t, err)
+		return k
+	}
+
+	// Keys must not change between processes and versions of client, otherwise persistent caches are invalidated
+	golden := []struct {
+		q   *reindexer.Query
+		key uint64
+	}{
+		{db.Query(conformanceNs), 0x39a5bcfe7c1a8744},
+		{db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 1), 0x27e50db474e2f9b3},
+		{db.Query(conformanceNs).WhereString("name", reindexer.SET, "name_1", "name_2").Sort("year", true).Offset(5).Limit(10).ReqTotal(), 0x9307e74cb59fc851},
+		{db.Query(conformanceNs).WhereInt("year", reindexer.GE, 2001).Not().WhereBool("active", reindexer.EQ, true).Select("id", "name").StableSort(), 0x80b6fcf79a7c860e},
+	}
+	for i, g := range golden {
+		assert.Equal(t, g.key, key(g.q), "query #%d", i)
+	}
+
+	assert.Equal(t, key(db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 1)), key(db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 1).
+		Label("label").FetchCount(5)), "parameters of execution are not hashed")
+	assert.NotEqual(t, key(db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 1)), key(db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 2)))
+	assert.NotEqual(t, key(db.Query(conformanceNs).Limit(1)), key(db.Query(conformanceNs).Offset(1)))
+}
+
+func TestQueryHooks(t *testing.T) {
+	type exec struct {
+		key     uint64
+		q       reindexer.QueryDescription
+		summary *reindexer.ResultSummary
+	}
+	var execs []exec
+	var serve *reindexer.CachedResult
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks), reindexer.WithQueryHooks(func(key uint64, q reindexer.QueryDescription) (bool, reindexer.CachedResult) {
+		execs = append(execs, exec{key: key, q: q})
+		if serve == nil {
+			return false, reindexer.CachedResult{}
+		}
+		return true, *serve
+	}, func(key uint64, res reindexer.ResultSummary) {
+		require.NotEmpty(t, execs)
+		assert.Equal(t, execs[len(execs)-1].key, key)
+		execs[len(execs)-1].summary = &res
+	}))
+	defer db.Close()
+	prepareConformanceNs(t, db)
+	execs = nil
+	query := func() *reindexer.Query {
+		return db.Query(conformanceNs).WhereInt("year", reindexer.EQ, 2001).Sort("id", false).Limit(2).ReqTotal().Label("cached")
+	}
+	key, err := query().CacheKey()
+	require.NoError(t, err)
+
+	it := query().Exec()
+	require.NoError(t, it.Error())
+	count, total := it.Count(), it.TotalCount()
+	items, err := it.FetchAll()
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.Len(t, execs, 1)
+	assert.Equal(t, exec{key: key, q: reindexer.QueryDescription{Namespace: conformanceNs, Label: "cached"},
+		summary: &reindexer.ResultSummary{Namespace: conformanceNs, Count: 2, TotalCount: 4}}, execs[0])
+
+	jit := query().ExecToJson()
+	require.NoError(t, jit.Error())
+	var docs [][]byte
+	for jit.Next() {
+		docs = append(docs, append([]byte(nil), jit.JSON()...))
+	}
+	jit.Close()
+	json, err := query().ExecToJson().FetchAll()
+	require.NoError(t, err)
+	require.Len(t, execs, 3)
+	assert.Equal(t, reindexer.QueryDescription{Namespace: conformanceNs, Label: "cached", JSON: true}, execs[1].q)
+	assert.Equal(t, &reindexer.ResultSummary{Namespace: conformanceNs, JSON: true, Count: 2, TotalCount: 4}, execs[1].summary)
+
+	// Served results must not be requested from server
+	hooks.InjectError(OpSelect, bindings.NewError("injected", bindings.ErrLogic))
+	serve = &reindexer.CachedResult{Items: items, JSON: docs, TotalCount: total}
+	execs = nil
+
+	t.Run("served items", func(t *testing.T) {
+		it := query().Exec()
+		require.NoError(t, it.Error())
+		assert.Equal(t, count, it.Count())
+		assert.Equal(t, total, it.TotalCount())
+		cached, err := it.FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, items, cached)
+		for i := range items {
+			assert.True(t, items[i] != cached[i], "objects of iterator must be copies of cached items")
+		}
+
+		it = query().Exec()
+		obj := &ConformanceItem{}
+		require.True(t, it.NextObj(obj))
+		assert.Equal(t, items[0], obj)
+		assert.True(t, it.Next())
+		assert.False(t, it.Next())
+		it.Close()
+
+		item, found := query().Get()
+		require.True(t, found)
+		assert.Equal(t, items[0], item)
+		require.Len(t, execs, 3)
+		for _, e := range execs {
+			assert.Nil(t, e.summary, "results of server are not received")
+		}
+	})
+
+	t.Run("served json", func(t *testing.T) {
+		cached, err := query().ExecToJson().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, string(json), string(cached))
+	})
+
+	t.Run("served aggregations", func(t *testing.T) {
+		saved := serve
+		defer func() { serve = saved }()
+		serve = &reindexer.CachedResult{Aggregations: [][]byte{[]byte(`{"fields":["year"],"type":"max","value":2004}`)}}
+		q := db.Query(conformanceNs)
+		q.AggregateMax("year")
+		it := q.Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		assert.Equal(t, 0, it.Count())
+		require.Len(t, it.AggResults(), 1)
+		assert.Equal(t, 2004.0, it.AggResults()[0].Value)
+	})
+
+	t.Run("wrong type of item", func(t *testing.T) {
+		saved := serve
+		defer func() { serve = saved }()
+		serve = &reindexer.CachedResult{Items: []interface{}{&ConformanceNested{}}}
+		assert.Equal(t, reindexer.ErrWrongType, query().Exec().Error())
+	})
+
+	t.Run("queries with joins bypass hooks", func(t *testing.T) {
+		execs = nil
+		it := db.Query(conformanceNs).InnerJoin(db.Query(conformanceNs), "joined").On("id", reindexer.EQ, "id").Exec()
+		defer it.Close()
+		assert.Error(t, it.Error())
+		assert.Empty(t, execs)
+	})
+}
+
+func TestDiffResults(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemory()
+	defer db.Close()
+	prepareConformanceNs(t, db)
+	key := func(item *ConformanceItem) string { return fmt.Sprint(item.ID) }
+	diff := func(prev reindexer.Snapshot) (added, changed []int, removed []string, next reindexer.Snapshot) {
+		q := db.Query(conformanceNs).WhereInt("year", reindexer.LT, 2004)
+		addedItems, changedItems, removed, next, err := reindexer.DiffResults(ctx, db, q, prev, key)
+		require.NoError(t, err)
+		for _, item := range addedItems {
+			added = append(added, item.ID)
+		}
+		for _, item := range changedItems {
+			changed = append(changed, item.ID)
+		}
+		return added, changed, removed, next
+	}
+
+	added, changed, removed, snapshot := diff(reindexer.Snapshot{})
+	assert.Len(t, added, 16)
+	assert.Empty(t, changed)
+	assert.Empty(t, removed)
+	assert.Len(t, snapshot.Hashes, 16)
+
+	added, changed, removed, snapshot = diff(snapshot)
+	assert.Empty(t, added)
+	assert.Empty(t, changed)
+	assert.Empty(t, removed)
+
+	// 1 is changed, 2 is deleted, 3 is moved out of results, 4 is moved into results, 100 is inserted
+	require.NoError(t, db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 1).Set("name", "changed").Update().Error())
+	require.NoError(t, db.Delete(conformanceNs, &ConformanceItem{ID: 2}))
+	require.NoError(t, db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 3).Set("year", 2004).Update().Error())
+	require.NoError(t, db.Query(conformanceNs).WhereInt("id", reindexer.EQ, 4).Set("year", 2000).Update().Error())
+	require.NoError(t, db.Upsert(conformanceNs, &ConformanceItem{ID: 100, Year: 2001}))
+	added, changed, removed, snapshot = diff(snapshot)
+	assert.ElementsMatch(t, []int{4, 100}, added)
+	assert.Equal(t, []int{1}, changed)
+	assert.Equal(t, []string{"2", "3"}, removed)
+	assert.Len(t, snapshot.Hashes, 16)
+
+	t.Run("wrong type", func(t *testing.T) {
+		_, _, _, _, err := reindexer.DiffResults(ctx, db, db.Query(conformanceNs), reindexer.Snapshot{}, func(item ConformanceItem) string { return "" })
+		assert.Equal(t, reindexer.ErrWrongType, err)
+	})
+
+	t.Run("duplicate keys", func(t *testing.T) {
+		_, _, _, _, err := reindexer.DiffResults(ctx, db, db.Query(conformanceNs), reindexer.Snapshot{}, func(item *ConformanceItem) string { return item.Name })
+		assert.Error(t, err)
+	})
+}
+
+func TestSystemNamespaces(t *testing.T) {
+	db := NewInMemory()
+	defer db.Close()
+	prepareConformanceNs(t, db)
+
+	readOnly := reindexer.ErrSystemNamespace{Namespace: reindexer.PerfstatsNamespaceName, ReadOnly: true}
+	assert.Equal(t, readOnly, db.Upsert(reindexer.PerfstatsNamespaceName, &reindexer.NamespacePerfStat{Name: conformanceNs}))
+	assert.Equal(t, readOnly, db.Delete(reindexer.PerfstatsNamespaceName, &reindexer.NamespacePerfStat{Name: conformanceNs}))
+	_, err := db.BeginTx(reindexer.PerfstatsNamespaceName)
+	assert.Equal(t, readOnly, err)
+
+	err = db.OpenNamespace(reindexer.ConfigNamespaceName, reindexer.DefaultNamespaceOptions(), ConformanceItem{})
+	assert.Equal(t, reindexer.ErrSystemNamespace{Namespace: reindexer.ConfigNamespaceName}, err)
+	assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+	// Namespace, which is registered with its own type, is not affected
+	assert.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+}
+
+type SoftDeleteItem struct {
+	ID        int    `reindex:"id,,pk" json:"id"`
+	Name      string `reindex:"name" json:"name"`
+	Deleted   bool   `reindex:"deleted" json:"deleted"`
+	DeletedAt int64  `reindex:"deleted_at" json:"deleted_at"`
+}
+
+func TestSoftDelete(t *testing.T) {
+	const ns = "soft_delete_items"
+	ctx := context.Background()
+	db := NewInMemory()
+	defer db.Close()
+	opts := reindexer.DefaultNamespaceOptions().SoftDeleteWith(reindexer.SoftDeleteOptions{Field: "deleted", TimeField: "deleted_at"})
+	require.NoError(t, db.OpenNamespace(ns, opts, SoftDeleteItem{}))
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, db.Upsert(ns, &SoftDeleteItem{ID: i, Name: fmt.Sprintf("item%d", i)}))
+	}
+	ids := func(q *reindexer.Query) (ids []int) {
+		items, err := q.Sort("id", false).Exec().FetchAll()
+		require.NoError(t, err)
+		for _, item := range items {
+			ids = append(ids, item.(*SoftDeleteItem).ID)
+		}
+		return ids
+	}
+
+	require.NoError(t, db.Delete(ns, &SoftDeleteItem{ID: 2}))
+	require.NoError(t, db.Delete(ns, &SoftDeleteItem{ID: 4}))
+	assert.Equal(t, []int{1, 3, 5}, ids(db.Query(ns)))
+	// Filter is not joined to the last condition by Or
+	assert.Equal(t, []int{1, 3}, ids(db.Query(ns).WhereInt("id", reindexer.EQ, 1).Or().WhereInt("id", reindexer.EQ, 2).Or().WhereInt("id", reindexer.EQ, 3)))
+
+	item, found := db.Query(ns).IncludeDeleted().WhereInt("id", reindexer.EQ, 2).Get()
+	require.True(t, found)
+	assert.True(t, item.(*SoftDeleteItem).Deleted)
+	assert.NotEqual(t, int64(0), item.(*SoftDeleteItem).DeletedAt)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, ids(db.Query(ns).IncludeDeleted()))
+
+	// Soft deleted items are not updated by queries
+	it := db.Query(ns).Set("name", "updated").Update()
+	assert.Equal(t, 3, it.Count())
+	it.Close()
+
+	count, err := db.Purge(ctx, ns, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	count, err = db.Purge(ctx, ns, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []int{1, 3, 5}, ids(db.Query(ns).IncludeDeleted()))
+
+	t.Run("hard delete", func(t *testing.T) {
+		const ns = "hard_delete_items"
+		opts := reindexer.DefaultNamespaceOptions().SoftDeleteWith(reindexer.SoftDeleteOptions{Field: "deleted", HardDelete: true})
+		require.NoError(t, db.OpenNamespace(ns, opts, SoftDeleteItem{}))
+		require.NoError(t, db.Upsert(ns, &SoftDeleteItem{ID: 1}))
+		require.NoError(t, db.Upsert(ns, &SoftDeleteItem{ID: 2, Deleted: true}))
+		require.NoError(t, db.Delete(ns, &SoftDeleteItem{ID: 1}))
+		assert.Empty(t, ids(db.Query(ns)))
+		assert.Equal(t, []int{2}, ids(db.Query(ns).IncludeDeleted()))
+
+		// Time field is required to purge by age
+		_, err := db.Purge(ctx, ns, time.Hour)
+		assert.Error(t, err)
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		prepareConformanceNs(t, db)
+		_, err := db.Purge(ctx, conformanceNs, 0)
+		assert.Error(t, err)
+	})
+}
+
+type UnknownFieldsItem struct {
+	ID      int               `reindex:"id,,pk" json:"id"`
+	Name    string            `reindex:"name" json:"name"`
+	Unknown reindexer.Unknown `json:"-"`
+}
+
+func TestUnknownFields(t *testing.T) {
+	ctx := context.Background()
+	db := NewInMemory()
+	defer db.Close()
+	// Document is written by the other service, which knows more fields
+	foreign := `{"id":1,"name":"first","rank":5,"owner":{"name":"other","tags":["a","b"]}}`
+	open := func(ns string, policy int) {
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().UnknownFields(policy), UnknownFieldsItem{}))
+		require.NoError(t, db.UpsertJSON(ctx, ns, []byte(foreign)))
+	}
+	get := func(ns string) (*UnknownFieldsItem, error) {
+		item, err := db.Query(ns).WhereInt("id", reindexer.EQ, 1).Exec().FetchOne()
+		if err != nil {
+			return nil, err
+		}
+		return item.(*UnknownFieldsItem), nil
+	}
+	getJSON := func(ns string) map[string]interface{} {
+		it := db.Query(ns).WhereInt("id", reindexer.EQ, 1).ExecToJson()
+		defer it.Close()
+		require.True(t, it.Next())
+		doc := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(it.JSON(), &doc))
+		return doc
+	}
+
+	t.Run("preserve", func(t *testing.T) {
+		const ns = "unknown_fields_preserve"
+		open(ns, reindexer.PreserveUnknownFields)
+		item, err := get(ns)
+		require.NoError(t, err)
+		assert.Equal(t, reindexer.Unknown{"rank": 5, "owner": map[string]interface{}{"name": "other", "tags": []interface{}{"a", "b"}}}, item.Unknown)
+
+		// Read-modify-write keeps fields of the other service
+		item.Name = "changed"
+		require.NoError(t, db.Upsert(ns, item))
+		doc := getJSON(ns)
+		assert.Equal(t, "changed", doc["name"])
+		assert.Equal(t, float64(5), doc["rank"])
+		assert.Equal(t, map[string]interface{}{"name": "other", "tags": []interface{}{"a", "b"}}, doc["owner"])
+
+		// Fields, which are set by update query, are preserved too
+		require.NoError(t, db.Query(ns).WhereInt("id", reindexer.EQ, 1).Set("rank", 6).Update().Error())
+		item, err = get(ns)
+		require.NoError(t, err)
+		assert.Equal(t, 6, item.Unknown["rank"])
+		require.NoError(t, db.Upsert(ns, item))
+		assert.Equal(t, float64(6), getJSON(ns)["rank"])
+
+		// Objects of update queries are encoded with fields of carrier
+		require.NoError(t, db.Query(ns).WhereInt("id", reindexer.EQ, 1).SetObject("copy", *item).Update().Error())
+		assert.Equal(t, float64(6), getJSON(ns)["copy"].(map[string]interface{})["rank"])
+
+		copied := item.Unknown.Copy()
+		copied["owner"].(map[string]interface{})["name"] = "copy"
+		assert.Equal(t, "other", item.Unknown["owner"].(map[string]interface{})["name"])
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		const ns = "unknown_fields_drop"
+		open(ns, reindexer.DropUnknownFields)
+		item, err := get(ns)
+		require.NoError(t, err)
+		assert.Empty(t, item.Unknown)
+		require.NoError(t, db.Upsert(ns, item))
+		assert.NotContains(t, getJSON(ns), "rank")
+	})
+
+	t.Run("error", func(t *testing.T) {
+		const ns = "unknown_fields_error"
+		open(ns, reindexer.ErrorOnUnknownFields)
+		_, err := get(ns)
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+		assert.IsType(t, reindexer.ErrUnknownField{}, err)
+	})
+}
+
+type UpsertDedupItem struct {
+	ID      int    `reindex:"id,,pk" json:"id"`
+	Name    string `reindex:"name" json:"name"`
+	Updated int64  `reindex:"updated" json:"updated"`
+}
+
+type RelTimeItem struct {
+	ID       int       `reindex:"id,,pk" json:"id"`
+	Unix     int64     `reindex:"unix,,time=unix" json:"unix"`
+	UnixNano int64     `reindex:"unix_nano,,time=unixnano" json:"unix_nano"`
+	RFC3339  string    `reindex:"rfc3339,,time=rfc3339" json:"rfc3339"`
+	Created  time.Time `json:"created"`
+}
+
+func TestRelTime(t *testing.T) {
+	const ns = "rel_time_items"
+	db := NewInMemory()
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), RelTimeItem{}))
+
+	now := time.Now()
+	for id, offset := range []time.Duration{-time.Hour, -10 * time.Minute, 300 * time.Millisecond, time.Hour} {
+		tm := now.Add(offset).UTC()
+		require.NoError(t, db.Upsert(ns, &RelTimeItem{ID: id, Unix: tm.Unix(), UnixNano: tm.UnixNano(), RFC3339: tm.Format(time.RFC3339Nano), Created: tm}))
+	}
+	ids := func(q *reindexer.Query) []int {
+		items, err := q.Sort("id", false).Exec().FetchAll()
+		require.NoError(t, err)
+		ids := []int{}
+		for _, item := range items {
+			ids = append(ids, item.(*RelTimeItem).ID)
+		}
+		return ids
+	}
+
+	for _, field := range []string{"unix", "unix_nano", "rfc3339", "created"} {
+		t.Run(field, func(t *testing.T) {
+			assert.Equal(t, []int{1, 2, 3}, ids(db.Query(ns).WhereSince(field, 15*time.Minute)))
+			assert.Equal(t, []int{0}, ids(db.Query(ns).WhereUntil(field, 15*time.Minute)))
+			assert.Equal(t, []int{1}, ids(db.Query(ns).Where(field, reindexer.RANGE, []interface{}{reindexer.RelTime(-15 * time.Minute), reindexer.RelTime(-5 * time.Minute)})))
+		})
+	}
+
+	t.Run("clone", func(t *testing.T) {
+		template := db.Query(ns).WhereUntil("unix_nano", 0)
+		assert.Equal(t, []int{0, 1}, ids(template.MakeCopy(db)))
+		time.Sleep(400 * time.Millisecond)
+		// "now" is resolved again by each execution
+		assert.Equal(t, []int{0, 1, 2}, ids(template.MakeCopy(db)))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		deleted, err := db.Query(ns).WhereUntil("created", 30*time.Minute).Delete()
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+	})
+
+	t.Run("unknown representation", func(t *testing.T) {
+		_, err := db.Query(ns).Where("id", reindexer.LE, reindexer.RelTime(0)).Exec().FetchAll()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "time=")
+	})
+
+	t.Run("invalid option", func(t *testing.T) {
+		type item struct {
+			ID   int    `reindex:"id,,pk" json:"id"`
+			Unix string `reindex:"unix,,time=unix" json:"unix"`
+		}
+		assert.Panics(t, func() { db.OpenNamespace("rel_time_invalid_items", reindexer.DefaultNamespaceOptions(), item{}) })
+	})
+
+	t.Run("cache key", func(t *testing.T) {
+		key := func(q *reindexer.Query) uint64 {
+			k, err := q.CacheKey()
+			require.NoError(t, err)
+			return k
+		}
+		since := key(db.Query(ns).WhereSince("unix", time.Minute))
+		assert.Equal(t, since, key(db.Query(ns).WhereSince("unix", time.Minute)))
+		assert.NotEqual(t, since, key(db.Query(ns).WhereSince("unix", 2*time.Minute)))
+		assert.NotEqual(t, since, key(db.Query(ns).Where("unix", reindexer.GE, int64(-time.Minute))))
+	})
+}
+
+func TestDeleteBatched(t *testing.T) {
+	const ns = "delete_batched_items"
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks))
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), UpsertDedupItem{}))
+	fill := func(from, to int) {
+		for id := from; id < to; id++ {
+			require.NoError(t, db.Upsert(ns, &UpsertDedupItem{ID: id, Name: "expired"}))
+		}
+	}
+	remain := func() int {
+		it := db.Query(ns).WhereString("name", reindexer.EQ, "expired").Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		return it.Count()
+	}
+	fill(0, 1000)
+	require.NoError(t, db.Upsert(ns, &UpsertDedupItem{ID: 1000, Name: "kept"}))
+
+	t.Run("progress and rate", func(t *testing.T) {
+		var progress []int64
+		start := time.Now()
+		deleted, err := db.Query(ns).WhereString("name", reindexer.EQ, "expired").DeleteBatched(context.Background(), reindexer.BatchDeleteOptions{
+			BatchSize: 100,
+			MaxRate:   2000,
+			Progress:  func(deleted int64) { progress = append(progress, deleted) },
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1000), deleted)
+		assert.Equal(t, []int64{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000}, progress)
+		// 1000 items are deleted not faster than 2000 per second
+		assert.True(t, time.Since(start) >= 500*time.Millisecond, "elapsed %s", time.Since(start))
+		assert.Equal(t, 0, remain())
+		assert.Equal(t, 11, hooks.Calls(OpDeleteQuery))
+	})
+
+	t.Run("transient errors", func(t *testing.T) {
+		fill(0, 250)
+		hooks.Reset()
+		hooks.InjectErrorAfter(OpDeleteQuery, 1, bindings.NewError("rq: connection is lost", bindings.ErrNetwork))
+		go func() {
+			time.Sleep(150 * time.Millisecond)
+			hooks.InjectError(OpDeleteQuery, nil)
+		}()
+		deleted, err := db.Query(ns).WhereString("name", reindexer.EQ, "expired").DeleteBatched(context.Background(), reindexer.BatchDeleteOptions{BatchSize: 100})
+		require.NoError(t, err)
+		assert.Equal(t, int64(250), deleted)
+		assert.Equal(t, 0, remain())
+
+		fill(0, 250)
+		hooks.InjectErrorAfter(OpDeleteQuery, 1, bindings.NewError("rq: invalid query", bindings.ErrParams))
+		deleted, err = db.Query(ns).WhereString("name", reindexer.EQ, "expired").DeleteBatched(context.Background(), reindexer.BatchDeleteOptions{BatchSize: 100})
+		require.Error(t, err)
+		assert.Equal(t, int64(100), deleted)
+		hooks.InjectError(OpDeleteQuery, nil)
+	})
+
+	t.Run("concurrent inserts", func(t *testing.T) {
+		fill(0, 300)
+		var inserted int32
+		deleted, err := db.Query(ns).WhereString("name", reindexer.EQ, "expired").DeleteBatched(context.Background(), reindexer.BatchDeleteOptions{
+			BatchSize: 50,
+			Progress: func(int64) {
+				if n := int(atomic.AddInt32(&inserted, 1)); n <= 2 {
+					fill(2000+10*n, 2010+10*n)
+				}
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(300+20), deleted)
+		assert.Equal(t, 0, remain())
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		fill(0, 300)
+		ctx, cancel := context.WithCancel(context.Background())
+		deleted, err := db.Query(ns).WhereString("name", reindexer.EQ, "expired").DeleteBatched(ctx, reindexer.BatchDeleteOptions{
+			BatchSize: 100,
+			Pause:     time.Hour,
+			Progress:  func(int64) { cancel() },
+		})
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, int64(100), deleted)
+		assert.Equal(t, 200, remain())
+	})
+
+	item, found := db.Query(ns).WhereInt("id", reindexer.EQ, 1000).Get()
+	require.True(t, found)
+	assert.Equal(t, "kept", item.(*UpsertDedupItem).Name)
+}
+
+func TestUpdateOptions(t *testing.T) {
+	db := NewInMemory()
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(conformanceNs, reindexer.DefaultNamespaceOptions(), ConformanceItem{}))
+	upsert := func(id int) error {
+		return db.Upsert(conformanceNs, &ConformanceItem{ID: id})
+	}
+	require.NoError(t, upsert(1))
+	require.NoError(t, upsert(2))
+
+	rule := reindexer.RateRule{
+		Match:   reindexer.RateMatch{Namespace: conformanceNs, CommandClass: reindexer.CommandClassWrite},
+		RPS:     0.1,
+		Burst:   1,
+		OnLimit: reindexer.RateLimitError,
+	}
+	require.NoError(t, db.UpdateOptions(reindexer.WithRateLimit(rule)))
+	require.NoError(t, upsert(3))
+	_, limited := upsert(4).(reindexer.ErrRateLimited)
+	assert.True(t, limited)
+	require.Len(t, db.Status().RateLimit, 1)
+
+	// Rejected options don't change anything
+	for _, options := range [][]interface{}{
+		{reindexer.WithRateLimit(reindexer.RateRule{RPS: -1})},
+		{reindexer.WithRateLimit(), reindexer.WithDisableObjCache()},
+		{reindexer.WithRateLimit(), reindexer.WithRequestTimeout(time.Second)},
+		{reindexer.WithRateLimit(), reindexer.WithRateLimit()},
+	} {
+		err := db.UpdateOptions(options...)
+		require.Error(t, err, "%v", options)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code(), "%v", options)
+		_, limited = upsert(5).(reindexer.ErrRateLimited)
+		assert.True(t, limited, "%v", options)
+	}
+
+	require.NoError(t, db.UpdateOptions(reindexer.WithRateLimit()))
+	for id := 5; id < 10; id++ {
+		require.NoError(t, upsert(id))
+	}
+	assert.Empty(t, db.Status().RateLimit)
+}
+
+func TestOrderedWrites(t *testing.T) {
+	const ns = "ordered_items"
+	const keys = 8
+	const writes = 50
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks))
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().OrderedWritesLanes(4), UpsertDedupItem{}))
+
+	t.Run("interleaved", func(t *testing.T) {
+		// Sync and async writes of each key are interleaved with the writes of the other keys
+		hooks.InjectLatency(OpModifyItem, time.Millisecond)
+		defer hooks.Reset()
+		var wg sync.WaitGroup
+		errs := make(chan error, keys*writes)
+		for k := 0; k < keys; k++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				var pending sync.WaitGroup
+				for seq := 1; seq <= writes; seq++ {
+					item := &UpsertDedupItem{ID: id, Updated: int64(seq)}
+					if seq%5 == 0 {
+						pending.Wait()
+						errs <- db.Upsert(ns, item)
+						continue
+					}
+					pending.Add(1)
+					if err := db.UpsertAsync(context.Background(), ns, item, func(err error) {
+						errs <- err
+						pending.Done()
+					}); err != nil {
+						errs <- err
+						pending.Done()
+					}
+				}
+				pending.Wait()
+			}(k)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		for k := 0; k < keys; k++ {
+			item, found := db.Query(ns).WhereInt("id", reindexer.EQ, k).Get()
+			require.True(t, found, "item %d", k)
+			assert.Equal(t, int64(writes), item.(*UpsertDedupItem).Updated, "item %d", k)
+		}
+	})
+
+	t.Run("serialized by key", func(t *testing.T) {
+		const latency = 20 * time.Millisecond
+		const writers = 5
+		hooks.InjectLatency(OpModifyItem, latency)
+		defer hooks.Reset()
+		start := time.Now()
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, db.Upsert(ns, &UpsertDedupItem{ID: 1, Name: "concurrent"}))
+			}()
+		}
+		wg.Wait()
+		assert.True(t, time.Since(start) >= writers*latency, "writes of the same PK must wait for each other, elapsed %v", time.Since(start))
+
+		// JSON items have no lane
+		start = time.Now()
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, db.UpsertJSON(context.Background(), ns, []byte(`{"id":1,"name":"json"}`)))
+			}()
+		}
+		wg.Wait()
+		assert.True(t, time.Since(start) < writers*latency, "JSON writes must not be ordered, elapsed %v", time.Since(start))
+	})
+}
+
+func TestPingCtx(t *testing.T) {
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks))
+	defer db.Close()
+
+	require.NoError(t, db.PingCtx(context.Background()))
+	assert.Equal(t, 1, hooks.Calls(OpPing))
+
+	hooks.InjectLatency(OpPing, time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, db.PingCtx(ctx))
+	assert.True(t, time.Since(start) < time.Minute/2)
+}
+
+type TenantOrder struct {
+	ID    int    `reindex:"id,,pk" json:"id"`
+	Title string `reindex:"title" json:"title"`
+}
+
+func TestTenantNamespaces(t *testing.T) {
+	ctx := context.Background()
+	hooks := &Hooks{}
+	db := NewInMemory(WithHooks(hooks))
+	defer db.Close()
+	require.NoError(t, db.RegisterTenantNamespace("orders_%s", reindexer.DefaultNamespaceOptions(), TenantOrder{}))
+
+	t.Run("lazy creation", func(t *testing.T) {
+		tenants, err := db.Tenants(ctx, "orders")
+		require.NoError(t, err)
+		assert.Empty(t, tenants)
+
+		tenant := db.Tenant("Acme")
+		require.NoError(t, tenant.Upsert("orders", &TenantOrder{ID: 1, Title: "first"}))
+		item, found := tenant.Query("orders").WhereInt("id", reindexer.EQ, 1).Get()
+		require.True(t, found)
+		assert.Equal(t, &TenantOrder{ID: 1, Title: "first"}, item)
+		// Namespace is resolved by template too
+		namespace, err := tenant.Namespace("orders_%s")
+		require.NoError(t, err)
+		assert.Equal(t, "orders_acme", namespace)
+		items, err := db.Query("orders_acme").Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Len(t, items, 1)
+		assert.Equal(t, 1, hooks.Calls(OpOpenNamespace))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := db.Tenant("acme").Namespace("invoices")
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeNotFound, err.(reindexer.Error).Code())
+		it := db.Tenant("bad tenant").Query("orders").Exec()
+		require.Error(t, it.Error())
+		assert.Equal(t, reindexer.ErrCodeParams, it.Error().(reindexer.Error).Code())
+		it.Close()
+
+		for _, template := range []string{"orders", "orders_%s_%s", "orders_%d_%s", "%s", "#orders_%s"} {
+			assert.Error(t, db.RegisterTenantNamespace(template, reindexer.DefaultNamespaceOptions(), TenantOrder{}), template)
+		}
+		// Short name 'orders' is already used
+		assert.Error(t, db.RegisterTenantNamespace("%s_orders", reindexer.DefaultNamespaceOptions(), TenantOrder{}))
+	})
+
+	t.Run("concurrent first use", func(t *testing.T) {
+		const goroutines = 50
+		hooks.Reset()
+		// Open is slow, so the concurrent callers find it running
+		hooks.InjectLatency(OpOpenNamespace, 20*time.Millisecond)
+		defer hooks.InjectLatency(OpOpenNamespace, 0)
+		var wg sync.WaitGroup
+		errs := make(chan error, goroutines)
+		for i := 0; i < goroutines; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- db.Tenant("concurrent").Upsert("orders", &TenantOrder{ID: i})
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 1, hooks.Calls(OpOpenNamespace), "namespace must be opened once")
+		count, err := db.Tenant("concurrent").Query("orders").ReqTotal().Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Len(t, count, goroutines)
+	})
+
+	t.Run("enumeration", func(t *testing.T) {
+		for _, ns := range []string{"orders", "orders_", "xorders_other", "customers_acme", "orders_direct"} {
+			require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TenantOrder{}))
+		}
+		tenants, err := db.Tenants(ctx, "orders_%s")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"acme", "concurrent", "direct"}, tenants)
+		_, err = db.Tenants(ctx, "customers")
+		assert.Error(t, err)
+	})
+
+	t.Run("for each tenant", func(t *testing.T) {
+		var lock sync.Mutex
+		visited := map[string]int{}
+		var running, maxRunning int32
+		err := db.ForEachTenant(ctx, "orders", 2, func(ctx context.Context, tenant *reindexer.Tenant) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			items, err := tenant.Query("orders").Exec().FetchAll()
+			lock.Lock()
+			visited[tenant.ID()] = len(items)
+			lock.Unlock()
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"acme": 1, "concurrent": 50, "direct": 0}, visited)
+		assert.True(t, maxRunning <= 2, "parallelism is exceeded: %d", maxRunning)
+
+		failed := errors.New("failed")
+		calls := int32(0)
+		err = db.ForEachTenant(ctx, "orders", 1, func(ctx context.Context, tenant *reindexer.Tenant) error {
+			atomic.AddInt32(&calls, 1)
+			return failed
+		})
+		assert.Equal(t, failed, err)
+		assert.Equal(t, int32(1), calls, "tenants are processed after error")
+	})
+}
+
+func TestShutdownReport(t *testing.T) {
+	hooks := &Hooks{}
+	reports := make(chan reindexer.ShutdownReport, 2)
+	db := NewInMemory(WithHooks(hooks), reindexer.WithShutdownReport(200*time.Millisecond, func(report reindexer.ShutdownReport) {
+		reports <- report
+	}))
+	prepareConformanceNs(t, db)
+
+	next := func(it *reindexer.Iterator, n int) {
+		for i := 0; i < n; i++ {
+			require.True(t, it.Next())
+		}
+	}
+	// Scan, which is completed before Close, isn't reported
+	assert.Len(t, ids(t, db.Query(conformanceNs).Exec()), 20)
+
+	held := db.Query(conformanceNs).Label("export").Exec()
+	require.NoError(t, held.Error())
+	next(held, 5)
+	closing := db.Query(conformanceNs).ExecCtx(reindexer.CtxWithActivityLabel(context.Background(), "sync"))
+	require.NoError(t, closing.Error())
+	next(closing, 3)
+
+	hooks.InjectLatency(OpModifyItem, 50*time.Millisecond)
+	upserts := hooks.Calls(OpModifyItem)
+	upserted := make(chan error, 1)
+	go func() {
+		upserted <- db.Upsert(conformanceNs, &ConformanceItem{ID: 100})
+	}()
+	for hooks.Calls(OpModifyItem) == upserts {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		next(closing, 2)
+		closing.Close()
+	}()
+
+	start := time.Now()
+	require.NoError(t, db.Close())
+	assert.True(t, time.Since(start) >= 200*time.Millisecond, "Close didn't wait for held iterator")
+	require.NoError(t, <-upserted)
+
+	var report reindexer.ShutdownReport
+	select {
+	case report = <-reports:
+	default:
+		t.Fatal("report isn't called by Close")
+	}
+	assert.True(t, report.DrainTime >= 200*time.Millisecond)
+	require.Len(t, report.Operations, 3)
+	exp := []reindexer.ShutdownOperation{
+		{Kind: bindings.ShutdownOpSelect, Namespace: conformanceNs, Label: "export", Consumed: 5, Drained: false},
+		{Kind: bindings.ShutdownOpSelect, Namespace: conformanceNs, Label: "sync", Consumed: 5, Drained: true},
+		{Kind: bindings.ShutdownOpModify, Namespace: conformanceNs, Drained: true},
+	}
+	for i, op := range report.Operations {
+		assert.True(t, op.ID > 0)
+		assert.True(t, op.Elapsed > 0)
+		assert.NoError(t, op.Err)
+		op.ID, op.Elapsed = 0, 0
+		assert.Equal(t, exp[i], op)
+	}
+	assert.True(t, report.Operations[0].Elapsed >= 200*time.Millisecond, "held iterator is open until report")
+
+	held.Close()
+	require.NoError(t, db.Close())
+	assert.Len(t, reports, 0, "report is called by repeated Close")
+}
+
+type SchemaItem struct {
+	ID    int      `reindex:"id,,pk" json:"id"`
+	Name  string   `reindex:"name" json:"name"`
+	Price int      `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+type SchemaRenamedItem struct {
+	ID    int      `reindex:"id,,pk" json:"id"`
+	Title string   `reindex:"name" json:"title"`
+	Price int      `json:"price"`
+	Tags  []string `json:"tags"`
+}
+
+type SchemaRemovedItem struct {
+	ID   int      `reindex:"id,,pk" json:"id"`
+	Name string   `reindex:"name" json:"name"`
+	Tags []string `json:"tags"`
+}
+
+type SchemaAddedItem struct {
+	Tags  []string `json:"tags"`
+	Price int32    `json:"price"`
+	Name  string   `reindex:"name" json:"name"`
+	ID    int      `reindex:"id,,pk" json:"id"`
+	Extra string   `json:"extra"`
+}
+
+func TestSchemaEvolution(t *testing.T) {
+	const ns = "schema_items"
+	db := NewInMemory()
+	defer db.Close()
+	open := func(opts *reindexer.NamespaceOptions, s interface{}) error {
+		err := db.OpenNamespace(ns, opts, s)
+		if err == nil {
+			require.NoError(t, db.CloseNamespace(ns))
+		}
+		return err
+	}
+	changes := func(t *testing.T, err error) []reindexer.SchemaChange {
+		require.Error(t, err)
+		var evolution reindexer.ErrSchemaEvolution
+		require.True(t, errors.As(err, &evolution), "unexpected error %v", err)
+		assert.Equal(t, ns, evolution.Namespace)
+		assert.Equal(t, reindexer.ErrCodeParams, evolution.Code())
+		return evolution.Changes
+	}
+	check := func() *reindexer.NamespaceOptions {
+		return reindexer.DefaultNamespaceOptions().CheckSchemaEvolution()
+	}
+
+	require.NoError(t, db.OpenNamespace(ns, check(), SchemaItem{}))
+	data, err := db.GetMeta(ns, "rq:schema_fingerprint")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"price":"int"`)
+	require.NoError(t, db.CloseNamespace(ns))
+
+	t.Run("rename", func(t *testing.T) {
+		res := changes(t, open(check(), SchemaRenamedItem{}))
+		require.Len(t, res, 2)
+		assert.Equal(t, reindexer.SchemaChange{Kind: reindexer.SchemaFieldRenamed, Name: "name", NewName: "title", Old: "string", New: "string"}, res[0])
+		assert.Equal(t, reindexer.SchemaIndexChanged, res[1].Kind)
+		assert.Equal(t, "name", res[1].Name)
+		assert.Contains(t, res[0].String(), "renamed to 'title'")
+	})
+
+	t.Run("removal", func(t *testing.T) {
+		res := changes(t, open(check(), SchemaRemovedItem{}))
+		assert.Equal(t, []reindexer.SchemaChange{{Kind: reindexer.SchemaFieldRemoved, Name: "price", Old: "int"}}, res)
+	})
+
+	t.Run("addition", func(t *testing.T) {
+		// Order of fields and size of ints don't matter
+		require.NoError(t, open(check(), SchemaAddedItem{}))
+		require.NoError(t, open(check(), SchemaAddedItem{}))
+		// Added field is stored, so its removal is detected too
+		res := changes(t, open(check(), SchemaItem{}))
+		assert.Equal(t, []reindexer.SchemaChange{{Kind: reindexer.SchemaFieldRemoved, Name: "extra", Old: "string"}}, res)
+	})
+
+	t.Run("without check", func(t *testing.T) {
+		require.NoError(t, open(reindexer.DefaultNamespaceOptions(), SchemaRemovedItem{}))
+	})
+
+	t.Run("acknowledge", func(t *testing.T) {
+		// Index of renamed field is updated
+		require.NoError(t, open(reindexer.DefaultNamespaceOptions().AcknowledgeSchemaChange(), SchemaRenamedItem{}))
+		require.NoError(t, open(check(), SchemaRenamedItem{}))
+		res := changes(t, open(check(), SchemaItem{}))
+		assert.Equal(t, reindexer.SchemaFieldRenamed, res[0].Kind)
+		assert.Equal(t, "title", res[0].Name)
+	})
+}
+
+// warningsLogger collects warnings of the client
+type warningsLogger struct {
+	lock     sync.Mutex
+	warnings []string
+}
+
+func (l *warningsLogger) Printf(level int, format string, msg ...interface{}) {
+	if level == bindings.WARNING {
+		l.lock.Lock()
+		l.warnings = append(l.warnings, fmt.Sprintf(format, msg...))
+		l.lock.Unlock()
+	}
+}
+
+func (l *warningsLogger) list() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]string(nil), l.warnings...)
+}
+
+func TestRegistrations(t *testing.T) {
+	const ns = "registered_items"
+	db := NewInMemory()
+	defer db.Close()
+	log := &warningsLogger{}
+	db.SetLogger(log)
+	defer db.SetLogger(nil)
+
+	_, file, line, _ := runtime.Caller(0)
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().DropOnIndexesConflict(), SchemaItem{}))
+	regs := db.Registrations()
+	require.Len(t, regs, 1)
+	reg := regs[0]
+	assert.Equal(t, ns, reg.Namespace)
+	assert.Equal(t, "reindexertest.SchemaItem", reg.Type)
+	assert.Equal(t, "github.com/restream/reindexer/reindexertest", reg.Package)
+	assert.Equal(t, file, reg.File)
+	assert.Equal(t, line+1, reg.Line)
+	assert.Equal(t, "github.com/restream/reindexer/reindexertest.TestRegistrations", reg.Function)
+	assert.Equal(t, []string{"DropOnIndexesConflict"}, reg.Options)
+	assert.Equal(t, []string{"add 2 indexes"}, reg.Actions)
+	assert.True(t, reg.Opened)
+	assert.NoError(t, reg.Err)
+	assert.Equal(t, regs, db.Status().Registrations)
+	assert.Empty(t, log.list())
+
+	t.Run("struct mismatch", func(t *testing.T) {
+		err := db.RegisterNamespace(ns, reindexer.DefaultNamespaceOptions(), SchemaRenamedItem{})
+		require.Error(t, err)
+		warnings := log.list()
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0], fmt.Sprintf("with type reindexertest.SchemaItem at %s:%d and with type reindexertest.SchemaRenamedItem at %s:", file, line+1, file))
+		// Registration isn't replaced
+		reg := db.Registrations()[0]
+		assert.Equal(t, "reindexertest.SchemaItem", reg.Type)
+		assert.Equal(t, 1, reg.Conflicts)
+	})
+
+	t.Run("another struct after close", func(t *testing.T) {
+		require.NoError(t, db.CloseNamespace(ns))
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().DropOnIndexesConflict(), SchemaRenamedItem{}))
+		assert.Len(t, log.list(), 2)
+		reg := db.Registrations()[0]
+		assert.Equal(t, "reindexertest.SchemaRenamedItem", reg.Type)
+		assert.Equal(t, 2, reg.Conflicts)
+		require.Len(t, reg.Actions, 2)
+		assert.Contains(t, reg.Actions[0], "drop namespace on conflict of indexes")
+		assert.Equal(t, "add 2 indexes", reg.Actions[1])
+		require.NoError(t, db.CloseNamespace(ns))
+	})
+
+	t.Run("the same location", func(t *testing.T) {
+		for _, s := range []interface{}{SchemaItem{}, SchemaRenamedItem{}} {
+			require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().DropOnIndexesConflict(), s))
+			require.NoError(t, db.CloseNamespace(ns))
+		}
+		// Only the first open is warned: it's made from another location than the previous one
+		assert.Len(t, log.list(), 3)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db := NewInMemory(reindexer.WithDisableRegistrationAudit())
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), SchemaItem{}))
+		assert.Empty(t, db.Registrations())
+		assert.Empty(t, db.Status().Registrations)
+	})
+}
+
+type WarmItem struct {
+	ID   int    `reindex:"id,,pk" json:"id"`
+	Name string `reindex:"name" json:"name"`
+}
+
+// expiringCtx is done after the limit of checks of its error
+type expiringCtx struct {
+	context.Context
+	checks int64
+	limit  int64
+}
+
+func (ctx *expiringCtx) Err() error {
+	if atomic.AddInt64(&ctx.checks, 1) > ctx.limit {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestWarmCache(t *testing.T) {
+	const ns = "warm_items"
+	const count = 100
+	newDB := func(t *testing.T, opts *reindexer.NamespaceOptions) *reindexer.Reindexer {
+		db := NewInMemory()
+		require.NoError(t, db.OpenNamespace(ns, opts, WarmItem{}))
+		for i := 0; i < count; i++ {
+			require.NoError(t, db.Upsert(ns, &WarmItem{ID: i, Name: fmt.Sprintf("item %d", i)}))
+		}
+		return db
+	}
+	readAll := func(t *testing.T, db *reindexer.Reindexer) {
+		items, err := db.Query(ns).Exec().AllowUnsafe(true).FetchAll()
+		require.NoError(t, err)
+		require.Len(t, items, count)
+	}
+
+	t.Run("reads after warm-up are cache hits", func(t *testing.T) {
+		db := newDB(t, reindexer.DefaultNamespaceOptions())
+		defer db.Close()
+		report, err := db.WarmCache(context.Background(), ns, nil, reindexer.WarmOptions{Parallelism: 4})
+		require.NoError(t, err)
+		assert.Equal(t, reindexer.WarmReport{Loaded: count}, report)
+		before := db.Status().ObjCache
+		assert.Equal(t, int64(count), before.Entries)
+
+		readAll(t, db)
+		after := db.Status().ObjCache
+		assert.Equal(t, int64(count), after.Hits-before.Hits)
+		assert.Equal(t, before.Misses, after.Misses)
+
+		// Cached items are skipped, updated item is loaded again
+		require.NoError(t, db.Upsert(ns, &WarmItem{ID: 1, Name: "updated"}))
+		report, err = db.WarmCache(context.Background(), ns, db.Query(ns).WhereInt("id", reindexer.LT, 10), reindexer.WarmOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, reindexer.WarmReport{Loaded: 1, Skipped: 9}, report)
+	})
+
+	t.Run("max items", func(t *testing.T) {
+		db := newDB(t, reindexer.DefaultNamespaceOptions())
+		defer db.Close()
+		report, err := db.WarmCache(context.Background(), ns, db.Query(ns).Sort("id", false), reindexer.WarmOptions{MaxItems: 10, Parallelism: 2})
+		require.NoError(t, err)
+		assert.Equal(t, reindexer.WarmReport{Loaded: 10, Truncated: true}, report)
+		assert.Equal(t, int64(10), db.Status().ObjCache.Entries)
+	})
+
+	t.Run("context bound", func(t *testing.T) {
+		db := newDB(t, reindexer.DefaultNamespaceOptions())
+		defer db.Close()
+		report, err := db.WarmCache(&expiringCtx{Context: context.Background(), limit: 20}, ns, nil, reindexer.WarmOptions{})
+		assert.Equal(t, context.Canceled, err)
+		assert.True(t, report.Truncated)
+		assert.True(t, report.Loaded > 0 && report.Loaded < count, "loaded %d", report.Loaded)
+		assert.Equal(t, int64(report.Loaded), db.Status().ObjCache.Entries)
+
+		// Warm-up is continued by the next call
+		next, err := db.WarmCache(context.Background(), ns, nil, reindexer.WarmOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, reindexer.WarmReport{Loaded: count - report.Loaded, Skipped: report.Loaded}, next)
+	})
+
+	t.Run("disabled object cache", func(t *testing.T) {
+		db := newDB(t, reindexer.DefaultNamespaceOptions().DisableObjCache())
+		defer db.Close()
+		_, err := db.WarmCache(context.Background(), ns, nil, reindexer.WarmOptions{})
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+		_, err = db.WarmCache(context.Background(), "other", db.Query(ns), reindexer.WarmOptions{})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,485 @@
+package reindexertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/restream/reindexer/cjson"
+)
+
+// Bit layout of cjson tags. Must be in sync with cjson/ctag.go
+const (
+	ctagTypeBits  = 3
+	ctagNameBits  = 12
+	carrCountBits = 24
+)
+
+// field of document
+type field struct {
+	name  string
+	value interface{}
+}
+
+// object is document or nested object. Fields are kept in the insertion order, like reindexer does
+// Values are one of: nil, bool, int64, float64, string, []interface{}, object
+type object []field
+
+func (o object) get(name string) (interface{}, bool) {
+	for i := range o {
+		if o[i].name == name {
+			return o[i].value, true
+		}
+	}
+	return nil, false
+}
+
+func (o object) set(name string, value interface{}) object {
+	for i := range o {
+		if o[i].name == name {
+			o[i].value = value
+			return o
+		}
+	}
+	return append(o, field{name: name, value: value})
+}
+
+func (o object) drop(name string) object {
+	for i := range o {
+		if o[i].name == name {
+			return append(o[:i:i], o[i+1:]...)
+		}
+	}
+	return o
+}
+
+// setPath sets value by json path like 'nested.field'. Missing objects on the path are created
+func (o object) setPath(path []string, value interface{}) object {
+	if len(path) == 1 {
+		return o.set(path[0], value)
+	}
+	sub, _ := o.get(path[0])
+	subObj, _ := sub.(object)
+	return o.set(path[0], subObj.setPath(path[1:], value))
+}
+
+// dropPath removes value by json path
+func (o object) dropPath(path []string) object {
+	if len(path) == 1 {
+		return o.drop(path[0])
+	}
+	if sub, ok := o.get(path[0]); ok {
+		if subObj, ok := sub.(object); ok {
+			return o.set(path[0], subObj.dropPath(path[1:]))
+		}
+	}
+	return o
+}
+
+// values returns all the scalar values by json path. Arrays on the path are flattened
+func (o object) values(path []string) []interface{} {
+	v, ok := o.get(path[0])
+	if !ok {
+		return nil
+	}
+	return appendValues(nil, v, path[1:])
+}
+
+func appendValues(dst []interface{}, v interface{}, path []string) []interface{} {
+	switch vv := v.(type) {
+	case []interface{}:
+		for _, e := range vv {
+			dst = appendValues(dst, e, path)
+		}
+	case object:
+		if len(path) != 0 {
+			if sub, ok := vv.get(path[0]); ok {
+				dst = appendValues(dst, sub, path[1:])
+			}
+		}
+	default:
+		if len(path) == 0 {
+			dst = append(dst, v)
+		}
+	}
+	return dst
+}
+
+// copyValue makes deep copy of document value
+func copyValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case object:
+		cpy := make(object, len(vv))
+		for i := range vv {
+			cpy[i] = field{name: vv[i].name, value: copyValue(vv[i].value)}
+		}
+		return cpy
+	case []interface{}:
+		cpy := make([]interface{}, len(vv))
+		for i := range vv {
+			cpy[i] = copyValue(vv[i])
+		}
+		return cpy
+	}
+	return v
+}
+
+// filterPaths returns copy of document with the given json paths only
+func (o object) filterPaths(paths [][]string) object {
+	res := object{}
+	for _, path := range paths {
+		v, ok := o.get(path[0])
+		for i := 1; ok && i < len(path); i++ {
+			var sub object
+			if sub, ok = v.(object); ok {
+				v, ok = sub.get(path[i])
+			}
+		}
+		if ok {
+			res = res.setPath(path, copyValue(v))
+		}
+	}
+	return res
+}
+
+// tagsMatcher maps field names to cjson tags
+type tagsMatcher struct {
+	tags  []string
+	names map[string]int
+}
+
+func (tm *tagsMatcher) name2tag(name string) (tag int, added bool) {
+	if tag, ok := tm.names[name]; ok {
+		return tag + 1, false
+	}
+	if tm.names == nil {
+		tm.names = make(map[string]int)
+	}
+	tm.names[name] = len(tm.tags)
+	tm.tags = append(tm.tags, name)
+	return len(tm.tags), true
+}
+
+// merge appends tags, which were added by client. Client tags must be compatible with already known ones
+func (tm *tagsMatcher) merge(tags []string) (ok bool, added bool) {
+	for i := 0; i < len(tags) && i < len(tm.tags); i++ {
+		if tags[i] != tm.tags[i] {
+			return false, false
+		}
+	}
+	for i := len(tm.tags); i < len(tags); i++ {
+		tm.name2tag(tags[i])
+		added = true
+	}
+	return true, added
+}
+
+func mkctag(tagType, tagName int) uint64 {
+	return uint64(tagType | (tagName << ctagTypeBits))
+}
+
+// splitCJSON splits item data, packed by cjson.Encoder, to cjson body and tags, added by client
+func splitCJSON(data []byte) (body []byte, tags []string, err error) {
+	if len(data) == 0 || data[0] != cjson.TAG_END {
+		return data, nil, nil
+	}
+	if len(data) < 5 {
+		return nil, nil, fmt.Errorf("cjson data is too short")
+	}
+	ser := cjson.NewSerializer(data[1:5])
+	offset := int(ser.GetUInt32())
+	if offset < 5 || offset > len(data) {
+		return nil, nil, fmt.Errorf("invalid cjson tags offset %d", offset)
+	}
+	ser = cjson.NewSerializer(data[offset:])
+	tags = make([]string, int(ser.GetVarUInt()))
+	for i := range tags {
+		tags[i] = ser.GetVString()
+	}
+	return data[5:offset], tags, nil
+}
+
+// decodeCJSON decodes cjson body to document
+func decodeCJSON(body []byte, tags []string) (doc object, err error) {
+	defer func() {
+		if ret := recover(); ret != nil {
+			err = fmt.Errorf("can't decode cjson: %v", ret)
+		}
+	}()
+
+	ser := cjson.NewSerializer(body)
+	tag := ser.GetVarUInt()
+	if int(tag)&((1<<ctagTypeBits)-1) != cjson.TAG_OBJECT {
+		return nil, fmt.Errorf("cjson document must be an object")
+	}
+	return readCJSONObject(&ser, tags), nil
+}
+
+func readCJSONObject(ser *cjson.Serializer, tags []string) object {
+	doc := object{}
+	for {
+		tag := int(ser.GetVarUInt())
+		tagType := tag & ((1 << ctagTypeBits) - 1)
+		if tagType == cjson.TAG_END {
+			return doc
+		}
+		tagName := (tag >> ctagTypeBits) & ((1 << ctagNameBits) - 1)
+		if tag>>(ctagTypeBits+ctagNameBits) != 0 {
+			panic(fmt.Errorf("payload field references are not supported"))
+		}
+		if tagName == 0 || tagName > len(tags) {
+			panic(fmt.Errorf("unknown tag %d", tagName))
+		}
+		doc = append(doc, field{name: tags[tagName-1], value: readCJSONValue(ser, tagType, tags)})
+	}
+}
+
+func readCJSONValue(ser *cjson.Serializer, tagType int, tags []string) interface{} {
+	switch tagType {
+	case cjson.TAG_VARINT:
+		return ser.GetVarInt()
+	case cjson.TAG_DOUBLE:
+		return ser.GetDouble()
+	case cjson.TAG_STRING:
+		return ser.GetVString()
+	case cjson.TAG_BOOL:
+		return ser.GetVarUInt() != 0
+	case cjson.TAG_NULL:
+		return nil
+	case cjson.TAG_OBJECT:
+		return readCJSONObject(ser, tags)
+	case cjson.TAG_ARRAY:
+		atag := int(ser.GetUInt32())
+		count := atag & ((1 << carrCountBits) - 1)
+		subtag := atag >> carrCountBits
+		arr := make([]interface{}, count)
+		for i := range arr {
+			if subtag == cjson.TAG_OBJECT {
+				arr[i] = readCJSONValue(ser, int(ser.GetVarUInt())&((1<<ctagTypeBits)-1), tags)
+			} else {
+				arr[i] = readCJSONValue(ser, subtag, tags)
+			}
+		}
+		return arr
+	}
+	panic(fmt.Errorf("unexpected tag type %d", tagType))
+}
+
+// encodeCJSON encodes document to cjson. New field names are added to tags matcher
+func encodeCJSON(ser *cjson.Serializer, doc object, tm *tagsMatcher) (tmUpdated bool) {
+	ser.PutVarUInt(mkctag(cjson.TAG_OBJECT, 0))
+	return writeCJSONObject(ser, doc, tm)
+}
+
+func writeCJSONObject(ser *cjson.Serializer, doc object, tm *tagsMatcher) (tmUpdated bool) {
+	for _, f := range doc {
+		tag, added := tm.name2tag(f.name)
+		tmUpdated = writeCJSONValue(ser, f.value, tag, tm) || added || tmUpdated
+	}
+	ser.PutVarUInt(mkctag(cjson.TAG_END, 0))
+	return tmUpdated
+}
+
+func cjsonType(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return cjson.TAG_NULL
+	case bool:
+		return cjson.TAG_BOOL
+	case int64:
+		return cjson.TAG_VARINT
+	case float64:
+		return cjson.TAG_DOUBLE
+	case string:
+		return cjson.TAG_STRING
+	case []interface{}:
+		return cjson.TAG_ARRAY
+	}
+	return cjson.TAG_OBJECT
+}
+
+func writeCJSONValue(ser *cjson.Serializer, v interface{}, tag int, tm *tagsMatcher) (tmUpdated bool) {
+	ser.PutVarUInt(mkctag(cjsonType(v), tag))
+	switch vv := v.(type) {
+	case bool:
+		if vv {
+			ser.PutVarUInt(1)
+		} else {
+			ser.PutVarUInt(0)
+		}
+	case int64:
+		ser.PutVarInt(vv)
+	case float64:
+		ser.PutDouble(vv)
+	case string:
+		ser.PutVString(vv)
+	case object:
+		return writeCJSONObject(ser, vv, tm)
+	case []interface{}:
+		subtag := cjson.TAG_OBJECT
+		for i, e := range vv {
+			t := cjsonType(e)
+			if t == cjson.TAG_OBJECT || t == cjson.TAG_ARRAY || t == cjson.TAG_NULL || (i != 0 && t != subtag) {
+				subtag = cjson.TAG_OBJECT
+				break
+			}
+			subtag = t
+		}
+		ser.PutUInt32(uint32(len(vv) | (subtag << carrCountBits)))
+		for _, e := range vv {
+			if subtag == cjson.TAG_OBJECT {
+				tmUpdated = writeCJSONValue(ser, e, 0, tm) || tmUpdated
+				continue
+			}
+			switch ee := e.(type) {
+			case bool:
+				if ee {
+					ser.PutVarUInt(1)
+				} else {
+					ser.PutVarUInt(0)
+				}
+			case int64:
+				ser.PutVarInt(ee)
+			case float64:
+				ser.PutDouble(ee)
+			case string:
+				ser.PutVString(ee)
+			}
+		}
+	}
+	return tmUpdated
+}
+
+// decodeJSON decodes json document preserving fields order
+func decodeJSON(data []byte) (object, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := readJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := v.(object)
+	if !ok {
+		return nil, fmt.Errorf("json document must be an object")
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after json document")
+	}
+	return doc, nil
+}
+
+// decodeJSONValue decodes any json value
+func decodeJSONValue(data string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	return readJSONValue(dec)
+}
+
+func readJSONValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			doc := object{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := readJSONValue(dec)
+				if err != nil {
+					return nil, withJSONPath(key.(string), err)
+				}
+				doc = doc.set(key.(string), v)
+			}
+			_, err = dec.Token()
+			return doc, err
+		case '[':
+			arr := []interface{}{}
+			for dec.More() {
+				v, err := readJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, v)
+			}
+			_, err = dec.Token()
+			return arr, err
+		}
+		return nil, fmt.Errorf("unexpected json delimiter %v", t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return tok, nil
+}
+
+// jsonPathError - error of decoding json value of field at path
+type jsonPathError struct {
+	path string
+	err  error
+}
+
+func (e *jsonPathError) Error() string {
+	return fmt.Sprintf("field '%s': %v", e.path, e.err)
+}
+
+// withJSONPath prepends key to path of decoding error
+func withJSONPath(key string, err error) error {
+	if perr, ok := err.(*jsonPathError); ok {
+		return &jsonPathError{path: key + "." + perr.path, err: perr.err}
+	}
+	return &jsonPathError{path: key, err: err}
+}
+
+// encodeJSON writes document as json
+func encodeJSON(buf *bytes.Buffer, v interface{}) {
+	switch vv := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(vv))
+	case int64:
+		buf.WriteString(strconv.FormatInt(vv, 10))
+	case float64:
+		if math.IsInf(vv, 0) || math.IsNaN(vv) {
+			buf.WriteString("0")
+		} else {
+			buf.WriteString(strconv.FormatFloat(vv, 'g', -1, 64))
+		}
+	case string:
+		b, _ := json.Marshal(vv)
+		buf.Write(b)
+	case object:
+		buf.WriteByte('{')
+		for i, f := range vv {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			b, _ := json.Marshal(f.name)
+			buf.Write(b)
+			buf.WriteByte(':')
+			encodeJSON(buf, f.value)
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range vv {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			encodeJSON(buf, e)
+		}
+		buf.WriteByte(']')
+	}
+}
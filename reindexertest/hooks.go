@@ -0,0 +1,155 @@
+package reindexertest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// Op - binding operation, which can be intercepted by Hooks
+type Op string
+
+const (
+	OpOpenNamespace     Op = "OpenNamespace"
+	OpCloseNamespace    Op = "CloseNamespace"
+	OpDropNamespace     Op = "DropNamespace"
+	OpTruncateNamespace Op = "TruncateNamespace"
+	OpRenameNamespace   Op = "RenameNamespace"
+	OpAddIndex          Op = "AddIndex"
+	OpUpdateIndex       Op = "UpdateIndex"
+	OpDropIndex         Op = "DropIndex"
+	OpModifyItem        Op = "ModifyItem"
+	OpSelect            Op = "Select"
+	OpDeleteQuery       Op = "DeleteQuery"
+	OpUpdateQuery       Op = "UpdateQuery"
+	OpBeginTx           Op = "BeginTx"
+	OpModifyItemTx      Op = "ModifyItemTx"
+	OpDeleteQueryTx     Op = "DeleteQueryTx"
+	OpUpdateQueryTx     Op = "UpdateQueryTx"
+	OpCommitTx          Op = "CommitTx"
+	OpRollbackTx        Op = "RollbackTx"
+	OpPutMeta           Op = "PutMeta"
+	OpGetMeta           Op = "GetMeta"
+	OpEnumMeta          Op = "EnumMeta"
+	OpPing              Op = "Ping"
+)
+
+// Hooks allows to inject errors and latencies into operations of in-memory binding
+// Zero value is ready to use. Hooks may be changed concurrently with running operations
+type Hooks struct {
+	lock      sync.Mutex
+	errors    map[Op]error
+	skips     map[Op]int
+	latencies map[Op]time.Duration
+	calls     map[Op]int
+}
+
+type optionHooks struct {
+	hooks *Hooks
+}
+
+// WithHooks attaches hooks to in-memory binding
+func WithHooks(hooks *Hooks) interface{} {
+	return optionHooks{hooks: hooks}
+}
+
+// InjectError makes each call of op to return err. Pass nil err to stop injection
+// To get reindexer error with code, use bindings.NewError
+func (h *Hooks) InjectError(op Op, err error) {
+	h.InjectErrorAfter(op, 0, err)
+}
+
+// InjectErrorAfter makes calls of op to return err after the next calls ones succeed, e.g. to fail the second commit
+func (h *Hooks) InjectErrorAfter(op Op, calls int, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.errors == nil {
+		h.errors = make(map[Op]error)
+		h.skips = make(map[Op]int)
+	}
+	if err == nil {
+		delete(h.errors, op)
+		delete(h.skips, op)
+	} else {
+		h.errors[op] = err
+		h.skips[op] = calls
+	}
+}
+
+// InjectLatency delays each call of op on d. Delay is interrupted by the operation context
+func (h *Hooks) InjectLatency(op Op, d time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.latencies == nil {
+		h.latencies = make(map[Op]time.Duration)
+	}
+	if d <= 0 {
+		delete(h.latencies, op)
+	} else {
+		h.latencies[op] = d
+	}
+}
+
+// Calls returns count of op calls
+func (h *Hooks) Calls(op Op) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.calls[op]
+}
+
+// Reset removes all the injected errors and latencies and resets calls counters
+func (h *Hooks) Reset() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.errors = nil
+	h.skips = nil
+	h.latencies = nil
+	h.calls = nil
+}
+
+func ctxError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return bindings.NewError("rq: Context timeout", bindings.ErrTimeout)
+	default:
+		return bindings.NewError("rq: Context was canceled", bindings.ErrCanceled)
+	}
+}
+
+// before is called before each operation
+func (h *Hooks) before(ctx context.Context, op Op) error {
+	var err error
+	var latency time.Duration
+	if h != nil {
+		h.lock.Lock()
+		if h.calls == nil {
+			h.calls = make(map[Op]int)
+		}
+		h.calls[op]++
+		err, latency = h.errors[op], h.latencies[op]
+		if err != nil && h.skips[op] > 0 {
+			h.skips[op]--
+			err = nil
+		}
+		h.lock.Unlock()
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+	if ctxErr := ctxError(ctx); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
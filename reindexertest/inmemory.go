@@ -0,0 +1,573 @@
+// Package reindexertest provides in-memory implementation of reindexer binding for unit tests of application code.
+// It doesn't require cgo or running server.
+//
+// Supported subset: namespaces, indexes (PK index is required to modify items), upsert/insert/update/delete of items,
+// queries with Where conditions (EQ, SET, ALLSET, LT, LE, GT, GE, RANGE, ANY, EMPTY, LIKE), brackets, OR/NOT, Sort,
+// Limit/Offset, ReqTotal, Select filter, update queries with Set/Drop/SetObject, delete queries, transactions, meta and
+// queries to #namespaces.
+// Joins, merges, aggregations, fulltext, SQL and select functions return error with ErrCodeParams.
+//
+//	db := reindexertest.NewInMemory()
+//	db.OpenNamespace("items", reindexer.DefaultNamespaceOptions(), Item{})
+package reindexertest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+)
+
+func init() {
+	bindings.RegisterBinding("inmemory", &inMemoryBinding{})
+}
+
+// NewInMemory creates reindexer client on top of empty in-memory DB
+// Options are the same as for reindexer.NewReindex, also WithHooks is accepted
+func NewInMemory(options ...interface{}) *reindexer.Reindexer {
+	return reindexer.NewReindex("inmemory://", options...)
+}
+
+type buffer []byte
+
+func (buf buffer) GetBuf() []byte {
+	return buf
+}
+
+func (buf buffer) Free() {
+}
+
+type txOp struct {
+	mode     int
+	doc      object
+	precepts []string
+	query    *query
+	update   bool
+}
+
+type transaction struct {
+	namespace string
+	ops       []txOp
+}
+
+type inMemoryBinding struct {
+	lock       sync.Mutex
+	namespaces map[string]*namespace
+	txs        map[uint64]*transaction
+	txCounter  uint64
+	hooks      *Hooks
+	// #namespaces, which items are descriptions of opened namespaces
+	described *namespace
+}
+
+func (binding *inMemoryBinding) Init(u []url.URL, options ...interface{}) error {
+	binding.namespaces = make(map[string]*namespace)
+	binding.txs = make(map[uint64]*transaction)
+	for _, option := range options {
+		switch v := option.(type) {
+		case optionHooks:
+			binding.hooks = v.hooks
+		default:
+			// other bindings options are accepted to keep client code unchanged
+		}
+	}
+	return nil
+}
+
+func (binding *inMemoryBinding) Clone() bindings.RawBinding {
+	return &inMemoryBinding{}
+}
+
+// getNs returns opened namespace. Must be called under lock
+func (binding *inMemoryBinding) getNs(name string) (*namespace, error) {
+	name = strings.ToLower(name)
+	ns, ok := binding.namespaces[name]
+	if !ok || !ns.opened {
+		return nil, errNsNotFound(name)
+	}
+	return ns, nil
+}
+
+func (binding *inMemoryBinding) OpenNamespace(ctx context.Context, namespace string, enableStorage, dropOnFileFormatError bool) error {
+	if err := binding.hooks.before(ctx, OpOpenNamespace); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	name := strings.ToLower(namespace)
+	ns, ok := binding.namespaces[name]
+	if !ok {
+		ns = newNamespace(name)
+		binding.namespaces[name] = ns
+	}
+	ns.opened = true
+	return nil
+}
+
+func (binding *inMemoryBinding) CloseNamespace(ctx context.Context, namespace string) error {
+	if err := binding.hooks.before(ctx, OpCloseNamespace); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return err
+	}
+	ns.opened = false
+	return nil
+}
+
+func (binding *inMemoryBinding) DropNamespace(ctx context.Context, namespace string) error {
+	if err := binding.hooks.before(ctx, OpDropNamespace); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	if _, err := binding.getNs(namespace); err != nil {
+		return err
+	}
+	delete(binding.namespaces, strings.ToLower(namespace))
+	return nil
+}
+
+func (binding *inMemoryBinding) TruncateNamespace(ctx context.Context, namespace string) error {
+	if err := binding.hooks.before(ctx, OpTruncateNamespace); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return err
+	}
+	ns.items = make(map[string]*item)
+	return nil
+}
+
+func (binding *inMemoryBinding) RenameNamespace(ctx context.Context, srcNs string, dstNs string) error {
+	if err := binding.hooks.before(ctx, OpRenameNamespace); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(srcNs)
+	if err != nil {
+		return err
+	}
+	dst := strings.ToLower(dstNs)
+	if len(dst) == 0 || strings.HasPrefix(dst, "#") {
+		return bindings.NewError(fmt.Sprintf("rq: Invalid namespace name '%s'", dstNs), bindings.ErrParams)
+	}
+	delete(binding.namespaces, ns.name)
+	ns.name = dst
+	binding.namespaces[dst] = ns
+	return nil
+}
+
+func (binding *inMemoryBinding) EnableStorage(ctx context.Context, namespace string) error {
+	return nil
+}
+
+func (binding *inMemoryBinding) AddIndex(ctx context.Context, namespace string, indexDef bindings.IndexDef) error {
+	if err := binding.hooks.before(ctx, OpAddIndex); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return err
+	}
+	return ns.addIndex(indexDef)
+}
+
+func (binding *inMemoryBinding) UpdateIndex(ctx context.Context, namespace string, indexDef bindings.IndexDef) error {
+	if err := binding.hooks.before(ctx, OpUpdateIndex); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return err
+	}
+	return ns.updateIndex(indexDef)
+}
+
+func (binding *inMemoryBinding) DropIndex(ctx context.Context, namespace, index string) error {
+	if err := binding.hooks.before(ctx, OpDropIndex); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return err
+	}
+	return ns.dropIndex(index)
+}
+
+func (binding *inMemoryBinding) BeginTx(ctx context.Context, namespace string) (bindings.TxCtx, error) {
+	if err := binding.hooks.before(ctx, OpBeginTx); err != nil {
+		return bindings.TxCtx{}, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return bindings.TxCtx{}, err
+	}
+	binding.txCounter++
+	binding.txs[binding.txCounter] = &transaction{namespace: ns.name}
+	return bindings.TxCtx{Id: binding.txCounter, UserCtx: ctx}, nil
+}
+
+// getTx returns transaction and its namespace. Must be called under lock
+func (binding *inMemoryBinding) getTx(txCtx *bindings.TxCtx) (*transaction, *namespace, error) {
+	tx, ok := binding.txs[txCtx.Id]
+	if !ok {
+		return nil, nil, bindings.NewError(fmt.Sprintf("rq: Transaction %d is not found", txCtx.Id), bindings.ErrLogic)
+	}
+	ns, err := binding.getNs(tx.namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, ns, nil
+}
+
+func (binding *inMemoryBinding) CommitTx(txCtx *bindings.TxCtx) (bindings.RawBuffer, error) {
+	if err := binding.hooks.before(txCtx.UserCtx, OpCommitTx); err != nil {
+		return nil, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	tx, ns, err := binding.getTx(txCtx)
+	if err != nil {
+		return nil, err
+	}
+	delete(binding.txs, txCtx.Id)
+
+	snapshot := ns.snapshot()
+	modified := make([]*item, 0, len(tx.ops))
+	for _, op := range tx.ops {
+		var items []*item
+		if op.query != nil {
+			if items, _, err = ns.selectItems(op.query); err == nil {
+				if op.update {
+					items, err = ns.updateItems(items, op.query.updates)
+				} else {
+					for _, it := range items {
+						delete(ns.items, it.pk)
+					}
+				}
+			}
+		} else {
+			var it *item
+			if it, err = ns.modify(op.doc, op.mode, op.precepts); it != nil {
+				items = []*item{it}
+			}
+		}
+		if err != nil {
+			ns.restore(snapshot)
+			return nil, err
+		}
+		modified = append(modified, items...)
+	}
+	return buffer(ns.encodeResults(&results{items: modified})), nil
+}
+
+func (binding *inMemoryBinding) RollbackTx(txCtx *bindings.TxCtx) error {
+	if err := binding.hooks.before(txCtx.UserCtx, OpRollbackTx); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	delete(binding.txs, txCtx.Id)
+	return nil
+}
+
+func (binding *inMemoryBinding) ModifyItemTx(txCtx *bindings.TxCtx, format int, data []byte, mode int, precepts []string, stateToken int) error {
+	if err := binding.hooks.before(txCtx.UserCtx, OpModifyItemTx); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	tx, ns, err := binding.getTx(txCtx)
+	if err != nil {
+		return err
+	}
+	doc, err := ns.decodeItem(format, data, stateToken)
+	if err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, txOp{mode: mode, doc: doc, precepts: append([]string(nil), precepts...)})
+	return nil
+}
+
+func (binding *inMemoryBinding) ModifyItemTxAsync(txCtx *bindings.TxCtx, format int, data []byte, mode int, precepts []string, stateToken int, cmpl bindings.RawCompletion) {
+	err := binding.ModifyItemTx(txCtx, format, data, mode, precepts, stateToken)
+	cmpl(nil, err)
+}
+
+func (binding *inMemoryBinding) queryTx(txCtx *bindings.TxCtx, op Op, rawQuery []byte, update bool) error {
+	if err := binding.hooks.before(txCtx.UserCtx, op); err != nil {
+		return err
+	}
+	q, err := parseQuery(rawQuery)
+	if err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	tx, ns, err := binding.getTx(txCtx)
+	if err != nil {
+		return err
+	}
+	if q.namespace != ns.name {
+		return bindings.NewError(fmt.Sprintf("rq: Query to namespace '%s' in transaction of namespace '%s'", q.namespace, ns.name), bindings.ErrParams)
+	}
+	tx.ops = append(tx.ops, txOp{query: q, update: update})
+	return nil
+}
+
+func (binding *inMemoryBinding) DeleteQueryTx(txCtx *bindings.TxCtx, rawQuery []byte) error {
+	return binding.queryTx(txCtx, OpDeleteQueryTx, rawQuery, false)
+}
+
+func (binding *inMemoryBinding) UpdateQueryTx(txCtx *bindings.TxCtx, rawQuery []byte) error {
+	return binding.queryTx(txCtx, OpUpdateQueryTx, rawQuery, true)
+}
+
+func (binding *inMemoryBinding) PutMeta(ctx context.Context, namespace, key, data string) error {
+	if err := binding.hooks.before(ctx, OpPutMeta); err != nil {
+		return err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return err
+	}
+	ns.meta[key] = data
+	return nil
+}
+
+func (binding *inMemoryBinding) GetMeta(ctx context.Context, namespace, key string) (bindings.RawBuffer, error) {
+	if err := binding.hooks.before(ctx, OpGetMeta); err != nil {
+		return nil, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return buffer(ns.meta[key]), nil
+}
+
+func (binding *inMemoryBinding) EnumMeta(ctx context.Context, namespace string) ([]string, error) {
+	if err := binding.hooks.before(ctx, OpEnumMeta); err != nil {
+		return nil, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(ns.meta))
+	for key := range ns.meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (binding *inMemoryBinding) ModifyItem(ctx context.Context, nsHash int, namespace string, format int, data []byte, mode int, precepts []string, stateToken int) (bindings.RawBuffer, error) {
+	if err := binding.hooks.before(ctx, OpModifyItem); err != nil {
+		return nil, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(namespace)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := ns.decodeItem(format, data, stateToken)
+	if err != nil {
+		return nil, err
+	}
+	it, err := ns.modify(doc, mode, precepts)
+	if err != nil {
+		return nil, err
+	}
+	r := &results{withData: true}
+	if it != nil {
+		r.items = []*item{it}
+	}
+	return buffer(ns.encodeResults(r)), nil
+}
+
+func (binding *inMemoryBinding) Select(ctx context.Context, query string, asJson bool, ptVersions []int32, fetchCount int) (bindings.RawBuffer, error) {
+	if err := binding.hooks.before(ctx, OpSelect); err != nil {
+		return nil, err
+	}
+	return nil, errUnsupported("SQL query")
+}
+
+func (binding *inMemoryBinding) SelectQuery(ctx context.Context, rawQuery []byte, asJson bool, ptVersions []int32, fetchCount int) (bindings.RawBuffer, error) {
+	if err := binding.hooks.before(ctx, OpSelect); err != nil {
+		return nil, err
+	}
+	q, err := parseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.selectNs(q.namespace)
+	if err != nil {
+		return nil, err
+	}
+	items, total, err := ns.selectItems(q)
+	if err != nil {
+		return nil, err
+	}
+	return buffer(ns.encodeResults(&results{
+		asJson:   asJson,
+		withData: !bindings.IDsOnly(ctx),
+		reqTotal: q.reqTotal,
+		total:    total,
+		items:    items,
+		filter:   q.selectFilter,
+	})), nil
+}
+
+func (binding *inMemoryBinding) DeleteQuery(ctx context.Context, nsHash int, rawQuery []byte) (bindings.RawBuffer, error) {
+	if err := binding.hooks.before(ctx, OpDeleteQuery); err != nil {
+		return nil, err
+	}
+	q, err := parseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(q.namespace)
+	if err != nil {
+		return nil, err
+	}
+	items, _, err := ns.selectItems(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range items {
+		delete(ns.items, it.pk)
+	}
+	return buffer(ns.encodeResults(&results{items: items})), nil
+}
+
+func (binding *inMemoryBinding) UpdateQuery(ctx context.Context, nsHash int, rawQuery []byte) (bindings.RawBuffer, error) {
+	if err := binding.hooks.before(ctx, OpUpdateQuery); err != nil {
+		return nil, err
+	}
+	q, err := parseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	binding.lock.Lock()
+	defer binding.lock.Unlock()
+	ns, err := binding.getNs(q.namespace)
+	if err != nil {
+		return nil, err
+	}
+	items, _, err := ns.selectItems(q)
+	if err != nil {
+		return nil, err
+	}
+	if items, err = ns.updateItems(items, q.updates); err != nil {
+		return nil, err
+	}
+	return buffer(ns.encodeResults(&results{withData: true, items: items})), nil
+}
+
+func (binding *inMemoryBinding) Commit(ctx context.Context, namespace string) error {
+	return nil
+}
+
+func (binding *inMemoryBinding) EnableLogger(logger bindings.Logger) {
+}
+
+func (binding *inMemoryBinding) DisableLogger() {
+}
+
+func (binding *inMemoryBinding) ReopenLogFiles() error {
+	return nil
+}
+
+func (binding *inMemoryBinding) Ping(ctx context.Context) error {
+	return binding.hooks.before(ctx, OpPing)
+}
+
+func (binding *inMemoryBinding) Finalize() error {
+	return nil
+}
+
+func (binding *inMemoryBinding) Status(ctx context.Context) bindings.Status {
+	return bindings.Status{}
+}
+
+func (binding *inMemoryBinding) ServerVersion() string {
+	return bindings.ReindexerVersion
+}
+
+// selectNs returns namespace to select from. Items of #namespaces are updated by descriptions of opened namespaces. Must be called under lock
+func (binding *inMemoryBinding) selectNs(name string) (*namespace, error) {
+	if strings.ToLower(name) != reindexer.NamespacesNamespaceName {
+		return binding.getNs(name)
+	}
+	if binding.described == nil {
+		binding.described = newNamespace(reindexer.NamespacesNamespaceName)
+		binding.described.indexes = []bindings.IndexDef{{Name: "name", JSONPaths: []string{"name"}, IndexType: "hash", FieldType: "string", IsPK: true}}
+	}
+	described := binding.described
+	described.items = make(map[string]*item)
+	names := make([]string, 0, len(binding.namespaces))
+	for name, ns := range binding.namespaces {
+		if ns.opened && !reindexer.IsSystemNamespace(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := json.Marshal(reindexer.NamespaceDescription{Name: name, Indexes: describeIndexes(binding.namespaces[name].indexes)})
+		if err != nil {
+			return nil, err
+		}
+		doc, err := decodeJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		pk, err := described.pkValue(doc)
+		if err != nil {
+			return nil, err
+		}
+		described.put(pk, nil, doc)
+	}
+	return described, nil
+}
+
+func describeIndexes(indexes []bindings.IndexDef) []reindexer.IndexDescription {
+	descs := make([]reindexer.IndexDescription, 0, len(indexes))
+	for _, index := range indexes {
+		descs = append(descs, reindexer.IndexDescription{IndexDef: reindexer.IndexDef(index)})
+	}
+	return descs
+}
@@ -0,0 +1,432 @@
+package reindexertest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+type item struct {
+	id      int
+	version int
+	pk      string
+	doc     object
+}
+
+type namespace struct {
+	name       string
+	indexes    []bindings.IndexDef
+	items      map[string]*item
+	meta       map[string]string
+	tm         tagsMatcher
+	stateToken int32
+	tmVersion  int32
+	nextID     int
+	version    int
+	serials    map[string]int64
+	opened     bool
+}
+
+// nsSnapshot is state of namespace items, which can be restored on transaction failure
+type nsSnapshot struct {
+	items   map[string]*item
+	nextID  int
+	version int
+	serials map[string]int64
+}
+
+func newNamespace(name string) *namespace {
+	return &namespace{
+		name:       name,
+		items:      make(map[string]*item),
+		meta:       make(map[string]string),
+		serials:    make(map[string]int64),
+		stateToken: rand.Int31(),
+	}
+}
+
+func errNsNotFound(name string) error {
+	return bindings.NewError(fmt.Sprintf("rq: Namespace '%s' does not exist", name), bindings.ErrNotFound)
+}
+
+func (ns *namespace) snapshot() nsSnapshot {
+	s := nsSnapshot{
+		items:   make(map[string]*item, len(ns.items)),
+		nextID:  ns.nextID,
+		version: ns.version,
+		serials: make(map[string]int64, len(ns.serials)),
+	}
+	for k, v := range ns.items {
+		s.items[k] = v
+	}
+	for k, v := range ns.serials {
+		s.serials[k] = v
+	}
+	return s
+}
+
+func (ns *namespace) restore(s nsSnapshot) {
+	ns.items, ns.nextID, ns.version, ns.serials = s.items, s.nextID, s.version, s.serials
+}
+
+func (ns *namespace) addIndex(indexDef bindings.IndexDef) error {
+	for _, idx := range ns.indexes {
+		if !strings.EqualFold(idx.Name, indexDef.Name) {
+			continue
+		}
+		if idx.IndexType != indexDef.IndexType || idx.FieldType != indexDef.FieldType || idx.IsPK != indexDef.IsPK ||
+			idx.IsArray != indexDef.IsArray || strings.Join(idx.JSONPaths, ",") != strings.Join(indexDef.JSONPaths, ",") {
+			return bindings.NewError(fmt.Sprintf("rq: Index '%s.%s' already exists with different settings", ns.name, idx.Name), bindings.ErrConflict)
+		}
+		return nil
+	}
+	if indexDef.IsPK && ns.pkIndex() != nil {
+		return bindings.NewError(fmt.Sprintf("rq: Namespace '%s' already has PK index", ns.name), bindings.ErrConflict)
+	}
+	ns.indexes = append(ns.indexes, indexDef)
+	return nil
+}
+
+func (ns *namespace) updateIndex(indexDef bindings.IndexDef) error {
+	for i, idx := range ns.indexes {
+		if strings.EqualFold(idx.Name, indexDef.Name) {
+			ns.indexes[i] = indexDef
+			return nil
+		}
+	}
+	return bindings.NewError(fmt.Sprintf("rq: Index '%s' not found in namespace '%s'", indexDef.Name, ns.name), bindings.ErrParams)
+}
+
+func (ns *namespace) dropIndex(name string) error {
+	for i, idx := range ns.indexes {
+		if strings.EqualFold(idx.Name, name) {
+			ns.indexes = append(ns.indexes[:i], ns.indexes[i+1:]...)
+			return nil
+		}
+	}
+	return bindings.NewError(fmt.Sprintf("rq: Index '%s' not found in namespace '%s'", name, ns.name), bindings.ErrParams)
+}
+
+func (ns *namespace) pkIndex() *bindings.IndexDef {
+	for i := range ns.indexes {
+		if ns.indexes[i].IsPK {
+			return &ns.indexes[i]
+		}
+	}
+	return nil
+}
+
+// pkValue makes string key of item by PK index
+func (ns *namespace) pkValue(doc object) (string, error) {
+	pk := ns.pkIndex()
+	if pk == nil {
+		return "", bindings.NewError(fmt.Sprintf("rq: Namespace '%s' doesn't have PK index", ns.name), bindings.ErrParams)
+	}
+	values, _ := ns.fieldValues(doc, pk.Name)
+	if len(values) == 1 {
+		if tuple, ok := values[0].([]interface{}); ok {
+			values = tuple
+		}
+	}
+	var sb strings.Builder
+	for _, v := range values {
+		switch vv := v.(type) {
+		case float64:
+			if vv == float64(int64(vv)) {
+				v = int64(vv)
+			}
+		case bool:
+			if vv {
+				v = int64(1)
+			} else {
+				v = int64(0)
+			}
+		}
+		fmt.Fprintf(&sb, "%T:%v;", v, v)
+	}
+	return sb.String(), nil
+}
+
+// decodeItem decodes item data, passed to binding by client
+func (ns *namespace) decodeItem(format int, data []byte, stateToken int) (object, error) {
+	switch format {
+	case bindings.FormatJson:
+		doc, err := decodeJSON(data)
+		if err != nil {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Can't parse json item: %v", err), bindings.ErrParseJson)
+		}
+		return doc, nil
+	case bindings.FormatCJson:
+		if int32(stateToken) != ns.stateToken {
+			return nil, bindings.NewError(fmt.Sprintf("rq: State token of namespace '%s' is invalidated", ns.name), bindings.ErrStateInvalidated)
+		}
+		body, tags, err := splitCJSON(data)
+		if err != nil {
+			return nil, bindings.NewError("rq: "+err.Error(), bindings.ErrParseBin)
+		}
+		if tags != nil {
+			ok, added := ns.tm.merge(tags)
+			if !ok {
+				return nil, bindings.NewError(fmt.Sprintf("rq: Tags of namespace '%s' are mismatched", ns.name), bindings.ErrStateInvalidated)
+			}
+			if added {
+				ns.tmVersion++
+			}
+		}
+		doc, err := decodeCJSON(body, ns.tm.tags)
+		if err != nil {
+			return nil, bindings.NewError("rq: "+err.Error(), bindings.ErrParseBin)
+		}
+		return doc, nil
+	}
+	return nil, bindings.NewError(fmt.Sprintf("rq: Unknown item format %d", format), bindings.ErrParams)
+}
+
+// applyPrecepts applies precepts like 'id=serial()' or 'updated=now(msec)' to document
+func (ns *namespace) applyPrecepts(doc object, precepts []string) (object, error) {
+	for _, precept := range precepts {
+		parts := strings.SplitN(precept, "=", 2)
+		if len(parts) != 2 {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Invalid precept '%s'", precept), bindings.ErrParams)
+		}
+		fieldName, fn := strings.TrimSpace(parts[0]), strings.ToLower(strings.Replace(parts[1], " ", "", -1))
+		var v int64
+		switch fn {
+		case "serial()":
+			ns.serials[fieldName]++
+			v = ns.serials[fieldName]
+		case "now()", "now(sec)":
+			v = time.Now().Unix()
+		case "now(msec)":
+			v = time.Now().UnixNano() / int64(time.Millisecond)
+		case "now(usec)":
+			v = time.Now().UnixNano() / int64(time.Microsecond)
+		case "now(nsec)":
+			v = time.Now().UnixNano()
+		default:
+			return nil, errUnsupported(fmt.Sprintf("Precept '%s'", precept))
+		}
+		doc = doc.setPath(ns.jsonPath(fieldName), v)
+	}
+	return doc, nil
+}
+
+// modify applies modification of single item. Returns nil, if item was not modified
+func (ns *namespace) modify(doc object, mode int, precepts []string) (*item, error) {
+	var err error
+	if mode != bindings.ModeDelete {
+		if doc, err = ns.applyPrecepts(doc, precepts); err != nil {
+			return nil, err
+		}
+	}
+	pk, err := ns.pkValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	old := ns.items[pk]
+
+	switch mode {
+	case bindings.ModeDelete:
+		if old != nil {
+			delete(ns.items, pk)
+		}
+		return old, nil
+	case bindings.ModeInsert:
+		if old != nil {
+			return nil, nil
+		}
+	case bindings.ModeUpdate:
+		if old == nil {
+			return nil, nil
+		}
+	case bindings.ModeUpsert:
+	default:
+		return nil, bindings.NewError(fmt.Sprintf("rq: Unknown modify mode %d", mode), bindings.ErrParams)
+	}
+	return ns.put(pk, old, doc), nil
+}
+
+func (ns *namespace) put(pk string, old *item, doc object) *item {
+	ns.version++
+	it := &item{pk: pk, doc: doc, version: ns.version}
+	if old != nil {
+		it.id = old.id
+	} else {
+		it.id = ns.nextID
+		ns.nextID++
+	}
+	ns.items[pk] = it
+	return it
+}
+
+// updatedDoc applies update fields of query to copy of item document
+func (ns *namespace) updatedDoc(it *item, updates []updateEntry) (object, error) {
+	doc := copyValue(it.doc).(object)
+	for _, u := range updates {
+		path := ns.jsonPath(u.field)
+		if u.drop {
+			doc = doc.dropPath(path)
+			continue
+		}
+		var v interface{}
+		if u.array || len(u.values) != 1 {
+			v = append([]interface{}{}, u.values...)
+		} else {
+			v = u.values[0]
+		}
+		doc = doc.setPath(path, v)
+	}
+	pk, err := ns.pkValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	if pk != it.pk {
+		return nil, bindings.NewError(fmt.Sprintf("rq: Update of PK field is not allowed in namespace '%s'", ns.name), bindings.ErrParams)
+	}
+	return doc, nil
+}
+
+// updateItems applies update query to items. Items are not changed on error
+func (ns *namespace) updateItems(items []*item, updates []updateEntry) ([]*item, error) {
+	docs := make([]object, len(items))
+	for i, it := range items {
+		var err error
+		if docs[i], err = ns.updatedDoc(it, updates); err != nil {
+			return nil, err
+		}
+	}
+	updated := make([]*item, len(items))
+	for i, it := range items {
+		updated[i] = ns.put(it.pk, it, docs[i])
+	}
+	return updated, nil
+}
+
+// selectItems returns items matching query, sorted and sliced by limit/offset, and total count of matched items
+func (ns *namespace) selectItems(q *query) (items []*item, total int, err error) {
+	for _, it := range ns.items {
+		ok, err := ns.match(it.doc, q.where)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ok {
+			items = append(items, it)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].id < items[j].id })
+	ns.sortItems(items, q.sort)
+
+	total = len(items)
+	if q.offset >= len(items) {
+		items = items[:0]
+	} else {
+		items = items[q.offset:]
+	}
+	if q.limit >= 0 && q.limit < len(items) {
+		items = items[:q.limit]
+	}
+	return items, total, nil
+}
+
+// writePayloadType writes state of namespace in the format of cjson.State.ReadPayloadType
+func (ns *namespace) writePayloadType(ser *cjson.Serializer) {
+	ser.PutVarUInt(uint64(ns.stateToken))
+	ser.PutVarUInt(uint64(ns.tmVersion))
+	ser.PutVarCUInt(len(ns.tm.tags))
+	for _, tag := range ns.tm.tags {
+		ser.PutVString(tag)
+	}
+	// payload type without fields: items are always returned as cjson
+	ser.PutVarUInt(0)
+	ser.PutVarUInt(0)
+}
+
+// results serializes items in the format of reindexer query results
+type results struct {
+	asJson   bool
+	withData bool
+	reqTotal bool
+	total    int
+	items    []*item
+	filter   []string
+}
+
+func (ns *namespace) encodeResults(r *results) []byte {
+	// encode items data first: it may update tags matcher
+	var data [][]byte
+	if r.withData {
+		data = make([][]byte, len(r.items))
+		for i, it := range r.items {
+			doc := it.doc
+			if len(r.filter) != 0 && !(len(r.filter) == 1 && r.filter[0] == "*") {
+				doc = ns.filterFields(doc, r.filter)
+			}
+			if r.asJson {
+				var buf bytes.Buffer
+				encodeJSON(&buf, doc)
+				data[i] = buf.Bytes()
+			} else {
+				ser := cjson.NewSerializer(nil)
+				if encodeCJSON(&ser, doc, &ns.tm) {
+					ns.tmVersion++
+				}
+				data[i] = ser.Bytes()
+			}
+		}
+	}
+
+	flags := bindings.ResultsWithItemID
+	switch {
+	case !r.withData:
+		flags |= bindings.ResultsPure
+	case r.asJson:
+		flags |= bindings.ResultsJson
+	default:
+		flags |= bindings.ResultsCJson
+	}
+	if r.withData && !r.asJson {
+		flags |= bindings.ResultsWithPayloadTypes
+	}
+
+	ser := cjson.NewSerializer(nil)
+	ser.PutVarCUInt(flags)
+	if r.reqTotal {
+		ser.PutVarCUInt(r.total)
+	} else {
+		ser.PutVarUInt(0)
+	}
+	ser.PutVarCUInt(len(r.items))
+	ser.PutVarCUInt(len(r.items))
+	if (flags & bindings.ResultsWithPayloadTypes) != 0 {
+		ser.PutVarUInt(1)
+		ser.PutVarUInt(0)
+		ser.PutVString(ns.name)
+		ns.writePayloadType(&ser)
+	}
+	ser.PutVarUInt(bindings.QueryResultEnd)
+
+	for i, it := range r.items {
+		ser.PutVarCUInt(it.id)
+		ser.PutVarCUInt(it.version)
+		if r.withData {
+			ser.PutUInt32(uint32(len(data[i])))
+			ser.Write(data[i])
+		}
+	}
+	return ser.Bytes()
+}
+
+// filterFields applies select filter to document
+func (ns *namespace) filterFields(doc object, fields []string) object {
+	paths := make([][]string, 0, len(fields))
+	for _, f := range fields {
+		paths = append(paths, ns.jsonPath(f))
+	}
+	return doc.filterPaths(paths)
+}
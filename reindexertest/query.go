@@ -0,0 +1,489 @@
+package reindexertest
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+type condition struct {
+	op      int
+	index   string
+	cond    int
+	keys    []interface{}
+	bracket []condition
+}
+
+type sortEntry struct {
+	index  string
+	desc   bool
+	forced []interface{}
+}
+
+type updateEntry struct {
+	field  string
+	values []interface{}
+	array  bool
+	drop   bool
+}
+
+// query is the supported subset of reindexer query
+type query struct {
+	namespace    string
+	where        []condition
+	sort         []sortEntry
+	limit        int
+	offset       int
+	reqTotal     bool
+	selectFilter []string
+	updates      []updateEntry
+}
+
+func errUnsupported(what string) error {
+	return bindings.NewError(fmt.Sprintf("rq: %s is not supported by in-memory binding", what), bindings.ErrParams)
+}
+
+// parseQuery parses query serialized by reindexer.Query
+func parseQuery(rawQuery []byte) (q *query, err error) {
+	defer func() {
+		if ret := recover(); ret != nil {
+			err = bindings.NewError(fmt.Sprintf("rq: Can't parse query: %v", ret), bindings.ErrParseBin)
+		}
+	}()
+
+	ser := cjson.NewSerializer(rawQuery)
+	q = &query{namespace: strings.ToLower(ser.GetVString()), limit: -1}
+	brackets := []*[]condition{&q.where}
+
+	for !ser.Eof() {
+		switch tag := int(ser.GetVarUInt()); tag {
+		case bindings.QueryCondition:
+			c := condition{index: ser.GetVString(), op: int(ser.GetVarUInt()), cond: int(ser.GetVarUInt())}
+			c.keys = readValues(&ser)
+			cur := brackets[len(brackets)-1]
+			*cur = append(*cur, c)
+		case bindings.QueryOpenBracket:
+			cur := brackets[len(brackets)-1]
+			*cur = append(*cur, condition{op: int(ser.GetVarUInt()), bracket: []condition{}})
+			brackets = append(brackets, &(*cur)[len(*cur)-1].bracket)
+		case bindings.QueryCloseBracket:
+			if len(brackets) == 1 {
+				return nil, bindings.NewError("rq: Close bracket before open it", bindings.ErrParams)
+			}
+			brackets = brackets[:len(brackets)-1]
+		case bindings.QuerySortIndex:
+			s := sortEntry{index: ser.GetVString(), desc: ser.GetVarUInt() != 0}
+			s.forced = readValues(&ser)
+			q.sort = append(q.sort, s)
+		case bindings.QueryLimit:
+			q.limit = int(ser.GetVarUInt())
+		case bindings.QueryOffset:
+			q.offset = int(ser.GetVarUInt())
+		case bindings.QueryReqTotal:
+			q.reqTotal = ser.GetVarUInt() != bindings.ModeNoCalc
+		case bindings.QueryDebugLevel:
+			ser.GetVarUInt()
+		case bindings.QueryExplain:
+		case bindings.QuerySelectFilter:
+			q.selectFilter = append(q.selectFilter, ser.GetVString())
+		case bindings.QueryUpdateField:
+			u := updateEntry{field: ser.GetVString()}
+			count := int(ser.GetVarUInt())
+			for i := 0; i < count; i++ {
+				if ser.GetVarUInt() != 0 {
+					return nil, errUnsupported("Update by expression")
+				}
+				u.values = append(u.values, readValue(&ser))
+			}
+			u.array = count != 1
+			q.updates = append(q.updates, u)
+		case bindings.QueryUpdateObject:
+			u := updateEntry{field: ser.GetVString()}
+			count := int(ser.GetVarUInt())
+			u.array = ser.GetVarUInt() != 0
+			for i := 0; i < count; i++ {
+				ser.GetVarUInt()
+				js, _ := readValue(&ser).(string)
+				v, err := decodeJSONValue(js)
+				if err != nil {
+					return nil, bindings.NewError(fmt.Sprintf("rq: Can't parse object for field '%s': %v", u.field, err), bindings.ErrParseJson)
+				}
+				u.values = append(u.values, v)
+			}
+			q.updates = append(q.updates, u)
+		case bindings.QueryDropField:
+			q.updates = append(q.updates, updateEntry{field: ser.GetVString(), drop: true})
+		case bindings.QueryEnd:
+			if !ser.Eof() {
+				return nil, errUnsupported("Join and merge")
+			}
+		case bindings.QueryJoinCondition, bindings.QueryJoinOn:
+			return nil, errUnsupported("Join")
+		case bindings.QueryAggregation, bindings.QueryAggregationLimit, bindings.QueryAggregationOffset, bindings.QueryAggregationSort:
+			return nil, errUnsupported("Aggregation")
+		case bindings.QuerySelectFunction:
+			return nil, errUnsupported("Select function")
+		case bindings.QueryEqualPosition:
+			return nil, errUnsupported("Equal position")
+		case bindings.QueryWithRank:
+			return nil, errUnsupported("Fulltext rank")
+		default:
+			return nil, bindings.NewError(fmt.Sprintf("rq: Unknown query tag %d", tag), bindings.ErrParseBin)
+		}
+	}
+	if len(brackets) != 1 {
+		return nil, bindings.NewError("rq: Bracket is not closed", bindings.ErrParams)
+	}
+	return q, nil
+}
+
+func readValues(ser *cjson.Serializer) []interface{} {
+	count := int(ser.GetVarUInt())
+	values := make([]interface{}, count)
+	for i := range values {
+		values[i] = readValue(ser)
+	}
+	return values
+}
+
+func readValue(ser *cjson.Serializer) interface{} {
+	switch t := int(ser.GetVarUInt()); t {
+	case bindings.ValueInt, bindings.ValueInt64:
+		return ser.GetVarInt()
+	case bindings.ValueDouble:
+		return ser.GetDouble()
+	case bindings.ValueString:
+		return ser.GetVString()
+	case bindings.ValueBool:
+		return ser.GetVarUInt() != 0
+	case bindings.ValueNull:
+		return nil
+	case bindings.ValueTuple:
+		return readValues(ser)
+	default:
+		panic(fmt.Errorf("unexpected value type %d", t))
+	}
+}
+
+// index resolves query index name to json paths and collate mode
+func (ns *namespace) index(name string) (paths [][]string, caseInsensitive bool) {
+	for _, idx := range ns.indexes {
+		if !strings.EqualFold(idx.Name, name) {
+			continue
+		}
+		jsonPaths := idx.JSONPaths
+		if len(jsonPaths) == 0 {
+			jsonPaths = []string{idx.Name}
+		}
+		if idx.FieldType == "composite" || strings.Contains(idx.Name, "+") {
+			// subindexes of composite may be passed by names of other indexes
+			jsonPaths = jsonPaths[:0:0]
+			for _, sub := range strings.Split(idx.Name, "+") {
+				subPaths, _ := ns.index(sub)
+				jsonPaths = append(jsonPaths, strings.Join(subPaths[0], "."))
+			}
+		}
+		for _, p := range jsonPaths {
+			paths = append(paths, strings.Split(p, "."))
+		}
+		return paths, idx.CollateMode == "ascii" || idx.CollateMode == "utf8"
+	}
+	return [][]string{strings.Split(name, ".")}, false
+}
+
+// jsonPath resolves index or field name to json path
+func (ns *namespace) jsonPath(name string) []string {
+	paths, _ := ns.index(name)
+	if len(paths) != 1 {
+		return strings.Split(name, ".")
+	}
+	return paths[0]
+}
+
+// fieldValues returns values of document by index
+func (ns *namespace) fieldValues(doc object, index string) (values []interface{}, caseInsensitive bool) {
+	paths, caseInsensitive := ns.index(index)
+	if len(paths) == 1 {
+		return doc.values(paths[0]), caseInsensitive
+	}
+	// composite index: single tuple value
+	tuple := make([]interface{}, 0, len(paths))
+	for _, p := range paths {
+		v := doc.values(p)
+		if len(v) == 0 {
+			tuple = append(tuple, nil)
+		} else {
+			tuple = append(tuple, v[0])
+		}
+	}
+	return []interface{}{tuple}, caseInsensitive
+}
+
+// match evaluates where conditions. OR binds to the previous condition, as in reindexer
+func (ns *namespace) match(doc object, conds []condition) (bool, error) {
+	result, cur := true, true
+	for i, c := range conds {
+		var v bool
+		var err error
+		if c.bracket != nil {
+			v, err = ns.match(doc, c.bracket)
+		} else {
+			v, err = ns.matchCondition(doc, &c)
+		}
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case c.op == bindings.OpOr && i != 0:
+			cur = cur || v
+		case c.op == bindings.OpNot:
+			result, cur = result && cur, !v
+		default:
+			result, cur = result && cur, v
+		}
+	}
+	return result && cur, nil
+}
+
+func (ns *namespace) matchCondition(doc object, c *condition) (bool, error) {
+	values, ci := ns.fieldValues(doc, c.index)
+	nonNull := values[:0:0]
+	for _, v := range values {
+		if v != nil {
+			nonNull = append(nonNull, v)
+		}
+	}
+
+	switch c.cond {
+	case bindings.ANY:
+		return len(nonNull) != 0, nil
+	case bindings.EMPTY:
+		return len(nonNull) == 0, nil
+	case bindings.EQ, bindings.SET:
+		for _, v := range nonNull {
+			for _, k := range c.keys {
+				if r, ok := compareValues(v, k, ci); ok && r == 0 {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case bindings.ALLSET:
+		for _, k := range c.keys {
+			found := false
+			for _, v := range nonNull {
+				if r, ok := compareValues(v, k, ci); ok && r == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false, nil
+			}
+		}
+		return len(c.keys) != 0, nil
+	case bindings.LT, bindings.LE, bindings.GT, bindings.GE, bindings.RANGE:
+		need := 1
+		if c.cond == bindings.RANGE {
+			need = 2
+		}
+		if len(c.keys) != need {
+			return false, bindings.NewError(fmt.Sprintf("rq: Condition on '%s' expects %d arguments, but got %d", c.index, need, len(c.keys)), bindings.ErrParams)
+		}
+		for _, v := range nonNull {
+			r, ok := compareValues(v, c.keys[0], ci)
+			if !ok {
+				continue
+			}
+			switch c.cond {
+			case bindings.LT:
+				ok = r < 0
+			case bindings.LE:
+				ok = r <= 0
+			case bindings.GT:
+				ok = r > 0
+			case bindings.GE:
+				ok = r >= 0
+			case bindings.RANGE:
+				r2, ok2 := compareValues(v, c.keys[1], ci)
+				ok = r >= 0 && ok2 && r2 <= 0
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	case bindings.LIKE:
+		if len(c.keys) != 1 {
+			return false, bindings.NewError(fmt.Sprintf("rq: Condition LIKE on '%s' expects 1 argument", c.index), bindings.ErrParams)
+		}
+		pattern, _ := c.keys[0].(string)
+		re, err := likeRegexp(pattern, ci)
+		if err != nil {
+			return false, err
+		}
+		for _, v := range nonNull {
+			if s, ok := v.(string); ok && re.MatchString(s) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, errUnsupported(fmt.Sprintf("Condition %d", c.cond))
+}
+
+func likeRegexp(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	if caseInsensitive {
+		sb.WriteString("(?i)")
+	}
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			sb.WriteString(".*")
+		case '_':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch vv := v.(type) {
+	case int64:
+		return float64(vv), true
+	case float64:
+		return vv, true
+	case bool:
+		if vv {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(vv, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compareValues compares document value with query key. Numeric and string values are converted, like reindexer does for typed indexes
+func compareValues(v, k interface{}, caseInsensitive bool) (int, bool) {
+	switch {
+	case v == nil && k == nil:
+		return 0, true
+	case v == nil:
+		return -1, true
+	case k == nil:
+		return 1, true
+	}
+
+	switch vv := v.(type) {
+	case string:
+		ks, ok := k.(string)
+		if !ok {
+			if kf, ok := toFloat(k); ok {
+				if vf, ok := toFloat(vv); ok {
+					return compareFloats(vf, kf), true
+				}
+			}
+			ks = fmt.Sprint(k)
+		}
+		if caseInsensitive {
+			vv, ks = strings.ToLower(vv), strings.ToLower(ks)
+		}
+		return strings.Compare(vv, ks), true
+	case []interface{}:
+		kt, ok := k.([]interface{})
+		if !ok || len(kt) != len(vv) {
+			return 0, false
+		}
+		for i := range vv {
+			if r, ok := compareValues(vv[i], kt[i], caseInsensitive); !ok || r != 0 {
+				return r, ok
+			}
+		}
+		return 0, true
+	case int64:
+		if ki, ok := k.(int64); ok {
+			switch {
+			case vv < ki:
+				return -1, true
+			case vv > ki:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	vf, ok1 := toFloat(v)
+	kf, ok2 := toFloat(k)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	return compareFloats(vf, kf), true
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// sortItems sorts selected items by query sort entries. Forced sort values are placed on the top
+func (ns *namespace) sortItems(items []*item, entries []sortEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	type sortKey struct {
+		forced int
+		value  interface{}
+	}
+	keys := make(map[*item][]sortKey, len(items))
+	cis := make([]bool, len(entries))
+	for _, it := range items {
+		k := make([]sortKey, len(entries))
+		for i, e := range entries {
+			values, ci := ns.fieldValues(it.doc, e.index)
+			cis[i] = ci
+			k[i].forced = len(e.forced)
+			if len(values) != 0 {
+				k[i].value = values[0]
+			}
+			for fi, f := range e.forced {
+				if r, ok := compareValues(k[i].value, f, ci); ok && r == 0 {
+					k[i].forced = fi
+					break
+				}
+			}
+		}
+		keys[it] = k
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		ki, kj := keys[items[i]], keys[items[j]]
+		for e := range entries {
+			if ki[e].forced != kj[e].forced {
+				return ki[e].forced < kj[e].forced
+			}
+			if r, _ := compareValues(ki[e].value, kj[e].value, cis[e]); r != 0 {
+				if entries[e].desc {
+					return r > 0
+				}
+				return r < 0
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,213 @@
+// +build cgo
+
+// Package serverharness starts embedded reindexer server for integration tests of application code.
+// It links builtinserver binding (cgo), so it's kept apart from reindexertest, which doesn't require cgo
+package serverharness
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restream/reindexer"
+	_ "github.com/restream/reindexer/bindings/builtinserver"
+	"github.com/restream/reindexer/bindings/builtinserver/config"
+	_ "github.com/restream/reindexer/bindings/cproto"
+)
+
+const serverDbName = "reindexertest"
+
+type fixture struct {
+	namespace string
+	item      interface{}
+	path      string
+}
+
+type serverOptions struct {
+	cproto   bool
+	dsn      *string
+	fixtures []fixture
+	startup  time.Duration
+}
+
+// ServerOpt - option of StartServer
+type ServerOpt func(opts *serverOptions)
+
+// WithCproto makes StartServer to return client, connected to the embedded server via cproto binding
+func WithCproto() ServerOpt {
+	return func(opts *serverOptions) {
+		opts.cproto = true
+	}
+}
+
+// WithDSN stores cproto DSN of the embedded server into dsn
+func WithDSN(dsn *string) ServerOpt {
+	return func(opts *serverOptions) {
+		opts.dsn = dsn
+	}
+}
+
+// WithFixture opens namespace with item type and upserts items from JSON file into it.
+// File may contain either JSON array of items or sequence of JSON items
+func WithFixture(namespace string, item interface{}, path string) ServerOpt {
+	return func(opts *serverOptions) {
+		opts.fixtures = append(opts.fixtures, fixture{namespace: namespace, item: item, path: path})
+	}
+}
+
+// WithStartupTimeout sets timeout of the embedded server startup. Default is 1 minute
+func WithStartupTimeout(timeout time.Duration) ServerOpt {
+	return func(opts *serverOptions) {
+		opts.startup = timeout
+	}
+}
+
+var (
+	portsLock sync.Mutex
+	usedPorts = make(map[int]struct{})
+)
+
+// freePort returns free local TCP port, which was not returned before in this process
+func freePort() (int, error) {
+	portsLock.Lock()
+	defer portsLock.Unlock()
+	for {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return 0, err
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+		l.Close()
+		if _, ok := usedPorts[port]; !ok {
+			usedPorts[port] = struct{}{}
+			return port, nil
+		}
+	}
+}
+
+func releasePort(port int) {
+	portsLock.Lock()
+	defer portsLock.Unlock()
+	delete(usedPorts, port)
+}
+
+// StartServer boots builtinserver on random free ports with storage in t.TempDir().
+// Server is shut down by t.Cleanup, so each test (including parallel ones) gets isolated instance
+func StartServer(t *testing.T, opts ...ServerOpt) *reindexer.Reindexer {
+	t.Helper()
+	options := serverOptions{startup: time.Minute}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	rpcPort, err := freePort()
+	if err != nil {
+		t.Fatalf("can't get free port: %v", err)
+	}
+	t.Cleanup(func() { releasePort(rpcPort) })
+	httpPort, err := freePort()
+	if err != nil {
+		t.Fatalf("can't get free port: %v", err)
+	}
+	t.Cleanup(func() { releasePort(httpPort) })
+
+	cfg := config.DefaultServerConfig()
+	cfg.Net.RPCAddr = "127.0.0.1:" + strconv.Itoa(rpcPort)
+	cfg.Net.HTTPAddr = "127.0.0.1:" + strconv.Itoa(httpPort)
+	cfg.Storage.Path = t.TempDir()
+	cfg.Logger.LogLevel = "error"
+	cfg.Logger.ServerLog = ""
+	cfg.Logger.CoreLog = ""
+	cfg.Logger.HTTPLog = ""
+	cfg.Logger.RPCLog = ""
+
+	server := reindexer.NewReindex("builtinserver://"+serverDbName, reindexer.WithServerConfig(options.startup, cfg))
+	t.Cleanup(func() { server.Close() })
+	if err := server.Status().Err; err != nil {
+		t.Fatalf("can't start server: %v", err)
+	}
+	if err := waitServer("http://"+cfg.Net.HTTPAddr+"/api/v1/check", options.startup); err != nil {
+		t.Fatalf("can't start server: %v", err)
+	}
+
+	dsn := fmt.Sprintf("cproto://%s/%s", cfg.Net.RPCAddr, serverDbName)
+	if options.dsn != nil {
+		*options.dsn = dsn
+	}
+
+	db := server
+	if options.cproto {
+		db = reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing())
+		// Registered after server cleanup, so client is closed before server shutdown
+		t.Cleanup(func() { db.Close() })
+		if err := db.Status().Err; err != nil {
+			t.Fatalf("can't connect to server: %v", err)
+		}
+	}
+
+	for _, f := range options.fixtures {
+		if err := loadFixture(db, f); err != nil {
+			t.Fatalf("can't load fixture '%s' into namespace '%s': %v", f.path, f.namespace, err)
+		}
+	}
+	return db
+}
+
+func waitServer(checkURL string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := http.Get(checkURL)
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK && len(body) != 0 {
+				return nil
+			}
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func loadFixture(db *reindexer.Reindexer, f fixture) error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if err = db.OpenNamespace(f.namespace, reindexer.DefaultNamespaceOptions(), f.item); err != nil {
+		return err
+	}
+
+	var items []json.RawMessage
+	if data = bytes.TrimSpace(data); len(data) != 0 && data[0] == '[' {
+		if err = json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for dec.More() {
+			var item json.RawMessage
+			if err = dec.Decode(&item); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+	}
+
+	for _, item := range items {
+		if err = db.Upsert(f.namespace, []byte(item)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+// +build cgo
+
+package serverharness
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type harnessNested struct {
+	Code string `json:"code"`
+}
+
+type harnessItem struct {
+	ID     int           `reindex:"id,,pk" json:"id"`
+	Name   string        `reindex:"name" json:"name"`
+	Year   int           `reindex:"year,tree" json:"year"`
+	Nested harnessNested `json:"nested"`
+}
+
+const harnessNs = "harness_items"
+
+func ids(t *testing.T, it *reindexer.Iterator) []int {
+	defer it.Close()
+	require.NoError(t, it.Error())
+	res := []int{}
+	for it.Next() {
+		res = append(res, it.Object().(*harnessItem).ID)
+	}
+	require.NoError(t, it.Error())
+	return res
+}
+
+func TestStartServerParallel(t *testing.T) {
+	dsns := make(chan string, 4)
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < cap(dsns); i++ {
+			i := i
+			t.Run(fmt.Sprintf("server_%d", i), func(t *testing.T) {
+				t.Parallel()
+				var dsn string
+				db := StartServer(t, WithCproto(), WithDSN(&dsn))
+				dsns <- dsn
+
+				require.NoError(t, db.OpenNamespace(harnessNs, reindexer.DefaultNamespaceOptions(), harnessItem{}))
+				require.NoError(t, db.Upsert(harnessNs, &harnessItem{ID: i}))
+				// Each server has own storage, so the only item is visible
+				assert.Equal(t, []int{i}, ids(t, db.Query(harnessNs).Exec()))
+			})
+		}
+	})
+	close(dsns)
+
+	addrs := make(map[string]struct{})
+	for dsn := range dsns {
+		u, err := url.Parse(dsn)
+		require.NoError(t, err)
+		addrs[u.Host] = struct{}{}
+		// Server must be shut down by cleanup of subtest
+		_, err = net.DialTimeout("tcp", u.Host, time.Second)
+		assert.Error(t, err)
+	}
+	assert.Equal(t, cap(dsns), len(addrs))
+}
+
+func TestStartServerFixtures(t *testing.T) {
+	db := StartServer(t,
+		WithFixture(harnessNs, harnessItem{}, "testdata/items.json"),
+		WithFixture("stream_items", harnessItem{}, "testdata/items_stream.json"),
+	)
+
+	assert.Equal(t, []int{1, 2, 3}, ids(t, db.Query(harnessNs).Sort("id", false).Exec()))
+	item, found := db.Query(harnessNs).WhereInt("id", reindexer.EQ, 2).Get()
+	require.True(t, found)
+	assert.Equal(t, "b", item.(*harnessItem).Nested.Code)
+	assert.Equal(t, []int{10, 11}, ids(t, db.Query("stream_items").Sort("id", false).Exec()))
+}
@@ -0,0 +1,192 @@
+package reindexer
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/restream/reindexer/cjson"
+)
+
+// RelTime - value of Where condition, which is resolved to time.Now()+d on each execution of query.
+// Time is represented as values of the field: by 'time=unix|unixmilli|unixnano|rfc3339' option of reindex tag
+// or as RFC3339 string in UTC for time.Time fields. RFC3339 strings are compared as strings, so they must be stored in UTC
+type RelTime time.Duration
+
+// timeRepr - representation of time in values of field
+type timeRepr int
+
+const (
+	timeReprRFC3339 timeRepr = iota
+	timeReprUnix
+	timeReprUnixMilli
+	timeReprUnixNano
+)
+
+var timeReprNames = map[timeRepr]string{
+	timeReprRFC3339:   "rfc3339",
+	timeReprUnix:      "unix",
+	timeReprUnixMilli: "unixmilli",
+	timeReprUnixNano:  "unixnano",
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func parseTimeReprName(name string) (timeRepr, error) {
+	for repr, reprName := range timeReprNames {
+		if reprName == name {
+			return repr, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid time option 'time=%s'", name)
+}
+
+func (r timeRepr) String() string {
+	return "time=" + timeReprNames[r]
+}
+
+// allowedOn returns true, if time in representation r may be stored in field (or elements of array field) of type t
+func (r timeRepr) allowedOn(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if r == timeReprRFC3339 {
+		return t == timeType || t.Kind() == reflect.String
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// putTime puts tm to ser as value of query in representation r
+func (r timeRepr) putTime(ser *cjson.Serializer, tm time.Time) {
+	switch r {
+	case timeReprUnix:
+		ser.PutVarCUInt(valueInt64).PutVarInt(tm.Unix())
+	case timeReprUnixMilli:
+		ser.PutVarCUInt(valueInt64).PutVarInt(tm.UnixNano() / int64(time.Millisecond))
+	case timeReprUnixNano:
+		ser.PutVarCUInt(valueInt64).PutVarInt(tm.UnixNano())
+	default:
+		ser.PutVarCUInt(valueString).PutVString(tm.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+// relTimeValue - RelTime value of Where condition. Until it's resolved, it's serialized as int64 value of duration.
+// Values are recorded in order of their offsets
+type relTimeValue struct {
+	index string
+	d     time.Duration
+	// offset and size of serialized value in query
+	offset, size int
+}
+
+// WhereSince - Add where condition, which matches time of field, which is not earlier than d ago
+func (q *Query) WhereSince(index string, d time.Duration) *Query {
+	return q.Where(index, GE, RelTime(-d))
+}
+
+// WhereUntil - Add where condition, which matches time of field, which is not later than d ago
+func (q *Query) WhereUntil(index string, d time.Duration) *Query {
+	return q.Where(index, LE, RelTime(-d))
+}
+
+// timeReprOf returns representation of time in values of field, which is addressed by index name or JSON path
+func (ns *reindexerNamespace) timeReprOf(index string) (timeRepr, bool) {
+	if repr, ok := ns.timeFields[index]; ok {
+		return repr, true
+	}
+	path := index
+	for _, indexDef := range ns.indexes {
+		if indexDef.Name == index && len(indexDef.JSONPaths) != 0 {
+			path = indexDef.JSONPaths[0]
+			break
+		}
+	}
+	if repr, ok := ns.timeFields[path]; ok {
+		return repr, true
+	}
+	if ns.rtype != nil {
+		if t, ok := cjson.FieldTypeByPath(ns.rtype, path); ok {
+			for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+				t = t.Elem()
+			}
+			if t == timeType {
+				return timeReprRFC3339, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// resolveRelTimes resolves RelTime values of query and its joined and merged queries to time now.
+// Resolved queries are serialized to resolvedSer
+func (q *Query) resolveRelTimes(now time.Time) error {
+	if err := q.resolveOwnRelTimes(now); err != nil {
+		return err
+	}
+	for _, sq := range q.joinQueries {
+		if err := sq.resolveOwnRelTimes(now); err != nil {
+			return err
+		}
+	}
+	for _, mq := range q.mergedQueries {
+		if err := mq.resolveRelTimes(now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Query) resolveOwnRelTimes(now time.Time) error {
+	if len(q.relTimes) == 0 {
+		return nil
+	}
+	ns, err := q.db.getNS(q.Namespace)
+	if err != nil {
+		return err
+	}
+	buf := q.ser.Bytes()
+	ser := cjson.NewSerializer(q.resolvedSer.Bytes()[:0])
+	prev := 0
+	for _, rt := range q.relTimes {
+		repr, ok := ns.timeReprOf(rt.index)
+		if !ok {
+			return fmt.Errorf("rq: Can't resolve relative time of field '%s' of namespace '%s': time representation of the field is unknown, set 'time=...' option of its reindex tag", rt.index, q.Namespace)
+		}
+		ser.Write(buf[prev:rt.offset])
+		repr.putTime(&ser, now.Add(rt.d))
+		prev = rt.offset + rt.size
+	}
+	ser.Write(buf[prev:])
+	q.resolvedSer = ser
+	return nil
+}
+
+// execSer returns serialized query, which is sent to server: with resolved RelTime values, if it has them
+func (q *Query) execSer() cjson.Serializer {
+	if len(q.relTimes) != 0 {
+		return q.resolvedSer
+	}
+	return q.ser
+}
+
+// putRelTimesKey distinguishes RelTime values of query and its joined and merged queries in cache key
+func (q *Query) putRelTimesKey(ser *cjson.Serializer) {
+	queries := append([]*Query{q}, q.joinQueries...)
+	for _, mq := range q.mergedQueries {
+		queries = append(queries, mq)
+		queries = append(queries, mq.joinQueries...)
+	}
+	for i, sq := range queries {
+		if len(sq.relTimes) == 0 {
+			continue
+		}
+		ser.PutVarCUInt(i).PutVarCUInt(len(sq.relTimes))
+		for _, rt := range sq.relTimes {
+			ser.PutVarCUInt(rt.offset)
+		}
+	}
+}
@@ -29,6 +29,8 @@ type rawResultQueryParams struct {
 	count          int
 	aggResults     [][]byte
 	explainResults []byte
+	// LSN of main namespace at the moment of query execution. -1 - not sent by server
+	nsStateLSN int64
 }
 
 type resultSerializer struct {
@@ -87,6 +89,7 @@ func (s *resultSerializer) readRawQueryParams(updatePayloadType ...updatePayload
 			updatePayloadType[0](nsid)
 		}
 	}
+	v.nsStateLSN = -1
 	s.readExtraResults(&v)
 	s.flags = v.flags
 
@@ -108,6 +111,9 @@ func (s *resultSerializer) readExtraResults(v *rawResultQueryParams) {
 			v.explainResults = data
 		case bindings.QueryResultAggregation:
 			v.aggResults = append(v.aggResults, data)
+		case bindings.QueryResultNsState:
+			ser := newSerializer(data)
+			v.nsStateLSN = ser.GetVarInt()
 		}
 	}
 	return
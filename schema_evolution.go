@@ -0,0 +1,241 @@
+package reindexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+const (
+	// fingerprint of fields and indexes of namespace is stored in its meta by schemaFingerprintKey (see CheckSchemaEvolution)
+	schemaFingerprintKey     = "rq:schema_fingerprint"
+	schemaFingerprintVersion = 1
+)
+
+// Kinds of changes of ErrSchemaEvolution
+const (
+	SchemaFieldRemoved = "field_removed"
+	SchemaFieldRenamed = "field_renamed"
+	SchemaFieldChanged = "field_changed"
+	SchemaIndexRemoved = "index_removed"
+	SchemaIndexChanged = "index_changed"
+)
+
+// SchemaChange - change of stored schema of namespace
+type SchemaChange struct {
+	// One of Schema... kinds
+	Kind string
+	// JSON path of field or name of index in stored schema
+	Name string
+	// JSON path of renamed field
+	NewName string
+	// Stored and new type of field or definition of index
+	Old string
+	New string
+}
+
+func (c SchemaChange) String() string {
+	switch c.Kind {
+	case SchemaFieldRemoved:
+		return fmt.Sprintf("field '%s' is removed", c.Name)
+	case SchemaFieldRenamed:
+		return fmt.Sprintf("field '%s' is renamed to '%s'", c.Name, c.NewName)
+	case SchemaFieldChanged:
+		return fmt.Sprintf("type of field '%s' is changed from %s to %s", c.Name, c.Old, c.New)
+	case SchemaIndexRemoved:
+		return fmt.Sprintf("index '%s' is removed", c.Name)
+	}
+	return fmt.Sprintf("index '%s' is changed from %s to %s", c.Name, c.Old, c.New)
+}
+
+// ErrSchemaEvolution is returned by OpenNamespace with CheckSchemaEvolution, when struct removes, renames or changes fields or indexes
+// of schema, which is stored in namespace, so the stored data may become invisible. Namespace isn't opened in this case
+type ErrSchemaEvolution struct {
+	Namespace string
+	Changes   []SchemaChange
+}
+
+func (e ErrSchemaEvolution) Error() string {
+	changes := make([]string, 0, len(e.Changes))
+	for _, c := range e.Changes {
+		changes = append(changes, c.String())
+	}
+	return fmt.Sprintf("rq: Schema of namespace '%s' is changed by struct: %s. Use AcknowledgeSchemaChange option to accept the changes",
+		e.Namespace, strings.Join(changes, ", "))
+}
+
+func (e ErrSchemaEvolution) Code() int {
+	return ErrCodeParams
+}
+
+// schemaFingerprint - types of fields by JSON paths and definitions of indexes by names. Maps are marshaled with sorted keys,
+// so order of fields doesn't change fingerprint
+type schemaFingerprint struct {
+	Version int               `json:"version"`
+	Fields  map[string]string `json:"fields"`
+	Indexes map[string]string `json:"indexes"`
+}
+
+func newSchemaFingerprint(t reflect.Type, indexes []bindings.IndexDef) *schemaFingerprint {
+	fp := &schemaFingerprint{Version: schemaFingerprintVersion, Fields: make(map[string]string), Indexes: make(map[string]string, len(indexes))}
+	fp.addFields(t, "", map[reflect.Type]bool{})
+	for _, def := range indexes {
+		desc := def.IndexType + " " + def.FieldType + " (" + strings.Join(def.JSONPaths, "+") + ")"
+		if def.IsPK {
+			desc += " pk"
+		}
+		if def.IsArray {
+			desc += " array"
+		}
+		fp.Indexes[def.Name] = desc
+	}
+	return fp
+}
+
+// addFields adds JSON paths and types of fields of struct t and of its nested structs
+func (fp *schemaFingerprint) addFields(t reflect.Type, base string, visited map[reflect.Type]bool) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		// Joined and composite fields aren't stored
+		if tag := f.Tag.Get("reindex"); name == "-" || f.PkgPath != "" && !f.Anonymous || strings.Contains(tag, "joined") || strings.Contains(tag, "composite") {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+			if ft.Kind() != reflect.Ptr && ft.Elem().Kind() == reflect.Uint8 {
+				break
+			}
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fp.addFields(ft, base, visited)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		jsonPath := base + name
+		fp.Fields[jsonPath] = schemaFieldType(f.Type)
+		if ft.Kind() == reflect.Struct {
+			fp.addFields(ft, jsonPath+".", visited)
+		}
+	}
+}
+
+// schemaFieldType returns type of field, which is semantic for stored data: e.g. sizes of ints don't matter
+func schemaFieldType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFieldType(t.Elem())
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		return "[]" + schemaFieldType(t.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "double"
+	case reflect.Interface:
+		return "any"
+	}
+	return t.Kind().String()
+}
+
+// diff returns changes of stored fingerprint fp by the new one. Removed field is renamed, if the only field of the same type
+// is added to the same parent
+func (fp *schemaFingerprint) diff(cur *schemaFingerprint) (changes []SchemaChange) {
+	var removed, added []string
+	for name, typ := range fp.Fields {
+		if curType, ok := cur.Fields[name]; !ok {
+			removed = append(removed, name)
+		} else if curType != typ {
+			changes = append(changes, SchemaChange{Kind: SchemaFieldChanged, Name: name, Old: typ, New: curType})
+		}
+	}
+	for name := range cur.Fields {
+		if _, ok := fp.Fields[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(removed)
+	sort.Strings(added)
+	parent := func(name string) string { return path.Dir(strings.Replace(name, ".", "/", -1)) }
+	for _, name := range removed {
+		var candidates []string
+		for _, a := range added {
+			if parent(a) == parent(name) && cur.Fields[a] == fp.Fields[name] {
+				candidates = append(candidates, a)
+			}
+		}
+		if len(candidates) == 1 {
+			changes = append(changes, SchemaChange{Kind: SchemaFieldRenamed, Name: name, NewName: candidates[0], Old: fp.Fields[name], New: fp.Fields[name]})
+		} else {
+			changes = append(changes, SchemaChange{Kind: SchemaFieldRemoved, Name: name, Old: fp.Fields[name]})
+		}
+	}
+	for name, def := range fp.Indexes {
+		if curDef, ok := cur.Indexes[name]; !ok {
+			changes = append(changes, SchemaChange{Kind: SchemaIndexRemoved, Name: name, Old: def})
+		} else if curDef != def {
+			changes = append(changes, SchemaChange{Kind: SchemaIndexChanged, Name: name, Old: def, New: curDef})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return changes[i].Kind < changes[j].Kind
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// checkSchemaEvolution compares fingerprint of struct of namespace with the stored one, then stores the new fingerprint, if it differs.
+// Additions of fields and indexes are allowed, the other changes fail with ErrSchemaEvolution, unless they are acknowledged.
+// Returns names of changed indexes, which are acknowledged, so they are updated instead of addition
+func (db *reindexerImpl) checkSchemaEvolution(ctx context.Context, ns *reindexerNamespace, acknowledge bool) (updatedIndexes map[string]bool, err error) {
+	data, err := db.getMeta(ctx, ns.name, schemaFingerprintKey)
+	if err != nil {
+		return nil, err
+	}
+	cur := newSchemaFingerprint(ns.rtype, ns.indexes)
+	curData, err := json.Marshal(cur)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 0 {
+		stored := &schemaFingerprint{}
+		if err = json.Unmarshal(data, stored); err != nil {
+			return nil, bindings.NewError(fmt.Sprintf("rq: Invalid stored schema fingerprint of namespace '%s': %v", ns.name, err), ErrCodeParams)
+		}
+		changes := stored.diff(cur)
+		if len(changes) != 0 && !acknowledge {
+			return nil, ErrSchemaEvolution{Namespace: ns.name, Changes: changes}
+		}
+		for _, c := range changes {
+			if c.Kind == SchemaIndexChanged {
+				if updatedIndexes == nil {
+					updatedIndexes = make(map[string]bool)
+				}
+				updatedIndexes[c.Name] = true
+			}
+		}
+		if string(data) == string(curData) {
+			return nil, nil
+		}
+	}
+	return updatedIndexes, db.putMeta(ctx, ns.name, schemaFingerprintKey, curData)
+}
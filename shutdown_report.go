@@ -0,0 +1,142 @@
+package reindexer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// shutdownDrainPoll - period of checks of operations, which are drained by close
+const shutdownDrainPoll = 5 * time.Millisecond
+
+// liveOps - registry of operations, which are running or have open results, for shutdown report (see WithShutdownReport).
+// Operations are registered only if report is set
+type liveOps struct {
+	opts   bindings.OptionShutdownReport
+	lastID uint64 // accessed atomically
+	ops    sync.Map
+}
+
+// liveOp - operation of liveOps. Methods of nil operation do nothing
+type liveOp struct {
+	id        uint64
+	kind      string
+	namespace string
+	label     string
+	start     time.Time
+	consumed  int64 // accessed atomically
+	// end and err are set before done (accessed atomically)
+	end  time.Time
+	err  error
+	done int32
+	// operation was done, when drain was finished. Set by close
+	drained bool
+}
+
+// startOp registers operation in live operations, if shutdown report is set
+func (db *reindexerImpl) startOp(ctx context.Context, kind, namespace string) *liveOp {
+	if db.liveOps.opts.Report == nil {
+		return nil
+	}
+	op := &liveOp{id: atomic.AddUint64(&db.liveOps.lastID, 1), kind: kind, namespace: namespace, label: bindings.ActivityLabel(ctx), start: time.Now()}
+	db.liveOps.ops.Store(op.id, op)
+	return op
+}
+
+// consume counts item, which is read from results of operation
+func (op *liveOp) consume() {
+	if op != nil {
+		atomic.AddInt64(&op.consumed, 1)
+	}
+}
+
+// finish removes operation from live operations
+func (op *liveOp) finish(db *reindexerImpl, err error) {
+	if op == nil {
+		return
+	}
+	op.end, op.err = time.Now(), err
+	atomic.StoreInt32(&op.done, 1)
+	db.liveOps.ops.Delete(op.id)
+}
+
+func (op *liveOp) isDone() bool {
+	return atomic.LoadInt32(&op.done) != 0
+}
+
+// liveSnapshot returns the current live operations
+func (db *reindexerImpl) liveSnapshot() []*liveOp {
+	var ops []*liveOp
+	db.liveOps.ops.Range(func(key, value interface{}) bool {
+		ops = append(ops, value.(*liveOp))
+		return true
+	})
+	return ops
+}
+
+// drainLiveOps waits for the current live operations up to drain timeout or until ctx is done. Returns operations to report
+func (db *reindexerImpl) drainLiveOps(ctx context.Context) (ops []*liveOp, drainTime time.Duration) {
+	if db.liveOps.opts.Report == nil {
+		return nil, 0
+	}
+	start := time.Now()
+	ops = db.liveSnapshot()
+	if timeout := db.liveOps.opts.DrainTimeout; timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		ticker := time.NewTicker(shutdownDrainPoll)
+		defer ticker.Stop()
+	drain:
+		for _, op := range ops {
+			for !op.isDone() {
+				select {
+				case <-ticker.C:
+				case <-timer.C:
+					break drain
+				case <-ctx.Done():
+					break drain
+				}
+			}
+		}
+	}
+	for _, op := range ops {
+		op.drained = op.isDone()
+	}
+	return ops, time.Since(start)
+}
+
+// reportShutdown reports operations, which were live, when close was started, and operations, which are started during drain
+func (db *reindexerImpl) reportShutdown(ops []*liveOp, drainTime time.Duration) {
+	report := db.liveOps.opts.Report
+	if report == nil {
+		return
+	}
+	seen := make(map[uint64]bool, len(ops))
+	for _, op := range ops {
+		seen[op.id] = true
+	}
+	for _, op := range db.liveSnapshot() {
+		if !seen[op.id] {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].id < ops[j].id })
+
+	now := time.Now()
+	res := bindings.ShutdownReport{Operations: make([]bindings.ShutdownOperation, 0, len(ops)), DrainTime: drainTime}
+	for _, op := range ops {
+		rop := bindings.ShutdownOperation{ID: op.id, Kind: op.kind, Namespace: op.namespace, Label: op.label,
+			Consumed: int(atomic.LoadInt64(&op.consumed)), Elapsed: now.Sub(op.start), Drained: op.drained}
+		if op.isDone() {
+			rop.Elapsed, rop.Err = op.end.Sub(op.start), op.err
+			// Requests, which are completed by graceful close of connections, are drained too
+			rop.Drained = rop.Drained || op.err == nil
+		}
+		res.Operations = append(res.Operations, rop)
+	}
+	report(res)
+}
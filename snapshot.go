@@ -0,0 +1,455 @@
+package reindexer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// Snapshot is a stream of frames after snapshotMagic: header frame, frames of JSON documents with marker frames between them and end frame.
+// Frame is type byte, little endian uint32 size and payload. Payload of marker and end frames is little endian uint64 count of documents
+// before frame and uint32 CRC-32C of all bytes of stream before frame
+const (
+	snapshotMagic = "RXSNAP\x00\x01"
+
+	snapshotFrameHeader = 'H'
+	snapshotFrameDoc    = 'D'
+	snapshotFrameMarker = 'M'
+	snapshotFrameEnd    = 'E'
+
+	snapshotFrameHdrLen   = 5
+	snapshotMaxFrameSize  = 64 << 20
+	snapshotMarkerPayload = 12
+
+	defaultSnapshotPageSize       = 1000
+	defaultSnapshotMarkerInterval = 1000
+)
+
+var snapshotCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SnapshotOptions - options of ExportSnapshot
+type SnapshotOptions struct {
+	// PageSize - count of documents, which are read by one query (default 1000)
+	PageSize int
+	// MarkerInterval - count of documents between markers (default 1000). ImportSnapshot commits documents of each interval by transaction
+	MarkerInterval int
+	// MetaKeys - keys of meta to export. By default all meta is exported, if binding enumerates its keys (cproto), otherwise meta is not exported
+	MetaKeys []string
+}
+
+// SnapshotConflictPolicy - action of ImportSnapshot, if namespace exists
+type SnapshotConflictPolicy int
+
+const (
+	// SnapshotConflictFail - import fails with ErrCodeConflict
+	SnapshotConflictFail SnapshotConflictPolicy = iota
+	// SnapshotConflictOverwrite - namespace is dropped and created from snapshot
+	SnapshotConflictOverwrite
+	// SnapshotConflictMerge - missing indexes are added to namespace, documents and meta of snapshot replace ones with the same PK and keys
+	SnapshotConflictMerge
+)
+
+// ImportSnapshotOptions - options of ImportSnapshot
+type ImportSnapshotOptions struct {
+	// Conflict - action, if namespace exists (default SnapshotConflictFail)
+	Conflict SnapshotConflictPolicy
+	// ResumeFrom - count of documents, which were imported by the failed import of the same snapshot (see Progress).
+	// Import skips them and doesn't change namespace, its indexes and meta, so Conflict is not applied
+	ResumeFrom int64
+	// Progress (optional) is called after each committed transaction with total count of imported documents
+	Progress func(imported int64)
+}
+
+// snapshotHeader - payload of header frame
+type snapshotHeader struct {
+	Namespace      string            `json:"namespace"`
+	Created        time.Time         `json:"created"`
+	StorageEnabled bool              `json:"storage_enabled"`
+	Indexes        []IndexDef        `json:"indexes"`
+	Meta           map[string][]byte `json:"meta,omitempty"`
+}
+
+func errSnapshot(format string, args ...interface{}) error {
+	return bindings.NewError("rq: Invalid snapshot: "+fmt.Sprintf(format, args...), ErrCodeParseBin)
+}
+
+type snapshotWriter struct {
+	w     io.Writer
+	crc   hash.Hash32
+	frame []byte
+}
+
+func (sw *snapshotWriter) write(p []byte) error {
+	sw.crc.Write(p)
+	_, err := sw.w.Write(p)
+	return err
+}
+
+func (sw *snapshotWriter) writeFrame(typ byte, payload []byte) error {
+	sw.frame = append(sw.frame[:0], typ, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(sw.frame[1:], uint32(len(payload)))
+	sw.frame = append(sw.frame, payload...)
+	return sw.write(sw.frame)
+}
+
+func (sw *snapshotWriter) writeMarker(typ byte, docs int64) error {
+	var payload [snapshotMarkerPayload]byte
+	binary.LittleEndian.PutUint64(payload[:], uint64(docs))
+	binary.LittleEndian.PutUint32(payload[8:], sw.crc.Sum32())
+	return sw.writeFrame(typ, payload[:])
+}
+
+type snapshotReader struct {
+	r       *bufio.Reader
+	crc     hash.Hash32
+	payload []byte
+}
+
+// next reads frame. sum is CRC-32C of stream before frame
+func (sr *snapshotReader) next() (typ byte, payload []byte, sum uint32, err error) {
+	sum = sr.crc.Sum32()
+	var hdr [snapshotFrameHdrLen]byte
+	if _, err = io.ReadFull(sr.r, hdr[:]); err != nil {
+		return 0, nil, 0, sr.readError(err)
+	}
+	size := binary.LittleEndian.Uint32(hdr[1:])
+	if size > snapshotMaxFrameSize {
+		return 0, nil, 0, errSnapshot("frame size %d exceeds %d", size, snapshotMaxFrameSize)
+	}
+	if cap(sr.payload) < int(size) {
+		sr.payload = make([]byte, size)
+	}
+	payload = sr.payload[:size]
+	if _, err = io.ReadFull(sr.r, payload); err != nil {
+		return 0, nil, 0, sr.readError(err)
+	}
+	sr.crc.Write(hdr[:])
+	sr.crc.Write(payload)
+	return hdr[0], payload, sum, nil
+}
+
+func (sr *snapshotReader) readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errSnapshot("unexpected end of stream")
+	}
+	return err
+}
+
+// checkSnapshotMarker checks count of documents and checksum of marker or end frame
+func checkSnapshotMarker(payload []byte, docs int64, sum uint32) error {
+	if len(payload) != snapshotMarkerPayload {
+		return errSnapshot("marker size %d, but %d is expected", len(payload), snapshotMarkerPayload)
+	}
+	if count := int64(binary.LittleEndian.Uint64(payload)); count != docs {
+		return errSnapshot("marker after %d documents has count %d", docs, count)
+	}
+	if crc := binary.LittleEndian.Uint32(payload[8:]); crc != sum {
+		return errSnapshot("checksum mismatch after %d documents", docs)
+	}
+	return nil
+}
+
+func (db *reindexerImpl) exportSnapshot(ctx context.Context, namespace string, w io.Writer, opts SnapshotOptions) error {
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultSnapshotPageSize
+	}
+	if opts.MarkerInterval <= 0 {
+		opts.MarkerInterval = defaultSnapshotMarkerInterval
+	}
+	desc, err := db.describeNamespace(ctx, namespace)
+	if err == ErrNotFound {
+		return bindings.NewError(fmt.Sprintf("rq: Namespace '%s' does not exist", namespace), ErrCodeNotFound)
+	} else if err != nil {
+		return err
+	}
+	hdr := snapshotHeader{Namespace: desc.Name, Created: time.Now().UTC(), StorageEnabled: desc.StorageEnabled}
+	var keys []paginationKey
+	for _, index := range desc.Indexes {
+		hdr.Indexes = append(hdr.Indexes, IndexDef(index.IndexDef))
+		if index.IsPK {
+			if keys, err = snapshotKeys(namespace, index.IndexDef); err != nil {
+				return err
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return bindings.NewError(fmt.Sprintf("rq: Namespace '%s' doesn't have PK, which is required by snapshot pages", namespace), ErrCodeParams)
+	}
+	if hdr.Meta, err = db.snapshotMeta(ctx, namespace, opts.MetaKeys); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(hdr)
+	if err != nil {
+		return err
+	}
+
+	sw := &snapshotWriter{w: w, crc: crc32.New(snapshotCRCTable)}
+	if err = sw.write([]byte(snapshotMagic)); err != nil {
+		return err
+	}
+	if err = sw.writeFrame(snapshotFrameHeader, payload); err != nil {
+		return err
+	}
+	var docs int64
+	var after []cursorValue
+	var last []byte
+	for {
+		// Each page is selected after PK of the last document of the previous one, so pages don't overlap even with concurrent writes
+		q := db.query(namespace).IncludeDeleted().Limit(opts.PageSize)
+		q.jsonNS = true
+		if after != nil {
+			q.putAfter(keys, after)
+		}
+		for _, key := range keys {
+			q.Sort(key.index, false)
+		}
+		it := q.ExecToJsonCtx(ctx)
+		count := 0
+		for it.Next() {
+			if err = sw.writeFrame(snapshotFrameDoc, it.JSON()); err != nil {
+				break
+			}
+			last = append(last[:0], it.JSON()...)
+			count++
+			docs++
+			if docs%int64(opts.MarkerInterval) == 0 {
+				if err = sw.writeMarker(snapshotFrameMarker, docs); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = it.Error()
+		}
+		it.Close()
+		if err != nil {
+			return err
+		}
+		if count < opts.PageSize {
+			break
+		}
+		if after, err = snapshotKeyValues(keys, last); err != nil {
+			return err
+		}
+	}
+	return sw.writeMarker(snapshotFrameEnd, docs)
+}
+
+// snapshotKeys returns keys of pages of snapshot by PK index: the PK field or fields of composite PK
+func snapshotKeys(namespace string, pk IndexDef) ([]paginationKey, error) {
+	if pk.IsArray {
+		return nil, bindings.NewError(fmt.Sprintf("rq: Array PK '%s' of namespace '%s' is not supported by snapshot", pk.Name, namespace), ErrCodeParams)
+	}
+	if pk.FieldType != "composite" {
+		path := pk.Name
+		if len(pk.JSONPaths) == 1 {
+			path = pk.JSONPaths[0]
+		}
+		return []paginationKey{{index: pk.Name, path: path}}, nil
+	}
+	keys := make([]paginationKey, 0, len(pk.JSONPaths))
+	for _, path := range pk.JSONPaths {
+		keys = append(keys, paginationKey{index: path, path: path})
+	}
+	return keys, nil
+}
+
+// snapshotKeyValues returns values of keys of JSON document
+func snapshotKeyValues(keys []paginationKey, doc []byte) ([]cursorValue, error) {
+	var obj interface{}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, err
+	}
+	values := make([]cursorValue, len(keys))
+	for i, key := range keys {
+		v := obj
+		for _, field := range strings.Split(key.path, ".") {
+			m, _ := v.(map[string]interface{})
+			v = m[field]
+		}
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				v = i
+			} else {
+				v, _ = n.Float64()
+			}
+		}
+		cv, ok := newCursorValue(v)
+		if !ok {
+			return nil, bindings.NewError(fmt.Sprintf("rq: PK field '%s' of document has unsupported value %v", key.path, v), ErrCodeParams)
+		}
+		values[i] = cv
+	}
+	return values, nil
+}
+
+// snapshotMeta reads meta of namespace by keys or by keys, which are enumerated by binding
+func (db *reindexerImpl) snapshotMeta(ctx context.Context, namespace string, keys []string) (map[string][]byte, error) {
+	if keys == nil {
+		binding, ok := db.getBinding().(bindings.RawBindingEnumMeta)
+		if !ok {
+			return nil, nil
+		}
+		if err := db.limiter().wait(ctx, namespace, CommandClassRead); err != nil {
+			return nil, err
+		}
+		var err error
+		if keys, err = binding.EnumMeta(ctx, namespace); err != nil {
+			return nil, err
+		}
+	}
+	meta := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		data, err := db.getMeta(ctx, namespace, key)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = data
+	}
+	return meta, nil
+}
+
+func (db *reindexerImpl) importSnapshot(ctx context.Context, namespace string, r io.Reader, opts ImportSnapshotOptions) error {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(snapshotCRCTable)}
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil {
+		return sr.readError(err)
+	}
+	if string(magic) != snapshotMagic {
+		return errSnapshot("unknown format")
+	}
+	sr.crc.Write(magic)
+	typ, payload, _, err := sr.next()
+	if err != nil {
+		return err
+	}
+	if typ != snapshotFrameHeader {
+		return errSnapshot("header is expected, but frame has type '%c'", typ)
+	}
+	var hdr snapshotHeader
+	if err = json.Unmarshal(payload, &hdr); err != nil {
+		return errSnapshot("header: %v", err)
+	}
+	if opts.ResumeFrom == 0 {
+		if err = db.prepareSnapshotNs(ctx, namespace, &hdr, opts.Conflict); err != nil {
+			return err
+		}
+	}
+
+	var tx *Tx
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+	var docs int64
+	for {
+		typ, payload, sum, err := sr.next()
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case snapshotFrameDoc:
+			docs++
+			if docs <= opts.ResumeFrom {
+				continue
+			}
+			if tx == nil {
+				if tx, err = newTx(db, namespace, ctx); err != nil {
+					return err
+				}
+			}
+			if err = tx.UpsertJSON(payload); err != nil {
+				return err
+			}
+		case snapshotFrameMarker, snapshotFrameEnd:
+			// Documents are committed only after they are verified by checksum
+			if err = checkSnapshotMarker(payload, docs, sum); err != nil {
+				return err
+			}
+			if tx != nil {
+				err = tx.Commit()
+				tx = nil
+				if err != nil {
+					return err
+				}
+				if opts.Progress != nil {
+					opts.Progress(docs)
+				}
+			}
+			if typ == snapshotFrameEnd {
+				return nil
+			}
+		default:
+			return errSnapshot("unknown frame type '%c' after %d documents", typ, docs)
+		}
+	}
+}
+
+// prepareSnapshotNs creates namespace with indexes and meta of snapshot or applies conflict policy to existing one
+func (db *reindexerImpl) prepareSnapshotNs(ctx context.Context, namespace string, hdr *snapshotHeader, conflict SnapshotConflictPolicy) error {
+	desc, err := db.describeNamespace(ctx, namespace)
+	exists := err == nil
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+	if exists {
+		switch conflict {
+		case SnapshotConflictFail:
+			return bindings.NewError(fmt.Sprintf("rq: Namespace '%s' already exists", namespace), ErrCodeConflict)
+		case SnapshotConflictOverwrite:
+			if err = db.dropNamespace(ctx, namespace); err != nil {
+				return err
+			}
+			exists = false
+		case SnapshotConflictMerge:
+		default:
+			return bindings.NewError(fmt.Sprintf("rq: Unknown snapshot conflict policy %d", conflict), ErrCodeParams)
+		}
+	}
+
+	if err = db.limiter().wait(ctx, namespace, CommandClassSchema); err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	if exists {
+		for _, index := range desc.Indexes {
+			existing[strings.ToLower(index.Name)] = true
+		}
+	} else if err = db.getBinding().OpenNamespace(ctx, namespace, hdr.StorageEnabled, false); err != nil {
+		return err
+	}
+	for _, index := range hdr.Indexes {
+		if existing[strings.ToLower(index.Name)] {
+			continue
+		}
+		if err = db.getBinding().AddIndex(ctx, namespace, bindings.IndexDef(index)); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(hdr.Meta))
+	for key := range hdr.Meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err = db.putMeta(ctx, namespace, key, hdr.Meta[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// IncludeDeleted - query returns soft deleted items too (see NamespaceOptions.SoftDelete)
+// It's applied only to q, joined and merged queries are filtered by their own settings
+func (q *Query) IncludeDeleted() *Query {
+	q.includeDeleted = true
+	return q
+}
+
+// putSoftDeleteFilter appends `AND (NOT field = true)` to serialized query, if its namespace has soft deletes.
+// Filter is put to its own bracket with AND, so conditions of query with Or are not changed
+func (q *Query) putSoftDeleteFilter(ser *cjson.Serializer) {
+	if q.includeDeleted {
+		return
+	}
+	ns, err := q.db.getNS(q.Namespace)
+	if err != nil || len(ns.opts.softDelete.Field) == 0 {
+		return
+	}
+	ser.PutVarCUInt(queryOpenBracket).PutVarCUInt(opAND)
+	ser.PutVarCUInt(queryCondition).PutVString(ns.opts.softDelete.Field).PutVarCUInt(opNOT).PutVarCUInt(EQ)
+	ser.PutVarCUInt(1).PutVarCUInt(valueBool).PutVarUInt(1)
+	ser.PutVarCUInt(queryCloseBracket)
+}
+
+// softDelete marks item as deleted by update query by its PK
+func (db *reindexerImpl) softDelete(ctx context.Context, ns *reindexerNamespace, item interface{}) error {
+	q := db.query(ns.name)
+	if !ns.wherePK(q, item) {
+		q.close()
+		return bindings.NewError(fmt.Sprintf("rq: Can't get primary key of item to soft delete it from namespace '%s'", ns.name), ErrCodeParams)
+	}
+	q.Set(ns.opts.softDelete.Field, true)
+	if len(ns.opts.softDelete.TimeField) != 0 {
+		q.Set(ns.opts.softDelete.TimeField, time.Now().Unix())
+	}
+	it := q.UpdateCtx(ctx)
+	defer it.Close()
+	return it.Error()
+}
+
+// wherePK adds conditions by primary key of item struct to q. Return false, if PK of item can't be derived
+func (ns *reindexerNamespace) wherePK(q *Query, item interface{}) bool {
+	pk := ns.itemPK(item)
+	if len(pk) == 0 {
+		return false
+	}
+	for _, index := range ns.indexes {
+		if !index.IsPK {
+			continue
+		}
+		if len(index.JSONPaths) == 1 {
+			q.Where(index.Name, EQ, pk[0])
+		} else {
+			q.WhereComposite(index.Name, EQ, pk[:len(index.JSONPaths)])
+		}
+		pk = pk[len(index.JSONPaths):]
+	}
+	return true
+}
+
+func (db *reindexerImpl) purge(ctx context.Context, namespace string, olderThan time.Duration) (int, error) {
+	ns, err := db.getNS(namespace)
+	if err != nil {
+		return 0, err
+	}
+	opts := ns.opts.softDelete
+	if len(opts.Field) == 0 {
+		return 0, bindings.NewError(fmt.Sprintf("rq: Soft deletes are not enabled for namespace '%s'", namespace), ErrCodeParams)
+	}
+	if olderThan > 0 && len(opts.TimeField) == 0 {
+		return 0, bindings.NewError(fmt.Sprintf("rq: Purge with olderThan requires time field of soft deletes of namespace '%s'", namespace), ErrCodeParams)
+	}
+	q := db.query(ns.name).IncludeDeleted().WhereBool(opts.Field, EQ, true)
+	if olderThan > 0 {
+		q.WhereInt64(opts.TimeField, LT, time.Now().Add(-olderThan).Unix())
+	}
+	return q.DeleteCtx(ctx)
+}
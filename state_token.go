@@ -0,0 +1,108 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// StateToken is an opaque token of namespace state. It's changed by each modification of namespace
+type StateToken string
+
+// ErrStateMismatch is returned by query with RequireState, when namespace is changed since the token was captured
+type ErrStateMismatch struct {
+	Namespace string
+	Required  StateToken
+}
+
+func (e ErrStateMismatch) Error() string {
+	return fmt.Sprintf("rq: State of namespace '%s' is changed since token '%s' was captured", e.Namespace, e.Required)
+}
+
+func (e ErrStateMismatch) Code() int {
+	return ErrCodeStateMismatch
+}
+
+func (e ErrStateMismatch) Unwrap() error {
+	return bindings.ErrorStateMismatch
+}
+
+func makeStateToken(lsn int64) StateToken {
+	return StateToken(strconv.FormatInt(lsn, 10))
+}
+
+func (t StateToken) lsn() (int64, error) {
+	lsn, err := strconv.ParseInt(string(t), 10, 64)
+	if err != nil || lsn < 0 {
+		return 0, bindings.NewError(fmt.Sprintf("rq: Invalid state token '%s'", t), ErrCodeParams)
+	}
+	return lsn, nil
+}
+
+// nsStateLSN returns LSN of namespace from memstats. It's used to emulate state tokens for servers, which don't support them
+func (db *reindexerImpl) nsStateLSN(ctx context.Context, namespace string) (int64, error) {
+	q := db.query(MemstatsNamespaceName).Where("name", EQ, namespace)
+	q.executed = true
+	// Request is not passed through rate limiter: it's made for the query, which is already accounted
+	result, err := db.prepareQuery(ctx, q, false)
+	if err != nil {
+		return 0, err
+	}
+	stat, err := newIterator(ctx, db, q, result, q.nsArray, nil, nil, nil).FetchOne()
+	if err != nil {
+		return 0, err
+	}
+	return stat.(*NamespaceMemStat).Replication.LastLSN, nil
+}
+
+// stateToken returns token of namespace state, captured by query results. lsn < 0 - state wasn't captured
+func stateToken(lsn int64) (StateToken, error) {
+	if lsn < 0 {
+		return "", bindings.NewError("rq: State token is not available", ErrCodeLogic)
+	}
+	return makeStateToken(lsn), nil
+}
+
+// captureStateLSN reads LSN of query namespace from memstats before execution for servers, which don't support state tokens.
+// It's best-effort, so query is executed even if memstats are unavailable
+func (db *reindexerImpl) captureStateLSN(ctx context.Context, q *Query) {
+	if q.stateLSN >= 0 || IsSystemNamespace(q.Namespace) || db.checkFeature(featureStateToken) == nil {
+		return
+	}
+	if lsn, err := db.nsStateLSN(ctx, q.Namespace); err == nil {
+		q.stateLSN = lsn
+	}
+}
+
+// putRequiredState adds required state of namespace to select query or checks it by memstats for servers, which don't support it
+func (db *reindexerImpl) putRequiredState(ctx context.Context, q *Query, ser *cjson.Serializer) error {
+	lsn, err := q.requiredState.lsn()
+	if err != nil {
+		return err
+	}
+	if db.checkFeature(featureStateToken) == nil {
+		ser.PutVarCUInt(queryRequireState)
+		ser.PutVarInt(lsn)
+		return nil
+	}
+	actual, err := db.nsStateLSN(ctx, q.Namespace)
+	if err != nil {
+		return err
+	}
+	q.stateLSN = actual
+	if actual != lsn {
+		return ErrStateMismatch{Namespace: q.Namespace, Required: q.requiredState}
+	}
+	return nil
+}
+
+// stateMismatchError converts state mismatch error of server to ErrStateMismatch
+func stateMismatchError(err error, q *Query) error {
+	if rerr, ok := err.(bindings.Error); ok && rerr.Code() == bindings.ErrStateMismatch {
+		return ErrStateMismatch{Namespace: q.Namespace, Required: q.requiredState}
+	}
+	return err
+}
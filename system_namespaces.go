@@ -0,0 +1,81 @@
+package reindexer
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Names of system namespaces. Their items are read by queries or by typed accessors (e.g. DescribeNamespaces,
+// GetNamespacesMemStat). Only #config is writable
+const (
+	ConfigNamespaceName           = "#config"
+	MemstatsNamespaceName         = "#memstats"
+	NamespacesNamespaceName       = "#namespaces"
+	PerfstatsNamespaceName        = "#perfstats"
+	QueriesperfstatsNamespaceName = "#queriesperfstats"
+	ClientsStatsNamespaceName     = "#clientsstats"
+	ActivitystatsNamespaceName    = "#activitystats"
+)
+
+// systemNamespaces - system namespaces, which are registered by client with their item types
+var systemNamespaces = []struct {
+	name     string
+	item     interface{}
+	readOnly bool
+}{
+	{NamespacesNamespaceName, NamespaceDescription{}, true},
+	{PerfstatsNamespaceName, NamespacePerfStat{}, true},
+	{MemstatsNamespaceName, NamespaceMemStat{}, true},
+	{QueriesperfstatsNamespaceName, QueryPerfStat{}, true},
+	{ConfigNamespaceName, DBConfigItem{}, false},
+	{ClientsStatsNamespaceName, ClientConnectionStat{}, true},
+	{ActivitystatsNamespaceName, ActivityStat{}, true},
+}
+
+// IsSystemNamespace returns true for names of system namespaces, i.e. names with '#' prefix
+func IsSystemNamespace(namespace string) bool {
+	return strings.HasPrefix(namespace, "#")
+}
+
+// ErrSystemNamespace is returned on writes to read-only system namespace and on OpenNamespace and RegisterNamespace
+// of system namespace with user struct
+type ErrSystemNamespace struct {
+	Namespace string
+	ReadOnly  bool
+}
+
+func (e ErrSystemNamespace) Error() string {
+	if e.ReadOnly {
+		return "rq: System namespace '" + e.Namespace + "' is read-only"
+	}
+	return "rq: System namespace '" + e.Namespace + "' can't be opened with user struct, it's read by queries or by typed accessors " +
+		"(e.g. DescribeNamespaces, GetNamespacesMemStat)"
+}
+
+func (e ErrSystemNamespace) Code() int {
+	if e.ReadOnly {
+		return ErrCodeForbidden
+	}
+	return ErrCodeParams
+}
+
+// checkSystemWrite returns error, if namespace is read-only system namespace
+func checkSystemWrite(namespace string) error {
+	namespace = strings.ToLower(namespace)
+	for _, sys := range systemNamespaces {
+		if sys.name == namespace && sys.readOnly {
+			return ErrSystemNamespace{Namespace: namespace, ReadOnly: true}
+		}
+	}
+	return nil
+}
+
+// checkSystemOpen returns error, if namespace is system namespace, which is registered by client with another type than t
+func checkSystemOpen(namespace string, t reflect.Type) error {
+	for _, sys := range systemNamespaces {
+		if sys.name == namespace && reflect.TypeOf(sys.item) != t {
+			return ErrSystemNamespace{Namespace: namespace}
+		}
+	}
+	return nil
+}
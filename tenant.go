@@ -0,0 +1,275 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// tenantPlaceholder - placeholder of tenant ID in template of tenant namespace
+const tenantPlaceholder = "%s"
+
+// defaultTenantsParallelism - default count of tenants, which are processed concurrently by ForEachTenant
+const defaultTenantsParallelism = 4
+
+// tenantTemplate - namespace of each tenant, which is registered by RegisterTenantNamespace
+type tenantTemplate struct {
+	// template is split by placeholder of tenant ID
+	prefix string
+	suffix string
+	opts   NamespaceOptions
+	s      interface{}
+}
+
+// tenantRegistry - templates of tenant namespaces by their short names
+type tenantRegistry struct {
+	lock      sync.RWMutex
+	templates map[string]*tenantTemplate
+}
+
+// Tenant - namespaces of tenant, which are resolved by short names of templates (see RegisterTenantNamespace).
+// Namespace of tenant is opened with struct and options of its template on the first use
+type Tenant struct {
+	db *Reindexer
+	id string
+}
+
+func (t *tenantTemplate) namespace(tenantID string) string {
+	return t.prefix + tenantID + t.suffix
+}
+
+// tenantID returns ID of tenant, which namespace name is, or false, if it's not namespace of the template
+func (t *tenantTemplate) tenantID(namespace string) (string, bool) {
+	if len(namespace) <= len(t.prefix)+len(t.suffix) || !strings.HasPrefix(namespace, t.prefix) || !strings.HasSuffix(namespace, t.suffix) {
+		return "", false
+	}
+	id := namespace[len(t.prefix) : len(namespace)-len(t.suffix)]
+	return id, checkTenantID(id) == nil
+}
+
+// tenantShortName returns short name of template: template without placeholder and separators around it, e.g. 'orders' for 'orders_%s'
+func tenantShortName(template string) string {
+	return strings.Trim(strings.Replace(template, tenantPlaceholder, "", 1), "_-")
+}
+
+// invalidNsChar returns the first character of s, which is not allowed in names of tenant namespaces (letters, digits, '_' and '-'), or -1
+func invalidNsChar(s string) rune {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return c
+		}
+	}
+	return -1
+}
+
+// checkTenantID checks, that tenant ID is valid part of namespace name
+func checkTenantID(id string) error {
+	if id == "" {
+		return bindings.NewError("rq: Tenant ID is empty", ErrCodeParams)
+	}
+	if c := invalidNsChar(id); c >= 0 {
+		return bindings.NewError(fmt.Sprintf("rq: Invalid character '%c' in tenant ID '%s'", c, id), ErrCodeParams)
+	}
+	return nil
+}
+
+func (db *reindexerImpl) registerTenantNamespace(template string, opts *NamespaceOptions, s interface{}) error {
+	template = strings.ToLower(template)
+	if strings.Count(template, tenantPlaceholder) != 1 || strings.Count(template, "%") != 1 {
+		return bindings.NewError(fmt.Sprintf("rq: Template of tenant namespace '%s' must contain single %s", template, tenantPlaceholder), ErrCodeParams)
+	}
+	name := tenantShortName(template)
+	if name == "" {
+		return bindings.NewError(fmt.Sprintf("rq: Template of tenant namespace '%s' has empty short name", template), ErrCodeParams)
+	}
+	pos := strings.Index(template, tenantPlaceholder)
+	t := &tenantTemplate{prefix: template[:pos], suffix: template[pos+len(tenantPlaceholder):], opts: *opts, s: s}
+	if c := invalidNsChar(t.prefix + t.suffix); c >= 0 {
+		return bindings.NewError(fmt.Sprintf("rq: Invalid character '%c' in template of tenant namespace '%s'", c, template), ErrCodeParams)
+	}
+
+	db.tenants.lock.Lock()
+	defer db.tenants.lock.Unlock()
+	if registered, ok := db.tenants.templates[name]; ok && registered.prefix+tenantPlaceholder+registered.suffix != template {
+		return bindings.NewError(fmt.Sprintf("rq: Short name '%s' of tenant namespace '%s' is already used by '%s'", name, template,
+			registered.prefix+tenantPlaceholder+registered.suffix), ErrCodeParams)
+	}
+	if db.tenants.templates == nil {
+		db.tenants.templates = make(map[string]*tenantTemplate)
+	}
+	db.tenants.templates[name] = t
+	return nil
+}
+
+// tenantTemplate returns template by its short name or by template itself
+func (db *reindexerImpl) tenantTemplate(name string) (*tenantTemplate, error) {
+	name = strings.ToLower(name)
+	if strings.Contains(name, tenantPlaceholder) {
+		name = tenantShortName(name)
+	}
+	db.tenants.lock.RLock()
+	t, ok := db.tenants.templates[name]
+	db.tenants.lock.RUnlock()
+	if !ok {
+		return nil, bindings.NewError(fmt.Sprintf("rq: Tenant namespace '%s' is not registered", name), ErrCodeNotFound)
+	}
+	return t, nil
+}
+
+// RegisterTenantNamespace registers template of namespace of each tenant, e.g. 'orders_%s', where '%s' is replaced by tenant ID.
+// Namespaces of templates are resolved by Tenant by short names: templates without '%s' and separators around it, e.g. 'orders'.
+// Namespace of tenant is opened with s and opts on the first use. Template may be registered again with other struct or options
+func (db *Reindexer) RegisterTenantNamespace(template string, opts *NamespaceOptions, s interface{}) error {
+	return db.impl.registerTenantNamespace(template, opts, s)
+}
+
+// Tenant returns namespaces of tenant. Tenant ID may contain letters, digits, '_' and '-', it's lowercased as names of namespaces.
+// Namespaces are opened with context of Reindexer (see WithContext)
+func (db *Reindexer) Tenant(tenantID string) *Tenant {
+	return &Tenant{db: db, id: strings.ToLower(tenantID)}
+}
+
+// Tenants returns sorted IDs of tenants, which namespaces of template (or of its short name) exist in DB, including the ones, which are not opened by this client
+func (db *Reindexer) Tenants(ctx context.Context, template string) ([]string, error) {
+	t, err := db.impl.tenantTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	descs, err := db.impl.query(NamespacesNamespaceName).ExecCtx(ctx).FetchAll()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(descs))
+	for _, desc := range descs {
+		if desc, ok := desc.(*NamespaceDescription); ok {
+			if id, ok := t.tenantID(strings.ToLower(desc.Name)); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// ForEachTenant calls fn for each tenant of template (see Tenants) with at most parallelism concurrent calls (default 4).
+// Tenant, which is passed to fn, uses ctx of fn. The first error cancels ctx of the other calls and is returned, calls, which are not started yet, are skipped
+func (db *Reindexer) ForEachTenant(ctx context.Context, template string, parallelism int, fn func(ctx context.Context, tenant *Tenant) error) error {
+	ids, err := db.Tenants(ctx, template)
+	if err != nil {
+		return err
+	}
+	if parallelism <= 0 {
+		parallelism = defaultTenantsParallelism
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	sem := make(chan struct{}, parallelism)
+	for _, id := range ids {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(id string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(ctx, db.WithContext(ctx).Tenant(id)); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(id)
+	}
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// ID returns ID of tenant
+func (t *Tenant) ID() string {
+	return t.id
+}
+
+// Namespace returns name of namespace of tenant by short name of template (or by template itself) and opens it, if it's not opened yet.
+// Concurrent first uses of namespace share one open
+func (t *Tenant) Namespace(name string) (string, error) {
+	if err := checkTenantID(t.id); err != nil {
+		return "", err
+	}
+	tmpl, err := t.db.impl.tenantTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	namespace := tmpl.namespace(t.id)
+	opts := tmpl.opts
+	return namespace, t.db.impl.openNamespace(t.db.ctx, namespace, &opts, tmpl.s)
+}
+
+// Query creates query to namespace of tenant (see Namespace). Query fails on execution, if namespace can't be opened
+func (t *Tenant) Query(name string) *Query {
+	namespace, err := t.Namespace(name)
+	q := t.db.Query(namespace)
+	q.nsErr = err
+	return q
+}
+
+// Upsert (Insert or Update) item to namespace of tenant
+func (t *Tenant) Upsert(name string, item interface{}, precepts ...string) error {
+	namespace, err := t.Namespace(name)
+	if err != nil {
+		return err
+	}
+	return t.db.Upsert(namespace, item, precepts...)
+}
+
+// Insert item to namespace of tenant. Return 0, if item was not inserted, 1 if item was inserted
+func (t *Tenant) Insert(name string, item interface{}, precepts ...string) (int, error) {
+	namespace, err := t.Namespace(name)
+	if err != nil {
+		return 0, err
+	}
+	return t.db.Insert(namespace, item, precepts...)
+}
+
+// Update item in namespace of tenant by PK. Return 0, if no item was updated, 1 if item was updated
+func (t *Tenant) Update(name string, item interface{}, precepts ...string) (int, error) {
+	namespace, err := t.Namespace(name)
+	if err != nil {
+		return 0, err
+	}
+	return t.db.Update(namespace, item, precepts...)
+}
+
+// Delete item from namespace of tenant by PK
+func (t *Tenant) Delete(name string, item interface{}, precepts ...string) error {
+	namespace, err := t.Namespace(name)
+	if err != nil {
+		return err
+	}
+	return t.db.Delete(namespace, item, precepts...)
+}
+
+// BeginTx starts transaction of namespace of tenant
+func (t *Tenant) BeginTx(name string) (*Tx, error) {
+	namespace, err := t.Namespace(name)
+	if err != nil {
+		return nil, err
+	}
+	return t.db.BeginTx(namespace)
+}
@@ -0,0 +1,95 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+func TestActivityLabel(t *testing.T) {
+	type Item struct {
+		ID   int    `reindex:"id,,pk"`
+		Name string `reindex:"name,tree"`
+	}
+	const ns = "test_items_activity_label"
+	const label = "req-12345 tenant=acme"
+
+	srv := helpers.TestServer{T: t, RpcPort: "6692", HttpPort: "9992", DbName: "reindex_test_activity_label"}
+	require.NoError(t, srv.Run())
+	defer srv.Clean()
+	defer srv.Stop()
+
+	var lock sync.Mutex
+	slowLabels := map[string]bool{}
+	db := reindexer.NewReindex(fmt.Sprintf("cproto://%s/%s_%s", srv.Addr(), srv.DbName, srv.RpcPort), reindexer.WithCreateDBIfMissing(),
+		reindexer.WithSlowRPCHook(0, func(rpc reindexer.SlowRPC) {
+			lock.Lock()
+			slowLabels[rpc.Label] = true
+			lock.Unlock()
+		}))
+	defer db.Close()
+	require.NoError(t, db.Upsert(reindexer.ConfigNamespaceName, reindexer.DBConfigItem{
+		Type:      "profiling",
+		Profiling: &reindexer.DBProfilingConfig{ActivityStats: true},
+	}))
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), Item{}))
+	tx := db.MustBeginTx(ns)
+	for i := 0; i < 50000; i++ {
+		tx.Upsert(&Item{ID: i, Name: fmt.Sprintf("name_%d", i)})
+	}
+	require.NoError(t, tx.Commit())
+
+	// checkLabel runs long queries until one of them is found in activity stats with expected label
+	checkLabel := func(t *testing.T, ctx context.Context, expected string, query func() *reindexer.Query) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for ctx.Err() == nil {
+				query().Where("name", reindexer.LIKE, "%9%").Sort("name", true).ExecCtx(ctx).Close()
+			}
+		}()
+
+		found := false
+		for deadline := time.Now().Add(10 * time.Second); !found && time.Now().Before(deadline); {
+			items, err := db.Query(reindexer.ActivitystatsNamespaceName).Exec().FetchAll()
+			require.NoError(t, err)
+			for _, item := range items {
+				activity := item.(*reindexer.ActivityStat)
+				if strings.Contains(activity.Query, ns) {
+					assert.True(t, strings.HasSuffix(activity.Client, " "+expected), "unexpected client: '%s'", activity.Client)
+					found = true
+				}
+			}
+		}
+		cancel()
+		<-done
+		assert.True(t, found, "query with label was not found in %s", reindexer.ActivitystatsNamespaceName)
+		lock.Lock()
+		assert.True(t, slowLabels[expected], "label is not passed to slow RPC hook")
+		lock.Unlock()
+	}
+
+	t.Run("context label", func(t *testing.T) {
+		checkLabel(t, reindexer.CtxWithActivityLabel(context.Background(), label), label, func() *reindexer.Query {
+			return db.Query(ns)
+		})
+	})
+
+	t.Run("query label", func(t *testing.T) {
+		const queryLabel = "service=billing\nendpoint='/invoices'"
+		checkLabel(t, reindexer.CtxWithActivityLabel(context.Background(), label), "service=billing_endpoint=_/invoices_", func() *reindexer.Query {
+			return db.Query(ns).Label(queryLabel)
+		})
+	})
+}
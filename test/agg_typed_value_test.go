@@ -0,0 +1,90 @@
+package reindexer
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemAggTypedValue struct {
+	ID        int     `reindex:"id,,pk"`
+	Timestamp int64   `reindex:"timestamp"`
+	Price     float64 `reindex:"price"`
+	Name      string  `reindex:"name"`
+}
+
+func TestAggTypedValue(t *testing.T) {
+	const ns = "test_items_agg_typed_value"
+	const itemsCount = 100
+	// Not representable by float64 exactly
+	const tsBase = int64(1<<53) + 1
+
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemAggTypedValue{}))
+	defer DBD.DropNamespace(ns)
+	for i := 0; i < itemsCount; i++ {
+		require.NoError(t, DBD.Upsert(ns, &TestItemAggTypedValue{
+			ID:        i,
+			Timestamp: tsBase + int64(i)*2,
+			Price:     -10.25 + float64(i)*0.5,
+			Name:      fmt.Sprintf("name_%03d", itemsCount-i),
+		}))
+	}
+
+	aggregate := func(q *reindexer.Query, field string) (min, max reindexer.Variant) {
+		q.AggregateMin(field)
+		q.AggregateMax(field)
+		it := q.Limit(0).Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		aggs := it.AggResults()
+		require.Equal(t, 2, len(aggs))
+		assert.Equal(t, []string{field}, aggs[0].Fields)
+		min, ok := aggs[0].AggMinValue()
+		require.True(t, ok)
+		_, ok = aggs[0].AggMaxValue()
+		assert.False(t, ok)
+		max, ok = aggs[1].AggMaxValue()
+		require.True(t, ok)
+		return
+	}
+
+	t.Run("int64 field", func(t *testing.T) {
+		min, max := aggregate(DBD.Query(ns), "timestamp")
+		assert.Equal(t, reflect.Int64, min.Kind())
+		assert.Equal(t, tsBase, min.AsInt64())
+		assert.Equal(t, tsBase+int64(itemsCount-1)*2, max.AsInt64())
+		assert.Equal(t, fmt.Sprint(tsBase), min.AsString())
+	})
+
+	t.Run("float field", func(t *testing.T) {
+		min, max := aggregate(DBD.Query(ns), "price")
+		assert.Equal(t, reflect.Float64, min.Kind())
+		assert.Equal(t, -10.25, min.AsFloat64())
+		assert.Equal(t, -10.25+float64(itemsCount-1)*0.5, max.AsFloat64())
+		assert.Equal(t, int64(-10), min.AsInt64())
+	})
+
+	t.Run("string field", func(t *testing.T) {
+		min, max := aggregate(DBD.Query(ns), "name")
+		assert.Equal(t, reflect.String, min.Kind())
+		assert.Equal(t, "name_001", min.AsString())
+		assert.Equal(t, fmt.Sprintf("name_%03d", itemsCount), max.AsString())
+	})
+
+	t.Run("no values", func(t *testing.T) {
+		q := DBD.Query(ns).WhereInt("id", reindexer.LT, 0)
+		q.AggregateMin("timestamp")
+		it := q.Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		aggs := it.AggResults()
+		require.Equal(t, 1, len(aggs))
+		_, ok := aggs[0].AggMinValue()
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,68 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+func TestAsyncWrites(t *testing.T) {
+	type Item struct {
+		ID   int    `reindex:"id,,pk"`
+		Name string `reindex:"name"`
+	}
+	const ns = "test_items_async_writes"
+	const itemsCount = 5000
+
+	srv := helpers.TestServer{T: t, RpcPort: "6694", HttpPort: "9994", DbName: "reindex_test_async_writes"}
+	require.NoError(t, srv.Run())
+	defer srv.Clean()
+	defer func() { srv.Stop() }()
+
+	db := reindexer.NewReindex(fmt.Sprintf("cproto://%s/%s_%s", srv.Addr(), srv.DbName, srv.RpcPort), reindexer.WithCreateDBIfMissing())
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), Item{}))
+
+	t.Run("all completions are delivered", func(t *testing.T) {
+		done := make(chan error, itemsCount)
+		for i := 0; i < itemsCount; i++ {
+			require.NoError(t, db.UpsertAsync(context.Background(), ns, &Item{ID: i, Name: fmt.Sprintf("name_%d", i)}, func(err error) { done <- err }))
+		}
+		for i := 0; i < itemsCount; i++ {
+			require.NoError(t, <-done)
+		}
+		it := db.Query(ns).Limit(0).ReqTotal().Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		assert.Equal(t, itemsCount, it.TotalCount())
+	})
+
+	t.Run("connection error", func(t *testing.T) {
+		var completed, failed int32
+		for i := 0; i < itemsCount; i++ {
+			err := db.UpsertAsync(context.Background(), ns, &Item{ID: i}, func(err error) {
+				if err != nil {
+					atomic.AddInt32(&failed, 1)
+				}
+				atomic.AddInt32(&completed, 1)
+			})
+			if err != nil {
+				atomic.AddInt32(&failed, 1)
+				atomic.AddInt32(&completed, 1)
+			}
+			if i == itemsCount/2 {
+				require.NoError(t, srv.Stop())
+			}
+		}
+		// Close returns only after all the completions were called
+		require.NoError(t, db.Close())
+		assert.Equal(t, int32(itemsCount), atomic.LoadInt32(&completed))
+		assert.NotEqual(t, int32(0), atomic.LoadInt32(&failed))
+	})
+}
@@ -0,0 +1,80 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+func TestBindingStats(t *testing.T) {
+	type Item struct {
+		ID int `reindex:"id,,pk"`
+	}
+
+	srv := helpers.TestServer{T: t, RpcPort: "6697", HttpPort: "9997", DbName: "reindex_test_binding_stats"}
+	require.NoError(t, srv.Run())
+	defer srv.Clean()
+	defer srv.Stop()
+
+	dsn := fmt.Sprintf("cproto://127.0.0.1:%s/%s_%s", srv.RpcPort, srv.DbName, srv.RpcPort)
+	db := reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing(), reindexer.WithConnPoolSize(2))
+	require.NoError(t, db.Status().Err)
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace("items", reindexer.DefaultNamespaceOptions(), Item{}))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, db.Upsert("items", Item{ID: i}))
+	}
+	_, err := db.Query("items").Exec().FetchAll()
+	require.NoError(t, err)
+
+	stats := db.BindingStats()
+	require.Len(t, stats, 2)
+	var requests int64
+	for i, st := range stats {
+		assert.Equal(t, i, st.Slot)
+		assert.Equal(t, srv.Addr(), st.Addr)
+		assert.NoError(t, st.Err)
+		assert.True(t, st.BytesRead > 0 && st.BytesWritten > 0)
+		requests += st.Requests
+	}
+	assert.True(t, requests >= 11, "requests: %d", requests)
+}
+
+// Statistics of connections are logged every 10 seconds
+func Example_bindingStats() {
+	db := reindexer.NewReindex("cproto://127.0.0.1:6534/testdb")
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, st := range db.BindingStats() {
+				state := "ok"
+				if st.Err != nil {
+					state = st.Err.Error()
+				}
+				log.Printf("conn #%d %s: %s, in flight %d, requests %d (%d failed), reconnects %d, read %d B, written %d B, seq wait %v, last read %v",
+					st.Slot, st.Addr, state, st.InFlight, st.Requests, st.Errors, st.Reconnects, st.BytesRead, st.BytesWritten, st.SeqWait,
+					time.Since(st.LastRead).Round(time.Millisecond))
+			}
+		}
+	}()
+
+	// ... work with db
+}
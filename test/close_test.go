@@ -0,0 +1,138 @@
+package reindexer
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/restream/reindexer"
+	_ "github.com/restream/reindexer/bindings/builtinserver"
+	"github.com/restream/reindexer/bindings/builtinserver/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type CloseTestItem struct {
+	ID int `reindex:"id,,pk"`
+}
+
+// goroutineStacks returns stacks of the current goroutines by their IDs
+func goroutineStacks() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		// Header of stack is 'goroutine <id> [<state>]:'
+		if fields := strings.Fields(stack); len(fields) > 1 && fields[0] == "goroutine" {
+			stacks[fields[1]] = stack
+		}
+	}
+	return stacks
+}
+
+// hasReindexerFrames checks, if stack has function frames of reindexer packages (except of this test package) or is created by them
+func hasReindexerFrames(stack string) bool {
+	for _, line := range strings.Split(stack, "\n") {
+		// Lines of files of frames are indented, lines of functions are not
+		if strings.HasPrefix(line, "\t") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "created by ")
+		if strings.HasPrefix(line, "github.com/restream/reindexer") && !strings.HasPrefix(line, "github.com/restream/reindexer/test.") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNoLeaks fails test, if goroutines of reindexer, which are not in base, are still running after timeout.
+// Goroutines of the other packages (e.g. of testing and of cgo runtime of builtinserver) are ignored, so they don't break the check
+func checkNoLeaks(t *testing.T, base map[string]string, timeout time.Duration) {
+	var leaked []string
+	for deadline := time.Now().Add(timeout); ; time.Sleep(10 * time.Millisecond) {
+		leaked = leaked[:0]
+		for id, stack := range goroutineStacks() {
+			if _, ok := base[id]; !ok && hasReindexerFrames(stack) {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	assert.Empty(t, leaked, "goroutines of closed clients are still running")
+}
+
+func TestCloseLeaks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg := config.DefaultServerConfig()
+	cfg.Net.HTTPAddr = "0:29100"
+	cfg.Net.RPCAddr = "0:26546"
+	cfg.Storage.Path = "/tmp/rx_close_test_server"
+	os.RemoveAll(cfg.Storage.Path)
+
+	srv, err := reindexer.Open(ctx, "builtinserver://xxx", reindexer.WithServerConfig(time.Second*100, cfg))
+	require.NoError(t, err)
+	defer srv.Close()
+	require.NoError(t, srv.OpenNamespace("items", reindexer.DefaultNamespaceOptions(), CloseTestItem{}))
+
+	t.Run("cproto", func(t *testing.T) {
+		base := goroutineStacks()
+		for i := 0; i < 100; i++ {
+			// Write journal and local replica run goroutines of the client itself
+			db, err := reindexer.Open(ctx, "cproto://127.0.0.1:26546/xxx", reindexer.WithConnPoolSize(2),
+				reindexer.WithWriteJournal(func(reindexer.WriteJournalEntry) {}, 0, false))
+			require.NoError(t, err)
+			require.NoError(t, db.OpenNamespace("items", reindexer.DefaultNamespaceOptions().LocalReplica(reindexer.LocalReplicaOptions{}), CloseTestItem{}))
+			require.NoError(t, db.Upsert("items", &CloseTestItem{ID: i}))
+			require.NoError(t, db.Close())
+		}
+		checkNoLeaks(t, base, 5*time.Second)
+	})
+
+	t.Run("cproto failed connect", func(t *testing.T) {
+		base := goroutineStacks()
+		for i := 0; i < 100; i++ {
+			_, err := reindexer.Open(ctx, "cproto://127.0.0.1:26546/unknown_db")
+			require.Error(t, err)
+		}
+		checkNoLeaks(t, base, 5*time.Second)
+	})
+
+	t.Run("builtin", func(t *testing.T) {
+		base := goroutineStacks()
+		for i := 0; i < 100; i++ {
+			db, err := reindexer.Open(ctx, "builtin:///tmp/rx_close_test_builtin")
+			require.NoError(t, err)
+			require.NoError(t, db.OpenNamespace("items", reindexer.DefaultNamespaceOptions(), CloseTestItem{}))
+			require.NoError(t, db.Close())
+		}
+		checkNoLeaks(t, base, 5*time.Second)
+	})
+
+	t.Run("calls after close", func(t *testing.T) {
+		db, err := reindexer.Open(ctx, "cproto://127.0.0.1:26546/xxx")
+		require.NoError(t, err)
+		require.NoError(t, db.OpenNamespace("items", reindexer.DefaultNamespaceOptions(), CloseTestItem{}))
+		require.NoError(t, db.CloseCtx(ctx))
+
+		assert.Equal(t, reindexer.ErrClientClosed, db.Ping())
+		assert.Equal(t, reindexer.ErrClientClosed, db.Status().Err)
+		assert.Equal(t, reindexer.ErrClientClosed, db.Upsert("items", &CloseTestItem{ID: 1}))
+		_, err = db.Query("items").Exec().FetchAll()
+		assert.Equal(t, reindexer.ErrClientClosed, err)
+		assert.NoError(t, db.Close())
+	})
+}
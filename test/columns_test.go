@@ -0,0 +1,123 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemColumnsNested struct {
+	Level int    `json:"level"`
+	Code  string `json:"code"`
+}
+
+type TestItemColumns struct {
+	ID      int                    `reindex:"id,,pk"`
+	Price   float64                `reindex:"price,tree"`
+	Sku     string                 `reindex:"sku"`
+	Count   uint32                 `reindex:"count"`
+	Active  bool                   `json:"active"`
+	Weight  *float64               `json:"weight,omitempty"`
+	Nested  *TestItemColumnsNested `json:"nested,omitempty"`
+	Tags    []string               `reindex:"tags"`
+	Comment string                 `json:"comment"`
+}
+
+func TestExecToColumns(t *testing.T) {
+	const ns = "test_items_columns"
+	const itemsCount = 1000
+	ctx := context.Background()
+
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemColumns{}))
+	defer DBD.DropNamespace(ns)
+	for i := 0; i < itemsCount; i++ {
+		item := &TestItemColumns{
+			ID:      i,
+			Price:   float64(i) * 1.25,
+			Sku:     fmt.Sprintf("sku_%d", i),
+			Count:   uint32(i * 1000003),
+			Active:  i%3 == 0,
+			Tags:    []string{"a", "b"},
+			Comment: "comment",
+		}
+		if i%2 == 0 {
+			w := float64(i) / 10
+			item.Weight = &w
+		}
+		if i%5 != 0 {
+			item.Nested = &TestItemColumnsNested{Level: i % 7, Code: fmt.Sprintf("c%d", i%3)}
+		}
+		require.NoError(t, DBD.Upsert(ns, item))
+	}
+
+	query := func() *reindexer.Query {
+		return DBD.Query(ns).WhereInt("id", reindexer.LT, 900).Sort("id", false).FetchCount(100)
+	}
+	items, err := query().Exec().FetchAll()
+	require.NoError(t, err)
+
+	t.Run("values match full decoding", func(t *testing.T) {
+		fields := []string{"id", "price", "sku", "count", "active", "weight", "nested.level", "nested.code"}
+		cols, err := query().ExecToColumns(ctx, reindexer.ColumnsSpec{
+			Fields:   fields,
+			Defaults: map[string]interface{}{"weight": -1.0, "nested.level": -1},
+		})
+		require.NoError(t, err)
+		require.Equal(t, len(items), cols.Len())
+		for _, field := range fields {
+			assert.Len(t, cols.Valid(field), (len(items)+63)/64)
+		}
+
+		for i, it := range items {
+			item := it.(*TestItemColumns)
+			assert.Equal(t, int64(item.ID), cols.Int64("id")[i])
+			assert.Equal(t, item.Price, cols.Float64("price")[i])
+			assert.Equal(t, item.Sku, cols.String("sku")[i])
+			assert.Equal(t, int64(item.Count), cols.Int64("count")[i])
+			assert.Equal(t, item.Active, cols.Bool("active")[i])
+			if item.Weight != nil {
+				assert.True(t, cols.IsValid("weight", i))
+				assert.Equal(t, *item.Weight, cols.Float64("weight")[i])
+			} else {
+				assert.False(t, cols.IsValid("weight", i))
+				assert.Equal(t, -1.0, cols.Float64("weight")[i])
+			}
+			if item.Nested != nil {
+				assert.True(t, cols.IsValid("nested.level", i))
+				assert.Equal(t, int64(item.Nested.Level), cols.Int64("nested.level")[i])
+				assert.Equal(t, item.Nested.Code, cols.String("nested.code")[i])
+			} else {
+				assert.False(t, cols.IsValid("nested.code", i))
+				assert.Equal(t, int64(-1), cols.Int64("nested.level")[i])
+				assert.Equal(t, "", cols.String("nested.code")[i])
+			}
+		}
+		assert.Nil(t, cols.Float64("id"), "column has another type")
+		assert.Nil(t, cols.Int64("missing"))
+	})
+
+	t.Run("empty results", func(t *testing.T) {
+		cols, err := DBD.Query(ns).WhereInt("id", reindexer.GT, itemsCount).ExecToColumns(ctx, reindexer.ColumnsSpec{Fields: []string{"id"}})
+		require.NoError(t, err)
+		assert.Equal(t, 0, cols.Len())
+		assert.Empty(t, cols.Int64("id"))
+	})
+
+	t.Run("invalid spec", func(t *testing.T) {
+		for _, spec := range []reindexer.ColumnsSpec{
+			{},
+			{Fields: []string{"unknown"}},
+			{Fields: []string{"tags"}},
+			{Fields: []string{"nested"}},
+			{Fields: []string{"sku"}, Defaults: map[string]interface{}{"sku": 1}},
+		} {
+			_, err := DBD.Query(ns).ExecToColumns(ctx, spec)
+			assert.Error(t, err, "%+v", spec)
+		}
+	})
+}
@@ -87,3 +87,74 @@ func TestSetDefaultQueryDebug(t *testing.T) {
 		assert.True(t, found)
 	})
 }
+
+func TestNamespaceCacheConfig(t *testing.T) {
+	const ns = "test_items_cache_config"
+
+	require.NoError(t, DB.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemSimple{}))
+	defer DB.DropNamespace(ns)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, DB.Upsert(ns, &TestItemSimple{ID: i, Year: 2000 + i%2}))
+	}
+	nsConfig := func() *reindexer.DBNamespacesConfig {
+		item, err := DB.Reindexer.Query(reindexer.ConfigNamespaceName).WhereString("type", reindexer.EQ, "namespaces").Exec().FetchOne()
+		require.NoError(t, err)
+		for _, nsCfg := range *item.(*reindexer.DBConfigItem).Namespaces {
+			if nsCfg.Namespace == ns {
+				return &nsCfg
+			}
+		}
+		return nil
+	}
+	cachedTotal := func() int {
+		it := DB.Query(ns).WhereInt("year", reindexer.EQ, 2000).Limit(1).CachedTotal().Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		return it.TotalCount()
+	}
+
+	t.Run("default config is read from '*'", func(t *testing.T) {
+		require.Nil(t, nsConfig())
+		cfg, err := DB.GetNamespaceCacheConfig(ns)
+		require.NoError(t, err)
+		assert.Equal(t, reindexer.NamespaceCacheConfig{JoinCacheMode: reindexer.JoinCacheModeOff, QueryCountCache: true}, cfg)
+	})
+
+	t.Run("config is set", func(t *testing.T) {
+		expected := reindexer.NamespaceCacheConfig{JoinCacheMode: reindexer.JoinCacheModeOn, QueryCountCache: false}
+		require.NoError(t, DB.SetNamespaceCacheConfig(ns, expected))
+		cfg, err := DB.GetNamespaceCacheConfig(ns)
+		require.NoError(t, err)
+		assert.Equal(t, expected, cfg)
+
+		nsCfg := nsConfig()
+		require.NotNil(t, nsCfg)
+		assert.Equal(t, "on", nsCfg.JoinCacheMode)
+		require.NotNil(t, nsCfg.QueryCountCache)
+		assert.False(t, *nsCfg.QueryCountCache)
+		assert.Equal(t, "none", nsCfg.LogLevel, "other options are copied from '*'")
+
+		// Total count is calculated by each query without cache
+		assert.Equal(t, 5, cachedTotal())
+		require.NoError(t, DB.Upsert(ns, &TestItemSimple{ID: 10, Year: 2000}))
+		assert.Equal(t, 6, cachedTotal())
+	})
+
+	t.Run("join cache mode is changed", func(t *testing.T) {
+		require.NoError(t, DB.SetNamespaceCacheConfig(ns, reindexer.NamespaceCacheConfig{JoinCacheMode: reindexer.JoinCacheModeAggressive, QueryCountCache: true}))
+		nsCfg := nsConfig()
+		require.NotNil(t, nsCfg)
+		assert.Equal(t, "aggressive", nsCfg.JoinCacheMode)
+		require.NotNil(t, nsCfg.QueryCountCache)
+		assert.True(t, *nsCfg.QueryCountCache)
+	})
+
+	t.Run("invalid join cache mode", func(t *testing.T) {
+		err := DB.SetNamespaceCacheConfig(ns, reindexer.NamespaceCacheConfig{JoinCacheMode: "always"})
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+		cfg, err := DB.GetNamespaceCacheConfig(ns)
+		require.NoError(t, err)
+		assert.Equal(t, reindexer.JoinCacheModeAggressive, cfg.JoinCacheMode)
+	})
+}
@@ -0,0 +1,66 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+func TestConnectRetries(t *testing.T) {
+	type Item struct {
+		ID int `reindex:"id,,pk"`
+	}
+
+	srv := helpers.TestServer{T: t, RpcPort: "6671", HttpPort: "9971", DbName: "reindex_test_connect_retries"}
+	dsn := fmt.Sprintf("cproto://127.0.0.1:%s/%s_%s", srv.RpcPort, srv.DbName, srv.RpcPort)
+	defer srv.Clean()
+
+	db := reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing(), reindexer.WithConnectRetries(20, 500*time.Millisecond, 30*time.Second))
+	require.NotNil(t, db)
+	defer db.Close()
+	require.NoError(t, db.Status().Err)
+	assert.Regexp(t, `^connecting \(attempt \d+/20\)$`, db.Status().CProto.ConnState)
+
+	type result struct {
+		items []interface{}
+		err   error
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		// First request is started before server and must wait for connection
+		err := db.OpenNamespace("items", reindexer.DefaultNamespaceOptions(), Item{})
+		if err == nil {
+			err = db.Upsert("items", Item{ID: 1})
+		}
+		if err != nil {
+			resCh <- result{err: err}
+			return
+		}
+		items, err := db.Query("items").Exec().FetchAll()
+		resCh <- result{items, err}
+	}()
+
+	time.Sleep(2 * time.Second)
+	require.NoError(t, srv.Run())
+	defer srv.Stop()
+
+	res := <-resCh
+	require.NoError(t, res.err)
+	assert.Equal(t, 1, len(res.items))
+	assert.Equal(t, "", db.Status().CProto.ConnState)
+	assert.Equal(t, srv.Addr(), db.Status().CProto.ConnAddr)
+}
+
+func TestConnectRetriesBudget(t *testing.T) {
+	start := time.Now()
+	_, err := reindexer.Open(context.Background(), "cproto://127.0.0.1:6672/db", reindexer.WithConnectRetries(3, 100*time.Millisecond, 0))
+	require.Error(t, err)
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+}
@@ -0,0 +1,180 @@
+package reindexer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/reindexertest"
+)
+
+type TestItemDecodeArena struct {
+	ID   int      `reindex:"id,,pk"`
+	Name string   `reindex:"name"`
+	Tags []string `reindex:"tags"`
+	Nums []int    `reindex:"nums"`
+}
+
+const testDecodeArenaNs = "test_items_decode_arena"
+
+func newTestItemDecodeArena(i int) *TestItemDecodeArena {
+	return &TestItemDecodeArena{
+		ID:   i,
+		Name: fmt.Sprintf("item_%d_%s", i, strings.Repeat("n", i%100)),
+		Tags: []string{fmt.Sprintf("tag_%d", i%10), strings.Repeat("t", i%30)},
+		Nums: []int{i, -i},
+	}
+}
+
+func TestDecodeArena(t *testing.T) {
+	DBD.DropNamespace(testDecodeArenaNs)
+	require.NoError(t, DBD.OpenNamespace(testDecodeArenaNs, reindexer.DefaultNamespaceOptions(), TestItemDecodeArena{}))
+	defer DBD.DropNamespace(testDecodeArenaNs)
+	const count = 3000
+	for i := 0; i < count; i++ {
+		require.NoError(t, DBD.Upsert(testDecodeArenaNs, newTestItemDecodeArena(i)))
+	}
+
+	// Results are fetched by small chunks, while arena grows by a few blocks
+	for pass := 0; pass < 2; pass++ {
+		it := DBD.Query(testDecodeArenaNs).Sort("id", false).FetchCount(100).Exec().WithArena(true)
+		items := make([]*TestItemDecodeArena, 0, count)
+		for it.Next() {
+			items = append(items, it.Object().(*TestItemDecodeArena))
+		}
+		require.NoError(t, it.Error())
+		require.Len(t, items, count)
+		for i, item := range items {
+			require.Equal(t, newTestItemDecodeArena(i), item)
+		}
+		it.Close()
+	}
+}
+
+type TestItemDecodeArenaInMemory struct {
+	ID     int       `reindex:"id,,pk" json:"id"`
+	Name   string    `reindex:"name" json:"name"`
+	Tags   []string  `json:"tags"`
+	Scores []int     `json:"scores"`
+	Counts []int64   `json:"counts"`
+	Rates  []float64 `json:"rates"`
+}
+
+const testDecodeArenaInMemoryNs = "test_items_decode_arena_in_memory"
+
+func newTestItemDecodeArenaInMemory(i int) *TestItemDecodeArenaInMemory {
+	return &TestItemDecodeArenaInMemory{
+		ID:     i,
+		Name:   fmt.Sprintf("item_%d_%s", i, strings.Repeat("x", 50+i%50)),
+		Tags:   []string{fmt.Sprintf("tag%d", i%10), fmt.Sprintf("tag%d", i%7)},
+		Scores: []int{i, i * 2, i * 3},
+		Counts: []int64{int64(i) << 33},
+		Rates:  []float64{float64(i) / 4},
+	}
+}
+
+func prepareDecodeArenaInMemoryNs(t testing.TB, db *reindexer.Reindexer, count int) {
+	require.NoError(t, db.OpenNamespace(testDecodeArenaInMemoryNs, reindexer.DefaultNamespaceOptions(), TestItemDecodeArenaInMemory{}))
+	for i := 0; i < count; i++ {
+		require.NoError(t, db.Upsert(testDecodeArenaInMemoryNs, newTestItemDecodeArenaInMemory(i)))
+	}
+}
+
+func TestDecodeArenaInMemory(t *testing.T) {
+	db := reindexertest.NewInMemory()
+	defer db.Close()
+	// Strings of items take a few blocks of arena
+	const count = 2000
+	prepareDecodeArenaInMemoryNs(t, db, count)
+	query := func() *reindexer.Query {
+		return db.Query(testDecodeArenaInMemoryNs).Sort("id", false)
+	}
+
+	t.Run("retained objects", func(t *testing.T) {
+		for pass := 0; pass < 2; pass++ {
+			// The second pass reuses blocks of pooled iterator
+			it := query().Exec().WithArena(true)
+			items := make([]*TestItemDecodeArenaInMemory, 0, count)
+			for it.Next() {
+				items = append(items, it.Object().(*TestItemDecodeArenaInMemory))
+			}
+			require.NoError(t, it.Error())
+			require.Len(t, items, count)
+			for i, item := range items {
+				require.Equal(t, newTestItemDecodeArenaInMemory(i), item)
+			}
+			it.Close()
+		}
+	})
+
+	t.Run("next object", func(t *testing.T) {
+		it := query().Exec().WithArena(true)
+		defer it.Close()
+		items := make([]TestItemDecodeArenaInMemory, 0, count)
+		for {
+			var item TestItemDecodeArenaInMemory
+			if !it.NextObj(&item) {
+				break
+			}
+			items = append(items, item)
+		}
+		require.NoError(t, it.Error())
+		require.Len(t, items, count)
+		for i := range items {
+			require.Equal(t, *newTestItemDecodeArenaInMemory(i), items[i])
+		}
+	})
+
+	t.Run("fetch all", func(t *testing.T) {
+		items, err := query().Exec().WithArena(true).FetchAll()
+		require.NoError(t, err)
+		// Objects are not overwritten by the next query
+		it := query().Exec().WithArena(true)
+		for it.Next() {
+		}
+		it.Close()
+		require.Len(t, items, count)
+		for i, item := range items {
+			require.Equal(t, newTestItemDecodeArenaInMemory(i), item)
+		}
+	})
+
+	t.Run("object cache", func(t *testing.T) {
+		it := query().Exec().AllowUnsafe(true).WithArena(true)
+		for it.Next() {
+		}
+		it.Close()
+		// Cached objects are decoded bypassing arena, so they are still valid
+		items, err := query().Exec().AllowUnsafe(true).FetchAll()
+		require.NoError(t, err)
+		require.Len(t, items, count)
+		for i, item := range items {
+			require.Equal(t, newTestItemDecodeArenaInMemory(i), item)
+		}
+	})
+}
+
+func BenchmarkDecodeArenaInMemory(b *testing.B) {
+	db := reindexertest.NewInMemory()
+	defer db.Close()
+	const count = 1000
+	prepareDecodeArenaInMemoryNs(b, db, count)
+	for _, arena := range []bool{false, true} {
+		b.Run(fmt.Sprintf("arena=%v", arena), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				it := db.Query(testDecodeArenaInMemoryNs).Exec().WithArena(arena)
+				var item TestItemDecodeArenaInMemory
+				for it.NextObj(&item) {
+				}
+				if it.Error() != nil {
+					b.Fatal(it.Error())
+				}
+				it.Close()
+			}
+		})
+	}
+}
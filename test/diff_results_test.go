@@ -0,0 +1,55 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemDiffResults struct {
+	ID   int    `reindex:"id,,pk"`
+	Name string `reindex:"name"`
+}
+
+func TestDiffResults(t *testing.T) {
+	const ns = "test_items_diff_results"
+	ctx := context.Background()
+
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemDiffResults{}))
+	defer DBD.DropNamespace(ns)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, DBD.Upsert(ns, &TestItemDiffResults{ID: i, Name: "item"}))
+	}
+	key := func(item *TestItemDiffResults) string { return fmt.Sprint(item.ID) }
+
+	added, _, _, snapshot, err := reindexer.DiffResults(ctx, DBD, DBD.Query(ns), reindexer.Snapshot{}, key)
+	require.NoError(t, err)
+	assert.Len(t, added, 10)
+	require.NotEmpty(t, snapshot.State)
+
+	t.Run("unchanged namespace is not queried", func(t *testing.T) {
+		added, changed, removed, next, err := reindexer.DiffResults(ctx, DBD, DBD.Query(ns), snapshot, key)
+		require.NoError(t, err)
+		assert.Empty(t, added)
+		assert.Empty(t, changed)
+		assert.Empty(t, removed)
+		assert.Equal(t, snapshot, next)
+	})
+
+	t.Run("changed namespace", func(t *testing.T) {
+		require.NoError(t, DBD.Upsert(ns, &TestItemDiffResults{ID: 1, Name: "changed"}))
+		require.NoError(t, DBD.Delete(ns, &TestItemDiffResults{ID: 2}))
+		added, changed, removed, next, err := reindexer.DiffResults(ctx, DBD, DBD.Query(ns), snapshot, key)
+		require.NoError(t, err)
+		assert.Empty(t, added)
+		require.Len(t, changed, 1)
+		assert.Equal(t, "changed", changed[0].Name)
+		assert.Equal(t, []string{"2"}, removed)
+		assert.NotEqual(t, snapshot.State, next.State)
+	})
+}
@@ -0,0 +1,166 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemDistinctValues struct {
+	ID       int      `reindex:"id,,pk"`
+	Category string   `reindex:"category"`
+	Tags     []string `reindex:"tags"`
+	Rank     int64    `reindex:"rank"`
+	Price    float64  `reindex:"price"`
+	OwnerID  int      `reindex:"owner_id"`
+}
+
+type TestItemDistinctValuesOwner struct {
+	ID     int  `reindex:"id,,pk"`
+	Active bool `reindex:"active"`
+}
+
+func TestDistinctValues(t *testing.T) {
+	const ns = "test_items_distinct_values"
+	const nsOwners = "test_items_distinct_values_owners"
+	const itemsCount = 500
+	const ownersCount = 10
+	ctx := context.Background()
+	categories := []string{"books", "Книги", "本", "música", "🍕 pizza", "ελληνικά"}
+
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemDistinctValues{}))
+	defer DBD.DropNamespace(ns)
+	require.NoError(t, DBD.OpenNamespace(nsOwners, reindexer.DefaultNamespaceOptions(), TestItemDistinctValuesOwner{}))
+	defer DBD.DropNamespace(nsOwners)
+
+	owners := make(map[int]bool, ownersCount)
+	for i := 0; i < ownersCount; i++ {
+		owners[i] = i%3 == 0
+		require.NoError(t, DBD.Upsert(nsOwners, &TestItemDistinctValuesOwner{ID: i, Active: owners[i]}))
+	}
+	items := make([]*TestItemDistinctValues, 0, itemsCount)
+	for i := 0; i < itemsCount; i++ {
+		item := &TestItemDistinctValues{
+			ID:       i,
+			Category: categories[i%len(categories)],
+			Tags:     []string{fmt.Sprintf("tag_%d", i%7), fmt.Sprintf("тег_%d", i%11)},
+			Rank:     int64(1<<53) + int64(i%13),
+			Price:    float64(i%17) + 0.25,
+			OwnerID:  (i * 7) % ownersCount,
+		}
+		require.NoError(t, DBD.Upsert(ns, item))
+		items = append(items, item)
+	}
+
+	// expected computes brute-force set of field values of items, which match filter
+	expected := func(match func(item *TestItemDistinctValues) bool, values func(item *TestItemDistinctValues) []string) []string {
+		set := make(map[string]struct{})
+		for _, item := range items {
+			if match(item) {
+				for _, v := range values(item) {
+					set[v] = struct{}{}
+				}
+			}
+		}
+		res := make([]string, 0, len(set))
+		for v := range set {
+			res = append(res, v)
+		}
+		sort.Strings(res)
+		return res
+	}
+	sorted := func(values []string) []string {
+		res := append([]string{}, values...)
+		sort.Strings(res)
+		return res
+	}
+	all := func(item *TestItemDistinctValues) bool { return true }
+
+	t.Run("string field", func(t *testing.T) {
+		values, err := DBD.Query(ns).DistinctValues(ctx, "category")
+		require.NoError(t, err)
+		assert.Equal(t, len(categories), len(values))
+		assert.Equal(t, expected(all, func(item *TestItemDistinctValues) []string { return []string{item.Category} }), sorted(values))
+	})
+
+	t.Run("array field is flattened", func(t *testing.T) {
+		match := func(item *TestItemDistinctValues) bool { return item.ID < 100 }
+		values, err := DBD.Query(ns).WhereInt("id", reindexer.LT, 100).DistinctValues(ctx, "tags")
+		require.NoError(t, err)
+		assert.Equal(t, expected(match, func(item *TestItemDistinctValues) []string { return item.Tags }), sorted(values))
+	})
+
+	t.Run("typed values", func(t *testing.T) {
+		ranks, err := DBD.Query(ns).DistinctValuesInt64(ctx, "rank")
+		require.NoError(t, err)
+		rankStrs := make([]string, 0, len(ranks))
+		for _, r := range ranks {
+			rankStrs = append(rankStrs, strconv.FormatInt(r, 10))
+		}
+		assert.Equal(t, expected(all, func(item *TestItemDistinctValues) []string { return []string{strconv.FormatInt(item.Rank, 10)} }), sorted(rankStrs))
+
+		prices, err := DBD.Query(ns).DistinctValuesFloat64(ctx, "price")
+		require.NoError(t, err)
+		priceSet := make(map[float64]struct{})
+		for _, item := range items {
+			priceSet[item.Price] = struct{}{}
+		}
+		assert.Equal(t, len(priceSet), len(prices))
+		for _, p := range prices {
+			assert.Contains(t, priceSet, p)
+		}
+
+		_, err = DBD.Query(ns).DistinctValuesInt64(ctx, "category")
+		assert.Error(t, err)
+	})
+
+	t.Run("join as filter", func(t *testing.T) {
+		match := func(item *TestItemDistinctValues) bool { return owners[item.OwnerID] && item.ID%2 == 0 }
+		q := DBD.Query(ns).Where("id", reindexer.SET, evenIDs(itemsCount))
+		q.InnerJoin(DBD.Query(nsOwners).WhereBool("active", reindexer.EQ, true), "owners").On("owner_id", reindexer.EQ, "id")
+		values, err := q.DistinctValues(ctx, "tags")
+		require.NoError(t, err)
+		assert.Equal(t, expected(match, func(item *TestItemDistinctValues) []string { return item.Tags }), sorted(values))
+	})
+
+	t.Run("joins as filters with select filter of the query", func(t *testing.T) {
+		match := func(item *TestItemDistinctValues) bool { return !owners[item.OwnerID] && item.ID < 250 }
+		q := DBD.Query(ns).Select("id", "owner_id").WhereInt("id", reindexer.LT, 250)
+		q.InnerJoin(DBD.Query(nsOwners).WhereBool("active", reindexer.EQ, false), "owners").On("owner_id", reindexer.EQ, "id")
+		values, err := q.DistinctValues(ctx, "category")
+		require.NoError(t, err)
+		assert.Equal(t, expected(match, func(item *TestItemDistinctValues) []string { return []string{item.Category} }), sorted(values))
+
+		match = func(item *TestItemDistinctValues) bool { return owners[item.OwnerID] && item.Price > 10 }
+		q = DBD.Query(ns).Where("price", reindexer.GT, 10).Limit(5)
+		q.InnerJoin(DBD.Query(nsOwners).WhereBool("active", reindexer.EQ, true), "owners").On("owner_id", reindexer.EQ, "id")
+		ranks, err := q.DistinctValuesInt64(ctx, "rank")
+		require.NoError(t, err)
+		rankStrs := make([]string, 0, len(ranks))
+		for _, r := range ranks {
+			rankStrs = append(rankStrs, strconv.FormatInt(r, 10))
+		}
+		assert.Equal(t, expected(match, func(item *TestItemDistinctValues) []string { return []string{strconv.FormatInt(item.Rank, 10)} }), sorted(rankStrs))
+	})
+
+	t.Run("empty result", func(t *testing.T) {
+		values, err := DBD.Query(ns).WhereString("category", reindexer.EQ, "missing").DistinctValues(ctx, "category")
+		require.NoError(t, err)
+		assert.Empty(t, values)
+	})
+}
+
+func evenIDs(count int) []int {
+	ids := make([]int, 0, count/2)
+	for i := 0; i < count; i += 2 {
+		ids = append(ids, i)
+	}
+	return ids
+}
@@ -0,0 +1,118 @@
+package reindexer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemDryRun struct {
+	ID   int    `reindex:"id,,pk"`
+	Year int    `reindex:"year,tree"`
+	Name string `reindex:"name"`
+}
+
+func TestUpdateDeleteExplain(t *testing.T) {
+	const ns = "test_items_dry_run"
+	const itemsCount = 100
+
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemDryRun{}))
+	defer DBD.DropNamespace(ns)
+	for i := 0; i < itemsCount; i++ {
+		require.NoError(t, DBD.Upsert(ns, &TestItemDryRun{ID: i, Year: 2000 + i%20, Name: fmt.Sprintf("name_%d", i)}))
+	}
+	const minYear = 2015
+	matched := itemsCount / 20 * (2019 - minYear)
+
+	selectors := func(explain *reindexer.ExplainResults) (res []string) {
+		require.NotNil(t, explain)
+		for _, s := range explain.Selectors {
+			res = append(res, s.Field+":"+s.Method)
+		}
+		return res
+	}
+	selectExplain := func() []string {
+		it := DBD.Query(ns).WhereInt("year", reindexer.GT, minYear).Explain().Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		assert.Equal(t, matched, it.Count())
+		explain, err := it.GetExplainResults()
+		require.NoError(t, err)
+		return selectors(explain)
+	}
+	countNamed := func(name string) int {
+		it := DBD.Query(ns).WhereString("name", reindexer.EQ, name).Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		return it.Count()
+	}
+	expected := selectExplain()
+	require.NotEmpty(t, expected)
+
+	t.Run("update dry run", func(t *testing.T) {
+		it := DBD.Query(ns).WhereInt("year", reindexer.GT, minYear).Set("name", "updated").Explain().DryRun().Update()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		assert.Equal(t, matched, it.Count())
+		explain, err := it.GetExplainResults()
+		require.NoError(t, err)
+		assert.Equal(t, expected, selectors(explain))
+		assert.Equal(t, 0, countNamed("updated"))
+	})
+
+	t.Run("update dry run is executed by server", func(t *testing.T) {
+		// Emulation for old servers always requests explain, so its absence means the native path was used
+		it := DBD.Query(ns).WhereInt("year", reindexer.GT, minYear).Set("name", "updated").DryRun().Update()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		assert.Equal(t, matched, it.Count())
+		explain, err := it.GetExplainResults()
+		require.NoError(t, err)
+		assert.Nil(t, explain)
+		assert.Equal(t, 0, countNamed("updated"))
+
+		count, err := DBD.Query(ns).WhereInt("year", reindexer.GT, minYear).DryRun().Delete()
+		require.NoError(t, err)
+		assert.Equal(t, matched, count)
+		assert.Equal(t, expected, selectExplain())
+	})
+
+	t.Run("delete dry run", func(t *testing.T) {
+		count, explain, err := DBD.Query(ns).WhereInt("year", reindexer.GT, minYear).DryRun().DeleteExplain()
+		require.NoError(t, err)
+		assert.Equal(t, matched, count)
+		assert.Equal(t, expected, selectors(explain))
+		assert.Equal(t, expected, selectExplain())
+	})
+
+	t.Run("update explain", func(t *testing.T) {
+		it := DBD.Query(ns).WhereInt("year", reindexer.GT, minYear).Set("name", "updated").Explain().Update()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		assert.Equal(t, matched, it.Count())
+		explain, err := it.GetExplainResults()
+		require.NoError(t, err)
+		assert.Equal(t, expected, selectors(explain))
+		assert.Equal(t, matched, countNamed("updated"))
+	})
+
+	t.Run("delete explain", func(t *testing.T) {
+		count, explain, err := DBD.Query(ns).WhereInt("year", reindexer.GT, minYear).DeleteExplain()
+		require.NoError(t, err)
+		assert.Equal(t, matched, count)
+		assert.Equal(t, expected, selectors(explain))
+		assert.Equal(t, 0, countNamed("updated"))
+	})
+
+	t.Run("dry run in transaction", func(t *testing.T) {
+		tx, err := DBD.BeginTx(ns)
+		require.NoError(t, err)
+		defer tx.Rollback()
+		_, err = tx.Query().WhereInt("year", reindexer.GT, minYear).DryRun().Delete()
+		assert.Error(t, err)
+	})
+}
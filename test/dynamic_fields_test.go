@@ -0,0 +1,85 @@
+package reindexer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemDynamicFields struct {
+	ID     int                    `reindex:"id,,pk"`
+	Custom map[string]interface{} `json:"custom"`
+}
+
+func TestDynamicFields(t *testing.T) {
+	const ns = "test_items_dynamic_fields"
+	const itemsCount = 100
+
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemDynamicFields{}))
+	defer DBD.DropNamespace(ns)
+
+	newItem := func(id int) *TestItemDynamicFields {
+		return &TestItemDynamicFields{ID: id, Custom: map[string]interface{}{
+			"score": id,
+			"color": fmt.Sprintf("color_%d", id%10),
+			"tags":  []interface{}{"tag_a", fmt.Sprintf("tag_%d", id%3)},
+		}}
+	}
+	getItem := func(id int) *TestItemDynamicFields {
+		item, found := DBD.Query(ns).WhereInt("id", reindexer.EQ, id).Get()
+		require.True(t, found, "item %d not found", id)
+		return item.(*TestItemDynamicFields)
+	}
+
+	for i := 0; i < itemsCount; i++ {
+		require.NoError(t, DBD.Upsert(ns, newItem(i)))
+	}
+	assert.Equal(t, newItem(42), getItem(42))
+
+	// Indexes on nested fields are created after data exists
+	require.NoError(t, DBD.AddIndex(ns,
+		reindexer.IndexDef{Name: "custom.score", JSONPaths: []string{"custom.score"}, IndexType: "tree", FieldType: "int"},
+		reindexer.IndexDef{Name: "custom.tags", JSONPaths: []string{"custom.tags"}, IndexType: "hash", FieldType: "string", IsArray: true},
+	))
+
+	t.Run("indexed values are decoded into map", func(t *testing.T) {
+		assert.Equal(t, newItem(42), getItem(42))
+	})
+
+	t.Run("query uses index", func(t *testing.T) {
+		// String value is converted to the index type
+		it := DBD.Query(ns).Where("custom.score", reindexer.EQ, "42").Explain().Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		require.True(t, it.Next())
+		assert.Equal(t, newItem(42), it.Object())
+		assert.False(t, it.Next())
+
+		explain, err := it.GetExplainResults()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(explain.Selectors))
+		assert.Equal(t, "custom.score", explain.Selectors[0].Field)
+		assert.Equal(t, "index", explain.Selectors[0].Method)
+
+		items, err := DBD.Query(ns).Where("custom.tags", reindexer.EQ, "tag_1").Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, itemsCount/3, len(items))
+	})
+
+	t.Run("later documents add new fields", func(t *testing.T) {
+		item := newItem(itemsCount)
+		item.Custom["brand_new"] = "value"
+		item.Custom["nested"] = map[string]interface{}{"deep": 1.5, "flag": true}
+		require.NoError(t, DBD.Upsert(ns, item))
+		assert.Equal(t, item, getItem(itemsCount))
+
+		items, err := DBD.Query(ns).Where("custom.score", reindexer.GE, int64(itemsCount)).Exec().FetchAll()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(items))
+		assert.Equal(t, item, items[0])
+	})
+}
@@ -0,0 +1,134 @@
+package reindexer
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+type TestItemFetchBudget struct {
+	ID   int    `reindex:"id,,pk"`
+	Data string `reindex:"-"`
+}
+
+func TestFetchBudget(t *testing.T) {
+	const ns = "test_items_fetch_budget"
+	const itemsCount = 1000
+	const budget = 64 * 1024
+	const cmdFetchResults = 50
+
+	srv := helpers.TestServer{T: t, RpcPort: "6696", HttpPort: "9996", DbName: "reindex_test_fetch_budget"}
+	require.NoError(t, srv.Run())
+	defer srv.Clean()
+	defer srv.Stop()
+
+	var fetches int64
+	db := reindexer.NewReindex(fmt.Sprintf("cproto://%s/%s_%s", srv.Addr(), srv.DbName, srv.RpcPort), reindexer.WithCreateDBIfMissing(),
+		reindexer.WithSlowRPCHook(0, func(rpc reindexer.SlowRPC) {
+			if rpc.Cmd == cmdFetchResults {
+				atomic.AddInt64(&fetches, 1)
+			}
+		}))
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemFetchBudget{}))
+	for i := 0; i < itemsCount; i++ {
+		// Mostly small items with large one in each 20 items
+		size := 100 + rand.Intn(1000)
+		if i%20 == 0 {
+			size = 10000 + rand.Intn(10000)
+		}
+		require.NoError(t, db.Upsert(ns, &TestItemFetchBudget{ID: i, Data: strings.Repeat("x", size)}))
+	}
+
+	// fetchChunks iterates results and returns sizes of data in each reply. Hook is called by the iterating goroutine
+	fetchChunks := func(t *testing.T, q *reindexer.Query) (chunks []int) {
+		it := q.Sort("id", false).Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		seen := atomic.LoadInt64(&fetches)
+		chunk := 0
+		for it.Next() {
+			if cur := atomic.LoadInt64(&fetches); cur != seen {
+				chunks = append(chunks, chunk)
+				seen, chunk = cur, 0
+			}
+			chunk += len(it.Object().(*TestItemFetchBudget).Data)
+		}
+		require.NoError(t, it.Error())
+		return append(chunks, chunk)
+	}
+
+	naive := fetchChunks(t, db.Query(ns).FetchCount(10))
+	require.Len(t, naive, itemsCount/10)
+
+	for run := 0; run < 2; run++ {
+		chunks := fetchChunks(t, db.Query(ns).FetchBudgetBytes(budget))
+		for i, chunk := range chunks {
+			if i == 0 && run == 0 {
+				// The first reply of the namespace is conservative, while size of items is unknown
+				continue
+			}
+			if i == len(chunks)-1 {
+				continue
+			}
+			assert.True(t, chunk <= 2*budget && chunk >= budget/2, "run %d, chunk %d: %d bytes", run, i, chunk)
+		}
+		assert.True(t, len(chunks) < len(naive)/2, "run %d: %d round trips, %d with FetchCount", run, len(chunks), len(naive))
+		items, err := db.Query(ns).FetchBudgetBytes(budget).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Len(t, items, itemsCount)
+	}
+}
+
+func TestBulkFetchThreshold(t *testing.T) {
+	const ns = "test_items_bulk_fetch"
+
+	srv := helpers.TestServer{T: t, RpcPort: "6698", HttpPort: "9998", DbName: "reindex_test_bulk_fetch"}
+	require.NoError(t, srv.Run())
+	defer srv.Clean()
+	defer srv.Stop()
+
+	db := reindexer.NewReindex(fmt.Sprintf("cproto://%s/%s_%s", srv.Addr(), srv.DbName, srv.RpcPort), reindexer.WithCreateDBIfMissing(),
+		reindexer.WithConnPoolSize(1), reindexer.WithBulkConns(1, 0), reindexer.WithBulkFetchThreshold(4000))
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemFetchBudget{}))
+	for i := 0; i < 200; i++ {
+		require.NoError(t, db.Upsert(ns, &TestItemFetchBudget{ID: i, Data: strings.Repeat("x", 100)}))
+	}
+	// bulkRequests returns count of requests, which were sent via bulk connections
+	bulkRequests := func() (requests int64) {
+		for _, st := range db.BindingStats() {
+			if st.Bulk {
+				requests += st.Requests
+			}
+		}
+		return requests
+	}
+	bulk := func(q *reindexer.Query) bool {
+		before := bulkRequests()
+		_, err := q.Exec().FetchAll()
+		require.NoError(t, err)
+		return bulkRequests() > before
+	}
+
+	assert.False(t, bulk(db.Query(ns).FetchCount(-1)), "size of items is unknown before the first results")
+	assert.True(t, bulk(db.Query(ns)), "100 items of default fetch count exceed threshold")
+	assert.False(t, bulk(db.Query(ns).FetchCount(10)))
+	assert.False(t, bulk(db.Query(ns).Limit(10)))
+	assert.False(t, bulk(db.Query(ns).FetchCount(-1)), "size of chunk of all the results is unknown")
+	assert.False(t, bulk(db.Query(ns).FetchCount(-1).Limit(10)))
+	assert.True(t, bulk(db.Query(ns).FetchCount(-1).Limit(150)))
+
+	before := bulkRequests()
+	_, err := db.Query(ns).ExecToJson().FetchAll()
+	require.NoError(t, err)
+	assert.Equal(t, before, bulkRequests(), "results of JSON queries are returned by one reply")
+}
@@ -0,0 +1,119 @@
+package reindexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemFloatFormat struct {
+	ID      int       `reindex:"id,,pk" json:"id"`
+	Score   float64   `reindex:"score,tree,precision=4" json:"score"`
+	Rounded float64   `reindex:"rounded,,precision=2,round" json:"rounded"`
+	Raw     float64   `reindex:"raw" json:"raw"`
+	Coords  []float64 `reindex:",,precision=3,nan=null" json:"coords"`
+	Nested  struct {
+		Value float64 `reindex:",,precision=1" json:"value"`
+	} `json:"nested"`
+	Strict float64 `reindex:",,nan=error" json:"strict"`
+}
+
+type TestItemFloatFormatInvalid struct {
+	ID   int    `reindex:"id,,pk"`
+	Name string `reindex:"name,,precision=2"`
+}
+
+type TestItemFloatFormatInvalidPrecision struct {
+	ID    int     `reindex:"id,,pk"`
+	Score float64 `reindex:"score,,precision=x"`
+}
+
+// fetchFloatFormatJSON returns JSON fields of the single item as raw number literals
+func fetchFloatFormatJSON(t *testing.T, db *reindexer.Reindexer, ns string, id int) map[string]interface{} {
+	it := db.Query(ns).WhereInt("id", reindexer.EQ, id).ExecToJson()
+	defer it.Close()
+	require.True(t, it.Next())
+	dec := json.NewDecoder(bytes.NewReader(it.JSON()))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	require.NoError(t, dec.Decode(&doc))
+	return doc
+}
+
+func TestFloatFormat(t *testing.T) {
+	const ns = "test_items_float_format"
+
+	t.Run("field precision", func(t *testing.T) {
+		require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemFloatFormat{}))
+		defer DBD.DropNamespace(ns)
+		item := &TestItemFloatFormat{ID: 1, Score: 1.23456789, Rounded: 2.345678, Raw: 0.1 + 0.2, Coords: []float64{1.23456, 2.0001}}
+		item.Nested.Value = 3.14159
+		require.NoError(t, DBD.Upsert(ns, item))
+
+		doc := fetchFloatFormatJSON(t, DBD, ns, 1)
+		assert.Equal(t, json.Number("1.2346"), doc["score"])
+		assert.Equal(t, json.Number("2.35"), doc["rounded"])
+		assert.Equal(t, json.Number("0.30000000000000004"), doc["raw"])
+		assert.Equal(t, []interface{}{json.Number("1.235"), json.Number("2")}, doc["coords"])
+		assert.Equal(t, map[string]interface{}{"value": json.Number("3.1")}, doc["nested"])
+
+		// Only 'round' fields are stored rounded, conditions use full precision of the others
+		stored, found := DBD.Query(ns).WhereInt("id", reindexer.EQ, 1).Get()
+		require.True(t, found)
+		assert.Equal(t, 1.23456789, stored.(*TestItemFloatFormat).Score)
+		assert.Equal(t, 2.35, stored.(*TestItemFloatFormat).Rounded)
+		_, found = DBD.Query(ns).WhereDouble("score", reindexer.EQ, 1.23456789).Get()
+		assert.True(t, found)
+		_, found = DBD.Query(ns).WhereDouble("score", reindexer.EQ, 1.2346).Get()
+		assert.False(t, found)
+		_, found = DBD.Query(ns).WhereDouble("rounded", reindexer.EQ, 2.35).Get()
+		assert.True(t, found)
+	})
+
+	t.Run("NaN policy", func(t *testing.T) {
+		require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemFloatFormat{}))
+		defer DBD.DropNamespace(ns)
+
+		err := DBD.Upsert(ns, &TestItemFloatFormat{ID: 1, Strict: math.NaN()})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "strict")
+		_, found := DBD.Query(ns).WhereInt("id", reindexer.EQ, 1).Get()
+		assert.False(t, found)
+
+		require.NoError(t, DBD.Upsert(ns, &TestItemFloatFormat{ID: 2, Raw: math.Inf(1), Coords: []float64{math.NaN(), 1.5}}))
+		doc := fetchFloatFormatJSON(t, DBD, ns, 2)
+		assert.Nil(t, doc["raw"])
+		assert.Equal(t, []interface{}{nil, json.Number("1.5")}, doc["coords"])
+	})
+
+	t.Run("client default", func(t *testing.T) {
+		db := reindexer.NewReindex("builtin:///tmp/reindex_test_float_format/", reindexer.WithFloatFormat(reindexer.FloatFormat{Precision: 1, NaN: reindexer.FloatNaNError}))
+		defer db.Close()
+		require.NoError(t, db.Status().Err)
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemFloatFormat{}))
+		defer db.DropNamespace(ns)
+
+		require.NoError(t, db.Upsert(ns, &TestItemFloatFormat{ID: 1, Score: 1.23456789, Raw: 0.1 + 0.2, Coords: []float64{math.NaN()}}))
+		doc := fetchFloatFormatJSON(t, db, ns, 1)
+		assert.Equal(t, json.Number("1.2346"), doc["score"])
+		assert.Equal(t, json.Number("0.3"), doc["raw"])
+		assert.Equal(t, []interface{}{nil}, doc["coords"])
+
+		assert.Error(t, db.Upsert(ns, &TestItemFloatFormat{ID: 2, Raw: math.NaN()}))
+
+		db2 := reindexer.NewReindex("builtin:///tmp/reindex_test_float_format/", reindexer.WithFloatFormat(reindexer.FloatFormat{Precision: -1}))
+		defer db2.Close()
+		assert.Error(t, db2.Status().Err)
+	})
+
+	t.Run("invalid tags", func(t *testing.T) {
+		assert.Error(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemFloatFormatInvalid{}))
+		assert.Error(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemFloatFormatInvalidPrecision{}))
+	})
+}
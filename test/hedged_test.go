@@ -0,0 +1,55 @@
+package reindexer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemHedged struct {
+	ID   int    `reindex:"id,,pk"`
+	Name string `reindex:"name"`
+}
+
+const testHedgedNs = "test_items_hedged"
+
+func TestHedgedQuery(t *testing.T) {
+	DBD.DropNamespace(testHedgedNs)
+	require.NoError(t, DBD.OpenNamespace(testHedgedNs, reindexer.DefaultNamespaceOptions(), TestItemHedged{}))
+	defer DBD.DropNamespace(testHedgedNs)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, DBD.Upsert(testHedgedNs, &TestItemHedged{ID: i, Name: "item" + strings.Repeat("_", i%5)}))
+	}
+
+	t.Run("point lookup", func(t *testing.T) {
+		// Tiny delay hedges almost each select
+		for i := 0; i < 100; i++ {
+			item, ok := DBD.Query(testHedgedNs).WhereInt("id", reindexer.EQ, i).Hedged(time.Microsecond).Get()
+			require.True(t, ok)
+			assert.Equal(t, i, item.(*TestItemHedged).ID)
+		}
+	})
+
+	t.Run("fetches of results", func(t *testing.T) {
+		items, err := DBD.Query(testHedgedNs).Sort("id", false).FetchCount(10).Hedged(time.Microsecond).Exec().FetchAll()
+		require.NoError(t, err)
+		require.Len(t, items, 100)
+		for i, item := range items {
+			assert.Equal(t, i, item.(*TestItemHedged).ID)
+		}
+	})
+
+	t.Run("delete isn't hedged", func(t *testing.T) {
+		count, err := DBD.Query(testHedgedNs).WhereInt("id", reindexer.LT, 10).Hedged(time.Microsecond).Delete()
+		require.NoError(t, err)
+		assert.Equal(t, 10, count)
+		it := DBD.Query(testHedgedNs).Hedged(time.Microsecond).Limit(0).ReqTotal().MustExec()
+		defer it.Close()
+		assert.Equal(t, 90, it.TotalCount())
+	})
+}
@@ -0,0 +1,129 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+const (
+	fakeCprotoMagic   = 0xEEDD1132
+	fakeCprotoVersion = 0x103
+	fakeCprotoHdrLen  = 16
+	fakeCmdLogin      = 1
+)
+
+// FakeServer is a minimal cproto server, which reports Version and Capabilities on login and answers 'OK' with empty result on any other command.
+// If ErrCode is not zero, other commands fail with it
+type FakeServer struct {
+	Version      string
+	Capabilities int64
+	ErrCode      int
+	l            net.Listener
+	conns        []net.Conn
+	cmds         []uint16
+	lock         sync.Mutex
+}
+
+// Run starts listening on random local port
+func (srv *FakeServer) Run() (err error) {
+	if srv.l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return err
+	}
+	go srv.acceptLoop()
+	return nil
+}
+
+// Addr returns address of listening socket
+func (srv *FakeServer) Addr() string {
+	return srv.l.Addr().String()
+}
+
+// Stop closes listener and all the accepted connections
+func (srv *FakeServer) Stop() {
+	srv.l.Close()
+	srv.lock.Lock()
+	for _, conn := range srv.conns {
+		conn.Close()
+	}
+	srv.conns = nil
+	srv.lock.Unlock()
+}
+
+// Commands returns codes of received commands, except login
+func (srv *FakeServer) Commands() []uint16 {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return append([]uint16(nil), srv.cmds...)
+}
+
+func (srv *FakeServer) acceptLoop() {
+	for {
+		conn, err := srv.l.Accept()
+		if err != nil {
+			return
+		}
+		srv.lock.Lock()
+		srv.conns = append(srv.conns, conn)
+		srv.lock.Unlock()
+		go srv.serve(conn)
+	}
+}
+
+func (srv *FakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	hdr := make([]byte, fakeCprotoHdrLen)
+	for {
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			return
+		}
+		cmd := binary.LittleEndian.Uint16(hdr[6:])
+		size := binary.LittleEndian.Uint32(hdr[8:])
+		seq := binary.LittleEndian.Uint32(hdr[12:])
+		if _, err := io.CopyN(ioutil.Discard, conn, int64(size)); err != nil {
+			return
+		}
+
+		body := cjson.NewSerializer(nil)
+		if cmd == fakeCmdLogin {
+			// error code and message
+			body.PutVarUInt(0)
+			body.PutVString("")
+			body.PutVarUInt(3)
+			body.PutVarUInt(uint64(bindings.ValueString))
+			body.PutVString(srv.Version)
+			body.PutVarUInt(uint64(bindings.ValueInt64))
+			body.PutVarInt(1)
+			body.PutVarUInt(uint64(bindings.ValueInt64))
+			body.PutVarInt(srv.Capabilities)
+		} else {
+			srv.lock.Lock()
+			srv.cmds = append(srv.cmds, cmd)
+			srv.lock.Unlock()
+			if srv.ErrCode != 0 {
+				body.PutVarUInt(uint64(srv.ErrCode))
+				body.PutVString("fake error")
+			} else {
+				body.PutVarUInt(0)
+				body.PutVString("")
+			}
+			body.PutVarUInt(0)
+		}
+
+		reply := cjson.NewSerializer(nil)
+		reply.PutUInt32(fakeCprotoMagic)
+		reply.PutUInt16(fakeCprotoVersion)
+		reply.PutUInt16(cmd)
+		reply.PutUInt32(uint32(len(body.Bytes())))
+		reply.PutUInt32(seq)
+		reply.Write(body.Bytes())
+		if _, err := conn.Write(reply.Bytes()); err != nil {
+			return
+		}
+	}
+}
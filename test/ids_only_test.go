@@ -0,0 +1,146 @@
+package reindexer
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemIDsOnly struct {
+	ID      int      `reindex:"id,,pk"`
+	Year    int      `reindex:"year,tree"`
+	GroupID int      `reindex:"group_id"`
+	Data    []string `reindex:"-"`
+}
+
+type TestItemIDsOnlyGroup struct {
+	ID     int  `reindex:"id,,pk"`
+	Active bool `reindex:"active"`
+}
+
+type TestItemIDsOnlyComposite struct {
+	Key   int      `reindex:"key"`
+	Value string   `reindex:"value"`
+	_     struct{} `reindex:"key+value,,composite,pk"`
+}
+
+const (
+	testIDsOnlyNs          = "test_items_ids_only"
+	testIDsOnlyGroupNs     = "test_items_ids_only_groups"
+	testIDsOnlyCompositeNs = "test_items_ids_only_composite"
+)
+
+func prepareIDsOnly(t testing.TB, itemsCount int) {
+	DBD.DropNamespace(testIDsOnlyNs)
+	require.NoError(t, DBD.OpenNamespace(testIDsOnlyNs, reindexer.DefaultNamespaceOptions(), TestItemIDsOnly{}))
+	DBD.DropNamespace(testIDsOnlyGroupNs)
+	require.NoError(t, DBD.OpenNamespace(testIDsOnlyGroupNs, reindexer.DefaultNamespaceOptions(), TestItemIDsOnlyGroup{}))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, DBD.Upsert(testIDsOnlyGroupNs, &TestItemIDsOnlyGroup{ID: i, Active: i%2 == 0}))
+	}
+	// Wide documents
+	data := make([]string, 50)
+	for i := range data {
+		data[i] = strings.Repeat("d", 100)
+	}
+	for i := 0; i < itemsCount; i++ {
+		require.NoError(t, DBD.Upsert(testIDsOnlyNs, &TestItemIDsOnly{ID: i, Year: 2000 + i%20, GroupID: i % 10, Data: data}))
+	}
+}
+
+func TestExecIDsOnly(t *testing.T) {
+	const itemsCount = 1000
+	prepareIDsOnly(t, itemsCount)
+	defer DBD.DropNamespace(testIDsOnlyNs)
+	defer DBD.DropNamespace(testIDsOnlyGroupNs)
+
+	// fullIDs returns primary keys of results of full fetch. Items are inserted in order of primary keys, so their ids are the same
+	fullIDs := func(q *reindexer.Query) (ids []int64) {
+		items, err := q.Exec().FetchAll()
+		require.NoError(t, err)
+		for _, item := range items {
+			ids = append(ids, int64(item.(*TestItemIDsOnly).ID))
+		}
+		return ids
+	}
+	queries := map[string]func() *reindexer.Query{
+		"filter": func() *reindexer.Query {
+			return DBD.Query(testIDsOnlyNs).WhereInt("year", reindexer.GE, 2015)
+		},
+		"sort and limit": func() *reindexer.Query {
+			return DBD.Query(testIDsOnlyNs).WhereInt("year", reindexer.LT, 2010).Sort("year", true).Sort("id", false).Offset(10).Limit(200)
+		},
+		"join as filter": func() *reindexer.Query {
+			q := DBD.Query(testIDsOnlyNs).WhereInt("year", reindexer.EQ, 2001)
+			q.InnerJoin(DBD.Query(testIDsOnlyGroupNs).WhereBool("active", reindexer.EQ, true), "groups").On("group_id", reindexer.EQ, "id")
+			return q
+		},
+	}
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			expected := fullIDs(query())
+			require.NotEmpty(t, expected)
+			ids, err := query().FetchCount(50).ExecIDsOnly(context.Background())
+			require.NoError(t, err)
+			if name != "sort and limit" {
+				sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+				sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+			}
+			assert.Equal(t, expected, ids)
+		})
+	}
+
+	t.Run("primary keys", func(t *testing.T) {
+		pks, err := queries["sort and limit"]().ExecPKs(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, fullIDs(queries["sort and limit"]()), pks.Int64("id"))
+	})
+
+	t.Run("composite primary keys", func(t *testing.T) {
+		DBD.DropNamespace(testIDsOnlyCompositeNs)
+		require.NoError(t, DBD.OpenNamespace(testIDsOnlyCompositeNs, reindexer.DefaultNamespaceOptions(), TestItemIDsOnlyComposite{}))
+		defer DBD.DropNamespace(testIDsOnlyCompositeNs)
+		for i := 0; i < 10; i++ {
+			require.NoError(t, DBD.Upsert(testIDsOnlyCompositeNs, &TestItemIDsOnlyComposite{Key: i / 2, Value: strconv.Itoa(i % 2)}))
+		}
+		pks, err := DBD.Query(testIDsOnlyCompositeNs).WhereInt("key", reindexer.LT, 2).Sort("key", false).Sort("value", false).ExecPKs(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []int64{0, 0, 1, 1}, pks.Int64("key"))
+		assert.Equal(t, []string{"0", "1", "0", "1"}, pks.String("value"))
+	})
+
+	t.Run("merge is not supported", func(t *testing.T) {
+		_, err := DBD.Query(testIDsOnlyNs).Merge(DBD.Query(testIDsOnlyGroupNs)).ExecIDsOnly(context.Background())
+		assert.Error(t, err)
+	})
+}
+
+func BenchmarkExecIDsOnly(b *testing.B) {
+	prepareIDsOnly(b, 5000)
+	defer DBD.DropNamespace(testIDsOnlyNs)
+	defer DBD.DropNamespace(testIDsOnlyGroupNs)
+
+	b.Run("full fetch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			it := DBD.Query(testIDsOnlyNs).WhereInt("year", reindexer.GE, 2010).Exec()
+			for it.Next() {
+				_ = it.Object().(*TestItemIDsOnly).ID
+			}
+			it.Close()
+		}
+	})
+	b.Run("ids only", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := DBD.Query(testIDsOnlyNs).WhereInt("year", reindexer.GE, 2010).ExecIDsOnly(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
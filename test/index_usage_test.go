@@ -0,0 +1,98 @@
+package reindexer
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemIndexUsage struct {
+	ID     int    `reindex:"id,,pk"`
+	Name   string `reindex:"name"`
+	Year   int    `reindex:"year,tree"`
+	Unused string `reindex:"unused"`
+	Flag   int    `reindex:"flag,-"`
+}
+
+func TestIndexUsageReport(t *testing.T) {
+	const ns = "test_items_index_usage"
+	ctx := context.Background()
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemIndexUsage{}))
+	defer DBD.DropNamespace(ns)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, DBD.Upsert(ns, &TestItemIndexUsage{ID: i, Name: randString(), Year: 2000 + i%20, Unused: randString(), Flag: i % 2}))
+	}
+
+	profiling := func() (cfg reindexer.DBProfilingConfig) {
+		item, err := DBD.Query(reindexer.ConfigNamespaceName).WhereString("type", reindexer.EQ, "profiling").Exec().FetchOne()
+		if err == nil && item.(*reindexer.DBConfigItem).Profiling != nil {
+			cfg = *item.(*reindexer.DBConfigItem).Profiling
+		}
+		return cfg
+	}
+	prevProfiling := profiling()
+
+	// Live traffic of the client selects by year while the report samples it
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			it := DBD.Query(ns).WhereInt("year", reindexer.GT, 2010).Label("live_year").Exec()
+			assert.NoError(t, it.Error())
+			it.Close()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	report, err := DBD.IndexUsageReport(ctx, ns, reindexer.SampleOptions{
+		Queries: []*reindexer.Query{
+			DBD.Query(ns).WhereInt("id", reindexer.EQ, 1),
+			DBD.Query(ns).WhereString("name", reindexer.EQ, "name").Sort("year", false),
+			DBD.Query(ns).WhereInt("flag", reindexer.EQ, 1).Label("by_flag"),
+		},
+		Duration:        200 * time.Millisecond,
+		EnableProfiling: true,
+	})
+	close(stop)
+	wg.Wait()
+	require.NoError(t, err)
+
+	assert.Equal(t, ns, report.Namespace)
+	assert.True(t, report.Queries > 3, "queries: %d", report.Queries)
+	usage := make(map[string]reindexer.IndexUsage)
+	for _, index := range report.Indexes {
+		usage[index.Name] = index
+	}
+	for _, name := range []string{"id", "name", "year"} {
+		assert.False(t, usage[name].Unused, name)
+		assert.True(t, usage[name].Hits > 0, name)
+		assert.False(t, usage[name].LastUsed.IsZero(), name)
+	}
+	assert.True(t, usage["unused"].Unused)
+	assert.Equal(t, 0, usage["unused"].Hits)
+	assert.Equal(t, []string{"by_flag"}, usage["flag"].FullScans)
+	assert.NotEmpty(t, report.ServerQueries)
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded reindexer.IndexUsageReport
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, len(report.Indexes), len(decoded.Indexes))
+
+	// Profiling config is restored
+	assert.Equal(t, prevProfiling, profiling())
+}
@@ -0,0 +1,47 @@
+package reindexer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+// Tests of client features, which need injection of faults and latencies, use in-memory DB of reindexertest with its Hooks
+
+type TestNestedInMemory struct {
+	Code  string `json:"code"`
+	Level int    `json:"level"`
+}
+
+type TestItemInMemory struct {
+	ID     int                `reindex:"id,,pk" json:"id"`
+	Name   string             `reindex:"name" json:"name"`
+	Year   int                `reindex:"year,tree" json:"year"`
+	Rate   float64            `reindex:"rate,tree" json:"rate"`
+	Genres []int              `reindex:"genres" json:"genres"`
+	Active bool               `reindex:"active" json:"active"`
+	Nested TestNestedInMemory `json:"nested"`
+	Tags   []string           `json:"tags"`
+}
+
+const testInMemoryNs = "test_items_in_memory"
+
+func prepareInMemoryNs(t *testing.T, db *reindexer.Reindexer) {
+	db.DropNamespace(testInMemoryNs)
+	require.NoError(t, db.OpenNamespace(testInMemoryNs, reindexer.DefaultNamespaceOptions(), TestItemInMemory{}))
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Upsert(testInMemoryNs, &TestItemInMemory{
+			ID:     i,
+			Name:   fmt.Sprintf("name_%d", i%7),
+			Year:   2000 + i%5,
+			Rate:   float64(i) / 2,
+			Genres: []int{i % 3, 10 + i%4},
+			Active: i%2 == 0,
+			Nested: TestNestedInMemory{Code: fmt.Sprintf("c%d", i%3), Level: i % 4},
+			Tags:   []string{"t", fmt.Sprintf("t%d", i%2)},
+		}))
+	}
+}
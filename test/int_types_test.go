@@ -0,0 +1,175 @@
+package reindexer
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemIntTypes struct {
+	ID      int     `reindex:"id,,pk" json:"id"`
+	I8      int8    `reindex:"i8" json:"i8"`
+	I16     int16   `reindex:"i16" json:"i16"`
+	I32     int32   `reindex:"i32" json:"i32"`
+	U8      uint8   `reindex:"u8" json:"u8"`
+	U16     uint16  `reindex:"u16" json:"u16"`
+	U32     uint32  `reindex:"u32" json:"u32"`
+	NI8     int8    `json:"ni8"`
+	NI16    int16   `json:"ni16"`
+	NI32    int32   `json:"ni32"`
+	NU8     uint8   `json:"nu8"`
+	NU16    uint16  `json:"nu16"`
+	NU32    uint32  `json:"nu32"`
+	U8Arr   []uint8 `reindex:"u8_arr,,int_array" json:"u8_arr"`
+	NU8Arr  []uint8 `json:"nu8_arr"`
+	NI16Arr []int16 `json:"ni16_arr"`
+}
+
+var intTypesBounds = []struct {
+	field    string
+	min, max interface{}
+	// stored values, which don't fit into type of the field. Values of int index are 32-bit, so i32 and u32 are not checked
+	overflows []int64
+}{
+	{"i8", int8(math.MinInt8), int8(math.MaxInt8), []int64{math.MinInt8 - 1, math.MaxInt8 + 1}},
+	{"i16", int16(math.MinInt16), int16(math.MaxInt16), []int64{math.MinInt16 - 1, math.MaxInt16 + 1}},
+	{"i32", int32(math.MinInt32), int32(math.MaxInt32), nil},
+	{"u8", uint8(0), uint8(math.MaxUint8), []int64{-1, math.MaxUint8 + 1}},
+	{"u16", uint16(0), uint16(math.MaxUint16), []int64{-1, math.MaxUint16 + 1}},
+	{"u32", uint32(0), uint32(math.MaxUint32), nil},
+	{"ni8", int8(math.MinInt8), int8(math.MaxInt8), []int64{math.MinInt8 - 1, math.MaxInt8 + 1}},
+	{"ni16", int16(math.MinInt16), int16(math.MaxInt16), []int64{math.MinInt16 - 1, math.MaxInt16 + 1}},
+	{"ni32", int32(math.MinInt32), int32(math.MaxInt32), []int64{math.MinInt32 - 1, math.MaxInt32 + 1}},
+	{"nu8", uint8(0), uint8(math.MaxUint8), []int64{-1, math.MaxUint8 + 1}},
+	{"nu16", uint16(0), uint16(math.MaxUint16), []int64{-1, math.MaxUint16 + 1}},
+	{"nu32", uint32(0), uint32(math.MaxUint32), []int64{-1, math.MaxUint32 + 1}},
+}
+
+// intTypesField returns field of item by JSON name
+func intTypesField(item *TestItemIntTypes, name string) reflect.Value {
+	v := reflect.ValueOf(item).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0] == name {
+			return v.Field(i)
+		}
+	}
+	panic(fmt.Errorf("No field '%s'", name))
+}
+
+func TestIntTypes(t *testing.T) {
+	const ns = "test_items_int_types"
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemIntTypes{}))
+	defer DBD.DropNamespace(ns)
+	id := 0
+
+	t.Run("boundaries", func(t *testing.T) {
+		for _, b := range intTypesBounds {
+			for _, v := range []interface{}{b.min, b.max} {
+				id++
+				item := &TestItemIntTypes{ID: id}
+				intTypesField(item, b.field).Set(reflect.ValueOf(v))
+				require.NoError(t, DBD.Upsert(ns, item))
+
+				found, ok := DBD.Query(ns).WhereInt("id", reindexer.EQ, id).Get()
+				require.True(t, ok, b.field)
+				assert.Equal(t, v, intTypesField(found.(*TestItemIntTypes), b.field).Interface(), b.field)
+
+				// Query argument of the field type
+				items, err := DBD.Query(ns).Where(b.field, reindexer.EQ, v).Exec().FetchAll()
+				require.NoError(t, err, b.field)
+				ids := make([]int, 0, len(items))
+				for _, it := range items {
+					assert.Equal(t, v, intTypesField(it.(*TestItemIntTypes), b.field).Interface(), b.field)
+					ids = append(ids, it.(*TestItemIntTypes).ID)
+				}
+				assert.Contains(t, ids, id, "%s = %v", b.field, v)
+			}
+		}
+	})
+
+	t.Run("arrays", func(t *testing.T) {
+		id++
+		item := &TestItemIntTypes{ID: id, U8Arr: []uint8{0, 1, math.MaxUint8}, NU8Arr: []uint8{0, 1, math.MaxUint8}, NI16Arr: []int16{math.MinInt16, math.MaxInt16}}
+		require.NoError(t, DBD.Upsert(ns, item))
+
+		found, ok := DBD.Query(ns).WhereInt("id", reindexer.EQ, id).Get()
+		require.True(t, ok)
+		assert.Equal(t, item.U8Arr, found.(*TestItemIntTypes).U8Arr)
+		assert.Equal(t, item.NU8Arr, found.(*TestItemIntTypes).NU8Arr)
+		assert.Equal(t, item.NI16Arr, found.(*TestItemIntTypes).NI16Arr)
+
+		// []uint8 with int_array option is array of integers, without it - base64 string
+		json, err := DBD.Query(ns).WhereInt("id", reindexer.EQ, id).ExecToJson().FetchAll()
+		require.NoError(t, err)
+		assert.Contains(t, string(json), `"u8_arr":[0,1,255]`)
+		assert.Contains(t, string(json), `"nu8_arr":"AAH/"`)
+
+		_, ok = DBD.Query(ns).Where("u8_arr", reindexer.EQ, uint8(math.MaxUint8)).Get()
+		assert.True(t, ok)
+		_, ok = DBD.Query(ns).Where("ni16_arr", reindexer.SET, []int16{math.MinInt16}).Get()
+		assert.True(t, ok)
+	})
+
+	t.Run("base64 []uint8 is decoded", func(t *testing.T) {
+		id++
+		require.NoError(t, DBD.Upsert(ns, []byte(fmt.Sprintf(`{"id":%d,"nu8_arr":"AAH/"}`, id))))
+		found, ok := DBD.Query(ns).WhereInt("id", reindexer.EQ, id).Get()
+		require.True(t, ok)
+		assert.Equal(t, []uint8{0, 1, math.MaxUint8}, found.(*TestItemIntTypes).NU8Arr)
+	})
+
+	t.Run("int_array option is allowed only on []uint8", func(t *testing.T) {
+		type TestItemInvalidIntArray struct {
+			ID   int    `reindex:"id,,pk"`
+			Name string `reindex:"name,,int_array"`
+		}
+		assert.Error(t, DBD.OpenNamespace(ns+"_invalid", reindexer.DefaultNamespaceOptions(), TestItemInvalidIntArray{}))
+	})
+
+	t.Run("stored value overflow", func(t *testing.T) {
+		check := func(field string, json string, value int64) {
+			id++
+			require.NoError(t, DBD.Upsert(ns, []byte(fmt.Sprintf(`{"id":%d,%s}`, id, json))))
+			defer DBD.Query(ns).WhereInt("id", reindexer.EQ, id).Delete()
+
+			_, err := DBD.Query(ns).WhereInt("id", reindexer.EQ, id).Exec().FetchAll()
+			require.Error(t, err, json)
+			overflow, ok := err.(reindexer.ErrValueOverflow)
+			require.True(t, ok, "%s: %v", json, err)
+			assert.Equal(t, field, overflow.Field)
+			assert.Equal(t, value, overflow.Value)
+			assert.Equal(t, reindexer.ErrCodeParams, overflow.Code())
+		}
+		for _, b := range intTypesBounds {
+			for _, v := range b.overflows {
+				check(b.field, fmt.Sprintf(`"%s":%d`, b.field, v), v)
+			}
+		}
+		check("u8_arr", `"u8_arr":[1,256]`, 256)
+		check("ni16_arr", `"ni16_arr":[1,-32769]`, -32769)
+	})
+
+	t.Run("truncation", func(t *testing.T) {
+		db := reindexer.NewReindex("builtin:///tmp/reindex_test_int_types/", reindexer.WithIntTruncation())
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemIntTypes{}))
+		defer db.DropNamespace(ns)
+
+		require.NoError(t, db.Upsert(ns, []byte(`{"id":1,"u8":300,"nu8":300,"i8":128,"nu32":-1,"u8_arr":[256,257]}`)))
+		found, ok := db.Query(ns).WhereInt("id", reindexer.EQ, 1).Get()
+		require.True(t, ok)
+		item := found.(*TestItemIntTypes)
+		assert.Equal(t, uint8(44), item.U8)
+		assert.Equal(t, uint8(44), item.NU8)
+		assert.Equal(t, int8(math.MinInt8), item.I8)
+		assert.Equal(t, uint32(math.MaxUint32), item.NU32)
+		assert.Equal(t, []uint8{0, 1}, item.U8Arr)
+	})
+}
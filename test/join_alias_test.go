@@ -0,0 +1,101 @@
+package reindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestJoinAliasUser struct {
+	ID   int    `reindex:"id,,pk"`
+	Name string `reindex:"name"`
+}
+
+type TestJoinAliasPost struct {
+	ID         int                  `reindex:"id,,pk"`
+	AuthorID   int                  `reindex:"author_id"`
+	ReviewerID int                  `reindex:"reviewer_id"`
+	Author     []*TestJoinAliasUser `reindex:"author,,joined"`
+	Reviewer   []*TestJoinAliasUser `reindex:"reviewer,,joined"`
+}
+
+func TestJoinSameNamespaceTwice(t *testing.T) {
+	const nsUsers = "test_join_alias_users"
+	const nsPosts = "test_join_alias_posts"
+	const postsCount = 10
+
+	require.NoError(t, DBD.OpenNamespace(nsUsers, reindexer.DefaultNamespaceOptions(), TestJoinAliasUser{}))
+	defer DBD.DropNamespace(nsUsers)
+	require.NoError(t, DBD.OpenNamespace(nsPosts, reindexer.DefaultNamespaceOptions(), TestJoinAliasPost{}))
+	defer DBD.DropNamespace(nsPosts)
+	for i := 0; i < postsCount; i++ {
+		require.NoError(t, DBD.Upsert(nsUsers, &TestJoinAliasUser{ID: i, Name: "user"}))
+	}
+	for i := 0; i < postsCount; i++ {
+		// Odd posts are not reviewed, so they must not get reviewer of the previous post
+		reviewer := -1
+		if i%2 == 0 {
+			reviewer = (i + 1) % postsCount
+		}
+		require.NoError(t, DBD.Upsert(nsPosts, &TestJoinAliasPost{ID: i, AuthorID: i, ReviewerID: reviewer}))
+	}
+
+	query := func() *reindexer.Query {
+		q := DBD.Query(nsPosts).Sort("id", false)
+		q.LeftJoin(DBD.Query(nsUsers), "author").On("author_id", reindexer.EQ, "id")
+		q.LeftJoin(DBD.Query(nsUsers), "reviewer").On("reviewer_id", reindexer.EQ, "id")
+		return q
+	}
+
+	t.Run("joined fields", func(t *testing.T) {
+		items, err := query().Exec().FetchAll()
+		require.NoError(t, err)
+		require.Len(t, items, postsCount)
+		for _, item := range items {
+			post := item.(*TestJoinAliasPost)
+			require.Len(t, post.Author, 1, "post %d", post.ID)
+			assert.Equal(t, post.AuthorID, post.Author[0].ID)
+			if post.ReviewerID < 0 {
+				assert.Empty(t, post.Reviewer, "post %d", post.ID)
+			} else {
+				require.Len(t, post.Reviewer, 1, "post %d", post.ID)
+				assert.Equal(t, post.ReviewerID, post.Reviewer[0].ID)
+			}
+		}
+	})
+
+	t.Run("joined objects and handlers", func(t *testing.T) {
+		handled := map[string]int{}
+		q := query()
+		for _, alias := range []string{"author", "reviewer"} {
+			q.JoinHandler(alias, func(field string, item interface{}, subitems []interface{}) bool {
+				handled[field] += len(subitems)
+				return false
+			})
+		}
+		it := q.Exec()
+		defer it.Close()
+		require.NoError(t, it.Error())
+		for it.Next() {
+			post := it.Object().(*TestJoinAliasPost)
+			assert.Empty(t, post.Author, "handler must prevent default join")
+			author, err := it.JoinedObjects("author")
+			require.NoError(t, err)
+			require.Len(t, author, 1)
+			assert.Equal(t, post.AuthorID, author[0].(*TestJoinAliasUser).ID)
+			reviewer, err := it.JoinedObjects("reviewer")
+			require.NoError(t, err)
+			if post.ReviewerID < 0 {
+				assert.Empty(t, reviewer, "post %d", post.ID)
+			} else {
+				require.Len(t, reviewer, 1)
+				assert.Equal(t, post.ReviewerID, reviewer[0].(*TestJoinAliasUser).ID)
+			}
+		}
+		require.NoError(t, it.Error())
+		assert.Equal(t, map[string]int{"author": postsCount, "reviewer": postsCount / 2}, handled)
+	})
+}
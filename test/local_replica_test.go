@@ -0,0 +1,164 @@
+package reindexer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/reindexertest"
+)
+
+type TestItemLocalReplicaInMemory struct {
+	ID       int     `reindex:"id,,pk" json:"id"`
+	Code     string  `reindex:"code" json:"code"`
+	Region   int     `reindex:"region,tree" json:"region"`
+	Rate     float64 `reindex:"rate,tree" json:"rate"`
+	Title    string  `reindex:"title,,collate_ascii" json:"title"`
+	Disabled bool    `json:"disabled"`
+}
+
+func TestLocalReplica(t *testing.T) {
+	const ns = "test_items_local_replica_in_memory"
+	hooks := &reindexertest.Hooks{}
+	db := reindexertest.NewInMemory(reindexertest.WithHooks(hooks))
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemLocalReplicaInMemory{}))
+	for i := 0; i < 20; i++ {
+		require.NoError(t, db.Upsert(ns, &TestItemLocalReplicaInMemory{ID: i, Code: fmt.Sprintf("c%d", i%7), Region: i % 3, Rate: float64(i) / 4, Title: "T"}))
+	}
+	require.NoError(t, db.CloseNamespace(ns))
+	// Replica is loaded by OpenNamespace and isn't polled by the test
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().LocalReplica(reindexer.LocalReplicaOptions{PollInterval: time.Hour}), TestItemLocalReplicaInMemory{}))
+	selects := func() int {
+		calls := hooks.Calls(reindexertest.OpSelect)
+		hooks.Reset()
+		return calls
+	}
+	ids := func(it *reindexer.Iterator) []int {
+		items, err := it.FetchAll()
+		require.NoError(t, err)
+		res := make([]int, 0, len(items))
+		for _, item := range items {
+			res = append(res, item.(*TestItemLocalReplicaInMemory).ID)
+		}
+		return res
+	}
+
+	t.Run("served by replica", func(t *testing.T) {
+		queries := map[string]func() *reindexer.Query{
+			"all": func() *reindexer.Query { return db.Query(ns) },
+			"eq":  func() *reindexer.Query { return db.Query(ns).WhereString("code", reindexer.EQ, "c3") },
+			"set and sort": func() *reindexer.Query {
+				return db.Query(ns).WhereInt("region", reindexer.SET, 0, 2).Sort("rate", true).Offset(2).Limit(5).ReqTotal()
+			},
+			"int key of double": func() *reindexer.Query {
+				return db.Query(ns).WhereInt("rate", reindexer.EQ, 2).WhereInt("id", reindexer.EQ, 8)
+			},
+			"no items": func() *reindexer.Query { return db.Query(ns).WhereString("code", reindexer.EQ, "none") },
+		}
+		for name, query := range queries {
+			selects()
+			it := query().Exec()
+			total := it.TotalCount()
+			local := ids(it)
+			assert.Equal(t, 0, selects(), name)
+			it = query().BypassLocalReplica().Exec()
+			assert.Equal(t, total, it.TotalCount(), name)
+			assert.Equal(t, ids(it), local, name)
+			assert.NotEqual(t, 0, selects(), name)
+		}
+		item, found := db.Query(ns).WhereInt("id", reindexer.EQ, 5).Get()
+		require.True(t, found)
+		assert.Equal(t, 0, selects())
+		// Objects of results are copies of replica
+		item.(*TestItemLocalReplicaInMemory).Code = "changed"
+		item, _ = db.Query(ns).WhereInt("id", reindexer.EQ, 5).Get()
+		assert.Equal(t, "c5", item.(*TestItemLocalReplicaInMemory).Code)
+	})
+
+	t.Run("bypassed", func(t *testing.T) {
+		queries := map[string]func() *reindexer.Query{
+			"or": func() *reindexer.Query {
+				return db.Query(ns).WhereInt("id", reindexer.EQ, 1).Or().WhereInt("id", reindexer.EQ, 2)
+			},
+			"not":           func() *reindexer.Query { return db.Query(ns).Not().WhereInt("id", reindexer.EQ, 1) },
+			"range":         func() *reindexer.Query { return db.Query(ns).WhereInt("id", reindexer.GT, 10) },
+			"collate":       func() *reindexer.Query { return db.Query(ns).WhereString("title", reindexer.EQ, "t") },
+			"not indexed":   func() *reindexer.Query { return db.Query(ns).WhereBool("disabled", reindexer.EQ, false) },
+			"select filter": func() *reindexer.Query { return db.Query(ns).Select("id") },
+			"stable sort":   func() *reindexer.Query { return db.Query(ns).Sort("region", false).StableSort() },
+			"forced sort":   func() *reindexer.Query { return db.Query(ns).Sort("id", false, 3, 1) },
+			"string key":    func() *reindexer.Query { return db.Query(ns).WhereString("id", reindexer.EQ, "1") },
+		}
+		for name, query := range queries {
+			selects()
+			query().Exec().Close()
+			assert.NotEqual(t, 0, selects(), name)
+		}
+		selects()
+		db.Query(ns).WhereInt("id", reindexer.EQ, 1).ExecToJson().Close()
+		assert.NotEqual(t, 0, selects())
+	})
+
+	t.Run("own writes", func(t *testing.T) {
+		require.NoError(t, db.Upsert(ns, &TestItemLocalReplicaInMemory{ID: 100, Code: "new"}))
+		get := func() (*TestItemLocalReplicaInMemory, int) {
+			selects()
+			item, found := db.Query(ns).WhereString("code", reindexer.EQ, "new").Get()
+			require.True(t, found)
+			return item.(*TestItemLocalReplicaInMemory), selects()
+		}
+		item, _ := get()
+		assert.Equal(t, 100, item.ID)
+		// Replica is reloaded after write
+		deadline := time.Now().Add(5 * time.Second)
+		for _, calls := get(); calls != 0; _, calls = get() {
+			require.True(t, time.Now().Before(deadline), "replica isn't reloaded")
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		it := db.Query(ns).WhereString("code", reindexer.EQ, "new").Set("code", "updated").Update()
+		require.NoError(t, it.Error())
+		it.Close()
+		_, found := db.Query(ns).WhereString("code", reindexer.EQ, "new").Get()
+		assert.False(t, found)
+	})
+
+	t.Run("refresh failure", func(t *testing.T) {
+		const polledNs = "test_items_local_replica_polled_in_memory"
+		opts := reindexer.LocalReplicaOptions{PollInterval: 10 * time.Millisecond, MaxStaleness: 300 * time.Millisecond}
+		require.NoError(t, db.OpenNamespace(polledNs, reindexer.DefaultNamespaceOptions().LocalReplica(opts), TestItemLocalReplicaInMemory{}))
+		require.NoError(t, db.Upsert(polledNs, &TestItemLocalReplicaInMemory{ID: 1, Code: "polled"}))
+		get := func() error {
+			_, err := db.Query(polledNs).WhereInt("id", reindexer.EQ, 1).Exec().FetchOne()
+			return err
+		}
+		waitFor := func(cond func() bool, what string) {
+			deadline := time.Now().Add(5 * time.Second)
+			for !cond() {
+				require.True(t, time.Now().Before(deadline), what)
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+		// Selects of background polls may be counted too, but query sent to server is never counted as local
+		local := func() bool {
+			selects()
+			return get() == nil && selects() == 0
+		}
+		waitFor(local, "replica isn't loaded")
+		injected := bindings.NewError("injected", bindings.ErrNetwork)
+		hooks.InjectError(reindexertest.OpSelect, injected)
+		assert.NoError(t, get())
+		waitFor(func() bool { return get() != nil }, "stale replica is used")
+		assert.Equal(t, injected, get())
+
+		hooks.InjectError(reindexertest.OpSelect, nil)
+		waitFor(local, "replica isn't reloaded")
+		require.NoError(t, db.CloseNamespace(polledNs))
+	})
+}
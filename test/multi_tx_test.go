@@ -0,0 +1,223 @@
+package reindexer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/reindexertest"
+)
+
+func TestMultiTx(t *testing.T) {
+	ctx := context.Background()
+	const stockNs = "test_items_in_memory_stock"
+	network := bindings.NewError("rq: injected network error", bindings.ErrNetwork)
+	prepare := func(t *testing.T, hooks *reindexertest.Hooks) *reindexer.Reindexer {
+		db := reindexertest.NewInMemory(reindexertest.WithHooks(hooks))
+		prepareInMemoryNs(t, db)
+		require.NoError(t, db.OpenNamespace(stockNs, reindexer.DefaultNamespaceOptions(), TestItemInMemory{}))
+		require.NoError(t, db.Upsert(stockNs, &TestItemInMemory{ID: 1, Name: "stock", Year: 10}))
+		hooks.Reset()
+		return db
+	}
+	// order inserts order and decrements stock
+	order := func(t *testing.T, db *reindexer.Reindexer) *reindexer.MultiTx {
+		mtx, err := db.BeginMultiTx(ctx, testInMemoryNs, stockNs)
+		require.NoError(t, err)
+		require.NoError(t, mtx.Insert(testInMemoryNs, &TestItemInMemory{ID: 100, Name: "order"}))
+		require.NoError(t, mtx.CompensateDelete(testInMemoryNs, &TestItemInMemory{ID: 100}))
+		require.NoError(t, mtx.Upsert(stockNs, &TestItemInMemory{ID: 1, Name: "stock", Year: 9}))
+		require.NoError(t, mtx.CompensateUpsert(stockNs, []byte(`{"id":1,"name":"stock","year":10}`)))
+		return mtx
+	}
+	checkOrdered := func(t *testing.T, db *reindexer.Reindexer, ordered bool) {
+		it := db.Query(testInMemoryNs).Limit(0).ReqTotal().Exec()
+		require.NoError(t, it.Error())
+		count := it.TotalCount()
+		it.Close()
+		_, found := db.Query(testInMemoryNs).WhereInt("id", reindexer.EQ, 100).Get()
+		assert.Equal(t, ordered, found)
+		stock, found := db.Query(stockNs).WhereInt("id", reindexer.EQ, 1).Get()
+		require.True(t, found)
+		if ordered {
+			assert.Equal(t, 21, count)
+			assert.Equal(t, 9, stock.(*TestItemInMemory).Year)
+		} else {
+			assert.Equal(t, 20, count)
+			assert.Equal(t, 10, stock.(*TestItemInMemory).Year)
+		}
+	}
+	checkNoPending := func(t *testing.T, db *reindexer.Reindexer) {
+		recs, err := db.RecoverMultiTx(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, recs)
+		pending, err := db.GetMeta(testInMemoryNs, "multitx:pending")
+		require.NoError(t, err)
+		assert.Equal(t, "[]", string(pending))
+	}
+	// closeStock makes changes of stock rejected, until it's opened again by openStock
+	closeStock := func(t *testing.T, db *reindexer.Reindexer) {
+		require.NoError(t, db.CloseNamespace(stockNs))
+	}
+	openStock := func(t *testing.T, db *reindexer.Reindexer) {
+		require.NoError(t, db.OpenNamespace(stockNs, reindexer.DefaultNamespaceOptions(), TestItemInMemory{}))
+	}
+	multiTxErr := func(t *testing.T, err error) *reindexer.ErrMultiTx {
+		var merr *reindexer.ErrMultiTx
+		require.True(t, errors.As(err, &merr), "unexpected error %v", err)
+		return merr
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		mtx := order(t, db)
+		require.NoError(t, mtx.Commit())
+		checkOrdered(t, db, true)
+		checkNoPending(t, db)
+		journal, err := db.GetMeta(testInMemoryNs, "multitx:"+mtx.ID())
+		require.NoError(t, err)
+		assert.Empty(t, journal)
+		assert.Error(t, mtx.Commit())
+		assert.Error(t, mtx.Upsert(stockNs, &TestItemInMemory{ID: 1}))
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		require.NoError(t, order(t, db).Rollback())
+		checkOrdered(t, db, false)
+		assert.Equal(t, 0, hooks.Calls(reindexertest.OpCommitTx))
+		assert.Equal(t, 0, hooks.Calls(reindexertest.OpPutMeta))
+	})
+
+	t.Run("crash after first commit", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		mtx := order(t, db)
+		hooks.InjectErrorAfter(reindexertest.OpCommitTx, 1, network)
+		merr := multiTxErr(t, mtx.Commit())
+		assert.True(t, merr.Pending)
+		assert.Equal(t, stockNs, merr.Namespace)
+		assert.Equal(t, []string{testInMemoryNs}, merr.Committed)
+		assert.Equal(t, bindings.ErrNetwork, merr.Code())
+		// Order is written, but stock is not changed until recovery
+		_, found := db.Query(testInMemoryNs).WhereInt("id", reindexer.EQ, 100).Get()
+		assert.True(t, found)
+
+		// Recovery fails with the same error and keeps journal
+		_, err := db.RecoverMultiTx(ctx)
+		assert.Error(t, err)
+		hooks.Reset()
+		recs, err := db.RecoverMultiTx(ctx)
+		require.NoError(t, err)
+		require.Len(t, recs, 1)
+		assert.Equal(t, reindexer.MultiTxRecovery{ID: mtx.ID(), Reapplied: []string{stockNs}}, recs[0])
+		checkOrdered(t, db, true)
+		checkNoPending(t, db)
+	})
+
+	t.Run("crash before journal update", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		mtx := order(t, db)
+		// Journal and list of journals are written, update of journal after the first commit fails
+		hooks.InjectErrorAfter(reindexertest.OpPutMeta, 2, network)
+		merr := multiTxErr(t, mtx.Commit())
+		assert.True(t, merr.Pending)
+		assert.Equal(t, 1, hooks.Calls(reindexertest.OpCommitTx))
+		hooks.Reset()
+
+		// Changes of the first namespace are applied again without duplicates
+		recs, err := db.RecoverMultiTx(ctx)
+		require.NoError(t, err)
+		require.Len(t, recs, 1)
+		assert.Equal(t, []string{testInMemoryNs, stockNs}, recs[0].Reapplied)
+		checkOrdered(t, db, true)
+		checkNoPending(t, db)
+	})
+
+	t.Run("rejected commit is compensated", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		mtx := order(t, db)
+		closeStock(t, db)
+		merr := multiTxErr(t, mtx.Commit())
+		assert.False(t, merr.Pending)
+		assert.Equal(t, stockNs, merr.Namespace)
+		assert.Empty(t, merr.Committed)
+		assert.Equal(t, bindings.ErrNotFound, merr.Code())
+		openStock(t, db)
+		checkOrdered(t, db, false)
+		checkNoPending(t, db)
+	})
+
+	t.Run("recovery compensates rejected changes", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		mtx := order(t, db)
+		hooks.InjectErrorAfter(reindexertest.OpCommitTx, 1, network)
+		assert.True(t, multiTxErr(t, mtx.Commit()).Pending)
+		hooks.Reset()
+		closeStock(t, db)
+
+		recs, err := db.RecoverMultiTx(ctx)
+		require.NoError(t, err)
+		require.Len(t, recs, 1)
+		assert.Empty(t, recs[0].Reapplied)
+		assert.Equal(t, []string{testInMemoryNs}, recs[0].Compensated)
+		assert.Error(t, recs[0].Rejected)
+		openStock(t, db)
+		checkOrdered(t, db, false)
+		checkNoPending(t, db)
+	})
+
+	t.Run("running commit is not recovered", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		mtx := order(t, db)
+		hooks.InjectLatency(reindexertest.OpCommitTx, 100*time.Millisecond)
+		done := make(chan error)
+		go func() { done <- mtx.Commit() }()
+		time.Sleep(50 * time.Millisecond)
+		recs, err := db.RecoverMultiTx(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, recs)
+		require.NoError(t, <-done)
+		checkOrdered(t, db, true)
+	})
+
+	t.Run("misuse", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := prepare(t, hooks)
+		defer db.Close()
+		_, err := db.BeginMultiTx(ctx)
+		assert.Equal(t, reindexer.ErrEmptyNamespace, err)
+		_, err = db.BeginMultiTx(ctx, testInMemoryNs, strings.ToUpper(testInMemoryNs))
+		assert.Error(t, err)
+		assert.Equal(t, 1, hooks.Calls(reindexertest.OpRollbackTx))
+
+		mtx, err := db.BeginMultiTx(ctx, testInMemoryNs)
+		require.NoError(t, err)
+		defer mtx.Rollback()
+		assert.Error(t, mtx.Upsert(stockNs, &TestItemInMemory{ID: 1}))
+		assert.Error(t, mtx.UpsertJSON(testInMemoryNs, []byte(`{"id":`)))
+		err = mtx.Upsert(testInMemoryNs, &TestItemInMemory{ID: 1}, "year=serial()")
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+	})
+}
@@ -0,0 +1,162 @@
+package reindexer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+type TestItemNsInvalidated struct {
+	ID   int    `reindex:"id,,pk"`
+	Name string `reindex:"name"`
+}
+
+// TestItemNsInvalidatedOther is the type of namespace, which is recreated by another client with other indexes
+type TestItemNsInvalidatedOther struct {
+	ID    int    `reindex:"id,,pk"`
+	Year  int    `reindex:"year,tree"`
+	Extra string `json:"extra"`
+	Name  string `json:"name"`
+}
+
+func TestNamespaceInvalidated(t *testing.T) {
+	const ns = "test_items_ns_invalidated"
+	const itemsCount = 50
+
+	srv := helpers.TestServer{T: t, RpcPort: "6695", HttpPort: "9995", DbName: "reindex_test_ns_invalidated"}
+	require.NoError(t, srv.Run())
+	defer srv.Clean()
+	defer func() { srv.Stop() }()
+	dsn := fmt.Sprintf("cproto://%s/%s_%s", srv.Addr(), srv.DbName, srv.RpcPort)
+
+	var lock sync.Mutex
+	reasons := map[string][]error{}
+	handler := func(client string) func(string, error) {
+		return func(namespace string, reason error) {
+			assert.Equal(t, ns, namespace)
+			lock.Lock()
+			reasons[client] = append(reasons[client], reason)
+			lock.Unlock()
+		}
+	}
+	reported := func(client string) []error {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]error{}, reasons[client]...)
+	}
+
+	db := reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing(), reindexer.WithOnNamespaceInvalidated(handler("db"), false))
+	defer db.Close()
+	dbReopen := reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing(), reindexer.WithOnNamespaceInvalidated(handler("reopen"), true))
+	defer dbReopen.Close()
+	other := reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing())
+	defer other.Close()
+
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemNsInvalidated{}))
+	require.NoError(t, dbReopen.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemNsInvalidated{}))
+	for i := 0; i < itemsCount; i++ {
+		require.NoError(t, db.Upsert(ns, &TestItemNsInvalidated{ID: i, Name: fmt.Sprintf("old_%d", i)}))
+	}
+
+	names := func(db *reindexer.Reindexer) ([]string, error) {
+		it := db.Query(ns).Sort("id", false).Exec()
+		defer it.Close()
+		var res []string
+		for it.Next() {
+			res = append(res, it.Object().(*TestItemNsInvalidated).Name)
+		}
+		return res, it.Error()
+	}
+	// Fill object caches of the clients
+	for _, c := range []*reindexer.Reindexer{db, dbReopen} {
+		res, err := names(c)
+		require.NoError(t, err)
+		require.Len(t, res, itemsCount)
+		assert.Equal(t, "old_0", res[0])
+	}
+
+	t.Run("recreated namespace", func(t *testing.T) {
+		require.NoError(t, other.DropNamespace(ns))
+		require.NoError(t, other.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemNsInvalidatedOther{}))
+		for i := 0; i < itemsCount; i++ {
+			require.NoError(t, other.Upsert(ns, &TestItemNsInvalidatedOther{ID: i, Year: 2000 + i, Extra: "extra", Name: fmt.Sprintf("new_%d", i)}))
+		}
+
+		res, err := names(db)
+		require.NoError(t, err)
+		require.Len(t, res, itemsCount)
+		for i, name := range res {
+			assert.Equal(t, fmt.Sprintf("new_%d", i), name, "stale object is returned from cache")
+		}
+		// Recreated namespace is reported in background
+		for start := time.Now(); len(reported("db")) == 0 && time.Since(start) < 5*time.Second; {
+			time.Sleep(10 * time.Millisecond)
+		}
+		require.Len(t, reported("db"), 1)
+		assert.Equal(t, reindexer.ErrCodeStateInvalidated, reported("db")[0].(reindexer.Error).Code())
+
+		// Namespace is not changed since the last results, so it's not reported again
+		_, err = names(db)
+		require.NoError(t, err)
+		assert.Len(t, reported("db"), 1)
+	})
+
+	t.Run("handler doesn't block results", func(t *testing.T) {
+		release := make(chan struct{})
+		called := make(chan struct{})
+		blocked := reindexer.NewReindex(dsn, reindexer.WithOnNamespaceInvalidated(func(string, error) {
+			close(called)
+			<-release
+		}, false))
+		defer blocked.Close()
+		defer close(release)
+		require.NoError(t, blocked.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemNsInvalidated{}))
+		_, err := names(blocked)
+		require.NoError(t, err)
+
+		require.NoError(t, other.DropNamespace(ns))
+		require.NoError(t, other.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemNsInvalidatedOther{}))
+		require.NoError(t, other.Upsert(ns, &TestItemNsInvalidatedOther{ID: 0, Name: "blocked"}))
+
+		res, err := names(blocked)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"blocked"}, res)
+		select {
+		case <-called:
+		case <-time.After(5 * time.Second):
+			t.Fatal("invalidation handler is not called")
+		}
+	})
+
+	t.Run("dropped namespace", func(t *testing.T) {
+		require.NoError(t, other.DropNamespace(ns))
+
+		_, err := names(db)
+		require.Error(t, err)
+		_, err = names(db)
+		require.Error(t, err)
+		require.Len(t, reported("db"), 2, "missing namespace must be reported once")
+		assert.Equal(t, err, reported("db")[1])
+
+		before := len(reported("reopen"))
+		_, err = names(dbReopen)
+		require.Error(t, err)
+		assert.Len(t, reported("reopen"), before+1)
+
+		// Namespace is opened again by the handler
+		res, err := names(dbReopen)
+		require.NoError(t, err)
+		assert.Empty(t, res)
+		require.NoError(t, dbReopen.Upsert(ns, &TestItemNsInvalidated{ID: 1, Name: "reopened"}))
+		res, err = names(db)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"reopened"}, res)
+	})
+}
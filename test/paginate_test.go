@@ -0,0 +1,168 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/reindexertest"
+)
+
+type TestItemPaginateInMemory struct {
+	ID    int    `reindex:"id,,pk" json:"id"`
+	Price int    `reindex:"price,tree" json:"price"`
+	Name  string `reindex:"name" json:"name"`
+}
+
+func TestPaginate(t *testing.T) {
+	const ns = "test_items_paginate_in_memory"
+	const count = 50
+	const pageSize = 7
+	newDB := func(t *testing.T) *reindexer.Reindexer {
+		db := reindexertest.NewInMemory()
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemPaginateInMemory{}))
+		for i := 0; i < count; i++ {
+			// Prices are repeated, so order of pages depends on tie-breaker
+			require.NoError(t, db.Upsert(ns, &TestItemPaginateInMemory{ID: i, Price: i % 5, Name: fmt.Sprintf("item %d", i%3)}))
+		}
+		return db
+	}
+	query := func(db *reindexer.Reindexer) *reindexer.Query {
+		return db.Query(ns).Sort("price", true).Sort("name", false)
+	}
+	// mutate deletes two items, which are already returned, and inserts item before the next page
+	mutate := func(t *testing.T, db *reindexer.Reindexer, page int, seen []*TestItemPaginateInMemory) {
+		require.NoError(t, db.Delete(ns, seen[0]))
+		require.NoError(t, db.Delete(ns, seen[1]))
+		require.NoError(t, db.Upsert(ns, &TestItemPaginateInMemory{ID: 1000 + page, Price: 100, Name: "new"}))
+	}
+	ids := func(items []interface{}) (ids []int) {
+		for _, item := range items {
+			ids = append(ids, item.(*TestItemPaginateInMemory).ID)
+		}
+		return ids
+	}
+
+	t.Run("pages of mutating dataset", func(t *testing.T) {
+		db := newDB(t)
+		defer db.Close()
+		var after reindexer.Cursor
+		seen := map[int]int{}
+		for page := 0; ; page++ {
+			require.True(t, page < count, "pagination doesn't stop")
+			items, next, err := query(db).Paginate(context.Background(), reindexer.PaginateOptions{PageSize: pageSize, After: after})
+			require.NoError(t, err)
+			require.True(t, len(items) <= pageSize)
+			pageItems := make([]*TestItemPaginateInMemory, 0, len(items))
+			for _, id := range ids(items) {
+				seen[id]++
+			}
+			for _, item := range items {
+				pageItems = append(pageItems, item.(*TestItemPaginateInMemory))
+			}
+			if next == "" {
+				break
+			}
+			assert.NotContains(t, string(next), "=")
+			assert.NotContains(t, string(next), "/")
+			assert.NotContains(t, string(next), "+")
+			mutate(t, db, page, pageItems)
+			after = next
+		}
+		// Each of the initial items is returned exactly once, inserted items sort before the cursor and aren't returned
+		for id, n := range seen {
+			assert.Equal(t, 1, n, "item %d", id)
+			assert.True(t, id < count, "item %d", id)
+		}
+		assert.Len(t, seen, count)
+	})
+
+	t.Run("offset pagination of mutating dataset fails", func(t *testing.T) {
+		db := newDB(t)
+		defer db.Close()
+		seen := map[int]int{}
+		for page := 0; ; page++ {
+			items, err := query(db).StableSort().Offset(page * pageSize).Limit(pageSize).Exec().FetchAll()
+			require.NoError(t, err)
+			pageItems := make([]*TestItemPaginateInMemory, 0, len(items))
+			for _, item := range items {
+				seen[item.(*TestItemPaginateInMemory).ID]++
+				pageItems = append(pageItems, item.(*TestItemPaginateInMemory))
+			}
+			if len(items) < pageSize {
+				break
+			}
+			mutate(t, db, page, pageItems)
+		}
+		duplicates, missed := 0, 0
+		for id := 0; id < count; id++ {
+			switch seen[id] {
+			case 0:
+				missed++
+			case 1:
+			default:
+				duplicates++
+			}
+		}
+		assert.True(t, duplicates+missed > 0, "offset pagination should overlap or miss items of mutating dataset")
+	})
+
+	t.Run("pages are equal to sorted results", func(t *testing.T) {
+		db := newDB(t)
+		defer db.Close()
+		all, err := query(db).StableSort().Exec().FetchAll()
+		require.NoError(t, err)
+		var paged []interface{}
+		var after reindexer.Cursor
+		key := []byte("secret")
+		for {
+			items, next, err := query(db).Paginate(context.Background(), reindexer.PaginateOptions{PageSize: pageSize, After: after, Key: key})
+			require.NoError(t, err)
+			paged = append(paged, items...)
+			if next == "" {
+				break
+			}
+			after = next
+		}
+		assert.Equal(t, ids(all), ids(paged))
+	})
+
+	t.Run("invalid cursors", func(t *testing.T) {
+		db := newDB(t)
+		defer db.Close()
+		_, next, err := query(db).Paginate(context.Background(), reindexer.PaginateOptions{PageSize: pageSize, Key: []byte("secret")})
+		require.NoError(t, err)
+		require.NotEqual(t, reindexer.Cursor(""), next)
+
+		tampered := []byte(next)
+		pos := len("v1.") + 2
+		if tampered[pos] == 'A' {
+			tampered[pos] = 'B'
+		} else {
+			tampered[pos] = 'A'
+		}
+		cases := map[string]struct {
+			q    *reindexer.Query
+			opts reindexer.PaginateOptions
+		}{
+			"tampered":     {query(db), reindexer.PaginateOptions{PageSize: pageSize, After: reindexer.Cursor(tampered), Key: []byte("secret")}},
+			"another key":  {query(db), reindexer.PaginateOptions{PageSize: pageSize, After: next, Key: []byte("other")}},
+			"without key":  {query(db), reindexer.PaginateOptions{PageSize: pageSize, After: next}},
+			"version":      {query(db), reindexer.PaginateOptions{PageSize: pageSize, After: "v0." + next[3:], Key: []byte("secret")}},
+			"another sort": {db.Query(ns).Sort("price", false), reindexer.PaginateOptions{PageSize: pageSize, After: next, Key: []byte("secret")}},
+			"page size":    {query(db), reindexer.PaginateOptions{}},
+			"expression":   {db.Query(ns).Sort("price * 2", false), reindexer.PaginateOptions{PageSize: pageSize}},
+			"forced":       {db.Query(ns).Sort("price", false, 3, 4), reindexer.PaginateOptions{PageSize: pageSize}},
+			"offset":       {query(db).Offset(10), reindexer.PaginateOptions{PageSize: pageSize}},
+		}
+		for name, c := range cases {
+			_, _, err := c.q.Paginate(context.Background(), c.opts)
+			require.Error(t, err, name)
+			assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code(), name)
+		}
+	})
+}
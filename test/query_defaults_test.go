@@ -0,0 +1,155 @@
+package reindexer
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/restream/reindexer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type TestItemQueryDefaults struct {
+	ID   int `reindex:"id,,pk"`
+	Year int `reindex:"year,tree"`
+}
+
+type TestJoinItemQueryDefaults struct {
+	ID     int                      `reindex:"id,,pk"`
+	Joined []*TestItemQueryDefaults `reindex:"joined,,joined"`
+}
+
+func TestQueryDefaults(t *testing.T) {
+	const nsFetch = "test_items_query_defaults_fetch"
+	const nsTimeout = "test_items_query_defaults_timeout"
+	const nsJoin = "test_items_query_defaults_join"
+	const nsLongTimeout = "test_items_query_defaults_long_timeout"
+	const nsNoCache = "test_items_query_defaults_no_cache"
+
+	require.NoError(t, DBD.OpenNamespace(nsFetch, reindexer.DefaultNamespaceOptions().
+		QueryDefaults(reindexer.NamespaceQueryDefaults{FetchCount: 1, Debug: reindexer.TRACE}), TestItemQueryDefaults{}))
+	require.NoError(t, DBD.OpenNamespace(nsTimeout, reindexer.DefaultNamespaceOptions().
+		QueryDefaults(reindexer.NamespaceQueryDefaults{Timeout: time.Nanosecond}), TestItemQueryDefaults{}))
+	require.NoError(t, DBD.OpenNamespace(nsJoin, reindexer.DefaultNamespaceOptions(), TestJoinItemQueryDefaults{}))
+	require.NoError(t, DBD.OpenNamespace(nsLongTimeout, reindexer.DefaultNamespaceOptions().
+		QueryDefaults(reindexer.NamespaceQueryDefaults{Timeout: 10 * time.Second}), TestItemQueryDefaults{}))
+	require.NoError(t, DBD.OpenNamespace(nsNoCache, reindexer.DefaultNamespaceOptions().
+		QueryDefaults(reindexer.NamespaceQueryDefaults{BypassObjCache: true}), TestItemQueryDefaults{}))
+	defer DBD.DropNamespace(nsFetch)
+	defer DBD.DropNamespace(nsLongTimeout)
+	defer DBD.DropNamespace(nsNoCache)
+	defer DBD.DropNamespace(nsTimeout)
+	defer DBD.DropNamespace(nsJoin)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, DBD.Upsert(nsFetch, &TestItemQueryDefaults{ID: i, Year: 2000 + i}))
+		require.NoError(t, DBD.Upsert(nsTimeout, &TestItemQueryDefaults{ID: i, Year: 2000 + i}))
+		require.NoError(t, DBD.Upsert(nsJoin, &TestJoinItemQueryDefaults{ID: i}))
+		require.NoError(t, DBD.Upsert(nsLongTimeout, &TestItemQueryDefaults{ID: i, Year: 2000 + i}))
+		require.NoError(t, DBD.Upsert(nsNoCache, &TestItemQueryDefaults{ID: i, Year: 2000 + i}))
+	}
+
+	t.Run("defaults are applied", func(t *testing.T) {
+		items, err := DBD.Query(nsFetch).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+
+		_, err = DBD.Query(nsTimeout).Exec().FetchAll()
+		assert.Error(t, err)
+		_, err = DBD.Query(nsTimeout).Where("id", reindexer.EQ, 1).Delete()
+		assert.Error(t, err)
+	})
+
+	t.Run("defaults are overridden", func(t *testing.T) {
+		items, err := DBD.Query(nsFetch).FetchCount(100).Debug(0).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		items, err = DBD.Query(nsTimeout).ExecCtx(ctx).FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+	})
+
+	t.Run("defaults are applied to joined and merged queries", func(t *testing.T) {
+		q := DBD.Query(nsJoin)
+		q.InnerJoin(DBD.Query(nsTimeout), "joined").On("id", reindexer.EQ, "id")
+		_, err := q.Exec().FetchAll()
+		assert.Error(t, err)
+
+		q = DBD.Query(nsFetch)
+		q.Merge(DBD.Query(nsTimeout))
+		_, err = q.Exec().FetchAll()
+		assert.Error(t, err)
+
+		q = DBD.Query(nsJoin)
+		q.InnerJoin(DBD.Query(nsFetch), "joined").On("id", reindexer.EQ, "id")
+		items, err := q.Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+	})
+
+	t.Run("fetch count is applied", func(t *testing.T) {
+		if !strings.HasPrefix(*dsn, "cproto") {
+			t.Skip("results are fetched by chunks only via cproto")
+		}
+		var fetches int32
+		db := reindexer.NewReindex(*dsn, reindexer.WithSlowRPCHook(0, func(rpc reindexer.SlowRPC) {
+			if rpc.Cmd == 50 { // cmdFetchResults
+				atomic.AddInt32(&fetches, 1)
+			}
+		}))
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(nsFetch, reindexer.DefaultNamespaceOptions().
+			QueryDefaults(reindexer.NamespaceQueryDefaults{FetchCount: 1}), TestItemQueryDefaults{}))
+
+		items, err := db.Query(nsFetch).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+		assert.True(t, atomic.LoadInt32(&fetches) >= 9)
+
+		atomic.StoreInt32(&fetches, 0)
+		items, err = db.Query(nsFetch).FetchCount(100).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+		assert.Equal(t, int32(0), atomic.LoadInt32(&fetches))
+	})
+
+	t.Run("iterators with default timeout are usable after return", func(t *testing.T) {
+		it := DBD.Query(nsLongTimeout).ExecToJson()
+		require.NoError(t, it.Error())
+		count := 0
+		for it.Next() {
+			count++
+		}
+		require.NoError(t, it.Error())
+		it.Close()
+		assert.Equal(t, 10, count)
+
+		items, err := DBD.Query(nsLongTimeout).Where("id", reindexer.EQ, 1).Set("year", 1999).Update().FetchAll()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(items))
+		assert.Equal(t, 1999, items[0].(*TestItemQueryDefaults).Year)
+	})
+
+	t.Run("object cache bypass is applied", func(t *testing.T) {
+		items, err := DBD.Query(nsNoCache).Where("id", reindexer.EQ, 1).Exec().FetchAll()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(items))
+		items[0].(*TestItemQueryDefaults).Year = 0
+
+		items, err = DBD.Query(nsNoCache).Where("id", reindexer.EQ, 1).Exec().FetchAll()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(items))
+		assert.Equal(t, 2001, items[0].(*TestItemQueryDefaults).Year)
+
+		items, err = DBD.Query(nsNoCache).Where("id", reindexer.EQ, 1).BypassObjCache(false).Exec().FetchAll()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(items))
+		assert.Equal(t, 2001, items[0].(*TestItemQueryDefaults).Year)
+	})
+}
@@ -1,6 +1,7 @@
 package reindexer
 
 import (
+	"context"
 	"encoding/json"
 	"math/rand"
 	"sync"
@@ -233,6 +234,27 @@ func BenchmarkInsertJson(b *testing.B) {
 	tx.MustCommit()
 }
 
+func BenchmarkUpsertRawJSON(b *testing.B) {
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		if err := DBD.UpsertJSON(ctx, "test_items_insert_json", testItemsJsonSeed[i%len(testItemsJsonSeed)]); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalAndUpsert(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		item := TestItem{}
+		if err := json.Unmarshal(testItemsJsonSeed[i%len(testItemsJsonSeed)], &item); err != nil {
+			panic(err)
+		}
+		if err := DBD.Upsert("test_items_insert_json", &item); err != nil {
+			panic(err)
+		}
+	}
+}
+
 func BenchmarkUpdate(b *testing.B) {
 	tx := DBD.MustBeginTx("test_items_insert")
 
@@ -481,6 +503,35 @@ func Benchmark2CondQueryInnerJoinCached(b *testing.B) {
 		q.MustExec().FetchAll()
 	}
 }
+// benchmarkInnerJoinCacheMode runs repeated join with the join cache mode of joined namespace
+func benchmarkInnerJoinCacheMode(b *testing.B, mode string) {
+	if err := DBD.SetNamespaceCacheConfig("test_join_items", reindexer.NamespaceCacheConfig{JoinCacheMode: mode, QueryCountCache: true}); err != nil {
+		panic(err)
+	}
+	defer DBD.SetNamespaceCacheConfig("test_join_items", reindexer.NamespaceCacheConfig{JoinCacheMode: reindexer.JoinCacheModeOff, QueryCountCache: true})
+
+	ctx := &TestJoinCtx{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q2 := DBD.Query("test_join_items").WhereString("device", reindexer.EQ, "ottstb").WhereString("location", reindexer.SET, "mos", "dv", "sib")
+		q := DBD.Query("test_items_bench").Limit(20).Sort("year", false).
+			WhereInt("genre", reindexer.EQ, 5).
+			WhereInt("year", reindexer.RANGE, 2010, 2016).
+			InnerJoin(q2, "prices").On("price_id", reindexer.SET, "id")
+		ctx.allPrices = ctx.allPrices[:0]
+		q.SetContext(ctx)
+		q.MustExec().FetchAll()
+	}
+}
+
+func Benchmark2CondQueryInnerJoinCacheOff(b *testing.B) {
+	benchmarkInnerJoinCacheMode(b, reindexer.JoinCacheModeOff)
+}
+
+func Benchmark2CondQueryInnerJoinCacheOn(b *testing.B) {
+	benchmarkInnerJoinCacheMode(b, reindexer.JoinCacheModeOn)
+}
+
 func Benchmark2CondQueryInnerJoinTotal(b *testing.B) {
 	ctx := &TestJoinCtx{}
 	for i := 0; i < b.N; i++ {
@@ -612,6 +663,34 @@ func BenchmarkFullScan(b *testing.B) {
 	}
 }
 
+func BenchmarkExtractColumns(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cols, err := DBD.Query("test_items_bench").ExecToColumns(context.Background(), reindexer.ColumnsSpec{Fields: []string{"id", "genre", "location"}})
+		if err != nil {
+			panic(err)
+		}
+		sum := int64(0)
+		for _, genre := range cols.Int64("genre") {
+			sum += genre
+		}
+	}
+}
+
+func BenchmarkExtractColumnsFetchAll(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		items, err := DBD.Query("test_items_bench").Select("id", "genre", "location").Exec().FetchAll()
+		if err != nil {
+			panic(err)
+		}
+		sum := int64(0)
+		for _, item := range items {
+			sum += item.(*TestItemBench).Genre
+		}
+	}
+}
+
 func BenchmarkSelectByPKAndUpdate(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		FillTestItemsBench(i, 1, 10)
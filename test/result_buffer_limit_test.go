@@ -0,0 +1,85 @@
+package reindexer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemResultBufferLimit struct {
+	ID   int    `reindex:"id,,pk"`
+	Data string `json:"data"`
+}
+
+func TestResultBufferLimit(t *testing.T) {
+	const ns = "test_items_result_buffer_limit"
+	// Each item takes a bit more than 1KB in results buffer
+	data := strings.Repeat("x", 1024)
+
+	t.Run("per query limit", func(t *testing.T) {
+		require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemResultBufferLimit{}))
+		defer DBD.DropNamespace(ns)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, DBD.Upsert(ns, &TestItemResultBufferLimit{ID: i, Data: data}))
+		}
+
+		items, err := DBD.Query(ns).Limit(2).MaxResultBufferBytes(5000).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 2, len(items))
+
+		_, err = DBD.Query(ns).MaxResultBufferBytes(5000).Exec().FetchAll()
+		assert.Equal(t, reindexer.ErrResultBufferLimit, err)
+
+		_, err = DBD.Query(ns).MaxResultBufferBytes(5000).ExecToJson().FetchAll()
+		assert.Equal(t, reindexer.ErrResultBufferLimit, err)
+		json, err := DBD.Query(ns).Limit(2).MaxResultBufferBytes(5000).ExecToJson().FetchAll()
+		require.NoError(t, err)
+		assert.NotEmpty(t, json)
+
+		// Results, which are fetched by small chunks, are not limited by their total size
+		if strings.HasPrefix(*dsn, "cproto") {
+			items, err = DBD.Query(ns).FetchCount(2).MaxResultBufferBytes(5000).Exec().FetchAll()
+			require.NoError(t, err)
+			assert.Equal(t, 100, len(items))
+		}
+	})
+
+	t.Run("client limit", func(t *testing.T) {
+		db := reindexer.NewReindex("builtin:///tmp/reindex_test_result_buffer_limit/", reindexer.WithMaxResultBufferBytes(25*1024))
+		defer db.Close()
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemResultBufferLimit{}))
+		defer db.DropNamespace(ns)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, db.Upsert(ns, &TestItemResultBufferLimit{ID: i, Data: data}))
+		}
+
+		it1 := db.Query(ns).Limit(10).Exec()
+		require.NoError(t, it1.Error())
+		it2 := db.Query(ns).Limit(10).Exec()
+		require.NoError(t, it2.Error())
+		defer it2.Close()
+
+		// Buffers of it1 and it2 are still retained
+		_, err := db.Query(ns).Limit(10).Exec().FetchAll()
+		assert.Equal(t, reindexer.ErrResultBufferLimit, err)
+
+		it1.Close()
+		items, err := db.Query(ns).Limit(10).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+
+		// Retained json is accounted too
+		jsonIt := db.Query(ns).Limit(10).ExecToJson()
+		require.NoError(t, jsonIt.Error())
+		_, err = db.Query(ns).Limit(10).Exec().FetchAll()
+		assert.Equal(t, reindexer.ErrResultBufferLimit, err)
+		jsonIt.Close()
+		items, err = db.Query(ns).Limit(10).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 10, len(items))
+	})
+}
@@ -0,0 +1,75 @@
+package reindexer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+func TestResultsLostOnReconnect(t *testing.T) {
+	type Item struct {
+		ID int `reindex:"id,,pk"`
+	}
+	const ns = "test_items_results_lost"
+	const itemsCount = 1000
+	const fetchCount = 100
+
+	srv := helpers.TestServer{T: t, RpcPort: "6693", HttpPort: "9993", DbName: "reindex_test_results_lost"}
+	require.NoError(t, srv.Run())
+	defer srv.Clean()
+	defer func() { srv.Stop() }()
+	dsn := fmt.Sprintf("cproto://%s/%s_%s", srv.Addr(), srv.DbName, srv.RpcPort)
+
+	db := reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing())
+	defer db.Close()
+	dbAuto := reindexer.NewReindex(dsn, reindexer.WithCreateDBIfMissing(), reindexer.WithAutoReexecute())
+	defer dbAuto.Close()
+
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), Item{}))
+	require.NoError(t, dbAuto.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), Item{}))
+	for i := 0; i < itemsCount; i++ {
+		require.NoError(t, db.Upsert(ns, Item{ID: i}))
+	}
+
+	// iterate reads results and restarts server after the first restartAfter items
+	iterate := func(db *reindexer.Reindexer, restartAfter int) (ids []int, err error) {
+		it := db.Query(ns).Sort("id", false).Offset(10).FetchCount(fetchCount).Exec()
+		defer it.Close()
+		for it.Next() {
+			ids = append(ids, it.Object().(*Item).ID)
+			if len(ids) == restartAfter {
+				require.NoError(t, srv.Stop())
+				require.NoError(t, srv.Run())
+			}
+		}
+		return ids, it.Error()
+	}
+
+	t.Run("typed error", func(t *testing.T) {
+		ids, err := iterate(db, fetchCount+fetchCount/2)
+		require.Error(t, err)
+		lost, ok := err.(*reindexer.ErrResultsLostOnReconnect)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.Equal(t, 2*fetchCount, lost.Consumed)
+		assert.Equal(t, reindexer.ErrCodeNetwork, lost.Code())
+		assert.Equal(t, 2*fetchCount, len(ids))
+
+		// Client is reconnected and works after the error
+		_, err = db.Query(ns).Limit(1).Exec().FetchAll()
+		assert.NoError(t, err)
+	})
+
+	t.Run("auto reexecute", func(t *testing.T) {
+		ids, err := iterate(dbAuto, fetchCount+fetchCount/2)
+		require.NoError(t, err)
+		require.Equal(t, itemsCount-10, len(ids))
+		for i, id := range ids {
+			require.Equal(t, i+10, id)
+		}
+	})
+}
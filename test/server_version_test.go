@@ -0,0 +1,203 @@
+package reindexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/test/helpers"
+)
+
+type TestItemServerVersion struct {
+	ID      int `reindex:"id,,pk"`
+	Payload struct {
+		Value int
+	}
+}
+
+func assertUnsupported(t *testing.T, err error, feature string, required string, actual string) {
+	require.Error(t, err)
+	uerr, ok := err.(reindexer.ErrServerUnsupported)
+	require.True(t, ok, "unexpected error: %v", err)
+	assert.Contains(t, uerr.Feature, feature)
+	assert.Equal(t, required, uerr.Required)
+	assert.Equal(t, actual, uerr.Actual)
+	assert.Equal(t, reindexer.ErrCodeParams, uerr.Code())
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		v1, v2   string
+		expected int
+	}{
+		{"v2.9.1", "v2.9.1", 0},
+		{"2.9.1", "v2.9.1", 0},
+		{"v2.9.1-12-g1a2b3c4", "v2.9.1", 0},
+		{"v2.10.0", "v2.9.1", 1},
+		{"v2.2.3", "v2.2.4", -1},
+		{"v3", "v2.9.9", 1},
+		{"v2.9", "v2.9.1", -1},
+	}
+	for _, c := range cases {
+		res, err := reindexer.CompareVersions(c.v1, c.v2)
+		require.NoError(t, err)
+		assert.Equal(t, c.expected, res, "%s <=> %s", c.v1, c.v2)
+	}
+
+	for _, v := range []string{"", "v", "va.b.c", "v1.2.3.4", "v1.-2.3"} {
+		_, err := reindexer.CompareVersions(v, "v2.9.1")
+		assert.Error(t, err, v)
+	}
+}
+
+func TestServerFeatureGating(t *testing.T) {
+	const ns = "items"
+
+	connect := func(t *testing.T, version string) (*reindexer.Reindexer, func()) {
+		srv := &helpers.FakeServer{Version: version}
+		require.NoError(t, srv.Run())
+		db := reindexer.NewReindex("cproto://" + srv.Addr() + "/db")
+		require.NoError(t, db.Status().Err)
+		require.NoError(t, db.RegisterNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemServerVersion{}))
+		return db, func() {
+			db.Close()
+			srv.Stop()
+		}
+	}
+
+	t.Run("server version is reported", func(t *testing.T) {
+		db, stop := connect(t, "v2.5.0")
+		defer stop()
+		assert.Equal(t, "v2.5.0", db.ServerVersion())
+	})
+
+	t.Run("old server", func(t *testing.T) {
+		db, stop := connect(t, "v2.2.0")
+		defer stop()
+
+		assertUnsupported(t, db.RenameNamespace(ns, "items_renamed"), "Rename namespace", "v2.5.3", "v2.2.0")
+
+		tx, err := db.BeginTx(ns)
+		require.NoError(t, err)
+		_, err = tx.Query().Where("id", reindexer.EQ, 1).Delete()
+		assertUnsupported(t, err, "transaction", "v2.2.4", "v2.2.0")
+		err = tx.Query().Where("id", reindexer.EQ, 1).Set("id", 2).Update().Error()
+		assertUnsupported(t, err, "transaction", "v2.2.4", "v2.2.0")
+
+		var obj TestItemServerVersion
+		err = db.Query(ns).Where("id", reindexer.EQ, 1).SetObject("payload", obj.Payload).Update().Error()
+		assertUnsupported(t, err, "object", "v2.6.2", "v2.2.0")
+	})
+
+	t.Run("server between features", func(t *testing.T) {
+		db, stop := connect(t, "v2.5.3")
+		defer stop()
+
+		assert.NoError(t, db.RenameNamespace(ns, "items_renamed"))
+		require.NoError(t, db.RegisterNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemServerVersion{}))
+
+		tx, err := db.BeginTx(ns)
+		require.NoError(t, err)
+		_, err = tx.Query().Where("id", reindexer.EQ, 1).Delete()
+		assert.NoError(t, err)
+
+		var obj TestItemServerVersion
+		err = db.Query(ns).Where("id", reindexer.EQ, 1).SetObject("payload", obj.Payload).Update().Error()
+		assertUnsupported(t, err, "object", "v2.6.2", "v2.5.3")
+	})
+
+	t.Run("new server", func(t *testing.T) {
+		db, stop := connect(t, "v2.9.1")
+		defer stop()
+
+		assert.NoError(t, db.RenameNamespace(ns, "items_renamed"))
+		require.NoError(t, db.RegisterNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemServerVersion{}))
+
+		tx, err := db.BeginTx(ns)
+		require.NoError(t, err)
+		_, err = tx.Query().Where("id", reindexer.EQ, 1).Delete()
+		assert.NoError(t, err)
+		err = tx.Query().Where("id", reindexer.EQ, 1).Set("id", 2).Update().Error()
+		assert.NoError(t, err)
+	})
+	t.Run("update query is closed on gating error", func(t *testing.T) {
+		db, stop := connect(t, "v2.2.0")
+		defer stop()
+
+		var obj TestItemServerVersion
+		q := db.Query(ns).Where("id", reindexer.EQ, 1).SetObject("payload", obj.Payload)
+		assertUnsupported(t, q.Update().Error(), "object", "v2.6.2", "v2.2.0")
+		assert.Panics(t, func() { q.Update() })
+	})
+}
+
+func TestServerCapabilityGating(t *testing.T) {
+	const ns = "items"
+	const (
+		cmdDeleteQuery = 34
+		cmdUpdateQuery = 35
+		cmdSelect      = 48
+	)
+
+	connect := func(t *testing.T, caps int64) (*reindexer.Reindexer, *helpers.FakeServer, func()) {
+		srv := &helpers.FakeServer{Version: "v2.9.1", Capabilities: caps, ErrCode: bindings.ErrLogic}
+		require.NoError(t, srv.Run())
+		db := reindexer.NewReindex("cproto://" + srv.Addr() + "/db")
+		require.NoError(t, db.Status().Err)
+		require.NoError(t, db.RegisterNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemServerVersion{}))
+		return db, srv, func() {
+			db.Close()
+			srv.Stop()
+		}
+	}
+	lastCmd := func(srv *helpers.FakeServer) uint16 {
+		cmds := srv.Commands()
+		require.NotEmpty(t, cmds)
+		return cmds[len(cmds)-1]
+	}
+
+	t.Run("dry run is emulated without capability", func(t *testing.T) {
+		db, srv, stop := connect(t, 0)
+		defer stop()
+
+		_, err := db.Query(ns).Where("id", reindexer.EQ, 1).DryRun().Delete()
+		assert.Error(t, err)
+		assert.Equal(t, uint16(cmdSelect), lastCmd(srv))
+
+		err = db.Query(ns).Where("id", reindexer.EQ, 1).Set("id", 2).DryRun().Update().Error()
+		assert.Error(t, err)
+		assert.Equal(t, uint16(cmdSelect), lastCmd(srv))
+	})
+
+	t.Run("dry run is sent to server with capability", func(t *testing.T) {
+		db, srv, stop := connect(t, bindings.ServerCapDryRun)
+		defer stop()
+
+		_, err := db.Query(ns).Where("id", reindexer.EQ, 1).DryRun().Delete()
+		assert.Error(t, err)
+		assert.Equal(t, uint16(cmdDeleteQuery), lastCmd(srv))
+
+		err = db.Query(ns).Where("id", reindexer.EQ, 1).Set("id", 2).DryRun().Update().Error()
+		assert.Error(t, err)
+		assert.Equal(t, uint16(cmdUpdateQuery), lastCmd(srv))
+	})
+
+	t.Run("state is read from memstats before select without capability", func(t *testing.T) {
+		db, srv, stop := connect(t, 0)
+		defer stop()
+
+		assert.Error(t, db.Query(ns).Where("id", reindexer.EQ, 1).Exec().Error())
+		assert.Equal(t, []uint16{cmdSelect, cmdSelect}, srv.Commands())
+	})
+
+	t.Run("state is not read from memstats with capability", func(t *testing.T) {
+		db, srv, stop := connect(t, bindings.ServerCapStateToken)
+		defer stop()
+
+		assert.Error(t, db.Query(ns).Where("id", reindexer.EQ, 1).Exec().Error())
+		assert.Equal(t, []uint16{cmdSelect}, srv.Commands())
+	})
+}
@@ -0,0 +1,304 @@
+package reindexer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/reindexertest"
+)
+
+type TestItemSnapshot struct {
+	ID    int      `reindex:"id,,pk"`
+	Name  string   `reindex:"name,tree"`
+	Tags  []string `reindex:"tags"`
+	Price float64  `reindex:"price"`
+	Year  int      `reindex:"year,tree,dense"`
+}
+
+func TestSnapshot(t *testing.T) {
+	const ns = "test_items_snapshot"
+	const copyNs = "test_items_snapshot_copy"
+	ctx := context.Background()
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().NoStorage(), TestItemSnapshot{}))
+	defer DBD.DropNamespace(ns)
+	defer DBD.DropNamespace(copyNs)
+	for i := 0; i < 2500; i++ {
+		require.NoError(t, DBD.Upsert(ns, &TestItemSnapshot{ID: i, Name: fmt.Sprintf("item%d", i), Tags: []string{"t", fmt.Sprint(i % 7)},
+			Price: float64(i) / 4, Year: 2000 + i%20}))
+	}
+	require.NoError(t, DBD.PutMeta(ns, "schema_version", []byte("7")))
+
+	items := func(ns string) []TestItemSnapshot {
+		found, err := DBD.Query(ns).Sort("id", false).Exec().FetchAll()
+		require.NoError(t, err)
+		items := make([]TestItemSnapshot, 0, len(found))
+		for _, item := range found {
+			items = append(items, *item.(*TestItemSnapshot))
+		}
+		return items
+	}
+	indexes := func(ns string) []reindexer.IndexDescription {
+		desc, err := DBD.DescribeNamespace(ns)
+		require.NoError(t, err)
+		return desc.Indexes
+	}
+
+	var snapshot bytes.Buffer
+	require.NoError(t, DBD.ExportSnapshot(ctx, ns, &snapshot, reindexer.SnapshotOptions{PageSize: 300, MetaKeys: []string{"schema_version"}}))
+
+	// export -> import to another namespace -> diff
+	var progress []int64
+	require.NoError(t, DBD.ImportSnapshot(ctx, copyNs, bytes.NewReader(snapshot.Bytes()), reindexer.ImportSnapshotOptions{
+		Progress: func(imported int64) { progress = append(progress, imported) },
+	}))
+	assert.Equal(t, []int64{1000, 2000, 2500}, progress)
+	require.NoError(t, DBD.OpenNamespace(copyNs, reindexer.DefaultNamespaceOptions().NoStorage(), TestItemSnapshot{}))
+	assert.Equal(t, items(ns), items(copyNs))
+	assert.Equal(t, indexes(ns), indexes(copyNs))
+	meta, err := DBD.GetMeta(copyNs, "schema_version")
+	require.NoError(t, err)
+	assert.Equal(t, "7", string(meta))
+
+	// export -> drop -> import -> diff
+	want := items(ns)
+	require.NoError(t, DBD.DropNamespace(ns))
+	require.NoError(t, DBD.ImportSnapshot(ctx, ns, bytes.NewReader(snapshot.Bytes()), reindexer.ImportSnapshotOptions{}))
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().NoStorage(), TestItemSnapshot{}))
+	assert.Equal(t, want, items(ns))
+
+	err = DBD.ImportSnapshot(ctx, ns, bytes.NewReader(snapshot.Bytes()), reindexer.ImportSnapshotOptions{})
+	require.Error(t, err)
+	assert.Equal(t, reindexer.ErrCodeConflict, err.(reindexer.Error).Code())
+}
+
+type TestItemSnapshotInMemory struct {
+	ID     int                `reindex:"id,,pk" json:"id"`
+	Name   string             `reindex:"name" json:"name"`
+	Tags   []string           `reindex:"tags" json:"tags"`
+	Price  float64            `json:"price"`
+	Nested TestNestedInMemory `json:"nested"`
+}
+
+type TestItemSnapshotCompositeInMemory struct {
+	Key   int      `reindex:"key" json:"key"`
+	Value string   `reindex:"value" json:"value"`
+	_     struct{} `reindex:"key+value,,composite,pk"`
+}
+
+type TestItemSnapshotNoPKInMemory struct {
+	Name string `reindex:"name" json:"name"`
+}
+
+// writerFunc - io.Writer, which calls function
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestSnapshotInMemory(t *testing.T) {
+	const ns = "test_items_snapshot_in_memory"
+	ctx := context.Background()
+	hooks := &reindexertest.Hooks{}
+	db := reindexertest.NewInMemory(reindexertest.WithHooks(hooks))
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemSnapshotInMemory{}))
+	for i := 1; i <= 25; i++ {
+		item := &TestItemSnapshotInMemory{ID: i, Name: fmt.Sprintf("item%d", i), Tags: []string{"t1", fmt.Sprintf("t%d", i)}, Price: float64(i) + 0.5,
+			Nested: TestNestedInMemory{Code: fmt.Sprintf("c%d", i%3), Level: i}}
+		require.NoError(t, db.Upsert(ns, item))
+	}
+	require.NoError(t, db.PutMeta(ns, "version", []byte("3")))
+	require.NoError(t, db.PutMeta(ns, "owner", []byte("backup")))
+
+	open := func(ns string) {
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemSnapshotInMemory{}))
+	}
+	items := func(ns string) []TestItemSnapshotInMemory {
+		found, err := db.Query(ns).Sort("id", false).Exec().FetchAll()
+		require.NoError(t, err)
+		items := make([]TestItemSnapshotInMemory, 0, len(found))
+		for _, item := range found {
+			items = append(items, *item.(*TestItemSnapshotInMemory))
+		}
+		return items
+	}
+	meta := func(ns string) map[string]string {
+		meta := make(map[string]string)
+		for _, key := range []string{"version", "owner"} {
+			data, err := db.GetMeta(ns, key)
+			require.NoError(t, err)
+			meta[key] = string(data)
+		}
+		return meta
+	}
+	indexes := func(ns string) []reindexer.IndexDescription {
+		desc, err := db.DescribeNamespace(ns)
+		require.NoError(t, err)
+		return desc.Indexes
+	}
+	export := func(ns string) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, db.ExportSnapshot(ctx, ns, &buf, reindexer.SnapshotOptions{PageSize: 4, MarkerInterval: 10}))
+		return buf.Bytes()
+	}
+	var progress []int64
+	importOpts := func(opts reindexer.ImportSnapshotOptions) reindexer.ImportSnapshotOptions {
+		progress = nil
+		opts.Progress = func(imported int64) { progress = append(progress, imported) }
+		return opts
+	}
+
+	want, wantMeta, wantIndexes := items(ns), meta(ns), indexes(ns)
+	require.Len(t, want, 25)
+	assert.Equal(t, map[string]string{"version": "3", "owner": "backup"}, wantMeta)
+	snapshot := export(ns)
+
+	t.Run("round trip", func(t *testing.T) {
+		require.NoError(t, db.DropNamespace(ns))
+		require.NoError(t, db.ImportSnapshot(ctx, ns, bytes.NewReader(snapshot), importOpts(reindexer.ImportSnapshotOptions{})))
+		assert.Equal(t, []int64{10, 20, 25}, progress)
+		open(ns)
+		assert.Equal(t, want, items(ns))
+		assert.Equal(t, wantMeta, meta(ns))
+		assert.Equal(t, wantIndexes, indexes(ns))
+	})
+
+	t.Run("not registered namespace", func(t *testing.T) {
+		const copyNs, copy2Ns = "test_items_snapshot_in_memory_copy", "test_items_snapshot_in_memory_copy2"
+		require.NoError(t, db.ImportSnapshot(ctx, copyNs, bytes.NewReader(snapshot), reindexer.ImportSnapshotOptions{}))
+		// Namespace, which is created by import, is exported without its Go type
+		copySnapshot := export(copyNs)
+		require.NoError(t, db.ImportSnapshot(ctx, copy2Ns, bytes.NewReader(copySnapshot), reindexer.ImportSnapshotOptions{}))
+		open(copy2Ns)
+		assert.Equal(t, want, items(copy2Ns))
+		assert.Equal(t, wantMeta, meta(copy2Ns))
+
+		err := db.ExportSnapshot(ctx, "test_items_snapshot_in_memory_missing", &bytes.Buffer{}, reindexer.SnapshotOptions{})
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeNotFound, err.(reindexer.Error).Code())
+	})
+
+	t.Run("pages by primary keys", func(t *testing.T) {
+		const pagesNs, pagesCopyNs = "test_items_snapshot_in_memory_pages", "test_items_snapshot_in_memory_pages_copy"
+		require.NoError(t, db.ImportSnapshot(ctx, pagesNs, bytes.NewReader(snapshot), reindexer.ImportSnapshotOptions{}))
+		open(pagesNs)
+		// Items of the first page are deleted, while it's written, so offset of the next page would skip the next items
+		deleted := false
+		var buf bytes.Buffer
+		w := writerFunc(func(p []byte) (int, error) {
+			if !deleted && bytes.Contains(p, []byte(`"item4"`)) {
+				deleted = true
+				for id := 1; id <= 4; id++ {
+					require.NoError(t, db.Delete(pagesNs, &TestItemSnapshotInMemory{ID: id}))
+				}
+			}
+			return buf.Write(p)
+		})
+		require.NoError(t, db.ExportSnapshot(ctx, pagesNs, w, reindexer.SnapshotOptions{PageSize: 4, MarkerInterval: 10}))
+		require.True(t, deleted)
+		require.NoError(t, db.ImportSnapshot(ctx, pagesCopyNs, &buf, reindexer.ImportSnapshotOptions{}))
+		open(pagesCopyNs)
+		assert.Equal(t, want, items(pagesCopyNs))
+
+		const compositeNs, compositeCopyNs = "test_items_snapshot_in_memory_composite", "test_items_snapshot_in_memory_composite_copy"
+		compositeItems := func(ns string) []interface{} {
+			found, err := db.Query(ns).Sort("key", false).Sort("value", false).Exec().FetchAll()
+			require.NoError(t, err)
+			return found
+		}
+		require.NoError(t, db.OpenNamespace(compositeNs, reindexer.DefaultNamespaceOptions(), TestItemSnapshotCompositeInMemory{}))
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Upsert(compositeNs, &TestItemSnapshotCompositeInMemory{Key: i / 3, Value: fmt.Sprint(i % 3)}))
+		}
+		buf.Reset()
+		require.NoError(t, db.ExportSnapshot(ctx, compositeNs, &buf, reindexer.SnapshotOptions{PageSize: 4}))
+		require.NoError(t, db.ImportSnapshot(ctx, compositeCopyNs, &buf, reindexer.ImportSnapshotOptions{}))
+		require.NoError(t, db.OpenNamespace(compositeCopyNs, reindexer.DefaultNamespaceOptions(), TestItemSnapshotCompositeInMemory{}))
+		assert.Len(t, compositeItems(compositeCopyNs), 10)
+		assert.Equal(t, compositeItems(compositeNs), compositeItems(compositeCopyNs))
+
+		const noPKNs = "test_items_snapshot_in_memory_no_pk"
+		require.NoError(t, db.OpenNamespace(noPKNs, reindexer.DefaultNamespaceOptions(), TestItemSnapshotNoPKInMemory{}))
+		err := db.ExportSnapshot(ctx, noPKNs, &bytes.Buffer{}, reindexer.SnapshotOptions{})
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParams, err.(reindexer.Error).Code())
+	})
+
+	t.Run("conflict policies", func(t *testing.T) {
+		err := db.ImportSnapshot(ctx, ns, bytes.NewReader(snapshot), reindexer.ImportSnapshotOptions{})
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeConflict, err.(reindexer.Error).Code())
+
+		changed := want[0]
+		changed.Name = "changed"
+		extra := TestItemSnapshotInMemory{ID: 100, Name: "extra", Tags: []string{}}
+		modify := func() {
+			require.NoError(t, db.Upsert(ns, &changed))
+			require.NoError(t, db.Upsert(ns, &extra))
+			require.NoError(t, db.PutMeta(ns, "owner", []byte("changed")))
+		}
+
+		modify()
+		require.NoError(t, db.ImportSnapshot(ctx, ns, bytes.NewReader(snapshot), reindexer.ImportSnapshotOptions{Conflict: reindexer.SnapshotConflictMerge}))
+		assert.Equal(t, append(append([]TestItemSnapshotInMemory{}, want...), extra), items(ns))
+		assert.Equal(t, wantMeta, meta(ns))
+
+		modify()
+		require.NoError(t, db.ImportSnapshot(ctx, ns, bytes.NewReader(snapshot), reindexer.ImportSnapshotOptions{Conflict: reindexer.SnapshotConflictOverwrite}))
+		open(ns)
+		assert.Equal(t, want, items(ns))
+		assert.Equal(t, wantMeta, meta(ns))
+		assert.Equal(t, wantIndexes, indexes(ns))
+	})
+
+	t.Run("corrupted snapshot", func(t *testing.T) {
+		corrupted := append([]byte{}, snapshot...)
+		pos := bytes.Index(corrupted, []byte(`"item15"`))
+		require.True(t, pos > 0)
+		corrupted[pos+1] = 'j'
+		const corruptedNs = "test_items_snapshot_in_memory_corrupted"
+		err := db.ImportSnapshot(ctx, corruptedNs, bytes.NewReader(corrupted), importOpts(reindexer.ImportSnapshotOptions{}))
+		require.Error(t, err)
+		assert.Equal(t, reindexer.ErrCodeParseBin, err.(reindexer.Error).Code())
+		assert.Contains(t, err.Error(), "checksum mismatch after 20 documents")
+		// Documents after the last verified marker are not committed
+		assert.Equal(t, []int64{10}, progress)
+		open(corruptedNs)
+		assert.Equal(t, want[:10], items(corruptedNs))
+
+		for _, data := range [][]byte{snapshot[:len(snapshot)/2], snapshot[:len(snapshot)-1]} {
+			err = db.ImportSnapshot(ctx, "test_items_snapshot_in_memory_truncated", bytes.NewReader(data), reindexer.ImportSnapshotOptions{Conflict: reindexer.SnapshotConflictOverwrite})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "unexpected end of stream")
+		}
+		err = db.ImportSnapshot(ctx, "test_items_snapshot_in_memory_unknown", strings.NewReader("not a snapshot"), reindexer.ImportSnapshotOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown format")
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		const resumedNs = "test_items_snapshot_in_memory_resumed"
+		injected := bindings.NewError("injected", bindings.ErrNetwork)
+		hooks.InjectErrorAfter(reindexertest.OpCommitTx, 1, injected)
+		err := db.ImportSnapshot(ctx, resumedNs, bytes.NewReader(snapshot), importOpts(reindexer.ImportSnapshotOptions{}))
+		hooks.InjectError(reindexertest.OpCommitTx, nil)
+		assert.Equal(t, injected, err)
+		require.Equal(t, []int64{10}, progress)
+
+		resumed := progress[len(progress)-1]
+		require.NoError(t, db.ImportSnapshot(ctx, resumedNs, bytes.NewReader(snapshot), importOpts(reindexer.ImportSnapshotOptions{ResumeFrom: resumed})))
+		assert.Equal(t, []int64{20, 25}, progress)
+		open(resumedNs)
+		assert.Equal(t, want, items(resumedNs))
+		assert.Equal(t, wantMeta, meta(resumedNs))
+	})
+}
@@ -0,0 +1,93 @@
+package reindexer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemSoftDelete struct {
+	ID        int                        `reindex:"id,,pk"`
+	OwnerID   int                        `reindex:"owner_id"`
+	Deleted   bool                       `reindex:"deleted"`
+	DeletedAt int64                      `reindex:"deleted_at"`
+	Owners    []*TestItemSoftDeleteOwner `reindex:"owners,,joined"`
+}
+
+type TestItemSoftDeleteOwner struct {
+	ID      int  `reindex:"id,,pk"`
+	Deleted bool `reindex:"deleted"`
+}
+
+func TestSoftDelete(t *testing.T) {
+	const ns = "test_items_soft_delete"
+	const nsOwners = "test_items_soft_delete_owners"
+	ctx := context.Background()
+	opts := reindexer.DefaultNamespaceOptions().SoftDeleteWith(reindexer.SoftDeleteOptions{Field: "deleted", TimeField: "deleted_at"})
+	require.NoError(t, DBD.OpenNamespace(ns, opts, TestItemSoftDelete{}))
+	defer DBD.DropNamespace(ns)
+	require.NoError(t, DBD.OpenNamespace(nsOwners, reindexer.DefaultNamespaceOptions().SoftDelete("deleted"), TestItemSoftDeleteOwner{}))
+	defer DBD.DropNamespace(nsOwners)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, DBD.Upsert(ns, &TestItemSoftDelete{ID: i, OwnerID: i % 2}))
+	}
+	require.NoError(t, DBD.Upsert(nsOwners, &TestItemSoftDeleteOwner{ID: 0}))
+	require.NoError(t, DBD.Upsert(nsOwners, &TestItemSoftDeleteOwner{ID: 1}))
+	ids := func(q *reindexer.Query) (ids []int) {
+		items, err := q.Sort("id", false).Exec().FetchAll()
+		require.NoError(t, err)
+		for _, item := range items {
+			ids = append(ids, item.(*TestItemSoftDelete).ID)
+		}
+		return ids
+	}
+
+	t.Run("delete marks items", func(t *testing.T) {
+		require.NoError(t, DBD.Delete(ns, &TestItemSoftDelete{ID: 8}))
+		require.NoError(t, DBD.Delete(ns, &TestItemSoftDelete{ID: 9}))
+		item, found := DBD.Query(ns).IncludeDeleted().WhereInt("id", reindexer.EQ, 9).Get()
+		require.True(t, found)
+		assert.True(t, item.(*TestItemSoftDelete).Deleted)
+		assert.InDelta(t, time.Now().Unix(), item.(*TestItemSoftDelete).DeletedAt, 60)
+	})
+
+	t.Run("queries exclude deleted items", func(t *testing.T) {
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, ids(DBD.Query(ns)))
+		assert.Equal(t, []int{7}, ids(DBD.Query(ns).WhereInt("id", reindexer.EQ, 7).Or().WhereInt("id", reindexer.EQ, 8)))
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, ids(DBD.Query(ns).IncludeDeleted()))
+	})
+
+	t.Run("joined queries exclude deleted items", func(t *testing.T) {
+		require.NoError(t, DBD.Delete(nsOwners, &TestItemSoftDeleteOwner{ID: 1}))
+		q := DBD.Query(ns).InnerJoin(DBD.Query(nsOwners), "owners").On("owner_id", reindexer.EQ, "id")
+		assert.Equal(t, []int{0, 2, 4, 6}, ids(q))
+		q = DBD.Query(ns).InnerJoin(DBD.Query(nsOwners).IncludeDeleted(), "owners").On("owner_id", reindexer.EQ, "id")
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, ids(q))
+
+		items, err := DBD.Query(ns).WhereInt("id", reindexer.LT, 2).Sort("id", false).
+			LeftJoin(DBD.Query(nsOwners), "owners").On("owner_id", reindexer.EQ, "id").Exec().FetchAll()
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+		assert.Len(t, items[0].(*TestItemSoftDelete).Owners, 1)
+		assert.Empty(t, items[1].(*TestItemSoftDelete).Owners)
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		count, err := DBD.Purge(ctx, ns, time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+		count, err = DBD.Purge(ctx, ns, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, ids(DBD.Query(ns).IncludeDeleted()))
+
+		_, err = DBD.Purge(ctx, nsOwners, time.Hour)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,58 @@
+package reindexer
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemSQLSuggestions struct {
+	ID   int    `reindex:"id,,pk"`
+	Year int    `reindex:"year,tree"`
+	Name string `reindex:"name"`
+}
+
+func TestSQLSuggestions(t *testing.T) {
+	const ns = "test_items_sql_suggestions"
+	ctx := context.Background()
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemSQLSuggestions{}))
+	defer DBD.DropNamespace(ns)
+
+	suggest := func(query string) []string {
+		suggestions, err := DBD.GetSQLSuggestions(ctx, query, len(query)-1)
+		require.NoError(t, err)
+		unique := make(map[string]struct{}, len(suggestions))
+		for _, s := range suggestions {
+			unique[s] = struct{}{}
+		}
+		assert.Equal(t, len(unique), len(suggestions), "suggestions must not be duplicated: %v", suggestions)
+		return suggestions
+	}
+	hasPrefix := func(suggestions []string, prefix string) {
+		for _, s := range suggestions {
+			assert.True(t, strings.HasPrefix(s, prefix), "suggestion '%s' doesn't match '%s'", s, prefix)
+		}
+	}
+
+	t.Run("namespace after FROM", func(t *testing.T) {
+		assert.Contains(t, suggest("SELECT * FROM "), ns)
+		suggestions := suggest("SELECT * FROM test_items_sql_sug")
+		assert.Contains(t, suggestions, ns)
+		hasPrefix(suggestions, "test_items_sql_sug")
+	})
+
+	t.Run("field after WHERE", func(t *testing.T) {
+		suggestions := suggest("SELECT * FROM " + ns + " WHERE ")
+		for _, index := range []string{"id", "year", "name"} {
+			assert.Contains(t, suggestions, index)
+		}
+		suggestions = suggest("SELECT * FROM " + ns + " WHERE ye")
+		assert.Contains(t, suggestions, "year")
+		hasPrefix(suggestions, "ye")
+	})
+}
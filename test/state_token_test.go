@@ -0,0 +1,92 @@
+package reindexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemStateToken struct {
+	ID   int    `reindex:"id,,pk"`
+	Name string `reindex:"name"`
+}
+
+func TestStateToken(t *testing.T) {
+	const ns = "test_items_state_token"
+	const itemsCount = 30
+	const pageSize = 10
+	ctx := context.Background()
+
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemStateToken{}))
+	defer DBD.DropNamespace(ns)
+	for i := 0; i < itemsCount; i++ {
+		require.NoError(t, DBD.Upsert(ns, &TestItemStateToken{ID: i, Name: "item"}))
+	}
+
+	// page returns ids of the page and state token, captured by the page query
+	page := func(offset int, token reindexer.StateToken) ([]int, reindexer.StateToken, error) {
+		q := DBD.Query(ns).Sort("id", false).Offset(offset).Limit(pageSize)
+		if len(token) != 0 {
+			q.RequireState(token)
+		}
+		it := q.Exec()
+		defer it.Close()
+		if it.Error() != nil {
+			return nil, "", it.Error()
+		}
+		var ids []int
+		for it.Next() {
+			ids = append(ids, it.Object().(*TestItemStateToken).ID)
+		}
+		newToken, err := it.StateToken()
+		return ids, newToken, err
+	}
+
+	t.Run("unchanged namespace", func(t *testing.T) {
+		ids, token, err := page(0, "")
+		require.NoError(t, err)
+		require.NotEmpty(t, token)
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, ids)
+
+		qToken, err := DBD.Query(ns).StateToken(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, token, qToken)
+
+		for offset := pageSize; offset < itemsCount; offset += pageSize {
+			ids, next, err := page(offset, token)
+			require.NoError(t, err)
+			assert.Equal(t, token, next)
+			assert.Len(t, ids, pageSize)
+			assert.Equal(t, offset, ids[0])
+		}
+	})
+
+	t.Run("modification between pages", func(t *testing.T) {
+		_, token, err := page(0, "")
+		require.NoError(t, err)
+		require.NoError(t, DBD.Upsert(ns, &TestItemStateToken{ID: itemsCount, Name: "new"}))
+
+		_, _, err = page(pageSize, token)
+		require.Error(t, err)
+		mismatch, ok := err.(reindexer.ErrStateMismatch)
+		require.True(t, ok, "unexpected error: %v", err)
+		assert.Equal(t, ns, mismatch.Namespace)
+		assert.Equal(t, token, mismatch.Required)
+		assert.Equal(t, reindexer.ErrCodeStateMismatch, mismatch.Code())
+
+		_, newToken, err := page(0, "")
+		require.NoError(t, err)
+		assert.NotEqual(t, token, newToken)
+		_, _, err = page(pageSize, newToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, _, err := page(0, "invalid")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,53 @@
+package reindexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemSystemNs struct {
+	ID int `reindex:"id,,pk"`
+}
+
+func TestSystemNamespaces(t *testing.T) {
+	const ns = "test_items_system_ns"
+	require.NoError(t, DBD.OpenNamespace(ns, reindexer.DefaultNamespaceOptions(), TestItemSystemNs{}))
+	defer DBD.DropNamespace(ns)
+	require.NoError(t, DBD.Upsert(ns, &TestItemSystemNs{ID: 1}))
+
+	t.Run("read-only namespaces reject writes", func(t *testing.T) {
+		readOnly := reindexer.ErrSystemNamespace{Namespace: reindexer.MemstatsNamespaceName, ReadOnly: true}
+		assert.Equal(t, readOnly, DBD.Upsert(reindexer.MemstatsNamespaceName, &reindexer.NamespaceMemStat{Name: ns}))
+		assert.Equal(t, readOnly, DBD.UpsertJSON(context.Background(), reindexer.MemstatsNamespaceName, []byte(`{"name":"`+ns+`"}`)))
+		_, err := DBD.BeginTx(reindexer.MemstatsNamespaceName)
+		assert.Equal(t, readOnly, err)
+		assert.Equal(t, reindexer.ErrCodeForbidden, err.(reindexer.Error).Code())
+	})
+
+	t.Run("user struct is rejected", func(t *testing.T) {
+		err := DBD.OpenNamespace(reindexer.NamespacesNamespaceName, reindexer.DefaultNamespaceOptions(), TestItemSystemNs{})
+		assert.Equal(t, reindexer.ErrSystemNamespace{Namespace: reindexer.NamespacesNamespaceName}, err)
+		err = DBD.RegisterNamespace("#MemStats", reindexer.DefaultNamespaceOptions(), TestItemSystemNs{})
+		assert.Equal(t, reindexer.ErrSystemNamespace{Namespace: reindexer.MemstatsNamespaceName}, err)
+	})
+
+	t.Run("reads", func(t *testing.T) {
+		desc, err := DBD.DescribeNamespace(ns)
+		require.NoError(t, err)
+		assert.Equal(t, ns, desc.Name)
+		stat, err := DBD.GetNamespaceMemStat(ns)
+		require.NoError(t, err)
+		assert.Equal(t, 1, int(stat.ItemsCount))
+		item, err := DBD.Query(reindexer.ConfigNamespaceName).WhereString("type", reindexer.EQ, "namespaces").Exec().FetchOne()
+		require.NoError(t, err)
+		assert.IsType(t, &reindexer.DBConfigItem{}, item)
+	})
+
+	assert.True(t, reindexer.IsSystemNamespace(reindexer.ActivitystatsNamespaceName))
+	assert.False(t, reindexer.IsSystemNamespace(ns))
+}
@@ -0,0 +1,61 @@
+package reindexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+)
+
+type TestItemUnknownFields struct {
+	ID      int               `reindex:"id,,pk"`
+	Name    string            `reindex:"name"`
+	Unknown reindexer.Unknown `json:"-"`
+}
+
+func (item *TestItemUnknownFields) DeepCopy() interface{} {
+	copied := *item
+	copied.Unknown = item.Unknown.Copy()
+	return &copied
+}
+
+func TestUnknownFields(t *testing.T) {
+	const ns = "test_items_unknown_fields"
+	ctx := context.Background()
+	opts := reindexer.DefaultNamespaceOptions().UnknownFields(reindexer.PreserveUnknownFields)
+	require.NoError(t, DBD.OpenNamespace(ns, opts, TestItemUnknownFields{}))
+	defer DBD.DropNamespace(ns)
+	// The other service has its own index and nested object, which are not in the struct
+	require.NoError(t, DBD.AddIndex(ns, reindexer.IndexDef{Name: "rank", JSONPaths: []string{"rank"}, IndexType: "tree", FieldType: "int"}))
+	require.NoError(t, DBD.UpsertJSON(ctx, ns, []byte(`{"id":1,"name":"first","rank":5,"owner":{"name":"other","tags":["a","b"]}}`)))
+	get := func() *TestItemUnknownFields {
+		item, found := DBD.Query(ns).WhereInt("id", reindexer.EQ, 1).Get()
+		require.True(t, found)
+		return item.(*TestItemUnknownFields)
+	}
+
+	item := get()
+	owner := map[string]interface{}{"name": "other", "tags": []interface{}{"a", "b"}}
+	assert.Equal(t, reindexer.Unknown{"rank": 5, "owner": owner}, item.Unknown)
+
+	// Items of object cache are not changed by modifications of their copies
+	item.Unknown["rank"] = 7
+	assert.Equal(t, 5, get().Unknown["rank"])
+
+	item.Name = "changed"
+	require.NoError(t, DBD.Upsert(ns, item))
+	item = get()
+	assert.Equal(t, "changed", item.Name)
+	assert.Equal(t, reindexer.Unknown{"rank": 7, "owner": owner}, item.Unknown)
+	found, err := DBD.Query(ns).WhereInt("rank", reindexer.EQ, 7).Exec().FetchAll()
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	it := DBD.Query(ns).WhereInt("id", reindexer.EQ, 1).Set("rank", 8).Update()
+	require.NoError(t, it.Error())
+	it.Close()
+	assert.Equal(t, 8, get().Unknown["rank"])
+}
@@ -0,0 +1,204 @@
+package reindexer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/reindexertest"
+)
+
+type TestItemUpsertDedup struct {
+	ID      int    `reindex:"id,,pk"`
+	Name    string `reindex:"name"`
+	Year    int    `reindex:"year,tree"`
+	Payload string
+}
+
+type TestItemUpsertDedupInMemory struct {
+	ID      int    `reindex:"id,,pk" json:"id"`
+	Name    string `reindex:"name" json:"name"`
+	Updated int64  `reindex:"updated" json:"updated"`
+}
+
+// BenchmarkUpsertDedup upserts items, 90% of which are not changed since the previous upsert
+func BenchmarkUpsertDedup(b *testing.B) {
+	const itemsCount = 1000
+	for _, c := range []struct {
+		name string
+		opts *reindexer.NamespaceOptions
+	}{
+		{"plain", reindexer.DefaultNamespaceOptions()},
+		{"skip unchanged", reindexer.DefaultNamespaceOptions().SkipUnchangedUpserts()},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			const ns = "test_items_upsert_dedup"
+			if err := DBD.OpenNamespace(ns, c.opts, TestItemUpsertDedup{}); err != nil {
+				b.Fatal(err)
+			}
+			defer DBD.DropNamespace(ns)
+			items := make([]TestItemUpsertDedup, itemsCount)
+			for i := range items {
+				items[i] = TestItemUpsertDedup{ID: i, Name: randString(), Year: 2000 + i%20, Payload: randLangString()}
+				if err := DBD.Upsert(ns, &items[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				item := &items[i%itemsCount]
+				if i%10 == 0 {
+					item.Year++
+				}
+				if err := DBD.Upsert(ns, item); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestUpsertDedup(t *testing.T) {
+	const ns = "test_items_upsert_dedup_in_memory"
+	hooks := &reindexertest.Hooks{}
+	db := reindexertest.NewInMemory(reindexertest.WithHooks(hooks))
+	defer db.Close()
+	require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().SkipUnchangedUpserts(), TestItemUpsertDedupInMemory{}))
+	sent := func() int {
+		calls := hooks.Calls(reindexertest.OpModifyItem)
+		hooks.Reset()
+		return calls
+	}
+	// The first upsert is retried with tags state of server
+	require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 100}))
+	sent()
+	get := func(id int) *TestItemUpsertDedupInMemory {
+		item, found := db.Query(ns).WhereInt("id", reindexer.EQ, id).Get()
+		require.True(t, found, "item %d", id)
+		return item.(*TestItemUpsertDedupInMemory)
+	}
+
+	require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 1, Name: "first"}))
+	require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 1, Name: "first"}))
+	assert.Equal(t, 1, sent())
+	require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 1, Name: "changed"}))
+	assert.Equal(t, 1, sent())
+	assert.Equal(t, "changed", get(1).Name)
+	status := db.Status().UpsertDedup
+	assert.Equal(t, int64(1), status.Skipped)
+	assert.Equal(t, int64(2), status.Entries)
+
+	t.Run("precepts", func(t *testing.T) {
+		item := &TestItemUpsertDedupInMemory{ID: 2, Name: "second"}
+		require.NoError(t, db.Upsert(ns, item, "updated=now(nsec)"))
+		require.NotEqual(t, int64(0), item.Updated)
+		// Item is updated by precept, but the field isn't compared
+		require.NoError(t, db.Upsert(ns, item, "updated=now(nsec)"))
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 2, Name: "second", Updated: 1}, "updated=now(nsec)"))
+		assert.Equal(t, 1, sent())
+		// Precept field is compared by upsert without precepts
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 2, Name: "second"}))
+		assert.Equal(t, 1, sent())
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		item := &TestItemUpsertDedupInMemory{ID: 1, Name: "changed"}
+		require.NoError(t, db.Delete(ns, item))
+		require.NoError(t, db.Upsert(ns, item))
+		assert.Equal(t, 2, sent())
+		get(1)
+
+		deleted, err := db.Query(ns).WhereInt("id", reindexer.EQ, 1).Delete()
+		require.NoError(t, err)
+		require.Equal(t, 1, deleted)
+		require.NoError(t, db.Upsert(ns, item))
+		assert.Equal(t, 1, sent())
+		get(1)
+
+		it := db.Query(ns).WhereInt("id", reindexer.EQ, 1).Set("name", "by query").Update()
+		require.NoError(t, it.Error())
+		it.Close()
+		require.NoError(t, db.Upsert(ns, item))
+		assert.Equal(t, 1, sent())
+		assert.Equal(t, "changed", get(1).Name)
+
+		require.NoError(t, db.UpsertJSON(context.Background(), ns, []byte(`{"id":1,"name":"by json"}`)))
+		require.NoError(t, db.Upsert(ns, item))
+		assert.Equal(t, 2, sent())
+		assert.Equal(t, "changed", get(1).Name)
+
+		// Hash of failed upsert is not recorded
+		injected := bindings.NewError("injected", bindings.ErrLogic)
+		hooks.InjectError(reindexertest.OpModifyItem, injected)
+		assert.Equal(t, injected, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 1, Name: "failed"}))
+		hooks.InjectError(reindexertest.OpModifyItem, nil)
+		hooks.Reset()
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 1, Name: "failed"}))
+		assert.Equal(t, 1, sent())
+		assert.Equal(t, "failed", get(1).Name)
+	})
+
+	t.Run("transaction", func(t *testing.T) {
+		tx, err := db.BeginTx(ns)
+		require.NoError(t, err)
+		require.NoError(t, tx.Upsert(&TestItemUpsertDedupInMemory{ID: 1, Name: "failed"}))
+		require.NoError(t, tx.Upsert(&TestItemUpsertDedupInMemory{ID: 3, Name: "third"}))
+		require.NoError(t, tx.Upsert(&TestItemUpsertDedupInMemory{ID: 3, Name: "third"}))
+		assert.Equal(t, 1, hooks.Calls(reindexertest.OpModifyItemTx))
+		require.NoError(t, tx.Commit())
+		hooks.Reset()
+		// Hashes of transaction are recorded on commit
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 3, Name: "third"}))
+		assert.Equal(t, 0, sent())
+		get(3)
+
+		tx, err = db.BeginTx(ns)
+		require.NoError(t, err)
+		require.NoError(t, tx.Delete(&TestItemUpsertDedupInMemory{ID: 3}))
+		require.NoError(t, tx.Upsert(&TestItemUpsertDedupInMemory{ID: 3, Name: "third"}))
+		assert.Equal(t, 2, hooks.Calls(reindexertest.OpModifyItemTx))
+		require.NoError(t, tx.Rollback())
+		hooks.Reset()
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 3, Name: "third"}))
+		assert.Equal(t, 0, sent(), "rolled back transaction doesn't change hashes")
+	})
+
+	t.Run("concurrent writes", func(t *testing.T) {
+		// Order of concurrent writes of the same item is unknown, so neither hash is recorded
+		hooks.InjectLatency(reindexertest.OpModifyItem, 20*time.Millisecond)
+		var wg sync.WaitGroup
+		for _, name := range []string{"one", "two"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				assert.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 4, Name: name}))
+			}(name)
+		}
+		wg.Wait()
+		hooks.Reset()
+		name := get(4).Name
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 4, Name: name}))
+		assert.Equal(t, 1, sent())
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		const ns = "upsert_dedup_limit_items"
+		require.NoError(t, db.OpenNamespace(ns, reindexer.DefaultNamespaceOptions().SkipUnchangedUpsertsLimit(2), TestItemUpsertDedupInMemory{}))
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 100}))
+		sent()
+		for _, id := range []int{1, 2, 3, 3, 1} {
+			require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: id, Name: "item"}))
+		}
+		// Item 3 is skipped, item 1 is evicted
+		assert.Equal(t, 4, sent())
+		require.NoError(t, db.TruncateNamespace(ns))
+		require.NoError(t, db.Upsert(ns, &TestItemUpsertDedupInMemory{ID: 1, Name: "item"}))
+		assert.Equal(t, 1, sent())
+	})
+}
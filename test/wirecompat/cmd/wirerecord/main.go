@@ -0,0 +1,50 @@
+// wirerecord records fixtures of wirecompat scenarios from running reindexer server:
+//
+//	go run ./test/wirecompat/cmd/wirerecord -upstream 127.0.0.1:6534 -out test/wirecompat/testdata
+//
+// Namespaces of scenarios are recreated in 'wirecompat' database of the server
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/restream/reindexer/test/wirecompat"
+)
+
+func main() {
+	upstream := flag.String("upstream", "127.0.0.1:6534", "address of cproto server to record")
+	out := flag.String("out", "test/wirecompat/testdata", "directory of fixtures")
+	version := flag.String("version", "", "version of the server (default is version, reported by server)")
+	only := flag.String("scenarios", "", "comma separated names of scenarios to record (default is all)")
+	flag.Parse()
+
+	if len(*version) == 0 {
+		db := wirecompat.Connect(*upstream)
+		if err := db.Status().Err; err != nil {
+			fail(err)
+		}
+		*version = db.ServerVersion()
+		db.Close()
+	}
+	for _, scenario := range wirecompat.Scenarios {
+		if len(*only) != 0 && !strings.Contains(","+*only+",", ","+scenario.Name+",") {
+			continue
+		}
+		fixture, err := wirecompat.Record(*upstream, *version, scenario)
+		if err != nil {
+			fail(err)
+		}
+		if err = fixture.Save(*out); err != nil {
+			fail(err)
+		}
+		fmt.Printf("%s/%s: %d RPCs\n", *version, scenario.Name, len(fixture.Exchanges))
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
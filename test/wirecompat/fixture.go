@@ -0,0 +1,122 @@
+// Package wirecompat tests decoding of cproto replies of different reindexer server versions without the servers.
+// Fixtures are recorded by Recorder proxy (see cmd/wirerecord) from real servers for each scenario of Scenarios
+// and are replayed by ReplayServer in unit tests. See README.md
+package wirecompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Exchange - cproto request of client and reply of server. Request and Reply are bodies of the frames (without headers)
+type Exchange struct {
+	RPC          string `json:"rpc"`
+	Cmd          uint16 `json:"cmd"`
+	Request      []byte `json:"request"`
+	Reply        []byte `json:"reply"`
+	ReplyVersion uint16 `json:"reply_version"`
+}
+
+// Fixture - exchanges of one scenario with server of one version and result of the scenario, decoded at recording
+type Fixture struct {
+	Version   string          `json:"version"`
+	Scenario  string          `json:"scenario"`
+	Login     Exchange        `json:"login"`
+	Exchanges []Exchange      `json:"exchanges"`
+	Result    json.RawMessage `json:"result"`
+}
+
+// LoadFixtures loads fixtures from dir, which contains directory of JSON files per server version
+func LoadFixtures(dir string) ([]*Fixture, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	fixtures := make([]*Fixture, 0, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		f := &Fixture{}
+		if err = json.Unmarshal(data, f); err != nil {
+			return nil, fmt.Errorf("wirecompat: can't parse fixture '%s': %v", file, err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+// Save writes fixture to dir/<version>/<scenario>.json
+func (f *Fixture) Save(dir string) error {
+	dir = filepath.Join(dir, strings.TrimPrefix(f.Version, "v"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, f.Scenario+".json"), append(data, '\n'), 0644)
+}
+
+// cproto commands, which are known by the harness
+const (
+	cmdPing          = 0
+	cmdLogin         = 1
+	cmdOpenNamespace = 16
+	cmdDropNamespace = 18
+	cmdAddIndex      = 21
+	cmdUpdateIndex   = 25
+	cmdAddTxItem     = 26
+	cmdCommitTx      = 27
+	cmdRollbackTx    = 28
+	cmdStartTx       = 29
+	cmdModifyItem    = 33
+	cmdDeleteQuery   = 34
+	cmdUpdateQuery   = 35
+	cmdSelect        = 48
+	cmdSelectSQL     = 49
+	cmdFetchResults  = 50
+	cmdCloseResults  = 51
+	cmdGetMeta       = 64
+	cmdPutMeta       = 65
+	cmdEnumMeta      = 66
+)
+
+var rpcNames = map[uint16]string{
+	cmdPing:          "Ping",
+	cmdLogin:         "Login",
+	cmdOpenNamespace: "OpenNamespace",
+	cmdDropNamespace: "DropNamespace",
+	cmdAddIndex:      "AddIndex",
+	cmdUpdateIndex:   "UpdateIndex",
+	cmdAddTxItem:     "AddTxItem",
+	cmdCommitTx:      "CommitTx",
+	cmdRollbackTx:    "RollbackTx",
+	cmdStartTx:       "StartTransaction",
+	cmdModifyItem:    "ModifyItem",
+	cmdDeleteQuery:   "DeleteQuery",
+	cmdUpdateQuery:   "UpdateQuery",
+	cmdSelect:        "Select",
+	cmdSelectSQL:     "SelectSQL",
+	cmdFetchResults:  "FetchResults",
+	cmdCloseResults:  "CloseResults",
+	cmdGetMeta:       "GetMeta",
+	cmdPutMeta:       "PutMeta",
+	cmdEnumMeta:      "EnumMeta",
+}
+
+// RPCName returns name of cproto command
+func RPCName(cmd uint16) string {
+	if name, ok := rpcNames[cmd]; ok {
+		return name
+	}
+	return fmt.Sprintf("cmd_%d", cmd)
+}
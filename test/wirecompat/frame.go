@@ -0,0 +1,48 @@
+package wirecompat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	cprotoMagic   = 0xEEDD1132
+	cprotoVersion = 0x103
+	cprotoHdrLen  = 16
+)
+
+// frame - cproto request or reply
+type frame struct {
+	version uint16
+	cmd     uint16
+	seq     uint32
+	body    []byte
+}
+
+func readFrame(r io.Reader) (f frame, err error) {
+	hdr := make([]byte, cprotoHdrLen)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		return f, err
+	}
+	if magic := binary.LittleEndian.Uint32(hdr); magic != cprotoMagic {
+		return f, fmt.Errorf("wirecompat: invalid cproto magic %08X", magic)
+	}
+	f.version = binary.LittleEndian.Uint16(hdr[4:])
+	f.cmd = binary.LittleEndian.Uint16(hdr[6:])
+	f.seq = binary.LittleEndian.Uint32(hdr[12:])
+	f.body = make([]byte, binary.LittleEndian.Uint32(hdr[8:]))
+	_, err = io.ReadFull(r, f.body)
+	return f, err
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	buf := make([]byte, cprotoHdrLen, cprotoHdrLen+len(f.body))
+	binary.LittleEndian.PutUint32(buf, cprotoMagic)
+	binary.LittleEndian.PutUint16(buf[4:], f.version)
+	binary.LittleEndian.PutUint16(buf[6:], f.cmd)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(f.body)))
+	binary.LittleEndian.PutUint32(buf[12:], f.seq)
+	_, err := w.Write(append(buf, f.body...))
+	return err
+}
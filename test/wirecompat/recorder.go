@@ -0,0 +1,168 @@
+package wirecompat
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Recorder - proxy between cproto client and server, which records exchanges of the client with the server.
+// Pings are not recorded, only the last login is kept
+type Recorder struct {
+	Upstream  string
+	l         net.Listener
+	lock      sync.Mutex
+	conns     []net.Conn
+	login     *Exchange
+	exchanges []*Exchange
+	err       error
+}
+
+// Run starts listening on random local port
+func (rec *Recorder) Run() (err error) {
+	if rec.l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return err
+	}
+	go rec.acceptLoop()
+	return nil
+}
+
+// Addr returns address of listening socket
+func (rec *Recorder) Addr() string {
+	return rec.l.Addr().String()
+}
+
+// Stop closes listener and all the proxied connections
+func (rec *Recorder) Stop() {
+	rec.l.Close()
+	rec.lock.Lock()
+	for _, conn := range rec.conns {
+		conn.Close()
+	}
+	rec.conns = nil
+	rec.lock.Unlock()
+}
+
+// Take returns login and exchanges, recorded since the previous call, and starts recording from scratch.
+// Must be called, when client has no requests in progress, e.g. after it's closed
+func (rec *Recorder) Take() (Exchange, []Exchange, error) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	login, recorded, err := rec.login, rec.exchanges, rec.err
+	rec.exchanges, rec.err = nil, nil
+	if err != nil {
+		return Exchange{}, nil, err
+	}
+	if login == nil || login.Reply == nil {
+		return Exchange{}, nil, fmt.Errorf("wirecompat: login was not recorded")
+	}
+	exchanges := make([]Exchange, 0, len(recorded))
+	for i, ex := range recorded {
+		if ex.Reply == nil {
+			return Exchange{}, nil, fmt.Errorf("wirecompat: reply to RPC %s (#%d) was not recorded", ex.RPC, i+1)
+		}
+		exchanges = append(exchanges, *ex)
+	}
+	return *login, exchanges, nil
+}
+
+func (rec *Recorder) setErr(err error) {
+	rec.lock.Lock()
+	if rec.err == nil {
+		rec.err = err
+	}
+	rec.lock.Unlock()
+}
+
+func (rec *Recorder) acceptLoop() {
+	for {
+		conn, err := rec.l.Accept()
+		if err != nil {
+			return
+		}
+		upstream, err := net.Dial("tcp", rec.Upstream)
+		if err != nil {
+			rec.setErr(err)
+			conn.Close()
+			continue
+		}
+		rec.lock.Lock()
+		rec.conns = append(rec.conns, conn, upstream)
+		rec.lock.Unlock()
+		go rec.proxy(conn, upstream)
+	}
+}
+
+func (rec *Recorder) proxy(conn, upstream net.Conn) {
+	defer conn.Close()
+	defer upstream.Close()
+	var lock sync.Mutex
+	// requests of the connection, which wait for replies, by seq number
+	pending := make(map[uint32]*Exchange)
+
+	go func() {
+		defer conn.Close()
+		defer upstream.Close()
+		rd := bufio.NewReader(upstream)
+		for {
+			reply, err := readFrame(rd)
+			if err != nil {
+				return
+			}
+			lock.Lock()
+			if ex, ok := pending[reply.seq]; ok {
+				rec.lock.Lock()
+				ex.Reply, ex.ReplyVersion = reply.body, reply.version
+				rec.lock.Unlock()
+				delete(pending, reply.seq)
+			}
+			lock.Unlock()
+			if err = writeFrame(conn, reply); err != nil {
+				return
+			}
+		}
+	}()
+
+	rd := bufio.NewReader(conn)
+	for {
+		req, err := readFrame(rd)
+		if err != nil {
+			return
+		}
+		if req.cmd != cmdPing {
+			ex := &Exchange{RPC: RPCName(req.cmd), Cmd: req.cmd, Request: req.body}
+			rec.lock.Lock()
+			if req.cmd == cmdLogin {
+				rec.login = ex
+			} else {
+				rec.exchanges = append(rec.exchanges, ex)
+			}
+			rec.lock.Unlock()
+			lock.Lock()
+			pending[req.seq] = ex
+			lock.Unlock()
+		}
+		if err = writeFrame(upstream, req); err != nil {
+			return
+		}
+	}
+}
+
+// Record runs scenario with server at upstream through Recorder and returns its fixture
+func Record(upstream string, version string, scenario Scenario) (*Fixture, error) {
+	rec := &Recorder{Upstream: upstream}
+	if err := rec.Run(); err != nil {
+		return nil, err
+	}
+	defer rec.Stop()
+	res, err := RunScenario(rec.Addr(), scenario)
+	if err != nil {
+		return nil, fmt.Errorf("wirecompat: scenario '%s' failed: %v", scenario.Name, err)
+	}
+	login, exchanges, err := rec.Take()
+	if err != nil {
+		return nil, err
+	}
+	return &Fixture{Version: version, Scenario: scenario.Name, Login: login, Exchanges: exchanges, Result: res}, nil
+}
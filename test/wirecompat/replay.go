@@ -0,0 +1,186 @@
+package wirecompat
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// Verify replays fixture to its scenario. Returns error, which names server version and RPC,
+// if requests of the scenario don't match the fixture, it fails to decode reply or its results differ from the recorded ones
+func Verify(fixture *Fixture) error {
+	var scenario *Scenario
+	for i := range Scenarios {
+		if Scenarios[i].Name == fixture.Scenario {
+			scenario = &Scenarios[i]
+		}
+	}
+	if scenario == nil {
+		return fmt.Errorf("wirecompat: %s: unknown scenario '%s'", fixture.Version, fixture.Scenario)
+	}
+	srv := NewReplayServer(fixture)
+	if err := srv.Run(); err != nil {
+		return err
+	}
+	defer srv.Stop()
+
+	res, err := RunScenario(srv.Addr(), *scenario)
+	srv.lock.Lock()
+	mismatch := srv.err
+	srv.lock.Unlock()
+	if mismatch != nil {
+		return mismatch
+	}
+	if err != nil {
+		return fmt.Errorf("wirecompat: %s: scenario '%s' failed on reply to RPC %s: %v", fixture.Version, fixture.Scenario, srv.LastRPC(), err)
+	}
+	if err = srv.Err(); err != nil {
+		return err
+	}
+	var decoded, recorded interface{}
+	if err = json.Unmarshal(res, &decoded); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(fixture.Result, &recorded); err != nil {
+		return fmt.Errorf("wirecompat: %s: invalid result of scenario '%s': %v", fixture.Version, fixture.Scenario, err)
+	}
+	if !reflect.DeepEqual(decoded, recorded) {
+		return fmt.Errorf("wirecompat: %s: results of scenario '%s' (last RPC %s) differ from the recorded ones\nrecorded: %s\ndecoded:  %s",
+			fixture.Version, fixture.Scenario, srv.LastRPC(), fixture.Result, res)
+	}
+	return nil
+}
+
+// ReplayServer - fake cproto server, which replies to requests of client by replies of fixture in the recorded order.
+// Login is replied on each connection, pings are replied with empty result and are not matched with the fixture
+type ReplayServer struct {
+	fixture *Fixture
+	l       net.Listener
+	lock    sync.Mutex
+	conns   []net.Conn
+	next    int
+	err     error
+}
+
+// NewReplayServer creates server for fixture
+func NewReplayServer(fixture *Fixture) *ReplayServer {
+	return &ReplayServer{fixture: fixture}
+}
+
+// Run starts listening on random local port
+func (srv *ReplayServer) Run() (err error) {
+	if srv.l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return err
+	}
+	go srv.acceptLoop()
+	return nil
+}
+
+// Addr returns address of listening socket
+func (srv *ReplayServer) Addr() string {
+	return srv.l.Addr().String()
+}
+
+// Stop closes listener and all the accepted connections
+func (srv *ReplayServer) Stop() {
+	srv.l.Close()
+	srv.lock.Lock()
+	for _, conn := range srv.conns {
+		conn.Close()
+	}
+	srv.conns = nil
+	srv.lock.Unlock()
+}
+
+// LastRPC returns name of the last replayed RPC, e.g. to report decoding error of its reply
+func (srv *ReplayServer) LastRPC() string {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	if srv.next == 0 {
+		return RPCName(cmdLogin)
+	}
+	return fmt.Sprintf("%s (#%d)", srv.fixture.Exchanges[srv.next-1].RPC, srv.next)
+}
+
+// Err returns the first mismatch of requests with the fixture or error, if some of recorded RPCs were not requested
+func (srv *ReplayServer) Err() error {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	if srv.err == nil && srv.next < len(srv.fixture.Exchanges) {
+		ex := srv.fixture.Exchanges[srv.next]
+		return fmt.Errorf("wirecompat: %s: RPC %s (#%d) of scenario '%s' was not requested", srv.fixture.Version, ex.RPC, srv.next+1, srv.fixture.Scenario)
+	}
+	return srv.err
+}
+
+func (srv *ReplayServer) acceptLoop() {
+	for {
+		conn, err := srv.l.Accept()
+		if err != nil {
+			return
+		}
+		srv.lock.Lock()
+		srv.conns = append(srv.conns, conn)
+		srv.lock.Unlock()
+		go srv.serve(conn)
+	}
+}
+
+func (srv *ReplayServer) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		req, err := readFrame(rd)
+		if err != nil {
+			return
+		}
+		reply := frame{version: cprotoVersion, cmd: req.cmd, seq: req.seq}
+		switch req.cmd {
+		case cmdLogin:
+			reply.version, reply.body = srv.fixture.Login.ReplyVersion, srv.fixture.Login.Reply
+		case cmdPing:
+			reply.body = replyBody(0, "")
+		default:
+			reply.version, reply.body = srv.replay(req.cmd)
+		}
+		if err = writeFrame(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// replay returns the next recorded reply, if cmd matches the fixture
+func (srv *ReplayServer) replay(cmd uint16) (uint16, []byte) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	var err error
+	if srv.err != nil {
+		err = srv.err
+	} else if srv.next >= len(srv.fixture.Exchanges) {
+		err = fmt.Errorf("wirecompat: %s: unexpected RPC %s after the end of scenario '%s'", srv.fixture.Version, RPCName(cmd), srv.fixture.Scenario)
+	} else if ex := srv.fixture.Exchanges[srv.next]; ex.Cmd != cmd {
+		err = fmt.Errorf("wirecompat: %s: RPC #%d of scenario '%s' is %s, but %s was recorded", srv.fixture.Version, srv.next+1, srv.fixture.Scenario, RPCName(cmd), ex.RPC)
+	} else {
+		srv.next++
+		return ex.ReplyVersion, ex.Reply
+	}
+	if srv.err == nil {
+		srv.err = err
+	}
+	return cprotoVersion, replyBody(bindings.ErrLogic, err.Error())
+}
+
+// replyBody encodes reply with error code and without arguments
+func replyBody(code int, msg string) []byte {
+	body := cjson.NewSerializer(nil)
+	body.PutVarUInt(uint64(code))
+	body.PutVString(msg)
+	body.PutVarUInt(0)
+	return body.Bytes()
+}
@@ -0,0 +1,219 @@
+package wirecompat
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/restream/reindexer"
+	_ "github.com/restream/reindexer/bindings/cproto"
+)
+
+// Scenario - canonical sequence of requests. Run returns decoded results, which are compared with the recorded ones.
+// Scenario must be deterministic: the same requests in the same order on each run
+type Scenario struct {
+	Name string
+	Run  func(db *reindexer.Reindexer) (interface{}, error)
+}
+
+const (
+	dbName      = "wirecompat"
+	nsItems     = "wirecompat_items"
+	nsAuthors   = "wirecompat_authors"
+	itemsCount  = 10
+	authorCount = 3
+)
+
+type Author struct {
+	ID   int    `reindex:"id,,pk" json:"id"`
+	Name string `reindex:"name" json:"name"`
+}
+
+type Item struct {
+	ID       int       `reindex:"id,,pk" json:"id"`
+	Name     string    `reindex:"name" json:"name"`
+	Year     int       `reindex:"year,tree" json:"year"`
+	Genre    int64     `reindex:"genre" json:"genre"`
+	Rate     float64   `reindex:"rate,tree" json:"rate"`
+	Tags     []string  `reindex:"tags" json:"tags"`
+	AuthorID int       `reindex:"author_id" json:"author_id"`
+	Authors  []*Author `reindex:"authors,,joined" json:"authors,omitempty"`
+}
+
+// Scenarios - scenarios, which are recorded for each server version
+var Scenarios = []Scenario{
+	{Name: "login", Run: scenarioLogin},
+	{Name: "select_aggregations", Run: scenarioSelectAggregations},
+	{Name: "select_joins", Run: scenarioSelectJoins},
+	{Name: "select_fetch", Run: scenarioSelectFetch},
+	{Name: "update_delete_query", Run: scenarioUpdateDeleteQuery},
+	{Name: "tx", Run: scenarioTx},
+	{Name: "meta", Run: scenarioMeta},
+}
+
+// Connect connects to server by the only connection, so requests are sent in the order of calls
+func Connect(addr string) *reindexer.Reindexer {
+	return reindexer.NewReindex(fmt.Sprintf("cproto://%s/%s", addr, dbName), reindexer.WithConnPoolSize(1), reindexer.WithCreateDBIfMissing())
+}
+
+// RunScenario runs scenario with new client, connected to addr, and returns JSON of its results
+func RunScenario(addr string, s Scenario) (json.RawMessage, error) {
+	db := Connect(addr)
+	defer db.Close()
+	if err := db.Status().Err; err != nil {
+		return nil, err
+	}
+	res, err := s.Run(db)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(res)
+}
+
+// prepare recreates namespaces of scenarios and fills them with the same items
+func prepare(db *reindexer.Reindexer) error {
+	db.DropNamespace(nsItems)
+	db.DropNamespace(nsAuthors)
+	if err := db.OpenNamespace(nsAuthors, reindexer.DefaultNamespaceOptions(), Author{}); err != nil {
+		return err
+	}
+	if err := db.OpenNamespace(nsItems, reindexer.DefaultNamespaceOptions(), Item{}); err != nil {
+		return err
+	}
+	for i := 0; i < authorCount; i++ {
+		if err := db.Upsert(nsAuthors, &Author{ID: i, Name: fmt.Sprintf("author_%d", i)}); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < itemsCount; i++ {
+		item := &Item{
+			ID:       i,
+			Name:     fmt.Sprintf("name_%d", i%4),
+			Year:     2000 + i,
+			Genre:    int64(i % 3),
+			Rate:     float64(i) / 4,
+			Tags:     []string{"tag", fmt.Sprintf("tag_%d", i%2)},
+			AuthorID: i % (authorCount + 1),
+		}
+		if err := db.Upsert(nsItems, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func fetchItems(it *reindexer.Iterator) ([]*Item, error) {
+	defer it.Close()
+	var items []*Item
+	for it.Next() {
+		items = append(items, it.Object().(*Item))
+	}
+	return items, it.Error()
+}
+
+func scenarioLogin(db *reindexer.Reindexer) (interface{}, error) {
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"server_version": db.ServerVersion()}, nil
+}
+
+func scenarioSelectAggregations(db *reindexer.Reindexer) (interface{}, error) {
+	if err := prepare(db); err != nil {
+		return nil, err
+	}
+	q := db.Query(nsItems).WhereInt("year", reindexer.GE, 2002).Sort("year", true).Limit(5).ReqTotal()
+	q.AggregateSum("rate")
+	q.AggregateMax("year")
+	q.AggregateFacet("genre").Sort("count", true).Limit(10)
+	q.Distinct("name")
+	it := q.Exec()
+	defer it.Close()
+	if it.Error() != nil {
+		return nil, it.Error()
+	}
+	total, aggs := it.TotalCount(), it.AggResults()
+	items, err := fetchItems(it)
+	return map[string]interface{}{"total": total, "aggregations": aggs, "items": items}, err
+}
+
+func scenarioSelectJoins(db *reindexer.Reindexer) (interface{}, error) {
+	if err := prepare(db); err != nil {
+		return nil, err
+	}
+	q := db.Query(nsItems).Sort("id", false)
+	q.InnerJoin(db.Query(nsAuthors), "authors").On("author_id", reindexer.EQ, "id")
+	items, err := fetchItems(q.Exec())
+	return map[string]interface{}{"items": items}, err
+}
+
+func scenarioSelectFetch(db *reindexer.Reindexer) (interface{}, error) {
+	if err := prepare(db); err != nil {
+		return nil, err
+	}
+	// Results are fetched by several requests and the rest of them are closed
+	items, err := fetchItems(db.Query(nsItems).Sort("id", false).FetchCount(3).Exec())
+	if err != nil {
+		return nil, err
+	}
+	it := db.Query(nsItems).Sort("id", false).FetchCount(3).Exec()
+	it.Next()
+	it.Close()
+	return map[string]interface{}{"items": items}, it.Error()
+}
+
+func scenarioUpdateDeleteQuery(db *reindexer.Reindexer) (interface{}, error) {
+	if err := prepare(db); err != nil {
+		return nil, err
+	}
+	updated, err := fetchItems(db.Query(nsItems).WhereInt("genre", reindexer.EQ, 1).Set("name", "updated").Update())
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := db.Query(nsItems).WhereInt("year", reindexer.LT, 2003).Delete()
+	if err != nil {
+		return nil, err
+	}
+	rest, err := fetchItems(db.Query(nsItems).Sort("id", false).Exec())
+	return map[string]interface{}{"updated": updated, "deleted": deleted, "items": rest}, err
+}
+
+func scenarioTx(db *reindexer.Reindexer) (interface{}, error) {
+	if err := prepare(db); err != nil {
+		return nil, err
+	}
+	tx, err := db.BeginTx(nsItems)
+	if err != nil {
+		return nil, err
+	}
+	for i := itemsCount; i < itemsCount+3; i++ {
+		if err = tx.Upsert(&Item{ID: i, Name: "tx", Year: 2100}); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err = tx.Delete(&Item{ID: 0}); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	count, err := tx.CommitWithCount()
+	if err != nil {
+		return nil, err
+	}
+	items, err := fetchItems(db.Query(nsItems).Sort("id", false).Exec())
+	return map[string]interface{}{"count": count, "items": items}, err
+}
+
+func scenarioMeta(db *reindexer.Reindexer) (interface{}, error) {
+	if err := prepare(db); err != nil {
+		return nil, err
+	}
+	if err := db.PutMeta(nsItems, "key", []byte("value")); err != nil {
+		return nil, err
+	}
+	value, err := db.GetMeta(nsItems, "key")
+	if err != nil {
+		return nil, err
+	}
+	missing, err := db.GetMeta(nsItems, "missing")
+	return map[string]interface{}{"value": string(value), "missing": string(missing)}, err
+}
@@ -0,0 +1,115 @@
+package wirecompat
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+func TestRecordedFixtures(t *testing.T) {
+	fixtures, err := LoadFixtures("testdata")
+	require.NoError(t, err)
+	if len(fixtures) == 0 {
+		t.Skip("no recorded fixtures, see README.md")
+	}
+	for _, f := range fixtures {
+		f := f
+		t.Run(f.Version+"/"+f.Scenario, func(t *testing.T) {
+			assert.NoError(t, Verify(f))
+		})
+	}
+}
+
+// loginFixture makes fixture of login scenario with reply of server of version
+func loginFixture(version string) *Fixture {
+	body := cjson.NewSerializer(nil)
+	body.PutVarUInt(0)
+	body.PutVString("")
+	body.PutVarUInt(3)
+	body.PutVarUInt(uint64(bindings.ValueString))
+	body.PutVString(version)
+	body.PutVarUInt(uint64(bindings.ValueInt64))
+	body.PutVarInt(1)
+	body.PutVarUInt(uint64(bindings.ValueInt64))
+	body.PutVarInt(0)
+	return &Fixture{
+		Version:  version,
+		Scenario: "login",
+		Login:    Exchange{RPC: RPCName(cmdLogin), Cmd: cmdLogin, Reply: body.Bytes(), ReplyVersion: cprotoVersion},
+		Result:   json.RawMessage(`{"server_version":"` + version + `"}`),
+	}
+}
+
+func TestHarness(t *testing.T) {
+	const version = "v2.9.1"
+
+	t.Run("replay", func(t *testing.T) {
+		assert.NoError(t, Verify(loginFixture(version)))
+	})
+
+	t.Run("record and replay", func(t *testing.T) {
+		upstream := NewReplayServer(loginFixture(version))
+		require.NoError(t, upstream.Run())
+		defer upstream.Stop()
+
+		fixture, err := Record(upstream.Addr(), version, Scenarios[0])
+		require.NoError(t, err)
+		assert.Equal(t, loginFixture(version).Login.Reply, fixture.Login.Reply)
+		assert.NotEmpty(t, fixture.Login.Request)
+		assert.Empty(t, fixture.Exchanges)
+		assert.JSONEq(t, string(loginFixture(version).Result), string(fixture.Result))
+
+		dir, err := ioutil.TempDir("", "wirecompat")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		require.NoError(t, fixture.Save(dir))
+		loaded, err := LoadFixtures(dir)
+		require.NoError(t, err)
+		require.Len(t, loaded, 1)
+		assert.NoError(t, Verify(loaded[0]))
+	})
+
+	t.Run("results differ", func(t *testing.T) {
+		fixture := loginFixture(version)
+		fixture.Result = json.RawMessage(`{"server_version":"v2.2.0"}`)
+		err := Verify(fixture)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), version)
+		assert.Contains(t, err.Error(), "Login")
+	})
+
+	t.Run("reply is not decoded", func(t *testing.T) {
+		fixture := loginFixture(version)
+		fixture.Login.Reply = []byte{0xFF}
+		err := Verify(fixture)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), version)
+		assert.Contains(t, err.Error(), "RPC Login")
+	})
+
+	t.Run("recorded RPC is not requested", func(t *testing.T) {
+		fixture := loginFixture(version)
+		fixture.Exchanges = []Exchange{{RPC: RPCName(cmdSelect), Cmd: cmdSelect}}
+		err := Verify(fixture)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), version)
+		assert.Contains(t, err.Error(), "RPC Select (#1)")
+	})
+
+	t.Run("unexpected RPC", func(t *testing.T) {
+		fixture := loginFixture(version)
+		fixture.Scenario = "meta"
+		fixture.Exchanges = []Exchange{{RPC: RPCName(cmdGetMeta), Cmd: cmdGetMeta}}
+		err := Verify(fixture)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), version)
+		assert.Contains(t, err.Error(), "is DropNamespace, but GetMeta was recorded")
+	})
+}
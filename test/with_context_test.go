@@ -0,0 +1,72 @@
+package reindexer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/restream/reindexer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	tnamespaces["test_items_with_context"] = TestItemSimple{}
+}
+
+func TestWithContext(t *testing.T) {
+	const ns = "test_items_with_context"
+	require.NoError(t, DB.Upsert(ns, &TestItemSimple{ID: 1, Year: 2000, Name: "item1"}))
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	rdb := DBD.WithContext(canceled)
+
+	t.Run("bound context is default", func(t *testing.T) {
+		_, err := rdb.Query(ns).Exec().FetchAll()
+		assert.Equal(t, context.Canceled, err)
+
+		_, err = rdb.Query(ns).Where("id", reindexer.EQ, 1).Delete()
+		assert.Equal(t, context.Canceled, err)
+
+		assert.Error(t, rdb.Upsert(ns, &TestItemSimple{ID: 2, Year: 2001, Name: "item2"}))
+	})
+
+	t.Run("explicit context overrides bound one", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		items, err := rdb.Query(ns).Where("id", reindexer.EQ, 1).ExecCtx(ctx).FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(items))
+	})
+
+	t.Run("parent is not affected", func(t *testing.T) {
+		items, err := DBD.Query(ns).Where("id", reindexer.EQ, 1).Exec().FetchAll()
+		require.NoError(t, err)
+		assert.Equal(t, 1, len(items))
+		assert.NoError(t, DBD.Upsert(ns, &TestItemSimple{ID: 3, Year: 2003, Name: "item3"}))
+	})
+
+	t.Run("tx query uses tx context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		tx, err := DBD.WithContext(ctx).BeginTx(ns)
+		require.NoError(t, err)
+		cancel()
+		_, err = tx.Query().Where("id", reindexer.EQ, 3).Delete()
+		assert.Error(t, err)
+		tx.Rollback()
+	})
+}
+
+func TestWithContextClosedParent(t *testing.T) {
+	db, err := reindexer.Open(context.Background(), "builtin:///tmp/rx_with_context_test")
+	require.NoError(t, err)
+	require.NoError(t, db.OpenNamespace("items", reindexer.DefaultNamespaceOptions(), TestItemSimple{}))
+	rdb := db.WithContext(context.Background())
+	require.NoError(t, rdb.Upsert("items", &TestItemSimple{ID: 1, Year: 2000, Name: "item1"}))
+
+	require.NoError(t, db.Close())
+	assert.Equal(t, reindexer.ErrClientClosed, rdb.Upsert("items", &TestItemSimple{ID: 2, Year: 2001, Name: "item2"}))
+	_, err = rdb.Query("items").Exec().FetchAll()
+	assert.Equal(t, reindexer.ErrClientClosed, err)
+}
@@ -0,0 +1,125 @@
+package reindexer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/restream/reindexer"
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/reindexertest"
+)
+
+func TestWriteJournal(t *testing.T) {
+	var lock sync.Mutex
+	var entries []reindexer.WriteJournalEntry
+	journal := func(entry reindexer.WriteJournalEntry) {
+		lock.Lock()
+		entries = append(entries, entry)
+		lock.Unlock()
+	}
+	// normalized checks fields, which vary from run to run, and clears them
+	normalized := func(t *testing.T) []reindexer.WriteJournalEntry {
+		lock.Lock()
+		defer lock.Unlock()
+		res := entries
+		entries = nil
+		for i := range res {
+			e := &res[i]
+			assert.True(t, e.Duration >= 0, "entry %d", i)
+			assert.True(t, e.PayloadSize > 0, "entry %d", i)
+			if e.Op != reindexer.WriteOpUpdateQuery && e.Op != reindexer.WriteOpDeleteQuery && e.Op != reindexer.WriteOpCommitTx && e.Err == nil {
+				assert.True(t, e.LSN > 0, "entry %d", i)
+			}
+			e.Duration, e.PayloadSize, e.LSN = 0, 0, 0
+		}
+		return res
+	}
+
+	t.Run("mixed workload", func(t *testing.T) {
+		hooks := &reindexertest.Hooks{}
+		db := reindexertest.NewInMemory(reindexertest.WithHooks(hooks), reindexer.WithWriteJournal(journal, 0, false))
+		require.NoError(t, db.OpenNamespace(testInMemoryNs, reindexer.DefaultNamespaceOptions(), TestItemInMemory{}))
+
+		require.NoError(t, db.Upsert(testInMemoryNs, &TestItemInMemory{ID: 1}))
+		_, err := db.Insert(testInMemoryNs, &TestItemInMemory{ID: 2})
+		require.NoError(t, err)
+		_, err = db.Update(testInMemoryNs, TestItemInMemory{ID: 1, Name: "updated"})
+		require.NoError(t, err)
+		require.NoError(t, db.Delete(testInMemoryNs, &TestItemInMemory{ID: 2}))
+		done := make(chan error, 1)
+		require.NoError(t, db.UpsertAsync(context.Background(), testInMemoryNs, &TestItemInMemory{ID: 3}, func(err error) { done <- err }))
+		require.NoError(t, <-done)
+		require.NoError(t, db.Query(testInMemoryNs).WhereInt("id", reindexer.GE, 1).Set("year", 2020).Update().Error())
+		_, err = db.Query(testInMemoryNs).WhereInt("id", reindexer.EQ, 1).DryRun().Delete()
+		require.NoError(t, err)
+		count, err := db.Query(testInMemoryNs).WhereInt("id", reindexer.EQ, 3).Delete()
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		tx, err := db.BeginTx(testInMemoryNs)
+		require.NoError(t, err)
+		require.NoError(t, tx.Upsert(&TestItemInMemory{ID: 4}))
+		require.NoError(t, tx.Upsert(&TestItemInMemory{ID: 5}))
+		require.NoError(t, tx.Commit())
+		injected := errors.New("injected")
+		hooks.InjectError(reindexertest.OpModifyItem, injected)
+		assert.Equal(t, injected, db.Upsert(testInMemoryNs, &TestItemInMemory{ID: 6}, "year=now()"))
+		hooks.InjectError(reindexertest.OpModifyItem, nil)
+		// System namespaces are excluded
+		db.Upsert(reindexer.ConfigNamespaceName, &reindexer.DBConfigItem{Type: "namespaces"})
+		require.NoError(t, db.Close())
+
+		assert.Equal(t, []reindexer.WriteJournalEntry{
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpUpsert, PK: []interface{}{1}, Count: 1},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpInsert, PK: []interface{}{2}, Count: 1},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpUpdate, PK: []interface{}{1}, Count: 1},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpDelete, PK: []interface{}{2}, Count: 1},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpUpsert, PK: []interface{}{3}, Count: 1},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpUpdateQuery, Count: 2},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpDeleteQuery, Count: 1},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpCommitTx, Count: 2},
+			{Namespace: testInMemoryNs, Op: reindexer.WriteOpUpsert, PK: []interface{}{6}, Precepts: []string{"year=now()"}, Err: injected},
+		}, normalized(t))
+		assert.Equal(t, bindings.StatusWriteJournal{Delivered: 9}, db.Status().WriteJournal)
+	})
+
+	t.Run("system namespaces", func(t *testing.T) {
+		db := reindexertest.NewInMemory(reindexer.WithWriteJournal(journal, 0, true))
+		db.Upsert(reindexer.ConfigNamespaceName, &reindexer.DBConfigItem{Type: "namespaces"})
+		require.NoError(t, db.Close())
+		lock.Lock()
+		defer lock.Unlock()
+		require.Len(t, entries, 1)
+		assert.Equal(t, reindexer.ConfigNamespaceName, entries[0].Namespace)
+		entries = nil
+	})
+
+	t.Run("slow journal doesn't block writes", func(t *testing.T) {
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		var calls int32
+		db := reindexertest.NewInMemory(reindexer.WithWriteJournal(func(entry reindexer.WriteJournalEntry) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				close(entered)
+				<-release
+			}
+		}, 1, false))
+		require.NoError(t, db.OpenNamespace(testInMemoryNs, reindexer.DefaultNamespaceOptions(), TestItemInMemory{}))
+
+		require.NoError(t, db.Upsert(testInMemoryNs, &TestItemInMemory{ID: 1}))
+		<-entered
+		// The first entry is being journaled, the second one is queued and the rest are dropped
+		for i := 2; i <= 4; i++ {
+			require.NoError(t, db.Upsert(testInMemoryNs, &TestItemInMemory{ID: i}))
+		}
+		assert.Equal(t, bindings.StatusWriteJournal{Dropped: 2}, db.Status().WriteJournal)
+		close(release)
+		require.NoError(t, db.Close())
+		assert.Equal(t, bindings.StatusWriteJournal{Delivered: 2, Dropped: 2}, db.Status().WriteJournal)
+	})
+}
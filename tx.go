@@ -4,6 +4,7 @@ import (
 	"context"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/restream/reindexer/bindings"
 	"github.com/restream/reindexer/cjson"
@@ -17,6 +18,8 @@ const retriesOnInvalidStateCnt = 1
 // There are synchronous and async transaction available. To start transaction method `db.BeginTx()` is used.
 // This method creates transaction object
 type Tx struct {
+	// total size of encoded items, sent by the transaction. Accessed atomically
+	payloadSize  int64
 	namespace    string
 	started      bool
 	db           *reindexerImpl
@@ -28,13 +31,19 @@ type Tx struct {
 	lock         sync.Mutex
 	asyncErr     error
 	asyncErrLock sync.RWMutex
+	// inRun - transaction is made by RunInTx, finished - it's committed or rolled back
+	inRun    bool
+	finished bool
+	// writes, which are registered in upsert deduplication on commit
+	dedup txDedup
 }
 
 func newTx(db *reindexerImpl, namespace string, ctx context.Context) (tx *Tx, err error) {
-	tx = &Tx{db: db, namespace: namespace}
-	if tx.ns, err = tx.db.getNS(tx.namespace); err != nil {
+	if err = checkSystemWrite(namespace); err != nil {
 		return nil, err
 	}
+	// Transaction of namespace, which is not registered by OpenNamespace, accepts only JSON items
+	tx = &Tx{db: db, namespace: namespace, ns: db.jsonNS(namespace)}
 	if err = tx.startTxCtx(ctx); err != nil {
 		return nil, err
 	}
@@ -50,9 +59,13 @@ func (tx *Tx) startTxCtx(ctx context.Context) (err error) {
 	if tx.started {
 		return nil
 	}
+	// Transaction is limited as a single write request on start
+	if err = tx.db.limiter().wait(ctx, tx.namespace, CommandClassWrite); err != nil {
+		return err
+	}
 	tx.asyncRspCnt = 0
 	tx.started = true
-	tx.ctx, err = tx.db.binding.BeginTx(ctx, tx.namespace)
+	tx.ctx, err = tx.db.getBinding().BeginTx(ctx, tx.namespace)
 	if err != nil {
 		return err
 	}
@@ -63,6 +76,9 @@ func (tx *Tx) startTxCtx(ctx context.Context) (err error) {
 }
 
 func (tx *Tx) startAsyncRoutines() (err error) {
+	if tx.inRun && tx.finished {
+		return errTxReused
+	}
 	if tx.cmplCh == nil {
 		tx.cmplCh = make(chan modifyInfo, asyncResponseQueueSize)
 		tx.cmplCond = sync.NewCond(&tx.lock)
@@ -87,6 +103,18 @@ func (tx *Tx) Update(item interface{}, precepts ...string) error {
 	return tx.modifyInternal(item, nil, modeUpdate, precepts...)
 }
 
+// InsertJSON inserts item to namespace
+func (tx *Tx) InsertJSON(json []byte, precepts ...string) error {
+	tx.startTx()
+	return tx.modifyInternal(nil, json, modeInsert, precepts...)
+}
+
+// UpdateJSON updates item of namespace
+func (tx *Tx) UpdateJSON(json []byte, precepts ...string) error {
+	tx.startTx()
+	return tx.modifyInternal(nil, json, modeUpdate, precepts...)
+}
+
 // Upsert (Insert or Update) item to namespace
 func (tx *Tx) Upsert(item interface{}, precepts ...string) error {
 	tx.startTx()
@@ -139,6 +167,24 @@ func (tx *Tx) UpsertAsync(item interface{}, cmpl bindings.Completion, precepts .
 	return tx.modifyInternalAsync(item, nil, modeUpsert, cmpl, retriesOnInvalidStateCnt, precepts...)
 }
 
+// InsertJSONAsync inserts item to namespace. Calls completion on result
+func (tx *Tx) InsertJSONAsync(json []byte, cmpl bindings.Completion, precepts ...string) error {
+	tx.startTx()
+	if err := tx.startAsyncRoutines(); err != nil {
+		return err
+	}
+	return tx.modifyInternalAsync(nil, json, modeInsert, cmpl, retriesOnInvalidStateCnt, precepts...)
+}
+
+// UpdateJSONAsync updates item of namespace. Calls completion on result
+func (tx *Tx) UpdateJSONAsync(json []byte, cmpl bindings.Completion, precepts ...string) error {
+	tx.startTx()
+	if err := tx.startAsyncRoutines(); err != nil {
+		return err
+	}
+	return tx.modifyInternalAsync(nil, json, modeUpdate, cmpl, retriesOnInvalidStateCnt, precepts...)
+}
+
 // UpsertJSONAsync (Insert or Update) item to index. Calls completion on result
 func (tx *Tx) UpsertJSONAsync(json []byte, cmpl bindings.Completion, precepts ...string) error {
 	tx.startTx()
@@ -230,6 +276,9 @@ func (tx *Tx) finalize() {
 }
 
 func (tx *Tx) modifyInternal(item interface{}, json []byte, mode int, precepts ...string) (err error) {
+	if tx.inRun && tx.finished {
+		return errTxReused
+	}
 	for tryCount := 0; tryCount < 2; tryCount++ {
 		ser := cjson.NewPoolSerializer()
 		defer ser.Close()
@@ -239,10 +288,15 @@ func (tx *Tx) modifyInternal(item interface{}, json []byte, mode int, precepts .
 		if format, stateToken, err = packItem(tx.ns, item, json, ser); err != nil {
 			return err
 		}
+		if tx.dedup.skip(tx.ns, item, ser.Bytes(), mode, precepts) {
+			return nil
+		}
 
-		err := tx.db.binding.ModifyItemTx(&tx.ctx, format, ser.Bytes(), mode, precepts, stateToken)
+		err := tx.db.getBinding().ModifyItemTx(&tx.ctx, format, ser.Bytes(), mode, precepts, stateToken)
+		atomic.AddInt64(&tx.payloadSize, int64(len(ser.Bytes())))
 
 		if err != nil {
+			tx.dedup.forget(tx.ns, item)
 			rerr, ok := err.(bindings.Error)
 			if ok && rerr.Code() == bindings.ErrStateInvalidated {
 				it := tx.db.query(tx.ns.name).Limit(0).ExecCtx(tx.ctx.UserCtx)
@@ -326,8 +380,11 @@ func (tx *Tx) modifyInternalAsync(item interface{}, json []byte, mode int, cmpl
 	if format, stateToken, err = packItem(tx.ns, item, json, ser); err != nil {
 		return err
 	}
+	// Async upserts are not deduplicated
+	tx.dedup.skip(tx.ns, item, nil, mode, precepts)
 
-	tx.db.binding.ModifyItemTxAsync(&tx.ctx, format, ser.Bytes(), mode, precepts, stateToken, internalCmpl)
+	atomic.AddInt64(&tx.payloadSize, int64(len(ser.Bytes())))
+	tx.db.getBinding().ModifyItemTxAsync(&tx.ctx, format, ser.Bytes(), mode, precepts, stateToken, internalCmpl)
 
 	return nil
 }
@@ -352,19 +409,32 @@ func (tx *Tx) checkReqCount() {
 // Commit apply changes
 func (tx *Tx) commitInternal() (count int, err error) {
 	count = 0
+	if journalEntry := tx.db.writeJournal.begin(tx.ns.name, bindings.WriteOpCommitTx, nil); journalEntry != nil {
+		start := time.Now()
+		defer func() {
+			journalEntry.PayloadSize = int(atomic.LoadInt64(&tx.payloadSize))
+			journalEntry.Count, journalEntry.Err = count, err
+			tx.db.writeJournal.record(journalEntry, start)
+		}()
+	}
 
+	tx.finished = true
 	tx.AwaitResults()
 	defer tx.finalize()
 	if tx.asyncErr != nil {
 		asyncErr := tx.asyncErr
-		err = tx.db.binding.RollbackTx(&tx.ctx)
+		err = tx.db.getBinding().RollbackTx(&tx.ctx)
 		if err == nil {
 			err = asyncErr
 		}
 		return 0, err
 	}
 
-	out, err := tx.db.binding.CommitTx(&tx.ctx)
+	dedupWrites := tx.dedup.beginCommit(tx.ns)
+	defer func() { endDedupWrites(dedupWrites, err) }()
+	tx.ns.replica.invalidate()
+	defer tx.ns.replica.invalidate()
+	out, err := tx.db.getBinding().CommitTx(&tx.ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -397,8 +467,9 @@ func (tx *Tx) commitInternal() (count int, err error) {
 // It is safe to call Rollback after Commit
 
 func (tx *Tx) Rollback() error {
+	tx.finished = true
 	tx.AwaitResults()
 	tx.asyncErr = nil
 	defer tx.finalize()
-	return tx.db.binding.RollbackTx(&tx.ctx)
+	return tx.db.getBinding().RollbackTx(&tx.ctx)
 }
@@ -0,0 +1,118 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+const (
+	defaultTxRetryAttempts   = 3
+	defaultTxRetryBackoff    = 10 * time.Millisecond
+	defaultTxRetryMaxBackoff = time.Second
+)
+
+// TxRetryOptions - options of RunInTx
+type TxRetryOptions struct {
+	// Attempts - max count of attempts, including the first one (default 3)
+	Attempts int
+	// Backoff - delay before the first retry, it's doubled on each next retry up to MaxBackoff (defaults are 10ms and 1s)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// IsConflict reports, whether error of fn or commit must be retried in addition to the state conflicts (see IsTxConflict)
+	IsConflict func(err error) bool
+}
+
+// ErrTxAttempts - error of RunInTx, which contains count of made attempts and error of the last one
+type ErrTxAttempts struct {
+	Attempts int
+	Err      error
+}
+
+func (e *ErrTxAttempts) Error() string {
+	return fmt.Sprintf("rq: transaction failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *ErrTxAttempts) Code() int {
+	if rerr, ok := e.Err.(Error); ok {
+		return rerr.Code()
+	}
+	return ErrCodeLogic
+}
+
+func (e *ErrTxAttempts) Unwrap() error {
+	return e.Err
+}
+
+// IsTxConflict returns true for errors, which mean that data was changed concurrently: ErrStateMismatch
+// (e.g. of query with RequireState, which fn made to check, that data is unchanged) and ErrCodeStateInvalidated
+func IsTxConflict(err error) bool {
+	if _, ok := err.(ErrStateMismatch); ok {
+		return true
+	}
+	if rerr, ok := err.(Error); ok {
+		return rerr.Code() == ErrCodeStateMismatch || rerr.Code() == ErrCodeStateInvalidated
+	}
+	return false
+}
+
+// RunInTx begins transaction of namespace ns, runs fn and commits transaction. If fn or commit fails with conflict error,
+// transaction is rolled back and fn is rerun in the new transaction after backoff. fn must be safe to rerun: it must read
+// the data, which it depends on, by itself on each call. Any error is returned as *ErrTxAttempts.
+// Misuse is rejected without retries: fn must not commit or roll back tx, and tx of the finished attempt must not be used
+func RunInTx(ctx context.Context, db *Reindexer, ns string, fn func(tx *Tx) error, opts TxRetryOptions) error {
+	if opts.Attempts <= 0 {
+		opts.Attempts = defaultTxRetryAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultTxRetryBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultTxRetryMaxBackoff
+	}
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		err := runTxAttempt(ctx, db, ns, fn)
+		if err == nil {
+			return nil
+		}
+		retry := err != errTxMisuse && (IsTxConflict(err) || (opts.IsConflict != nil && opts.IsConflict(err)))
+		if !retry || attempt >= opts.Attempts {
+			return &ErrTxAttempts{Attempts: attempt, Err: err}
+		}
+		select {
+		case <-ctx.Done():
+			return &ErrTxAttempts{Attempts: attempt, Err: err}
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+var errTxMisuse = bindings.NewError("rq: RunInTx: fn must not commit or roll back transaction", ErrCodeParams)
+
+// errTxReused is returned by modifications of transaction of the finished RunInTx attempt, e.g. if fn saved it outside
+var errTxReused = bindings.NewError("rq: Transaction of the finished RunInTx attempt is used", ErrCodeParams)
+
+func runTxAttempt(ctx context.Context, db *Reindexer, ns string, fn func(tx *Tx) error) error {
+	tx, err := db.impl.beginTx(ctx, ns)
+	if err != nil {
+		return err
+	}
+	tx.inRun = true
+	err = fn(tx)
+	switch {
+	case tx.finished:
+		err = errTxMisuse
+	case err != nil:
+		tx.Rollback()
+	default:
+		err = tx.Commit()
+	}
+	tx.finished = true
+	return err
+}
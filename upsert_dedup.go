@@ -0,0 +1,367 @@
+package reindexer
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+// defaultUpsertDedupEntries - default limit of PKs, which are remembered by SkipUnchangedUpserts
+const defaultUpsertDedupEntries = 100000
+
+// upsertHash - 128-bit FNV-1a hash of encoded item
+type upsertHash [16]byte
+
+// upsertDedup - LRU of hashes of items, which were upserted by the client, by their PKs (see NamespaceOptions.SkipUnchangedUpserts).
+// Hash is recorded only by write of item, which was not concurrent with the other writes of the same PK or with writes of unknown items
+type upsertDedup struct {
+	lock    sync.Mutex
+	limit   int
+	lru     *list.List
+	entries map[string]*list.Element
+	// epoch is changed by each write of unknown items (JSON items, queries, truncate), clearing - count of such running writes
+	epoch    uint64
+	clearing int
+	// skipped upserts. Accessed atomically
+	skipped int64
+}
+
+type upsertDedupEntry struct {
+	key        string
+	hash       upsertHash
+	valid      bool
+	inflight   int
+	conflicted bool
+}
+
+// dedupWrite - write, which is registered in upsert deduplication. Zero value is not registered
+type dedupWrite struct {
+	d     *upsertDedup
+	key   string
+	hash  upsertHash
+	epoch uint64
+	// record - hash is recorded after successful write, clear - write of unknown items
+	record bool
+	clear  bool
+}
+
+func newUpsertDedup(limit int) *upsertDedup {
+	return &upsertDedup{limit: limit, lru: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// unchanged returns true and counts skipped upsert, if the last write of key is finished and has the same hash
+func (d *upsertDedup) unchanged(key string, hash upsertHash) bool {
+	if d == nil {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	el, ok := d.entries[key]
+	if !ok || d.clearing != 0 {
+		return false
+	}
+	e := el.Value.(*upsertDedupEntry)
+	if !e.valid || e.inflight != 0 || e.hash != hash {
+		return false
+	}
+	d.lru.MoveToFront(el)
+	atomic.AddInt64(&d.skipped, 1)
+	return true
+}
+
+// begin registers write of item with key. Hash is forgotten until the write is finished
+func (d *upsertDedup) begin(key string, hash upsertHash, record bool) dedupWrite {
+	if d == nil {
+		return dedupWrite{}
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	el, ok := d.entries[key]
+	if ok {
+		d.lru.MoveToFront(el)
+	} else {
+		el = d.lru.PushFront(&upsertDedupEntry{key: key})
+		d.entries[key] = el
+		d.evict()
+	}
+	e := el.Value.(*upsertDedupEntry)
+	e.valid = false
+	if e.inflight++; e.inflight > 1 {
+		e.conflicted = true
+	}
+	return dedupWrite{d: d, key: key, hash: hash, epoch: d.epoch, record: record}
+}
+
+// beginClear registers write, which may change any items: all the hashes are forgotten
+func (d *upsertDedup) beginClear() dedupWrite {
+	if d == nil {
+		return dedupWrite{}
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.clearing++
+	d.flushLocked()
+	return dedupWrite{d: d, clear: true}
+}
+
+// flush forgets all the hashes
+func (d *upsertDedup) flush() {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	d.flushLocked()
+	d.lock.Unlock()
+}
+
+func (d *upsertDedup) flushLocked() {
+	d.epoch++
+	for key, el := range d.entries {
+		if e := el.Value.(*upsertDedupEntry); e.inflight == 0 {
+			d.lru.Remove(el)
+			delete(d.entries, key)
+		} else {
+			e.valid = false
+		}
+	}
+}
+
+// evict removes the least recently used entries above limit. Entries of running writes are kept. Must be called under lock
+func (d *upsertDedup) evict() {
+	for el := d.lru.Back(); el != nil && len(d.entries) > d.limit; {
+		prev := el.Prev()
+		if e := el.Value.(*upsertDedupEntry); e.inflight == 0 {
+			d.lru.Remove(el)
+			delete(d.entries, e.key)
+		}
+		el = prev
+	}
+}
+
+// end finishes write. Hash of upsert is recorded, if it's succeeded and no other writes of the item were made meanwhile
+func (w dedupWrite) end(err error) {
+	d := w.d
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if w.clear {
+		d.clearing--
+		d.flushLocked()
+		return
+	}
+	el, ok := d.entries[w.key]
+	if !ok {
+		return
+	}
+	e := el.Value.(*upsertDedupEntry)
+	e.inflight--
+	if w.record && err == nil && !e.conflicted && d.epoch == w.epoch && d.clearing == 0 {
+		e.hash, e.valid = w.hash, true
+	}
+	if e.inflight == 0 {
+		e.conflicted = false
+	}
+}
+
+// repacked updates hash of upsert, which item is packed again with the refreshed tags state
+func (w *dedupWrite) repacked(ns *reindexerNamespace, item interface{}, encoded []byte, precepts []string) {
+	if w.record {
+		w.hash, w.record = ns.upsertItemHash(item, encoded, precepts)
+	}
+}
+
+func (d *upsertDedup) status() (skipped int64, entries int64) {
+	if d == nil {
+		return 0, 0
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return atomic.LoadInt64(&d.skipped), int64(len(d.entries))
+}
+
+// itemKey returns key of item struct by its PK. Returns false for JSON item or item without PK
+func (ns *reindexerNamespace) itemKey(item interface{}) (string, bool) {
+	if item == nil {
+		return "", false
+	}
+	if _, ok := item.([]byte); ok {
+		return "", false
+	}
+	pk := ns.itemPK(item)
+	if len(pk) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%#v", pk), true
+}
+
+// upsertItemHash returns hash of encoded item struct without fields, which are set by precepts. If there are precepts,
+// item is encoded again with zero values of their fields, and precepts are hashed too. Returns false, if precept field can't be excluded
+func (ns *reindexerNamespace) upsertItemHash(item interface{}, encoded []byte, precepts []string) (hash upsertHash, ok bool) {
+	if len(precepts) != 0 {
+		v := reflect.New(ns.rtype).Elem()
+		v.Set(reflect.Indirect(reflect.ValueOf(item)))
+		for _, precept := range precepts {
+			i := strings.IndexByte(precept, '=')
+			if i <= 0 || !cjson.ZeroFieldByPath(v, strings.TrimSpace(precept[:i])) {
+				return hash, false
+			}
+		}
+		ser := cjson.NewPoolSerializer()
+		defer ser.Close()
+		enc := ns.cjsonState.NewEncoder()
+		enc.SetFloatFormat(ns.floatFormat)
+		if _, err := enc.Encode(v.Addr().Interface(), ser); err != nil {
+			return hash, false
+		}
+		encoded = ser.Bytes()
+	}
+	h := fnv.New128a()
+	h.Write(encoded)
+	for _, precept := range precepts {
+		h.Write([]byte{0})
+		h.Write([]byte(precept))
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash, true
+}
+
+// beginDedupWrite registers write of item in upsert deduplication of namespace. Returns true, if upsert of item is skipped.
+// encoded - item, which is packed to be sent. Writes without encoded item or of other modes are registered without check
+func (ns *reindexerNamespace) beginDedupWrite(item interface{}, encoded []byte, mode int, precepts []string) (w dedupWrite, skip bool) {
+	d := ns.upsertDedup
+	if d == nil {
+		return w, false
+	}
+	key, ok := ns.itemKey(item)
+	if !ok {
+		return d.beginClear(), false
+	}
+	if mode != modeUpsert || encoded == nil {
+		return d.begin(key, upsertHash{}, false), false
+	}
+	hash, ok := ns.upsertItemHash(item, encoded, precepts)
+	if !ok {
+		return d.begin(key, hash, false), false
+	}
+	if d.unchanged(key, hash) {
+		return w, true
+	}
+	return d.begin(key, hash, true), false
+}
+
+// txDedup - writes of transaction, which are registered in upsert deduplication on commit
+type txDedup struct {
+	lock sync.Mutex
+	// hashes of upserted items by their keys, invalid hash - item is changed otherwise
+	pending map[string]upsertDedupEntry
+	// transaction has writes of unknown items
+	clear bool
+}
+
+// skip registers write of item in transaction. Returns true, if upsert of item is skipped:
+// item has the same hash as in the previous upsert of the transaction or, if it was not changed by the transaction, as in namespace.
+// Item, which write failed, must be passed to forget before retry
+func (td *txDedup) skip(ns *reindexerNamespace, item interface{}, encoded []byte, mode int, precepts []string) bool {
+	d := ns.upsertDedup
+	if d == nil {
+		return false
+	}
+	td.lock.Lock()
+	defer td.lock.Unlock()
+	key, ok := ns.itemKey(item)
+	if !ok {
+		td.clearLocked()
+		return false
+	}
+	var hash upsertHash
+	if mode == modeUpsert && encoded != nil {
+		hash, ok = ns.upsertItemHash(item, encoded, precepts)
+	} else {
+		ok = false
+	}
+	prev, changed := td.pending[key]
+	if ok {
+		if changed && prev.valid && prev.hash == hash {
+			atomic.AddInt64(&d.skipped, 1)
+			return true
+		}
+		if !changed && !td.clear && d.unchanged(key, hash) {
+			return true
+		}
+	}
+	if td.pending == nil {
+		td.pending = make(map[string]upsertDedupEntry)
+	}
+	td.pending[key] = upsertDedupEntry{key: key, hash: hash, valid: ok}
+	return false
+}
+
+// forget marks item, which write to transaction failed, as changed
+func (td *txDedup) forget(ns *reindexerNamespace, item interface{}) {
+	if ns.upsertDedup == nil {
+		return
+	}
+	if key, ok := ns.itemKey(item); ok {
+		td.lock.Lock()
+		if _, changed := td.pending[key]; changed {
+			td.pending[key] = upsertDedupEntry{key: key}
+		}
+		td.lock.Unlock()
+	}
+}
+
+// clearLocked registers write of unknown items: the previous upserts of transaction are forgotten. Must be called under lock
+func (td *txDedup) clearLocked() {
+	td.clear, td.pending = true, nil
+}
+
+func (td *txDedup) clearAll() {
+	td.lock.Lock()
+	td.clearLocked()
+	td.lock.Unlock()
+}
+
+// beginCommit registers writes of transaction in upsert deduplication of namespace
+func (td *txDedup) beginCommit(ns *reindexerNamespace) []dedupWrite {
+	d := ns.upsertDedup
+	if d == nil {
+		return nil
+	}
+	td.lock.Lock()
+	defer td.lock.Unlock()
+	writes := make([]dedupWrite, 0, len(td.pending)+1)
+	if td.clear {
+		writes = append(writes, d.beginClear())
+	}
+	for key, e := range td.pending {
+		writes = append(writes, d.begin(key, e.hash, e.valid))
+	}
+	td.clear, td.pending = false, nil
+	return writes
+}
+
+func endDedupWrites(writes []dedupWrite, err error) {
+	for _, w := range writes {
+		w.end(err)
+	}
+}
+
+func (db *reindexerImpl) upsertDedupStatus() (status bindings.StatusUpsertDedup) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	for _, ns := range db.ns {
+		skipped, entries := ns.upsertDedup.status()
+		status.Skipped += skipped
+		status.Entries += entries
+	}
+	return status
+}
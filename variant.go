@@ -0,0 +1,151 @@
+package reindexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Variant is a value, which keeps its original kind: reflect.Int64, reflect.Float64 or reflect.String
+type Variant struct {
+	kind reflect.Kind
+	i    int64
+	f    float64
+	s    string
+}
+
+// Kind returns kind of the value
+func (v Variant) Kind() reflect.Kind {
+	return v.kind
+}
+
+// AsInt64 returns value as int64. Floats are truncated, strings are parsed
+func (v Variant) AsInt64() int64 {
+	switch v.kind {
+	case reflect.Int64:
+		return v.i
+	case reflect.Float64:
+		return int64(v.f)
+	case reflect.String:
+		if i, err := strconv.ParseInt(v.s, 10, 64); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(v.s, 64)
+		return int64(f)
+	}
+	return 0
+}
+
+// AsFloat64 returns value as float64. Strings are parsed
+func (v Variant) AsFloat64() float64 {
+	switch v.kind {
+	case reflect.Int64:
+		return float64(v.i)
+	case reflect.Float64:
+		return v.f
+	case reflect.String:
+		f, _ := strconv.ParseFloat(v.s, 64)
+		return f
+	}
+	return 0
+}
+
+// AsString returns value as string
+func (v Variant) AsString() string {
+	switch v.kind {
+	case reflect.Int64:
+		return strconv.FormatInt(v.i, 10)
+	case reflect.Float64:
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	case reflect.String:
+		return v.s
+	}
+	return ""
+}
+
+// Interface returns value as int64, float64 or string
+func (v Variant) Interface() interface{} {
+	switch v.kind {
+	case reflect.Int64:
+		return v.i
+	case reflect.Float64:
+		return v.f
+	case reflect.String:
+		return v.s
+	}
+	return nil
+}
+
+type variantJSON struct {
+	Kind  string          `json:"kind"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// UnmarshalJSON decodes value, which is encoded by server as {"kind":"int64|double|string|null","value":...}
+func (v *Variant) UnmarshalJSON(b []byte) (err error) {
+	var vj variantJSON
+	if err = json.Unmarshal(b, &vj); err != nil {
+		return err
+	}
+	switch vj.Kind {
+	case "int64":
+		v.kind = reflect.Int64
+		v.i, err = strconv.ParseInt(string(vj.Value), 10, 64)
+	case "double":
+		v.kind = reflect.Float64
+		v.f, err = strconv.ParseFloat(string(vj.Value), 64)
+	case "string":
+		v.kind = reflect.String
+		err = json.Unmarshal(vj.Value, &v.s)
+	case "null":
+		*v = Variant{}
+	default:
+		err = fmt.Errorf("rq: unknown variant kind '%s'", vj.Kind)
+	}
+	return err
+}
+
+// MarshalJSON encodes value in the same format, as it's sent by server
+func (v Variant) MarshalJSON() ([]byte, error) {
+	vj := variantJSON{}
+	switch v.kind {
+	case reflect.Int64:
+		vj.Kind, vj.Value = "int64", json.RawMessage(strconv.FormatInt(v.i, 10))
+	case reflect.Float64:
+		if math.IsInf(v.f, 0) || math.IsNaN(v.f) {
+			return nil, fmt.Errorf("rq: unsupported variant value %v", v.f)
+		}
+		vj.Kind, vj.Value = "double", json.RawMessage(strconv.FormatFloat(v.f, 'g', -1, 64))
+	case reflect.String:
+		s, _ := json.Marshal(v.s)
+		vj.Kind, vj.Value = "string", s
+	default:
+		vj.Kind = "null"
+	}
+	return json.Marshal(vj)
+}
+
+// AggMinValue returns exact value of MIN aggregation.
+// false is returned, if it's not a MIN aggregation or there were no values to aggregate
+func (a *AggregationResult) AggMinValue() (Variant, bool) {
+	return a.typedValue("min")
+}
+
+// AggMaxValue returns exact value of MAX aggregation.
+// false is returned, if it's not a MAX aggregation or there were no values to aggregate
+func (a *AggregationResult) AggMaxValue() (Variant, bool) {
+	return a.typedValue("max")
+}
+
+func (a *AggregationResult) typedValue(aggType string) (Variant, bool) {
+	if a.Type != aggType {
+		return Variant{}, false
+	}
+	if a.TypedValue != nil {
+		return *a.TypedValue, a.TypedValue.kind != reflect.Invalid
+	}
+	// Older servers send MIN/MAX only as double
+	return Variant{kind: reflect.Float64, f: a.Value}, true
+}
@@ -0,0 +1,117 @@
+package reindexer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// ErrServerUnsupported is returned, when client feature is not supported by version of connected server
+type ErrServerUnsupported struct {
+	// Feature name
+	Feature string
+	// Minimal server version, which supports feature. Empty for features, which are advertised by server capabilities
+	Required string
+	// Actual server version
+	Actual string
+}
+
+func (e ErrServerUnsupported) Error() string {
+	if len(e.Required) == 0 {
+		return fmt.Sprintf("rq: %s is not supported by server %s", e.Feature, e.Actual)
+	}
+	return fmt.Sprintf("rq: %s is not supported by server %s, required version is %s or newer", e.Feature, e.Actual, e.Required)
+}
+
+func (e ErrServerUnsupported) Code() int {
+	return ErrCodeParams
+}
+
+// serverFeature is gated either by minimal server version or by capability bit, which server advertises
+type serverFeature struct {
+	name       string
+	minVersion string
+	capability int64
+}
+
+var (
+	featureQueriesInTx     = serverFeature{"Delete and update queries in transaction", "v2.2.4", 0}
+	featureRenameNamespace = serverFeature{"Rename namespace", "v2.5.3", 0}
+	featureUpdateObject    = serverFeature{"Update query with object value", "v2.6.2", 0}
+	featureSQLSuggestions  = serverFeature{"SQL suggestions", "v2.2.4", 0}
+	featureDryRun          = serverFeature{"Dry run of update and delete queries", "", bindings.ServerCapDryRun}
+	featureStateToken      = serverFeature{"Namespace state tokens", "", bindings.ServerCapStateToken}
+)
+
+// CompareVersions compares reindexer versions like 'v2.9.1' or '2.9.1-12-g1a2b3c4'. Only major, minor and patch numbers are compared
+// Returns -1, if v1 < v2, 0, if v1 == v2 and 1, if v1 > v2
+func CompareVersions(v1, v2 string) (int, error) {
+	p1, err := parseVersion(v1)
+	if err != nil {
+		return 0, err
+	}
+	p2, err := parseVersion(v2)
+	if err != nil {
+		return 0, err
+	}
+	for i := range p1 {
+		if p1[i] < p2[i] {
+			return -1, nil
+		} else if p1[i] > p2[i] {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func parseVersion(v string) (parsed [3]int, err error) {
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(s, "-+ "); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(s) == 0 || len(parts) > len(parsed) {
+		return parsed, bindings.NewError(fmt.Sprintf("rq: Invalid version '%s'", v), ErrCodeParams)
+	}
+	for i, part := range parts {
+		if parsed[i], err = strconv.Atoi(part); err != nil || parsed[i] < 0 {
+			return parsed, bindings.NewError(fmt.Sprintf("rq: Invalid version '%s'", v), ErrCodeParams)
+		}
+	}
+	return parsed, nil
+}
+
+func (db *reindexerImpl) serverVersion() string {
+	if binding, ok := db.getBinding().(bindings.RawBindingVersion); ok {
+		return binding.ServerVersion()
+	}
+	return ""
+}
+
+func (db *reindexerImpl) serverCapabilities() int64 {
+	if binding, ok := db.getBinding().(bindings.RawBindingCapabilities); ok {
+		return binding.ServerCapabilities()
+	}
+	return 0
+}
+
+// checkFeature fails fast, if feature is not supported by server. Version gated features are not checked, if server version is unknown.
+// Capability gated features are supported only if server has advertised them
+func (db *reindexerImpl) checkFeature(feature serverFeature) error {
+	version := db.serverVersion()
+	if feature.capability != 0 {
+		if db.serverCapabilities()&feature.capability == 0 {
+			return ErrServerUnsupported{Feature: feature.name, Actual: version}
+		}
+		return nil
+	}
+	if len(version) == 0 {
+		return nil
+	}
+	if cmp, err := CompareVersions(version, feature.minVersion); err != nil || cmp >= 0 {
+		return nil
+	}
+	return ErrServerUnsupported{Feature: feature.name, Required: feature.minVersion, Actual: version}
+}
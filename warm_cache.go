@@ -0,0 +1,179 @@
+package reindexer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/restream/reindexer/bindings"
+)
+
+// WarmOptions - options of WarmCache
+type WarmOptions struct {
+	// MaxItems - max count of items of results, which are processed (0 - all the items)
+	MaxItems int
+	// Parallelism - count of goroutines, which decode items (default 1)
+	Parallelism int
+}
+
+// WarmReport - result of WarmCache
+type WarmReport struct {
+	// Loaded - items, which were decoded and stored to object cache
+	Loaded int
+	// Skipped - items, which were already stored to object cache with the same or newer version
+	Skipped int
+	// Truncated - warm-up was stopped by MaxItems or by context before the end of results
+	Truncated bool
+}
+
+// WarmCache executes select query q of namespace ns (or selects all the items of ns, if q is nil) and stores decoded items of results
+// to object cache without returning them, so the next queries take them from cache (e.g. hot items after deploy).
+// Warm-up is bounded by ctx and by MaxItems. Items, which are stored until ctx is done, stay in cache, the report is returned with error of ctx.
+// Items are stored by their versions like items of the other queries, so warm-up is safe to run while traffic flows. Object cache isn't limited by size,
+// so MaxItems bounds its growth. Namespaces with disabled object cache and queries with joins or merges are rejected with ErrCodeParams
+func (db *Reindexer) WarmCache(ctx context.Context, ns string, q *Query, opts WarmOptions) (WarmReport, error) {
+	return db.impl.warmCache(ctx, ns, q, opts)
+}
+
+func (db *reindexerImpl) warmCache(ctx context.Context, namespace string, q *Query, opts WarmOptions) (report WarmReport, err error) {
+	namespace = strings.ToLower(namespace)
+	if q == nil {
+		q = db.query(namespace)
+	} else if q.root != nil {
+		q = q.root
+	}
+	if !strings.EqualFold(q.Namespace, namespace) || len(q.joinQueries) != 0 || len(q.mergedQueries) != 0 {
+		return report, bindings.NewError(fmt.Sprintf("rq: Query of WarmCache must select items of namespace '%s' without joins and merges", namespace), ErrCodeParams)
+	}
+	ns, err := db.getNS(namespace)
+	if err != nil {
+		return report, err
+	}
+	if ns.opts.disableObjCache || db.disableObjCache || q.noObjCache {
+		return report, bindings.NewError(fmt.Sprintf("rq: Object cache of namespace '%s' is disabled", namespace), ErrCodeParams)
+	}
+	parallelism := opts.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	it := q.ExecCtx(ctx)
+	defer it.Close()
+	if err = it.Error(); err != nil {
+		return report, err
+	}
+	if it.rawQueryParams.flags&bindings.ResultsWithItemID == 0 {
+		return report, bindings.NewError(fmt.Sprintf("rq: Results of namespace '%s' don't contain IDs of items, so they can't be cached", namespace), ErrCodeParams)
+	}
+	for it.ptr < it.rawQueryParams.qcount {
+		processed := report.Loaded + report.Skipped
+		if opts.MaxItems > 0 && processed >= opts.MaxItems {
+			report.Truncated = true
+			break
+		}
+		if err = ctx.Err(); err != nil {
+			report.Truncated = true
+			return report, err
+		}
+		if it.needMore() {
+			if it.fetchResults(); it.err != nil {
+				return report, it.err
+			}
+		}
+		// Items of the current chunk of results are decoded concurrently: their data is valid until the next fetch
+		n := it.rawQueryParams.count - it.resPtr
+		if rest := it.rawQueryParams.qcount - it.ptr; n > rest {
+			n = rest
+		}
+		if opts.MaxItems > 0 && n > opts.MaxItems-processed {
+			n = opts.MaxItems - processed
+		}
+		items := make([]rawResultItemParams, n)
+		for i := range items {
+			items[i] = it.ser.readRawtItemParams()
+			if it.rawQueryParams.flags&bindings.ResultsWithJoined != 0 && it.ser.GetVarUInt() != 0 {
+				return report, bindings.NewError("rq: Results of WarmCache query contain joined items", ErrCodeParams)
+			}
+		}
+		it.resPtr += n
+		it.ptr += n
+		loaded, skipped, err := warmItems(ctx, it.nsArray, items, parallelism)
+		report.Loaded += loaded
+		report.Skipped += skipped
+		if err != nil {
+			report.Truncated = ctx.Err() != nil
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// warmItems decodes items by parallelism goroutines and stores them to object cache. Items are not decoded after ctx is done
+func warmItems(ctx context.Context, nsArray []nsArrayEntry, items []rawResultItemParams, parallelism int) (loaded, skipped int, err error) {
+	if parallelism > len(items) {
+		parallelism = len(items)
+	}
+	var next, loadedCount, skippedCount int64
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1)) - 1
+				if i >= len(items) || ctx.Err() != nil {
+					return
+				}
+				stored, decodeErr := warmItem(&nsArray[items[i].nsid], &items[i])
+				if decodeErr != nil {
+					errOnce.Do(func() { err = decodeErr })
+					return
+				}
+				if stored {
+					atomic.AddInt64(&loadedCount, 1)
+				} else {
+					atomic.AddInt64(&skippedCount, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	loaded, skipped = int(loadedCount), int(skippedCount)
+	if err == nil && loaded+skipped < len(items) {
+		err = ctx.Err()
+	}
+	return loaded, skipped, err
+}
+
+// warmItem decodes item of results and stores it to object cache. Returns false, if the same or newer version of item is cached already
+func warmItem(ns *nsArrayEntry, params *rawResultItemParams) (bool, error) {
+	ns.cacheLock.RLock()
+	citem, ok := ns.cacheItems[params.id]
+	ns.cacheLock.RUnlock()
+	if ok && citem.version >= params.version {
+		return false, nil
+	}
+	item := reflect.New(ns.rtype).Interface()
+	if err := decodeItem(ns, params, item, nil, nil); err != nil {
+		return false, err
+	}
+	_, stored := ns.storeCachedItem(params, item)
+	return stored, nil
+}
+
+func (db *reindexerImpl) objCacheStatus() (status bindings.StatusObjCache) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	for _, ns := range db.ns {
+		status.Hits += atomic.LoadInt64(&ns.objCacheHits)
+		status.Misses += atomic.LoadInt64(&ns.objCacheMisses)
+		ns.cacheLock.RLock()
+		status.Entries += int64(len(ns.cacheItems))
+		ns.cacheLock.RUnlock()
+	}
+	return status
+}
@@ -0,0 +1,145 @@
+package reindexer
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/restream/reindexer/bindings"
+	"github.com/restream/reindexer/cjson"
+)
+
+const defaultWriteJournalQueueSize = 1000
+
+// writeJournal passes entries to the journal from bounded queue by background goroutine
+type writeJournal struct {
+	// counters are first to be aligned for atomic operations
+	delivered     int64
+	dropped       int64
+	journal       func(entry bindings.WriteJournalEntry)
+	includeSystem bool
+	queue         chan bindings.WriteJournalEntry
+	done          chan struct{}
+	// closed is guarded by lock to prevent sending to the closed queue
+	lock   sync.RWMutex
+	closed bool
+}
+
+func newWriteJournal(opt bindings.OptionWriteJournal) *writeJournal {
+	if opt.Journal == nil {
+		return nil
+	}
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = defaultWriteJournalQueueSize
+	}
+	wj := &writeJournal{
+		journal:       opt.Journal,
+		includeSystem: opt.IncludeSystem,
+		queue:         make(chan bindings.WriteJournalEntry, opt.QueueSize),
+		done:          make(chan struct{}),
+	}
+	go wj.run()
+	return wj
+}
+
+func (wj *writeJournal) run() {
+	defer close(wj.done)
+	for entry := range wj.queue {
+		wj.journal(entry)
+		atomic.AddInt64(&wj.delivered, 1)
+	}
+}
+
+// begin returns entry to fill, if write to namespace is journaled, or nil
+func (wj *writeJournal) begin(namespace string, op string, precepts []string) *bindings.WriteJournalEntry {
+	if wj == nil || (!wj.includeSystem && IsSystemNamespace(namespace)) {
+		return nil
+	}
+	entry := &bindings.WriteJournalEntry{Namespace: namespace, Op: op}
+	if len(precepts) > 0 {
+		entry.Precepts = append([]string(nil), precepts...)
+	}
+	return entry
+}
+
+// record queues entry without waiting. Duration is measured from start
+func (wj *writeJournal) record(entry *bindings.WriteJournalEntry, start time.Time) {
+	if entry == nil {
+		return
+	}
+	entry.Duration = time.Since(start)
+	wj.lock.RLock()
+	defer wj.lock.RUnlock()
+	if wj.closed {
+		atomic.AddInt64(&wj.dropped, 1)
+		return
+	}
+	select {
+	case wj.queue <- *entry:
+	default:
+		atomic.AddInt64(&wj.dropped, 1)
+	}
+}
+
+// close stops accepting entries and waits, until queued ones are passed to the journal, or ctx is done
+func (wj *writeJournal) close(ctx context.Context) {
+	if wj == nil {
+		return
+	}
+	wj.lock.Lock()
+	if !wj.closed {
+		wj.closed = true
+		close(wj.queue)
+	}
+	wj.lock.Unlock()
+	select {
+	case <-wj.done:
+	case <-ctx.Done():
+	}
+}
+
+func (wj *writeJournal) status() bindings.StatusWriteJournal {
+	if wj == nil {
+		return bindings.StatusWriteJournal{}
+	}
+	return bindings.StatusWriteJournal{
+		Delivered: atomic.LoadInt64(&wj.delivered),
+		Dropped:   atomic.LoadInt64(&wj.dropped),
+	}
+}
+
+func writeOpName(mode int) string {
+	switch mode {
+	case modeInsert:
+		return bindings.WriteOpInsert
+	case modeUpdate:
+		return bindings.WriteOpUpdate
+	case modeDelete:
+		return bindings.WriteOpDelete
+	}
+	return bindings.WriteOpUpsert
+}
+
+// itemPK returns values of primary key fields of item struct, or nil, if some of them can't be derived
+func (ns *reindexerNamespace) itemPK(item interface{}) []interface{} {
+	if item == nil {
+		return nil
+	}
+	v := reflect.ValueOf(item)
+	var pk []interface{}
+	for _, index := range ns.indexes {
+		if !index.IsPK {
+			continue
+		}
+		for _, path := range index.JSONPaths {
+			fv, ok := cjson.FieldValueByPath(v, path)
+			if !ok || !fv.CanInterface() {
+				return nil
+			}
+			pk = append(pk, fv.Interface())
+		}
+	}
+	return pk
+}